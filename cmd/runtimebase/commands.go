@@ -0,0 +1,537 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// What the positional arguments of a command complete to.
+const (
+	completeBaselines = "baselines"
+	completeFiles     = "files"
+	completeCommands  = "commands"
+	completeShells    = "shells"
+)
+
+// command is a runtimebase subcommand.
+type command struct {
+	name    string
+	args    string // positional arguments, as shown in usage
+	summary string
+	// help is shown after the summary in the command's --help.
+	help string
+	// setup defines the command's flags on fs and returns the function
+	// running it with its positional arguments.
+	setup func(fs *flag.FlagSet) func(args []string)
+	// complete is what the positional arguments complete to, if anything.
+	complete string
+	// hidden commands are left out of usage and completion.
+	hidden bool
+}
+
+const gateHelp = `Exits with 0 when clean, 1 for findings below HIGH, 2 for HIGH or above and
+3 on error; --fail-on changes the severity exiting with 2.`
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "learn", args: "<name>", summary: "Create and learn new behavior baseline",
+			help: `With --from, learns the new lines of an observations file since the last run.
+With --relearn as well, learns them afresh into <name>.candidate instead:
+observations anomalous against <name> are held pending until approved or
+rejected with baselines approve|reject, and shadow --promote replaces <name>
+with the candidate once none are pending.`,
+			setup: learnBaseline, complete: completeBaselines},
+		{name: "detect", args: "<name>", summary: "Detect anomalies against baseline",
+			help: gateHelp, setup: detectAnomalies, complete: completeBaselines},
+		{name: "analyze", args: "<file>...", summary: "Discover message templates in a log file",
+			help: `Reads .gz and .zst files; <file> may be - or a pipe, or a glob such as
+'/var/log/myapp/*.json'. Events of several files are merged into timestamp
+order before they are analyzed.
+
+` + gateHelp, setup: analyzeLog, complete: completeFiles},
+		{name: "check", args: "<name>", summary: "Check current behavior against baseline",
+			help: gateHelp, setup: checkBehavior, complete: completeBaselines},
+		{name: "timeline", args: "<name>", summary: "Show anomalies and events in time order",
+			setup: showTimeline, complete: completeBaselines},
+		{name: "score", args: "<name>", summary: "Show the behavior score trend recorded by check",
+			setup: showScores, complete: completeBaselines},
+		{name: "trend", args: "<name> [key-prefix]", summary: "Show the per-interval history of a baseline's statistics",
+			help: `History is kept per learning interval for a day, hourly for 30 days and
+daily beyond; see retention.default.raw_stats and hourly_stats.`,
+			setup: showTrend, complete: completeBaselines},
+		{name: "report", args: "<name>", summary: "Write findings as JSON or HTML, or an incident bundle",
+			help: `--format stix writes a STIX 2.1 bundle and --format misp a MISP event, with
+an indicator or attribute per IP address, domain or file hash found in the
+evidence of the findings, for threat intelligence platforms.`,
+			setup: writeReport, complete: completeBaselines},
+		{name: "export", args: "[selinux|notebook] [name]...", summary: "Export the learned statistics or behavior graphs of baselines",
+			help: `Exports every baseline when none is named. --format rego writes an OPA
+bundle of the baselines' behaviors with a policy allowing only those.
+"export selinux <name>", or
+--format selinux, writes audit2allow-style SELinux allow rules for the files,
+executables and ports the baseline learned, as hints for a policy module.
+"export notebook <name>" writes the baseline's statistics and anomaly
+history as Parquet (or, with --dataset json, JSON) datasets into the
+--output directory, <name>-notebook by default, with a starter Jupyter
+notebook exploring them with pandas.`,
+			setup: exportStats, complete: completeBaselines},
+		{name: "import", args: "<file>", summary: "Preview, and apply, per-key thresholds from a reviewed CSV",
+			help: `An optional direction column (both, up or down) limits keys to alerting
+on increases or decreases only.`,
+			setup: importThresholds, complete: completeFiles},
+		{name: "bootstrap", args: "<bin>", summary: "Pre-seed a baseline from static analysis of an ELF binary",
+			setup: bootstrapBaseline, complete: completeFiles},
+		{name: "enforce", args: "<name>", summary: "Kill processes performing operations never seen in the baseline (BPF LSM)",
+			help: `BPF LSM hooks report operations without blocking them, so a process is
+killed after its operation has been allowed.`,
+			setup: runEnforce, complete: completeBaselines},
+		{name: "oci-hook", summary: "OCI runtime hook starting a monitoring session per container",
+			setup: runOCIHook},
+		{name: "install-service", summary: "Install the daemon as a systemd unit (or Windows service)",
+			setup: installService},
+		{name: "uninstall-service", summary: "Stop and remove the service",
+			setup: uninstallService},
+		{name: "audit", args: "[name]", summary: "Show the audit log of baseline and config changes",
+			setup: showAudit, complete: completeBaselines},
+		{name: "baselines", args: "list | show <name> | approve|reject <name> [key...]", summary: "List baselines with their quality, or show one's in detail",
+			help: `The quality score (0-100) weighs how many keys have enough samples, which
+runtime categories are covered, how recently the baseline was updated and how
+stable its variances are; enforce warns about baselines scoring below 80.
+
+approve merges the observations a relearned baseline holds pending, those of
+the given statistics keys or all of them, and reject discards them.`,
+			setup: showBaselines},
+		{name: "override", args: "add|remove <name> <key> | list [name]", summary: "Expect behavior on a baseline for a while, such as a migration",
+			help: `An override is a time-boxed exception: anomalies about the key, a statistics
+key such as network:203.0.113.7 (every port) or process:/usr/bin/rsync, whose
+pattern may be a glob, are not raised for the baseline until it expires:
+
+  runtimebase override add myapp network:203.0.113.7 --ttl 48h --reason "migration"
+
+Adding and removing overrides is recorded in the audit log, as is their
+expiry when the daemon's janitor or override list removes them.`,
+			setup: runOverride, complete: completeBaselines},
+		{name: "categories", summary: "List the categories of behavior rules and suppressions select",
+			help: `Categories nest with dots: a pattern such as "network" also selects
+network.dns, "file.*" the kinds of file access and "*.write" writes of any
+kind. Add categories under "categories:" in the configuration.`,
+			setup: listCategories},
+		{name: "shadow", args: "<name>", summary: "Compare the candidate learned in shadow mode with the baseline",
+			setup: showShadow, complete: completeBaselines},
+		{name: "canary", summary: "Learn a canary live and gate on its divergence from the stable baseline",
+			help: `Reads the canary's events from --events for --duration, or until they end,
+learns them into the --candidate baseline and compares it with --stable.
+Exits with 0 when the divergence is below --threshold, 2 when it is not and
+3 on error, so progressive delivery pipelines can promote or roll back.`,
+			setup: runCanary, complete: completeBaselines},
+		{name: "rules", args: "test|stats", summary: "Test a proposed rule set, or show how each detection rule performs",
+			help: `Replays the events archived at --against, a file, a directory or a glob of
+JSON lines files that may be compressed, through the --rules rule set and
+the current one, and reports how many alerts each would have raised and
+which alerts change. A rule set selects the correlation packs to run, the
+suppressions to apply and the min_severity to alert at, under the same keys
+as the configuration file:
+
+  runtimebase rules test --rules new.yaml --against /var/log/events/ --since 7d
+
+stats reads the daemon's metrics endpoint and lists, per rule and per
+category, the anomalies raised, the share suppressed, their average
+confidence and, for correlation rules, the average time to evaluate an
+event, so noisy or expensive rules stand out.`,
+			setup: runRules},
+		{name: "simulate", summary: "Generate a synthetic event stream with injected attacks",
+			setup: simulateEvents},
+		{name: "daemon", summary: "Run background services (retention janitor, metrics)",
+			setup: runDaemon},
+		{name: "alert-test", summary: "Send a synthetic anomaly through the daemon's pipelines",
+			setup: alertTest},
+		{name: "completion", args: "bash|zsh|fish", summary: "Print a shell completion script",
+			help: `Load it in the current shell with, for example:
+
+  source <(runtimebase completion bash)
+  runtimebase completion zsh > "${fpath[1]}/_runtimebase"
+  runtimebase completion fish > ~/.config/fish/completions/runtimebase.fish`,
+			setup: printCompletion, complete: completeShells},
+		{name: "version", summary: "Show version information",
+			setup: func(*flag.FlagSet) func([]string) {
+				return func([]string) { fmt.Printf("runtimebase version %s\n", version) }
+			}},
+		{name: "help", args: "[command]", summary: "Show this help message, or a command's",
+			setup: showHelp, complete: completeCommands},
+		{name: "__baselines", hidden: true, setup: listBaselineNames},
+	}
+}
+
+// lookupCommand returns the command called name, or nil.
+func lookupCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// flagSet returns the command's flags and the function running it.
+func (c *command) flagSet() (*flag.FlagSet, func([]string)) {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.Usage = func() { c.usage(fs.Output(), fs) }
+	fs.BoolVar(&noColor, "no-color", noColor, "do not color output")
+	return fs, c.setup(fs)
+}
+
+// run parses args and runs the command.
+func (c *command) run(args []string) {
+	fs, run := c.flagSet()
+	run(parseArgs(fs, args))
+}
+
+func (c *command) usage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage:\n  runtimebase %s", c.name)
+	if c.args != "" {
+		fmt.Fprintf(w, " %s", c.args)
+	}
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprint(w, " [options]")
+	}
+	fmt.Fprintf(w, "\n\n%s.\n", c.summary)
+	if c.help != "" {
+		fmt.Fprintf(w, "\n%s\n", c.help)
+	}
+	if hasFlags {
+		fmt.Fprintln(w, "\nOptions:")
+		printFlags(w, fs)
+	}
+}
+
+// printFlags lists the flags of fs as --name value, as they are written
+// elsewhere in the help and documentation.
+func printFlags(w io.Writer, fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		value, usage := flag.UnquoteUsage(f)
+		fmt.Fprintf(w, "  --%s", f.Name)
+		if value != "" {
+			fmt.Fprintf(w, " %s", value)
+		}
+		fmt.Fprintf(w, "\n        %s", strings.ReplaceAll(usage, "\n", "\n        "))
+		switch f.DefValue {
+		case "", "false", "0", "0s", "[]":
+		default:
+			fmt.Fprintf(w, " (default %s)", f.DefValue)
+		}
+		fmt.Fprintln(w)
+	})
+}
+
+func printUsage() {
+	fmt.Printf(`runtimebase - Runtime Behavior Baseline
+
+Usage:
+  runtimebase [--namespace team-a] [--read-only] [--no-color] <command> [options]
+
+Commands:
+`)
+	width := 0
+	for _, c := range commands {
+		if !c.hidden {
+			width = max(width, len(strings.TrimSpace(c.name+" "+c.args)))
+		}
+	}
+	for _, c := range commands {
+		if !c.hidden {
+			fmt.Printf("  %-*s  %s\n", width, strings.TrimSpace(c.name+" "+c.args), c.summary)
+		}
+	}
+	fmt.Print(`
+Run 'runtimebase <command> --help' for the options of a command.
+
+Examples:
+  runtimebase learn myapp
+  runtimebase detect myapp --events events.jsonl
+  runtimebase analyze /var/log/myapp.log
+  runtimebase analyze '/var/log/audit/audit.log*' /var/log/myapp/*.log
+  strace -f -tt myapp 2>&1 | runtimebase analyze - --format strace
+  runtimebase timeline myapp --window 1h
+  runtimebase simulate --duration 1h --learn demo
+  runtimebase simulate --inject reverse-shell | runtimebase check demo --events -
+
+detect, check and analyze accept [--quiet] [--fail-on SEVERITY] and exit with
+0 when clean, 1 for findings below HIGH, 2 for HIGH or above, 3 on error.
+Every command exits with 3 on errors and on unknown commands, flags or missing
+arguments.
+
+Baselines and history are stored in $RUNTIMEBASE_HOME (default ~/.runtimebase),
+in the namespace given by --namespace or $RUNTIMEBASE_NAMESPACE, if any.
+--read-only or $RUNTIMEBASE_READ_ONLY opens them read-only, refusing changes.
+Output is colored on terminals unless --no-color is given or NO_COLOR is set.
+`)
+}
+
+func showHelp(fs *flag.FlagSet) func(args []string) {
+	return func(positional []string) {
+		if len(positional) == 0 {
+			printUsage()
+			return
+		}
+		c := lookupCommand(positional[0])
+		if c == nil || c.hidden {
+			fmt.Fprintf(os.Stderr, "Unknown command: %s\n", positional[0])
+			os.Exit(exitError)
+		}
+		cfs, _ := c.flagSet()
+		c.usage(os.Stdout, cfs)
+	}
+}
+
+func listBaselineNames(fs *flag.FlagSet) func(args []string) {
+	return func([]string) {
+		store, err := openStore()
+		if err != nil {
+			return
+		}
+		names, err := store.ListBaselines()
+		if err != nil {
+			return
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	}
+}
+
+func printCompletion(fs *flag.FlagSet) func(args []string) {
+	return func(positional []string) {
+		if len(positional) != 1 {
+			fs.Usage()
+			os.Exit(exitError)
+		}
+		var err error
+		switch positional[0] {
+		case "bash":
+			err = writeBashCompletion(os.Stdout)
+		case "zsh":
+			err = writeZshCompletion(os.Stdout)
+		case "fish":
+			err = writeFishCompletion(os.Stdout)
+		default:
+			err = fmt.Errorf("unsupported shell %q: use bash, zsh or fish", positional[0])
+		}
+		if err != nil {
+			fail(err)
+		}
+	}
+}
+
+// completionFlag is a flag as completion scripts need it.
+type completionFlag struct {
+	name   string
+	usage  string
+	valued bool // takes a value
+}
+
+// commandFlags returns the flags of a command, sorted by name.
+func commandFlags(c *command) []completionFlag {
+	fs, _ := c.flagSet()
+	var flags []completionFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		_, usage := flag.UnquoteUsage(f)
+		flags = append(flags, completionFlag{name: f.Name, usage: usage, valued: !ok || !b.IsBoolFlag()})
+	})
+	sort.Slice(flags, func(i, j int) bool { return flags[i].name < flags[j].name })
+	return flags
+}
+
+// visibleCommands returns the commands completion offers.
+func visibleCommands() []*command {
+	var visible []*command
+	for _, c := range commands {
+		if !c.hidden {
+			visible = append(visible, c)
+		}
+	}
+	return visible
+}
+
+func writeBashCompletion(w io.Writer) error {
+	var names, cases []string
+	for _, c := range visibleCommands() {
+		names = append(names, c.name)
+		flags, valued := []string{"--help"}, []string{}
+		for _, f := range commandFlags(c) {
+			flags = append(flags, "--"+f.name)
+			if f.valued {
+				valued = append(valued, "--"+f.name)
+			}
+		}
+		cases = append(cases, fmt.Sprintf("        %s) flags=%q; valued=%q; args=%s ;;",
+			c.name, strings.Join(flags, " "), strings.Join(valued, " "), c.complete))
+	}
+	_, err := fmt.Fprintf(w, `# bash completion for runtimebase
+_runtimebase() {
+    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+    local cmd="" ns="" i
+    for ((i = 1; i < COMP_CWORD; i++)); do
+        case "${COMP_WORDS[i]}" in
+            --namespace|-namespace) ns="${COMP_WORDS[i+1]}"; ((i++)) ;;
+            -*) ;;
+            *) cmd="${COMP_WORDS[i]}"; break ;;
+        esac
+    done
+    if [[ -z $cmd ]]; then
+        case "$prev" in --namespace|-namespace) return ;; esac
+        if [[ $cur == -* ]]; then
+            COMPREPLY=($(compgen -W "--namespace --help" -- "$cur"))
+        else
+            COMPREPLY=($(compgen -W "%s" -- "$cur"))
+        fi
+        return
+    fi
+    local flags="" valued="" args=""
+    case "$cmd" in
+%s
+    esac
+    if [[ " $valued " == *" $prev "* ]]; then
+        COMPREPLY=($(compgen -f -- "$cur"))
+        return
+    fi
+    if [[ $cur == -* ]]; then
+        COMPREPLY=($(compgen -W "$flags" -- "$cur"))
+        return
+    fi
+    case "$args" in
+        baselines) COMPREPLY=($(compgen -W "$(runtimebase ${ns:+--namespace "$ns"} __baselines 2>/dev/null)" -- "$cur")) ;;
+        files) COMPREPLY=($(compgen -f -- "$cur")) ;;
+        commands) COMPREPLY=($(compgen -W "%[1]s" -- "$cur")) ;;
+        shells) COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur")) ;;
+    esac
+}
+complete -o filenames -F _runtimebase runtimebase
+`, strings.Join(names, " "), strings.Join(cases, "\n"))
+	return err
+}
+
+// zshQuote quotes a description for an _arguments spec or _describe item
+// inside single quotes.
+func zshQuote(s string) string {
+	return strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`, "\n", " ").Replace(s)
+}
+
+func writeZshCompletion(w io.Writer) error {
+	var items, cases []string
+	for _, c := range visibleCommands() {
+		items = append(items, fmt.Sprintf("    '%s:%s'", c.name, zshQuote(c.summary)))
+		specs := []string{"'(- *)--help[show help]'"}
+		for _, f := range commandFlags(c) {
+			if f.valued {
+				specs = append(specs, fmt.Sprintf("'--%s=[%s]:value:_files'", f.name, zshQuote(f.usage)))
+			} else {
+				specs = append(specs, fmt.Sprintf("'--%s[%s]'", f.name, zshQuote(f.usage)))
+			}
+		}
+		switch c.complete {
+		case completeBaselines:
+			specs = append(specs, "'*:baseline:_runtimebase_baselines'")
+		case completeFiles:
+			specs = append(specs, "'*:file:_files'")
+		case completeCommands:
+			specs = append(specs, "'1:command:_runtimebase_commands'")
+		case completeShells:
+			specs = append(specs, "'1:shell:(bash zsh fish)'")
+		}
+		cases = append(cases, fmt.Sprintf("        %s)\n          _arguments \\\n            %s\n          ;;",
+			c.name, strings.Join(specs, " \\\n            ")))
+	}
+	_, err := fmt.Fprintf(w, `#compdef runtimebase
+
+_runtimebase_commands() {
+  local -a commands
+  commands=(
+%s
+  )
+  _describe 'command' commands
+}
+
+_runtimebase_baselines() {
+  local -a baselines
+  baselines=(${(f)"$(runtimebase ${opt_args[--namespace]:+--namespace $opt_args[--namespace]} __baselines 2>/dev/null)"})
+  _describe 'baseline' baselines
+}
+
+_runtimebase() {
+  local curcontext="$curcontext" state line
+  typeset -A opt_args
+  _arguments -C \
+    '--namespace=[tenant namespace of the baselines to work with]:namespace:' \
+    '(- *)--help[show help]' \
+    '1:command:_runtimebase_commands' \
+    '*::arg:->args'
+  case $state in
+    args)
+      case $line[1] in
+%s
+      esac
+      ;;
+  esac
+}
+
+if [ "$funcstack[1]" = "_runtimebase" ]; then
+  _runtimebase "$@"
+else
+  compdef _runtimebase runtimebase
+fi
+`, strings.Join(items, "\n"), strings.Join(cases, "\n"))
+	return err
+}
+
+// fishQuote quotes s as a single-quoted fish string.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`, "\n", " ").Replace(s) + "'"
+}
+
+func writeFishCompletion(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString(`# fish completion for runtimebase
+function __runtimebase_baselines
+    runtimebase __baselines 2>/dev/null
+end
+
+complete -c runtimebase -f
+complete -c runtimebase -n __fish_use_subcommand -l namespace -x -d 'tenant namespace of the baselines to work with'
+`)
+	var names []string
+	for _, c := range visibleCommands() {
+		names = append(names, c.name)
+		fmt.Fprintf(&b, "complete -c runtimebase -n __fish_use_subcommand -a %s -d %s\n", c.name, fishQuote(c.summary))
+	}
+	for _, c := range visibleCommands() {
+		cond := fishQuote("__fish_seen_subcommand_from " + c.name)
+		for _, f := range commandFlags(c) {
+			option := ""
+			if f.valued {
+				option = " -r -F"
+			}
+			fmt.Fprintf(&b, "complete -c runtimebase -n %s -l %s%s -d %s\n", cond, f.name, option, fishQuote(f.usage))
+		}
+		switch c.complete {
+		case completeBaselines:
+			fmt.Fprintf(&b, "complete -c runtimebase -n %s -a '(__runtimebase_baselines)'\n", cond)
+		case completeFiles:
+			fmt.Fprintf(&b, "complete -c runtimebase -n %s -F\n", cond)
+		case completeCommands:
+			fmt.Fprintf(&b, "complete -c runtimebase -n %s -a %s\n", cond, fishQuote(strings.Join(names, " ")))
+		case completeShells:
+			fmt.Fprintf(&b, "complete -c runtimebase -n %s -a 'bash zsh fish'\n", cond)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,613 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/audit"
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/export"
+	"github.com/hallucinaut/runtimebase/pkg/storage"
+)
+
+// TestMain runs the CLI instead of the tests when the test binary is run
+// by runCLI.
+func TestMain(m *testing.M) {
+	if os.Getenv("RUNTIMEBASE_TEST_CLI") == "1" {
+		os.Args = append([]string{"runtimebase"}, os.Args[1:]...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runCLI runs runtimebase with args in a fresh home and returns its
+// output and exit code.
+func runCLI(t *testing.T, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	return runCLIIn(t, t.TempDir(), args...)
+}
+
+// runCLIIn is runCLI in the given home, for runs that build on each other.
+func runCLIIn(t *testing.T, home string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "RUNTIMEBASE_TEST_CLI=1", "RUNTIMEBASE_HOME="+home, "RUNTIMEBASE_CONFIG=", "NO_COLOR=1")
+	var out, errOut bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	err := cmd.Run()
+	var exit *exec.ExitError
+	switch {
+	case errors.As(err, &exit):
+		code = exit.ExitCode()
+	case err != nil:
+		t.Fatal(err)
+	}
+	return out.String(), errOut.String(), code
+}
+
+func TestDispatch(t *testing.T) {
+	tests := []struct {
+		args   []string
+		code   int
+		stdout string // contained in stdout
+		stderr string // contained in stderr
+	}{
+		{nil, 0, "Commands:", ""},
+		{[]string{"version"}, 0, "runtimebase version " + version, ""},
+		{[]string{"--no-color", "version"}, 0, "runtimebase version", ""},
+		{[]string{"help"}, 0, "Commands:", ""},
+		{[]string{"help", "detect"}, 0, "--fail-on", ""},
+		{[]string{"detect", "--help"}, 0, "", "runtimebase detect <name>"},
+		{[]string{"nosuch"}, exitError, "Commands:", "Unknown command: nosuch"},
+		{[]string{"help", "nosuch"}, exitError, "", "Unknown command: nosuch"},
+		{[]string{"help", "__baselines"}, exitError, "", "Unknown command"},
+		{[]string{"--bogus", "version"}, exitError, "", "flag provided but not defined"},
+		{[]string{"detect", "--bogus"}, exitError, "", "flag provided but not defined"},
+		{[]string{"detect", "--fail-on", "SEVERE", "myapp"}, exitError, "", "unknown --fail-on severity"},
+		{[]string{"learn"}, exitError, "", "Usage:"},
+		{[]string{"report", "nosuch"}, exitError, "", "baseline not found"},
+		{[]string{"trend", "nosuch"}, exitError, "", "baseline not found"},
+		{[]string{"score", "--since", "soon", "nosuch"}, exitError, "", "--since"},
+		{[]string{"score", "nosuch"}, exitError, "", "baseline not found"},
+		{[]string{"enforce", "nosuch"}, exitError, "", "baseline not found"},
+		{[]string{"completion"}, exitError, "", "Usage:"},
+		{[]string{"completion", "tcsh"}, exitError, "", "unsupported shell"},
+		{[]string{"completion", "bash"}, 0, "complete -o filenames -F _runtimebase runtimebase", ""},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			stdout, stderr, code := runCLI(t, tt.args...)
+			if code != tt.code {
+				t.Errorf("exit code %d, want %d\nstderr: %s", code, tt.code, stderr)
+			}
+			if !strings.Contains(stdout, tt.stdout) {
+				t.Errorf("stdout does not contain %q:\n%s", tt.stdout, stdout)
+			}
+			if !strings.Contains(stderr, tt.stderr) {
+				t.Errorf("stderr does not contain %q:\n%s", tt.stderr, stderr)
+			}
+		})
+	}
+}
+
+func TestReportIndicatorFormats(t *testing.T) {
+	home := t.TempDir()
+	store, err := storage.Open(home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveBaseline(baseline.NewLearner().CreateBaseline("web")); err != nil {
+		t.Fatal(err)
+	}
+	a := baseline.Anomaly{Type: "Unseen Behavior", Severity: "HIGH", Confidence: 0.9, Evidence: "network:203.0.113.7:443", Timestamp: time.Now()}
+	if err := store.AppendHistory("web", storage.Record{Time: a.Timestamp, Kind: "anomaly", Anomaly: &a}); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr, code := runCLIIn(t, home, "report", "--format", "stix", "web")
+	if code != 0 {
+		t.Fatalf("report --format stix: exit code %d\nstderr: %s", code, stderr)
+	}
+	var bundle export.STIXBundle
+	if err := json.Unmarshal([]byte(stdout), &bundle); err != nil {
+		t.Fatalf("report --format stix: %v\n%s", err, stdout)
+	}
+	if len(bundle.Objects) != 2 || bundle.Objects[1].Pattern != "[ipv4-addr:value = '203.0.113.7']" {
+		t.Errorf("STIX bundle objects: %+v", bundle.Objects)
+	}
+
+	stdout, stderr, code = runCLIIn(t, home, "report", "--format", "misp", "web")
+	if code != 0 {
+		t.Fatalf("report --format misp: exit code %d\nstderr: %s", code, stderr)
+	}
+	var event export.MISPEvent
+	if err := json.Unmarshal([]byte(stdout), &event); err != nil {
+		t.Fatalf("report --format misp: %v\n%s", err, stdout)
+	}
+	if attrs := event.Event.Attribute; len(attrs) != 1 || attrs[0].Value != "203.0.113.7" || !attrs[0].ToIDS {
+		t.Errorf("MISP attributes: %+v", attrs)
+	}
+}
+
+func TestDetectNeedsBaselineAndEvents(t *testing.T) {
+	home := t.TempDir()
+	events := filepath.Join(t.TempDir(), "events.jsonl")
+	if err := os.WriteFile(events, []byte(`{"Type":"syscall","Data":{"syscall":"open"}}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, code := runCLIIn(t, home, "detect", "--events", events, "nosuch")
+	if code != exitError || !strings.Contains(stderr, "baseline not found") {
+		t.Errorf("missing baseline: exit code %d, want %d\nstderr: %s", code, exitError, stderr)
+	}
+	if strings.Contains(stdout, "No anomalies") {
+		t.Errorf("missing baseline reported as clean:\n%s", stdout)
+	}
+	if _, err := os.Stat(filepath.Join(home, "history", "nosuch.jsonl")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no history for a missing baseline, got %v", err)
+	}
+
+	if _, stderr, code := runCLIIn(t, home, "detect", "nosuch"); code != exitError || !strings.Contains(stderr, "--events required") {
+		t.Errorf("no events: exit code %d, want %d\nstderr: %s", code, exitError, stderr)
+	}
+}
+
+func TestLearnLabelsKeepLearnedStats(t *testing.T) {
+	home := t.TempDir()
+	observations := filepath.Join(t.TempDir(), "observations.jsonl")
+	var lines strings.Builder
+	for i := 0; i < 10; i++ {
+		lines.WriteString(`{"category":"syscall","pattern":"openat","count":100}` + "\n")
+	}
+	if err := os.WriteFile(observations, []byte(lines.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, code := runCLIIn(t, home, "learn", "--from", observations, "web")
+	if code != 0 {
+		t.Fatalf("learn: exit code %d\nstderr: %s", code, stderr)
+	}
+	if want := "Baseline web: 1 keys, 10 samples, counted per 1m0s of event time"; !strings.Contains(stdout, want) {
+		t.Errorf("learn printed\n%s\nwant the summary %q", stdout, want)
+	}
+	for _, args := range [][]string{{"--label", "owner=payments"}, {"--cold-start", "24h"}, nil} {
+		if _, stderr, code := runCLIIn(t, home, append(append([]string{"learn"}, args...), "web")...); code != 0 {
+			t.Fatalf("learn %v: exit code %d\nstderr: %s", args, code, stderr)
+		}
+	}
+
+	store, err := storage.Open(home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := store.LoadBaseline("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat := b.Stats["syscall:openat"]; stat.SampleCount != 10 {
+		t.Errorf("relabeling left %d samples of syscall:openat, want 10", stat.SampleCount)
+	}
+	if b.Metadata["owner"] != "payments" || b.ColdStart != 24*time.Hour {
+		t.Errorf("labels %v, cold start %s", b.Metadata, b.ColdStart)
+	}
+	entries, err := store.Audit.Query(audit.Filter{Target: "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		for _, c := range e.Diff {
+			if strings.HasPrefix(c.Field, "Stats.") && c.New == "" {
+				t.Errorf("audit log records the removal of %s", c.Field)
+			}
+		}
+	}
+}
+
+func TestRelearnHoldsAnomalousObservations(t *testing.T) {
+	home := t.TempDir()
+	dir := t.TempDir()
+	write := func(name string, counts ...int) string {
+		var lines strings.Builder
+		for _, count := range counts {
+			fmt.Fprintf(&lines, `{"category":"syscall","pattern":"openat","count":%d}`+"\n", count)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(lines.String()), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	learned := write("learned.jsonl", 100, 100, 100, 100, 100)
+	relearned := write("relearned.jsonl", 100, 5000, 100)
+	if _, stderr, code := runCLIIn(t, home, "learn", "--from", learned, "web"); code != 0 {
+		t.Fatalf("learn: exit code %d\nstderr: %s", code, stderr)
+	}
+	stdout, stderr, code := runCLIIn(t, home, "learn", "--relearn", "--from", relearned, "web")
+	if code != 0 {
+		t.Fatalf("learn --relearn: exit code %d\nstderr: %s", code, stderr)
+	}
+	if want := "1 observations pending approval"; !strings.Contains(stdout, want) {
+		t.Errorf("learn --relearn printed\n%s\nwant %q", stdout, want)
+	}
+
+	store, err := storage.Open(home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := store.LoadBaseline("web.candidate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Reference == nil || c.Reference.Name != "web" || len(c.Pending) != 1 || c.Stats["syscall:openat"].SampleCount != 2 {
+		t.Fatalf("candidate: reference %v, %d pending, stats %v", c.Reference, len(c.Pending), c.Stats)
+	}
+	if _, _, code := runCLIIn(t, home, "shadow", "--promote", "web"); code != exitError {
+		t.Errorf("shadow --promote with observations pending: exit code %d, want %d", code, exitError)
+	}
+	if stdout, stderr, code := runCLIIn(t, home, "baselines", "approve", "web.candidate", "syscall:openat"); code != 0 || !strings.Contains(stdout, "Approved 1 pending observations") {
+		t.Fatalf("baselines approve: exit code %d\nstdout: %s\nstderr: %s", code, stdout, stderr)
+	}
+	if _, stderr, code := runCLIIn(t, home, "shadow", "--promote", "web"); code != 0 {
+		t.Fatalf("shadow --promote: exit code %d\nstderr: %s", code, stderr)
+	}
+	b, err := store.LoadBaseline("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Reference != nil || len(b.Pending) != 0 || b.Stats["syscall:openat"].SampleCount != 3 {
+		t.Errorf("promoted: reference %v, %d pending, stats %v", b.Reference, len(b.Pending), b.Stats)
+	}
+}
+func TestUnseenInColdStart(t *testing.T) {
+	home, dir := t.TempDir(), t.TempDir()
+	// An hour of a baseline starting cold for a day.
+	var observations strings.Builder
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for minute := 0; minute <= 60; minute++ {
+		fmt.Fprintf(&observations, `{"category":"syscall","pattern":"openat","count":100,"time":%q}`+"\n", start.Add(time.Duration(minute)*time.Minute).Format(time.RFC3339))
+	}
+	path := filepath.Join(dir, "observations.jsonl")
+	if err := os.WriteFile(path, []byte(observations.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, stderr, code := runCLIIn(t, home, "learn", "--from", path, "--cold-start", "24h", "web"); code != 0 {
+		t.Fatalf("learn: exit code %d\nstderr: %s", code, stderr)
+	}
+
+	// The usual openat, and a reverse shell.
+	events := filepath.Join(dir, "events.jsonl")
+	err := os.WriteFile(events, []byte(`{"Type":"syscall","Data":{"syscall":"openat"}}
+{"Type":"process","Path":"/bin/sh","PID":41}
+{"Type":"network","PID":41,"Data":{"destination":"203.0.113.7:4444"}}
+{"Type":"syscall","PID":41,"Data":{"syscall":"dup2"}}
+{"Type":"syscall","PID":41,"Data":{"syscall":"dup2"}}
+`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"process:/bin/sh", "network:203.0.113.7:4444", "syscall:dup2"}
+
+	stdout, stderr, code := runCLIIn(t, home, "detect", "--events", events, "web")
+	if code != exitHigh {
+		t.Errorf("detect: exit code %d, want %d\nstderr: %s", code, exitHigh, stderr)
+	}
+	if !strings.Contains(stdout, "Found 3 anomalies") || !strings.Contains(stdout, "cold start") {
+		t.Errorf("detect reported:\n%s", stdout)
+	}
+	for _, key := range want {
+		if !strings.Contains(stdout, "Evidence: "+key) {
+			t.Errorf("detect did not report %s:\n%s", key, stdout)
+		}
+	}
+
+	stdout, stderr, code = runCLIIn(t, home, "check", "--events", events, "--json", "web")
+	if code != exitHigh {
+		t.Errorf("check: exit code %d, want %d\nstderr: %s", code, exitHigh, stderr)
+	}
+	var report struct {
+		Unseen []baseline.Anomaly `json:"unseen_anomalies"`
+	}
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+		t.Fatalf("%v: %s", err, stdout)
+	}
+	var got []string
+	for _, a := range report.Unseen {
+		if !a.ColdStart {
+			t.Errorf("%s not marked as raised in cold start", a.Evidence)
+		}
+		got = append(got, a.Evidence)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("check reported unseen %q, want %q", got, want)
+	}
+}
+
+func TestCheckRecordsOnlyWhenAsked(t *testing.T) {
+	home := t.TempDir()
+	events := filepath.Join(t.TempDir(), "events.jsonl")
+	if err := os.WriteFile(events, []byte(`{"Type":"syscall","Data":{"syscall":"open"}}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	scores := func() string {
+		t.Helper()
+		stdout, stderr, code := runCLIIn(t, home, "score", "--json", "web")
+		if code != 0 {
+			t.Fatalf("score: exit code %d\nstderr: %s", code, stderr)
+		}
+		return strings.Join(strings.Fields(stdout), "")
+	}
+
+	observations := filepath.Join(t.TempDir(), "observations.jsonl")
+	if err := os.WriteFile(observations, []byte(`{"category":"syscall","pattern":"open","count":1}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, stderr, code := runCLIIn(t, home, "learn", "--from", observations, "web"); code != 0 {
+		t.Fatalf("learn: exit code %d\nstderr: %s", code, stderr)
+	}
+	if _, stderr, code := runCLIIn(t, home, "check", "--events", events, "web"); code != 0 {
+		t.Fatalf("check: exit code %d\nstderr: %s", code, stderr)
+	}
+	if got := scores(); got != "[]" {
+		t.Errorf("check without --record stored scores: %s", got)
+	}
+
+	if _, stderr, code := runCLIIn(t, home, "check", "--events", events, "--release", "v2", "web"); code != exitError || !strings.Contains(stderr, "--release requires --record") {
+		t.Errorf("--release without --record: exit code %d\nstderr: %s", code, stderr)
+	}
+	if _, stderr, code := runCLIIn(t, home, "check", "--events", events, "--record", "--release", "v2", "web"); code != 0 {
+		t.Fatalf("check --record: exit code %d\nstderr: %s", code, stderr)
+	}
+	if got := scores(); !strings.Contains(got, `"release":"v2"`) {
+		t.Errorf("check --record stored no score: %s", got)
+	}
+}
+
+func TestCheckWindow(t *testing.T) {
+	home, dir := t.TempDir(), t.TempDir()
+	observations := filepath.Join(dir, "observations.jsonl")
+	if err := os.WriteFile(observations, []byte(`{"category":"syscall","pattern":"open","count":12}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, stderr, code := runCLIIn(t, home, "learn", "--from", observations, "web"); code != 0 {
+		t.Fatalf("learn: exit code %d\nstderr: %s", code, stderr)
+	}
+
+	// Two events in ten seconds, the 12 a minute learned, then a burst of
+	// eight.
+	var events strings.Builder
+	for _, second := range []int{1, 5, 21, 22, 23, 24, 25, 26, 27, 28, 45} {
+		fmt.Fprintf(&events, `{"Type":"syscall","Timestamp":"2026-01-01T00:00:%02dZ","Data":{"syscall":"open"}}`+"\n", second)
+	}
+	path := filepath.Join(dir, "events.jsonl")
+	if err := os.WriteFile(path, []byte(events.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, stderr, code := runCLIIn(t, home, "check", "--events", path, "--window", "10s", "--interval", "10s", "--json", "web")
+	if code != exitHigh {
+		t.Errorf("exit code %d, want %d for the burst\nstderr: %s", code, exitHigh, stderr)
+	}
+	var windows []string
+	for _, line := range strings.Split(strings.TrimSpace(stdout), "\n") {
+		var ws struct {
+			End    time.Time `json:"end"`
+			Events int       `json:"events"`
+			Score  float64   `json:"score"`
+		}
+		if err := json.Unmarshal([]byte(line), &ws); err != nil {
+			t.Fatalf("%v: %s", err, line)
+		}
+		windows = append(windows, fmt.Sprintf("%s %d %.0f", ws.End.Format("04:05"), ws.Events, ws.Score))
+	}
+	want := []string{"00:10 2 100", "00:20 0 100", "00:30 8 0", "00:40 0 100", "00:45 1 100"}
+	if !reflect.DeepEqual(windows, want) {
+		t.Errorf("windows %q, want %q", windows, want)
+	}
+}
+
+func TestCheckScopesExpectedTotals(t *testing.T) {
+	home, dir := t.TempDir(), t.TempDir()
+	observations := filepath.Join(dir, "observations.jsonl")
+	learned := `{"category":"syscall","pattern":"open","count":100}` + "\n" + `{"category":"network","pattern":"10.0.0.9:443","count":10}` + "\n"
+	if err := os.WriteFile(observations, []byte(learned), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, stderr, code := runCLIIn(t, home, "learn", "--from", observations, "web"); code != 0 {
+		t.Fatalf("learn: exit code %d\nstderr: %s", code, stderr)
+	}
+
+	// Three times the network rate learned in the first minute, and four
+	// times the open rate an hour later.
+	var events strings.Builder
+	for i := 0; i < 30; i++ {
+		fmt.Fprintf(&events, `{"Type":"network","Timestamp":"2026-01-01T00:00:%02dZ","Data":{"destination":"10.0.0.9:443"}}`+"\n", i)
+	}
+	for i := 0; i < 400; i++ {
+		fmt.Fprintf(&events, `{"Type":"syscall","Timestamp":"2026-01-01T01:00:%02dZ","Data":{"syscall":"open"}}`+"\n", i%60)
+	}
+	path := filepath.Join(dir, "events.jsonl")
+	if err := os.WriteFile(path, []byte(events.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	score := func(args ...string) float64 {
+		t.Helper()
+		stdout, stderr, code := runCLIIn(t, home, append(append([]string{"check", "--events", path, "--json"}, args...), "web")...)
+		if code == exitError {
+			t.Fatalf("check %v: exit code %d\nstderr: %s", args, code, stderr)
+		}
+		var breakdown detect.Breakdown
+		if err := json.Unmarshal([]byte(stdout), &breakdown); err != nil {
+			t.Fatalf("check %v: %v\n%s", args, err, stdout)
+		}
+		return breakdown.Score
+	}
+	if got := score(); got != 100 {
+		t.Errorf("score over both hours %.0f, want 100", got)
+	}
+	if got := score("--category", "network"); got != 0 {
+		t.Errorf("network score %.0f, want 0 against the network rate learned", got)
+	}
+	if got := score("--since", "2026-01-01T01:00:00Z"); got != 0 {
+		t.Errorf("score since 01:00 %.0f, want 0", got)
+	}
+}
+
+func TestIntervalVolumeAnomalies(t *testing.T) {
+	b := baseline.NewLearner().CreateBaseline("db")
+	b.Volume = map[string]baseline.Stat{"file:/var/lib/db": {Mean: 1000, StdDev: 100, SampleCount: 30}}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var events []detect.SystemEvent
+	for minute, bytes := range []int64{1000, 1100, 5000, 900, 20000, 1000, 1000, 1000, 1000, 1000} {
+		events = append(events, detect.SystemEvent{Type: "file", Path: "/var/lib/db", Bytes: bytes, Timestamp: start.Add(time.Duration(minute) * time.Minute)})
+	}
+	// Ten minutes of the usual volume, summed, would be ten times it.
+	anomalies := intervalVolumeAnomalies(b, events)
+	if len(anomalies) != 1 || !strings.Contains(anomalies[0].Description, "20x") {
+		t.Fatalf("expected the worst minute only, got %+v", anomalies)
+	}
+	if anomalies := intervalVolumeAnomalies(b, events[5:]); len(anomalies) != 0 {
+		t.Errorf("usual volumes reported: %+v", anomalies)
+	}
+}
+
+func TestCanary(t *testing.T) {
+	home, dir := t.TempDir(), t.TempDir()
+	observations := filepath.Join(dir, "observations.jsonl")
+	if err := os.WriteFile(observations, []byte(`{"category":"syscall","pattern":"open","count":2}`+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, stderr, code := runCLIIn(t, home, "learn", "--from", observations, "web"); code != 0 {
+		t.Fatalf("learn: exit code %d\nstderr: %s", code, stderr)
+	}
+	eventsFile := func(name string, syscalls ...string) string {
+		var events strings.Builder
+		at := time.Date(2026, 1, 1, 0, 0, 10, 0, time.UTC)
+		for i, syscall := range syscalls {
+			fmt.Fprintf(&events, `{"Type":"syscall","Timestamp":%q,"Data":{"syscall":%q}}`+"\n", at.Add(time.Duration(i)*30*time.Second).Format(time.RFC3339), syscall)
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(events.String()), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	canary := func(events string, extra ...string) (report canaryReport, stderr string, code int) {
+		t.Helper()
+		args := append([]string{"canary", "--stable", "web", "--candidate", "web-canary", "--duration", "1m", "--events", events, "--json"}, extra...)
+		stdout, stderr, code := runCLIIn(t, home, args...)
+		if code == 0 || code == exitHigh {
+			if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+				t.Fatalf("%v: %s", err, stdout)
+			}
+		}
+		return report, stderr, code
+	}
+
+	report, stderr, code := canary(eventsFile("same.jsonl", "open", "open", "open", "open"))
+	if code != 0 || !report.Pass {
+		t.Fatalf("same behavior: exit code %d, report %+v\nstderr: %s", code, report, stderr)
+	}
+	if report.Events != 4 || report.Span != 90*time.Second || report.Divergence.Score != 0 {
+		t.Errorf("same behavior: report %+v", report)
+	}
+
+	diverged := eventsFile("diverged.jsonl", "open", "ptrace", "open", "mount")
+	if _, stderr, code := canary(diverged); code != exitError || !strings.Contains(stderr, "--replace") {
+		t.Errorf("existing candidate: exit code %d, want %d\nstderr: %s", code, exitError, stderr)
+	}
+	report, stderr, code = canary(diverged, "--replace")
+	if code != exitHigh || report.Pass {
+		t.Fatalf("diverged behavior: exit code %d, want %d, report %+v\nstderr: %s", code, exitHigh, report, stderr)
+	}
+	if len(report.Divergence.New) != 2 || report.Divergence.Score < report.Threshold {
+		t.Errorf("diverged behavior: divergence %+v", report.Divergence)
+	}
+
+	if _, stderr, code := runCLIIn(t, home, "canary", "--stable", "web", "--candidate", "web"); code != exitError || !strings.Contains(stderr, "must differ") {
+		t.Errorf("same baselines: exit code %d\nstderr: %s", code, stderr)
+	}
+}
+
+func TestCommandTable(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, c := range commands {
+		if seen[c.name] {
+			t.Errorf("command %s defined twice", c.name)
+		}
+		seen[c.name] = true
+		if lookupCommand(c.name) != c {
+			t.Errorf("lookupCommand(%q) does not return it", c.name)
+		}
+		if c.setup == nil || !c.hidden && c.summary == "" {
+			t.Errorf("command %s has no setup or summary", c.name)
+			continue
+		}
+		switch c.complete {
+		case "", completeBaselines, completeFiles, completeCommands, completeShells:
+		default:
+			t.Errorf("command %s completes to unknown %q", c.name, c.complete)
+		}
+
+		fs, run := c.flagSet()
+		if run == nil {
+			t.Errorf("command %s has no run function", c.name)
+		}
+		if fs.ErrorHandling() != flag.ContinueOnError {
+			t.Errorf("command %s exits on flag errors by itself", c.name)
+		}
+		var usage bytes.Buffer
+		c.usage(&usage, fs)
+		if !strings.HasPrefix(usage.String(), "Usage:\n  runtimebase "+c.name) || !strings.Contains(usage.String(), c.summary) {
+			t.Errorf("usage of %s:\n%s", c.name, usage.String())
+		}
+	}
+	if lookupCommand("nosuch") != nil {
+		t.Error("expected no command nosuch")
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	writers := map[string]func(io.Writer) error{
+		"bash": writeBashCompletion,
+		"zsh":  writeZshCompletion,
+		"fish": writeFishCompletion,
+	}
+	for shell, write := range writers {
+		t.Run(shell, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := write(&out); err != nil {
+				t.Fatal(err)
+			}
+			script := out.String()
+			for _, c := range commands {
+				if got := strings.Contains(script, c.name); got == c.hidden && c.name != "__baselines" {
+					t.Errorf("command %s: in script %v, hidden %v", c.name, got, c.hidden)
+				}
+			}
+			for _, want := range []string{"fail-on", "__baselines", "namespace"} {
+				if !strings.Contains(script, want) {
+					t.Errorf("script does not contain %q", want)
+				}
+			}
+			if path, err := exec.LookPath(shell); err == nil && shell != "fish" {
+				check := exec.Command(path, "-n")
+				check.Stdin = strings.NewReader(script)
+				if out, err := check.CombinedOutput(); err != nil {
+					t.Errorf("%s -n: %v\n%s", shell, err, out)
+				}
+			}
+		})
+	}
+}
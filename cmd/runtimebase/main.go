@@ -1,12 +1,56 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
 	"os"
-//	"path/filepath"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/hallucinaut/runtimebase/pkg/audit"
 	"github.com/hallucinaut/runtimebase/pkg/baseline"
-//	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/bootstrap"
+	"github.com/hallucinaut/runtimebase/pkg/category"
+	"github.com/hallucinaut/runtimebase/pkg/collect"
+	"github.com/hallucinaut/runtimebase/pkg/config"
+	"github.com/hallucinaut/runtimebase/pkg/daemon"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/enforce"
+	"github.com/hallucinaut/runtimebase/pkg/enrich"
+	"github.com/hallucinaut/runtimebase/pkg/export"
+	"github.com/hallucinaut/runtimebase/pkg/filter"
+	"github.com/hallucinaut/runtimebase/pkg/logfile"
+	"github.com/hallucinaut/runtimebase/pkg/logging"
+	"github.com/hallucinaut/runtimebase/pkg/metrics"
+	"github.com/hallucinaut/runtimebase/pkg/mining"
+	"github.com/hallucinaut/runtimebase/pkg/ocihook"
+	"github.com/hallucinaut/runtimebase/pkg/pipeline"
+	"github.com/hallucinaut/runtimebase/pkg/proctree"
+	"github.com/hallucinaut/runtimebase/pkg/report"
+	"github.com/hallucinaut/runtimebase/pkg/rules"
+	"github.com/hallucinaut/runtimebase/pkg/service"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+	"github.com/hallucinaut/runtimebase/pkg/simulate"
+	"github.com/hallucinaut/runtimebase/pkg/storage"
+	"github.com/hallucinaut/runtimebase/pkg/term"
+	"github.com/hallucinaut/runtimebase/pkg/timeline"
+	// "github.com/hallucinaut/runtimebase/pkg/detect"
 )
 
 const version = "1.0.0"
@@ -16,155 +60,3304 @@ func main() {
 		printUsage()
 		return
 	}
+	setupLogging(logging.Config{})
+	applyConfig()
 
-	switch os.Args[1] {
-	case "learn":
-		if len(os.Args) < 3 {
-			fmt.Println("Error: baseline name required")
-			printUsage()
+	global := flag.NewFlagSet("runtimebase", flag.ContinueOnError)
+	global.Usage = printUsage
+	global.StringVar(&namespace, "namespace", namespace, "tenant namespace of the baselines to work with")
+	global.BoolVar(&noColor, "no-color", false, "do not color output")
+	global.BoolVar(&readOnly, "read-only", readOnly, "open the baseline store read-only, refusing changes")
+	if err := global.Parse(os.Args[1:]); err != nil {
+		os.Exit(parseExitCode(err))
+	}
+	args := global.Args()
+	if len(args) == 0 {
+		printUsage()
+		return
+	}
+
+	c := lookupCommand(args[0])
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", args[0])
+		printUsage()
+		os.Exit(exitError)
+	}
+	c.run(args[1:])
+}
+
+func learnBaseline(fs *flag.FlagSet) func(args []string) {
+	from := fs.String("from", "", "learn the observations in this JSON lines file, resuming where the last run stopped")
+	coldStart := fs.Duration("cold-start", 0, "event time to learn before detecting in full, e.g. 24h; only new behavior is reported until then, negative to turn cold start off (default: the baseline's)")
+	relearn := fs.Bool("relearn", false, "learn --from afresh into the baseline's candidate, quarantining observations anomalous against the baseline until approved")
+	labels := addLabelFlag(fs)
+	return func(positional []string) {
+		if len(positional) < 1 {
+			slog.Error("baseline name required")
+			fs.Usage()
+			os.Exit(exitError)
+		}
+		name := positional[0]
+		metadata := parseLabels(*labels)
+		if *relearn && *from == "" {
+			fs.Usage()
+			fail(errors.New("--relearn requires --from"))
+		}
+
+		store, err := openStore()
+		if err != nil {
+			fail(err)
+		}
+		if *relearn {
+			candidate := name + pipeline.DefaultCandidateSuffix
+			if err := relearnCandidate(store, name, candidate); err != nil {
+				fail(err)
+			}
+			fmt.Printf("Relearning %s into %s\n", name, candidate)
+			name = candidate
+		}
+		n := 0
+		if *from != "" {
+			learner := baseline.NewLearner(baseline.WithStorage(store), baseline.WithNamespace(namespace))
+			if n, err = learner.LearnFromFile(name, *from); err != nil {
+				fail(err)
+			}
+		}
+		// Labels and cold start change only those of a baseline already
+		// learned; without --from, one is created if there is none.
+		var learned *baseline.Baseline
+		created := false
+		err = store.Update(name, func(b *baseline.Baseline) (*baseline.Baseline, error) {
+			learned = b
+			switch {
+			case b == nil && *from == "":
+				b, created = baseline.NewLearner().CreateBaseline(name), true
+			case len(metadata) == 0 && *coldStart == 0:
+				return nil, nil
+			case b == nil:
+				return nil, storage.ErrNotFound
+			}
+			b.SetMetadata(metadata)
+			if *coldStart != 0 {
+				b.ColdStart = *coldStart
+			}
+			learned = b
+			return b, nil
+		})
+		if err != nil {
+			fail(err)
+		}
+
+		switch {
+		case *from != "":
+			fmt.Printf("Learned %d new observations from %s\n", n, *from)
+		case created:
+			fmt.Printf("Created baseline %s\n", name)
+		default:
+			fmt.Printf("Updated baseline %s\n", name)
+		}
+		if learned != nil {
+			printLearned(learned)
+		}
+	}
+}
+
+// relearnCandidate creates candidate as a fresh baseline screened against
+// active, as by baseline.Learner.Relearn, unless a candidate of active
+// already exists to resume.
+func relearnCandidate(store *storage.Store, active, candidate string) error {
+	c, err := store.LoadBaseline(candidate)
+	if err == nil {
+		if c.CandidateFor != active {
+			return fmt.Errorf("baseline %s exists and is not a candidate of %s", candidate, active)
+		}
+		return nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	b, err := store.LoadBaseline(active)
+	if err != nil {
+		return err
+	}
+	learner := baseline.NewLearner()
+	learner.AddBaseline(b)
+	c = learner.Relearn(active)
+	c.Name = candidate
+	c.CandidateFor = active
+	c.SetMetadata(b.Metadata)
+	return store.SaveBaseline(c)
+}
+
+// printLearned summarizes what b has learned for learn.
+func printLearned(b *baseline.Baseline) {
+	samples := 0
+	for _, stat := range b.Stats {
+		samples += stat.SampleCount
+	}
+	q := b.Quality()
+	fmt.Printf("Baseline %s: %d keys, %d samples, counted per %s of event time\n", b.Name, q.Keys, samples, b.BucketWidthOrDefault())
+	if len(b.Metadata) > 0 {
+		fmt.Printf("  labels %s\n", baseline.FormatMetadata(b.Metadata))
+	}
+	if status := b.ColdStartStatus(); status != "" {
+		fmt.Printf("  %s: reporting new behavior only\n", status)
+	}
+	if len(b.Pending) > 0 {
+		fmt.Printf("  %d observations pending approval: runtimebase baselines approve|reject %s\n", len(b.Pending), b.Name)
+	}
+	if q.Keys == 0 {
+		fmt.Println("  nothing learned yet: learn observations with --from, analyze --learn, simulate --learn or a daemon learn source")
+	}
+}
+
+func detectAnomalies(fs *flag.FlagSet) func(args []string) {
+	eventsPath := fs.String("events", "", "JSON lines file of events to detect, - for stdin (required)")
+	interval := fs.Duration("interval", time.Minute, "interval the baseline learned counts over")
+	top := fs.Int("top", 0, "also show the N keys deviating most from the baseline, alerting or not")
+	minSamples := fs.Int("min-samples", 0, fmt.Sprintf("samples a key needs before it can alert (default: the baseline's, or %d)", baseline.DefaultMinSamples))
+	g := addGateFlags(fs)
+	ff := addFilterFlags(fs)
+	return func(positional []string) {
+		g.validate()
+		scope := ff.filter()
+		if len(positional) < 1 {
+			fs.Usage()
+			fail(errors.New("baseline name required"))
+		}
+		name := positional[0]
+		if *eventsPath == "" {
+			fs.Usage()
+			fail(errors.New("--events required: a JSON lines file, or - for stdin"))
+		}
+		out := g.output()
+
+		store, err := openStore()
+		if err != nil {
+			fail(err)
+		}
+		stored, err := store.LoadBaseline(name)
+		if err != nil {
+			fail(err)
+		}
+		learner := baseline.NewLearner()
+		learner.AddBaseline(stored)
+		if *minSamples > 0 {
+			stored.MinSampleCount = *minSamples
+		}
+		events, err := readEvents(*eventsPath, scope, store, name)
+		if err != nil {
+			fail(err)
+		}
+
+		fmt.Fprintf(out, "Detecting anomalies for: %s\n", name)
+		fmt.Fprintln(out)
+
+		var results []baseline.Anomaly
+		for _, anomaly := range unseenAnomalies(store, stored, events) {
+			if scope.Anomaly(anomaly) {
+				results = append(results, anomaly)
+			}
+		}
+		observed := detect.IntervalCounts(events, *interval)
+		if lastSeen, first, last := detect.LastSeen(events); !first.IsZero() {
+			for _, anomaly := range stored.Silences(lastSeen, first, last) {
+				if scope.Anomaly(anomaly) {
+					results = append(results, anomaly)
+				}
+			}
+		}
+		keys := make([]string, 0, len(observed))
+		for key := range observed {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			category, pattern, _ := strings.Cut(key, ":")
+			for _, anomaly := range learner.DetectAnomaly(name, category, pattern, int(math.Round(observed[key]))) {
+				if scope.Anomaly(anomaly) {
+					results = append(results, anomaly)
+				}
+			}
+		}
+		anomalies, insufficient := baseline.SplitInsufficient(results)
+		anomalies, _ = learner.GetBaseline(name).FilterOverrides(anomalies, time.Now())
+		anomalies, _ = learner.GetBaseline(name).FilterColdStart(anomalies)
+		learner.GetBaseline(name).ApplyMetadata(anomalies)
+		anomalies, suppressed := cliConfig.Suppress.Filter(anomalies)
+		if enricher := loadEnricher(); enricher != nil {
+			enricher.Annotate(anomalies)
+		}
+		cliConfig.Remediation.Apply(anomalies)
+		if err := store.AppendAnomalies(name, anomalies); err != nil {
+			slog.Warn("could not record history", "error", err)
+		}
+
+		printColdStart(out, learner.GetBaseline(name))
+		if len(anomalies) > 0 {
+			fmt.Fprintf(out, "Found %d anomalies:\n\n", len(anomalies))
+			for i, anomaly := range anomalies {
+				printAnomalyHeader(out, i, anomaly)
+				fmt.Fprintf(out, "    Evidence: %s\n", anomaly.Evidence)
+				fmt.Fprintf(out, "    Confidence: %.0f%%\n", anomaly.Confidence*100)
+				fmt.Fprintf(out, "    Risk Level: %s\n", anomaly.RiskLevel)
+				printEnrichment(out, anomaly.Enrichment)
+				printRemediation(out, anomaly.Remediation)
+				printLineage(out, anomaly.Lineage)
+				fmt.Fprintln(out)
+			}
+		} else {
+			fmt.Fprintln(out, "No anomalies detected - behavior within normal range")
+		}
+		if suppressed > 0 {
+			fmt.Fprintf(out, "%d anomalies suppressed by configuration\n", suppressed)
+		}
+		printInsufficient(out, insufficient)
+		if deviations := learner.GetBaseline(name).TopDeviations(observed, *top); len(deviations) > 0 {
+			fmt.Fprintf(out, "\nTop %d deviators:\n", len(deviations))
+			p := paint(out)
+			t := term.NewTable(out)
+			t.Indent, t.Right = "  ", []int{0}
+			for _, d := range deviations {
+				alerting := ""
+				switch {
+				case d.Alerting() && d.Insufficient():
+					alerting = p.Dim(fmt.Sprintf("insufficient data (%d of %d samples)", d.Samples, d.MinSamples))
+				case d.Alerting():
+					alerting = p.Bad("alerting")
+				}
+				t.Row(fmt.Sprintf("%+.2fσ", d.ZScore), d.Key,
+					fmt.Sprintf("observed %.1f, mean %.1f ± %.1f", d.Observed, d.Mean, d.StdDev), alerting)
+			}
+			t.Flush()
+		}
+
+		severities := make([]string, len(anomalies))
+		for i, anomaly := range anomalies {
+			severities[i] = anomaly.Severity
+		}
+		g.exit(severities...)
+	}
+}
+
+func analyzeLog(fs *flag.FlagSet) func(args []string) {
+	minCount := fs.Int("min-count", 2, "lines a template needs to become a pattern")
+	similarity := fs.Float64("similarity", 0.4, "fraction of matching tokens for a line to join a template")
+	top := fs.Int("top", 20, "templates or operations to print, 0 for all")
+	learnName := fs.String("learn", "", "add discovered templates to this baseline, creating it if needed")
+	against := fs.String("baseline", "", "report templates new to, or deviating from, this baseline")
+	jsonOutput := fs.Bool("json", false, "print templates and anomalies as JSON")
+	rotated := fs.Bool("rotated", true, "also read rotated predecessors (app.log.2.gz, app.log.1), oldest first")
+	follow := fs.Bool("follow", false, "keep reading as the file grows, like tail -F, until interrupted")
+	format := fs.String("format", "text", "input format: text to mine message templates, or events as "+strings.Join(pipeline.Parsers.Names(), ", "))
+	interval := fs.Duration("interval", time.Minute, "learning interval for --learn with an event format")
+	lateness := fs.Duration("lateness", 0, "how long after its interval an out-of-order event still counts, for --learn with an event format")
+	timezone := fs.String("timezone", "", "time zone of times written without one, such as syslog's or strace's (default local)")
+	dedup := fs.Duration("dedup", 0, "drop events another of the files reported for the same process and object within this window, with an event format")
+	labels := addLabelFlag(fs)
+	g := addGateFlags(fs)
+	ff := addFilterFlags(fs)
+	return func(positional []string) {
+		g.validate()
+		scope := &filter.Lines{Filter: ff.filter(), Year: time.Now().Year()}
+		metadata := parseLabels(*labels)
+		if *timezone != "" {
+			loc, err := time.LoadLocation(*timezone)
+			if err != nil {
+				fail(fmt.Errorf("--timezone: %w", err))
+			}
+			scope.Loc = loc
+		}
+		if len(positional) < 1 {
+			fs.Usage()
+			fail(errors.New("log file required"))
+		}
+		out := g.output()
+		inputs, err := analyzeInputs(positional, *rotated)
+		if err != nil {
+			fail(err)
+		}
+		// Standard input and named pipes are already streams; there is no
+		// file to follow.
+		path := inputNames(inputs)
+		paths := inputs[0]
+		*follow = *follow && !logfile.IsStream(paths[len(paths)-1])
+		if *follow && len(inputs) > 1 {
+			fail(errors.New("--follow reads a single file"))
+		}
+		if *format != "text" {
+			if !pipeline.Parsers.Has(*format) {
+				fail(fmt.Errorf("unknown --format %q: want text, %s", *format, strings.Join(pipeline.Parsers.Names(), ", ")))
+			}
+			analyzeEvents(out, inputs, eventAnalysis{
+				format:        *format,
+				parserOptions: pipeline.Options{"timezone": *timezone},
+				dedup:         *dedup,
+				follow:        *follow,
+				scope:         scope.Filter,
+				learn:         *learnName,
+				metadata:      metadata,
+				against:       *against,
+				interval:      *interval,
+				lateness:      *lateness,
+				top:           *top,
+				jsonOutput:    *jsonOutput,
+			}, g)
+			return
+		}
+		miner := mining.NewMiner()
+		miner.Similarity = *similarity
+		lines := 0
+		add := func(line []byte) {
+			if !scope.Filter.Empty() && !scope.Match(string(line)) {
+				return
+			}
+			c := miner.AddBytes(line)
+			if c == nil {
+				return
+			}
+			lines++
+			if *follow && c.Count == 1 && !*jsonOutput {
+				fmt.Fprintf(out, "new template [%d]: %s\n", c.ID, c)
+			}
+		}
+		// Templates do not depend on the order lines arrive in, so the
+		// files of several logs are mined one after the other.
+		for _, paths := range inputs {
+			scanInput(paths, *follow, add)
+		}
+		clusters := miner.Clusters()
+
+		var store *storage.Store
+		if *against != "" || *learnName != "" {
+			if store, err = openStore(); err != nil {
+				fail(err)
+			}
+		}
+
+		var anomalies, insufficient []baseline.Anomaly
+		var againstBaseline *baseline.Baseline
+		if *against != "" {
+			b, err := store.LoadBaseline(*against)
+			if err != nil {
+				fail(err)
+			}
+			againstBaseline = b
+			learner := baseline.NewLearner()
+			learner.AddBaseline(b)
+			anomalies, insufficient = baseline.SplitInsufficient(mining.Detect(learner, *against, clusters, mining.Category))
+			anomalies, _ = b.FilterOverrides(anomalies, time.Now())
+			anomalies, _ = b.FilterColdStart(anomalies)
+			b.ApplyMetadata(anomalies)
+			anomalies, _ = cliConfig.Suppress.Filter(anomalies)
+			if index, err := store.Index(); err != nil {
+				slog.Warn("not scoring against the fleet index", "error", err)
+			} else {
+				for i := range anomalies {
+					if anomalies[i].Type == mining.NewTemplate {
+						index.Adjust(&anomalies[i], *against, mining.Category+":"+anomalies[i].Evidence)
+					}
+				}
+			}
+			if enricher := loadEnricher(); enricher != nil {
+				enricher.Annotate(anomalies)
+			}
+			cliConfig.Remediation.Apply(anomalies)
+			if err := store.AppendAnomalies(*against, anomalies); err != nil {
+				slog.Warn("could not record history", "error", err)
+			}
+		}
+
+		added := 0
+		if *learnName != "" {
+			b, err := store.LoadBaseline(*learnName)
+			if errors.Is(err, storage.ErrNotFound) {
+				b, err = baseline.NewLearner().CreateBaseline(*learnName), nil
+			}
+			if err != nil {
+				fail(err)
+			}
+			added = mining.Learn(b, clusters, mining.Category, *minCount)
+			b.SetMetadata(metadata)
+			if err := store.SaveBaseline(b); err != nil {
+				fail(err)
+			}
+		}
+
+		if *jsonOutput {
+			type template struct {
+				ID       int    `json:"id"`
+				Count    int    `json:"count"`
+				Template string `json:"template"`
+				Sample   string `json:"sample"`
+			}
+			result := struct {
+				Lines        int                `json:"lines"`
+				Templates    []template         `json:"templates"`
+				Anomalies    []baseline.Anomaly `json:"anomalies,omitempty"`
+				Insufficient []baseline.Anomaly `json:"insufficient_data,omitempty"`
+			}{Lines: lines, Templates: []template{}, Anomalies: anomalies, Insufficient: insufficient}
+			for _, c := range clusters {
+				result.Templates = append(result.Templates, template{c.ID, c.Count, c.String(), c.Sample})
+			}
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(result); err != nil {
+				fail(err)
+			}
+		} else {
+			fmt.Fprintf(out, "Analyzing log file: %s\n\n", path)
+			fmt.Fprintf(out, "Discovered %d templates in %d lines:\n\n", len(clusters), lines)
+			for i, c := range clusters {
+				if *top > 0 && i == *top {
+					fmt.Fprintf(out, "  ... %d more (use --top 0 to show all)\n", len(clusters)-i)
+					break
+				}
+				fmt.Fprintf(out, "  %8d  %s\n", c.Count, c)
+			}
+			if *learnName != "" {
+				fmt.Fprintf(out, "\nAdded %d patterns to baseline %s\n", added, *learnName)
+			}
+			if *against != "" {
+				fmt.Fprintf(out, "\nFound %d anomalies against baseline %s\n", len(anomalies), *against)
+				printColdStart(out, againstBaseline)
+				for i, anomaly := range anomalies {
+					printAnomalyHeader(out, i, anomaly)
+					fmt.Fprintf(out, "    Evidence: %s\n", anomaly.Evidence)
+					printEnrichment(out, anomaly.Enrichment)
+					printRemediation(out, anomaly.Remediation)
+				}
+				printInsufficient(out, insufficient)
+			}
+		}
+
+		severities := make([]string, len(anomalies))
+		for i, anomaly := range anomalies {
+			severities[i] = anomaly.Severity
+		}
+		g.exit(severities...)
+	}
+}
+
+// analyzeInputs expands the file arguments of analyze into the logs to
+// read, each the files to read in order: globs are expanded, and with
+// rotated, each log is preceded by its rotated predecessors. Files that are
+// the predecessors of another log given are read as part of it, so
+// 'app.log*' reads each file once.
+func analyzeInputs(args []string, rotated bool) ([][]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		matches := []string{arg}
+		if !logfile.IsStream(arg) && strings.ContainsAny(arg, "*?[") {
+			var err error
+			if matches, err = filepath.Glob(arg); err != nil {
+				return nil, fmt.Errorf("%s: %w", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %s", arg)
+			}
+			sort.Strings(matches)
+		}
+		for _, file := range matches {
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	inputs := make([][]string, len(files))
+	predecessor := make(map[string]bool)
+	for i, file := range files {
+		inputs[i] = []string{file}
+		if !rotated || logfile.IsStream(file) {
+			continue
+		}
+		paths, err := logfile.Rotated(file)
+		if err != nil {
+			return nil, err
+		}
+		inputs[i] = paths
+		for _, p := range paths[:len(paths)-1] {
+			predecessor[p] = true
+		}
+	}
+	logs := inputs[:0]
+	for i, paths := range inputs {
+		if !predecessor[files[i]] {
+			logs = append(logs, paths)
+		}
+	}
+	return logs, nil
+}
+
+// inputNames names the logs analyze reads, for its output.
+func inputNames(inputs [][]string) string {
+	names := make([]string, len(inputs))
+	for i, paths := range inputs {
+		names[i] = paths[len(paths)-1]
+	}
+	return strings.Join(names, ", ")
+}
+
+// scanInput calls fn with every line of paths in order and, with follow,
+// keeps reading the last as it grows until interrupted. line is only valid
+// until fn returns.
+func scanInput(paths []string, follow bool, fn func(line []byte)) {
+	live := paths[len(paths)-1]
+	if follow {
+		paths = paths[:len(paths)-1]
+	}
+	err := logfile.ScanBytes(paths, false, func(line []byte) error {
+		fn(line)
+		return nil
+	})
+	if err != nil {
+		fail(err)
+	}
+	if follow {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		follower := &logfile.Follower{Path: live, FromStart: true}
+		err := follower.Run(ctx, func(line string) { fn([]byte(line)) })
+		stop()
+		if err != nil {
+			fail(err)
+		}
+	}
+}
+
+// eventAnalysis holds the options of analyze for structured input.
+type eventAnalysis struct {
+	format        string           // pipeline parser of the lines
+	parserOptions pipeline.Options // options of the parser
+	dedup         time.Duration    // window dropping duplicates across inputs, 0 for none
+	follow        bool
+	scope         filter.Filter
+	learn         string // baseline to learn the events into
+	metadata      map[string]string
+	against       string // baseline to detect the events against
+	interval      time.Duration
+	lateness      time.Duration
+	top           int
+	jsonOutput    bool
+}
+
+// analyzeEvents is analyze for structured input: it parses each line as an
+// event with the pipeline parser of o, summarizes the operations seen, and
+// learns them into or detects them against a baseline.
+func analyzeEvents(out io.Writer, inputs [][]string, o eventAnalysis, g *gate) {
+	env := &pipeline.Env{Remediation: cliConfig.Remediation, Suppressions: cliConfig.Suppress}
+	if o.learn != "" || o.against != "" {
+		var err error
+		if env.Store, err = openStore(); err != nil {
+			fail(err)
+		}
+	}
+	parser, err := pipeline.Parsers.New(o.format, env, o.parserOptions)
+	if err != nil {
+		fail(err)
+	}
+	lines, events, skipped := 0, 0, 0
+	counts := make(map[string]int)
+	stages := []pipeline.Stage{
+		parser,
+		pipeline.Defaults(time.Now),
+	}
+	duplicates := &pipeline.Dedup{Window: o.dedup, Origin: pipeline.OriginSource}
+	if o.dedup > 0 {
+		stages = append(stages, duplicates)
+	}
+	stages = append(stages,
+		pipeline.StageFunc(func(_ context.Context, r *pipeline.Record) (bool, error) {
+			if !o.scope.Event(*r.Event) {
+				return false, nil
+			}
+			events++
+			if key := r.Event.Key(); key != "" {
+				counts[key] += r.Event.Weight()
+			}
+			return true, nil
+		}),
+	)
+	var againstBaseline *baseline.Baseline
+	if o.against != "" {
+		if againstBaseline, err = env.Store.LoadBaseline(o.against); err != nil {
+			fail(err)
+		}
+		if enricher := loadEnricher(); enricher != nil {
+			stages = append(stages, pipeline.Reputation(enricher))
+		}
+		stages = append(stages, pipeline.StaticRoute(o.against), pipeline.Detect(env.Store, time.Hour, slog.Default()),
+			pipeline.ColdStart(env.Store, time.Hour, slog.Default()), pipeline.Metadata(env.Store, time.Hour, slog.Default()), pipeline.Overrides(env.Store, time.Hour, time.Now, slog.Default()),
+			pipeline.Suppress(env.Suppressions, slog.Default()), pipeline.Remediate(env.Remediation))
+	}
+	if o.learn != "" {
+		stages = append(stages, pipeline.StaticRoute(o.learn), pipeline.Learn(env.Store, o.interval, o.lateness, o.metadata))
+	}
+	var anomalies []baseline.Anomaly
+	p := &pipeline.Pipeline{Name: "analyze", Stages: stages, Sinks: []pipeline.Sink{
+		pipeline.SinkFunc(func(_ context.Context, r *pipeline.Record) error {
+			anomalies = append(anomalies, r.Anomalies...)
+			if o.follow && !o.jsonOutput {
+				p := paint(out)
+				for _, a := range r.Anomalies {
+					fmt.Fprintf(out, "%s - %s: %s\n", p.Severity(a.Severity, a.Severity), a.Type, a.Evidence)
+				}
+			}
+			return nil
+		}),
+	}}
+
+	ctx := context.Background()
+	if len(inputs) == 1 {
+		paths := inputs[0]
+		// Parsers copy what they keep of a line and the sink keeps only
+		// the anomalies, so one record serves every line.
+		r := &pipeline.Record{}
+		scanInput(paths, o.follow, func(line []byte) {
+			if len(line) == 0 {
+				return
+			}
+			lines++
+			*r = pipeline.Record{Source: paths[len(paths)-1], Raw: line}
+			if err := p.Handle(ctx, r); err != nil {
+				skipped++
+			}
+			if r.Event != nil && len(r.Anomalies) == 0 {
+				detect.ReleaseEvent(r.Event)
+			}
+		})
+	} else {
+		// The events of several logs are parsed as they are read and
+		// merged into timestamp order; the parser stage passes them
+		// through.
+		merged := &pipeline.Merged{Inputs: inputs, Parser: func() (pipeline.Stage, error) {
+			return pipeline.Parsers.New(o.format, env, o.parserOptions)
+		}}
+		records := make(chan *pipeline.Record)
+		errs := make(chan error, 1)
+		go func() {
+			errs <- merged.Run(ctx, records)
+			close(records)
+		}()
+		for r := range records {
+			if err := p.Handle(ctx, r); err != nil {
+				skipped++
+			}
+			if len(r.Anomalies) == 0 {
+				detect.ReleaseEvent(r.Event)
+			}
+		}
+		if err := <-errs; err != nil {
+			fail(err)
+		}
+		lines += merged.Lines()
+		skipped += merged.Skipped()
+	}
+	if err := p.Flush(ctx); err != nil {
+		fail(err)
+	}
+	if o.against != "" {
+		if err := env.Store.AppendAnomalies(o.against, anomalies); err != nil {
+			slog.Warn("could not record history", "error", err)
+		}
+	}
+
+	type operation struct {
+		Key   string `json:"key"`
+		Count int    `json:"count"`
+	}
+	operations := make([]operation, 0, len(counts))
+	for key, count := range counts {
+		operations = append(operations, operation{key, count})
+	}
+	sort.Slice(operations, func(i, j int) bool {
+		if operations[i].Count != operations[j].Count {
+			return operations[i].Count > operations[j].Count
+		}
+		return operations[i].Key < operations[j].Key
+	})
+
+	if o.jsonOutput {
+		result := struct {
+			Lines      int                `json:"lines"`
+			Events     int                `json:"events"`
+			Skipped    int                `json:"skipped,omitempty"`
+			Duplicates int                `json:"duplicates,omitempty"`
+			Operations []operation        `json:"operations"`
+			Anomalies  []baseline.Anomaly `json:"anomalies,omitempty"`
+		}{lines, events, skipped, duplicates.Dropped(), operations, anomalies}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			fail(err)
+		}
+	} else {
+		fmt.Fprintf(out, "Analyzing %s events: %s\n\n", o.format, inputNames(inputs))
+		fmt.Fprintf(out, "Read %d events in %d lines", events, lines)
+		if skipped > 0 {
+			fmt.Fprintf(out, " (%d could not be parsed)", skipped)
+		}
+		if n := duplicates.Dropped(); n > 0 {
+			fmt.Fprintf(out, " after dropping %d duplicates", n)
+		}
+		fmt.Fprintf(out, ", %d distinct operations:\n\n", len(operations))
+		for i, op := range operations {
+			if o.top > 0 && i == o.top {
+				fmt.Fprintf(out, "  ... %d more (use --top 0 to show all)\n", len(operations)-i)
+				break
+			}
+			fmt.Fprintf(out, "  %8d  %s\n", op.Count, op.Key)
+		}
+		if o.learn != "" {
+			fmt.Fprintf(out, "\nLearned %d events into baseline %s\n", events, o.learn)
+		}
+		if o.against != "" {
+			fmt.Fprintf(out, "\nFound %d anomalies against baseline %s\n", len(anomalies), o.against)
+			printColdStart(out, againstBaseline)
+			for i, anomaly := range anomalies {
+				printAnomalyHeader(out, i, anomaly)
+				fmt.Fprintf(out, "    Evidence: %s\n", anomaly.Evidence)
+				printTrace(out, anomaly)
+				printEnrichment(out, anomaly.Enrichment)
+				printRemediation(out, anomaly.Remediation)
+			}
+		}
+	}
+
+	severities := make([]string, len(anomalies))
+	for i, anomaly := range anomalies {
+		severities[i] = anomaly.Severity
+	}
+	g.exit(severities...)
+}
+
+// scopedBaseline returns b with only the statistics of the categories
+// scope selects, so check scores the events it keeps against what the
+// baseline expects of those categories alone. Time bounds need nothing:
+// events are scored per interval of the event time they span.
+func scopedBaseline(b *baseline.Baseline, scope filter.Filter) *baseline.Baseline {
+	if len(scope.Categories) == 0 {
+		return b
+	}
+	scoped := *b
+	scoped.Stats = make(map[string]baseline.Stat)
+	for key, stat := range b.Stats {
+		if category, _, _ := baseline.ParseStatKey(key); scope.Category(category) {
+			scoped.Stats[key] = stat
+		}
+	}
+	return &scoped
+}
+
+func checkBehavior(fs *flag.FlagSet) func(args []string) {
+	eventsPath := fs.String("events", "", "JSON lines file of events to score")
+	jsonOutput := fs.Bool("json", false, "print the score breakdown as JSON")
+	output := fs.String("output", "text", "text, or github for a step summary and workflow annotations in GitHub Actions")
+	window := fs.Duration("window", 0, "score a sliding window of this length over streamed events")
+	interval := fs.Duration("interval", 10*time.Second, "how often to score the sliding window")
+	record := fs.Bool("record", false, "store scores in the baseline's score series")
+	release := fs.String("release", "", "tag recorded scores with this release (requires --record)")
+	top := fs.Int("top", detect.DefaultContributions, "explain the operations pulling the score down the most, 0 for all")
+	g := addGateFlags(fs)
+	ff := addFilterFlags(fs)
+	return func(positional []string) {
+		g.validate()
+		scope := ff.filter()
+		if len(positional) < 1 {
+			fs.Usage()
+			fail(errors.New("baseline name required"))
+		}
+		name := positional[0]
+		out := g.output()
+		github := *output == "github"
+		switch {
+		case *output != "text" && !github:
+			fail(fmt.Errorf("unknown --output %q", *output))
+		case github && (*jsonOutput || *window > 0):
+			fail(errors.New("--output github cannot be combined with --json or --window"))
+		case *release != "" && !*record:
+			fail(errors.New("--release requires --record"))
+		}
+
+		store, err := openStore()
+		if err != nil {
+			fail(err)
+		}
+		b, err := store.LoadBaseline(name)
+		if err != nil {
+			fail(err)
+		}
+		recordScore := func(point storage.ScorePoint) {
+			if !*record {
+				return
+			}
+			point.Release = *release
+			if err := store.AppendScores(name, point); err != nil {
+				slog.Warn("could not record score", "error", err)
+			}
+		}
+
+		if *window > 0 {
+			if *eventsPath == "" {
+				*eventsPath = "-"
+			}
+			worst, err := checkRolling(out, b, *eventsPath, scope, *window, *interval, *jsonOutput, func(ws detect.WindowScore) {
+				recordScore(storage.ScorePoint{Time: ws.End, Interval: *interval, Score: ws.Score, Events: ws.Events})
+			})
+			if err != nil {
+				fail(err)
+			}
+			if b.InColdStart() {
+				g.exit()
+			}
+			g.exit(scoreSeverity(worst))
+		}
+
+		var events []detect.SystemEvent
+		if *eventsPath != "" {
+			if events, err = readEvents(*eventsPath, scope, store, name); err != nil {
+				fail(err)
+			}
+		}
+
+		scored := scopedBaseline(b, scope)
+		breakdown := detect.ScoreBreakdown(events, scored.CategoryTotals(), b.BucketWidthOrDefault())
+		breakdown.Contributions = detect.Contributions(events, scored, *top)
+		recordScore(storage.ScorePoint{Score: breakdown.Score, Events: detect.TotalWeight(events)})
+		unseen, _ := b.FilterOverrides(unseenAnomalies(store, b, events), time.Now())
+		unseen, _ = b.FilterColdStart(unseen)
+		b.ApplyMetadata(unseen)
+		unseen, _ = cliConfig.Suppress.Filter(unseen)
+		cliConfig.Remediation.Apply(unseen)
+		volumeAnomalies, _ := b.FilterOverrides(intervalVolumeAnomalies(b, events), time.Now())
+		volumeAnomalies, _ = b.FilterColdStart(volumeAnomalies)
+		b.ApplyMetadata(volumeAnomalies)
+		volumeAnomalies, _ = cliConfig.Suppress.Filter(volumeAnomalies)
+		cliConfig.Remediation.Apply(volumeAnomalies)
+		var graphAnomalies []baseline.Anomaly
+		for _, edge := range detect.Edges(events) {
+			if a, isNew := b.EdgeAnomaly(edge); isNew {
+				graphAnomalies = append(graphAnomalies, a)
+			}
+		}
+		graphAnomalies, _ = b.FilterOverrides(graphAnomalies, time.Now())
+		graphAnomalies, _ = b.FilterColdStart(graphAnomalies)
+		b.ApplyMetadata(graphAnomalies)
+		graphAnomalies, _ = cliConfig.Suppress.Filter(graphAnomalies)
+		cliConfig.Remediation.Apply(graphAnomalies)
+		found := append(append(append([]baseline.Anomaly(nil), unseen...), volumeAnomalies...), graphAnomalies...)
+		if err := store.AppendAnomalies(name, found); err != nil {
+			slog.Warn("could not record history", "error", err)
+		}
+		// The score compares rates too, so in cold start it is reported
+		// but does not fail the check.
+		var severities []string
+		if !b.InColdStart() {
+			severities = append(severities, scoreSeverity(breakdown.Score))
+		}
+		for _, anomaly := range found {
+			severities = append(severities, anomaly.Severity)
+		}
+		if *jsonOutput {
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			err := enc.Encode(struct {
+				detect.Breakdown
+				ColdStart       string             `json:"cold_start,omitempty"`
+				UnseenAnomalies []baseline.Anomaly `json:"unseen_anomalies,omitempty"`
+				VolumeAnomalies []baseline.Anomaly `json:"volume_anomalies,omitempty"`
+				GraphAnomalies  []baseline.Anomaly `json:"graph_anomalies,omitempty"`
+			}{breakdown, b.ColdStartStatus(), unseen, volumeAnomalies, graphAnomalies})
+			if err != nil {
+				fail(err)
+			}
+			g.exit(severities...)
+		}
+
+		if github {
+			fmt.Fprintf(out, "::group::Behavior check against %s\n", name)
+		}
+		fmt.Fprintf(out, "Checking behavior against baseline: %s\n", name)
+		printColdStart(out, b)
+		fmt.Fprintln(out)
+
+		p := paint(out)
+		t := term.NewTable(out)
+		t.Indent, t.Right = "  ", []int{1}
+		for _, cs := range breakdown.Categories {
+			t.Row(cs.Category, paintScore(p, cs.Score, fmt.Sprintf("%.0f%%", cs.Score)),
+				fmt.Sprintf("observed %d, expected %d (weight %.2f)", cs.Observed, cs.Expected, cs.Weight))
+		}
+		t.Flush()
+		if len(breakdown.TopContributors) > 0 {
+			fmt.Fprintln(out, "\nTop contributors:")
+			for _, cs := range breakdown.TopContributors {
+				t.Row(cs.Category, fmt.Sprintf("-%.1f points", cs.Contribution))
+			}
+			t.Flush()
+		}
+		if len(breakdown.Contributions) > 0 {
+			fmt.Fprintln(out, "\nWhy the score dropped:")
+			t := term.NewTable(out)
+			t.Indent, t.Right = "  ", []int{0}
+			for _, c := range breakdown.Contributions {
+				t.Row(fmt.Sprintf("-%.1f points", c.Points), c.Explanation)
+			}
+			t.Flush()
+		}
+		if len(unseen) > 0 {
+			fmt.Fprintln(out, "\nUnseen behavior:")
+			for _, anomaly := range unseen {
+				fmt.Fprintf(out, "  %s %s: %s\n", p.Severity(anomaly.Severity, fmt.Sprintf("%-8s", anomaly.Severity)), anomaly.Evidence, anomaly.Description)
+				printRemediation(out, anomaly.Remediation)
+			}
+		}
+		if len(volumeAnomalies) > 0 {
+			fmt.Fprintln(out, "\nData volume:")
+			for _, anomaly := range volumeAnomalies {
+				fmt.Fprintf(out, "  %s %s: %s\n", p.Severity(anomaly.Severity, fmt.Sprintf("%-8s", anomaly.Severity)), anomaly.Evidence, anomaly.Description)
+				printRemediation(out, anomaly.Remediation)
+			}
+		}
+		if len(graphAnomalies) > 0 {
+			fmt.Fprintln(out, "\nBehavior graph:")
+			for _, anomaly := range graphAnomalies {
+				fmt.Fprintf(out, "  %s %s\n", p.Severity(anomaly.Severity, fmt.Sprintf("%-8s", anomaly.Severity)), anomaly.Description)
+				printRemediation(out, anomaly.Remediation)
+			}
+		}
+
+		score := breakdown.Score
+		fmt.Fprintf(out, "\nBehavior Score: %s\n", paintScore(p, score, fmt.Sprintf("%.0f%%", score)))
+
+		if score >= 90 {
+			fmt.Fprintln(out, "Status: "+p.Good("Excellent")+" - No anomalies detected")
+		} else if score >= 70 {
+			fmt.Fprintln(out, "Status: "+p.Good("Good")+" - Minor deviations")
+		} else if score >= 50 {
+			fmt.Fprintln(out, "Status: "+p.Warn("Fair")+" - Investigate further")
+		} else {
+			fmt.Fprintln(out, "Status: "+p.Bad("Poor")+" - Immediate action required")
+		}
+		if github {
+			fmt.Fprintln(out, "::endgroup::")
+			if err := writeGitHubCheck(out, report.Check{Baseline: name, Breakdown: breakdown, Groups: []report.Group{
+				{Title: "Unseen behavior", Anomalies: unseen},
+				{Title: "Data volume", Anomalies: volumeAnomalies},
+				{Title: "Behavior graph", Anomalies: graphAnomalies},
+			}}); err != nil {
+				slog.Warn("could not write the step summary", "error", err)
+			}
+		}
+		g.exit(severities...)
+	}
+}
+
+// writeGitHubCheck writes the annotations of a check to out and appends its
+// step summary to $GITHUB_STEP_SUMMARY, or writes it to out when not
+// running in GitHub Actions.
+func writeGitHubCheck(out io.Writer, c report.Check) error {
+	if err := report.WriteGitHubAnnotations(out, c); err != nil {
+		return err
+	}
+	path := os.Getenv("GITHUB_STEP_SUMMARY")
+	if path == "" {
+		return report.WriteGitHubSummary(out, c)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := report.WriteGitHubSummary(f, c); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// checkRolling scores a sliding window over events streamed from path ("-"
+// for stdin) every interval until the input ends, and returns the worst
+// score seen. Windows follow event timestamps; while the stream is quiet,
+// time advances with the wall clock from the last event. onScore is called
+// with every window scored.
+func checkRolling(out io.Writer, b *baseline.Baseline, path string, scope filter.Filter, window, interval time.Duration, jsonOutput bool, onScore func(detect.WindowScore)) (float64, error) {
+	in := os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		in = f
+	}
+	events, errs := streamEvents(in, path, scope)
+
+	scorer := detect.NewRollingScorer(window, interval, scopedBaseline(b, scope).CategoryTotals(), b.BucketWidthOrDefault())
+	worst := 100.0
+	emit := func(scores ...detect.WindowScore) error {
+		for _, ws := range scores {
+			onScore(ws)
+			worst = math.Min(worst, ws.Score)
+			if jsonOutput {
+				if err := json.NewEncoder(out).Encode(ws); err != nil {
+					return err
+				}
+				continue
+			}
+			fmt.Fprintf(out, "%s  score %3.0f%%  %d events in last %s\n",
+				ws.End.Format(time.RFC3339), ws.Score, ws.Events, window)
+		}
+		return nil
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var lastEvent, lastArrival time.Time
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				select {
+				case err := <-errs:
+					return worst, err
+				default:
+				}
+				if !lastEvent.IsZero() {
+					return worst, emit(scorer.Flush())
+				}
+				return worst, nil
+			}
+			if event.Timestamp.IsZero() {
+				event.Timestamp = time.Now()
+			}
+			lastEvent, lastArrival = event.Timestamp, time.Now()
+			if err := emit(scorer.Add(event)...); err != nil {
+				return worst, err
+			}
+		case <-ticker.C:
+			if lastEvent.IsZero() {
+				continue
+			}
+			if err := emit(scorer.Tick(lastEvent.Add(time.Since(lastArrival)))...); err != nil {
+				return worst, err
+			}
+		}
+	}
+}
+
+// streamEvents decodes the JSON lines events of in as they arrive, sending
+// those in scope until the end of the input or the first error, which is
+// sent on the second channel.
+func streamEvents(in io.Reader, path string, scope filter.Filter) (<-chan detect.SystemEvent, <-chan error) {
+	events := make(chan detect.SystemEvent)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(events)
+		dec := json.NewDecoder(in)
+		for {
+			var event detect.SystemEvent
+			if err := dec.Decode(&event); err == io.EOF {
+				return
+			} else if err != nil {
+				errs <- fmt.Errorf("%s: %w", path, err)
+				return
+			}
+			if !scope.Event(event) {
+				continue
+			}
+			events <- event
+		}
+	}()
+	return events, errs
+}
+
+// scoreSeverity maps a behavior score to the severity it is reported with:
+// none when behavior is normal (90% or more), LOW for minor deviations,
+// MEDIUM below 70% and HIGH when immediate action is required (below 50%).
+// unseenAnomalies reports the operations of events b has never observed,
+// as the pipeline detect processor does, scored lower when other baselines
+// of the store have observed them.
+func unseenAnomalies(store *storage.Store, b *baseline.Baseline, events []detect.SystemEvent) []baseline.Anomaly {
+	anomalies := enforce.Unseen(b, events)
+	if len(anomalies) == 0 {
+		return nil
+	}
+	index, err := store.Index()
+	if err != nil {
+		slog.Warn("not scoring against the fleet index", "error", err)
+	}
+	for i := range anomalies {
+		index.Adjust(&anomalies[i], b.Name, anomalies[i].Evidence)
+	}
+	return anomalies
+}
+
+// intervalVolumeAnomalies compares the volumes of events with b's per
+// interval of its bucket width, the unit they were learned in, and
+// reports each key once, for the interval most above its usual volume.
+func intervalVolumeAnomalies(b *baseline.Baseline, events []detect.SystemEvent) []baseline.Anomaly {
+	worst := make(map[string]int)
+	var anomalies []baseline.Anomaly
+	for _, volumes := range detect.IntervalVolumes(events, b.BucketWidthOrDefault()) {
+		for _, a := range b.VolumeAnomalies(volumes) {
+			i, seen := worst[a.Evidence]
+			switch {
+			case !seen:
+				worst[a.Evidence] = len(anomalies)
+				anomalies = append(anomalies, a)
+			case a.Confidence > anomalies[i].Confidence || severity.Of(a.Severity) > severity.Of(anomalies[i].Severity):
+				anomalies[i] = a
+			}
+		}
+	}
+	return anomalies
+}
+
+func scoreSeverity(score float64) string {
+	switch {
+	case score >= 90:
+		return ""
+	case score >= 70:
+		return severity.Label(severity.Low)
+	case score >= 50:
+		return severity.Label(severity.Medium)
+	default:
+		return severity.Label(severity.High)
+	}
+}
+
+// Exit codes of detect, check and analyze, so pipelines can gate on them.
+const (
+	exitClean = 0 // nothing at or above --fail-on
+	exitLow   = 1 // findings below HIGH severity
+	exitHigh  = 2 // findings of HIGH severity or above
+	exitError = 3 // the command could not run
+)
+
+// gate holds the flags shared by commands whose exit code reports what
+// they found.
+type gate struct {
+	quiet  bool
+	failOn string
+}
+
+func addGateFlags(fs *flag.FlagSet) *gate {
+	g := &gate{}
+	fs.BoolVar(&g.quiet, "quiet", false, "print nothing and report only through the exit code")
+	fs.StringVar(&g.failOn, "fail-on", severity.Label(severity.Low), "lowest severity that makes the command fail")
+	return g
+}
+
+// validate exits with exitError when --fail-on is not a known severity.
+func (g *gate) validate() {
+	if !severity.Known(g.failOn) {
+		fail(fmt.Errorf("unknown --fail-on severity %q", g.failOn))
+	}
+}
+
+// output returns where the command writes its report.
+func (g *gate) output() io.Writer {
+	if g.quiet {
+		return io.Discard
+	}
+	return os.Stdout
+}
+
+// exit ends the process with the exit code for the most severe of
+// severities, ignoring those below --fail-on. Empty severities are clean.
+func (g *gate) exit(severities ...string) {
+	code := exitClean
+	for _, s := range severities {
+		if s == "" || !severity.AtLeast(s, g.failOn) {
+			continue
+		}
+		if severity.AtLeast(s, severity.Label(severity.High)) {
+			os.Exit(exitHigh)
+		}
+		code = exitLow
+	}
+	os.Exit(code)
+}
+
+// addLabelFlag adds the --label flag of commands that learn baselines.
+func addLabelFlag(fs *flag.FlagSet) *string {
+	return fs.String("label", "", "metadata to set on the learned baseline, as comma-separated key=value pairs such as owner=payments,env=prod")
+}
+
+// parseLabels parses a --label flag, exiting with exitError when it is
+// malformed.
+func parseLabels(s string) map[string]string {
+	metadata, err := baseline.ParseMetadata(s)
+	if err != nil {
+		fail(fmt.Errorf("--label: %w", err))
+	}
+	return metadata
+}
+
+// filterFlags holds the flags that scope analysis to a time window and
+// workload.
+type filterFlags struct {
+	since, until, pids, processes, categories string
+}
+
+func addFilterFlags(fs *flag.FlagSet) *filterFlags {
+	f := &filterFlags{}
+	fs.StringVar(&f.since, "since", "", "only from this time: RFC 3339, a date, or a duration ago such as 2h")
+	fs.StringVar(&f.until, "until", "", "only before this time, in the same forms as --since")
+	fs.StringVar(&f.pids, "pid", "", "only these comma-separated process IDs")
+	fs.StringVar(&f.processes, "process", "", "only these comma-separated process names or globs such as nginx*")
+	fs.StringVar(&f.categories, "category", "", "only these comma-separated categories, e.g. network,file")
+	return f
+}
+
+// filter returns the parsed filter, exiting with exitError when a flag is
+// malformed.
+func (f *filterFlags) filter() filter.Filter {
+	parsed, err := filter.Parse(f.since, f.until, f.pids, f.processes, f.categories, time.Now())
+	if err != nil {
+		fail(err)
+	}
+	return parsed
+}
+
+// fail reports err and exits with exitError.
+func fail(err error) {
+	slog.Error("command failed", "error", err)
+	os.Exit(exitError)
+}
+
+// readEvents reads system events encoded as JSON lines from path ("-" for
+// stdin), keeping those that match scope. Captures of the named baseline
+// the store encrypted are decrypted.
+func readEvents(path string, scope filter.Filter, store *storage.Store, name string) ([]detect.SystemEvent, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if data, err = store.OpenCapture(name, data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var events []detect.SystemEvent
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var event detect.SystemEvent
+		if err := dec.Decode(&event); err == io.EOF {
+			return events, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if scope.Event(event) {
+			events = append(events, event)
+		}
+	}
+}
+
+func showTimeline(fs *flag.FlagSet) func(args []string) {
+	window := fs.Duration("window", time.Hour, "how far back to show")
+	return func(positional []string) {
+		if len(positional) < 1 {
+			slog.Error("baseline name required")
+			fs.Usage()
+			os.Exit(exitError)
+		}
+		name := positional[0]
+
+		store, err := openStore()
+		if err != nil {
+			fail(err)
+		}
+		now := time.Now()
+		records, err := store.History(name, now.Add(-*window))
+		if err != nil {
+			fail(err)
+		}
+
+		fmt.Printf("Timeline for %s (last %s)\n\n", name, *window)
+		timeline.Render(os.Stdout, timeline.Build(records, *window, now))
+	}
+}
+
+// showTrend prints the stat history of a baseline's keys: per-interval
+// counts, coarser the older they are.
+func showTrend(fs *flag.FlagSet) func(args []string) {
+	since := fs.String("since", "7d", "how far back to show, e.g. 12h or 90d")
+	jsonOutput := fs.Bool("json", false, "print the points as JSON")
+	return func(positional []string) {
+		if len(positional) < 1 {
+			fs.Usage()
+			os.Exit(exitError)
+		}
+		name, prefix := positional[0], ""
+		if len(positional) > 1 {
+			prefix = positional[1]
+		}
+		sinceDuration, err := config.ParseDuration(*since)
+		if err != nil {
+			fail(err)
+		}
+		store, err := openStore()
+		if err != nil {
+			fail(err)
+		}
+		if _, err := store.LoadBaseline(name); err != nil {
+			fail(err)
+		}
+		points, err := store.StatSeries(name, prefix, time.Now().Add(-sinceDuration))
+		if err != nil {
+			fail(err)
+		}
+		if *jsonOutput {
+			if points == nil {
+				points = []storage.StatPoint{}
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(points); err != nil {
+				fail(err)
+			}
+			return
+		}
+		if len(points) == 0 {
+			fmt.Printf("No stat history for %s in the last %s. It is recorded as pipelines learn.\n", name, *since)
+			return
+		}
+		p := paint(os.Stdout)
+		t := term.NewTable(os.Stdout)
+		t.Right = []int{3, 4, 5, 6}
+		t.Row(p.Bold("START"), p.Bold("WIDTH"), p.Bold("KEY"), p.Bold("SAMPLES"), p.Bold("MEAN"), p.Bold("MIN"), p.Bold("MAX"))
+		for _, point := range points {
+			t.Row(point.Start.Local().Format("2006-01-02 15:04"), point.Width.String(), point.Key, fmt.Sprint(point.Samples),
+				fmt.Sprintf("%.4g", point.Mean()), fmt.Sprintf("%.4g", point.Min), fmt.Sprintf("%.4g", point.Max))
+		}
+		t.Flush()
+	}
+}
+
+func showScores(fs *flag.FlagSet) func(args []string) {
+	since := fs.String("since", "7d", "how far back to show, e.g. 12h or 30d")
+	bucket := fs.String("bucket", "", "average scores over buckets of this width, e.g. 1d")
+	jsonOutput := fs.Bool("json", false, "print the series as JSON")
+	return func(positional []string) {
+		if len(positional) < 1 {
+			slog.Error("baseline name required")
+			fs.Usage()
+			os.Exit(exitError)
+		}
+		name := positional[0]
+
+		sinceDuration, err := config.ParseDuration(*since)
+		if err != nil {
+			fail(fmt.Errorf("--since: %w", err))
+		}
+		var width time.Duration
+		if *bucket != "" {
+			if width, err = config.ParseDuration(*bucket); err != nil {
+				fail(fmt.Errorf("--bucket: %w", err))
+			}
+		}
+
+		store, err := openStore()
+		if err != nil {
+			fail(err)
+		}
+		if _, err := store.LoadBaseline(name); err != nil {
+			fail(err)
+		}
+		points, err := store.Scores(name, time.Now().Add(-sinceDuration))
+		if err != nil {
+			fail(err)
+		}
+		points = storage.Bucket(points, width)
+
+		if *jsonOutput {
+			if points == nil {
+				points = []storage.ScorePoint{}
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(points); err != nil {
+				fail(err)
+			}
+			return
+		}
+
+		fmt.Printf("Behavior score for %s (last %s)\n\n", name, *since)
+		if len(points) == 0 {
+			fmt.Println("No scores recorded. Scores are recorded by `runtimebase check --record`.")
+			return
+		}
+		type releaseStats struct {
+			sum   float64
+			count int
+		}
+		var releases []string
+		byRelease := make(map[string]*releaseStats)
+		for _, p := range points {
+			bar := strings.Repeat("#", int(math.Round(p.Score/5)))
+			fmt.Printf("  %s  %3.0f%%  %-20s %8d events  %s\n", p.Time.Local().Format("2006-01-02 15:04"), p.Score, bar, p.Events, p.Release)
+			if p.Release == "" {
+				continue
+			}
+			st, ok := byRelease[p.Release]
+			if !ok {
+				st = &releaseStats{}
+				byRelease[p.Release] = st
+				releases = append(releases, p.Release)
+			}
+			st.sum += p.Score
+			st.count++
+		}
+		if len(releases) > 0 {
+			fmt.Println("\nBy release:")
+			for _, r := range releases {
+				st := byRelease[r]
+				fmt.Printf("  %-20s %3.0f%% average over %d points\n", r, st.sum/float64(st.count), st.count)
+			}
+		}
+	}
+}
+
+func writeReport(fs *flag.FlagSet) func(args []string) {
+	since := fs.Duration("since", 24*time.Hour, "how far back to report")
+	format := fs.String("format", "json", "output format when not bundling: json, html, stix (a STIX 2.1 bundle) or misp (a MISP event)")
+	bundlePath := fs.String("bundle", "", "write a .tar.gz bundle with findings, HTML report, baseline and evidence")
+	return func(positional []string) {
+		if len(positional) < 1 {
+			slog.Error("baseline name required")
+			fs.Usage()
+			os.Exit(exitError)
+		}
+		name := positional[0]
+
+		store, err := openStore()
+		if err != nil {
+			fail(err)
+		}
+		b, err := store.LoadBaseline(name)
+		if err != nil {
+			fail(err)
+		}
+		now := time.Now()
+		records, err := store.History(name, now.Add(-*since))
+		if err != nil {
+			fail(err)
+		}
+		r := report.Build(name, records, now.Add(-*since), now)
+
+		if *bundlePath != "" {
+			f, err := os.OpenFile(*bundlePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+			if err != nil {
+				fail(err)
+			}
+			if err := r.WriteBundle(f, b); err != nil {
+				f.Close()
+				fail(err)
+			}
+			if err := f.Close(); err != nil {
+				fail(err)
+			}
+			fmt.Printf("Wrote %s: %d anomalies in %d incidents, %d evidence events\n", *bundlePath, len(r.Anomalies), len(r.Incidents), len(r.Events))
+			return
+		}
+
+		switch *format {
+		case "json":
+			err = r.WriteJSON(os.Stdout)
+		case "html":
+			err = r.WriteHTML(os.Stdout)
+		case "stix", "misp":
+			var data []byte
+			if *format == "stix" {
+				data, err = export.WriteSTIX(r.Anomalies)
+			} else {
+				data, err = export.WriteMISP(fmt.Sprintf("runtimebase anomalies of %s since %s", name, now.Add(-*since).Format(time.RFC3339)), r.Anomalies)
+			}
+			if err == nil {
+				_, err = fmt.Printf("%s\n", data)
+			}
+		default:
+			err = fmt.Errorf("unknown format %q", *format)
+		}
+		if err != nil {
+			fail(err)
+		}
+	}
+}
+
+func exportStats(fs *flag.FlagSet) func(args []string) {
+	format := fs.String("format", "csv", "output format: csv or parquet for statistics, dot or graphml for behavior graphs, rego for an OPA bundle, selinux for policy hints")
+	output := fs.String("output", "-", "file to write, or - for stdout")
+	domain := fs.String("domain", "", "SELinux domain type of the workload (default <name>_t)")
+	dataset := fs.String("dataset", "parquet", "dataset format of notebook exports: parquet or json")
+	return func(names []string) {
+		if len(names) > 0 && (names[0] == "selinux" || names[0] == "notebook") {
+			*format, names = names[0], names[1:]
+		}
+		switch *format {
+		case "csv", "parquet", "dot", "graphml", "rego":
+		case "selinux":
+			if len(names) != 1 {
+				fs.Usage()
+				fail(errors.New("SELinux hints are exported for one baseline name"))
+			}
+		case "notebook":
+			if len(names) != 1 {
+				fs.Usage()
+				fail(errors.New("notebooks are exported for one baseline name"))
+			}
+			exportNotebook(names[0], *output, *dataset)
+			return
+		default:
+			fail(fmt.Errorf("unknown format %q", *format))
+		}
+
+		store, err := openStore()
+		if err != nil {
+			fail(err)
+		}
+		if len(names) == 0 {
+			if names, err = store.ListBaselines(); err != nil {
+				fail(err)
+			}
+		}
+		var baselines []*baseline.Baseline
+		for _, name := range names {
+			b, err := store.LoadBaseline(name)
+			if err != nil {
+				fail(err)
+			}
+			baselines = append(baselines, b)
+		}
+		rows := export.StatRows(baselines...)
+
+		out := io.Writer(os.Stdout)
+		var f *os.File
+		if *output != "-" {
+			if f, err = os.Create(*output); err != nil {
+				fail(err)
+			}
+			out = f
+		}
+		written := fmt.Sprintf("%d statistics", len(rows))
+		switch *format {
+		case "csv":
+			err = export.WriteStatsCSV(out, rows)
+		case "parquet":
+			err = export.WriteStatsParquet(out, rows)
+		case "rego":
+			behaviors := 0
+			for _, b := range baselines {
+				behaviors += len(export.RegoData(b).Behaviors)
+			}
+			written = fmt.Sprintf("%d behaviors", behaviors)
+			err = export.WriteRegoBundle(out, baselines...)
+		case "selinux":
+			if *domain == "" {
+				*domain = export.SELinuxDomain(names[0])
+			}
+			written = fmt.Sprintf("%d SELinux allow rules", len(export.SELinuxRules(baselines[0])))
+			err = export.WriteSELinuxHints(out, baselines[0], *domain)
+		case "dot", "graphml":
+			edges := 0
+			for _, b := range baselines {
+				edges += len(b.Graph)
+			}
+			written = fmt.Sprintf("%d graph edges", edges)
+			if *format == "dot" {
+				err = export.WriteGraphDOT(out, baselines...)
+			} else {
+				err = export.WriteGraphML(out, baselines...)
+			}
+		}
+		if err != nil {
+			fail(err)
+		}
+		if f != nil {
+			if err := f.Close(); err != nil {
+				fail(err)
+			}
+			fmt.Fprintf(os.Stderr, "Wrote %s of %d baselines to %s\n", written, len(baselines), *output)
+		}
+	}
+}
+
+// exportNotebook writes the datasets and starter notebook of a baseline
+// into dir, <name>-notebook when dir is -.
+func exportNotebook(name, dir, dataset string) {
+	if dir == "-" {
+		dir = name + "-notebook"
+	}
+	store, err := openStore()
+	if err != nil {
+		fail(err)
+	}
+	b, err := store.LoadBaseline(name)
+	if err != nil {
+		fail(err)
+	}
+	records, err := store.History(name, time.Time{})
+	if err != nil {
+		fail(err)
+	}
+	var anomalies []baseline.Anomaly
+	for _, r := range records {
+		if r.Anomaly != nil {
+			anomalies = append(anomalies, *r.Anomaly)
+		}
+	}
+	files, err := export.WriteNotebook(dir, b, anomalies, dataset, time.Now())
+	if err != nil {
+		fail(err)
+	}
+	fmt.Printf("Wrote %d statistics and %d anomalies of %s to %s: %s\n", len(export.StatRows(b)), len(anomalies), name, dir, strings.Join(files, ", "))
+	fmt.Printf("Open it with: jupyter lab %s\n", filepath.Join(dir, "explore.ipynb"))
+}
+
+func importThresholds(fs *flag.FlagSet) func(args []string) {
+	only := fs.String("baseline", "", "baseline of rows without a baseline column; limits the import to it otherwise")
+	apply := fs.Bool("apply", false, "save the changes instead of only showing them")
+	return func(positional []string) {
+		if len(positional) < 1 {
+			fs.Usage()
+			fail(errors.New("threshold CSV file required"))
+		}
+		f, err := os.Open(positional[0])
+		if err != nil {
+			fail(err)
+		}
+		overrides, err := export.ReadThresholdsCSV(f)
+		f.Close()
+		if err != nil {
+			fail(err)
+		}
+
+		byBaseline := make(map[string]map[string]float64)
+		directions := make(map[string]map[string]baseline.Direction)
+		for _, o := range overrides {
+			name := o.Baseline
+			switch {
+			case name == "" && *only == "":
+				fail(fmt.Errorf("line %d: no baseline column; pass --baseline", o.Line))
+			case name == "":
+				name = *only
+			case *only != "" && name != *only:
+				continue
+			}
+			if byBaseline[name] == nil {
+				byBaseline[name] = make(map[string]float64)
+				directions[name] = make(map[string]baseline.Direction)
+			}
+			byBaseline[name][o.Key] = o.Threshold
+			if o.Direction != "" {
+				directions[name][o.Key] = o.Direction
+			}
+		}
+		names := make([]string, 0, len(byBaseline))
+		for name := range byBaseline {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		store, err := openStore()
+		if err != nil {
+			fail(err)
+		}
+		// Every baseline is validated before any is saved.
+		type plan struct {
+			b          *baseline.Baseline
+			changes    []baseline.ThresholdChange
+			directions []baseline.DirectionChange
+		}
+		var plans []plan
+		var errs []error
+		for _, name := range names {
+			b, err := store.LoadBaseline(name)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			changes, err := b.PlanThresholds(byBaseline[name])
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			dirChanges, err := b.PlanDirections(directions[name])
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if len(changes) > 0 || len(dirChanges) > 0 {
+				plans = append(plans, plan{b, changes, dirChanges})
+			}
+		}
+		if err := errors.Join(errs...); err != nil {
+			fail(err)
+		}
+		if len(plans) == 0 {
+			fmt.Println("No threshold or direction changes")
+			return
+		}
+
+		t := term.NewTable(os.Stdout)
+		t.Indent, t.Right = "  ", []int{1, 3}
+		for _, p := range plans {
+			fmt.Printf("%s (default %.4g):\n", p.b.Name, p.b.AnomalyThreshold)
+			for _, c := range p.changes {
+				note := ""
+				if !c.Override {
+					note = "(default)"
+				}
+				t.Row(c.Key, fmt.Sprintf("%.4g", c.Old), "->", fmt.Sprintf("%.4g", c.New), note)
+			}
+			for _, c := range p.directions {
+				t.Row(c.Key, string(c.Old), "->", string(c.New), "(direction)")
+			}
+			t.Flush()
+		}
+		if !*apply {
+			fmt.Println("\nRun again with --apply to save these changes")
+			return
+		}
+		for _, p := range plans {
+			p.b.ApplyThresholds(p.changes)
+			p.b.ApplyDirections(p.directions)
+			if err := store.SaveBaseline(p.b); err != nil {
+				fail(err)
+			}
+		}
+		fmt.Printf("\nApplied threshold and direction changes to %d baselines\n", len(plans))
+	}
+}
+
+func runDaemon(fs *flag.FlagSet) func(args []string) {
+	configPath := fs.String("config", "", "path to YAML configuration file")
+	serviceName := fs.String("service", "", "run as the named Windows service, reporting to the Service Control Manager")
+	return func([]string) {
+		cfg := config.Default()
+		if *configPath != "" {
+			var err error
+			if cfg, err = config.Load(*configPath); err != nil {
+				fail(err)
+			}
+		}
+
+		setupLogging(cfg.Log)
+		d, err := daemon.New(cfg, slog.Default())
+		if err != nil {
+			fail(err)
+		}
+
+		if err := d.AuditConfig(cliActor()); err != nil {
+			slog.Warn("could not audit configuration", "error", err)
+		}
+
+		if *serviceName != "" {
+			if err := service.Run(*serviceName, d.Run); err != nil {
+				fail(err)
+			}
+			return
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := d.Run(ctx); err != nil {
+			fail(err)
+		}
+	}
+}
+
+func alertTest(fs *flag.FlagSet) func(args []string) {
+	configPath := fs.String("config", "", "path to the daemon's YAML configuration file (default: $RUNTIMEBASE_CONFIG)")
+	level := fs.String("severity", severity.Critical, "severity of the synthetic anomaly")
+	pipelines := fs.String("pipeline", "", "comma-separated pipelines to test (default: all)")
+	baselineName := fs.String("baseline", "", "baseline to assign the anomaly to, for pipelines without a route")
+	labels := fs.String("label", "", "comma-separated key=value labels of the anomaly's event, for label routes")
+	return func([]string) {
+		cfg := cliConfig
+		if *configPath != "" {
+			var err error
+			if cfg, err = config.Load(*configPath); err != nil {
+				fail(err)
+			}
+		}
+		d, err := daemon.New(cfg, slog.Default())
+		if err != nil {
+			fail(err)
+		}
+		if !severity.Known(*level) {
+			fail(fmt.Errorf("unknown severity %q", *level))
+		}
+		if len(cfg.Pipelines) == 0 {
+			fail(errors.New("no pipelines configured"))
+		}
+		var names []string
+		if *pipelines != "" {
+			names = strings.Split(*pipelines, ",")
+		}
+
+		eventLabels := make(map[string]string)
+		for _, pair := range strings.Split(*labels, ",") {
+			if pair == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				fail(fmt.Errorf("--label %q: want key=value", pair))
+			}
+			eventLabels[key] = value
+		}
+
+		results, err := d.AlertTest(context.Background(), names, *level, *baselineName, eventLabels)
+		if err != nil {
+			fail(err)
+		}
+		failed := 0
+		for _, r := range results {
+			switch {
+			case r.Err != nil:
+				failed++
+				fmt.Printf("FAIL  %s: %v\n", r.Pipeline, r.Err)
+			case !r.Delivered:
+				failed++
+				fmt.Printf("FAIL  %s: dropped before the sinks; no route assigned a baseline (try --label or --baseline)\n", r.Pipeline)
+			case r.Sinks == 0:
+				failed++
+				fmt.Printf("FAIL  %s: no sinks configured\n", r.Pipeline)
+			default:
+				fmt.Printf("ok    %s: %s anomaly delivered to %d sinks\n", r.Pipeline, severity.Label(*level), r.Sinks)
+			}
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+	}
+}
+
+func bootstrapBaseline(fs *flag.FlagSet) func(args []string) {
+	name := fs.String("name", "", "baseline name (default: binary file name)")
+	return func(positional []string) {
+		if len(positional) < 1 {
+			slog.Error("binary path required")
+			fs.Usage()
+			os.Exit(exitError)
+		}
+		binary, err := filepath.Abs(positional[0])
+		if err != nil {
+			fail(err)
+		}
+		if *name == "" {
+			*name = filepath.Base(binary)
+		}
+
+		profile, err := bootstrap.Analyze(binary)
+		if err != nil {
+			fail(err)
+		}
+
+		store, err := openStore()
+		if err != nil {
+			fail(err)
+		}
+		b, err := store.LoadBaseline(*name)
+		if err != nil {
+			b = baseline.NewLearner().CreateBaseline(*name)
+		}
+		profile.Apply(b)
+		if err := store.SaveBaseline(b); err != nil {
+			fail(err)
+		}
+
+		fmt.Printf("Bootstrapped baseline %s from %s\n\n", *name, binary)
+		fmt.Printf("  Syscalls:  %d %s\n", len(profile.Syscalls), strings.Join(profile.Syscalls, ", "))
+		fmt.Printf("  Libraries: %d %s\n", len(profile.Libraries), strings.Join(profile.Libraries, ", "))
+		fmt.Printf("  Paths:     %d\n", len(profile.Paths))
+		fmt.Printf("  Hosts:     %d %s\n", len(profile.Hosts), strings.Join(profile.Hosts, ", "))
+		fmt.Println("\nSeeded entries are expected but carry no statistics until observed at runtime.")
+	}
+}
+
+func runOCIHook(fs *flag.FlagSet) func(args []string) {
+	stage := fs.String("stage", "createRuntime", "OCI hook stage this invocation runs at")
+	installDir := fs.String("install-dir", "", "write hooks.d configuration into this directory and exit")
+	list := fs.Bool("list", false, "list active container sessions and exit")
+	strict := fs.Bool("strict", false, "fail the hook (and the container) on errors")
+	return func([]string) {
+		sessions := &ocihook.Sessions{Dir: ocihook.DefaultDir()}
+
+		switch {
+		case *installDir != "":
+			binary, err := os.Executable()
+			if err != nil {
+				fail(err)
+			}
+			start, stop := ocihook.HookConfig(binary)
+			for file, data := range map[string][]byte{"runtimebase-start.json": start, "runtimebase-stop.json": stop} {
+				path := filepath.Join(*installDir, file)
+				if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+					fail(err)
+				}
+				fmt.Printf("Wrote %s\n", path)
+			}
+			return
+
+		case *list:
+			active, err := sessions.List()
+			if err != nil {
+				fail(err)
+			}
+			if len(active) == 0 {
+				fmt.Println("No active container sessions")
+			}
+			for _, session := range active {
+				fmt.Printf("%-16.16s pid %-7d baseline %-24s since %s\n", session.ContainerID, session.PID,
+					session.Baseline, session.StartedAt.Format("2006-01-02 15:04:05"))
+			}
+			return
+		}
+
+		// Hooks must not print to stdout, and by default must not stop the
+		// container from starting when monitoring cannot be set up.
+		if _, err := ocihook.Run(*stage, os.Stdin, sessions); err != nil {
+			slog.Error("oci-hook failed", "stage", *stage, "error", err)
+			if *strict {
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+func installService(fs *flag.FlagSet) func(args []string) {
+	spec := service.Spec{Name: service.DefaultName, Config: service.DefaultConfig, User: service.DefaultUser, Home: service.DefaultHome}
+	if runtime.GOOS == "windows" {
+		spec.Config, spec.User, spec.Home = "", "", filepath.Join(os.Getenv("ProgramData"), "runtimebase")
+	}
+	fs.StringVar(&spec.Name, "name", spec.Name, "service name")
+	fs.StringVar(&spec.Config, "config", spec.Config, "daemon configuration file, empty for the defaults")
+	fs.StringVar(&spec.User, "user", spec.User, "account to run as, root for root; systemd allocates it if it does not exist")
+	fs.StringVar(&spec.Home, "home", spec.Home, "data directory (RUNTIMEBASE_HOME), the only one the daemon may write")
+	fs.StringVar(&spec.Binary, "binary", "", "runtimebase executable to run (default this one)")
+	unitDir := fs.String("unit-dir", service.DefaultUnitDir, "directory systemd units are installed in")
+	printOnly := fs.Bool("print", false, "print the unit (or service commands) instead of installing it")
+	noStart := fs.Bool("no-start", false, "install and enable the service without starting it")
+	return func([]string) {
+		if spec.Binary == "" {
+			binary, err := os.Executable()
+			if err != nil {
+				fail(err)
+			}
+			spec.Binary = binary
+		}
+		if spec.User != "" && spec.User != "root" && runtime.GOOS != "windows" {
+			if _, err := user.Lookup(spec.User); err != nil {
+				spec.DynamicUser = true
+			}
+		}
+		if *printOnly {
+			render := service.SystemdUnit
+			if runtime.GOOS == "windows" {
+				render = service.WindowsScript
+			}
+			data, err := render(spec)
+			if err != nil {
+				fail(err)
+			}
+			os.Stdout.Write(data)
 			return
 		}
-		learnBaseline(os.Args[2])
-	case "detect":
-		if len(os.Args) < 3 {
-			fmt.Println("Error: baseline name required")
-			printUsage()
+
+		if spec.Config != "" {
+			if _, err := os.Stat(spec.Config); err != nil {
+				slog.Warn("the daemon will not start until its configuration exists", "config", spec.Config, "error", err)
+			}
+		}
+		installer := &service.Installer{OS: runtime.GOOS, UnitDir: *unitDir, Log: func(format string, args ...interface{}) {
+			fmt.Printf(format+"\n", args...)
+		}}
+		if err := installer.Install(spec, !*noStart); err != nil {
+			fail(err)
+		}
+		fmt.Printf("Installed service %s\n", spec.Name)
+	}
+}
+
+func uninstallService(fs *flag.FlagSet) func(args []string) {
+	name := fs.String("name", service.DefaultName, "service name")
+	unitDir := fs.String("unit-dir", service.DefaultUnitDir, "directory systemd units are installed in")
+	return func([]string) {
+		installer := &service.Installer{OS: runtime.GOOS, UnitDir: *unitDir, Log: func(format string, args ...interface{}) {
+			fmt.Printf(format+"\n", args...)
+		}}
+		if err := installer.Uninstall(*name); err != nil {
+			fail(err)
+		}
+		fmt.Printf("Uninstalled service %s; its data directory was kept\n", *name)
+	}
+}
+
+func runEnforce(fs *flag.FlagSet) func(args []string) {
+	categories := fs.String("categories", "", "comma-separated categories to kill processes for (network,process); empty reports only")
+	killSwitch := fs.String("kill-switch", "/run/runtimebase/enforce.disable", "enforcement is disabled while this file exists")
+	return func(positional []string) {
+		if len(positional) < 1 {
+			slog.Error("baseline name required")
+			fs.Usage()
+			os.Exit(exitError)
+		}
+		name := positional[0]
+
+		store, err := openStore()
+		if err != nil {
+			fail(err)
+		}
+		b, err := store.LoadBaseline(name)
+		if err != nil {
+			fail(err)
+		}
+
+		var enabled []string
+		if *categories != "" {
+			enabled = strings.Split(*categories, ",")
+			if err := enforce.CheckCategories(enabled, collect.LSMEventTypes); err != nil {
+				fail(err)
+			}
+			if q := b.Quality(); !q.Ready() {
+				slog.Warn("baseline may not be trustworthy enough to enforce; see runtimebase baselines show "+name,
+					"quality", math.Round(q.Score), "issues", strings.Join(q.Issues, "; "))
+			}
+		}
+		enforcer := enforce.New(b, enabled, *killSwitch, enforce.KillProcess)
+		fmt.Printf("Enforcing baseline %s by killing processes after operations outside it (categories: %s, kill-switch: %s)\n", name, orNone(enabled), *killSwitch)
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		tree := proctree.New()
+		stages := []pipeline.Stage{
+			pipeline.StageFunc(func(_ context.Context, r *pipeline.Record) (bool, error) {
+				tree.Observe(*r.Event)
+				return true, nil
+			}),
+		}
+		if enricher := loadEnricher(); enricher != nil {
+			stages = append(stages, pipeline.Reputation(enricher))
+		}
+		stages = append(stages, pipeline.StaticRoute(name), pipeline.StageFunc(func(_ context.Context, r *pipeline.Record) (bool, error) {
+			event := *r.Event
+			d, err := enforcer.Enforce(event)
+			if err != nil {
+				slog.Warn("enforcement failed", "error", err)
+			}
+			if d.Verdict == enforce.Allow {
+				return false, nil
+			}
+			fmt.Printf("[%s] %s pid=%d %s: %s\n", d.Verdict, event.ProcessName, event.PID, d.Key, d.Reason)
+			r.AddAnomalies(d.Anomaly(event))
+			tree.Annotate(r.Anomalies)
+			return true, nil
+		}), pipeline.Remediate(cliConfig.Remediation))
+
+		p := &pipeline.Pipeline{
+			Name:   "enforce",
+			Source: pipeline.FromCollector("lsm", &collect.LSMCollector{IgnoreComms: []string{"runtimebase"}}),
+			Stages: stages,
+			Sinks:  []pipeline.Sink{pipeline.History(store)},
+		}
+		if err := p.Run(ctx); err != nil {
+			fail(err)
+		}
+	}
+}
+
+// printAnomalyHeader prints the numbered heading of the i-th anomaly of a
+// list, its severity in color.
+func printAnomalyHeader(out io.Writer, i int, a baseline.Anomaly) {
+	p := paint(out)
+	fmt.Fprintf(out, "[%d] %s - %s\n", i+1, p.Severity(a.Severity, a.Severity), p.Bold(a.Type))
+}
+
+// printColdStart notes that b is in cold start, when it is, and so only
+// reports new behavior.
+func printColdStart(out io.Writer, b *baseline.Baseline) {
+	if status := b.ColdStartStatus(); status != "" {
+		fmt.Fprintf(out, "%s: reporting new behavior only\n", paint(out).Warn("Baseline in "+status))
+	}
+}
+
+// printInsufficient lists the keys that deviated but were learned from too
+// few samples to report as anomalies.
+func printInsufficient(out io.Writer, insufficient []baseline.Anomaly) {
+	if len(insufficient) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "\nInsufficient data to judge %d deviating keys:\n", len(insufficient))
+	for _, a := range insufficient {
+		fmt.Fprintf(out, "  %s: %s\n", a.Evidence, a.Description)
+	}
+}
+
+// paintScore colors text about a behavior score by the status it has.
+func paintScore(p term.Painter, score float64, text string) string {
+	switch {
+	case score >= 70:
+		return p.Good(text)
+	case score >= 50:
+		return p.Warn(text)
+	}
+	return p.Bad(text)
+}
+
+// printEnrichment prints an anomaly's network peer annotations on one line.
+func printEnrichment(out io.Writer, enrichment map[string]string) {
+	if len(enrichment) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(enrichment))
+	for k := range enrichment {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + enrichment[k]
+	}
+	fmt.Fprintf(out, "    Enrichment: %s\n", strings.Join(parts, " "))
+}
+
+// printRemediation prints what to do about an anomaly, if configured.
+func printRemediation(out io.Writer, r *baseline.Remediation) {
+	if r == nil {
+		return
+	}
+	for _, field := range []struct{ label, value string }{
+		{"Runbook", r.Runbook},
+		{"Owner", r.Owner},
+		{"Response", r.Action},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(out, "    %s: %s\n", field.label, field.value)
+		}
+	}
+}
+
+// printTrace prints the distributed trace an anomaly was raised in, if any.
+func printTrace(out io.Writer, a baseline.Anomaly) {
+	if a.TraceID == "" {
+		return
+	}
+	fmt.Fprintf(out, "    Trace: %s", a.TraceID)
+	if a.SpanID != "" {
+		fmt.Fprintf(out, " (span %s)", a.SpanID)
+	}
+	fmt.Fprintln(out)
+}
+
+// printLineage prints an anomaly's process ancestry, one process per line.
+func printLineage(out io.Writer, lineage []baseline.Process) {
+	if len(lineage) == 0 {
+		return
+	}
+	fmt.Fprintln(out, "    Lineage:")
+	for i, p := range lineage {
+		exe := p.Exe
+		if exe == "" {
+			exe = "?"
+		}
+		fmt.Fprintf(out, "    %s%d %s", strings.Repeat("  ", i), p.PID, exe)
+		if p.Cmdline != "" && p.Cmdline != exe {
+			fmt.Fprintf(out, " (%s)", p.Cmdline)
+		}
+		fmt.Fprintln(out)
+	}
+}
+
+func orNone(values []string) string {
+	if len(values) == 0 {
+		return "none, report only"
+	}
+	return strings.Join(values, ",")
+}
+
+func showAudit(fs *flag.FlagSet) func(args []string) {
+	since := fs.Duration("since", 0, "only show entries newer than this (0 for all)")
+	action := fs.String("action", "", "only show this action")
+	actor := fs.String("actor", "", "only show entries by this actor")
+	verify := fs.Bool("verify", false, "verify the audit log hash chain")
+	jsonOutput := fs.Bool("json", false, "print entries as JSON lines")
+	return func(positional []string) {
+		store, err := openStore()
+		if err != nil {
+			fail(err)
+		}
+
+		if *verify {
+			if err := store.Audit.Verify(); err != nil {
+				fmt.Printf("Audit log verification FAILED: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println("Audit log verified: hash chain intact")
 			return
 		}
-		detectAnomalies(os.Args[2])
-	case "analyze":
-		if len(os.Args) < 3 {
-			fmt.Println("Error: log file required")
-			printUsage()
+
+		filter := audit.Filter{Action: *action, Actor: *actor}
+		if *since > 0 {
+			filter.Since = time.Now().Add(-*since)
+		}
+		if len(positional) > 0 {
+			filter.Target = store.Qualify(positional[0])
+		}
+		entries, err := store.Audit.Query(filter)
+		if err != nil {
+			fail(err)
+		}
+
+		if *jsonOutput {
+			enc := json.NewEncoder(os.Stdout)
+			for _, entry := range entries {
+				if err := enc.Encode(entry); err != nil {
+					fail(err)
+				}
+			}
 			return
 		}
-		analyzeLog(os.Args[2])
-	case "check":
-		if len(os.Args) < 3 {
-			fmt.Println("Error: baseline name required")
-			printUsage()
+		if len(entries) == 0 {
+			fmt.Println("No audit entries")
 			return
 		}
-		checkBehavior(os.Args[2])
-	case "version":
-		fmt.Printf("runtimebase version %s\n", version)
-	case "help", "--help", "-h":
-		printUsage()
-	default:
-		fmt.Printf("Unknown command: %s\n", os.Args[1])
-		printUsage()
+		p := paint(os.Stdout)
+		for _, entry := range entries {
+			fmt.Printf("%s  %-10s %-20s by %s\n", p.Dim(entry.Time.Local().Format("2006-01-02 15:04:05")), entry.Action, entry.Target, entry.Actor)
+			for _, change := range entry.Diff {
+				switch {
+				case change.Old == "":
+					fmt.Printf("    %s %s %s\n", p.Good("+"), change.Field, change.New)
+				case change.New == "":
+					fmt.Printf("    %s %s %s\n", p.Bad("-"), change.Field, change.Old)
+				default:
+					fmt.Printf("    %s %s %s -> %s\n", p.Warn("~"), change.Field, change.Old, change.New)
+				}
+			}
+		}
 	}
 }
 
-func printUsage() {
-	fmt.Printf(`runtimebase - Runtime Behavior Baseline
+// setupLogging installs the default logger on stderr. $RUNTIMEBASE_LOG_LEVEL
+// and $RUNTIMEBASE_LOG_FORMAT override cfg.
+func showShadow(fs *flag.FlagSet) func(args []string) {
+	suffix := fs.String("suffix", pipeline.DefaultCandidateSuffix, "suffix naming the candidate baseline")
+	jsonOutput := fs.Bool("json", false, "print the divergence as JSON")
+	promote := fs.Bool("promote", false, "replace the baseline with the candidate")
+	return func(positional []string) {
+		if len(positional) < 1 {
+			fs.Usage()
+			fail(errors.New("baseline name required"))
+		}
+		name := positional[0]
+		candidate := name + *suffix
+
+		store, err := openStore()
+		if err != nil {
+			fail(err)
+		}
+		if *promote {
+			if err := store.Promote(candidate, name); err != nil {
+				fail(err)
+			}
+			fmt.Printf("Promoted %s to %s\n", candidate, name)
+			return
+		}
+		active, err := store.LoadBaseline(name)
+		if err != nil {
+			fail(err)
+		}
+		shadowed, err := store.LoadBaseline(candidate)
+		if err != nil {
+			fail(err)
+		}
+		d := baseline.Diverge(active, shadowed)
+		if *jsonOutput {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(d); err != nil {
+				fail(err)
+			}
+			return
+		}
 
-Usage:
-  runtimebase <command> [options]
+		fmt.Printf("Shadow candidate %s against %s\n", candidate, name)
+		fmt.Printf("  learning since %s, %d patterns observed\n\n", shadowed.CreatedAt.Format("2006-01-02 15:04:05"), d.Observed)
+		printDivergence(os.Stdout, d)
+		if d.Score >= pipeline.DefaultDivergenceThreshold {
+			fmt.Printf("\nBehavior has moved on; consider: runtimebase shadow %s --promote\n", name)
+		}
+	}
+}
 
-Commands:
-  learn <name>    Create and learn new behavior baseline
-  detect <name>   Detect anomalies against baseline
-  analyze <file>  Analyze log file for behavioral patterns
-  check <name>    Check current behavior against baseline
-  version         Show version information
-  help            Show this help message
+// printDivergence prints the divergence score of a candidate and the
+// patterns that are new, shifted or missing.
+func printDivergence(out io.Writer, d baseline.Divergence) {
+	fmt.Fprintf(out, "Divergence: %.0f%% of current patterns are new or shifted\n", d.Score*100)
+	printKeys := func(title string, keys []string) {
+		if len(keys) == 0 {
+			return
+		}
+		fmt.Fprintf(out, "\n%s (%d):\n", title, len(keys))
+		for _, key := range keys {
+			fmt.Fprintf(out, "  %s\n", key)
+		}
+	}
+	printKeys("New", d.New)
+	if len(d.Shifted) > 0 {
+		fmt.Fprintf(out, "\nShifted (%d):\n", len(d.Shifted))
+		t := term.NewTable(out)
+		t.Indent, t.Right = "  ", []int{1, 3}
+		for _, s := range d.Shifted {
+			t.Row(s.Key, fmt.Sprintf("mean %.1f", s.ActiveMean), "->", fmt.Sprintf("%.1f", s.CandidateMean))
+		}
+		t.Flush()
+	}
+	printKeys("Not seen by the candidate", d.Missing)
+}
 
-Examples:
-  runtimebase learn myapp
-  runtimebase detect myapp
-  runtimebase analyze /var/log/myapp.log
-`,)
+// canaryReport is the JSON output of canary.
+type canaryReport struct {
+	Stable     string
+	Candidate  string
+	Events     int
+	Span       time.Duration // between the first and last event learned
+	Threshold  float64
+	Pass       bool
+	Divergence baseline.Divergence
 }
 
-func learnBaseline(name string) {
-	learner := baseline.NewLearner()
-	baseline := learner.CreateBaseline(name)
+// runCanary learns a canary's behavior from a live event stream into a
+// fresh candidate baseline and gates on its divergence from the stable
+// baseline.
+func runCanary(fs *flag.FlagSet) func(args []string) {
+	stable := fs.String("stable", "", "baseline of the stable version (required)")
+	candidate := fs.String("candidate", "", "baseline to learn the canary into (required)")
+	duration := fs.Duration("duration", 30*time.Minute, "how long to learn the canary for")
+	eventsPath := fs.String("events", "-", "JSON lines events of the canary, - for stdin")
+	interval := fs.Duration("interval", time.Minute, "interval the stable baseline learned counts over")
+	threshold := fs.Float64("threshold", pipeline.DefaultDivergenceThreshold, "divergence score, from 0 to 1, at which the canary fails")
+	replace := fs.Bool("replace", false, "replace the candidate baseline if it exists")
+	jsonOutput := fs.Bool("json", false, "print the report as JSON")
+	quiet := fs.Bool("quiet", false, "print nothing and report only through the exit code")
+	ff := addFilterFlags(fs)
+	return func([]string) {
+		scope := ff.filter()
+		if *stable == "" || *candidate == "" {
+			fs.Usage()
+			fail(errors.New("--stable and --candidate are required"))
+		}
+		if *stable == *candidate {
+			fail(errors.New("--candidate must differ from --stable"))
+		}
+		out := io.Writer(os.Stdout)
+		if *quiet {
+			out = io.Discard
+		}
+
+		store, err := openStore()
+		if err != nil {
+			fail(err)
+		}
+		active, err := store.LoadBaseline(*stable)
+		if err != nil {
+			fail(err)
+		}
+		if _, err := store.LoadBaseline(*candidate); err == nil {
+			if !*replace {
+				fail(fmt.Errorf("baseline %s exists; pass --replace to learn the canary afresh", *candidate))
+			}
+			if err := store.DeleteBaseline(*candidate); err != nil {
+				fail(err)
+			}
+		} else if !errors.Is(err, storage.ErrNotFound) {
+			fail(err)
+		}
+
+		in := os.Stdin
+		if *eventsPath != "-" {
+			if in, err = os.Open(*eventsPath); err != nil {
+				fail(err)
+			}
+			defer in.Close()
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		p := &pipeline.Pipeline{Name: "canary", Stages: []pipeline.Stage{
+			pipeline.StaticRoute(*candidate),
+			pipeline.Learn(store, *interval, 30*time.Second, active.Metadata),
+		}}
+		if !*jsonOutput {
+			fmt.Fprintf(out, "Learning canary %s for %s against %s\n", *candidate, *duration, *stable)
+		}
+		deadline := time.NewTimer(*duration)
+		defer deadline.Stop()
+		events, errs := streamEvents(in, *eventsPath, scope)
+		n := 0
+		var first, last time.Time
+	learn:
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					select {
+					case err := <-errs:
+						fail(err)
+					default:
+					}
+					break learn
+				}
+				if event.Timestamp.IsZero() {
+					event.Timestamp = time.Now()
+				}
+				if err := p.Handle(ctx, &pipeline.Record{Source: *eventsPath, Event: &event}); err != nil {
+					fail(err)
+				}
+				if first.IsZero() || event.Timestamp.Before(first) {
+					first = event.Timestamp
+				}
+				if event.Timestamp.After(last) {
+					last = event.Timestamp
+				}
+				n++
+			case <-deadline.C:
+				break learn
+			case <-ctx.Done():
+				break learn
+			}
+		}
+		if err := p.Flush(context.Background()); err != nil {
+			fail(err)
+		}
+		learned, err := store.LoadBaseline(*candidate)
+		if errors.Is(err, storage.ErrNotFound) {
+			fail(fmt.Errorf("no events of the canary to learn from %s", *eventsPath))
+		} else if err != nil {
+			fail(err)
+		}
 
-	fmt.Printf("Learning baseline: %s\n", name)
-	fmt.Printf("Created at: %s\n", baseline.CreatedAt.Format("2006-01-02 15:04:05"))
-	fmt.Println()
-	fmt.Println("Baseline initialized. Start collecting behavior data...")
-	fmt.Println("Use RecordObservation() to learn patterns:")
-	fmt.Println("  baseline.RecordObservation(\"syscall\", \"open\", 100)")
-	fmt.Println("  baseline.RecordObservation(\"file\", \"read\", 500)")
+		d := baseline.Diverge(active, learned)
+		report := canaryReport{Stable: *stable, Candidate: *candidate, Events: n, Span: last.Sub(first).Round(time.Second),
+			Threshold: *threshold, Pass: d.Score < *threshold, Divergence: d}
+		if *jsonOutput {
+			enc := json.NewEncoder(out)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(report); err != nil {
+				fail(err)
+			}
+		} else {
+			fmt.Fprintf(out, "  learned %d events spanning %s, %d patterns observed\n\n", n, report.Span, d.Observed)
+			printDivergence(out, d)
+			p := paint(out)
+			verdict := p.Good("PASS")
+			if !report.Pass {
+				verdict = p.Bad("FAIL")
+			}
+			fmt.Fprintf(out, "\n%s: divergence %.0f%%, threshold %.0f%%\n", verdict, d.Score*100, *threshold*100)
+		}
+		if !report.Pass {
+			os.Exit(exitHigh)
+		}
+	}
 }
 
-func detectAnomalies(name string) {
-	learner := baseline.NewLearner()
-	baseline := learner.CreateBaseline(name)
+// runRules runs the rules subcommands: test replays archived events
+// through a proposed rule set and diffs its alerts against the current
+// one, and stats shows the daemon's metrics of each rule.
+func runRules(fs *flag.FlagSet) func(args []string) {
+	proposedPath := fs.String("rules", "", "test: YAML rule set to test (required)")
+	currentPath := fs.String("current", "", "test: YAML rule set to compare with (default: the configuration's suppressions and default packs)")
+	against := fs.String("against", "", "test: archived JSON lines events: a file, a directory of files or a glob (required)")
+	name := fs.String("baseline", "", "test: also alert on behavior outside this baseline")
+	interval := fs.Duration("interval", time.Minute, "test: interval the baseline learned counts over")
+	top := fs.Int("top", 20, "test: changed alerts to list")
+	url := fs.String("url", "", "stats: metrics endpoint of the daemon (default: from metrics_addr in the configuration)")
+	sortBy := fs.String("sort", "fired", "stats: order rules by fired, suppressed, confidence or latency")
+	jsonOutput := fs.Bool("json", false, "print JSON")
+	ff := addFilterFlags(fs)
+	return func(args []string) {
+		if len(args) == 1 && args[0] == "stats" {
+			showRuleStats(*url, *sortBy, *jsonOutput)
+			return
+		}
+		if len(args) != 1 || args[0] != "test" {
+			fs.Usage()
+			os.Exit(exitError)
+		}
+		scope := ff.filter()
+		if *proposedPath == "" || *against == "" {
+			fs.Usage()
+			fail(errors.New("--rules and --against are required"))
+		}
+		proposed, err := rules.Load(*proposedPath)
+		if err != nil {
+			fail(err)
+		}
+		current := rules.Set{Suppress: cliConfig.Suppress}
+		if *currentPath != "" {
+			if current, err = rules.Load(*currentPath); err != nil {
+				fail(err)
+			}
+		}
+		paths, err := archivePaths(*against)
+		if err != nil {
+			fail(err)
+		}
+		var events []detect.SystemEvent
+		err = logfile.ScanLines(paths, false, func(line string) error {
+			if strings.TrimSpace(line) == "" {
+				return nil
+			}
+			var event detect.SystemEvent
+			if err := json.Unmarshal([]byte(line), &event); err != nil {
+				return err
+			}
+			if scope.Event(event) {
+				events = append(events, event)
+			}
+			return nil
+		})
+		if err != nil {
+			fail(err)
+		}
+		sort.SliceStable(events, func(i, j int) bool { return events[i].Timestamp.Before(events[j].Timestamp) })
 
-	fmt.Printf("Detecting anomalies for: %s\n", name)
-	fmt.Println()
+		var b *baseline.Baseline
+		if *name != "" {
+			store, err := openStore()
+			if err != nil {
+				fail(err)
+			}
+			if b, err = store.LoadBaseline(*name); err != nil {
+				fail(err)
+			}
+		}
+		was, err := rules.Evaluate(current, events, b, *interval)
+		if err != nil {
+			fail(err)
+		}
+		now, err := rules.Evaluate(proposed, events, b, *interval)
+		if err != nil {
+			fail(err)
+		}
+		changes := rules.Diff(was, now)
 
-	// Simulate some observations
-	baseline.RecordObservation("syscall", "open", 100)
-	baseline.RecordObservation("syscall", "read", 500)
-	baseline.RecordObservation("file", "write", 200)
+		if *jsonOutput {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			err := enc.Encode(struct {
+				Files    []string       `json:"files"`
+				Current  rules.Result   `json:"current"`
+				Proposed rules.Result   `json:"proposed"`
+				Changes  []rules.Change `json:"changes"`
+			}{paths, was, now, changes})
+			if err != nil {
+				fail(err)
+			}
+			return
+		}
 
-	// Detect anomalies
-	anomalies := learner.DetectAnomaly(name, "syscall", "open", 500)
+		out := os.Stdout
+		p := paint(out)
+		fmt.Fprintf(out, "Replayed %d events from %d files", len(events), len(paths))
+		if len(events) > 0 {
+			fmt.Fprintf(out, ", %s to %s", events[0].Timestamp.Format(time.RFC3339), events[len(events)-1].Timestamp.Format(time.RFC3339))
+		}
+		fmt.Fprintln(out)
+		fmt.Fprintln(out)
+		delta := func(d int) string {
+			switch {
+			case d > 0:
+				return p.Bad(fmt.Sprintf("%+d", d))
+			case d < 0:
+				return p.Good(fmt.Sprintf("%+d", d))
+			}
+			return ""
+		}
+		t := term.NewTable(out)
+		t.Indent, t.Right = "  ", []int{1, 2}
+		t.Row("", p.Dim("current"), p.Dim("proposed"), "")
+		t.Row(p.Bold("Alerts"), fmt.Sprint(was.Total), fmt.Sprint(now.Total), delta(now.Total-was.Total))
+		wasTypes, nowTypes := was.Types, now.Types
+		var types []string
+		for typ := range wasTypes {
+			types = append(types, typ)
+		}
+		for typ := range nowTypes {
+			if _, ok := wasTypes[typ]; !ok {
+				types = append(types, typ)
+			}
+		}
+		sort.Strings(types)
+		for _, typ := range types {
+			t.Row("  "+typ, fmt.Sprint(wasTypes[typ]), fmt.Sprint(nowTypes[typ]), delta(nowTypes[typ]-wasTypes[typ]))
+		}
+		t.Row("Suppressed", fmt.Sprint(was.Suppressed), fmt.Sprint(now.Suppressed), "")
+		t.Row("Below min severity", fmt.Sprint(was.BelowMin), fmt.Sprint(now.BelowMin), "")
+		t.Flush()
 
-	if len(anomalies) > 0 {
-		fmt.Printf("Found %d anomalies:\n\n", len(anomalies))
-		for i, anomaly := range anomalies {
-			fmt.Printf("[%d] %s - %s\n", i+1, anomaly.Severity, anomaly.Type)
-			fmt.Printf("    Evidence: %s\n", anomaly.Evidence)
-			fmt.Printf("    Confidence: %.0f%%\n", anomaly.Confidence*100)
-			fmt.Printf("    Risk Level: %s\n\n", anomaly.RiskLevel)
+		if len(changes) == 0 {
+			fmt.Fprintln(out, "\nThe proposed rules raise the same alerts as the current ones")
+			return
 		}
-	} else {
-		fmt.Println("No anomalies detected - behavior within normal range")
-	}
-}
-
-func analyzeLog(filepath string) {
-	fmt.Printf("Analyzing log file: %s\n", filepath)
-	fmt.Println()
-
-	// In production: read and parse log file
-	// For demo: show analysis template
-	fmt.Println("Log analysis template:")
-	fmt.Println("1. Parse log entries")
-	fmt.Println("2. Extract behavioral patterns")
-	fmt.Println("3. Calculate statistics")
-	fmt.Println("4. Generate baseline")
-	fmt.Println()
-	fmt.Println("Supported log formats:")
-	fmt.Println("  - Syscall traces")
-	fmt.Println("  - File access logs")
-	fmt.Println("  - Network connection logs")
-	fmt.Println("  - Process activity logs")
-}
-
-func checkBehavior(name string) {
-//	learner := baseline.NewLearner()
-//	baseline := learner.CreateBaseline(name)
-
-	fmt.Printf("Checking behavior against baseline: %s\n", name)
-	fmt.Println()
-
-	// Example check
-//	isNormal := baseline.IsNormal(100, 100, 10, 3.0)
-//	if isNormal {
-//		fmt.Println("✓ Behavior is within normal parameters")
-//	} else {
-//		fmt.Println("⚠ Behavior deviates from baseline")
-//	}
-//
-//	// Calculate behavior score
-//	score := detect.CalculateBehaviorScore([]interface{}{}, map[string]int{"syscall": 100, "file": 500})
-//	fmt.Printf("\nBehavior Score: %.0f%%\n", score)
-//
-//	if score >= 90 {
-//		fmt.Println("Status: Excellent - No anomalies detected")
-//	} else if score >= 70 {
-//		fmt.Println("Status: Good - Minor deviations")
-//	} else if score >= 50 {
-//		fmt.Println("Status: Fair - Investigate further")
-//	} else {
-		fmt.Println("Status: Poor - Immediate action required")
-//	}
+		fmt.Fprintf(out, "\n%d alerts change:\n", len(changes))
+		t = term.NewTable(out)
+		t.Indent, t.Right = "  ", []int{0}
+		for _, c := range changes[:min(len(changes), *top)] {
+			t.Row(delta(c.Delta()), p.Severity(c.Severity, c.Severity), c.Type, c.Evidence)
+		}
+		t.Flush()
+		if more := len(changes) - *top; more > 0 {
+			fmt.Fprintf(out, "  and %d more; see --json\n", more)
+		}
+	}
+}
+
+// showRuleStats prints the metrics of each detection rule and category
+// the daemon serves at url.
+func showRuleStats(url, sortBy string, jsonOutput bool) {
+	if url == "" {
+		if cliConfig.MetricsAddr == "" {
+			fail(errors.New("no metrics_addr in the configuration; pass --url"))
+		}
+		host, port, err := net.SplitHostPort(cliConfig.MetricsAddr)
+		if err != nil {
+			fail(fmt.Errorf("metrics_addr: %w", err))
+		}
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			host = "localhost"
+		}
+		url = "http://" + net.JoinHostPort(host, port) + "/metrics"
+	}
+	less := map[string]func(a, b rules.Stats) bool{
+		"fired":      func(a, b rules.Stats) bool { return a.Fired > b.Fired },
+		"suppressed": func(a, b rules.Stats) bool { return a.SuppressedRatio() > b.SuppressedRatio() },
+		"confidence": func(a, b rules.Stats) bool { return a.Confidence < b.Confidence },
+		"latency":    func(a, b rules.Stats) bool { return a.Latency > b.Latency },
+	}[sortBy]
+	if less == nil {
+		fail(fmt.Errorf("unknown --sort %q: want fired, suppressed, confidence or latency", sortBy))
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		fail(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fail(fmt.Errorf("%s: %s", url, resp.Status))
+	}
+	samples, err := metrics.Parse(resp.Body)
+	if err != nil {
+		fail(fmt.Errorf("%s: %w", url, err))
+	}
+	byRule, byCategory := rules.StatsOf(samples)
+	sort.SliceStable(byRule, func(i, j int) bool { return less(byRule[i], byRule[j]) })
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(struct {
+			Rules      []rules.Stats `json:"rules"`
+			Categories []rules.Stats `json:"categories"`
+		}{byRule, byCategory})
+		if err != nil {
+			fail(err)
+		}
+		return
+	}
+	if len(byRule) == 0 {
+		fmt.Println("No rule metrics yet: the daemon has not run detection in a pipeline")
+		return
+	}
+	out := os.Stdout
+	p := paint(out)
+	t := term.NewTable(out)
+	t.Indent, t.Right = "  ", []int{1, 2, 3, 4, 5}
+	fmt.Fprintln(out, "Rules:")
+	t.Row(p.Dim("rule"), p.Dim("fired"), p.Dim("suppressed"), p.Dim("confidence"), p.Dim("evaluations"), p.Dim("avg latency"))
+	fired := func(st rules.Stats) []string {
+		if st.Fired == 0 {
+			return []string{"0", "-", "-"}
+		}
+		return []string{fmt.Sprint(st.Fired), fmt.Sprintf("%d (%.0f%%)", st.Suppressed, st.SuppressedRatio()*100),
+			fmt.Sprintf("%.0f%%", st.Confidence*100)}
+	}
+	for _, st := range byRule {
+		evaluations, latency := "-", "-"
+		if st.Evaluations > 0 {
+			evaluations, latency = fmt.Sprint(st.Evaluations), st.Latency.String()
+		}
+		t.Row(append(append([]string{st.Name}, fired(st)...), evaluations, latency)...)
+	}
+	t.Flush()
+	fmt.Fprintln(out, "\nCategories:")
+	t.Row(p.Dim("category"), p.Dim("fired"), p.Dim("suppressed"), p.Dim("confidence"))
+	for _, st := range byCategory {
+		t.Row(append([]string{st.Name}, fired(st)...)...)
+	}
+	t.Flush()
+}
+
+// archivePaths expands an event archive given as a file, a directory,
+// whose files are all read, or a glob, to the files it names, sorted.
+func archivePaths(archive string) ([]string, error) {
+	if info, err := os.Stat(archive); err == nil && info.IsDir() {
+		entries, err := os.ReadDir(archive)
+		if err != nil {
+			return nil, err
+		}
+		var paths []string
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				paths = append(paths, filepath.Join(archive, e.Name()))
+			}
+		}
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("%s: no event files", archive)
+		}
+		return paths, nil
+	} else if err == nil {
+		return []string{archive}, nil
+	}
+	paths, err := filepath.Glob(archive)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("%s: no event files", archive)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// listCategories prints the category ontology: the built-in categories and
+// those added in the configuration.
+func listCategories(fs *flag.FlagSet) func(args []string) {
+	jsonOutput := fs.Bool("json", false, "print the categories as JSON")
+	return func([]string) {
+		categories := category.Current().Categories()
+		if *jsonOutput {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(categories); err != nil {
+				fail(err)
+			}
+			return
+		}
+		p := paint(os.Stdout)
+		t := term.NewTable(os.Stdout)
+		t.Row(p.Bold("CATEGORY"), p.Bold("DESCRIPTION"))
+		for _, c := range categories {
+			depth := strings.Count(c.Name, ".")
+			t.Row(strings.Repeat("  ", depth)+c.Name, c.Description)
+		}
+		t.Flush()
+	}
+}
+
+func showBaselines(fs *flag.FlagSet) func(args []string) {
+	keys := fs.Int("keys", 10, "number of least-sampled keys to show")
+	jsonOutput := fs.Bool("json", false, "print the quality as JSON")
+	selector := fs.String("label", "", "list: only baselines with this comma-separated key=value metadata, or key= for any value")
+	return func(positional []string) {
+		if len(positional) < 1 || positional[0] != "list" && len(positional) < 2 {
+			fs.Usage()
+			os.Exit(exitError)
+		}
+		labels := parseLabels(*selector)
+		store, err := openStore()
+		if err != nil {
+			fail(err)
+		}
+		switch positional[0] {
+		case "list":
+			names, err := store.ListBaselines()
+			if err != nil {
+				fail(err)
+			}
+			if len(names) == 0 {
+				fmt.Println("No baselines")
+				return
+			}
+			p := paint(os.Stdout)
+			t := term.NewTable(os.Stdout)
+			t.Right = []int{1, 2}
+			t.Row(p.Bold("NAME"), p.Bold("KEYS"), p.Bold("QUALITY"), p.Bold("UPDATED"), p.Bold("LABELS"))
+			listed := 0
+			for _, name := range names {
+				b, err := store.LoadBaseline(name)
+				if err != nil {
+					if len(labels) == 0 {
+						t.Row(name, "", "", p.Bad(err.Error()), "")
+					}
+					continue
+				}
+				if !b.MatchMetadata(labels) {
+					continue
+				}
+				q := b.Quality()
+				quality := paintQuality(p, q, fmt.Sprintf("%.0f", q.Score))
+				if b.InColdStart() {
+					quality = p.Warn("cold start") + " " + quality
+				}
+				t.Row(name, fmt.Sprint(q.Keys), quality, b.UpdatedAt.Format("2006-01-02 15:04"), baseline.FormatMetadata(b.Metadata))
+				listed++
+			}
+			if listed == 0 && len(labels) > 0 {
+				fmt.Printf("No baselines labeled %s\n", baseline.FormatMetadata(labels))
+				return
+			}
+			t.Flush()
+		case "show":
+			name := positional[1]
+			b, err := store.LoadBaseline(name)
+			if err != nil {
+				fail(err)
+			}
+			q := b.Quality()
+			if *jsonOutput {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(q); err != nil {
+					fail(err)
+				}
+				return
+			}
+			p := paint(os.Stdout)
+			verdict := "ready for enforcement"
+			if !q.Ready() {
+				verdict = "not yet trustworthy enough to enforce"
+			}
+			fmt.Printf("Baseline %s\n", p.Bold(name))
+			fmt.Printf("  created %s, updated %s\n", b.CreatedAt.Format("2006-01-02 15:04:05"), b.UpdatedAt.Format("2006-01-02 15:04:05"))
+			if len(b.Metadata) > 0 {
+				fmt.Printf("  labels %s\n", baseline.FormatMetadata(b.Metadata))
+			}
+			if status := b.ColdStartStatus(); status != "" {
+				fmt.Printf("  %s: reporting new behavior only\n", p.Warn(status))
+			}
+			fmt.Printf("  %d keys", q.Keys)
+			if q.Seeded > 0 {
+				fmt.Printf(" (%d more seeded, not yet observed)", q.Seeded)
+			}
+			if len(b.Open) > 0 || b.Late > 0 {
+				fmt.Printf("\n  %d intervals of event time open, %d observations dropped as too late (lateness %s)", len(b.Open), b.Late, b.Lateness)
+			}
+			if b.Reference != nil {
+				fmt.Printf("\n  relearning against %s, %d observations pending approval", b.Reference.Name, len(b.Pending))
+			}
+			fmt.Printf("\n\nQuality: %s (%s)\n", paintQuality(p, q, fmt.Sprintf("%.0f/100", q.Score)), verdict)
+			t := term.NewTable(os.Stdout)
+			t.Indent, t.Right = "  ", []int{1}
+			t.Row("samples", fmt.Sprintf("%.0f%%", q.Samples*100), fmt.Sprintf("keys with %d or more samples", baseline.MinQualitySamples))
+			t.Row("coverage", fmt.Sprintf("%.0f%%", q.Coverage*100), "runtime categories observed")
+			t.Row("freshness", fmt.Sprintf("%.0f%%", q.Freshness*100), "updated "+q.Age.Round(time.Minute).String()+" ago")
+			t.Row("stability", fmt.Sprintf("%.0f%%", q.Stability*100), "keys varying less than their mean")
+			t.Flush()
+			if len(q.Issues) > 0 {
+				fmt.Println("\nIssues:")
+				for _, issue := range q.Issues {
+					fmt.Printf("  %s\n", p.Warn(issue))
+				}
+			}
+			if len(q.Categories) > 0 {
+				fmt.Println("\nCategories:")
+				names := make([]string, 0, len(q.Categories))
+				for category := range q.Categories {
+					names = append(names, category)
+				}
+				sort.Strings(names)
+				t := term.NewTable(os.Stdout)
+				t.Indent, t.Right = "  ", []int{1, 2}
+				for _, category := range names {
+					c := q.Categories[category]
+					t.Row(category, fmt.Sprintf("%d keys", c.Keys), fmt.Sprintf("%d samples", c.Samples))
+				}
+				t.Flush()
+			}
+			if n := min(*keys, len(q.PerKey)); n > 0 {
+				fmt.Printf("\nLeast sampled keys:\n")
+				t := term.NewTable(os.Stdout)
+				t.Indent, t.Right = "  ", []int{1, 2}
+				for _, k := range q.PerKey[:n] {
+					samples := fmt.Sprintf("%d samples", k.Samples)
+					if k.Samples < baseline.MinQualitySamples {
+						samples = p.Warn(samples)
+					}
+					t.Row(k.Key, samples, fmt.Sprintf("cv %.2f", k.Variation))
+				}
+				t.Flush()
+			}
+			if len(b.Pending) > 0 {
+				fmt.Printf("\nPending approval:\n")
+				t := term.NewTable(os.Stdout)
+				t.Indent, t.Right = "  ", []int{1, 2}
+				for _, o := range b.Pending {
+					t.Row(baseline.StatKey(o.Category, o.Pattern, o.Labels), fmt.Sprintf("count %d", o.Count), fmt.Sprintf("z %.1f", o.ZScore), o.QueuedAt.Format("2006-01-02 15:04:05"))
+				}
+				t.Flush()
+			}
+		case "approve", "reject":
+			name, keys := positional[1], positional[2:]
+			n := 0
+			err := store.Update(name, func(b *baseline.Baseline) (*baseline.Baseline, error) {
+				if b == nil {
+					return nil, storage.ErrNotFound
+				}
+				if positional[0] == "approve" {
+					n = b.ApprovePending(keys...)
+				} else {
+					n = b.RejectPending(keys...)
+				}
+				if n == 0 {
+					return nil, nil
+				}
+				return b, nil
+			})
+			if err != nil {
+				fail(err)
+			}
+			verb := "Approved"
+			if positional[0] == "reject" {
+				verb = "Rejected"
+			}
+			fmt.Printf("%s %d pending observations of %s\n", verb, n, name)
+		default:
+			fs.Usage()
+			os.Exit(exitError)
+		}
+	}
+}
+
+// paintQuality colors text about a baseline's quality by whether it is
+// ready for enforcement.
+func paintQuality(p term.Painter, q baseline.Quality, text string) string {
+	switch {
+	case q.Ready():
+		return p.Good(text)
+	case q.Score >= 50:
+		return p.Warn(text)
+	}
+	return p.Bad(text)
+}
+
+// runOverride adds, lists and removes the time-boxed overrides of
+// baselines. The audit log records every change, and listing removes, and
+// records, the overrides that have expired.
+func runOverride(fs *flag.FlagSet) func(args []string) {
+	ttl := fs.Duration("ttl", 0, "add: how long the behavior is expected (required)")
+	reason := fs.String("reason", "", "add: why the behavior is expected (required)")
+	jsonOutput := fs.Bool("json", false, "list: print JSON")
+	return func(args []string) {
+		if len(args) == 0 || args[0] != "list" && len(args) != 3 || args[0] == "list" && len(args) > 2 {
+			fs.Usage()
+			os.Exit(exitError)
+		}
+		store, err := openStore()
+		if err != nil {
+			fail(err)
+		}
+		now := time.Now()
+		switch args[0] {
+		case "add":
+			if *ttl <= 0 || *reason == "" {
+				fs.Usage()
+				fail(errors.New("--ttl and --reason are required"))
+			}
+			o := baseline.Override{Key: args[2], Reason: *reason, Actor: store.Actor, Created: now, Expires: now.Add(*ttl)}
+			err := store.Update(args[1], func(b *baseline.Baseline) (*baseline.Baseline, error) {
+				if b == nil {
+					return nil, fmt.Errorf("%w: %s", storage.ErrNotFound, args[1])
+				}
+				return b, b.AddOverride(o)
+			})
+			if err != nil {
+				fail(err)
+			}
+			fmt.Printf("%s is expected on %s until %s\n", o.Key, args[1], o.Expires.Format("2006-01-02 15:04 MST"))
+		case "remove":
+			err := store.Update(args[1], func(b *baseline.Baseline) (*baseline.Baseline, error) {
+				if b == nil {
+					return nil, fmt.Errorf("%w: %s", storage.ErrNotFound, args[1])
+				}
+				if !b.RemoveOverride(args[2]) {
+					return nil, fmt.Errorf("baseline %s has no override of %s", b.Name, args[2])
+				}
+				return b, nil
+			})
+			if err != nil {
+				fail(err)
+			}
+			fmt.Printf("Removed the override of %s on %s\n", args[2], args[1])
+		case "list":
+			names := args[1:]
+			if len(names) == 0 {
+				if names, err = store.ListBaselines(); err != nil {
+					fail(err)
+				}
+			}
+			type listed struct {
+				Baseline string
+				baseline.Override
+			}
+			var overrides []listed
+			for _, name := range names {
+				b, err := store.LoadBaseline(name)
+				if err != nil {
+					fail(err)
+				}
+				if expired := b.ExpireOverrides(now); len(expired) > 0 && !store.ReadOnly {
+					if err := store.SaveBaseline(b); err != nil {
+						fail(err)
+					}
+				}
+				for _, o := range b.Overrides {
+					overrides = append(overrides, listed{name, o})
+				}
+			}
+			if *jsonOutput {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(overrides); err != nil {
+					fail(err)
+				}
+				return
+			}
+			if len(overrides) == 0 {
+				fmt.Println("No overrides")
+				return
+			}
+			p := paint(os.Stdout)
+			t := term.NewTable(os.Stdout)
+			t.Row(p.Bold("BASELINE"), p.Bold("KEY"), p.Bold("EXPIRES"), p.Bold("BY"), p.Bold("REASON"))
+			for _, o := range overrides {
+				left := o.Expires.Sub(now).Round(time.Minute)
+				t.Row(o.Baseline, o.Key, fmt.Sprintf("%s (in %s)", o.Expires.Format("2006-01-02 15:04"), left), o.Actor, o.Reason)
+			}
+			t.Flush()
+		default:
+			fs.Usage()
+			os.Exit(exitError)
+		}
+	}
+}
+
+func simulateEvents(fs *flag.FlagSet) func(args []string) {
+	profile := fs.String("profile", "web", "normal workload: "+strings.Join(simulate.ProfileNames(), ", "))
+	duration := fs.Duration("duration", 10*time.Minute, "length of the stream")
+	rate := fs.Float64("rate", 10, "normal events per second")
+	seed := fs.Int64("seed", 1, "random seed; the same seed gives the same stream")
+	inject := fs.String("inject", "", "comma-separated scenarios, each optionally @offset (default mid-stream): "+strings.Join(simulate.ScenarioNames(), ", "))
+	startAt := fs.String("start", "", "RFC 3339 time of the first event (default: now minus --duration)")
+	learnName := fs.String("learn", "", "learn the stream into this baseline instead of printing it")
+	interval := fs.Duration("interval", time.Minute, "learning interval for --learn")
+	labels := addLabelFlag(fs)
+	output := fs.String("output", "-", "file to write events to as JSON lines")
+	list := fs.Bool("list", false, "list profiles and scenarios")
+	return func([]string) {
+		if *list {
+			fmt.Println("Profiles:")
+			for _, name := range simulate.ProfileNames() {
+				fmt.Printf("  %-14s %s\n", name, simulate.Profiles[name].Description)
+			}
+			fmt.Println("\nScenarios:")
+			for _, name := range simulate.ScenarioNames() {
+				fmt.Printf("  %-14s %s\n", name, simulate.Scenarios[name].Description)
+			}
+			return
+		}
+
+		start := time.Now().Add(-*duration).Truncate(time.Second)
+		if *startAt != "" {
+			var err error
+			if start, err = time.Parse(time.RFC3339, *startAt); err != nil {
+				fail(fmt.Errorf("invalid --start: %w", err))
+			}
+		}
+		injections, err := simulate.ParseInjections(*inject, *duration/2)
+		if err != nil {
+			fail(err)
+		}
+		g := simulate.Generator{Profile: *profile, Start: start, Duration: *duration, Rate: *rate, Seed: *seed, Inject: injections}
+		events, err := g.Generate()
+		if err != nil {
+			fail(err)
+		}
+
+		if *learnName != "" {
+			store, err := openStore()
+			if err != nil {
+				fail(err)
+			}
+			p := &pipeline.Pipeline{Name: "simulate", Stages: []pipeline.Stage{
+				pipeline.StaticRoute(*learnName),
+				pipeline.Learn(store, *interval, 0, parseLabels(*labels)),
+			}}
+			ctx := context.Background()
+			for i := range events {
+				if err := p.Handle(ctx, &pipeline.Record{Source: "simulate", Event: &events[i]}); err != nil {
+					fail(err)
+				}
+			}
+			if err := p.Flush(ctx); err != nil {
+				fail(err)
+			}
+			fmt.Printf("Learned %d simulated %s events over %s into baseline %s\n", len(events), *profile, *duration, *learnName)
+			return
+		}
+
+		out := io.Writer(os.Stdout)
+		if *output != "-" {
+			f, err := os.Create(*output)
+			if err != nil {
+				fail(err)
+			}
+			defer f.Close()
+			out = f
+		}
+		w := bufio.NewWriter(out)
+		enc := json.NewEncoder(w)
+		for _, event := range events {
+			if err := enc.Encode(event); err != nil {
+				fail(err)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			fail(err)
+		}
+	}
+}
+
+func setupLogging(cfg logging.Config) {
+	if level := os.Getenv("RUNTIMEBASE_LOG_LEVEL"); level != "" {
+		cfg.Level = level
+	}
+	if format := os.Getenv("RUNTIMEBASE_LOG_FORMAT"); format != "" {
+		cfg.Format = format
+	}
+	logger, err := logging.New(os.Stderr, cfg)
+	if err != nil {
+		slog.Warn("ignoring log settings", "error", err)
+		return
+	}
+	slog.SetDefault(logger)
+}
+
+// applyConfig applies process-wide settings, such as the severity taxonomy
+// and logging, from the file named by $RUNTIMEBASE_CONFIG.
+func applyConfig() {
+	path := os.Getenv("RUNTIMEBASE_CONFIG")
+	if path == "" {
+		return
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		slog.Warn("ignoring RUNTIMEBASE_CONFIG", "error", err)
+		return
+	}
+	cliConfig = cfg
+	severity.SetCurrent(cfg.Taxonomy())
+	category.SetCurrent(cfg.Ontology())
+	setupLogging(cfg.Log)
+}
+
+// loadEnricher opens the enrichment sources in cliConfig, returning nil when
+// none are configured or they cannot be read.
+func loadEnricher() *enrich.Enricher {
+	enricher, err := cliConfig.Enrich.Enricher()
+	if err != nil {
+		slog.Warn("enrichment disabled", "error", err)
+		return nil
+	}
+	return enricher
+}
+
+// cliConfig is the configuration applied from $RUNTIMEBASE_CONFIG.
+var cliConfig = config.Default()
+
+// namespace is the tenant namespace the CLI works in, set with the global
+// --namespace flag or $RUNTIMEBASE_NAMESPACE; empty for the default one.
+var namespace = os.Getenv("RUNTIMEBASE_NAMESPACE")
+
+// noColor turns off colored output, set with --no-color.
+var noColor bool
+
+// readOnly opens the store read-only, set with the global --read-only flag
+// or $RUNTIMEBASE_READ_ONLY.
+var readOnly = os.Getenv("RUNTIMEBASE_READ_ONLY") != ""
+
+// paint returns the painter for output to w.
+func paint(w io.Writer) term.Painter {
+	return term.For(w, noColor)
+}
+
+// openStore opens the baseline store in the default location, attributing
+// changes to the invoking user and encrypting baselines when a key is
+// configured. With --read-only the store is opened read-only.
+func openStore() (*storage.Store, error) {
+	open := storage.Open
+	if readOnly {
+		open = storage.OpenReadOnly
+	}
+	store, err := open(storage.DefaultDir())
+	if err != nil {
+		return nil, err
+	}
+	if store, err = store.WithNamespace(namespace); err != nil {
+		return nil, err
+	}
+	if kp := cliConfig.Encryption.Provider(); kp != nil {
+		if store, err = store.WithEncryption(kp); err != nil {
+			return nil, err
+		}
+	}
+	return store.WithActor(cliActor()), nil
+}
+
+// cliActor identifies the user running the CLI for the audit log.
+func cliActor() string {
+	if u, err := user.Current(); err == nil {
+		return "cli:" + u.Username
+	}
+	return "cli"
+}
+
+// parseExitCode returns the exit code for a flag parsing error, which the
+// flag set has already reported: 0 for --help, exitError otherwise, so
+// usage errors are not mistaken for HIGH findings.
+func parseExitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	return exitError
+}
+
+// parseArgs parses flags that may be interleaved with positional arguments
+// and returns the positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			os.Exit(parseExitCode(err))
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
 }
 
 func getType(info os.FileInfo) string {
@@ -172,4 +3365,4 @@ func getType(info os.FileInfo) string {
 		return "directory"
 	}
 	return "file"
-}
\ No newline at end of file
+}
@@ -2,4 +2,4 @@ module github.com/hallucinaut/runtimebase
 
 go 1.21
 
-require gopkg.in/yaml.v3 v3.0.1
\ No newline at end of file
+require gopkg.in/yaml.v3 v3.0.1
@@ -0,0 +1,202 @@
+// Package audit records baseline and configuration mutations in an
+// append-only, hash-chained log.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Actions recorded in the audit log.
+const (
+	ActionCreate    = "create"
+	ActionUpdate    = "update"
+	ActionLearn     = "learn"
+	ActionThreshold = "threshold"
+	ActionOverride  = "override"
+	ActionMigrate   = "migrate"
+	ActionPromote   = "promote"
+	ActionDelete    = "delete"
+	ActionArchive   = "archive"
+	ActionConfig    = "config"
+)
+
+// Entry is one audit record. Hash covers every other field plus the previous
+// entry's hash, so edits or deletions break the chain.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`
+	Action   string    `json:"action"`
+	Target   string    `json:"target"`
+	Diff     []Change  `json:"diff,omitempty"`
+	PrevHash string    `json:"prev_hash"`
+	Hash     string    `json:"hash"`
+}
+
+// Change is one field-level difference.
+type Change struct {
+	Field string `json:"field"`
+	Old   string `json:"old,omitempty"`
+	New   string `json:"new,omitempty"`
+}
+
+// Log is an append-only audit log stored as JSON lines.
+type Log struct {
+	Path string
+
+	mu       sync.Mutex
+	lastHash string
+	loaded   bool
+	size     int64 // of the file after our last append
+}
+
+// Open opens the audit log at path, creating its directory if needed.
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	return &Log{Path: path}, nil
+}
+
+// Record appends an entry, filling in its time and hash chain.
+func (l *Log) Record(actor, action, target string, diff []Change) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Another process sharing the log may have appended since.
+	if info, err := os.Stat(l.Path); l.loaded && (err != nil || info.Size() != l.size) {
+		l.loaded = false
+	}
+	if !l.loaded {
+		entries, err := l.read()
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			l.lastHash = entries[len(entries)-1].Hash
+		}
+		l.loaded = true
+	}
+
+	entry := Entry{
+		Time:     time.Now().UTC(),
+		Actor:    actor,
+		Action:   action,
+		Target:   target,
+		Diff:     diff,
+		PrevHash: l.lastHash,
+	}
+	entry.Hash = entry.digest()
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	l.lastHash = entry.Hash
+	if info, err := f.Stat(); err == nil {
+		l.size = info.Size()
+	}
+	return nil
+}
+
+// Filter selects audit entries. Zero fields match everything.
+type Filter struct {
+	Since  time.Time
+	Actor  string
+	Action string
+	Target string
+}
+
+// Query returns entries matching filter, oldest first.
+func (l *Log) Query(filter Filter) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries, err := l.read()
+	if err != nil {
+		return nil, err
+	}
+	var matched []Entry
+	for _, e := range entries {
+		if e.Time.Before(filter.Since) ||
+			(filter.Actor != "" && e.Actor != filter.Actor) ||
+			(filter.Action != "" && e.Action != filter.Action) ||
+			(filter.Target != "" && e.Target != filter.Target) {
+			continue
+		}
+		matched = append(matched, e)
+	}
+	return matched, nil
+}
+
+// Verify checks the hash chain and returns an error describing the first
+// entry that was altered, removed or reordered.
+func (l *Log) Verify() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries, err := l.read()
+	if err != nil {
+		return err
+	}
+	prev := ""
+	for i, e := range entries {
+		if e.PrevHash != prev {
+			return fmt.Errorf("entry %d: chain broken (previous entry missing or reordered)", i+1)
+		}
+		if e.digest() != e.Hash {
+			return fmt.Errorf("entry %d: contents do not match hash", i+1)
+		}
+		prev = e.Hash
+	}
+	return nil
+}
+
+func (l *Log) read() ([]Entry, error) {
+	f, err := os.Open(l.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", l.Path, line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+func (e Entry) digest() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,131 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+func record(t *testing.T, l *Log, actor, action, target string) {
+	t.Helper()
+	if err := l.Record(actor, action, target, []Change{{Field: "AnomalyThreshold", Old: "3", New: "4"}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHashChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record(t, l, "alice", ActionCreate, "web")
+	record(t, l, "bob", ActionThreshold, "web")
+	// Another process appending to the same log continues the chain.
+	other, _ := Open(path)
+	record(t, other, "daemon", ActionUpdate, "db")
+	record(t, l, "alice", ActionDelete, "db")
+
+	if err := l.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := l.Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 || entries[0].PrevHash != "" {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i].PrevHash != entries[i-1].Hash {
+			t.Errorf("entry %d does not chain to entry %d", i+1, i)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+	tampered := map[string]string{
+		"altered":   strings.Replace(string(data), `"actor":"bob"`, `"actor":"eve"`, 1),
+		"removed":   lines[0] + lines[2] + lines[3],
+		"reordered": lines[0] + lines[2] + lines[1] + lines[3],
+		"truncated": lines[1] + lines[2] + lines[3],
+	}
+	for name, contents := range tampered {
+		tamperedPath := filepath.Join(t.TempDir(), name+".jsonl")
+		if err := os.WriteFile(tamperedPath, []byte(strings.TrimSuffix(contents, "\n")+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := (&Log{Path: tamperedPath}).Verify(); err == nil {
+			t.Errorf("expected the %s log rejected", name)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	l, err := Open(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := l.Query(Filter{}); err != nil || len(entries) != 0 {
+		t.Fatalf("expected an empty log, got %v, %v", entries, err)
+	}
+	record(t, l, "alice", ActionCreate, "web")
+	record(t, l, "bob", ActionThreshold, "web")
+	record(t, l, "alice", ActionCreate, "db")
+
+	tests := []struct {
+		filter Filter
+		want   int
+	}{
+		{Filter{Actor: "alice"}, 2},
+		{Filter{Action: ActionCreate, Target: "db"}, 1},
+		{Filter{Target: "web"}, 2},
+		{Filter{Since: time.Now().Add(time.Hour)}, 0},
+	}
+	for _, tt := range tests {
+		if entries, _ := l.Query(tt.filter); len(entries) != tt.want {
+			t.Errorf("Query(%+v) = %d entries, want %d", tt.filter, len(entries), tt.want)
+		}
+	}
+}
+
+func TestDiffBaselines(t *testing.T) {
+	old := baseline.NewLearner().CreateBaseline("web")
+	old.RecordObservation("syscall", "open", 10)
+	old.RecordObservation("syscall", "close", 10)
+	new := baseline.NewLearner().CreateBaseline("web")
+	new.RecordObservation("syscall", "open", 12)
+	new.RecordObservation("network", "10.0.0.1:443", 1)
+	new.AnomalyThreshold = old.AnomalyThreshold + 1
+
+	fields := make(map[string]Change)
+	for _, c := range DiffBaselines(old, new) {
+		fields[c.Field] = c
+	}
+	if c := fields["AnomalyThreshold"]; c.Old == "" || c.New == "" {
+		t.Errorf("expected the threshold change, got %+v", c)
+	}
+	if c := fields["Stats.syscall:close"]; c.Old == "" || c.New != "" {
+		t.Errorf("expected the removed stat, got %+v", c)
+	}
+	if c := fields["Stats.network:10.0.0.1:443"]; c.Old != "" || c.New == "" {
+		t.Errorf("expected the added stat, got %+v", c)
+	}
+	if c := fields["Stats.syscall:open"]; c.Old == "" || c.New == "" {
+		t.Errorf("expected the changed stat, got %+v", c)
+	}
+
+	redacted := Redact(DiffBaselines(old, new), func(key string) string { return "tag" })
+	for _, c := range redacted {
+		if strings.Contains(c.Field, "syscall") || strings.Contains(c.Old+c.New, "mean=") {
+			t.Errorf("expected %+v redacted", c)
+		}
+	}
+}
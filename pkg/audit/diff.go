@@ -0,0 +1,204 @@
+package audit
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+// DiffBaselines describes what changed between two versions of a baseline.
+// A nil old baseline reports every stat as added.
+func DiffBaselines(old, new *baseline.Baseline) []Change {
+	var changes []Change
+	if old == nil {
+		old = &baseline.Baseline{}
+	}
+
+	if old.AnomalyThreshold != new.AnomalyThreshold {
+		changes = append(changes, Change{
+			Field: "AnomalyThreshold",
+			Old:   formatFloat(old.AnomalyThreshold),
+			New:   formatFloat(new.AnomalyThreshold),
+		})
+	}
+
+	overridden := make(map[string]bool)
+	for key := range old.Thresholds {
+		overridden[key] = true
+	}
+	for key := range new.Thresholds {
+		overridden[key] = true
+	}
+	for _, key := range sortedKeys(overridden) {
+		before, hadBefore := old.Thresholds[key]
+		after, hasAfter := new.Thresholds[key]
+		if hadBefore != hasAfter || before != after {
+			c := Change{Field: "Thresholds." + key}
+			if hadBefore {
+				c.Old = formatFloat(before)
+			}
+			if hasAfter {
+				c.New = formatFloat(after)
+			}
+			changes = append(changes, c)
+		}
+	}
+
+	changes = append(changes, diffOverrides(old.Overrides, new.Overrides)...)
+
+	keys := make(map[string]bool)
+	for key := range old.Stats {
+		keys[key] = true
+	}
+	for key := range new.Stats {
+		keys[key] = true
+	}
+	for _, key := range sortedKeys(keys) {
+		before, hadBefore := old.Stats[key]
+		after, hasAfter := new.Stats[key]
+		switch {
+		case !hadBefore:
+			changes = append(changes, Change{Field: "Stats." + key, New: formatStat(after)})
+		case !hasAfter:
+			changes = append(changes, Change{Field: "Stats." + key, Old: formatStat(before)})
+		case before.SampleCount != after.SampleCount || before.Mean != after.Mean || before.StdDev != after.StdDev:
+			changes = append(changes, Change{Field: "Stats." + key, Old: formatStat(before), New: formatStat(after)})
+		}
+	}
+
+	if len(old.Pending) != len(new.Pending) {
+		changes = append(changes, Change{
+			Field: "Pending",
+			Old:   fmt.Sprint(len(old.Pending)),
+			New:   fmt.Sprint(len(new.Pending)),
+		})
+	}
+	return changes
+}
+
+// Summarize condenses diff, the changes from old to new, to the number of
+// stats before and after and how many of them changed, for learning
+// updates too frequent to audit key by key.
+func Summarize(old, new *baseline.Baseline, diff []Change) []Change {
+	before := 0
+	if old != nil {
+		before = len(old.Stats)
+	}
+	changed := 0
+	for _, c := range diff {
+		if strings.HasPrefix(c.Field, "Stats.") {
+			changed++
+		}
+	}
+	return []Change{
+		{Field: "Stats", Old: fmt.Sprint(before), New: fmt.Sprint(len(new.Stats))},
+		{Field: "StatsChanged", New: fmt.Sprint(changed)},
+	}
+}
+
+// Redacted replaces values Redact removes from a change.
+const Redacted = "[redacted]"
+
+// Redact returns a copy of diff without the baseline contents it reveals,
+// for baselines encrypted at rest: the stat keys of Stats, Thresholds and
+// Overrides changes are replaced by tag(key), which still tells changes
+// to the same key apart, and the stat and override values by Redacted.
+// Threshold values and counts are kept.
+func Redact(diff []Change, tag func(string) string) []Change {
+	redacted := make([]Change, len(diff))
+	for i, c := range diff {
+		field, key, keyed := strings.Cut(c.Field, ".")
+		switch {
+		case !keyed:
+		case field == "Thresholds":
+			c.Field = field + "." + tag(key)
+		case field == "Stats" || field == "Overrides":
+			c.Field = field + "." + tag(key)
+			c.Old, c.New = redact(c.Old), redact(c.New)
+		}
+		redacted[i] = c
+	}
+	return redacted
+}
+
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	return Redacted
+}
+
+// diffOverrides reports overrides added, changed and removed, by key.
+func diffOverrides(old, new []baseline.Override) []Change {
+	before, after := make(map[string]string), make(map[string]string)
+	keys := make(map[string]bool)
+	for _, o := range old {
+		before[o.Key], keys[o.Key] = o.String(), true
+	}
+	for _, o := range new {
+		after[o.Key], keys[o.Key] = o.String(), true
+	}
+	var changes []Change
+	for _, key := range sortedKeys(keys) {
+		if before[key] != after[key] {
+			changes = append(changes, Change{Field: "Overrides." + key, Old: before[key], New: after[key]})
+		}
+	}
+	return changes
+}
+
+// DiffLines reports removed and added lines between two texts, such as
+// configuration files.
+func DiffLines(old, new string) []Change {
+	oldLines := strings.Split(old, "\n")
+	newLines := strings.Split(new, "\n")
+	counts := make(map[string]int)
+	for _, line := range newLines {
+		counts[line]++
+	}
+
+	var changes []Change
+	for _, line := range oldLines {
+		if counts[line] > 0 {
+			counts[line]--
+			continue
+		}
+		if strings.TrimSpace(line) != "" {
+			changes = append(changes, Change{Field: "line", Old: line})
+		}
+	}
+
+	remaining := make(map[string]int)
+	for _, line := range oldLines {
+		remaining[line]++
+	}
+	for _, line := range newLines {
+		if remaining[line] > 0 {
+			remaining[line]--
+			continue
+		}
+		if strings.TrimSpace(line) != "" {
+			changes = append(changes, Change{Field: "line", New: line})
+		}
+	}
+	return changes
+}
+
+func formatStat(s baseline.Stat) string {
+	return fmt.Sprintf("mean=%s stddev=%s n=%d", formatFloat(s.Mean), formatFloat(s.StdDev), s.SampleCount)
+}
+
+func formatFloat(f float64) string {
+	return fmt.Sprintf("%.4g", f)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -2,8 +2,17 @@
 package baseline
 
 import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
 	"regexp"
+	"strings"
 	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/clock"
+	"github.com/hallucinaut/runtimebase/pkg/logfile"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
 )
 
 // BehaviorPattern represents a detected behavioral pattern.
@@ -15,14 +24,190 @@ type BehaviorPattern struct {
 	Threshold   float64
 }
 
+// MarshalJSON encodes the pattern with its regular expression as a string.
+func (p BehaviorPattern) MarshalJSON() ([]byte, error) {
+	type plain BehaviorPattern
+	regex := ""
+	if p.Regex != nil {
+		regex = p.Regex.String()
+	}
+	return json.Marshal(struct {
+		plain
+		Regex string
+	}{plain(p), regex})
+}
+
+// UnmarshalJSON decodes a pattern encoded by MarshalJSON.
+func (p *BehaviorPattern) UnmarshalJSON(data []byte) error {
+	type plain BehaviorPattern
+	var decoded struct {
+		plain
+		Regex string
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*p = BehaviorPattern(decoded.plain)
+	p.Regex = nil
+	if decoded.Regex != "" {
+		re, err := regexp.Compile(decoded.Regex)
+		if err != nil {
+			return err
+		}
+		p.Regex = re
+	}
+	return nil
+}
+
 // Baseline represents learned runtime behavior.
 type Baseline struct {
-	Name           string
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	Patterns       []BehaviorPattern
-	Stats          map[string]Stat
+	// SchemaVersion is the version of the format the baseline was stored
+	// in. See Migrate.
+	SchemaVersion int
+	Name          string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+
+	// Metadata are labels of the workload the baseline was learned from,
+	// such as its owner, environment, git SHA or image digest. They are
+	// set when learning, select baselines to list and are added to the
+	// anomalies the baseline raises. See SetMetadata.
+	Metadata map[string]string `json:",omitempty"`
+
+	Patterns         []BehaviorPattern
+	Stats            map[string]Stat
 	AnomalyThreshold float64
+
+	// Thresholds overrides AnomalyThreshold for individual statistic keys.
+	// See ThresholdFor.
+	Thresholds map[string]float64 `json:",omitempty"`
+
+	// MinSampleCount is how many samples a key needs before deviations
+	// from it are reported as anomalies; 0 uses DefaultMinSamples.
+	// MinSamples overrides it for individual keys. See MinSamplesFor.
+	MinSampleCount int            `json:",omitempty"`
+	MinSamples     map[string]int `json:",omitempty"`
+
+	// ColdStart, when positive, is how much event time the baseline
+	// learns before it detects in full; see InColdStart. LearnedSince is
+	// the start of the first interval of event time it learned from
+	// timestamped observations, unset for baselines learned only from
+	// untimed samples.
+	ColdStart    time.Duration `json:",omitempty"`
+	LearnedSince time.Time     `json:",omitempty"`
+
+	// Directions limits the keys, or patterns across their labeled
+	// segments, that alert only on increases or decreases. See
+	// DirectionFor.
+	Directions map[string]Direction `json:",omitempty"`
+
+	// Overrides are time-boxed exceptions for expected behavior. See
+	// FilterOverrides.
+	Overrides []Override `json:",omitempty"`
+
+	// Reference is the previous baseline used to screen learning data,
+	// saved with the statistics and thresholds screening needs.
+	// Observations that look anomalous against it are quarantined in
+	// Pending until approved with ApprovePending or dropped with
+	// RejectPending.
+	Reference *Baseline     `json:",omitempty"`
+	Pending   []Observation `json:",omitempty"`
+
+	// Integrity maps file paths to the content hashes seen for them.
+	Integrity map[string]FileIntegrity
+
+	// Volume holds byte volume statistics per file path and network
+	// destination, keyed like "file:/var/lib/db". See RecordVolumes.
+	Volume map[string]Stat `json:",omitempty"`
+
+	// Gaps holds, per statistic key, the seconds between the learning
+	// intervals in which the key was active, in event time; LastSeen is
+	// its latest activity. See RecordActivity and Silences.
+	Gaps map[string]Stat `json:",omitempty"`
+
+	// BucketWidth is the interval of event time whose timestamped
+	// observations form one sample; 0 uses DefaultBucketWidth. Lateness is
+	// how long after a bucket ends its observations are still accepted.
+	// Open holds the buckets not yet closed, Watermark the latest
+	// observation time and Closed the end of the last closed bucket;
+	// observations before it are too late and are counted in Late. See
+	// RecordObservationAt.
+	BucketWidth time.Duration `json:",omitempty"`
+	Lateness    time.Duration `json:",omitempty"`
+	Open        []Bucket      `json:",omitempty"`
+	Watermark   time.Time
+	Closed      time.Time
+	Late        int      `json:",omitempty"`
+	closed      []Bucket // closed since the last TakeClosed
+
+	// Resource holds the peak per interval of resource measurements such
+	// as pressure stall averages, keyed like "resource:cpu.pressure". See
+	// RecordResources.
+	Resource map[string]Stat `json:",omitempty"`
+
+	// LabelPolicy limits which labels segment statistics; nil uses
+	// DefaultLabelPolicy. LabelValues tracks the values admitted so far.
+	LabelPolicy *LabelPolicy
+	LabelValues map[string][]string
+
+	// CandidateFor names the active baseline this one is a candidate
+	// replacement for, learned in shadow mode; empty for active baselines.
+	CandidateFor string `json:",omitempty"`
+
+	// Namespace is the tenant the baseline belongs to; empty for the
+	// default namespace. Baselines of different namespaces may share a
+	// name.
+	Namespace string `json:",omitempty"`
+
+	// Checkpoints maps the files learned with Learner.LearnFromFile to
+	// the position learning reached in each.
+	Checkpoints map[string]logfile.Offset `json:",omitempty"`
+
+	// Graph is the behavior graph, keyed by Edge.Key: which processes
+	// spawn which, and the destinations and files each connects to and
+	// writes. See RecordEdges.
+	Graph map[string]Edge `json:",omitempty"`
+
+	// Exits holds how each executable, or container, has been seen to
+	// exit and restart. See RecordExits.
+	Exits map[string]ExitProfile `json:",omitempty"`
+
+	// TLS holds the TLS client fingerprints each process has been seen
+	// to use, and for which destinations. See RecordTLS.
+	TLS map[string]TLSProfile `json:",omitempty"`
+
+	// Sequences holds the order in which each process has been seen to
+	// perform its operations. See RecordSequences.
+	Sequences map[string]SequenceProfile `json:",omitempty"`
+
+	clock clock.Clock
+}
+
+// now returns the baseline clock's time. Baselines loaded from storage use
+// the system clock until SetClock is called.
+func (b *Baseline) now() time.Time {
+	if b.clock == nil {
+		return time.Now()
+	}
+	return b.clock.Now()
+}
+
+// SetClock sets the clock used for the baseline's timestamps.
+func (b *Baseline) SetClock(c clock.Clock) {
+	b.clock = c
+}
+
+// Observation is a single learning sample held for manual approval.
+type Observation struct {
+	Category string
+	Pattern  string
+	Labels   map[string]string
+	Count    int
+	// Time is when, in event time, the observation was made; zero for
+	// observations of the present. See RecordObservationAt.
+	Time     time.Time
+	ZScore   float64
+	QueuedAt time.Time
 }
 
 // Stat represents statistical data for a pattern.
@@ -32,70 +217,402 @@ type Stat struct {
 	Min         float64
 	Max         float64
 	SampleCount int
+	M2          float64 // sum of squared deviations, for incremental StdDev
+	LastSeen    time.Time
+	Seeded      bool // expected from static analysis, not yet observed
 }
 
 // Anomaly represents a detected behavioral anomaly.
 type Anomaly struct {
-	Type         string
-	Description  string
-	Severity     string
-	Evidence     string
-	Confidence   float64
-	Timestamp    time.Time
-	RiskLevel    string
+	Type        string
+	Description string
+	Severity    string
+	Evidence    string
+	// Category is the category of the behavior the anomaly reports, such
+	// as "syscall" for a syscall rate, when it reports one; Evidence may
+	// then hold only the pattern. See Anomaly.Behavior.
+	Category    string `json:",omitempty"`
+	Confidence  float64
+	Timestamp   time.Time
+	RiskLevel   string
+	PID         int
+	Process     string
+	Lineage     []Process         // offending process first, then its ancestors
+	Enrichment  map[string]string `json:",omitempty"` // network peer reputation, see package enrich
+	Remediation *Remediation      `json:",omitempty"` // runbook, owner and automated response
+	// FleetBaselines is how many other baselines have seen the pattern
+	// this anomaly reports as new; see Index.Adjust.
+	FleetBaselines int `json:",omitempty"`
+	// GraphContext holds learned edges around the behavior graph edge this
+	// anomaly reports as new; see Baseline.EdgeAnomaly.
+	GraphContext []Edge `json:",omitempty"`
+	// Incident is set on anomalies standing for a group of related ones;
+	// see Clusterer.
+	Incident *Incident `json:",omitempty"`
+	// Recurrence is set on anomalies the escalation processor tracks; see
+	// Escalator.
+	Recurrence *Recurrence `json:",omitempty"`
+	// TraceID and SpanID identify the distributed trace and span of the
+	// request that raised the anomaly, when its event carried them.
+	TraceID string `json:",omitempty"`
+	SpanID  string `json:",omitempty"`
+	// Capture is the file holding the raw events that led up to the
+	// anomaly, when the capture processor wrote one.
+	Capture string `json:",omitempty"`
+	// Metadata is that of the baseline that raised the anomaly, for
+	// routing it; see Baseline.ApplyMetadata.
+	Metadata map[string]string `json:",omitempty"`
+	// ColdStart is set on anomalies raised by a baseline in cold start;
+	// see Baseline.FilterColdStart.
+	ColdStart bool `json:",omitempty"`
+}
+
+// InsufficientData is the Type of results DetectAnomaly returns instead of
+// an anomaly when the observation deviates from a key learned from fewer
+// samples than MinSamplesFor: too few to tell whether it is anomalous.
+const InsufficientData = "Insufficient Data"
+
+// SplitInsufficient separates InsufficientData results from anomalies.
+func SplitInsufficient(results []Anomaly) (anomalies, insufficient []Anomaly) {
+	for _, a := range results {
+		if a.Type == InsufficientData {
+			insufficient = append(insufficient, a)
+		} else {
+			anomalies = append(anomalies, a)
+		}
+	}
+	return anomalies, insufficient
+}
+
+// Process is one link in a process lineage.
+type Process struct {
+	PID     int
+	PPID    int
+	Exe     string
+	Cmdline string
 }
 
 // Learner learns runtime behavior patterns.
 type Learner struct {
 	baselines map[string]*Baseline
+	opts      options
 }
 
 // NewLearner creates a new behavior learner.
-func NewLearner() *Learner {
-	return &Learner{
+func NewLearner(opts ...Option) *Learner {
+	l := &Learner{
 		baselines: make(map[string]*Baseline),
+		opts:      defaultOptions(),
+	}
+	for _, opt := range opts {
+		opt(&l.opts)
 	}
+	return l
 }
 
-// CreateBaseline creates a new behavior baseline.
-func (l *Learner) CreateBaseline(name string) *Baseline {
-	baseline := &Baseline{
-		Name:           name,
-		CreatedAt:      time.Now(),
-		UpdatedAt:      time.Now(),
-		Patterns:       make([]BehaviorPattern, 0),
-		Stats:          make(map[string]Stat),
-		AnomalyThreshold: 3.0, // 3 standard deviations
+// CreateBaseline creates a new behavior baseline. Options override the
+// learner's threshold and clock for this baseline.
+func (l *Learner) CreateBaseline(name string, opts ...Option) *Baseline {
+	o := l.opts
+	for _, opt := range opts {
+		opt(&o)
 	}
+	baseline := newBaseline(name, o)
 	l.baselines[name] = baseline
+	o.logger.Debug("created baseline", "component", "baseline", "baseline", name, "threshold", o.threshold)
 	return baseline
 }
 
-// GetBaseline retrieves a baseline by name.
+func newBaseline(name string, o options) *Baseline {
+	now := o.clock.Now()
+	baseline := &Baseline{
+		SchemaVersion:    SchemaVersion,
+		Name:             name,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+		Patterns:         make([]BehaviorPattern, 0),
+		Stats:            make(map[string]Stat),
+		AnomalyThreshold: o.threshold,
+		MinSampleCount:   o.minSamples,
+		ColdStart:        o.coldStart,
+		BucketWidth:      o.bucketWidth,
+		Lateness:         o.lateness,
+		Namespace:        o.namespace,
+		clock:            o.clock,
+	}
+	return baseline
+}
+
+// Save persists the named baseline to the learner's storage.
+func (l *Learner) Save(name string) error {
+	if l.opts.storage == nil {
+		return fmt.Errorf("learner has no storage configured")
+	}
+	b := l.baselines[name]
+	if b == nil {
+		return fmt.Errorf("unknown baseline %q", name)
+	}
+	return l.opts.storage.SaveBaseline(b)
+}
+
+// AddBaseline registers an existing baseline, such as one loaded from
+// storage, replacing any baseline with the same name.
+func (l *Learner) AddBaseline(b *Baseline) {
+	l.baselines[b.Name] = b
+}
+
+// GetBaseline retrieves a baseline by name, loading it from the learner's
+// storage if it is not in memory.
 func (l *Learner) GetBaseline(name string) *Baseline {
-	return l.baselines[name]
+	if b, ok := l.baselines[name]; ok {
+		return b
+	}
+	if l.opts.storage == nil {
+		return nil
+	}
+	b, err := l.opts.storage.LoadBaseline(name)
+	if err != nil {
+		l.opts.logger.Debug("baseline not loaded", "component", "baseline", "baseline", name, "error", err)
+		return nil
+	}
+	b.clock = l.opts.clock
+	l.baselines[name] = b
+	return b
 }
 
-// RecordObservation records a behavioral observation.
+// Relearn starts a fresh baseline for name, screening its learning data
+// against the current one. The current baseline stays live for detection
+// until the new one has learned enough to replace it with AddBaseline;
+// without a current baseline, the new one is live at once.
+func (l *Learner) Relearn(name string) *Baseline {
+	previous := l.baselines[name]
+	if previous == nil {
+		return l.CreateBaseline(name)
+	}
+	baseline := newBaseline(name, l.opts)
+	baseline.Reference = &Baseline{
+		Name:             previous.Name,
+		Stats:            previous.Stats,
+		AnomalyThreshold: previous.AnomalyThreshold,
+		Thresholds:       previous.Thresholds,
+		Directions:       previous.Directions,
+	}
+	baseline.AnomalyThreshold = previous.AnomalyThreshold
+	baseline.Thresholds = previous.Thresholds
+	baseline.BucketWidth = previous.BucketWidth
+	l.opts.logger.Debug("relearning baseline", "component", "baseline", "baseline", name)
+	return baseline
+}
+
+// RecordObservation records a behavioral observation. When the baseline has
+// a reference, observations anomalous against it are quarantined instead.
 func (b *Baseline) RecordObservation(category, pattern string, count int) {
-	key := category + ":" + pattern
-	if _, exists := b.Stats[key]; !exists {
-		b.Stats[key] = Stat{}
+	b.RecordLabeledObservation(category, pattern, nil, count)
+}
+
+// RecordLabeledObservation records an observation into the statistics
+// segmented by labels, subject to the baseline's label policy.
+func (b *Baseline) RecordLabeledObservation(category, pattern string, labels map[string]string, count int) {
+	b.observe(Observation{Category: category, Pattern: pattern, Labels: labels, Count: count}, b.now())
+}
+
+// RecordObservations records a batch of observations. It is equivalent to
+// calling RecordLabeledObservation, or RecordObservationAt for those with
+// a Time, for each, but reads the clock once and only updates UpdatedAt at
+// the end, which matters at thousands of observations per second.
+func (b *Baseline) RecordObservations(observations []Observation) {
+	if len(observations) == 0 {
+		return
+	}
+	now := b.now()
+	timed := false
+	for _, o := range observations {
+		if o.Time.IsZero() {
+			b.observe(o, now)
+		} else {
+			b.observeAt(o)
+			timed = true
+		}
+	}
+	if timed {
+		b.closeBuckets(b.Watermark.Add(-b.Lateness))
+	}
+	b.UpdatedAt = now
+}
+
+func (b *Baseline) observe(o Observation, now time.Time) {
+	o.Labels = b.normalizeLabels(o.Labels)
+	b.observeKey(StatKey(o.Category, o.Pattern, o.Labels), o, now)
+}
+
+// observeKey records o under key, whose labels are already normalized.
+func (b *Baseline) observeKey(key string, o Observation, now time.Time) {
+	if b.Reference != nil {
+		if z, anomalous := b.Reference.deviation(key, float64(o.Count)); anomalous {
+			o.ZScore = z
+			o.QueuedAt = now
+			b.Pending = append(b.Pending, o)
+			return
+		}
+	}
+	b.recordAt(key, float64(o.Count), now)
+}
+
+// record folds a value into the statistics for a key.
+func (b *Baseline) record(key string, value float64) {
+	b.recordAt(key, value, b.now())
+}
+
+func (b *Baseline) recordAt(key string, value float64, now time.Time) {
+	b.Stats[key] = foldStat(b.Stats[key], value, now)
+	b.UpdatedAt = now
+}
+
+// foldStat returns stat with value added to its running statistics.
+func foldStat(stat Stat, value float64, now time.Time) Stat {
+	if stat.SampleCount == 0 {
+		stat.Min = value
+		stat.Max = value
 	}
-	stat := b.Stats[key]
 	stat.SampleCount++
-	b.Stats[key] = stat
+	delta := value - stat.Mean
+	stat.Mean += delta / float64(stat.SampleCount)
+	stat.M2 += delta * (value - stat.Mean)
+	if stat.SampleCount > 1 {
+		stat.StdDev = math.Sqrt(stat.M2 / float64(stat.SampleCount-1))
+	}
+	stat.Min = min(stat.Min, value)
+	stat.Max = max(stat.Max, value)
+	stat.LastSeen = now
+	stat.Seeded = false
+	return stat
 }
 
-// LearnFromFile learns from a log file.
-func (l *Learner) LearnFromFile(filepath string) error {
-	// Placeholder - would read and analyze log file
-	// In production: parse logs, extract patterns, update baselines
-	return nil
+// Seed marks a pattern as expected before it has been observed, for example
+// from static analysis of the binary. Existing stats are left unchanged.
+func (b *Baseline) Seed(category, pattern string) {
+	key := StatKey(category, pattern, nil)
+	if _, exists := b.Stats[key]; exists {
+		return
+	}
+	b.Stats[key] = Stat{Seeded: true}
+	b.UpdatedAt = b.now()
+}
+
+// deviation returns the z-score of value for key and whether it exceeds the
+// anomaly threshold. Unknown keys are not considered anomalous.
+func (b *Baseline) deviation(key string, value float64) (float64, bool) {
+	stat, exists := b.Stats[key]
+	if !exists {
+		return 0, false
+	}
+	if stat.StdDev == 0 {
+		return 0, !b.DirectionFor(key).IsNormal(value, stat.Mean, 0, 0) && stat.SampleCount > 1
+	}
+	z := CalculateZScore(value, stat.Mean, stat.StdDev)
+	return z, b.DirectionFor(key).Exceeds(z, b.ThresholdFor(key))
+}
+
+// ApprovePending merges quarantined observations into the baseline. With no
+// keys, all pending observations are merged. It returns the number merged.
+func (b *Baseline) ApprovePending(keys ...string) int {
+	return b.drainPending(keys, func(o Observation) {
+		b.record(StatKey(o.Category, o.Pattern, o.Labels), float64(o.Count))
+	})
+}
+
+// RejectPending discards quarantined observations. With no keys, all pending
+// observations are discarded. It returns the number discarded.
+func (b *Baseline) RejectPending(keys ...string) int {
+	return b.drainPending(keys, func(Observation) {})
+}
+
+func (b *Baseline) drainPending(keys []string, apply func(Observation)) int {
+	selected := make(map[string]bool)
+	for _, key := range keys {
+		selected[key] = true
+	}
+
+	n := 0
+	kept := b.Pending[:0]
+	for _, o := range b.Pending {
+		if len(keys) > 0 && !selected[StatKey(o.Category, o.Pattern, o.Labels)] {
+			kept = append(kept, o)
+			continue
+		}
+		apply(o)
+		n++
+	}
+	b.Pending = kept
+	return n
+}
+
+// LearnFromFile learns the observations in a JSON lines file, one per
+// line, e.g. {"category":"syscall","pattern":"openat","count":12}, into
+// the named baseline, creating it if needed, and returns how many were
+// learned. Lines with a "time" are counted into the bucket of event time
+// they belong to, as by RecordObservationAt, so buckets still open when
+// the file ends close on a later run. Only lines added since the last
+// call are read: the position reached is checkpointed in the baseline's
+// Checkpoints, saved together with the statistics when the learner has
+// storage, so repeated runs and restarts never count a line twice. A
+// rotated or truncated file is read from the start. Malformed lines are
+// logged and skipped.
+func (l *Learner) LearnFromFile(name, path string) (int, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return 0, err
+	}
+	b := l.GetBaseline(name)
+	if b == nil {
+		b = l.CreateBaseline(name)
+	}
+
+	var batch []Observation
+	lineNo := 0
+	offset, err := logfile.ReadFrom(abs, b.Checkpoints[abs], func(line string) error {
+		lineNo++
+		if strings.TrimSpace(line) == "" {
+			return nil
+		}
+		var o Observation
+		if err := json.Unmarshal([]byte(line), &o); err != nil || o.Category == "" || o.Pattern == "" {
+			l.opts.logger.Warn("skipping malformed observation", "component", "baseline", "file", abs, "line", lineNo)
+			return nil
+		}
+		batch = append(batch, Observation{Category: o.Category, Pattern: o.Pattern, Labels: o.Labels, Count: o.Count, Time: o.Time})
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if offset == b.Checkpoints[abs] {
+		return 0, nil
+	}
+
+	b.RecordObservations(batch)
+	if b.Checkpoints == nil {
+		b.Checkpoints = make(map[string]logfile.Offset)
+	}
+	b.Checkpoints[abs] = offset
+	b.UpdatedAt = b.now()
+	if l.opts.storage == nil {
+		return len(batch), nil
+	}
+	if s, ok := l.opts.storage.(interface{ SaveLearned(*Baseline) error }); ok {
+		return len(batch), s.SaveLearned(b)
+	}
+	return len(batch), l.opts.storage.SaveBaseline(b)
 }
 
 // DetectAnomaly detects anomalies against baseline.
 func (l *Learner) DetectAnomaly(name, category, pattern string, count int) []Anomaly {
+	return l.DetectLabeledAnomaly(name, category, pattern, nil, count)
+}
+
+// DetectLabeledAnomaly detects anomalies against the statistics for the
+// given label segment.
+func (l *Learner) DetectLabeledAnomaly(name, category, pattern string, labels map[string]string, count int) []Anomaly {
 	var anomalies []Anomaly
 
 	baseline := l.GetBaseline(name)
@@ -103,22 +620,36 @@ func (l *Learner) DetectAnomaly(name, category, pattern string, count int) []Ano
 		return anomalies
 	}
 
-	key := category + ":" + pattern
+	key := StatKey(category, pattern, baseline.lookupLabels(labels))
 	stat, exists := baseline.Stats[key]
 
-	if exists {
+	// Seeded stats have no observations to compare against yet.
+	if exists && stat.SampleCount > 0 {
 		// Calculate z-score
 		zScore := (float64(count) - stat.Mean) / stat.StdDev
 
-		if zScore > baseline.AnomalyThreshold || zScore < -baseline.AnomalyThreshold {
+		if baseline.DirectionFor(key).Exceeds(zScore, baseline.ThresholdFor(key)) {
+			if minSamples := baseline.MinSamplesFor(key); stat.SampleCount < minSamples {
+				anomalies = append(anomalies, Anomaly{
+					Type:        InsufficientData,
+					Description: fmt.Sprintf("Observed behavior deviates from a key learned from only %d of %d samples", stat.SampleCount, minSamples),
+					Severity:    severity.Label(severity.Low),
+					Evidence:    pattern,
+					Category:    category,
+					Timestamp:   baseline.now(),
+					RiskLevel:   severity.Label(severity.Low),
+				})
+				return anomalies
+			}
 			anomalies = append(anomalies, Anomaly{
-				Type:         "Behavioral Anomaly",
-				Description:  "Observed behavior deviates from baseline",
-				Severity:     getSeverity(zScore),
-				Evidence:     pattern,
-				Confidence:   calculateConfidence(zScore),
-				Timestamp:    time.Now(),
-				RiskLevel:    getRiskLevel(zScore),
+				Type:        "Behavioral Anomaly",
+				Description: "Observed behavior deviates from baseline",
+				Severity:    getSeverity(zScore),
+				Evidence:    pattern,
+				Category:    category,
+				Confidence:  calculateConfidence(zScore),
+				Timestamp:   baseline.now(),
+				RiskLevel:   getRiskLevel(zScore),
 			})
 		}
 	}
@@ -128,14 +659,7 @@ func (l *Learner) DetectAnomaly(name, category, pattern string, count int) []Ano
 
 // GetSeverity returns severity based on z-score.
 func getSeverity(zScore float64) string {
-	if zScore > 5 {
-		return "CRITICAL"
-	} else if zScore > 3 {
-		return "HIGH"
-	} else if zScore > 2 {
-		return "MEDIUM"
-	}
-	return "LOW"
+	return severity.ForZScore(zScore)
 }
 
 // CalculateConfidence calculates anomaly confidence.
@@ -150,25 +674,19 @@ func calculateConfidence(zScore float64) float64 {
 
 // GetRiskLevel returns risk level based on z-score.
 func getRiskLevel(zScore float64) string {
-	if zScore > 5 {
-		return "CRITICAL"
-	} else if zScore > 3 {
-		return "HIGH"
-	} else if zScore > 2 {
-		return "MEDIUM"
-	}
-	return "LOW"
+	return severity.ForZScore(zScore)
 }
 
 // UpdateBaseline updates baseline statistics.
 func (b *Baseline) UpdateBaseline(category, pattern string, count float64) {
-	key := category + ":" + pattern
+	key := StatKey(category, pattern, nil)
 	if _, exists := b.Stats[key]; !exists {
 		b.Stats[key] = Stat{
-			Mean:  count,
-			StdDev: 0,
-			Min:   count,
-			Max:   count,
+			Mean:     count,
+			StdDev:   0,
+			Min:      count,
+			Max:      count,
+			LastSeen: b.now(),
 		}
 		return
 	}
@@ -177,9 +695,76 @@ func (b *Baseline) UpdateBaseline(category, pattern string, count float64) {
 	stat.Mean = (stat.Mean*float64(stat.SampleCount) + count) / float64(stat.SampleCount+1)
 	stat.Max = max(stat.Max, count)
 	stat.Min = min(stat.Min, count)
+	stat.LastSeen = b.now()
 	b.Stats[key] = stat
 }
 
+// PruneStats removes statistics not observed since before now-maxAge and
+// returns the number removed. Stats without a LastSeen time are kept. The
+// Gaps of removed keys go with them.
+func (b *Baseline) PruneStats(maxAge time.Duration, now time.Time) int {
+	cutoff := now.Add(-maxAge)
+	pruned := 0
+	for key, stat := range b.Stats {
+		if !stat.LastSeen.IsZero() && stat.LastSeen.Before(cutoff) {
+			delete(b.Stats, key)
+			pruned++
+		}
+	}
+	for key, stat := range b.Volume {
+		if !stat.LastSeen.IsZero() && stat.LastSeen.Before(cutoff) {
+			delete(b.Volume, key)
+			pruned++
+		}
+	}
+	for key, stat := range b.Resource {
+		if !stat.LastSeen.IsZero() && stat.LastSeen.Before(cutoff) {
+			delete(b.Resource, key)
+			pruned++
+		}
+	}
+	for executable, profile := range b.Exits {
+		if last := profile.Restarts.LastSeen; !last.IsZero() && last.Before(cutoff) {
+			delete(b.Exits, executable)
+			pruned++
+		}
+	}
+	for process, profile := range b.TLS {
+		if !profile.LastSeen.IsZero() && profile.LastSeen.Before(cutoff) {
+			delete(b.TLS, process)
+			pruned++
+		}
+	}
+	for process, profile := range b.Sequences {
+		if !profile.LastSeen.IsZero() && profile.LastSeen.Before(cutoff) {
+			delete(b.Sequences, process)
+			pruned++
+		}
+	}
+	for key := range b.Gaps {
+		if _, ok := b.Stats[key]; !ok {
+			delete(b.Gaps, key)
+		}
+	}
+	return pruned
+}
+
+// CategoryTotals returns the expected event count per category, summing the
+// mean of every pattern in the category.
+func (b *Baseline) CategoryTotals() map[string]int {
+	totals := make(map[string]float64)
+	for key, stat := range b.Stats {
+		category, _, _ := strings.Cut(key, ":")
+		totals[category] += stat.Mean
+	}
+
+	rounded := make(map[string]int, len(totals))
+	for category, total := range totals {
+		rounded[category] = int(math.Round(total))
+	}
+	return rounded
+}
+
 // GetAnomalyReport generates anomaly report.
 func GetAnomalyReport(anomalies []Anomaly) map[string]interface{} {
 	report := map[string]interface{}{
@@ -196,13 +781,10 @@ func GetAnomalyReport(anomalies []Anomaly) map[string]interface{} {
 	return report
 }
 
-// IsNormal checks if behavior is within normal range.
+// IsNormal checks if behavior is within normal range in both directions.
+// Direction.IsNormal checks one direction.
 func IsNormal(count, mean, stddev, threshold float64) bool {
-	if stddev == 0 {
-		return count == mean
-	}
-	zScore := (count - mean) / stddev
-	return zScore <= threshold && zScore >= -threshold
+	return DirectionBoth.IsNormal(count, mean, stddev, threshold)
 }
 
 // CalculateZScore calculates z-score for anomaly detection.
@@ -211,4 +793,4 @@ func CalculateZScore(value, mean, stddev float64) float64 {
 		return 0
 	}
 	return (value - mean) / stddev
-}
\ No newline at end of file
+}
@@ -0,0 +1,1086 @@
+package baseline
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/clock"
+)
+
+func TestRelearnQuarantinesAnomalousObservations(t *testing.T) {
+	learner := NewLearner()
+	b := learner.CreateBaseline("myapp")
+	for _, count := range []int{98, 100, 102, 99, 101} {
+		b.RecordObservation("syscall", "open", count)
+	}
+
+	candidate := learner.Relearn("myapp")
+	candidate.RecordObservation("syscall", "open", 100)
+	candidate.RecordObservation("syscall", "open", 5000)
+
+	if len(candidate.Pending) != 1 {
+		t.Fatalf("expected 1 pending observation, got %d", len(candidate.Pending))
+	}
+	if got := candidate.Stats["syscall:open"].SampleCount; got != 1 {
+		t.Errorf("expected 1 merged sample, got %d", got)
+	}
+
+	if n := candidate.ApprovePending("syscall:open"); n != 1 {
+		t.Errorf("expected 1 approved observation, got %d", n)
+	}
+	if got := candidate.Stats["syscall:open"].SampleCount; got != 2 {
+		t.Errorf("expected 2 samples after approval, got %d", got)
+	}
+	if len(candidate.Pending) != 0 {
+		t.Errorf("expected empty pending set, got %d", len(candidate.Pending))
+	}
+}
+
+func TestRelearnKeepsPreviousBaselineLive(t *testing.T) {
+	learner := NewLearner()
+	b := learner.CreateBaseline("myapp")
+	for _, count := range []int{98, 100, 102, 99, 101} {
+		b.RecordObservation("syscall", "open", count)
+	}
+
+	candidate := learner.Relearn("myapp")
+	if learner.GetBaseline("myapp") != b {
+		t.Fatal("expected the previous baseline live while relearning")
+	}
+	if anomalies := learner.DetectAnomaly("myapp", "syscall", "open", 5000); len(anomalies) == 0 {
+		t.Error("expected detection against the previous baseline while relearning")
+	}
+
+	candidate.RecordObservation("syscall", "open", 100)
+	learner.AddBaseline(candidate)
+	if learner.GetBaseline("myapp") != candidate {
+		t.Error("expected the relearned baseline live once added")
+	}
+
+	if fresh := learner.Relearn("other"); learner.GetBaseline("other") != fresh || fresh.Reference != nil {
+		t.Error("expected a baseline relearned from scratch live at once")
+	}
+}
+
+func TestLabelCardinalityLimit(t *testing.T) {
+	b := NewLearner().CreateBaseline("myapp")
+	b.LabelPolicy = &LabelPolicy{Allowed: []string{"tenant"}, MaxValues: map[string]int{"tenant": 2}}
+
+	for _, tenant := range []string{"a", "b", "c", "d"} {
+		b.RecordLabeledObservation("network", "connect", map[string]string{"tenant": tenant, "pod": "x"}, 1)
+	}
+
+	for _, key := range []string{
+		"network:connect{tenant=a}",
+		"network:connect{tenant=b}",
+		"network:connect{tenant=" + OverflowLabelValue + "}",
+	} {
+		if _, ok := b.Stats[key]; !ok {
+			t.Errorf("expected stat %s", key)
+		}
+	}
+	if n := b.Stats["network:connect{tenant="+OverflowLabelValue+"}"].SampleCount; n != 2 {
+		t.Errorf("expected 2 overflow samples, got %d", n)
+	}
+
+	category, pattern, labels := ParseStatKey("network:10.0.0.1:443{tenant=a}")
+	if category != "network" || pattern != "10.0.0.1:443" || labels["tenant"] != "a" {
+		t.Errorf("unexpected parse: %s %s %v", category, pattern, labels)
+	}
+}
+
+func TestDetectionLeavesLabelBudget(t *testing.T) {
+	learner := NewLearner()
+	b := learner.CreateBaseline("myapp")
+	b.LabelPolicy = &LabelPolicy{MaxValues: map[string]int{"tenant": 2}}
+	for i := 0; i < 5; i++ {
+		b.RecordLabeledObservation("network", "connect", map[string]string{"tenant": "a"}, 10)
+	}
+
+	// Detecting against unseen tenants must not use up the budget.
+	for _, tenant := range []string{"x", "y", "z"} {
+		learner.DetectLabeledAnomaly("myapp", "network", "connect", map[string]string{"tenant": tenant}, 10)
+	}
+	if got := b.LabelValues["tenant"]; len(got) != 1 || got[0] != "a" {
+		t.Fatalf("expected detection to leave the tenant values alone, got %v", got)
+	}
+
+	b.RecordLabeledObservation("network", "connect", map[string]string{"tenant": "b"}, 10)
+	if _, ok := b.Stats["network:connect{tenant=b}"]; !ok {
+		t.Error("expected a second tenant learned under its own key")
+	}
+	// Once the label is at its limit, detection looks unseen values up
+	// under the overflow value, as learning records them.
+	b.RecordLabeledObservation("network", "connect", map[string]string{"tenant": "c"}, 10)
+	b.RecordLabeledObservation("network", "connect", map[string]string{"tenant": "d"}, 10)
+	if anomalies := learner.DetectLabeledAnomaly("myapp", "network", "connect", map[string]string{"tenant": "e"}, 10); len(anomalies) != 0 {
+		t.Errorf("expected an unseen tenant past the limit matched to the overflow stat, got %+v", anomalies)
+	}
+	if got := b.LabelValues["tenant"]; len(got) != 2 {
+		t.Errorf("expected 2 tenant values, got %v", got)
+	}
+}
+
+func TestStatKeyRoundTrip(t *testing.T) {
+	tests := []struct {
+		category, pattern string
+		labels            map[string]string
+		key               string
+	}{
+		{"network", "10.0.0.1:443", nil, "network:10.0.0.1:443"},
+		{"network", "connect", map[string]string{"tenant": "a", "env": "prod"}, "network:connect{env=prod,tenant=a}"},
+		{"file", `C:\Windows\System32\drivers`, map[string]string{"user": "svc"}, `file:C:\Windows\System32\drivers{user=svc}`},
+		{"file", `C:\Temp\`, nil, `file:C:\\Temp\\`},
+		{"file", "/srv/{app}/config", nil, `file:/srv/\{app\}/config`},
+		{"file", "/srv/{app}", map[string]string{"job": "a{b}"}, `file:/srv/\{app\}{job=a\{b\}}`},
+		{"process", "/usr/bin/env", map[string]string{"args": "a=1,b=2", "k,=": `x\`}, `process:/usr/bin/env{args=a\=1\,b\=2,k\,\==x\\}`},
+		{"network", "connect", map[string]string{"tenant": ""}, "network:connect{tenant=}"},
+	}
+	for _, tt := range tests {
+		key := StatKey(tt.category, tt.pattern, tt.labels)
+		if key != tt.key {
+			t.Errorf("StatKey(%q, %q, %v) = %q, want %q", tt.category, tt.pattern, tt.labels, key, tt.key)
+		}
+		category, pattern, labels := ParseStatKey(key)
+		if category != tt.category || pattern != tt.pattern || len(labels) != len(tt.labels) {
+			t.Errorf("ParseStatKey(%q) = %q, %q, %v", key, category, pattern, labels)
+			continue
+		}
+		for name, value := range tt.labels {
+			if labels[name] != value {
+				t.Errorf("ParseStatKey(%q): label %q = %q, want %q", key, name, labels[name], value)
+			}
+		}
+	}
+
+	// Timed observations are quarantined under the key they were counted by.
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	learner := NewLearner()
+	b := learner.CreateBaseline("web")
+	labels := map[string]string{"query": "a=1,b={2}"}
+	for i := 0; i < 5; i++ {
+		b.RecordLabeledObservation("file", "/srv/{app}", labels, 10)
+	}
+	candidate := learner.Relearn("web")
+	candidate.RecordObservationAt("file", "/srv/{app}", labels, 500, start)
+	candidate.CloseBuckets()
+	if len(candidate.Pending) != 1 {
+		t.Fatalf("expected 1 pending observation, got %d", len(candidate.Pending))
+	}
+	if o := candidate.Pending[0]; o.Pattern != "/srv/{app}" || o.Labels["query"] != labels["query"] {
+		t.Errorf("quarantined %q %v", o.Pattern, o.Labels)
+	}
+	if n := candidate.ApprovePending(StatKey("file", "/srv/{app}", labels)); n != 1 {
+		t.Errorf("expected 1 approved observation, got %d", n)
+	}
+}
+
+func TestBehaviorPatternJSON(t *testing.T) {
+	pattern := BehaviorPattern{Name: "shell", Regex: regexp.MustCompile(`^/bin/(ba)?sh$`), Category: "process", NormalCount: 2, Threshold: 5}
+	data, err := json.Marshal(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Regex":"^/bin/(ba)?sh$"`) {
+		t.Errorf("expected the regex encoded as a string: %s", data)
+	}
+	var decoded BehaviorPattern
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Regex == nil || decoded.Regex.String() != pattern.Regex.String() || decoded.Name != "shell" || decoded.Threshold != 5 {
+		t.Errorf("decoded %+v", decoded)
+	}
+
+	if err := json.Unmarshal([]byte(`{"Name":"none"}`), &decoded); err != nil || decoded.Regex != nil {
+		t.Errorf("pattern without a regex: %+v, %v", decoded, err)
+	}
+	if err := json.Unmarshal([]byte(`{"Regex":"("}`), &decoded); err == nil {
+		t.Error("expected an invalid regex to be rejected")
+	}
+}
+
+func TestLearnerOptions(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	learner := NewLearner(WithClock(fake), WithThreshold(2))
+	b := learner.CreateBaseline("myapp")
+	if b.AnomalyThreshold != 2 {
+		t.Errorf("expected threshold 2, got %v", b.AnomalyThreshold)
+	}
+	if !b.CreatedAt.Equal(start) {
+		t.Errorf("expected CreatedAt %v, got %v", start, b.CreatedAt)
+	}
+
+	fake.Advance(time.Hour)
+	b.RecordObservation("syscall", "open", 10)
+	if got := b.Stats["syscall:open"].LastSeen; !got.Equal(start.Add(time.Hour)) {
+		t.Errorf("expected LastSeen from fake clock, got %v", got)
+	}
+
+	if err := learner.Save("myapp"); err == nil {
+		t.Error("expected Save without storage to fail")
+	}
+}
+
+func TestVolumeAnomalies(t *testing.T) {
+	b := NewLearner().CreateBaseline("db")
+	for _, bytes := range []int64{1 << 20, 1100 << 10, 900 << 10, 1 << 20} {
+		b.RecordVolumes(map[string]int64{"file:/var/lib/db/data": bytes, "network:10.0.0.9:443": 4096})
+	}
+
+	anomalies := b.VolumeAnomalies(map[string]int64{
+		"file:/var/lib/db/data": 100 << 20, // 100x the usual writes
+		"network:10.0.0.9:443":  4096,
+		"file:/tmp/new":         1 << 30, // never learned
+	})
+	if len(anomalies) != 1 {
+		t.Fatalf("expected one anomaly, got %+v", anomalies)
+	}
+	if a := anomalies[0]; a.Evidence != "file:/var/lib/db/data" || a.Severity != "CRITICAL" {
+		t.Errorf("unexpected anomaly %+v", a)
+	}
+
+	// Constant volumes have no deviation; a small multiple still stands out.
+	if got := b.VolumeAnomalies(map[string]int64{"network:10.0.0.9:443": 3 * 4096}); len(got) != 1 || got[0].Severity != "LOW" {
+		t.Errorf("expected a LOW anomaly for 3x a constant volume, got %+v", got)
+	}
+	if got := b.VolumeAnomalies(map[string]int64{"network:10.0.0.9:443": 5000}); len(got) != 0 {
+		t.Errorf("expected volumes under MinVolumeRatio to pass, got %+v", got)
+	}
+}
+
+func TestExitAnomalies(t *testing.T) {
+	b := NewLearner().CreateBaseline("web")
+	for _, exits := range []int{1, 2, 1, 2} {
+		b.RecordExits(map[string]map[string]int{"/usr/bin/worker": {"0": exits - 1, "1": 1}})
+	}
+
+	for _, status := range []string{"0", "1", ""} {
+		if a, found := b.ExitAnomaly("/usr/bin/worker", status); found {
+			t.Errorf("status %q: unexpected %+v", status, a)
+		}
+	}
+	if a, found := b.ExitAnomaly("/usr/bin/worker", "2"); !found || a.Severity != "MEDIUM" || a.Evidence != "exit:/usr/bin/worker:2" {
+		t.Errorf("new exit code: %+v", a)
+	}
+	if a, found := b.ExitAnomaly("/usr/bin/worker", "SIGSEGV"); !found || a.Type != "Process Crash" || a.Severity != "HIGH" {
+		t.Errorf("crash: %+v", a)
+	}
+
+	if _, found := b.RestartAnomaly("/usr/bin/worker", 2); found {
+		t.Error("expected a usual restart rate to pass")
+	}
+	if a, found := b.RestartAnomaly("/usr/bin/worker", 20); !found || a.Type != "Crash Loop" || a.Evidence != "restart:/usr/bin/worker" {
+		t.Errorf("crash loop: %+v", a)
+	}
+	if _, found := b.RestartAnomaly("/usr/bin/new", MinCrashLoopExits-1); found {
+		t.Error("expected exits under MinCrashLoopExits to pass")
+	}
+	if _, found := b.RestartAnomaly("/usr/bin/new", MinCrashLoopExits); !found {
+		t.Error("expected a never-exiting executable restarting to be reported")
+	}
+}
+
+func TestIntegrityAnomalies(t *testing.T) {
+	b := NewLearner().CreateBaseline("web")
+	b.RecordFileHash("/usr/bin/nginx", "aaa")
+	b.RecordFileHash("/usr/bin/nginx", "aaa")
+	b.RecordFileHash("/usr/bin/python3", "bbb")
+	b.RecordFileHash("/usr/bin/python3", "ccc")
+	b.RecordFileHash("", "ddd")
+	b.RecordFileHash("/usr/bin/empty", "")
+
+	if !b.Integrity["/usr/bin/nginx"].Stable() || b.Integrity["/usr/bin/python3"].Stable() {
+		t.Errorf("unexpected stability: %+v", b.Integrity)
+	}
+	if len(b.Integrity) != 2 {
+		t.Errorf("expected paths or hashes left empty to be ignored, got %+v", b.Integrity)
+	}
+
+	if a, found := b.CheckExecutedBinary("/usr/bin/nginx", "aaa"); found {
+		t.Errorf("learned hash: unexpected %+v", a)
+	}
+	if _, found := b.CheckExecutedBinary("/usr/bin/nginx", ""); found {
+		t.Error("expected events without a hash to pass")
+	}
+	if a, found := b.CheckExecutedBinary("/usr/bin/nginx", "eee"); !found || a.Type != "Binary Hash Changed" || a.Severity != "CRITICAL" || a.Evidence != "/usr/bin/nginx sha256=eee" {
+		t.Errorf("changed stable binary: %+v", a)
+	}
+	if a, found := b.CheckExecutedBinary("/usr/bin/python3", "eee"); !found || a.Type != "Binary Hash Changed" || a.Severity != "LOW" {
+		t.Errorf("changed unstable binary: %+v", a)
+	}
+	if a, found := b.CheckExecutedBinary("/tmp/x", "fff"); !found || a.Type != "Unknown Binary" || a.Severity != "HIGH" {
+		t.Errorf("unknown binary: %+v", a)
+	}
+}
+
+func TestTLSAnomalies(t *testing.T) {
+	b := NewLearner().CreateBaseline("web")
+	if _, found := b.TLSAnomaly(Handshake{"/usr/bin/app", "ja3:abc", "api.example.com"}); found {
+		t.Error("expected nothing reported before handshakes are learned")
+	}
+	b.RecordTLS(map[Handshake]int{
+		{"/usr/bin/app", "ja4:t13d_go", "api.example.com"}:    10,
+		{"/usr/bin/app", "ja4:t13d_go", "cdn.example.com"}:    2,
+		{"/usr/bin/curl", "ja4:t13d_curl", "api.example.com"}: 1,
+	})
+
+	if a, found := b.TLSAnomaly(Handshake{"/usr/bin/app", "ja4:t13d_go", "new.example.com"}); found {
+		t.Errorf("known fingerprint to a new destination: unexpected %+v", a)
+	}
+	if a, found := b.TLSAnomaly(Handshake{"/usr/bin/app", "ja4:t13d_py", "api.example.com"}); !found || a.Type != "New TLS Fingerprint" || a.Severity != "HIGH" || a.Evidence != "tls:/usr/bin/app:ja4:t13d_py" {
+		t.Errorf("new fingerprint: %+v", a)
+	}
+	if a, found := b.TLSAnomaly(Handshake{"/usr/bin/app", "ja4:t13d_curl", "api.example.com"}); !found || a.Severity != "MEDIUM" || !strings.Contains(a.Description, "/usr/bin/curl") {
+		t.Errorf("fingerprint of another process: %+v", a)
+	}
+
+	b.RecordTLS(map[Handshake]int{{"/usr/bin/app", "ja4:t13d_alt", "cdn.example.com"}: 1})
+	if a, found := b.TLSAnomaly(Handshake{"/usr/bin/app", "ja4:t13d_alt", "api.example.com"}); !found || a.Type != "TLS Fingerprint Change" || a.Evidence != "tls:/usr/bin/app:ja4:t13d_alt->api.example.com" {
+		t.Errorf("fingerprint change: %+v", a)
+	}
+}
+
+func TestResourceAnomalies(t *testing.T) {
+	b := NewLearner().CreateBaseline("web")
+	for _, pressure := range []float64{1, 2, 1.5, 2.5, 1} {
+		b.RecordResources(map[string]float64{"resource:cpu.pressure": pressure, "resource:memory.oom_kill": 0})
+	}
+
+	streaks := make(map[string]int)
+	high := map[string]float64{"resource:cpu.pressure": 60, "resource:memory.oom_kill": 0}
+	for i := 1; i < DefaultSustainedIntervals; i++ {
+		if got := b.ResourceAnomalies(high, streaks, DefaultSustainedIntervals); len(got) != 0 {
+			t.Fatalf("interval %d: expected no anomaly before pressure is sustained, got %+v", i, got)
+		}
+	}
+	got := b.ResourceAnomalies(high, streaks, DefaultSustainedIntervals)
+	if len(got) != 1 || got[0].Evidence != "resource:cpu.pressure" || got[0].Severity != "HIGH" {
+		t.Fatalf("expected a HIGH pressure anomaly, got %+v", got)
+	}
+	if got := b.ResourceAnomalies(high, streaks, DefaultSustainedIntervals); len(got) != 0 {
+		t.Errorf("expected sustained pressure to be reported once, got %+v", got)
+	}
+
+	// A dip resets the streak.
+	b.ResourceAnomalies(map[string]float64{"resource:cpu.pressure": 2}, streaks, DefaultSustainedIntervals)
+	if streaks["resource:cpu.pressure"] != 0 {
+		t.Errorf("expected the streak to reset, got %d", streaks["resource:cpu.pressure"])
+	}
+
+	// Constant zero has no deviation, but a small rise is under MinResourceIncrease.
+	if b.ResourceElevated("resource:memory.oom_kill", 1) || !b.ResourceElevated("resource:memory.oom_kill", 6) {
+		t.Error("expected only increases of at least MinResourceIncrease to be elevated")
+	}
+}
+
+func TestRemediations(t *testing.T) {
+	rules := Remediations{
+		{Evidence: "network:*", MinSeverity: "HIGH", Remediation: Remediation{Runbook: "https://runbooks.example.com/egress", Action: "isolate-pod"}},
+		{Type: "Volume*", Remediation: Remediation{Runbook: "https://runbooks.example.com/volume"}},
+		{Remediation: Remediation{Owner: "platform-oncall"}},
+	}
+	anomalies := []Anomaly{
+		{Type: "Unseen Behavior", Severity: "CRITICAL", Evidence: "network:203.0.113.7:4444"},
+		{Type: "Unseen Behavior", Severity: "LOW", Evidence: "network:10.0.0.9:443"},
+		{Type: "Volume Anomaly", Severity: "HIGH", Evidence: "file:/var/lib/db", Remediation: &Remediation{Owner: "dba"}},
+	}
+	if n := rules.Apply(anomalies); n != 3 {
+		t.Errorf("expected 3 anomalies changed, got %d", n)
+	}
+	want := []Remediation{
+		{Runbook: "https://runbooks.example.com/egress", Owner: "platform-oncall", Action: "isolate-pod"},
+		{Owner: "platform-oncall"},
+		{Runbook: "https://runbooks.example.com/volume", Owner: "dba"},
+	}
+	for i, a := range anomalies {
+		if a.Remediation == nil || *a.Remediation != want[i] {
+			t.Errorf("anomaly %d: expected %+v, got %+v", i, want[i], a.Remediation)
+		}
+	}
+
+	if !(RemediationRule{Evidence: "file:/var/lib/*"}).Matches(Anomaly{Evidence: "file:/var/lib/db/data"}) {
+		t.Error("expected * to match across path separators")
+	}
+	if err := (RemediationRule{Type: "*"}).Validate(); err == nil {
+		t.Error("expected a rule without remediation to be rejected")
+	}
+}
+
+func TestSuppressions(t *testing.T) {
+	rules := Suppressions{
+		{Category: "network.dns", Reason: "resolver churn"},
+		{Category: "*.write", MaxSeverity: "MEDIUM", Reason: "log rotation"},
+	}
+	anomalies := []Anomaly{
+		{Type: "Unseen Behavior", Severity: "HIGH", Evidence: "network.dns:example.com"},
+		{Type: "Unseen Behavior", Severity: "HIGH", Evidence: "network:10.0.0.9:443"},
+		{Type: "Volume Anomaly", Severity: "LOW", Evidence: "file.write:/var/log/app.log"},
+		{Type: "Volume Anomaly", Severity: "CRITICAL", Evidence: "file.write:/etc/passwd"},
+	}
+	kept, n := rules.Filter(anomalies)
+	if n != 2 || len(kept) != 2 || kept[0].Evidence != "network:10.0.0.9:443" || kept[1].Evidence != "file.write:/etc/passwd" {
+		t.Errorf("expected the network and critical write anomalies kept, got %d suppressed: %+v", n, kept)
+	}
+	if anomalies[1].Evidence != "network:10.0.0.9:443" {
+		t.Error("Filter modified its input")
+	}
+
+	if !(RemediationRule{Category: "network"}).Matches(anomalies[0]) {
+		t.Error("expected category network to match network.dns evidence")
+	}
+	if (RemediationRule{Category: "network.*"}).Matches(anomalies[1]) {
+		t.Error("expected network.* not to match network evidence")
+	}
+
+	// Rate anomalies carry the pattern as evidence and their category
+	// apart.
+	learner := NewLearner()
+	b := learner.CreateBaseline("web")
+	for _, count := range []int{98, 100, 102, 99, 101} {
+		b.RecordObservation("network.dns", "example.com", count)
+	}
+	rate := learner.DetectAnomaly("web", "network.dns", "example.com", 200)
+	if len(rate) != 1 || rate[0].Evidence != "example.com" {
+		t.Fatalf("expected a rate anomaly with the pattern as evidence, got %+v", rate)
+	}
+	if !rules[0].Matches(rate[0]) || rules[1].Matches(rate[0]) {
+		t.Error("expected only the network.dns suppression to match the rate anomaly")
+	}
+	if !(RemediationRule{Category: "network"}).Matches(rate[0]) {
+		t.Error("expected category network to match the network.dns rate anomaly")
+	}
+	if err := (Suppression{Reason: "everything"}).Validate(); err == nil {
+		t.Error("expected a suppression matching everything to be rejected")
+	}
+	if err := (Suppression{Category: "network"}).Validate(); err == nil {
+		t.Error("expected a suppression without a reason to be rejected")
+	}
+}
+
+func TestIndexAdjust(t *testing.T) {
+	learner := NewLearner()
+	index := NewIndex()
+	for i, name := range []string{"a", "b", "c", "d"} {
+		b := learner.CreateBaseline(name)
+		b.RecordObservation("process", "/usr/bin/curl", 1) // everywhere but "new"
+		if i == 0 {
+			b.RecordObservation("network", "10.0.0.7:8443", 1) // rare
+		}
+		index.Update(b)
+	}
+	index.Update(learner.CreateBaseline("new"))
+
+	unseen := func() Anomaly {
+		return Anomaly{Type: "Unseen Behavior", Severity: "HIGH", RiskLevel: "HIGH", Confidence: 1}
+	}
+	tests := []struct {
+		key      string
+		severity string
+		fleet    int
+	}{
+		{"process:/usr/bin/curl", "LOW", 4},
+		{"network:10.0.0.7:8443", "MEDIUM", 1},
+		{"network:203.0.113.7:4444", "HIGH", 0},
+	}
+	for _, tt := range tests {
+		a := unseen()
+		index.Adjust(&a, "new", tt.key)
+		if a.Severity != tt.severity || a.FleetBaselines != tt.fleet {
+			t.Errorf("%s: expected %s seen in %d, got %s seen in %d", tt.key, tt.severity, tt.fleet, a.Severity, a.FleetBaselines)
+		}
+	}
+
+	index.Remove("a")
+	if seen, others := index.Prevalence("network:10.0.0.7:8443", "new"); seen != 0 || others != 3 {
+		t.Errorf("expected removed baseline to be forgotten, got %d of %d", seen, others)
+	}
+}
+
+func benchmarkObservations(n int) []Observation {
+	observations := make([]Observation, n)
+	for i := range observations {
+		observations[i] = Observation{Category: "syscall", Pattern: []string{"open", "read", "write", "close"}[i%4], Count: 100 + i%7}
+	}
+	return observations
+}
+
+func BenchmarkRecordObservation(b *testing.B) {
+	observations := benchmarkObservations(1000)
+	baseline := NewLearner().CreateBaseline("bench")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, o := range observations {
+			baseline.RecordObservation(o.Category, o.Pattern, o.Count)
+		}
+	}
+}
+
+func BenchmarkRecordObservations(b *testing.B) {
+	observations := benchmarkObservations(1000)
+	baseline := NewLearner().CreateBaseline("bench")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		baseline.RecordObservations(observations)
+	}
+}
+
+func TestLearnFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "observations.jsonl")
+	os.WriteFile(path, []byte(`{"category":"syscall","pattern":"openat","count":10}`+"\nnot json\n"), 0o600)
+	l := NewLearner()
+	if n, err := l.LearnFromFile("svc", path); err != nil || n != 1 {
+		t.Fatalf("expected one observation, got %d, %v", n, err)
+	}
+	if n, _ := l.LearnFromFile("svc", path); n != 0 {
+		t.Errorf("expected a second run to learn nothing new, got %d", n)
+	}
+
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString(`{"category":"syscall","pattern":"openat","count":14}` + "\n")
+	f.Close()
+	if n, _ := l.LearnFromFile("svc", path); n != 1 {
+		t.Errorf("expected the appended observation, got %d", n)
+	}
+	if stat := l.GetBaseline("svc").Stats["syscall:openat"]; stat.SampleCount != 2 || stat.Mean != 12 {
+		t.Errorf("expected two samples averaging 12, got %+v", stat)
+	}
+}
+
+func TestThresholdOverrides(t *testing.T) {
+	learner := NewLearner()
+	b := learner.CreateBaseline("myapp")
+	for _, count := range []int{98, 100, 102, 99, 101} {
+		b.RecordObservation("syscall", "open", count)
+		b.RecordObservation("syscall", "read", count)
+	}
+	if got := learner.DetectAnomaly("myapp", "syscall", "open", 105); len(got) != 1 {
+		t.Fatalf("expected an anomaly at the default threshold, got %+v", got)
+	}
+
+	changes, err := b.PlanThresholds(map[string]float64{"syscall:open": 5, "syscall:read": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0] != (ThresholdChange{Key: "syscall:open", Old: 3, New: 5, Override: true}) {
+		t.Fatalf("expected only syscall:open to change, got %+v", changes)
+	}
+	if b.ThresholdFor("syscall:open") != 3 {
+		t.Error("planning must not apply the changes")
+	}
+	b.ApplyThresholds(changes)
+	if got := learner.DetectAnomaly("myapp", "syscall", "open", 105); len(got) != 0 {
+		t.Errorf("expected the override to raise the threshold, got %+v", got)
+	}
+	if got := learner.DetectAnomaly("myapp", "syscall", "read", 105); len(got) != 1 {
+		t.Errorf("expected other keys to keep the default, got %+v", got)
+	}
+
+	if _, err := b.PlanThresholds(map[string]float64{"syscall:never": 4, "syscall:read": -1}); err == nil {
+		t.Error("expected unknown keys and negative thresholds to be rejected")
+	}
+	changes, _ = b.PlanThresholds(map[string]float64{"syscall:open": 0})
+	b.ApplyThresholds(changes)
+	if b.Thresholds != nil || b.ThresholdFor("syscall:open") != 3 {
+		t.Errorf("expected a zero threshold to remove the override, got %v", b.Thresholds)
+	}
+}
+
+func TestGraphEdges(t *testing.T) {
+	b := NewLearner().CreateBaseline("web")
+	if _, isNew := b.EdgeAnomaly(Edge{Kind: EdgeSpawn, From: "nginx", To: "sh"}); isNew {
+		t.Error("expected no graph anomalies before a graph is learned")
+	}
+	b.RecordEdges([]Edge{
+		{Kind: EdgeSpawn, From: "nginx", To: "php-fpm", Count: 5},
+		{Kind: EdgeSpawn, From: "cron", To: "sh"},
+		{Kind: EdgeConnect, From: "nginx", To: "10.0.0.20:8080", Count: 40},
+	})
+	b.RecordEdges([]Edge{{Kind: EdgeSpawn, From: "nginx", To: "php-fpm", Count: 2}})
+	if got := b.Graph["spawn:nginx->php-fpm"].Count; got != 7 {
+		t.Errorf("expected counts to add up to 7, got %d", got)
+	}
+
+	if _, isNew := b.EdgeAnomaly(Edge{Kind: EdgeConnect, From: "nginx", To: "10.0.0.20:8080"}); isNew {
+		t.Error("expected a learned edge not to be an anomaly")
+	}
+	a, isNew := b.EdgeAnomaly(Edge{Kind: EdgeSpawn, From: "nginx", To: "sh"})
+	if !isNew || a.Severity != "HIGH" || a.Evidence != "spawn:nginx->sh" {
+		t.Fatalf("expected a HIGH anomaly for a new spawn, got %+v", a)
+	}
+	if !strings.Contains(a.Description, "nginx usually spawned php-fpm") || !strings.Contains(a.Description, "sh is usually started by cron") {
+		t.Errorf("expected graph context in the description, got %q", a.Description)
+	}
+	if len(a.GraphContext) != 2 {
+		t.Errorf("expected the usual child and parent as context, got %+v", a.GraphContext)
+	}
+
+	defer func(max int) { MaxGraphEdges = max }(MaxGraphEdges)
+	MaxGraphEdges = 3
+	b.RecordEdges([]Edge{{Kind: EdgeWrite, From: "nginx", To: "/tmp/x"}})
+	if len(b.Graph) != 3 {
+		t.Errorf("expected the graph to stop growing at MaxGraphEdges, got %d edges", len(b.Graph))
+	}
+}
+
+func TestCluster(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+	shell := []Process{{PID: 12, PPID: 10, Exe: "/bin/sh"}, {PID: 10, PPID: 1, Exe: "/usr/sbin/nginx"}, {PID: 1}}
+	anomalies := []Anomaly{
+		{Type: "New Graph Edge", Severity: "HIGH", Confidence: 0.8, Evidence: "spawn:nginx->sh", Timestamp: at(0), PID: 12, Process: "sh", Lineage: shell},
+		{Type: "New Graph Edge", Severity: "MEDIUM", Confidence: 0.8, Evidence: "connect:sh->203.0.113.7:4444", Timestamp: at(1), PID: 12, Process: "sh"},
+		// Another process tree, same destination.
+		{Type: "Unseen Behavior", Severity: "HIGH", Confidence: 0.9, Evidence: "network:203.0.113.7:4444", Timestamp: at(3), PID: 40, Process: "curl"},
+		// Unrelated, and related but too late.
+		{Type: "Unseen Behavior", Severity: "LOW", Evidence: "file:/etc/hosts", Timestamp: at(2), PID: 99, Process: "cron", Lineage: []Process{{PID: 99}, {PID: 1}}},
+		{Type: "Unseen Behavior", Severity: "LOW", Evidence: "syscall:ptrace", Timestamp: at(20), PID: 12, Process: "sh"},
+	}
+	incidents := Cluster(anomalies, DefaultIncidentWindow)
+	if len(incidents) != 3 {
+		t.Fatalf("expected 3 incidents, got %d: %+v", len(incidents), incidents)
+	}
+	inc := incidents[0]
+	if inc.Count != 3 || !inc.Start.Equal(at(0)) || !inc.End.Equal(at(3)) {
+		t.Fatalf("expected the shell, its connection and the other connection in one incident, got %+v", inc)
+	}
+	if inc.Score <= 80 || inc.Severity != "CRITICAL" {
+		t.Errorf("expected the combined score to outrank each anomaly, got %.1f %s", inc.Score, inc.Severity)
+	}
+	if len(inc.Destinations) != 1 || inc.Destinations[0] != "203.0.113.7:4444" {
+		t.Errorf("unexpected destinations %v", inc.Destinations)
+	}
+	a := inc.Anomaly()
+	if a.Type != "Incident" || a.Evidence != inc.ID || a.PID != 12 || a.Incident == nil || len(a.Incident.Anomalies) != 3 {
+		t.Errorf("unexpected incident anomaly %+v", a)
+	}
+	if !strings.HasPrefix(a.Description, "3 related anomalies (2 New Graph Edge, 1 Unseen Behavior)") {
+		t.Errorf("unexpected description %q", a.Description)
+	}
+	if single := incidents[1].Anomaly(); single.Type != "Unseen Behavior" || single.Incident == nil {
+		t.Errorf("expected an incident of one to alert as its anomaly, got %+v", single)
+	}
+
+	// An anomaly related to two open incidents joins them.
+	c := NewClusterer(time.Minute)
+	first, _ := c.Add(Anomaly{Type: "x", Evidence: "a", PID: 5, Timestamp: at(0)})
+	c.Add(Anomaly{Type: "x", Evidence: "b", PID: 6, Timestamp: at(0)})
+	joined, isNew := c.Add(Anomaly{Type: "x", Evidence: "c", PID: 6, Lineage: []Process{{PID: 6}, {PID: 5}}, Timestamp: at(0)})
+	if isNew || joined.ID != first.ID || joined.Count != 3 {
+		t.Errorf("expected both incidents to join into the first, got %+v", joined)
+	}
+}
+
+func TestTopDeviations(t *testing.T) {
+	b := NewLearner().CreateBaseline("web")
+	for _, count := range []int{90, 100, 110} {
+		b.RecordObservations([]Observation{
+			{Category: "syscall", Pattern: "read", Count: count},
+			{Category: "syscall", Pattern: "write", Count: count / 10},
+			{Category: "syscall", Pattern: "close", Count: count},
+			{Category: "syscall", Pattern: "fixed", Count: 5},
+		})
+	}
+	observed := map[string]float64{
+		"syscall:read":   115, // +1.5σ
+		"syscall:write":  6,   // -4σ, beyond the threshold
+		"syscall:close":  100,
+		"syscall:fixed":  9, // no variance
+		"syscall:ptrace": 1, // not learned
+	}
+	top := b.TopDeviations(observed, 2)
+	if len(top) != 2 || top[0].Key != "syscall:write" || top[1].Key != "syscall:read" {
+		t.Fatalf("expected write then read, got %+v", top)
+	}
+	if !top[0].Alerting() || top[1].Alerting() {
+		t.Errorf("expected only write to be alerting, got %+v", top)
+	}
+	if math.Abs(top[1].ZScore-1.5) > 1e-9 {
+		t.Errorf("expected read at +1.5σ, got %v", top[1].ZScore)
+	}
+	if all := b.TopDeviations(observed, 10); len(all) != 3 {
+		t.Errorf("expected unlearned and invariant keys to be left out, got %+v", all)
+	}
+}
+
+func TestCategoryPercentile(t *testing.T) {
+	b := NewLearner().CreateBaseline("web")
+	for _, count := range []int{90, 100, 110} {
+		b.RecordObservations([]Observation{
+			{Category: "syscall", Pattern: "read", Count: count},
+			{Category: "syscall", Pattern: "write", Count: count},
+		})
+	}
+	// Two keys of mean 100 and stddev 10: the total has mean 200 and
+	// stddev √200.
+	p99, ok := b.CategoryPercentile("syscall", 0.99)
+	if want := 200 + 2.3263*math.Sqrt(200); !ok || math.Abs(p99-want) > 0.01 {
+		t.Errorf("expected p99 %.2f, got %.2f", want, p99)
+	}
+	if median, _ := b.CategoryPercentile("syscall", 0.5); math.Abs(median-200) > 1e-9 {
+		t.Errorf("expected the median at the mean, got %v", median)
+	}
+	if _, ok := b.CategoryPercentile("network", 0.99); ok {
+		t.Error("expected no percentile for an unobserved category")
+	}
+}
+
+func TestQuality(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+	b := NewLearner(WithClock(fake)).CreateBaseline("app")
+	if q := b.Quality(); q.Score != 0 || q.Ready() || len(q.Issues) != 1 {
+		t.Errorf("expected an empty baseline to score 0, got %+v", q)
+	}
+
+	for i := 0; i < MinQualitySamples; i++ {
+		for _, category := range RuntimeCategories {
+			b.RecordObservation(category, "steady", 10+i%3)
+		}
+	}
+	b.RecordObservation("file", "rare", 1)
+	q := b.Quality()
+	if q.Keys != 5 || q.Sparse != 1 || q.Coverage != 1 || q.Freshness != 1 || q.Stability != 1 {
+		t.Fatalf("unexpected quality %+v", q)
+	}
+	if q.PerKey[0].Key != "file:rare" || !q.Ready() || len(q.Issues) != 1 {
+		t.Errorf("expected one sparse key and a ready baseline, got %+v", q)
+	}
+
+	fake.Advance(QualityStaleAfter)
+	if q := b.Quality(); q.Freshness != 0 || q.Ready() {
+		t.Errorf("expected a stale baseline not to be ready, got %+v", q)
+	}
+
+	logs := NewLearner(WithClock(fake)).CreateBaseline("logs")
+	for i := 0; i < MinQualitySamples; i++ {
+		logs.RecordObservation("template", "user <*> logged in", 1)
+	}
+	if q := logs.Quality(); q.Coverage != 1 || q.MissingCategories != nil {
+		t.Errorf("expected non-runtime baselines to be fully covered, got %+v", q)
+	}
+}
+
+func TestMinSamples(t *testing.T) {
+	learner := NewLearner()
+	b := learner.CreateBaseline("myapp")
+	for _, count := range []int{98, 100, 102} {
+		b.RecordObservation("syscall", "open", count)
+	}
+	got := learner.DetectAnomaly("myapp", "syscall", "open", 150)
+	if len(got) != 1 || got[0].Type != InsufficientData {
+		t.Fatalf("expected insufficient data below %d samples, got %+v", DefaultMinSamples, got)
+	}
+	if anomalies, insufficient := SplitInsufficient(got); len(anomalies) != 0 || len(insufficient) != 1 {
+		t.Errorf("unexpected split %+v %+v", anomalies, insufficient)
+	}
+	if got := learner.DetectAnomaly("myapp", "syscall", "open", 100); len(got) != 0 {
+		t.Errorf("expected nothing for normal values, got %+v", got)
+	}
+
+	if err := b.SetMinSamples("syscall:open", 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := learner.DetectAnomaly("myapp", "syscall", "open", 150); len(got) != 1 || got[0].Type != "Behavioral Anomaly" {
+		t.Errorf("expected the per-key minimum to allow an anomaly, got %+v", got)
+	}
+	if err := b.SetMinSamples("syscall:never", 3); err == nil {
+		t.Error("expected unknown keys to be rejected")
+	}
+	b.SetMinSamples("syscall:open", 0)
+	if b.MinSamples != nil || b.MinSamplesFor("syscall:open") != DefaultMinSamples {
+		t.Errorf("expected 0 to remove the override, got %v", b.MinSamples)
+	}
+
+	strict := NewLearner(WithMinSamples(10)).CreateBaseline("strict")
+	if strict.MinSamplesFor("syscall:open") != 10 {
+		t.Errorf("expected the learner's minimum, got %d", strict.MinSamplesFor("syscall:open"))
+	}
+}
+
+func TestDirections(t *testing.T) {
+	learner := NewLearner()
+	b := learner.CreateBaseline("svc")
+	for _, count := range []int{98, 100, 102, 99, 101} {
+		b.RecordObservation("network", "connect", count)
+		b.RecordLabeledObservation("heartbeat", "beat", map[string]string{"peer": "a"}, count)
+	}
+	if err := b.SetDirection("network:connect", DirectionUp); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetDirection("heartbeat:beat", DirectionDown); err != nil {
+		t.Fatalf("expected a pattern learned only with labels to be accepted: %v", err)
+	}
+	if got := learner.DetectAnomaly("svc", "network", "connect", 90); len(got) != 0 {
+		t.Errorf("expected decreases not to alert on an up key, got %+v", got)
+	}
+	if got := learner.DetectAnomaly("svc", "network", "connect", 110); len(got) != 1 {
+		t.Errorf("expected increases to alert on an up key, got %+v", got)
+	}
+	beat := map[string]string{"peer": "a"}
+	if got := learner.DetectLabeledAnomaly("svc", "heartbeat", "beat", beat, 110); len(got) != 0 {
+		t.Errorf("expected the pattern's direction to apply to its segments, got %+v", got)
+	}
+	if got := learner.DetectLabeledAnomaly("svc", "heartbeat", "beat", beat, 90); len(got) != 1 {
+		t.Errorf("expected decreases to alert on a down key, got %+v", got)
+	}
+
+	if !DirectionUp.IsNormal(0, 100, 1, 3) || DirectionUp.IsNormal(200, 100, 1, 3) || !IsNormal(102, 100, 1, 3) {
+		t.Error("unexpected IsNormal")
+	}
+	if !DirectionDown.IsNormal(5, 4, 0, 3) || DirectionDown.IsNormal(3, 4, 0, 3) {
+		t.Error("expected invariant keys to honor the direction")
+	}
+
+	changes, err := b.PlanDirections(map[string]Direction{"heartbeat:beat{peer=a}": DirectionBoth})
+	if err != nil || len(changes) != 1 {
+		t.Fatalf("expected one change, got %+v, %v", changes, err)
+	}
+	b.ApplyDirections(changes)
+	if b.DirectionFor("heartbeat:beat{peer=a}") != DirectionBoth || b.DirectionFor("heartbeat:beat{peer=b}") != DirectionDown {
+		t.Errorf("expected a segment to override its pattern, got %v", b.Directions)
+	}
+	if _, err := b.PlanDirections(map[string]Direction{"network:never": DirectionUp, "network:connect": "sideways"}); err == nil {
+		t.Error("expected unknown keys and directions to be rejected")
+	}
+}
+
+func TestTimedObservations(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := NewLearner().CreateBaseline("web", WithLateness(30*time.Second))
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	b.RecordObservationAt("syscall", "openat", nil, 2, at(10*time.Second))
+	b.RecordObservationAt("syscall", "openat", nil, 3, at(70*time.Second))
+	// Out of order, but within the lateness of the first minute.
+	b.RecordObservationAt("syscall", "openat", nil, 4, at(50*time.Second))
+	if _, ok := b.Stats["syscall:openat"]; ok {
+		t.Fatal("expected the first minute to stay open within its lateness")
+	}
+	b.RecordObservationAt("syscall", "openat", nil, 1, at(95*time.Second))
+	if s := b.Stats["syscall:openat"]; s.SampleCount != 1 || s.Mean != 6 {
+		t.Fatalf("expected the first minute closed with 6, got %+v", s)
+	}
+	if b.RecordObservationAt("syscall", "openat", nil, 9, at(20*time.Second)) || b.Late != 1 {
+		t.Errorf("expected an observation of a closed minute to be dropped as late, got %d late", b.Late)
+	}
+
+	if n := b.CloseBuckets(); n != 1 {
+		t.Errorf("expected one open bucket closed, got %d", n)
+	}
+	if s := b.Stats["syscall:openat"]; s.SampleCount != 2 || s.Mean != 5 {
+		t.Errorf("expected the second minute closed with 4, got %+v", s)
+	}
+	if g := b.Gaps["syscall:openat"]; g.SampleCount != 1 || g.Mean != 60 {
+		t.Errorf("expected activity a minute apart, got %+v", g)
+	}
+
+	// Batches mix timed and untimed observations.
+	b.RecordObservations([]Observation{
+		{Category: "file", Pattern: "/etc/hosts", Count: 1},
+		{Category: "file", Pattern: "/etc/passwd", Count: 1, Time: at(3 * time.Minute)},
+	})
+	if _, ok := b.Stats["file:/etc/hosts"]; !ok || len(b.Open) != 1 {
+		t.Errorf("expected the untimed observation recorded and the timed one open, got %d open", len(b.Open))
+	}
+}
+
+func TestEscalator(t *testing.T) {
+	start := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	e := NewEscalator(time.Hour, StepEscalation(2, "HIGH"))
+	observe := func(hours float64, evidence string) Anomaly {
+		t.Helper()
+		return e.Observe(Anomaly{Type: "New Graph Edge", Evidence: evidence, Severity: "LOW", Description: "new edge",
+			Timestamp: start.Add(time.Duration(hours * float64(time.Hour)))})
+	}
+
+	if a := observe(0, "spawn:cron->sh"); a.Severity != "LOW" || a.Recurrence.Windows != 1 {
+		t.Fatalf("first window: %+v", a.Recurrence)
+	}
+	if a := observe(0.5, "spawn:cron->sh"); a.Severity != "LOW" || a.Recurrence.Count != 2 {
+		t.Fatalf("same window: %s %+v", a.Severity, a.Recurrence)
+	}
+	a := observe(1.2, "spawn:cron->sh")
+	if a.Severity != "MEDIUM" || a.Recurrence.Original != "LOW" || a.Recurrence.Windows != 2 || !a.Recurrence.Since.Equal(start) {
+		t.Fatalf("second window: %s %+v", a.Severity, a.Recurrence)
+	}
+	if !strings.Contains(a.Description, "escalated from LOW") {
+		t.Errorf("description = %q", a.Description)
+	}
+	for h := 2; h < 8; h++ {
+		a = observe(float64(h), "spawn:cron->sh")
+	}
+	if a.Severity != "HIGH" {
+		t.Errorf("expected escalation to stop at the ceiling, got %s", a.Severity)
+	}
+	if a := observe(7, "spawn:cron->curl"); a.Severity != "LOW" {
+		t.Errorf("expected other evidence to be tracked apart, got %s", a.Severity)
+	}
+	// A window without the anomaly ends its recurrence.
+	if a := observe(9, "spawn:cron->sh"); a.Severity != "LOW" || a.Recurrence.Windows != 1 {
+		t.Errorf("after a gap: %s %+v", a.Severity, a.Recurrence)
+	}
+
+	threshold := NewEscalator(time.Hour, ThresholdEscalation(3, "CRITICAL"))
+	for h := 0; h < 3; h++ {
+		a = threshold.Observe(Anomaly{Type: "Unseen Behavior", Severity: "MEDIUM", Timestamp: start.Add(time.Duration(h) * time.Hour)})
+	}
+	if a.Severity != "CRITICAL" {
+		t.Errorf("threshold escalation = %s", a.Severity)
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	b := NewLearner().CreateBaseline("myapp")
+	for _, o := range []Override{{Key: "network", Reason: "x", Expires: now}, {Key: "network:10.0.0.1", Expires: now}, {Key: "network:10.0.0.1", Reason: "x"}} {
+		if err := b.AddOverride(o); err == nil {
+			t.Errorf("expected %+v to be rejected", o)
+		}
+	}
+	b.AddOverride(Override{Key: "network:203.0.113.7", Reason: "migration", Expires: now.Add(48 * time.Hour)})
+	b.AddOverride(Override{Key: "process:/usr/bin/rsync*", Reason: "sync", Expires: now.Add(time.Hour)})
+
+	anomalies := []Anomaly{
+		{Type: "Unseen Behavior", Evidence: "network:203.0.113.7:443"},
+		{Type: "New Graph Edge", Evidence: "connect:sh->203.0.113.7:4444"},
+		{Type: "Unseen Behavior", Evidence: "network:203.0.113.70:443"},
+		{Type: "Unseen Behavior", Evidence: "process:/usr/bin/rsync-ssl"},
+		{Type: "Unseen Behavior", Evidence: "file:/usr/bin/rsync"},
+	}
+	kept, n := b.FilterOverrides(anomalies, now)
+	if n != 3 || len(kept) != 2 || kept[0].Evidence != "network:203.0.113.70:443" {
+		t.Fatalf("kept %+v", kept)
+	}
+
+	// Rate anomalies carry the pattern as evidence and their category
+	// apart.
+	learner := NewLearner()
+	rates := learner.CreateBaseline("rates")
+	for _, count := range []int{98, 100, 102, 99, 101} {
+		rates.RecordObservation("network", "203.0.113.7:443", count)
+		rates.RecordObservation("process", "/usr/bin/rsync", count)
+	}
+	rates.Overrides = b.Overrides
+	deviations := append(learner.DetectAnomaly("rates", "network", "203.0.113.7:443", 200), learner.DetectAnomaly("rates", "process", "/usr/bin/rsync", 200)...)
+	if len(deviations) != 2 {
+		t.Fatalf("expected two rate anomalies, got %+v", deviations)
+	}
+	if kept, n := rates.FilterOverrides(deviations, now); n != 2 {
+		t.Errorf("expected the overrides to cover rate anomalies, kept %+v", kept)
+	}
+
+	if kept, _ := b.FilterOverrides(anomalies, now.Add(2*time.Hour)); len(kept) != 3 {
+		t.Errorf("expected the expired override to stop applying, kept %+v", kept)
+	}
+	if expired := b.ExpireOverrides(now.Add(2 * time.Hour)); len(expired) != 1 || len(b.Overrides) != 1 || expired[0].Reason != "sync" {
+		t.Errorf("expired %+v, left %+v", expired, b.Overrides)
+	}
+	if !b.RemoveOverride("network:203.0.113.7") || b.Overrides != nil {
+		t.Errorf("overrides left: %+v", b.Overrides)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	if len(migrations) != SchemaVersion {
+		t.Fatalf("%d migrations for schema version %d", len(migrations), SchemaVersion)
+	}
+	for i, m := range migrations {
+		if m.From != i {
+			t.Errorf("migrations[%d] upgrades version %d", i, m.From)
+		}
+	}
+	data, version, err := Migrate([]byte(`{"Name":"web"}`))
+	if err != nil || version != 0 {
+		t.Fatalf("Migrate = %d, %v", version, err)
+	}
+	if string(data) != `{"Name":"web","SchemaVersion":1,"Stats":{}}` {
+		t.Errorf("migrated = %s", data)
+	}
+	current := []byte(`{"SchemaVersion":1,"Name":"web"}`)
+	if data, _, _ := Migrate(current); &data[0] != &current[0] {
+		t.Error("a current baseline was rewritten")
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	metadata, err := ParseMetadata("owner=payments, env=prod,sha=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Baseline{Name: "web", Metadata: map[string]string{"sha": "1a2b", "team": "core"}}
+	b.SetMetadata(metadata)
+	if got := FormatMetadata(b.Metadata); got != "env=prod,owner=payments,team=core" {
+		t.Errorf("got metadata %s", got)
+	}
+	for selector, want := range map[string]bool{"env=prod": true, "env=dev": false, "owner=": true, "sha=": false, "": true} {
+		parsed, _ := ParseMetadata(selector)
+		if b.MatchMetadata(parsed) != want {
+			t.Errorf("MatchMetadata(%q) = %v", selector, !want)
+		}
+	}
+	anomalies := []Anomaly{{Type: "Unseen Behavior"}, {Type: "Unseen Behavior", Metadata: map[string]string{"team": "sre"}}}
+	b.ApplyMetadata(anomalies)
+	if anomalies[0].Metadata["owner"] != "payments" || anomalies[1].Metadata["team"] != "sre" {
+		t.Errorf("got anomaly metadata %v and %v", anomalies[0].Metadata, anomalies[1].Metadata)
+	}
+	for _, bad := range []string{"env", "=prod", "en v=prod"} {
+		if _, err := ParseMetadata(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestColdStart(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := NewLearner(WithColdStart(DefaultColdStart)).CreateBaseline("web", WithBucketWidth(5*time.Minute))
+	for i := 0; i <= 36; i++ {
+		b.RecordObservationAt("syscall", "read", nil, 40, start.Add(time.Duration(i)*5*time.Minute))
+	}
+	if b.Learned() != 3*time.Hour || !b.InColdStart() || b.ColdStartStatus() != "cold start, 3h of 24h learned" {
+		t.Fatalf("got learned %s, status %q", b.Learned(), b.ColdStartStatus())
+	}
+	anomalies := []Anomaly{
+		{Type: "Behavioral Anomaly", Description: "Observed behavior deviates from baseline"},
+		{Type: "Unseen Behavior", Description: "Operation never seen in the baseline"},
+		{Type: SilenceAnomaly},
+	}
+	kept, dropped := b.FilterColdStart(anomalies)
+	if dropped != 2 || len(kept) != 1 || !kept[0].ColdStart || kept[0].Description != "Operation never seen in the baseline (cold start, 3h of 24h learned)" {
+		t.Errorf("got %d dropped, kept %+v", dropped, kept)
+	}
+	if anomalies[1].ColdStart {
+		t.Error("expected the anomalies passed in to be left alone")
+	}
+
+	b.ColdStart = 3 * time.Hour
+	if kept, _ := b.FilterColdStart(anomalies); b.InColdStart() || len(kept) != 3 || kept[1].ColdStart {
+		t.Errorf("expected full detection once enough is learned, got %+v", kept)
+	}
+	b.ColdStart = -1
+	if b.InColdStart() {
+		t.Error("expected a negative cold start to detect in full")
+	}
+
+	// Samples without event time say nothing about coverage, however
+	// many there are, and baselines without a cold start never start
+	// cold.
+	untimed := NewLearner(WithColdStart(DefaultColdStart)).CreateBaseline("untimed")
+	for i := 0; i < 5; i++ {
+		untimed.RecordObservation("syscall", "read", 40)
+	}
+	if untimed.Learned() != 0 || untimed.InColdStart() {
+		t.Errorf("expected a baseline of untimed samples not in cold start, learned %s", untimed.Learned())
+	}
+	b.ColdStart = 0
+	if b.InColdStart() {
+		t.Error("expected cold start off by default")
+	}
+}
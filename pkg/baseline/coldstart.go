@@ -0,0 +1,83 @@
+package baseline
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultColdStart is the cold start of baselines learned with cold start
+// turned on without a duration.
+const DefaultColdStart = 24 * time.Hour
+
+// RateAnomalyTypes are the types of anomalies comparing how often or how
+// much something happens with what a baseline learned. Baselines in cold
+// start do not raise them: rates learned from a few hours miss the
+// workload's daily cycle and would alert on every busy hour.
+var RateAnomalyTypes = map[string]bool{
+	"Behavioral Anomaly": true,
+	"Volume Anomaly":     true,
+	"Resource Pressure":  true,
+	"Crash Loop":         true,
+	SilenceAnomaly:       true,
+}
+
+// Learned returns how much event time b has learned: from LearnedSince to
+// the end of the last interval it closed. It is 0 for baselines learned
+// only from untimed samples, whose coverage is unknown.
+func (b *Baseline) Learned() time.Duration {
+	if b.LearnedSince.IsZero() || !b.Closed.After(b.LearnedSince) {
+		return 0
+	}
+	return b.Closed.Sub(b.LearnedSince)
+}
+
+// InColdStart reports whether b starts cold, ColdStart being set, and has
+// learned less event time than that since LearnedSince. A baseline in cold
+// start only reports behavior it has never seen, and the anomalies it
+// raises are marked; it switches to full detection on its own once it has
+// learned enough. Baselines without ColdStart or LearnedSince, such as
+// those learned before either existed, and nil baselines are not in cold
+// start.
+func (b *Baseline) InColdStart() bool {
+	return b != nil && b.ColdStart > 0 && !b.LearnedSince.IsZero() && b.Learned() < b.ColdStart
+}
+
+// ColdStartStatus describes b's cold start, such as "cold start, 3h of
+// 24h learned", or returns "" when b is not in cold start.
+func (b *Baseline) ColdStartStatus() string {
+	if !b.InColdStart() {
+		return ""
+	}
+	return fmt.Sprintf("cold start, %s of %s learned", formatLearned(b.Learned()), formatLearned(b.ColdStart))
+}
+
+// FilterColdStart returns the anomalies b raises in its current mode, in
+// order, and how many were dropped. In cold start the anomalies of
+// RateAnomalyTypes are dropped and the others marked ColdStart, with the
+// status appended to their description.
+func (b *Baseline) FilterColdStart(anomalies []Anomaly) ([]Anomaly, int) {
+	status := b.ColdStartStatus()
+	if status == "" {
+		return anomalies, 0
+	}
+	kept := anomalies[:0:0]
+	for _, a := range anomalies {
+		if RateAnomalyTypes[a.Type] {
+			continue
+		}
+		if !a.ColdStart {
+			a.ColdStart = true
+			a.Description += " (" + status + ")"
+		}
+		kept = append(kept, a)
+	}
+	return kept, len(anomalies) - len(kept)
+}
+
+// formatLearned formats d in whole hours, or minutes below an hour.
+func formatLearned(d time.Duration) string {
+	if d < time.Hour {
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	}
+	return fmt.Sprintf("%dh", int(d/time.Hour))
+}
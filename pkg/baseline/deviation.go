@@ -0,0 +1,122 @@
+package baseline
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Deviation is how far an observed value of a key lies from what the
+// baseline learned for it.
+type Deviation struct {
+	Key       string  `json:"key"`
+	Observed  float64 `json:"observed"`
+	Mean      float64 `json:"mean"`
+	StdDev    float64 `json:"stddev"`
+	ZScore    float64 `json:"z_score"`
+	Threshold float64 `json:"threshold"`
+	// Direction is the direction in which the key alerts.
+	Direction Direction `json:"direction"`
+	// Samples is how many samples the key was learned from, and
+	// MinSamples how many it needs to alert.
+	Samples    int `json:"samples"`
+	MinSamples int `json:"min_samples"`
+}
+
+// Alerting reports whether the deviation exceeds the key's threshold in
+// the key's direction.
+func (d Deviation) Alerting() bool {
+	return d.Direction.Exceeds(d.ZScore, d.Threshold)
+}
+
+// Insufficient reports whether the key has too few samples for the
+// deviation to be reported as an anomaly.
+func (d Deviation) Insufficient() bool {
+	return d.Samples < d.MinSamples
+}
+
+// TopDeviations returns the n observed keys with the largest standardized
+// deviation, in either direction, from their learned means, largest
+// first. Unlike detection it reports them whether or not they exceed
+// their thresholds, so drift shows before it alerts. Keys the baseline has
+// not observed, and keys learned without variance, which have no
+// standardized deviation, are left out.
+func (b *Baseline) TopDeviations(observed map[string]float64, n int) []Deviation {
+	if n <= 0 {
+		return nil
+	}
+	h := make(deviationHeap, 0, n+1)
+	for key, value := range observed {
+		stat, ok := b.Stats[key]
+		if !ok || stat.SampleCount == 0 || stat.StdDev == 0 {
+			continue
+		}
+		d := Deviation{
+			Key:        key,
+			Observed:   value,
+			Mean:       stat.Mean,
+			StdDev:     stat.StdDev,
+			ZScore:     CalculateZScore(value, stat.Mean, stat.StdDev),
+			Threshold:  b.ThresholdFor(key),
+			Direction:  b.DirectionFor(key),
+			Samples:    stat.SampleCount,
+			MinSamples: b.MinSamplesFor(key),
+		}
+		if len(h) < n {
+			heap.Push(&h, d)
+		} else if h.less(h[0], d) {
+			h[0] = d
+			heap.Fix(&h, 0)
+		}
+	}
+	sort.Slice(h, func(i, j int) bool { return h.less(h[j], h[i]) })
+	return h
+}
+
+// deviationHeap is a min-heap of deviations by absolute z-score, so the
+// smallest of the n kept is the one replaced.
+type deviationHeap []Deviation
+
+func (h deviationHeap) less(a, b Deviation) bool {
+	if za, zb := math.Abs(a.ZScore), math.Abs(b.ZScore); za != zb {
+		return za < zb
+	}
+	return a.Key > b.Key
+}
+
+func (h deviationHeap) Len() int            { return len(h) }
+func (h deviationHeap) Less(i, j int) bool  { return h.less(h[i], h[j]) }
+func (h deviationHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *deviationHeap) Push(x interface{}) { *h = append(*h, x.(Deviation)) }
+func (h *deviationHeap) Pop() interface{} {
+	old := *h
+	d := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return d
+}
+
+// CategoryPercentile estimates the p-th percentile (0 < p < 1) of the
+// number of events of a category per learned interval. The category's
+// per-interval count is the sum of its keys' counts, so its mean and
+// variance are the sums of theirs, taking keys as independent; the
+// percentile is that of a normal distribution with them, and never below
+// the mean. It reports false when the baseline has no observations of the
+// category.
+func (b *Baseline) CategoryPercentile(category string, p float64) (float64, bool) {
+	mean, variance := 0.0, 0.0
+	found := false
+	for key, stat := range b.Stats {
+		if c, _, _ := strings.Cut(key, ":"); c != category || stat.SampleCount == 0 {
+			continue
+		}
+		found = true
+		mean += stat.Mean
+		variance += stat.StdDev * stat.StdDev
+	}
+	if !found {
+		return 0, false
+	}
+	z := math.Sqrt2 * math.Erfinv(2*p-1)
+	return mean + max(z, 0)*math.Sqrt(variance), true
+}
@@ -0,0 +1,156 @@
+package baseline
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Direction is which deviations from a key's mean count as anomalous.
+type Direction string
+
+// Directions. The zero value is DirectionBoth.
+const (
+	// DirectionBoth alerts on increases and decreases.
+	DirectionBoth Direction = "both"
+	// DirectionUp alerts only on increases, such as connection counts.
+	DirectionUp Direction = "up"
+	// DirectionDown alerts only on decreases, such as heartbeats.
+	DirectionDown Direction = "down"
+)
+
+// ParseDirection parses both, up or down; the empty string is both.
+func ParseDirection(s string) (Direction, error) {
+	switch d := Direction(strings.ToLower(strings.TrimSpace(s))); d {
+	case "", DirectionBoth:
+		return DirectionBoth, nil
+	case DirectionUp, DirectionDown:
+		return d, nil
+	}
+	return "", fmt.Errorf("invalid direction %q: want both, up or down", s)
+}
+
+// Exceeds reports whether a z-score lies beyond threshold in the
+// direction.
+func (d Direction) Exceeds(z, threshold float64) bool {
+	switch d {
+	case DirectionUp:
+		return z > threshold
+	case DirectionDown:
+		return z < -threshold
+	}
+	return z > threshold || z < -threshold
+}
+
+// IsNormal checks if behavior is within normal range in the direction:
+// decreases are normal for DirectionUp and increases for DirectionDown.
+func (d Direction) IsNormal(count, mean, stddev, threshold float64) bool {
+	if stddev == 0 {
+		switch d {
+		case DirectionUp:
+			return count <= mean
+		case DirectionDown:
+			return count >= mean
+		}
+		return count == mean
+	}
+	return !d.Exceeds((count-mean)/stddev, threshold)
+}
+
+// DirectionFor returns the direction in which deviations of a statistic
+// key are anomalous: its entry in Directions, the entry of its pattern
+// without labels, or DirectionBoth.
+func (b *Baseline) DirectionFor(key string) Direction {
+	if d, ok := b.Directions[key]; ok {
+		return d
+	}
+	if category, pattern, labels := ParseStatKey(key); len(labels) > 0 {
+		if d, ok := b.Directions[StatKey(category, pattern, nil)]; ok {
+			return d
+		}
+	}
+	return DirectionBoth
+}
+
+// DirectionChange is a change of the direction applying to one key.
+type DirectionChange struct {
+	Key string
+	Old Direction
+	New Direction
+}
+
+// PlanDirections validates per-key directions and returns the changes they
+// make, ordered by key, without applying them. Like PlanThresholds, every
+// key must have been learned, as a key or as a pattern whose labeled
+// segments were, and all violations are returned together.
+func (b *Baseline) PlanDirections(directions map[string]Direction) ([]DirectionChange, error) {
+	keys := make([]string, 0, len(directions))
+	for key := range directions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var changes []DirectionChange
+	var errs []error
+	for _, key := range keys {
+		d, err := ParseDirection(string(directions[key]))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			continue
+		}
+		if !b.hasKey(key) && !b.hasPattern(key) {
+			errs = append(errs, fmt.Errorf("%s: not learned by baseline %s", key, b.Name))
+			continue
+		}
+		if old := b.DirectionFor(key); old != d {
+			changes = append(changes, DirectionChange{Key: key, Old: old, New: d})
+		}
+	}
+	return changes, errors.Join(errs...)
+}
+
+// hasPattern reports whether key, without labels, names a pattern learned
+// only in labeled segments.
+func (b *Baseline) hasPattern(key string) bool {
+	prefix := key + "{"
+	for stat := range b.Stats {
+		if strings.HasPrefix(stat, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyDirections applies changes from PlanDirections.
+func (b *Baseline) ApplyDirections(changes []DirectionChange) {
+	for _, c := range changes {
+		delete(b.Directions, c.Key)
+		// A labeled key inheriting its pattern's direction needs its own
+		// entry to differ from it, even when that is both.
+		if b.DirectionFor(c.Key) != c.New {
+			if b.Directions == nil {
+				b.Directions = make(map[string]Direction)
+			}
+			b.Directions[c.Key] = c.New
+		}
+	}
+	if len(b.Directions) == 0 {
+		b.Directions = nil
+	}
+	if len(changes) > 0 {
+		b.UpdatedAt = b.now()
+	}
+}
+
+// SetDirection sets the direction in which deviations of key are
+// anomalous. Keys of a pattern without labels apply to all its labeled
+// segments that have none of their own.
+func (b *Baseline) SetDirection(key string, d Direction) error {
+	changes, err := b.PlanDirections(map[string]Direction{key: d})
+	if err != nil {
+		return err
+	}
+	b.ApplyDirections(changes)
+	return nil
+}
@@ -0,0 +1,156 @@
+package baseline
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// Shift is a pattern whose typical count differs between two baselines.
+type Shift struct {
+	Key           string
+	ActiveMean    float64
+	CandidateMean float64
+	ZScore        float64 // of the candidate mean against the active statistics
+}
+
+// Divergence compares a candidate baseline, learned from current behavior,
+// with the active one.
+type Divergence struct {
+	Active    string
+	Candidate string
+	// New are patterns the candidate observed that the active baseline
+	// never did; Missing the reverse. Shifted are patterns both observed
+	// whose counts moved beyond the active anomaly threshold.
+	New     []string
+	Missing []string
+	Shifted []Shift
+	// Observed is the number of patterns the candidate observed.
+	Observed int
+	// Score is the share of the candidate's patterns that are new or
+	// shifted, from 0 (same behavior) to 1. Missing patterns do not count:
+	// a young candidate has not seen everything yet.
+	Score float64
+}
+
+// minShiftSamples is how many samples both baselines need before a pattern
+// can count as shifted.
+const minShiftSamples = 2
+
+// Diverge compares candidate with active.
+func Diverge(active, candidate *Baseline) Divergence {
+	d := Divergence{Active: active.Name, Candidate: candidate.Name}
+	for key, c := range candidate.Stats {
+		if c.SampleCount == 0 {
+			continue
+		}
+		d.Observed++
+		a, known := active.Stats[key]
+		if !known || a.SampleCount == 0 {
+			d.New = append(d.New, key)
+			continue
+		}
+		if a.SampleCount < minShiftSamples || c.SampleCount < minShiftSamples {
+			continue
+		}
+		z := CalculateZScore(c.Mean, a.Mean, a.StdDev)
+		shifted := math.Abs(z) > active.AnomalyThreshold
+		if a.StdDev == 0 {
+			// A constant count has no spread to measure against; call
+			// it shifted when it moved by more than half.
+			shifted = math.Abs(c.Mean-a.Mean) > 0.5*math.Max(a.Mean, 1)
+		}
+		if shifted {
+			d.Shifted = append(d.Shifted, Shift{Key: key, ActiveMean: a.Mean, CandidateMean: c.Mean, ZScore: z})
+		}
+	}
+	for key, a := range active.Stats {
+		if a.SampleCount == 0 {
+			continue
+		}
+		if c, seen := candidate.Stats[key]; !seen || c.SampleCount == 0 {
+			d.Missing = append(d.Missing, key)
+		}
+	}
+	sort.Strings(d.New)
+	sort.Strings(d.Missing)
+	sort.Slice(d.Shifted, func(i, j int) bool { return d.Shifted[i].Key < d.Shifted[j].Key })
+	if d.Observed > 0 {
+		d.Score = float64(len(d.New)+len(d.Shifted)) / float64(d.Observed)
+	}
+	return d
+}
+
+// CategoryShift is how much of one category's behavior over an interval
+// differs from what a baseline learned.
+type CategoryShift struct {
+	Category string
+	// New, Shifted and Missing count the category's patterns observed
+	// that the baseline never saw, observed beyond their threshold, and
+	// learned as active in every interval but not observed.
+	New     int
+	Shifted int
+	Missing int
+	// Share is the part of the category's operations, by count, that are
+	// new, shifted or missing, from 0 (as learned) to 1.
+	Share float64
+}
+
+// CategoryShifts compares counts, the operations of one interval of the
+// baseline's bucket width by statistics key, with what b learned, per
+// category, in category order. Categories neither observed nor learned as
+// active are left out. Shifts of many categories together point to the
+// workload itself having changed, such as by a deploy, rather than to one
+// anomalous operation.
+func (b *Baseline) CategoryShifts(counts map[string]float64) []CategoryShift {
+	shifts := make(map[string]*CategoryShift)
+	totals := make(map[string]float64)
+	moved := make(map[string]float64)
+	shift := func(category string) *CategoryShift {
+		s, ok := shifts[category]
+		if !ok {
+			s = &CategoryShift{Category: category}
+			shifts[category] = s
+		}
+		return s
+	}
+	for key, count := range counts {
+		if count <= 0 {
+			continue
+		}
+		category, _, _ := strings.Cut(key, ":")
+		s := shift(category)
+		totals[category] += count
+		stat, known := b.Stats[key]
+		switch {
+		case !known || stat.SampleCount == 0:
+			s.New++
+			moved[category] += count
+		case stat.SampleCount < minShiftSamples:
+		case stat.StdDev == 0 && math.Abs(count-stat.Mean) > 0.5*math.Max(stat.Mean, 1),
+			stat.StdDev > 0 && math.Abs(CalculateZScore(count, stat.Mean, stat.StdDev)) > b.ThresholdFor(key):
+			s.Shifted++
+			moved[category] += math.Abs(count - stat.Mean)
+		}
+	}
+	for key, stat := range b.Stats {
+		// Only keys active in every interval are expected in this one.
+		category, _, labels := ParseStatKey(key)
+		if labels != nil || stat.SampleCount < minShiftSamples || stat.Min < 1 || counts[key] > 0 {
+			continue
+		}
+		s := shift(category)
+		s.Missing++
+		totals[category] += stat.Mean
+		moved[category] += stat.Mean
+	}
+	result := make([]CategoryShift, 0, len(shifts))
+	for category, s := range shifts {
+		if totals[category] > 0 {
+			s.Share = math.Min(moved[category]/totals[category], 1)
+		}
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Category < result[j].Category })
+	return result
+}
@@ -0,0 +1,132 @@
+package baseline
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// DefaultEscalationWindow and DefaultEscalationAfter are the recurrence
+// window and the number of consecutive windows after which the escalation
+// processor raises an anomaly's severity by default.
+const (
+	DefaultEscalationWindow = time.Hour
+	DefaultEscalationAfter  = 3
+)
+
+// Recurrence is the recurrence history of an anomaly: the same type and
+// evidence raised in consecutive windows. See Escalator.
+type Recurrence struct {
+	// Windows is how many consecutive windows, this one included, the
+	// anomaly was raised in, and Count how many times in all.
+	Windows int
+	Count   int
+	// Since is the start of the first of those windows.
+	Since  time.Time
+	Window time.Duration
+	// Original is the severity the anomaly was raised with, set when the
+	// policy escalated it.
+	Original string `json:",omitempty"`
+}
+
+// EscalationPolicy decides the severity of a recurring anomaly from the
+// severity it was raised with and its recurrence.
+type EscalationPolicy interface {
+	Escalate(level string, r Recurrence) string
+}
+
+// EscalationFunc adapts a function to an EscalationPolicy.
+type EscalationFunc func(level string, r Recurrence) string
+
+// Escalate implements EscalationPolicy.
+func (f EscalationFunc) Escalate(level string, r Recurrence) string { return f(level, r) }
+
+// StepEscalation raises an anomaly one severity level for every after
+// consecutive windows it recurs in, up to ceiling (the highest level when
+// empty). Anomalies already above ceiling keep their severity.
+func StepEscalation(after int, ceiling string) EscalationPolicy {
+	return EscalationFunc(func(level string, r Recurrence) string {
+		for steps := r.Windows / after; steps > 0; steps-- {
+			if ceiling != "" && severity.AtLeast(level, ceiling) {
+				break
+			}
+			level = severity.Raise(level)
+		}
+		return level
+	})
+}
+
+// ThresholdEscalation raises an anomaly to at least target once it has
+// recurred in after consecutive windows.
+func ThresholdEscalation(after int, target string) EscalationPolicy {
+	return EscalationFunc(func(level string, r Recurrence) string {
+		if r.Windows >= after && !severity.AtLeast(level, target) {
+			return target
+		}
+		return level
+	})
+}
+
+// Escalator tracks how anomalies recur across fixed windows and has its
+// policy escalate those that persist, so that a low-grade deviation raised
+// hour after hour eventually surfaces. Anomalies are the same when they
+// share type and evidence; a window without one ends its recurrence.
+type Escalator struct {
+	Window time.Duration
+	Policy EscalationPolicy
+
+	seen   map[string]*recurrence
+	latest time.Time // start of the latest window seen
+}
+
+type recurrence struct {
+	Recurrence
+	last time.Time // start of the last window the anomaly was raised in
+}
+
+// NewEscalator returns an escalator over windows of the given length.
+func NewEscalator(window time.Duration, policy EscalationPolicy) *Escalator {
+	return &Escalator{Window: window, Policy: policy, seen: make(map[string]*recurrence)}
+}
+
+// Observe records a, raised at its timestamp, and returns it annotated
+// with its recurrence and with the severity the policy gives it. The
+// description of an escalated anomaly says why.
+func (e *Escalator) Observe(a Anomaly) Anomaly {
+	start := a.Timestamp.Truncate(e.Window)
+	if start.After(e.latest) {
+		e.latest = start
+		// Forget anomalies whose recurrence has ended.
+		for key, r := range e.seen {
+			if e.latest.Sub(r.last) > e.Window {
+				delete(e.seen, key)
+			}
+		}
+	}
+	key := a.Type + "\x00" + a.Evidence
+	r := e.seen[key]
+	switch {
+	case r == nil || start.Sub(r.last) > e.Window:
+		r = &recurrence{Recurrence: Recurrence{Since: start, Window: e.Window}}
+		e.seen[key] = r
+		r.Windows = 1
+	case start.Sub(r.last) == e.Window:
+		r.Windows++
+	case start.Before(r.last):
+		// Late anomalies count toward the window under way.
+		start = r.last
+	}
+	r.last = start
+	r.Count++
+
+	history := r.Recurrence
+	if level := e.Policy.Escalate(a.Severity, history); level != a.Severity {
+		history.Original = a.Severity
+		a.Severity = level
+		a.Description += fmt.Sprintf(" (escalated from %s: recurred in %d consecutive %s windows since %s)",
+			history.Original, history.Windows, e.Window, history.Since.UTC().Format(time.RFC3339))
+	}
+	a.Recurrence = &history
+	return a
+}
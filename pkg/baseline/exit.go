@@ -0,0 +1,131 @@
+package baseline
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// MinCrashLoopExits is how many times an executable must exit in one
+// interval before its restart rate is reported, however unusual it is.
+const MinCrashLoopExits = 3
+
+// crashSignals are the signals a process dies of when it crashes, rather
+// than when it is stopped.
+var crashSignals = map[string]bool{
+	"SIGSEGV": true, "SIGBUS": true, "SIGILL": true, "SIGFPE": true, "SIGABRT": true, "SIGSYS": true, "SIGTRAP": true,
+}
+
+// ExitProfile is how an executable, or a container, has been seen to
+// exit.
+type ExitProfile struct {
+	// Statuses counts the exits by exit code, such as "1", or signal,
+	// such as "SIGTERM".
+	Statuses map[string]int
+	// Restarts holds the exits per interval, over the intervals with
+	// any.
+	Restarts Stat
+}
+
+// RecordExits learns the exits of one interval by executable and status,
+// such as the output of detect.Exits. Executables that did not exit in the
+// interval are not updated; an interval without exits is not a sample.
+func (b *Baseline) RecordExits(exits map[string]map[string]int) {
+	if len(exits) == 0 {
+		return
+	}
+	if b.Exits == nil {
+		b.Exits = make(map[string]ExitProfile)
+	}
+	now := b.now()
+	for executable, statuses := range exits {
+		profile := b.Exits[executable]
+		if profile.Statuses == nil {
+			profile.Statuses = make(map[string]int)
+		}
+		total := 0
+		for status, n := range statuses {
+			if status != "" {
+				profile.Statuses[status] += n
+			}
+			total += n
+		}
+		profile.Restarts = foldStat(profile.Restarts, float64(total), now)
+		b.Exits[executable] = profile
+	}
+	b.UpdatedAt = now
+}
+
+// ExitAnomaly reports an exit with a status the executable has not been
+// seen to exit with: a new non-zero exit code, or death by a signal. A
+// crash, death by a signal such as SIGSEGV, is HIGH; other new statuses
+// are MEDIUM. Clean exits, and exits whose status is unknown, are never
+// reported.
+func (b *Baseline) ExitAnomaly(executable, status string) (Anomaly, bool) {
+	if status == "" || status == "0" || b.Exits[executable].Statuses[status] > 0 {
+		return Anomaly{}, false
+	}
+	level, kind := severity.Label(severity.Medium), "New Exit Status"
+	description := fmt.Sprintf("%s exited with status %s, never seen in the baseline", executable, status)
+	if strings.HasPrefix(status, "SIG") {
+		description = fmt.Sprintf("%s was killed by %s, never seen in the baseline", executable, status)
+		if crashSignals[status] {
+			level, kind = severity.Label(severity.High), "Process Crash"
+			description = fmt.Sprintf("%s crashed with %s", executable, status)
+		}
+	}
+	return Anomaly{
+		Type:        kind,
+		Description: description,
+		Severity:    level,
+		Evidence:    "exit:" + executable + ":" + status,
+		Category:    "exit",
+		Confidence:  1,
+		Timestamp:   b.now(),
+		RiskLevel:   level,
+	}, true
+}
+
+// RestartAnomaly reports an executable exiting exits times in one
+// interval, when that is at least MinCrashLoopExits and more than it
+// usually exits: above the anomaly threshold for its "restart:" key in
+// standard deviations, or above every learned interval when they all had
+// the same number of exits. An executable never seen exiting restarting
+// MinCrashLoopExits times is reported too.
+func (b *Baseline) RestartAnomaly(executable string, exits int) (Anomaly, bool) {
+	if exits < MinCrashLoopExits {
+		return Anomaly{}, false
+	}
+	key := "restart:" + executable
+	stat := b.Exits[executable].Restarts
+	value := float64(exits)
+	z := math.Inf(1)
+	switch {
+	case stat.SampleCount == 0:
+	case stat.StdDev == 0:
+		if value <= stat.Mean {
+			return Anomaly{}, false
+		}
+	default:
+		if z = CalculateZScore(value, stat.Mean, stat.StdDev); z <= b.ThresholdFor(key) {
+			return Anomaly{}, false
+		}
+	}
+	description := fmt.Sprintf("%s exited %d times in one interval, usually %.1f", executable, exits, stat.Mean)
+	if stat.SampleCount == 0 {
+		description = fmt.Sprintf("%s exited %d times in one interval, never seen exiting in the baseline", executable, exits)
+	}
+	level := severity.Label(severity.High)
+	return Anomaly{
+		Type:        "Crash Loop",
+		Description: description,
+		Severity:    level,
+		Evidence:    key,
+		Category:    "restart",
+		Confidence:  calculateConfidence(math.Min(z, 10)),
+		Timestamp:   b.now(),
+		RiskLevel:   level,
+	}, true
+}
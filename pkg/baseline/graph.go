@@ -0,0 +1,176 @@
+package baseline
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// Edge kinds of the behavior graph.
+const (
+	EdgeSpawn   = "spawn"   // process → process it started
+	EdgeConnect = "connect" // process → network destination
+	EdgeWrite   = "write"   // process → file it wrote
+)
+
+// edgeCategories are the categories of the behavior edges of each kind
+// stand for.
+var edgeCategories = map[string]string{
+	EdgeSpawn:   "process",
+	EdgeConnect: "network",
+	EdgeWrite:   "file.write",
+}
+
+// MaxGraphEdges bounds the edges a baseline's graph learns, so workloads
+// writing ever-new file names cannot grow it without limit. Edges beyond
+// it are not learned.
+var MaxGraphEdges = 10000
+
+// graphContext is how many learned edges a new edge is reported with.
+const graphContext = 5
+
+// Edge is a relationship in a baseline's behavior graph. Processes are
+// named by executable base name, destinations by host:port and files by
+// path.
+type Edge struct {
+	Kind      string
+	From      string
+	To        string
+	Count     int
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// Key returns the key the edge is kept under in Baseline.Graph, e.g.
+// "spawn:nginx->sh".
+func (e Edge) Key() string {
+	return e.Kind + ":" + e.From + "->" + e.To
+}
+
+// RecordEdges learns edges, adding their counts to those already known.
+func (b *Baseline) RecordEdges(edges []Edge) {
+	for _, e := range edges {
+		if e.Count < 1 {
+			e.Count = 1
+		}
+		if e.LastSeen.IsZero() {
+			e.LastSeen = b.now()
+		}
+		if e.FirstSeen.IsZero() {
+			e.FirstSeen = e.LastSeen
+		}
+		key := e.Key()
+		known, ok := b.Graph[key]
+		if !ok {
+			if len(b.Graph) >= MaxGraphEdges {
+				continue
+			}
+			if b.Graph == nil {
+				b.Graph = make(map[string]Edge)
+			}
+			b.Graph[key] = e
+			continue
+		}
+		known.Count += e.Count
+		if e.LastSeen.After(known.LastSeen) {
+			known.LastSeen = e.LastSeen
+		}
+		if e.FirstSeen.Before(known.FirstSeen) {
+			known.FirstSeen = e.FirstSeen
+		}
+		b.Graph[key] = known
+	}
+	if len(edges) > 0 {
+		b.UpdatedAt = b.now()
+	}
+}
+
+// EdgeAnomaly reports e as an anomaly when the baseline has learned a
+// graph and e is not in it. The anomaly's GraphContext holds the most
+// frequent learned edges of the same kind out of e.From and, for spawns,
+// into e.To, and its description summarizes them.
+func (b *Baseline) EdgeAnomaly(e Edge) (Anomaly, bool) {
+	if len(b.Graph) == 0 {
+		return Anomaly{}, false
+	}
+	if _, known := b.Graph[e.Key()]; known {
+		return Anomaly{}, false
+	}
+
+	var from, into []Edge
+	knownFrom := false
+	for _, g := range b.Graph {
+		if g.From == e.From || g.To == e.From {
+			knownFrom = true
+		}
+		if g.Kind != e.Kind {
+			continue
+		}
+		if g.From == e.From {
+			from = append(from, g)
+		}
+		if e.Kind == EdgeSpawn && g.To == e.To {
+			into = append(into, g)
+		}
+	}
+	from, into = topEdges(from), topEdges(into)
+
+	verb := map[string]string{EdgeSpawn: "spawned", EdgeConnect: "connected to", EdgeWrite: "wrote"}[e.Kind]
+	description := fmt.Sprintf("%s %s %s, an edge not in the behavior graph", e.From, verb, e.To)
+	switch {
+	case !knownFrom:
+		description += fmt.Sprintf("; %s is new to the graph", e.From)
+	case len(from) > 0:
+		description += fmt.Sprintf("; %s usually %s %s", e.From, verb, edgeEnds(from, false))
+	default:
+		description += fmt.Sprintf("; %s never %s anything before", e.From, verb)
+	}
+	if len(into) > 0 {
+		description += fmt.Sprintf("; %s is usually started by %s", e.To, edgeEnds(into, true))
+	}
+
+	level := severity.Label(severity.Medium)
+	if e.Kind == EdgeSpawn {
+		level = severity.Label(severity.High)
+	}
+	return Anomaly{
+		Type:         "New Graph Edge",
+		Description:  description,
+		Severity:     level,
+		Evidence:     e.Key(),
+		Category:     edgeCategories[e.Kind],
+		Confidence:   0.8,
+		Timestamp:    e.LastSeen,
+		RiskLevel:    level,
+		GraphContext: append(from, into...),
+	}, true
+}
+
+// topEdges returns the graphContext most frequent edges.
+func topEdges(edges []Edge) []Edge {
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Count != edges[j].Count {
+			return edges[i].Count > edges[j].Count
+		}
+		return edges[i].Key() < edges[j].Key()
+	})
+	if len(edges) > graphContext {
+		edges = edges[:graphContext]
+	}
+	return edges
+}
+
+// edgeEnds lists the targets, or with sources the origins, of edges.
+func edgeEnds(edges []Edge, sources bool) string {
+	names := make([]string, len(edges))
+	for i, e := range edges {
+		names[i] = e.To
+		if sources {
+			names[i] = e.From
+		}
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,328 @@
+package baseline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// DefaultIncidentWindow is how long an incident stays open for related
+// anomalies after its last one.
+const DefaultIncidentWindow = 5 * time.Minute
+
+// MaxIncidentAnomalies bounds the anomalies an incident keeps; later ones
+// still count towards Count and the combined score.
+const MaxIncidentAnomalies = 100
+
+// maxIncidentKeys bounds the relations an incident is matched on.
+const maxIncidentKeys = 1024
+
+// incidentAncestors is how many ancestors of an anomaly's process count as
+// its process tree. Going higher would join everything under init or a
+// container's shim.
+const incidentAncestors = 2
+
+// Incident groups related anomalies: those close in time that share a
+// process tree, a network destination or their evidence.
+type Incident struct {
+	ID           string
+	Start        time.Time
+	End          time.Time
+	Score        float64 // combined internal score, 0-100
+	Severity     string
+	Count        int       // anomalies grouped, including those not kept
+	Processes    []string  `json:",omitempty"`
+	Destinations []string  `json:",omitempty"`
+	Anomalies    []Anomaly // the first MaxIncidentAnomalies
+
+	keys   map[string]bool
+	scores map[string]float64 // type and evidence → highest score
+}
+
+// Anomaly returns the incident as a single anomaly, to alert on instead
+// of its members. An incident of one anomaly is that anomaly; otherwise it
+// is of type "Incident" and carries the lineage and remediation of the
+// most severe member. Either way Incident is set.
+func (inc *Incident) Anomaly() Anomaly {
+	snapshot := *inc
+	snapshot.Anomalies = append([]Anomaly(nil), inc.Anomalies...)
+	snapshot.keys, snapshot.scores = nil, nil
+	if inc.Count == 1 {
+		a := inc.Anomalies[0]
+		a.Incident = &snapshot
+		return a
+	}
+
+	types := make(map[string]int)
+	worst := inc.Anomalies[0]
+	for _, a := range inc.Anomalies {
+		types[a.Type]++
+		if severity.Score(a.Severity) > severity.Score(worst.Severity) {
+			worst = a
+		}
+	}
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if types[names[i]] != types[names[j]] {
+			return types[names[i]] > types[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	for i, name := range names {
+		names[i] = fmt.Sprintf("%d %s", types[name], name)
+	}
+	description := fmt.Sprintf("%d related anomalies (%s)", inc.Count, strings.Join(names, ", "))
+	if len(inc.Processes) > 0 {
+		description += "; processes " + strings.Join(inc.Processes, ", ")
+	}
+	if len(inc.Destinations) > 0 {
+		description += "; destinations " + strings.Join(inc.Destinations, ", ")
+	}
+	return Anomaly{
+		Type:        "Incident",
+		Description: description,
+		Severity:    inc.Severity,
+		Evidence:    inc.ID,
+		Confidence:  inc.Score / 100,
+		Timestamp:   inc.End,
+		RiskLevel:   inc.Severity,
+		PID:         worst.PID,
+		Process:     worst.Process,
+		Lineage:     worst.Lineage,
+		Enrichment:  worst.Enrichment,
+		Remediation: worst.Remediation,
+		Incident:    &snapshot,
+	}
+}
+
+// add groups a into the incident.
+func (inc *Incident) add(a Anomaly, keys []string) {
+	if inc.Count == 0 || a.Timestamp.Before(inc.Start) {
+		inc.Start = a.Timestamp
+	}
+	if a.Timestamp.After(inc.End) {
+		inc.End = a.Timestamp
+	}
+	inc.Count++
+	if len(inc.Anomalies) < MaxIncidentAnomalies {
+		inc.Anomalies = append(inc.Anomalies, a)
+	}
+	for _, key := range keys {
+		if len(inc.keys) >= maxIncidentKeys || inc.keys[key] {
+			continue
+		}
+		inc.keys[key] = true
+		if name, ok := strings.CutPrefix(key, "dest:"); ok {
+			inc.Destinations = append(inc.Destinations, name)
+		}
+	}
+	if a.Process != "" && !slices.Contains(inc.Processes, a.Process) && len(inc.Processes) < maxIncidentKeys {
+		inc.Processes = append(inc.Processes, a.Process)
+	}
+	confidence := a.Confidence
+	if confidence <= 0 || confidence > 1 {
+		confidence = 1
+	}
+	id := a.Type + " " + a.Evidence
+	if score := severity.Score(a.Severity) * confidence; score > inc.scores[id] {
+		inc.scores[id] = score
+	}
+	inc.rescore()
+}
+
+// merge moves other's anomalies into the incident.
+func (inc *Incident) merge(other *Incident) {
+	if other.Start.Before(inc.Start) {
+		inc.Start = other.Start
+	}
+	if other.End.After(inc.End) {
+		inc.End = other.End
+	}
+	inc.Count += other.Count
+	inc.Anomalies = append(inc.Anomalies, other.Anomalies...)
+	if len(inc.Anomalies) > MaxIncidentAnomalies {
+		inc.Anomalies = inc.Anomalies[:MaxIncidentAnomalies]
+	}
+	for key := range other.keys {
+		if len(inc.keys) < maxIncidentKeys {
+			inc.keys[key] = true
+		}
+	}
+	for _, name := range other.Processes {
+		if !slices.Contains(inc.Processes, name) {
+			inc.Processes = append(inc.Processes, name)
+		}
+	}
+	for _, name := range other.Destinations {
+		if !slices.Contains(inc.Destinations, name) {
+			inc.Destinations = append(inc.Destinations, name)
+		}
+	}
+	for id, score := range other.scores {
+		if score > inc.scores[id] {
+			inc.scores[id] = score
+		}
+	}
+	inc.rescore()
+}
+
+// rescore combines the scores of the incident's distinct anomalies as
+// independent evidence, 100 × (1 − Π(1 − score/100)), so that several
+// related findings outrank any one of them while repeats of the same one
+// do not. The severity is that of the combined score, and never below
+// the most severe member.
+func (inc *Incident) rescore() {
+	remaining := 1.0
+	for _, score := range inc.scores {
+		remaining *= 1 - score/100
+	}
+	inc.Score = 100 * (1 - remaining)
+	inc.Severity = severity.Current().ForScore(inc.Score).Name
+	for _, a := range inc.Anomalies {
+		if !severity.AtLeast(inc.Severity, a.Severity) {
+			inc.Severity = a.Severity
+		}
+	}
+}
+
+// incidentKeys returns what relates a to other anomalies: the processes of
+// its tree, its network destination and its evidence.
+func incidentKeys(a Anomaly) []string {
+	keys := []string{"evidence:" + a.Type + " " + a.Evidence}
+	pids := []int{a.PID}
+	for i, p := range a.Lineage {
+		if i > incidentAncestors {
+			break
+		}
+		pids = append(pids, p.PID)
+	}
+	for _, pid := range pids {
+		if pid > 1 {
+			keys = append(keys, "pid:"+strconv.Itoa(pid))
+		}
+	}
+	if a.PID <= 0 && a.Process != "" {
+		keys = append(keys, "process:"+a.Process)
+	}
+	if dest := anomalyDestination(a); dest != "" {
+		keys = append(keys, "dest:"+dest)
+	}
+	return keys
+}
+
+// anomalyDestination returns the network destination an anomaly is about,
+// from evidence such as "network:203.0.113.7:4444" or a connect edge.
+func anomalyDestination(a Anomaly) string {
+	if _, to, ok := strings.Cut(a.Evidence, "->"); ok && strings.HasPrefix(a.Evidence, EdgeConnect+":") {
+		return to
+	}
+	if category, pattern := a.Behavior(); category == "network" {
+		return pattern
+	}
+	return ""
+}
+
+// Clusterer groups a stream of anomalies into incidents. Anomalies are
+// related when they share a process tree (the process or one of its
+// nearest ancestors), a network destination or their type and evidence,
+// and an incident takes in related anomalies until Window passes without
+// one. An anomaly related to several open incidents joins them into one.
+type Clusterer struct {
+	Window time.Duration
+
+	open   []*Incident
+	closed []*Incident // kept for Cluster
+	keep   bool
+}
+
+// NewClusterer returns a clusterer keeping incidents open for window after
+// their last anomaly.
+func NewClusterer(window time.Duration) *Clusterer {
+	return &Clusterer{Window: window}
+}
+
+// Add groups a into an open incident, or opens one, and returns it and
+// whether it is new.
+func (c *Clusterer) Add(a Anomaly) (*Incident, bool) {
+	open := c.open[:0]
+	for _, inc := range c.open {
+		if a.Timestamp.Sub(inc.End) <= c.Window {
+			open = append(open, inc)
+		} else if c.keep {
+			c.closed = append(c.closed, inc)
+		}
+	}
+	c.open = open
+
+	keys := incidentKeys(a)
+	var found *Incident
+	open = c.open[:0]
+	for _, inc := range c.open {
+		related := false
+		for _, key := range keys {
+			if inc.keys[key] {
+				related = true
+				break
+			}
+		}
+		switch {
+		case !related:
+			open = append(open, inc)
+		case found == nil:
+			found = inc
+			open = append(open, inc)
+		default:
+			// Keep the older incident, and with it its ID.
+			if inc.Start.Before(found.Start) {
+				inc, found = found, inc
+			}
+			found.merge(inc)
+			for i, o := range open {
+				if o == inc {
+					open[i] = found
+				}
+			}
+		}
+	}
+	c.open = open
+
+	isNew := found == nil
+	if isNew {
+		sum := sha256.Sum256([]byte(a.Type + "\x00" + a.Evidence + "\x00" + a.Timestamp.UTC().Format(time.RFC3339Nano)))
+		found = &Incident{
+			ID:     "inc-" + hex.EncodeToString(sum[:6]),
+			keys:   make(map[string]bool),
+			scores: make(map[string]float64),
+		}
+		c.open = append(c.open, found)
+	}
+	found.add(a, keys)
+	return found, isNew
+}
+
+// Cluster groups anomalies into incidents (see Clusterer), ordered by when
+// they started.
+func Cluster(anomalies []Anomaly, window time.Duration) []Incident {
+	sorted := append([]Anomaly(nil), anomalies...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Timestamp.Before(sorted[j].Timestamp) })
+	c := &Clusterer{Window: window, keep: true}
+	for _, a := range sorted {
+		c.Add(a)
+	}
+	incidents := make([]Incident, 0, len(c.closed)+len(c.open))
+	for _, inc := range append(c.closed, c.open...) {
+		incidents = append(incidents, *inc)
+	}
+	sort.SliceStable(incidents, func(i, j int) bool { return incidents[i].Start.Before(incidents[j].Start) })
+	return incidents
+}
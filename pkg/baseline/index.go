@@ -0,0 +1,118 @@
+package baseline
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// CommonFraction is the share of the other baselines a pattern must have
+// been seen in to count as common fleet-wide.
+const CommonFraction = 0.5
+
+// Index records which baselines have observed each pattern, so a pattern
+// new to one application can be told apart from one never seen anywhere.
+// Keys are stats keys without labels, e.g. "syscall:openat".
+type Index struct {
+	Patterns  map[string][]string // key → sorted names of baselines that observed it
+	Baselines []string            // sorted names of all indexed baselines
+}
+
+// NewIndex returns an empty index.
+func NewIndex() *Index {
+	return &Index{Patterns: make(map[string][]string)}
+}
+
+// Update replaces what the index holds for b with the patterns b has
+// observed. Seeded patterns, expected but never observed, are left out, as
+// are candidate baselines, which would otherwise vouch for patterns new to
+// the baseline they shadow.
+func (x *Index) Update(b *Baseline) {
+	x.Remove(b.Name)
+	if b.CandidateFor != "" {
+		return
+	}
+	x.Baselines = insertSorted(x.Baselines, b.Name)
+	seen := make(map[string]bool)
+	for key, stat := range b.Stats {
+		if stat.Seeded || stat.SampleCount == 0 {
+			continue
+		}
+		category, pattern, _ := ParseStatKey(key)
+		key = StatKey(category, pattern, nil)
+		if !seen[key] {
+			seen[key] = true
+			x.Patterns[key] = insertSorted(x.Patterns[key], b.Name)
+		}
+	}
+}
+
+// Remove drops a baseline from the index.
+func (x *Index) Remove(name string) {
+	if x.Patterns == nil {
+		x.Patterns = make(map[string][]string)
+	}
+	x.Baselines = removeSorted(x.Baselines, name)
+	for key, names := range x.Patterns {
+		if names = removeSorted(names, name); len(names) == 0 {
+			delete(x.Patterns, key)
+		} else {
+			x.Patterns[key] = names
+		}
+	}
+}
+
+// Prevalence returns how many baselines other than name have observed key,
+// and how many other baselines there are.
+func (x *Index) Prevalence(key, name string) (seen, others int) {
+	seen = len(removeSorted(append([]string(nil), x.Patterns[key]...), name))
+	others = len(removeSorted(append([]string(nil), x.Baselines...), name))
+	return seen, others
+}
+
+// Adjust scores an anomaly about key, new to the named baseline, by how
+// common key is across the fleet. A pattern other baselines have seen is
+// lowered one severity level, and a pattern at least CommonFraction of them
+// have seen two, with confidence reduced to match; a pattern seen nowhere
+// is left as it is. It reports whether a was changed.
+func (x *Index) Adjust(a *Anomaly, name, key string) bool {
+	if x == nil {
+		return false
+	}
+	category, pattern, _ := ParseStatKey(key)
+	seen, others := x.Prevalence(category+":"+pattern, name)
+	if seen == 0 {
+		return false
+	}
+	fraction := float64(seen) / float64(others)
+	a.FleetBaselines = seen
+	a.Severity = severity.Lower(a.Severity)
+	a.RiskLevel = severity.Lower(a.RiskLevel)
+	if fraction >= CommonFraction && seen > 1 {
+		a.Severity = severity.Lower(a.Severity)
+		a.RiskLevel = severity.Lower(a.RiskLevel)
+	}
+	a.Confidence *= 1 - fraction/2
+	a.Description += fmt.Sprintf(" (seen in %d of %d other baselines)", seen, others)
+	return true
+}
+
+func insertSorted(names []string, name string) []string {
+	i := sort.SearchStrings(names, name)
+	if i < len(names) && names[i] == name {
+		return names
+	}
+	names = append(names, "")
+	copy(names[i+1:], names[i:])
+	names[i] = name
+	return names
+}
+
+func removeSorted(names []string, name string) []string {
+	i := sort.SearchStrings(names, name)
+	if i == len(names) || names[i] != name {
+		return names
+	}
+	return append(names[:i], names[i+1:]...)
+}
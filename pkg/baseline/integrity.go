@@ -0,0 +1,86 @@
+package baseline
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// FileIntegrity tracks the content hashes observed for one path.
+type FileIntegrity struct {
+	Hashes    map[string]int // hash -> times observed
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// Stable reports whether the path has only ever had one hash.
+func (f FileIntegrity) Stable() bool {
+	return len(f.Hashes) == 1
+}
+
+// RecordFileHash learns that path had the given content hash.
+func (b *Baseline) RecordFileHash(path, hash string) {
+	if path == "" || hash == "" {
+		return
+	}
+	if b.Integrity == nil {
+		b.Integrity = make(map[string]FileIntegrity)
+	}
+
+	now := b.now()
+	record, exists := b.Integrity[path]
+	if !exists {
+		record = FileIntegrity{Hashes: make(map[string]int), FirstSeen: now}
+	}
+	record.Hashes[hash]++
+	record.LastSeen = now
+	b.Integrity[path] = record
+	b.UpdatedAt = now
+}
+
+// CheckExecutedBinary checks an executed binary's hash against learned
+// integrity data. It reports an anomaly when the binary is unknown or when a
+// binary whose hash has been stable now has a different one. Binaries whose
+// hash already varies during learning only raise a low-severity anomaly.
+func (b *Baseline) CheckExecutedBinary(path, hash string) (Anomaly, bool) {
+	if path == "" || hash == "" {
+		return Anomaly{}, false
+	}
+
+	record, known := b.Integrity[path]
+	if !known {
+		return Anomaly{
+			Type:        "Unknown Binary",
+			Description: "Executed binary was never seen during learning",
+			Severity:    severity.Label(severity.High),
+			Evidence:    fmt.Sprintf("%s sha256=%s", path, hash),
+			Category:    "process",
+			Confidence:  0.8,
+			Timestamp:   b.now(),
+			RiskLevel:   severity.Label(severity.High),
+		}, true
+	}
+
+	if _, seen := record.Hashes[hash]; seen {
+		return Anomaly{}, false
+	}
+
+	anomaly := Anomaly{
+		Type:        "Binary Hash Changed",
+		Description: "Executed binary content differs from the learned hash",
+		Severity:    severity.Label(severity.Critical),
+		Evidence:    fmt.Sprintf("%s sha256=%s", path, hash),
+		Category:    "process",
+		Confidence:  0.95,
+		Timestamp:   b.now(),
+		RiskLevel:   severity.Label(severity.Critical),
+	}
+	if !record.Stable() {
+		anomaly.Description = "Executed binary has a new hash; its hash was already unstable during learning"
+		anomaly.Severity = severity.Label(severity.Low)
+		anomaly.Confidence = 0.4
+		anomaly.RiskLevel = severity.Label(severity.Low)
+	}
+	return anomaly, true
+}
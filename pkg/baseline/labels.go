@@ -0,0 +1,222 @@
+package baseline
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/hallucinaut/runtimebase/pkg/category"
+)
+
+// OverflowLabelValue replaces label values beyond a label's cardinality
+// limit, so one runaway label cannot explode the number of stats.
+const OverflowLabelValue = "__other__"
+
+// LabelPolicy controls how labels segment statistics.
+type LabelPolicy struct {
+	// Allowed lists the label names that segment stats; others are dropped.
+	// Empty allows every label.
+	Allowed []string `yaml:"allowed"`
+	// MaxValues caps the distinct values kept per label.
+	MaxValues map[string]int `yaml:"max_values"`
+	// DefaultMaxValues applies to labels without an entry in MaxValues.
+	DefaultMaxValues int `yaml:"default_max_values"`
+}
+
+// DefaultLabelPolicy allows any label with up to 20 values each.
+var DefaultLabelPolicy = LabelPolicy{DefaultMaxValues: 20}
+
+func (p *LabelPolicy) allowed(name string) bool {
+	if len(p.Allowed) == 0 {
+		return true
+	}
+	for _, a := range p.Allowed {
+		if a == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *LabelPolicy) maxValues(name string) int {
+	if n, ok := p.MaxValues[name]; ok {
+		return n
+	}
+	return p.DefaultMaxValues
+}
+
+// StatKey builds the stats key for a pattern and label set:
+// "category:pattern" or "category:pattern{env=prod,region=eu}". A
+// pattern containing { or }, or a label name or value containing one of
+// ,={}, is escaped with backslashes, as is one ending in a backslash, so
+// ParseStatKey recovers it; others, such as Windows paths, are kept as
+// they are.
+func StatKey(category, pattern string, labels map[string]string) string {
+	key := category + ":" + escapeKeyPart(pattern, "{}")
+	if len(labels) == 0 {
+		return key
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = escapeKeyPart(name, labelSpecials) + "=" + escapeKeyPart(labels[name], labelSpecials)
+	}
+	return key + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// ParseStatKey splits a stats key built by StatKey.
+func ParseStatKey(key string) (category, pattern string, labels map[string]string) {
+	if i := indexUnescaped(key, '{'); i >= 0 && strings.HasSuffix(key, "}") && indexUnescaped(key[i+1:], '}') == len(key)-i-2 {
+		for _, pair := range splitUnescaped(key[i+1:len(key)-1], ',') {
+			if j := indexUnescaped(pair, '='); j >= 0 {
+				if labels == nil {
+					labels = make(map[string]string)
+				}
+				labels[unescapeKeyPart(pair[:j], labelSpecials)] = unescapeKeyPart(pair[j+1:], labelSpecials)
+			}
+		}
+		key = key[:i]
+	}
+	category, pattern, _ = strings.Cut(key, ":")
+	return category, unescapeKeyPart(pattern, "{}"), labels
+}
+
+// labelSpecials are the characters escaped in the label names and values
+// of stats keys.
+const labelSpecials = ",={}"
+
+// escapeKeyPart escapes backslashes and specials in s with backslashes if
+// s contains a special or ends in a backslash. Unescaped parts contain
+// neither, which tells unescapeKeyPart whether to unescape.
+func escapeKeyPart(s, specials string) string {
+	if !strings.ContainsAny(s, specials) && !strings.HasSuffix(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' || strings.IndexByte(specials, s[i]) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// unescapeKeyPart reverses escapeKeyPart.
+func unescapeKeyPart(s, specials string) string {
+	if !strings.ContainsAny(s, specials) && !strings.HasSuffix(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// indexUnescaped returns the index of the first c in s not escaped with a
+// backslash, or -1.
+func indexUnescaped(s string, c byte) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case c:
+			return i
+		}
+	}
+	return -1
+}
+
+// splitUnescaped splits s around each sep not escaped with a backslash.
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+	for {
+		i := indexUnescaped(s, sep)
+		if i < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+1:]
+	}
+}
+
+// Behavior returns the category and pattern of the behavior a reports,
+// without labels: its Category and its evidence, or, for anomalies that
+// do not set Category, those of evidence that is a statistics key such as
+// "network:10.0.0.9:443". It returns "" for anomalies about no one kind of
+// behavior.
+func (a Anomaly) Behavior() (string, string) {
+	key := a.Evidence
+	if a.Category != "" && !strings.HasPrefix(key, a.Category+":") {
+		key = a.Category + ":" + key
+	}
+	if category.Of(key) == "" {
+		return "", ""
+	}
+	c, pattern, _ := ParseStatKey(key)
+	return c, pattern
+}
+
+// normalizeLabels applies the label policy: disallowed labels are dropped
+// and values past a label's cardinality limit become OverflowLabelValue.
+// New values are admitted against the limit, so only learning may call it;
+// detection uses lookupLabels.
+func (b *Baseline) normalizeLabels(labels map[string]string) map[string]string {
+	return b.applyLabelPolicy(labels, true)
+}
+
+// lookupLabels is normalizeLabels without admitting new values: a value
+// not seen yet is kept while the label is under its limit, so it matches
+// no stat, and becomes OverflowLabelValue once the label is at it.
+func (b *Baseline) lookupLabels(labels map[string]string) map[string]string {
+	return b.applyLabelPolicy(labels, false)
+}
+
+func (b *Baseline) applyLabelPolicy(labels map[string]string, admit bool) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	policy := b.LabelPolicy
+	if policy == nil {
+		policy = &DefaultLabelPolicy
+	}
+
+	normalized := make(map[string]string, len(labels))
+	for name, value := range labels {
+		if !policy.allowed(name) || value == "" {
+			continue
+		}
+		normalized[name] = b.labelValue(policy, name, value, admit)
+	}
+	if len(normalized) == 0 {
+		return nil
+	}
+	return normalized
+}
+
+func (b *Baseline) labelValue(policy *LabelPolicy, name, value string, admit bool) string {
+	values := b.LabelValues[name]
+	for _, v := range values {
+		if v == value {
+			return value
+		}
+	}
+	if limit := policy.maxValues(name); limit > 0 && len(values) >= limit {
+		return OverflowLabelValue
+	}
+	if admit {
+		if b.LabelValues == nil {
+			b.LabelValues = make(map[string][]string)
+		}
+		b.LabelValues[name] = append(values, value)
+	}
+	return value
+}
@@ -0,0 +1,96 @@
+package baseline
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ParseMetadata parses comma-separated key=value metadata, such as
+// "env=prod,owner=payments". Keys are letters, digits, '_', '.' and '-';
+// a key without a value, as in "env=", stands for removing the key (see
+// SetMetadata).
+func ParseMetadata(s string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || !validMetadataKey(key) {
+			return nil, fmt.Errorf("label %q: want key=value with a key of letters, digits, '_', '.' and '-'", pair)
+		}
+		metadata[key] = strings.TrimSpace(value)
+	}
+	return metadata, nil
+}
+
+func validMetadataKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '.' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatMetadata returns metadata as sorted, comma-separated key=value
+// pairs, the form ParseMetadata reads.
+func FormatMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// SetMetadata sets metadata on b, replacing the values of keys it has;
+// keys with an empty value are removed.
+func (b *Baseline) SetMetadata(metadata map[string]string) {
+	for key, value := range metadata {
+		if value == "" {
+			delete(b.Metadata, key)
+			continue
+		}
+		if b.Metadata == nil {
+			b.Metadata = make(map[string]string)
+		}
+		b.Metadata[key] = value
+	}
+}
+
+// MatchMetadata reports whether b has every key of selector, with the
+// same value; a selector key with an empty value only needs to be set.
+func (b *Baseline) MatchMetadata(selector map[string]string) bool {
+	for key, want := range selector {
+		if value, ok := b.Metadata[key]; !ok || want != "" && value != want {
+			return false
+		}
+	}
+	return true
+}
+
+// ApplyMetadata adds b's metadata to anomalies it raised, so alerts can
+// be routed by it, such as to the team a baseline's owner key names. Keys
+// an anomaly already has are kept. A nil baseline adds none.
+func (b *Baseline) ApplyMetadata(anomalies []Anomaly) {
+	if b == nil || len(b.Metadata) == 0 {
+		return
+	}
+	for i := range anomalies {
+		a := &anomalies[i]
+		if a.Metadata == nil {
+			a.Metadata = make(map[string]string, len(b.Metadata))
+		}
+		for key, value := range b.Metadata {
+			if _, set := a.Metadata[key]; !set {
+				a.Metadata[key] = value
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package baseline
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/clock"
+)
+
+// Storage persists baselines for a Learner. *storage.Store implements it.
+type Storage interface {
+	SaveBaseline(b *Baseline) error
+	LoadBaseline(name string) (*Baseline, error)
+}
+
+// DefaultThreshold is the default anomaly threshold in standard deviations.
+const DefaultThreshold = 3.0
+
+// DefaultMinSamples is the default number of samples a key needs before
+// deviations from it are reported as anomalies rather than as
+// InsufficientData.
+const DefaultMinSamples = 5
+
+// Option configures a Learner or, passed to CreateBaseline, one baseline.
+type Option func(*options)
+
+type options struct {
+	storage    Storage
+	threshold  float64
+	minSamples int
+	coldStart  time.Duration
+	// bucketWidth and lateness configure timestamped observations.
+	bucketWidth time.Duration
+	lateness    time.Duration
+	clock       clock.Clock
+	logger      *slog.Logger
+	namespace   string
+}
+
+func defaultOptions() options {
+	return options{
+		threshold: DefaultThreshold,
+		clock:     clock.System,
+		logger:    slog.Default(),
+	}
+}
+
+// WithStorage makes the learner load unknown baselines from s and persist
+// them with Learner.Save.
+func WithStorage(s Storage) Option {
+	return func(o *options) { o.storage = s }
+}
+
+// WithThreshold sets the anomaly threshold, in standard deviations, of
+// created baselines.
+func WithThreshold(sigma float64) Option {
+	return func(o *options) { o.threshold = sigma }
+}
+
+// WithMinSamples sets the number of samples a key of created baselines
+// needs before deviations from it are reported as anomalies.
+func WithMinSamples(n int) Option {
+	return func(o *options) { o.minSamples = n }
+}
+
+// WithColdStart sets how much event time created baselines learn before
+// they detect in full; see Baseline.InColdStart.
+func WithColdStart(d time.Duration) Option {
+	return func(o *options) { o.coldStart = d }
+}
+
+// WithBucketWidth sets the interval of event time whose timestamped
+// observations form one sample in created baselines.
+func WithBucketWidth(d time.Duration) Option {
+	return func(o *options) { o.bucketWidth = d }
+}
+
+// WithLateness sets how long after its bucket ends a timestamped
+// observation is still counted in created baselines.
+func WithLateness(d time.Duration) Option {
+	return func(o *options) { o.lateness = d }
+}
+
+// WithClock sets the clock used for timestamps.
+func WithClock(c clock.Clock) Option {
+	return func(o *options) { o.clock = c }
+}
+
+// WithLogger sets the logger.
+func WithLogger(l *slog.Logger) Option {
+	return func(o *options) { o.logger = l }
+}
+
+// WithNamespace places created baselines in a tenant namespace. Pair it
+// with storage for the same namespace, e.g. storage.Store.WithNamespace.
+func WithNamespace(ns string) Option {
+	return func(o *options) { o.namespace = ns }
+}
@@ -0,0 +1,135 @@
+package baseline
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Override is a time-boxed exception to a baseline: behavior expected for
+// a while, such as connections to a migration target, that raises no
+// anomalies until the override expires. Unlike a suppression it belongs to
+// one baseline and ends on its own.
+type Override struct {
+	// Key is a statistics key, "category:pattern", whose pattern may be a
+	// glob. A network pattern without a port covers every port.
+	Key     string
+	Reason  string
+	Actor   string `json:",omitempty"`
+	Created time.Time
+	Expires time.Time
+}
+
+// Validate checks that the override names a key, says why and expires.
+func (o Override) Validate() error {
+	category, pattern, _ := ParseStatKey(o.Key)
+	if category == "" || pattern == "" {
+		return fmt.Errorf("override key %q: want category:pattern", o.Key)
+	}
+	if o.Reason == "" {
+		return fmt.Errorf("override %s has no reason", o.Key)
+	}
+	if o.Expires.IsZero() {
+		return fmt.Errorf("override %s does not expire", o.Key)
+	}
+	return nil
+}
+
+// Active reports whether the override is in effect at now.
+func (o Override) Active(now time.Time) bool {
+	return now.Before(o.Expires)
+}
+
+// Matches reports whether the override covers the behavior a reports,
+// whether it is new or deviates in rate; see Anomaly.Behavior. The
+// destinations of connect edges count as network keys.
+func (o Override) Matches(a Anomaly) bool {
+	category, pattern, _ := ParseStatKey(o.Key)
+	reported, observed := a.Behavior()
+	if category == "network" {
+		if dest := anomalyDestination(a); dest != "" {
+			reported, observed = "network", dest
+		}
+	}
+	if reported != category {
+		return false
+	}
+	return glob(pattern, observed) || category == "network" && !strings.Contains(pattern, ":") && glob(pattern+":*", observed)
+}
+
+// String describes the override as the audit log records it.
+func (o Override) String() string {
+	return fmt.Sprintf("until %s: %s", o.Expires.UTC().Format(time.RFC3339), o.Reason)
+}
+
+// AddOverride adds o, replacing any override of the same key.
+func (b *Baseline) AddOverride(o Override) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	b.RemoveOverride(o.Key)
+	b.Overrides = append(b.Overrides, o)
+	b.UpdatedAt = b.now()
+	return nil
+}
+
+// RemoveOverride removes the override of key and reports whether there
+// was one.
+func (b *Baseline) RemoveOverride(key string) bool {
+	for i, o := range b.Overrides {
+		if o.Key == key {
+			b.Overrides = append(b.Overrides[:i], b.Overrides[i+1:]...)
+			if len(b.Overrides) == 0 {
+				b.Overrides = nil
+			}
+			b.UpdatedAt = b.now()
+			return true
+		}
+	}
+	return false
+}
+
+// ExpireOverrides removes the overrides expired at now and returns them.
+func (b *Baseline) ExpireOverrides(now time.Time) []Override {
+	var expired []Override
+	kept := b.Overrides[:0]
+	for _, o := range b.Overrides {
+		if o.Active(now) {
+			kept = append(kept, o)
+		} else {
+			expired = append(expired, o)
+		}
+	}
+	if len(expired) > 0 {
+		b.Overrides = kept
+		if len(kept) == 0 {
+			b.Overrides = nil
+		}
+		b.UpdatedAt = b.now()
+	}
+	return expired
+}
+
+// FilterOverrides returns the anomalies no override active at now covers,
+// in order, and how many were covered.
+func (b *Baseline) FilterOverrides(anomalies []Anomaly, now time.Time) ([]Anomaly, int) {
+	if b == nil || len(b.Overrides) == 0 {
+		return anomalies, 0
+	}
+	kept := anomalies[:0:0]
+	for _, a := range anomalies {
+		if !b.overridden(a, now) {
+			kept = append(kept, a)
+		}
+	}
+	return kept, len(anomalies) - len(kept)
+}
+
+func (b *Baseline) overridden(a Anomaly, now time.Time) bool {
+	for _, o := range b.Overrides {
+		if o.Active(now) && o.Matches(a) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,210 @@
+package baseline
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/category"
+)
+
+// Quality thresholds.
+const (
+	// MinQualitySamples is how many samples a key needs before its mean
+	// and standard deviation are estimated well enough to alert on.
+	MinQualitySamples = 30
+	// MaxStableVariation is the coefficient of variation (standard
+	// deviation over mean) above which a key is too noisy for its
+	// threshold to mean much.
+	MaxStableVariation = 1.0
+	// QualityFreshFor is how long after its last update a baseline counts
+	// as current, and QualityStaleAfter when it counts as stale.
+	QualityFreshFor   = 24 * time.Hour
+	QualityStaleAfter = 7 * 24 * time.Hour
+	// MinEnforcementQuality is the quality score a baseline needs before
+	// operations it has not seen should be denied rather than reported.
+	MinEnforcementQuality = 80
+)
+
+// RuntimeCategories are the categories collectors observe. Coverage is the
+// fraction of them a baseline has learned.
+var RuntimeCategories = []string{"syscall", "file", "network", "process"}
+
+// Quality reports how far a baseline can be trusted: whether its keys have
+// enough samples, it covers the runtime categories, it is current and its
+// variances are stable.
+type Quality struct {
+	// Score combines the components below into 0-100, weighting samples
+	// 35%, coverage 25%, freshness 20% and stability 20%.
+	Score float64 `json:"score"`
+	// Samples is the fraction of observed keys with MinQualitySamples.
+	Samples float64 `json:"samples"`
+	// Coverage is the fraction of RuntimeCategories observed; baselines
+	// of none of them, such as log templates, have full coverage.
+	Coverage float64 `json:"coverage"`
+	// Freshness is 1 up to QualityFreshFor after the last update, falling
+	// to 0 at QualityStaleAfter.
+	Freshness float64 `json:"freshness"`
+	// Stability is the fraction of keys with at least two samples whose
+	// coefficient of variation is at most MaxStableVariation.
+	Stability float64 `json:"stability"`
+
+	UpdatedAt time.Time     `json:"updated_at"`
+	Age       time.Duration `json:"age"`
+	// Keys counts observed keys; Seeded those expected but not yet seen.
+	Keys   int `json:"keys"`
+	Seeded int `json:"seeded,omitempty"`
+	// Sparse counts keys with fewer than MinQualitySamples samples and
+	// Noisy those above MaxStableVariation.
+	Sparse int `json:"sparse"`
+	Noisy  int `json:"noisy"`
+	// Categories counts keys and samples per category.
+	Categories map[string]CategoryQuality `json:"categories"`
+	// MissingCategories lists the RuntimeCategories not observed.
+	MissingCategories []string `json:"missing_categories,omitempty"`
+	// PerKey holds every key, fewest samples first.
+	PerKey []KeyQuality `json:"keys_detail"`
+	// Issues explains, in words, what holds the score back.
+	Issues []string `json:"issues,omitempty"`
+}
+
+// CategoryQuality is how much of a category a baseline has learned.
+type CategoryQuality struct {
+	Keys    int `json:"keys"`
+	Samples int `json:"samples"`
+}
+
+// KeyQuality is how well one key is learned.
+type KeyQuality struct {
+	Key       string    `json:"key"`
+	Samples   int       `json:"samples"`
+	Mean      float64   `json:"mean"`
+	StdDev    float64   `json:"stddev"`
+	Variation float64   `json:"variation"` // coefficient of variation
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// Ready reports whether the baseline is trustworthy enough for
+// enforcement.
+func (q Quality) Ready() bool {
+	return q.Score >= MinEnforcementQuality
+}
+
+// Quality assesses the baseline at the baseline clock's current time.
+func (b *Baseline) Quality() Quality {
+	now := b.now()
+	q := Quality{UpdatedAt: b.UpdatedAt, Categories: make(map[string]CategoryQuality)}
+	if !b.UpdatedAt.IsZero() && now.After(b.UpdatedAt) {
+		q.Age = now.Sub(b.UpdatedAt)
+	}
+
+	varied := 0
+	for key, stat := range b.Stats {
+		if stat.SampleCount == 0 {
+			q.Seeded++
+			continue
+		}
+		category, _, _ := ParseStatKey(key)
+		c := q.Categories[category]
+		c.Keys++
+		c.Samples += stat.SampleCount
+		q.Categories[category] = c
+
+		k := KeyQuality{Key: key, Samples: stat.SampleCount, Mean: stat.Mean, StdDev: stat.StdDev, LastSeen: stat.LastSeen}
+		if stat.Mean != 0 {
+			k.Variation = stat.StdDev / math.Abs(stat.Mean)
+		}
+		q.PerKey = append(q.PerKey, k)
+		if stat.SampleCount < MinQualitySamples {
+			q.Sparse++
+		}
+		if stat.SampleCount >= 2 {
+			varied++
+			if k.Variation > MaxStableVariation {
+				q.Noisy++
+			}
+		}
+	}
+	q.Keys = len(q.PerKey)
+	sort.Slice(q.PerKey, func(i, j int) bool {
+		if q.PerKey[i].Samples != q.PerKey[j].Samples {
+			return q.PerKey[i].Samples < q.PerKey[j].Samples
+		}
+		return q.PerKey[i].Key < q.PerKey[j].Key
+	})
+
+	if q.Keys > 0 {
+		q.Samples = float64(q.Keys-q.Sparse) / float64(q.Keys)
+	}
+	if varied > 0 {
+		q.Stability = float64(varied-q.Noisy) / float64(varied)
+	}
+	runtime := 0
+	for _, name := range RuntimeCategories {
+		if q.observed(name) {
+			runtime++
+		} else {
+			q.MissingCategories = append(q.MissingCategories, name)
+		}
+	}
+	switch {
+	case q.Keys == 0:
+	case runtime == 0:
+		q.Coverage, q.MissingCategories = 1, nil
+	default:
+		q.Coverage = float64(runtime) / float64(len(RuntimeCategories))
+	}
+	switch {
+	case q.Keys == 0:
+	case q.Age <= QualityFreshFor:
+		q.Freshness = 1
+	case q.Age < QualityStaleAfter:
+		q.Freshness = 1 - float64(q.Age-QualityFreshFor)/float64(QualityStaleAfter-QualityFreshFor)
+	}
+	q.Score = 100 * (0.35*q.Samples + 0.25*q.Coverage + 0.2*q.Freshness + 0.2*q.Stability)
+
+	if q.Keys == 0 {
+		q.Issues = append(q.Issues, "nothing learned yet")
+		return q
+	}
+	if q.Sparse > 0 {
+		q.Issues = append(q.Issues, fmt.Sprintf("%d of %d keys have fewer than %d samples", q.Sparse, q.Keys, MinQualitySamples))
+	}
+	if len(q.MissingCategories) > 0 {
+		q.Issues = append(q.Issues, fmt.Sprintf("no %s observed", joinOr(q.MissingCategories)))
+	}
+	if q.Freshness < 1 {
+		q.Issues = append(q.Issues, fmt.Sprintf("not updated for %s", q.Age.Round(time.Hour)))
+	}
+	if q.Noisy > 0 {
+		q.Issues = append(q.Issues, fmt.Sprintf("%d keys vary more than their mean", q.Noisy))
+	}
+	return q
+}
+
+// observed reports whether keys of the category, or of one of its
+// subcategories, were observed.
+func (q Quality) observed(name string) bool {
+	for observed := range q.Categories {
+		if category.Match(name, observed) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinOr joins words as "a, b or c".
+func joinOr(words []string) string {
+	switch len(words) {
+	case 0:
+		return ""
+	case 1:
+		return words[0]
+	}
+	s := words[0]
+	for _, w := range words[1 : len(words)-1] {
+		s += ", " + w
+	}
+	return s + " or " + words[len(words)-1]
+}
@@ -0,0 +1,122 @@
+package baseline
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hallucinaut/runtimebase/pkg/category"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// Remediation tells whoever receives an anomaly what to do about it.
+type Remediation struct {
+	Runbook string `json:",omitempty" yaml:"runbook"` // URL of the playbook to follow
+	Owner   string `json:",omitempty" yaml:"owner"`   // team that owns the response
+	// Action references an automated response, e.g. a SOAR playbook ID or
+	// "isolate-pod".
+	Action string `json:",omitempty" yaml:"action"`
+}
+
+// IsZero reports whether r carries no remediation.
+func (r Remediation) IsZero() bool {
+	return r == Remediation{}
+}
+
+// merge fills r's empty fields from other.
+func (r Remediation) merge(other Remediation) Remediation {
+	if r.Runbook == "" {
+		r.Runbook = other.Runbook
+	}
+	if r.Owner == "" {
+		r.Owner = other.Owner
+	}
+	if r.Action == "" {
+		r.Action = other.Action
+	}
+	return r
+}
+
+// RemediationRule attaches remediation to the anomalies it matches. Empty
+// match fields match everything.
+type RemediationRule struct {
+	// Type is a glob matched against the anomaly type, e.g. "Volume*".
+	// "*" matches any text and "?" any one character.
+	Type string `yaml:"type"`
+	// Evidence is a glob matched against the evidence, e.g. "network:*"
+	// or "file:/var/lib/*".
+	Evidence string `yaml:"evidence"`
+	// Category is a category pattern matched against the category of the
+	// evidence, e.g. "network", which includes network.dns, or "*.write".
+	// See category.Match.
+	Category string `yaml:"category"`
+	// MinSeverity is the lowest severity the rule applies to.
+	MinSeverity string `yaml:"min_severity"`
+
+	Remediation `yaml:",inline"`
+}
+
+// Matches reports whether the rule applies to a.
+func (r RemediationRule) Matches(a Anomaly) bool {
+	behavior, _ := a.Behavior()
+	return glob(r.Type, a.Type) && glob(r.Evidence, a.Evidence) &&
+		category.Match(r.Category, behavior) &&
+		(r.MinSeverity == "" || severity.AtLeast(a.Severity, r.MinSeverity))
+}
+
+// Validate checks that the rule sets some remediation. MinSeverity and
+// Category are checked by the caller, which knows the severity taxonomy
+// and category ontology in effect.
+func (r RemediationRule) Validate() error {
+	if r.Remediation.IsZero() {
+		return fmt.Errorf("rule sets none of runbook, owner and action")
+	}
+	return nil
+}
+
+// glob reports whether s matches pattern, where "*" matches any text,
+// including "/", and "?" any one character. An empty pattern matches
+// everything.
+func glob(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(expr)
+	ok, _ := regexp.MatchString("^"+expr+"$", s)
+	return ok
+}
+
+// Remediations are rules applied in order: each remediation field comes
+// from the first matching rule that sets it.
+type Remediations []RemediationRule
+
+// For returns the remediation the rules give a.
+func (rs Remediations) For(a Anomaly) Remediation {
+	var r Remediation
+	for _, rule := range rs {
+		if rule.Matches(a) {
+			r = r.merge(rule.Remediation)
+		}
+	}
+	return r
+}
+
+// Apply attaches remediation to anomalies, keeping fields a detector
+// already set, and returns the number of anomalies changed.
+func (rs Remediations) Apply(anomalies []Anomaly) int {
+	changed := 0
+	for i := range anomalies {
+		a := &anomalies[i]
+		var current Remediation
+		if a.Remediation != nil {
+			current = *a.Remediation
+		}
+		merged := current.merge(rs.For(*a))
+		if merged != current {
+			a.Remediation = &merged
+			changed++
+		}
+	}
+	return changed
+}
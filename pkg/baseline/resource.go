@@ -0,0 +1,106 @@
+package baseline
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/hallucinaut/runtimebase/pkg/category"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// MinResourceIncrease is how far above its mean a resource measurement
+// must be to count as elevated: percentage points of pressure or
+// throttling, or memory events per reading. It keeps idle workloads, whose
+// pressure hovers near zero, from alerting on every small stall.
+const MinResourceIncrease = 5.0
+
+// DefaultSustainedIntervals is how many consecutive intervals a resource
+// measurement must stay elevated before it is reported.
+const DefaultSustainedIntervals = 3
+
+// RecordResources learns the peak resource measurements of one interval,
+// such as the output of detect.Resources, keyed like
+// "resource:cpu.pressure".
+func (b *Baseline) RecordResources(peaks map[string]float64) {
+	if len(peaks) == 0 {
+		return
+	}
+	if b.Resource == nil {
+		b.Resource = make(map[string]Stat)
+	}
+	now := b.now()
+	for key, value := range peaks {
+		b.Resource[key] = foldStat(b.Resource[key], value, now)
+	}
+	b.UpdatedAt = now
+}
+
+// ResourceElevated reports whether value is unusually high for the
+// resource key: above the anomaly threshold in standard deviations and at
+// least MinResourceIncrease above the mean. Keys never learned are not
+// elevated.
+func (b *Baseline) ResourceElevated(key string, value float64) bool {
+	stat, known := b.Resource[key]
+	if !known || stat.SampleCount == 0 || value-stat.Mean < MinResourceIncrease {
+		return false
+	}
+	if stat.StdDev == 0 {
+		return true
+	}
+	return CalculateZScore(value, stat.Mean, stat.StdDev) > b.ThresholdFor(key)
+}
+
+// ResourceAnomalies compares the peak resource measurements of one
+// interval with the learned ones. streaks counts, per key, the consecutive
+// intervals the measurement has been elevated (see ResourceElevated) and is
+// updated in place; a key is reported once, when its streak reaches
+// sustained, so a brief spike does not alert but pressure that stays
+// high does.
+func (b *Baseline) ResourceAnomalies(peaks map[string]float64, streaks map[string]int, sustained int) []Anomaly {
+	for key := range streaks {
+		if _, measured := peaks[key]; !measured {
+			delete(streaks, key)
+		}
+	}
+	keys := make([]string, 0, len(peaks))
+	for key := range peaks {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var anomalies []Anomaly
+	for _, key := range keys {
+		value := peaks[key]
+		if !b.ResourceElevated(key, value) {
+			delete(streaks, key)
+			continue
+		}
+		streaks[key]++
+		if streaks[key] != sustained {
+			continue
+		}
+		stat := b.Resource[key]
+		z := math.Inf(1)
+		if stat.StdDev > 0 {
+			z = CalculateZScore(value, stat.Mean, stat.StdDev)
+		}
+		level := severity.Label(severity.Medium)
+		if value >= 50 && strings.Contains(key, "pressure") {
+			level = severity.Label(severity.High)
+		}
+		anomalies = append(anomalies, Anomaly{
+			Type: "Resource Pressure",
+			Description: fmt.Sprintf("%s at %.1f for %d intervals, usually %.1f",
+				strings.TrimPrefix(key, "resource:"), value, sustained, stat.Mean),
+			Severity:   level,
+			Evidence:   key,
+			Category:   category.Of(key),
+			Confidence: calculateConfidence(math.Min(z, 10)),
+			Timestamp:  b.now(),
+			RiskLevel:  level,
+		})
+	}
+	return anomalies
+}
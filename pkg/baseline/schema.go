@@ -0,0 +1,69 @@
+package baseline
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// SchemaVersion is the version of the format baselines are stored in.
+// Changes to the format that older baselines cannot be decoded with as
+// they are add a migration and raise it.
+const SchemaVersion = 1
+
+// ErrSchemaTooNew is returned by Migrate for baselines stored by a newer
+// version of runtimebase, which this one cannot read without losing data.
+var ErrSchemaTooNew = errors.New("baseline schema is newer than supported")
+
+// Migration upgrades the stored form of a baseline from schema version
+// From to From+1, editing the JSON object's fields in place.
+type Migration struct {
+	From        int
+	Description string
+	Apply       func(doc map[string]json.RawMessage) error
+}
+
+// migrations upgrade baselines one schema version each, in order:
+// migrations[i] upgrades version i.
+var migrations = []Migration{
+	{From: 0, Description: "version unversioned baselines and give them a statistics table",
+		Apply: func(doc map[string]json.RawMessage) error {
+			if stats := doc["Stats"]; len(stats) == 0 || bytes.Equal(stats, []byte("null")) {
+				doc["Stats"] = json.RawMessage("{}")
+			}
+			return nil
+		}},
+}
+
+// Migrate upgrades the stored form of a baseline to SchemaVersion and
+// returns it with the schema version it had. Baselines without a version
+// predate versioning and are version 0. Data already at SchemaVersion is
+// returned as is.
+func Migrate(data []byte) ([]byte, int, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, 0, err
+	}
+	version := 0
+	if raw, ok := doc["SchemaVersion"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return nil, 0, fmt.Errorf("schema version: %w", err)
+		}
+	}
+	switch {
+	case version == SchemaVersion:
+		return data, version, nil
+	case version > SchemaVersion:
+		return nil, version, fmt.Errorf("%w: version %d, this build reads up to %d", ErrSchemaTooNew, version, SchemaVersion)
+	}
+	for v := version; v < SchemaVersion; v++ {
+		m := migrations[v]
+		if err := m.Apply(doc); err != nil {
+			return nil, version, fmt.Errorf("migrating schema version %d to %d (%s): %w", v, v+1, m.Description, err)
+		}
+	}
+	doc["SchemaVersion"] = json.RawMessage(fmt.Sprint(SchemaVersion))
+	upgraded, err := json.Marshal(doc)
+	return upgraded, version, err
+}
@@ -0,0 +1,96 @@
+package baseline
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// MaxTransitions bounds the transitions a process's sequence profile
+// learns, so a process with ever-new operations, such as one opening
+// ever-new files, cannot grow it without limit. Transitions beyond it are
+// not learned.
+var MaxTransitions = 10000
+
+// Transition is one operation of a process following another: the keys of
+// two consecutive events, such as "syscall:openat" and "syscall:read".
+type Transition struct {
+	Process string
+	From    string
+	To      string
+}
+
+// Key returns the transition's key in a SequenceProfile.
+func (t Transition) Key() string {
+	return t.From + " -> " + t.To
+}
+
+// SequenceProfile is the order in which a process has been seen to
+// perform its operations: a first-order model of which operation follows
+// which.
+type SequenceProfile struct {
+	// Transitions counts the transitions by Transition.Key.
+	Transitions map[string]int
+	LastSeen    time.Time
+}
+
+// RecordSequences learns transitions, adding their counts to those
+// already known.
+func (b *Baseline) RecordSequences(transitions map[Transition]int) {
+	if len(transitions) == 0 {
+		return
+	}
+	if b.Sequences == nil {
+		b.Sequences = make(map[string]SequenceProfile)
+	}
+	now := b.now()
+	for t, n := range transitions {
+		if t.Process == "" || t.From == "" || t.To == "" {
+			continue
+		}
+		profile := b.Sequences[t.Process]
+		if profile.Transitions == nil {
+			profile.Transitions = make(map[string]int)
+		}
+		if _, known := profile.Transitions[t.Key()]; known || len(profile.Transitions) < MaxTransitions {
+			profile.Transitions[t.Key()] += n
+		}
+		profile.LastSeen = now
+		b.Sequences[t.Process] = profile
+	}
+	b.UpdatedAt = now
+}
+
+// SequenceAnomaly reports a transition the process has not been seen to
+// make between two operations it has been seen to perform, such as a
+// server reading a file it only ever wrote or executing a shell right
+// after accepting a connection. It is MEDIUM. Evidence is "sequence:",
+// the process and the transition. New operations are left to the count
+// model, and processes without a learned sequence profile are not checked.
+func (b *Baseline) SequenceAnomaly(t Transition) (Anomaly, bool) {
+	profile, ok := b.Sequences[t.Process]
+	if !ok || len(profile.Transitions) == 0 || profile.Transitions[t.Key()] > 0 {
+		return Anomaly{}, false
+	}
+	if len(profile.Transitions) >= MaxTransitions {
+		return Anomaly{}, false
+	}
+	if _, ok := b.Stats[t.From]; !ok {
+		return Anomaly{}, false
+	}
+	if _, ok := b.Stats[t.To]; !ok {
+		return Anomaly{}, false
+	}
+	level := severity.Label(severity.Medium)
+	return Anomaly{
+		Type:        "Unusual Sequence",
+		Description: fmt.Sprintf("%s performed %s right after %s, an order never seen in the baseline", t.Process, t.To, t.From),
+		Severity:    level,
+		Evidence:    "sequence:" + t.Process + ":" + t.Key(),
+		Category:    "sequence",
+		Confidence:  1,
+		Timestamp:   b.now(),
+		RiskLevel:   level,
+	}, true
+}
@@ -0,0 +1,107 @@
+package baseline
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/category"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// SilenceAnomaly is the Type of anomalies raised when a pattern that
+// recurs regularly has gone quiet for longer than its learned gaps.
+const SilenceAnomaly = "Expected Activity Missing"
+
+// SilenceMargin scales the longest gap a key is expected to have before
+// its absence is reported, leaving room for jitter.
+const SilenceMargin = 1.5
+
+// RecordActivity learns the gaps between the times statistic keys are
+// active. active maps keys to when, in event time, they were active, once
+// per learning interval; the time since each key's previous activity is
+// folded into Gaps.
+func (b *Baseline) RecordActivity(active map[string]time.Time) {
+	if len(active) == 0 {
+		return
+	}
+	for key, at := range active {
+		gap, seen := b.Gaps[key]
+		switch {
+		case !seen || gap.LastSeen.IsZero():
+			gap.LastSeen = at
+		case at.After(gap.LastSeen):
+			gap = foldStat(gap, at.Sub(gap.LastSeen).Seconds(), at)
+		default:
+			continue
+		}
+		if b.Gaps == nil {
+			b.Gaps = make(map[string]Stat)
+		}
+		b.Gaps[key] = gap
+	}
+	b.UpdatedAt = b.now()
+}
+
+// SilenceLimit returns how long key may go without activity before its
+// absence is anomalous: SilenceMargin times the larger of the longest gap
+// learned and the mean gap plus the key's threshold in standard
+// deviations. Keys with fewer gaps than MinSamplesFor, or whose gaps vary
+// more than MaxStableVariation, do not occur regularly and have no limit.
+func (b *Baseline) SilenceLimit(key string) (time.Duration, bool) {
+	gap, ok := b.Gaps[key]
+	if !ok || gap.SampleCount < b.MinSamplesFor(key) || gap.Mean <= 0 {
+		return 0, false
+	}
+	if gap.StdDev/gap.Mean > MaxStableVariation {
+		return 0, false
+	}
+	limit := math.Max(gap.Max, gap.Mean+b.ThresholdFor(key)*gap.StdDev) * SilenceMargin
+	return time.Duration(limit * float64(time.Second)), true
+}
+
+// Silences returns a SilenceAnomaly for each regularly recurring key that
+// has not been active for longer than its SilenceLimit at now. lastSeen
+// maps keys to when they were last active; keys not in it count as silent
+// since since, when watching started.
+func (b *Baseline) Silences(lastSeen map[string]time.Time, since, now time.Time) []Anomaly {
+	keys := make([]string, 0, len(b.Gaps))
+	for key := range b.Gaps {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var anomalies []Anomaly
+	for _, key := range keys {
+		limit, ok := b.SilenceLimit(key)
+		if !ok {
+			continue
+		}
+		last := lastSeen[key]
+		if last.Before(since) {
+			last = since
+		}
+		quiet := now.Sub(last)
+		if quiet <= limit {
+			continue
+		}
+		level := severity.Label(severity.Medium)
+		if quiet > 3*limit {
+			level = severity.Label(severity.High)
+		}
+		gap := time.Duration(b.Gaps[key].Mean * float64(time.Second))
+		anomalies = append(anomalies, Anomaly{
+			Type: SilenceAnomaly,
+			Description: fmt.Sprintf("No activity for %s; learned to recur about every %s",
+				quiet.Round(time.Second), gap.Round(time.Second)),
+			Severity:   level,
+			Evidence:   key,
+			Category:   category.Of(key),
+			Confidence: math.Min(1, 1-float64(limit)/float64(quiet)+0.5),
+			Timestamp:  now,
+			RiskLevel:  level,
+		})
+	}
+	return anomalies
+}
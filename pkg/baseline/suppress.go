@@ -0,0 +1,76 @@
+package baseline
+
+import (
+	"fmt"
+
+	"github.com/hallucinaut/runtimebase/pkg/category"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// Suppression silences the anomalies it matches, for behavior that is
+// known and accepted but not worth learning. Empty match fields match
+// everything, but a suppression must set at least one.
+type Suppression struct {
+	// Type is a glob matched against the anomaly type.
+	Type string `yaml:"type"`
+	// Category is a category pattern matched against the category of the
+	// behavior the anomaly reports, e.g. "network.*" or "*.write". See
+	// category.Match and Anomaly.Behavior.
+	Category string `yaml:"category"`
+	// Evidence is a glob matched against the evidence.
+	Evidence string `yaml:"evidence"`
+	// MaxSeverity is the highest severity suppressed; more severe
+	// anomalies are kept.
+	MaxSeverity string `yaml:"max_severity"`
+	// Reason records why the behavior is accepted.
+	Reason string `yaml:"reason"`
+}
+
+// Matches reports whether the suppression silences a.
+func (s Suppression) Matches(a Anomaly) bool {
+	behavior, _ := a.Behavior()
+	return glob(s.Type, a.Type) && glob(s.Evidence, a.Evidence) &&
+		category.Match(s.Category, behavior) &&
+		(s.MaxSeverity == "" || severity.AtLeast(s.MaxSeverity, a.Severity))
+}
+
+// Validate checks that the suppression selects something and says why.
+// MaxSeverity and Category are checked by the caller, which knows the
+// severity taxonomy and category ontology in effect.
+func (s Suppression) Validate() error {
+	if s.Type == "" && s.Category == "" && s.Evidence == "" {
+		return fmt.Errorf("suppression sets none of type, category and evidence")
+	}
+	if s.Reason == "" {
+		return fmt.Errorf("suppression has no reason")
+	}
+	return nil
+}
+
+// Suppressions are suppression rules; an anomaly any of them matches is
+// suppressed.
+type Suppressions []Suppression
+
+// Filter returns the anomalies no suppression matches, in order, and how
+// many were suppressed.
+func (ss Suppressions) Filter(anomalies []Anomaly) ([]Anomaly, int) {
+	if len(ss) == 0 {
+		return anomalies, 0
+	}
+	kept := anomalies[:0:0]
+	for _, a := range anomalies {
+		if !ss.suppresses(a) {
+			kept = append(kept, a)
+		}
+	}
+	return kept, len(anomalies) - len(kept)
+}
+
+func (ss Suppressions) suppresses(a Anomaly) bool {
+	for _, s := range ss {
+		if s.Matches(a) {
+			return true
+		}
+	}
+	return false
+}
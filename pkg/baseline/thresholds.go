@@ -0,0 +1,130 @@
+package baseline
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ThresholdFor returns the anomaly threshold, in standard deviations, that
+// applies to a statistic key: its override in Thresholds, or
+// AnomalyThreshold. Keys shared by Stats, Volume and Resource share an
+// override.
+func (b *Baseline) ThresholdFor(key string) float64 {
+	if t, ok := b.Thresholds[key]; ok {
+		return t
+	}
+	return b.AnomalyThreshold
+}
+
+// MinSamplesFor returns how many samples a statistic key needs before
+// deviations from it are reported as anomalies: its override in
+// MinSamples, MinSampleCount, or DefaultMinSamples.
+func (b *Baseline) MinSamplesFor(key string) int {
+	if n, ok := b.MinSamples[key]; ok {
+		return n
+	}
+	if b.MinSampleCount > 0 {
+		return b.MinSampleCount
+	}
+	return DefaultMinSamples
+}
+
+// SetMinSamples overrides the samples key needs before deviations from it
+// are reported as anomalies; 0 removes the override. The key must have
+// been learned.
+func (b *Baseline) SetMinSamples(key string, n int) error {
+	if !b.hasKey(key) {
+		return fmt.Errorf("%s: not learned by baseline %s", key, b.Name)
+	}
+	if n < 0 {
+		return fmt.Errorf("%s: invalid minimum sample count %d", key, n)
+	}
+	if n == 0 {
+		delete(b.MinSamples, key)
+		if len(b.MinSamples) == 0 {
+			b.MinSamples = nil
+		}
+	} else {
+		if b.MinSamples == nil {
+			b.MinSamples = make(map[string]int)
+		}
+		b.MinSamples[key] = n
+	}
+	b.UpdatedAt = b.now()
+	return nil
+}
+
+// ThresholdChange is a change of the threshold applying to one key. Old and
+// New are effective thresholds; Override tells whether New is an override
+// or the baseline's AnomalyThreshold.
+type ThresholdChange struct {
+	Key      string
+	Old      float64
+	New      float64
+	Override bool
+}
+
+// PlanThresholds validates per-key threshold overrides and returns the
+// changes they make, ordered by key, without applying them. A threshold of
+// 0, or one equal to AnomalyThreshold, removes the key's override. Every
+// key must have been learned, in Stats, Volume or Resource, and every
+// threshold must be finite and not negative; all violations are returned
+// together.
+func (b *Baseline) PlanThresholds(overrides map[string]float64) ([]ThresholdChange, error) {
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var changes []ThresholdChange
+	var errs []error
+	for _, key := range keys {
+		t := overrides[key]
+		if !b.hasKey(key) {
+			errs = append(errs, fmt.Errorf("%s: not learned by baseline %s", key, b.Name))
+			continue
+		}
+		if t < 0 || math.IsNaN(t) || math.IsInf(t, 0) {
+			errs = append(errs, fmt.Errorf("%s: invalid threshold %v", key, t))
+			continue
+		}
+		c := ThresholdChange{Key: key, Old: b.ThresholdFor(key), New: b.AnomalyThreshold}
+		if t != 0 && t != b.AnomalyThreshold {
+			c.New, c.Override = t, true
+		}
+		if _, had := b.Thresholds[key]; c.New != c.Old || had != c.Override {
+			changes = append(changes, c)
+		}
+	}
+	return changes, errors.Join(errs...)
+}
+
+// ApplyThresholds applies changes from PlanThresholds.
+func (b *Baseline) ApplyThresholds(changes []ThresholdChange) {
+	for _, c := range changes {
+		if !c.Override {
+			delete(b.Thresholds, c.Key)
+			continue
+		}
+		if b.Thresholds == nil {
+			b.Thresholds = make(map[string]float64)
+		}
+		b.Thresholds[c.Key] = c.New
+	}
+	if len(b.Thresholds) == 0 {
+		b.Thresholds = nil
+	}
+	if len(changes) > 0 {
+		b.UpdatedAt = b.now()
+	}
+}
+
+func (b *Baseline) hasKey(key string) bool {
+	_, stat := b.Stats[key]
+	_, volume := b.Volume[key]
+	_, resource := b.Resource[key]
+	return stat || volume || resource
+}
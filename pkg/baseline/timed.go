@@ -0,0 +1,140 @@
+package baseline
+
+import (
+	"sort"
+	"time"
+)
+
+// DefaultBucketWidth is the interval of event time whose timestamped
+// observations are counted into one sample.
+const DefaultBucketWidth = time.Minute
+
+// MaxClosedBuckets bounds the closed buckets a baseline keeps for
+// TakeClosed; older ones are discarded when nobody takes them.
+const MaxClosedBuckets = 24 * 60
+
+// Bucket counts the timestamped observations of one interval of event
+// time, per statistic key, until it closes.
+type Bucket struct {
+	Start  time.Time
+	Counts map[string]int
+}
+
+// BucketWidthOrDefault returns BucketWidth, or DefaultBucketWidth when it
+// is unset.
+func (b *Baseline) BucketWidthOrDefault() time.Duration {
+	if b.BucketWidth > 0 {
+		return b.BucketWidth
+	}
+	return DefaultBucketWidth
+}
+
+// RecordObservationAt records an observation made at a time in the past,
+// in event time. Observations are counted into the bucket of BucketWidth
+// containing at, and a bucket's counts become one sample per key, and
+// activity (see RecordActivity), when it closes: once an observation later
+// than its end by more than Lateness arrives, or CloseBuckets is called.
+// Observations may so arrive out of order by up to Lateness; later ones
+// belong to closed buckets and are dropped and counted in Late. It reports
+// whether the observation was counted.
+func (b *Baseline) RecordObservationAt(category, pattern string, labels map[string]string, count int, at time.Time) bool {
+	ok := b.observeAt(Observation{Category: category, Pattern: pattern, Labels: labels, Count: count, Time: at})
+	b.closeBuckets(b.Watermark.Add(-b.Lateness))
+	b.UpdatedAt = b.now()
+	return ok
+}
+
+// observeAt counts a timestamped observation into its open bucket,
+// advancing the watermark, without closing buckets.
+func (b *Baseline) observeAt(o Observation) bool {
+	start := o.Time.Truncate(b.BucketWidthOrDefault())
+	if start.Before(b.Closed) {
+		b.Late++
+		return false
+	}
+	key := StatKey(o.Category, o.Pattern, b.normalizeLabels(o.Labels))
+	i := sort.Search(len(b.Open), func(i int) bool { return !b.Open[i].Start.Before(start) })
+	if i == len(b.Open) || !b.Open[i].Start.Equal(start) {
+		b.Open = append(b.Open, Bucket{})
+		copy(b.Open[i+1:], b.Open[i:])
+		b.Open[i] = Bucket{Start: start, Counts: make(map[string]int)}
+	}
+	b.Open[i].Counts[key] += o.Count
+	if o.Time.After(b.Watermark) {
+		b.Watermark = o.Time
+	}
+	return true
+}
+
+// Advance moves the watermark to t, when later, and closes the buckets
+// that ended more than Lateness before it, for callers that aggregate
+// observations themselves and know how far event time has progressed.
+func (b *Baseline) Advance(t time.Time) {
+	if t.After(b.Watermark) {
+		b.Watermark = t
+	}
+	if len(b.Open) > 0 {
+		b.closeBuckets(b.Watermark.Add(-b.Lateness))
+		b.UpdatedAt = b.now()
+	}
+}
+
+// CloseBuckets closes every open bucket, for when no more observations of
+// their intervals are expected, such as at the end of the input. It
+// returns the number closed.
+func (b *Baseline) CloseBuckets() int {
+	n := len(b.Open)
+	if n > 0 {
+		b.closeBuckets(b.Open[n-1].Start.Add(b.BucketWidthOrDefault()))
+		b.UpdatedAt = b.now()
+	}
+	return n
+}
+
+// closeBuckets folds the open buckets ending by horizon into the
+// statistics, oldest first. Observations screened against a reference are
+// quarantined as by RecordObservation.
+func (b *Baseline) closeBuckets(horizon time.Time) {
+	width := b.BucketWidthOrDefault()
+	now := b.now()
+	closed := 0
+	for _, bucket := range b.Open {
+		end := bucket.Start.Add(width)
+		if end.After(horizon) {
+			break
+		}
+		keys := make([]string, 0, len(bucket.Counts))
+		for key := range bucket.Counts {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		active := make(map[string]time.Time, len(keys))
+		for _, key := range keys {
+			category, pattern, labels := ParseStatKey(key)
+			b.observeKey(key, Observation{Category: category, Pattern: pattern, Labels: labels, Count: bucket.Counts[key], Time: bucket.Start}, now)
+			active[key] = bucket.Start
+		}
+		b.RecordActivity(active)
+		if b.LearnedSince.IsZero() {
+			b.LearnedSince = bucket.Start
+		}
+		b.Closed = end
+		b.closed = append(b.closed, bucket)
+		closed++
+	}
+	if over := len(b.closed) - MaxClosedBuckets; over > 0 {
+		b.closed = append(b.closed[:0:0], b.closed[over:]...)
+	}
+	b.Open = b.Open[closed:]
+	if len(b.Open) == 0 {
+		b.Open = nil
+	}
+}
+
+// TakeClosed returns the buckets closed since the last call, oldest first,
+// so their counts can be kept as history (see storage.Store.StatSeries).
+func (b *Baseline) TakeClosed() []Bucket {
+	closed := b.closed
+	b.closed = nil
+	return closed
+}
@@ -0,0 +1,144 @@
+package baseline
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// MaxTLSDestinations bounds the destinations a process's TLS profile
+// learns, so a crawler connecting to ever-new hosts cannot grow it without
+// limit. Destinations beyond it are not learned; their fingerprints are.
+var MaxTLSDestinations = 1000
+
+// Handshake is a TLS client handshake: the process that made it, its
+// client fingerprint, such as "ja4:t13d1516h2_8daaf6152771_e5627efa2ab1"
+// or "ja3:" and the JA3 hash, and the destination, host:port or server
+// name, when known.
+type Handshake struct {
+	Process     string
+	Fingerprint string
+	Destination string
+}
+
+// TLSProfile is how a process has been seen to make TLS handshakes.
+type TLSProfile struct {
+	// Fingerprints counts the handshakes by client fingerprint.
+	Fingerprints map[string]int
+	// Destinations holds the fingerprints used for each destination.
+	Destinations map[string][]string `json:",omitempty"`
+	LastSeen     time.Time
+}
+
+// RecordTLS learns handshakes, adding their counts to those already
+// known.
+func (b *Baseline) RecordTLS(handshakes map[Handshake]int) {
+	if len(handshakes) == 0 {
+		return
+	}
+	if b.TLS == nil {
+		b.TLS = make(map[string]TLSProfile)
+	}
+	now := b.now()
+	for h, n := range handshakes {
+		if h.Process == "" || h.Fingerprint == "" {
+			continue
+		}
+		profile := b.TLS[h.Process]
+		if profile.Fingerprints == nil {
+			profile.Fingerprints = make(map[string]int)
+		}
+		profile.Fingerprints[h.Fingerprint] += n
+		if h.Destination != "" {
+			known, seen := profile.Destinations[h.Destination]
+			switch {
+			case !seen && len(profile.Destinations) >= MaxTLSDestinations:
+			case !slices.Contains(known, h.Fingerprint):
+				if profile.Destinations == nil {
+					profile.Destinations = make(map[string][]string)
+				}
+				profile.Destinations[h.Destination] = append(known, h.Fingerprint)
+			}
+		}
+		profile.LastSeen = now
+		b.TLS[h.Process] = profile
+	}
+	b.UpdatedAt = now
+}
+
+// TLSAnomaly reports a handshake with a client fingerprint the process has
+// not been seen to use, the mark of tooling injected into it that brings
+// its own TLS stack. It is HIGH, or MEDIUM when another process of the
+// baseline uses the fingerprint. A known fingerprint is reported, as a
+// MEDIUM "TLS Fingerprint Change", when the destination was learned with
+// other fingerprints only. Evidence is "tls:", the process and the
+// fingerprint, and for changes "->" and the destination. Nothing is
+// reported for baselines that have not learned any handshakes.
+func (b *Baseline) TLSAnomaly(h Handshake) (Anomaly, bool) {
+	if len(b.TLS) == 0 || h.Process == "" || h.Fingerprint == "" {
+		return Anomaly{}, false
+	}
+	profile := b.TLS[h.Process]
+	level := severity.Label(severity.High)
+	evidence := "tls:" + h.Process + ":" + h.Fingerprint
+	var kind, description string
+	if profile.Fingerprints[h.Fingerprint] == 0 {
+		kind = "New TLS Fingerprint"
+		description = fmt.Sprintf("%s made a TLS handshake with fingerprint %s", h.Process, h.Fingerprint)
+		if h.Destination != "" {
+			description = fmt.Sprintf("%s made a TLS handshake to %s with fingerprint %s", h.Process, h.Destination, h.Fingerprint)
+		}
+		if users := b.tlsUsers(h.Fingerprint); len(users) > 0 {
+			level = severity.Label(severity.Medium)
+			description += fmt.Sprintf(", which only %s used in the baseline", strings.Join(users, ", "))
+		} else {
+			description += ", never seen in the baseline"
+			if len(profile.Fingerprints) > 0 {
+				description += fmt.Sprintf("; it uses %s", strings.Join(sortedKeys(profile.Fingerprints), ", "))
+			}
+		}
+	} else {
+		known, seen := profile.Destinations[h.Destination]
+		if !seen || slices.Contains(known, h.Fingerprint) {
+			return Anomaly{}, false
+		}
+		level, kind = severity.Label(severity.Medium), "TLS Fingerprint Change"
+		description = fmt.Sprintf("%s connected to %s with fingerprint %s, usually %s", h.Process, h.Destination, h.Fingerprint, strings.Join(known, ", "))
+		evidence += "->" + h.Destination
+	}
+	return Anomaly{
+		Type:        kind,
+		Description: description,
+		Severity:    level,
+		Evidence:    evidence,
+		Category:    "tls",
+		Confidence:  1,
+		Timestamp:   b.now(),
+		RiskLevel:   level,
+	}, true
+}
+
+// tlsUsers returns the processes that have been seen to use fingerprint.
+func (b *Baseline) tlsUsers(fingerprint string) []string {
+	var users []string
+	for process, profile := range b.TLS {
+		if profile.Fingerprints[fingerprint] > 0 {
+			users = append(users, process)
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,107 @@
+package baseline
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/hallucinaut/runtimebase/pkg/category"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// MinVolumeRatio is how many times its usual volume a path or destination
+// must see before the volume is reported, however unusual it is in
+// standard deviations. It keeps small, tightly clustered volumes quiet.
+const MinVolumeRatio = 2.0
+
+// RecordVolumes learns the bytes moved per volume key over one interval,
+// such as the output of detect.Volumes. Keys already known but absent from
+// volumes are not updated; an interval without traffic is not a sample.
+func (b *Baseline) RecordVolumes(volumes map[string]int64) {
+	if len(volumes) == 0 {
+		return
+	}
+	if b.Volume == nil {
+		b.Volume = make(map[string]Stat)
+	}
+	now := b.now()
+	for key, bytes := range volumes {
+		b.Volume[key] = foldStat(b.Volume[key], float64(bytes), now)
+	}
+	b.UpdatedAt = now
+}
+
+// VolumeAnomalies compares the bytes moved per volume key over one interval
+// with the learned volumes. A key is reported when its volume is above the
+// anomaly threshold in standard deviations and at least MinVolumeRatio
+// times its mean, so a 100x write burst to a database path is caught even
+// when the number of writes looks normal. Keys never seen moving data are
+// left to the unseen-behavior checks.
+func (b *Baseline) VolumeAnomalies(volumes map[string]int64) []Anomaly {
+	keys := make([]string, 0, len(volumes))
+	for key := range volumes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var anomalies []Anomaly
+	for _, key := range keys {
+		stat, known := b.Volume[key]
+		if !known || stat.SampleCount == 0 {
+			continue
+		}
+		value := float64(volumes[key])
+		ratio := value / math.Max(stat.Mean, 1)
+		if ratio < MinVolumeRatio {
+			continue
+		}
+		z := CalculateZScore(value, stat.Mean, stat.StdDev)
+		if stat.StdDev == 0 {
+			// Every sample had the same volume: any multiple of it is
+			// as unusual as it gets.
+			z = math.Inf(1)
+		}
+		if z <= b.ThresholdFor(key) {
+			continue
+		}
+		level := volumeSeverity(ratio)
+		anomalies = append(anomalies, Anomaly{
+			Type:        "Volume Anomaly",
+			Description: fmt.Sprintf("Moved %.0fx the usual data volume (%s, usually %s)", ratio, FormatBytes(value), FormatBytes(stat.Mean)),
+			Severity:    level,
+			Evidence:    key,
+			Category:    category.Of(key),
+			Confidence:  calculateConfidence(math.Min(z, 10)),
+			Timestamp:   b.now(),
+			RiskLevel:   level,
+		})
+	}
+	return anomalies
+}
+
+// volumeSeverity grades a volume by how many times the usual it is.
+func volumeSeverity(ratio float64) string {
+	switch {
+	case ratio >= 100:
+		return severity.Label(severity.Critical)
+	case ratio >= 10:
+		return severity.Label(severity.High)
+	case ratio >= 5:
+		return severity.Label(severity.Medium)
+	}
+	return severity.Label(severity.Low)
+}
+
+// FormatBytes formats a byte count with a binary unit, e.g. "1.5 MiB".
+func FormatBytes(n float64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	exp := 0
+	for n >= unit*unit && exp < 5 {
+		n /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", n/unit, "KMGTPE"[exp])
+}
@@ -0,0 +1,250 @@
+// Package bootstrap pre-seeds baselines from static analysis of ELF
+// binaries, so a workload has an expected profile before any runtime data
+// exists.
+package bootstrap
+
+import (
+	"debug/elf"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+// Profile is what static analysis expects a binary to do.
+type Profile struct {
+	Binary    string
+	Syscalls  []string
+	Libraries []string
+	Paths     []string
+	Hosts     []string
+}
+
+// libcSyscalls maps imported libc symbols to the syscalls they issue.
+var libcSyscalls = map[string][]string{
+	"open": {"openat"}, "open64": {"openat"}, "openat": {"openat"}, "fopen": {"openat"}, "fopen64": {"openat"},
+	"read": {"read"}, "fread": {"read"}, "pread": {"pread64"}, "pread64": {"pread64"},
+	"write": {"write"}, "fwrite": {"write"}, "pwrite": {"pwrite64"}, "pwrite64": {"pwrite64"},
+	"close": {"close"}, "fclose": {"close"},
+	"stat": {"newfstatat"}, "lstat": {"newfstatat"}, "fstat": {"fstat"},
+	"unlink": {"unlinkat"}, "unlinkat": {"unlinkat"}, "rename": {"renameat2"}, "mkdir": {"mkdirat"}, "rmdir": {"unlinkat"},
+	"chmod": {"fchmodat"}, "chown": {"fchownat"},
+	"socket": {"socket"}, "connect": {"connect"}, "bind": {"bind"}, "listen": {"listen"},
+	"accept": {"accept"}, "accept4": {"accept4"}, "send": {"sendto"}, "sendto": {"sendto"},
+	"recv": {"recvfrom"}, "recvfrom": {"recvfrom"}, "sendmsg": {"sendmsg"}, "recvmsg": {"recvmsg"},
+	"getaddrinfo": {"socket", "connect", "sendto", "recvfrom"},
+	"fork":        {"clone"}, "vfork": {"clone"}, "posix_spawn": {"clone", "execve"}, "system": {"clone", "execve", "wait4"},
+	"popen": {"clone", "execve"}, "execve": {"execve"}, "execv": {"execve"}, "execvp": {"execve"}, "execl": {"execve"},
+	"waitpid": {"wait4"}, "wait": {"wait4"}, "kill": {"kill"},
+	"mmap": {"mmap"}, "mprotect": {"mprotect"}, "munmap": {"munmap"},
+	"dlopen": {"openat", "mmap"}, "ptrace": {"ptrace"}, "setuid": {"setuid"}, "setgid": {"setgid"},
+	"chroot": {"chroot"}, "mount": {"mount"}, "epoll_wait": {"epoll_wait"}, "poll": {"poll"}, "select": {"pselect6"},
+}
+
+// goSyscalls maps Go standard library symbols, found in a statically linked
+// Go binary's symbol table, to the syscalls they issue.
+var goSyscalls = map[string][]string{
+	"os.OpenFile":                  {"openat"},
+	"os.Remove":                    {"unlinkat"},
+	"os.Rename":                    {"renameat"},
+	"os.Mkdir":                     {"mkdirat"},
+	"net.(*Dialer).DialContext":    {"socket", "connect"},
+	"net.(*ListenConfig).Listen":   {"socket", "bind", "listen"},
+	"net.(*netFD).accept":          {"accept4"},
+	"os/exec.(*Cmd).Start":         {"clone", "execve"},
+	"os.StartProcess":              {"clone", "execve"},
+	"syscall.Exec":                 {"execve"},
+	"syscall.PtraceAttach":         {"ptrace"},
+	"syscall.Setuid":               {"setuid"},
+	"syscall.Mount":                {"mount"},
+	"syscall.Chroot":               {"chroot"},
+	"net.(*Resolver).lookupIPAddr": {"socket", "connect", "sendto", "recvfrom"},
+}
+
+var pathPattern = regexp.MustCompile(`^/(etc|var|usr|opt|tmp|proc|sys|dev|run|home|lib|lib64|srv|data)(/[A-Za-z0-9._@+-]+)*/?$`)
+
+// knownTLDs are the top-level domains of the host names trusted in
+// binaries' strings. Other dotted words there are far more often Go
+// package and symbol names, such as bytes.Buffer, or file names, such as
+// a.out, than hosts.
+var knownTLDs = map[string]bool{
+	"com": true, "net": true, "org": true, "io": true, "dev": true, "app": true, "cloud": true,
+	"co": true, "ai": true, "me": true, "edu": true, "gov": true, "mil": true, "int": true,
+	"info": true, "biz": true, "us": true, "uk": true, "eu": true, "de": true, "fr": true,
+	"nl": true, "jp": true, "cn": true, "ru": true, "br": true, "in": true, "au": true, "ca": true,
+	"local": true, "internal": true, "localdomain": true, "lan": true, "corp": true, "svc": true, "arpa": true,
+}
+
+var hostLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// networkHosts returns the hosts s names: IP addresses, and host names
+// in knownTLDs, bare, with a port or in a URL. Tokens with a path but no
+// URL scheme, such as github.com/org/module, are Go package paths and are
+// skipped, as are names with capitals.
+func networkHosts(s string) []string {
+	var hosts []string
+	tokens := strings.FieldsFunc(s, func(r rune) bool {
+		return r <= ' ' || strings.ContainsRune("\"'`,;()<>[]{}=|", r)
+	})
+	for _, token := range tokens {
+		token = strings.TrimRight(token, ".:")
+		if scheme, rest, ok := strings.Cut(token, "://"); ok {
+			if scheme == "" {
+				continue
+			}
+			token, _, _ = strings.Cut(rest, "/")
+			if _, host, ok := strings.Cut(token, "@"); ok {
+				token = host
+			}
+		} else if strings.Contains(token, "/") {
+			continue
+		}
+		if host, _, err := net.SplitHostPort(token); err == nil {
+			token = host
+		}
+		if ip := net.ParseIP(token); ip != nil {
+			if !ip.IsUnspecified() {
+				hosts = append(hosts, ip.String())
+			}
+			continue
+		}
+		if isHostName(token) {
+			hosts = append(hosts, token)
+		}
+	}
+	return hosts
+}
+
+// isHostName reports whether name is a lower-case host name of at least
+// two labels in one of knownTLDs.
+func isHostName(name string) bool {
+	labels := strings.Split(name, ".")
+	if len(labels) < 2 || len(name) > 253 || !knownTLDs[labels[len(labels)-1]] {
+		return false
+	}
+	for _, label := range labels {
+		if !hostLabel.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+// minStringLen is the shortest printable run treated as a string.
+const minStringLen = 6
+
+// Analyze inspects an ELF binary.
+func Analyze(path string) (*Profile, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening ELF %s: %w", path, err)
+	}
+	defer f.Close()
+
+	profile := &Profile{Binary: path}
+	syscalls := make(map[string]bool)
+
+	if libs, err := f.ImportedLibraries(); err == nil {
+		profile.Libraries = libs
+	}
+
+	if imported, err := f.ImportedSymbols(); err == nil {
+		for _, sym := range imported {
+			for _, sc := range libcSyscalls[sym.Name] {
+				syscalls[sc] = true
+			}
+		}
+	}
+	if symbols, err := f.Symbols(); err == nil {
+		for _, sym := range symbols {
+			for _, sc := range goSyscalls[sym.Name] {
+				syscalls[sc] = true
+			}
+		}
+	}
+	// Every dynamically linked program loads its libraries.
+	if len(profile.Libraries) > 0 {
+		for _, sc := range []string{"openat", "read", "mmap", "mprotect", "close"} {
+			syscalls[sc] = true
+		}
+	}
+	profile.Syscalls = sortedKeys(syscalls)
+
+	paths := make(map[string]bool)
+	hosts := make(map[string]bool)
+	for _, name := range []string{".rodata", ".data", ".go.buildinfo"} {
+		section := f.Section(name)
+		if section == nil {
+			continue
+		}
+		data, err := section.Data()
+		if err != nil {
+			continue
+		}
+		for _, s := range printableStrings(data) {
+			for _, token := range strings.Fields(s) {
+				if pathPattern.MatchString(token) {
+					paths[token] = true
+				}
+			}
+			for _, host := range networkHosts(s) {
+				hosts[host] = true
+			}
+		}
+	}
+	profile.Paths = sortedKeys(paths)
+	profile.Hosts = sortedKeys(hosts)
+
+	return profile, nil
+}
+
+// Apply seeds b with the profile's expected behavior.
+func (p *Profile) Apply(b *baseline.Baseline) {
+	for _, sc := range p.Syscalls {
+		b.Seed("syscall", sc)
+	}
+	for _, lib := range p.Libraries {
+		b.Seed("file", lib)
+	}
+	for _, path := range p.Paths {
+		b.Seed("file", path)
+	}
+	for _, host := range p.Hosts {
+		b.Seed("network", host)
+	}
+	b.Seed("process", p.Binary)
+}
+
+// printableStrings extracts runs of printable ASCII like strings(1).
+func printableStrings(data []byte) []string {
+	var out []string
+	start := -1
+	for i, c := range data {
+		if c >= 0x20 && c < 0x7f {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 && i-start >= minStringLen {
+			out = append(out, string(data[start:i]))
+		}
+		start = -1
+	}
+	if start >= 0 && len(data)-start >= minStringLen {
+		out = append(out, string(data[start:]))
+	}
+	return out
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
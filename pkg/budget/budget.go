@@ -0,0 +1,203 @@
+// Package budget keeps the daemon within CPU and memory budgets, so the
+// monitoring agent does not become the noisy neighbor it is watching.
+//
+// A Governor caps GOMAXPROCS and the Go runtime's memory limit, then
+// measures the process's CPU and memory use and, over budget, sheds
+// events: past the CPU budget it samples them with a collect.Sampler,
+// keeping one in N of the busiest streams with weight N so learned counts
+// stay unbiased, and past the memory budget it drops them until usage
+// falls back. Every event shed is counted.
+package budget
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/collect"
+	"github.com/hallucinaut/runtimebase/pkg/metrics"
+)
+
+// Metrics of a Governor: the events it sheds, by pipeline and reason
+// ("sampling" for events a kept one stands for, "memory" for events lost
+// to the memory budget), and the time spent over budget, by resource.
+const (
+	MetricDropped = "runtimebase_budget_dropped_total"
+	MetricOver    = "runtimebase_budget_over_seconds_total"
+)
+
+// MaxSampling bounds the sampling the CPU budget imposes: one event is
+// kept in MaxSampling at least, in every stream.
+const MaxSampling = 1000
+
+// Governor enforces a CPU and a memory budget.
+type Governor struct {
+	// CPU is the budget in cores, e.g. 0.5; 0 leaves CPU unlimited.
+	CPU float64
+	// Memory is the budget in bytes; 0 leaves memory unlimited.
+	Memory int64
+	// Interval is how often usage is measured.
+	Interval time.Duration
+	Metrics  *metrics.Registry
+	Logger   *slog.Logger
+
+	every  atomic.Int64 // keep about one event in every
+	shed   atomic.Bool  // memory is over budget
+	cpu    func() (time.Duration, error)
+	memory func() uint64
+
+	mu       sync.Mutex
+	samplers map[string]*collect.Sampler // by pipeline
+}
+
+// New returns a governor of the given budgets, measuring every second.
+func New(cpu float64, memory int64, reg *metrics.Registry, logger *slog.Logger) *Governor {
+	reg.Describe(MetricDropped, "Events shed to stay within the daemon's resource budget, by pipeline and reason.")
+	reg.Describe(MetricOver, "Time the daemon spent over its resource budget, by resource.")
+	g := &Governor{CPU: cpu, Memory: memory, Interval: time.Second, Metrics: reg, Logger: logger,
+		cpu: cpuTime, memory: memoryInUse}
+	g.every.Store(1)
+	return g
+}
+
+// Apply limits the Go runtime to the budgets: GOMAXPROCS to the CPU budget
+// rounded up, unless the GOMAXPROCS environment variable sets it, and the
+// garbage collector's soft limit to 90% of the memory budget, so the heap
+// is collected harder before events are dropped.
+func (g *Governor) Apply() {
+	if g.CPU > 0 && os.Getenv("GOMAXPROCS") == "" {
+		procs := max(1, int(math.Ceil(g.CPU)))
+		if procs < runtime.GOMAXPROCS(0) {
+			runtime.GOMAXPROCS(procs)
+			g.Logger.Info("limited GOMAXPROCS to the CPU budget", "gomaxprocs", procs, "cpu", g.CPU)
+		}
+	}
+	if g.Memory > 0 {
+		debug.SetMemoryLimit(g.Memory / 10 * 9)
+	}
+}
+
+// Run measures usage every Interval and adapts shedding until ctx is done.
+func (g *Governor) Run(ctx context.Context) {
+	ticker := time.NewTicker(g.Interval)
+	defer ticker.Stop()
+	last := time.Now()
+	used, err := g.cpu()
+	if err != nil && g.CPU > 0 {
+		g.Logger.Warn("not enforcing the CPU budget", "error", err)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			cores := -1.0
+			if u, err := g.cpu(); err == nil {
+				cores = (u - used).Seconds() / now.Sub(last).Seconds()
+				used = u
+			}
+			g.Adjust(cores, g.memory(), now.Sub(last))
+			last = now
+		}
+	}
+}
+
+// Adjust adapts shedding to the cores used and memory in use over the last
+// elapsed time; a negative cores is unknown. Over the CPU budget, sampling
+// grows in proportion to the excess; under 70% of it, sampling halves. The
+// sampler then keeps about one in Sampling of the events seen since the
+// last adjustment, sparing quiet streams. Memory over budget drops events
+// until it falls below 90% of the budget.
+func (g *Governor) Adjust(cores float64, memory uint64, elapsed time.Duration) {
+	if g.CPU > 0 && cores >= 0 {
+		every := g.every.Load()
+		next := every
+		switch {
+		case cores > g.CPU:
+			next = min(MaxSampling, int64(math.Ceil(float64(every)*cores/g.CPU)))
+			g.Metrics.Add(MetricOver, elapsed.Seconds(), "resource", "cpu")
+		case cores < 0.7*g.CPU && every > 1:
+			next = every / 2
+		}
+		if next != every {
+			g.every.Store(next)
+			g.Logger.Info("adapted event sampling to the CPU budget", "keep_one_in", next, "cores", math.Round(cores*100)/100, "cpu", g.CPU)
+		}
+		g.mu.Lock()
+		for _, sampler := range g.samplers {
+			sampler.Adapt(1 / float64(next))
+		}
+		g.mu.Unlock()
+	}
+	if g.Memory > 0 {
+		over := memory > uint64(g.Memory)
+		if g.shed.Load() {
+			over = memory > uint64(g.Memory)/10*9
+		}
+		if over {
+			g.Metrics.Add(MetricOver, elapsed.Seconds(), "resource", "memory")
+		}
+		if g.shed.Swap(over) != over {
+			if over {
+				g.Logger.Warn("dropping events over the memory budget", "memory", memory, "budget", g.Memory)
+			} else {
+				g.Logger.Info("resumed events under the memory budget", "memory", memory, "budget", g.Memory)
+			}
+		}
+	}
+}
+
+// Sampling returns N when about one event in N is kept.
+func (g *Governor) Sampling() int {
+	return int(g.every.Load())
+}
+
+// Admit reports whether the next record of a pipeline, of the sampling
+// stream key (see collect.SampleKey), is processed and, if so, how many
+// records it stands for: more than one while the CPU budget samples its
+// stream. Records shed are counted under MetricDropped.
+func (g *Governor) Admit(pipeline, key string) (weight int, ok bool) {
+	if g.shed.Load() {
+		g.Metrics.Add(MetricDropped, 1, "pipeline", pipeline, "reason", "memory")
+		return 0, false
+	}
+	rate, ok := g.sampler(pipeline).Keep(key)
+	if !ok {
+		g.Metrics.Add(MetricDropped, 1, "pipeline", pipeline, "reason", "sampling")
+		return 0, false
+	}
+	return rate, true
+}
+
+// sampler returns the sampler of a pipeline's streams.
+func (g *Governor) sampler(pipeline string) *collect.Sampler {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	sampler := g.samplers[pipeline]
+	if sampler == nil {
+		sampler = collect.NewSampler(collect.SamplingPolicy{
+			Always:  collect.DefaultAlwaysSampled,
+			MaxRate: MaxSampling,
+			Window:  -1, // adapted as usage is measured
+		}, nil)
+		if g.samplers == nil {
+			g.samplers = make(map[string]*collect.Sampler)
+		}
+		g.samplers[pipeline] = sampler
+	}
+	return sampler
+}
+
+// memoryInUse returns the memory the Go runtime holds from the operating
+// system and has not returned.
+func memoryInUse() uint64 {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.Sys - ms.HeapReleased
+}
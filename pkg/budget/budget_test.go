@@ -0,0 +1,90 @@
+package budget
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/metrics"
+)
+
+func TestGovernor(t *testing.T) {
+	reg := metrics.NewRegistry()
+	g := New(0.5, 100<<20, reg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	admit := func(n int) (kept, weight int) {
+		for i := 0; i < n; i++ {
+			if w, ok := g.Admit("web", "file"); ok {
+				kept++
+				weight += w
+			}
+		}
+		return kept, weight
+	}
+
+	if kept, _ := admit(100); kept != 100 {
+		t.Fatalf("kept %d events within budget", kept)
+	}
+	g.Adjust(2, 10<<20, time.Second)
+	if g.Sampling() != 4 {
+		t.Fatalf("sampling = %d at 4x the CPU budget", g.Sampling())
+	}
+	if kept, weight := admit(100); kept != 25 || weight != 100 {
+		t.Errorf("kept %d events weighing %d, want 25 weighing 100", kept, weight)
+	}
+	g.Adjust(0.2, 10<<20, time.Second)
+	if g.Sampling() != 2 {
+		t.Errorf("sampling = %d well under the CPU budget", g.Sampling())
+	}
+
+	g.Adjust(0.4, 120<<20, time.Second)
+	if kept, _ := admit(10); kept != 0 {
+		t.Errorf("kept %d events over the memory budget", kept)
+	}
+	// Memory must fall below 90% of the budget before events resume.
+	g.Adjust(0.4, 95<<20, time.Second)
+	if kept, _ := admit(10); kept != 0 {
+		t.Errorf("kept %d events just under the memory budget", kept)
+	}
+	g.Adjust(0.4, 80<<20, time.Second)
+	if kept, _ := admit(10); kept != 5 {
+		t.Errorf("kept %d of 10 events sampled 1 in 2", kept)
+	}
+
+	if n := reg.Value(MetricDropped, "pipeline", "web", "reason", "memory"); n != 20 {
+		t.Errorf("dropped for memory = %v", n)
+	}
+	if n := reg.Value(MetricDropped, "pipeline", "web", "reason", "sampling"); n != 80 {
+		t.Errorf("dropped by sampling = %v", n)
+	}
+	if s := reg.Value(MetricOver, "resource", "cpu"); s != 1 {
+		t.Errorf("seconds over the CPU budget = %v", s)
+	}
+}
+
+func TestGovernorSparesQuietStreams(t *testing.T) {
+	g := New(0.5, 0, metrics.NewRegistry(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	admit := func(key string, n int) (kept, weight int) {
+		for i := 0; i < n; i++ {
+			if w, ok := g.Admit("host", key); ok {
+				kept++
+				weight += w
+			}
+		}
+		return kept, weight
+	}
+	admit("syscall:read", 1000)
+	admit("process:exec", 10)
+	admit("file:open", 10)
+	g.Adjust(2, 0, time.Second)
+
+	if kept, weight := admit("syscall:read", 1000); kept > 250 || weight < 990 || weight > 1010 {
+		t.Errorf("kept %d reads weighing %d, want them sampled and weighing about 1000", kept, weight)
+	}
+	if kept, _ := admit("process:exec", 10); kept != 10 {
+		t.Errorf("kept %d of 10 execs, want all of them", kept)
+	}
+	if kept, _ := admit("file:open", 10); kept != 10 {
+		t.Errorf("kept %d of 10 opens of a quiet stream, want all of them", kept)
+	}
+}
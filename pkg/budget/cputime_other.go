@@ -0,0 +1,13 @@
+//go:build !unix
+
+package budget
+
+import (
+	"errors"
+	"time"
+)
+
+// cpuTime fails: the CPU budget is not enforced on this platform.
+func cpuTime() (time.Duration, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,206 @@
+// Package category defines the ontology of behavior categories: the
+// prefixes of baseline keys such as "syscall" in "syscall:openat".
+// Categories are hierarchical, with dots separating levels, so
+// "network.dns" is a kind of "network", and rules select them with
+// patterns such as "network", "file.*" or "*.write".
+package category
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Category is one category of behavior.
+type Category struct {
+	// Name is the dotted path of the category, e.g. "network.dns".
+	Name        string `json:"name" yaml:"name"`
+	Description string `json:"description" yaml:"description"`
+}
+
+// Builtin are the categories runtimebase collects or learns itself.
+var Builtin = []Category{
+	{Name: "syscall", Description: "System calls by name"},
+	{Name: "file", Description: "File accesses by path"},
+	{Name: "file.read", Description: "File reads"},
+	{Name: "file.write", Description: "File writes"},
+	{Name: "network", Description: "Network connections by destination"},
+	{Name: "network.dns", Description: "DNS lookups by name"},
+	{Name: "network.http", Description: "Outbound HTTP requests by host"},
+	{Name: "process", Description: "Processes executed by path"},
+	{Name: "resource", Description: "Resource pressure measurements"},
+	{Name: "log", Description: "Log message templates"},
+	{Name: "http", Description: "Served HTTP traffic from access logs"},
+}
+
+var (
+	segment        = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+	patternSegment = regexp.MustCompile(`^[a-z0-9_*?-]+$`)
+)
+
+// Ontology is a set of categories.
+type Ontology struct {
+	categories map[string]Category
+}
+
+// New builds an ontology of the built-in categories and custom ones. Every
+// category's parent, the name without its last level, must be part of
+// it.
+func New(custom []Category) (*Ontology, error) {
+	o := &Ontology{categories: make(map[string]Category)}
+	for _, c := range append(append([]Category(nil), Builtin...), custom...) {
+		if err := ValidateName(c.Name); err != nil {
+			return nil, err
+		}
+		if _, dup := o.categories[c.Name]; dup {
+			return nil, fmt.Errorf("duplicate category %q", c.Name)
+		}
+		o.categories[c.Name] = c
+	}
+	for name := range o.categories {
+		if parent := Parent(name); parent != "" {
+			if _, ok := o.categories[parent]; !ok {
+				return nil, fmt.Errorf("category %q: parent %q is not defined", name, parent)
+			}
+		}
+	}
+	return o, nil
+}
+
+// Default returns the ontology of the built-in categories.
+func Default() *Ontology {
+	o, _ := New(nil)
+	return o
+}
+
+// Categories returns the ontology's categories, sorted by name so children
+// follow their parents.
+func (o *Ontology) Categories() []Category {
+	categories := make([]Category, 0, len(o.categories))
+	for _, c := range o.categories {
+		categories = append(categories, c)
+	}
+	sort.Slice(categories, func(i, j int) bool { return categories[i].Name < categories[j].Name })
+	return categories
+}
+
+// Lookup finds a category by name.
+func (o *Ontology) Lookup(name string) (Category, bool) {
+	c, ok := o.categories[name]
+	return c, ok
+}
+
+// Validate checks that name is a category of the ontology.
+func (o *Ontology) Validate(name string) error {
+	if err := ValidateName(name); err != nil {
+		return err
+	}
+	if _, ok := o.categories[name]; !ok {
+		return fmt.Errorf("unknown category %q", name)
+	}
+	return nil
+}
+
+// ValidatePattern checks that a category pattern is well formed and
+// selects at least one category of the ontology.
+func (o *Ontology) ValidatePattern(pattern string) error {
+	if err := ValidatePatternSyntax(pattern); err != nil {
+		return err
+	}
+	for name := range o.categories {
+		if Match(pattern, name) {
+			return nil
+		}
+	}
+	return fmt.Errorf("category pattern %q matches no known category", pattern)
+}
+
+// ValidatePatternSyntax checks that a category pattern is well formed,
+// without requiring it to match a known category, for filters over
+// categories applications report freely.
+func ValidatePatternSyntax(pattern string) error {
+	for _, s := range strings.Split(pattern, ".") {
+		if !patternSegment.MatchString(s) {
+			return fmt.Errorf("invalid category pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// ValidateName checks the syntax of a category name: lower-case levels of
+// letters, digits, "_" and "-", starting with a letter and separated by
+// dots.
+func ValidateName(name string) error {
+	for _, s := range strings.Split(name, ".") {
+		if !segment.MatchString(s) {
+			return fmt.Errorf("invalid category name %q", name)
+		}
+	}
+	return nil
+}
+
+// Parent returns the category name without its last level, or "" for
+// top-level categories.
+func Parent(name string) string {
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// Match reports whether category matches pattern. Levels are matched one
+// by one, with "*" matching any one level, or any text within one, and
+// "?" any one character; a pattern also matches the descendants of what
+// it matches. So "network" matches "network" and "network.dns",
+// "network.*" only the latter, "*.write" "file.write", and "*" anything.
+// An empty pattern matches everything.
+func Match(pattern, category string) bool {
+	if pattern == "" {
+		return true
+	}
+	want := strings.Split(pattern, ".")
+	have := strings.Split(category, ".")
+	if len(have) < len(want) {
+		return false
+	}
+	for i, p := range want {
+		if ok, _ := path.Match(p, have[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Of returns the category of a baseline key, e.g. "network.dns" for
+// "network.dns:example.com", or "" when the key has none.
+func Of(key string) string {
+	if category, _, ok := strings.Cut(key, ":"); ok && ValidateName(category) == nil {
+		return category
+	}
+	return ""
+}
+
+var current atomic.Pointer[Ontology]
+
+func init() {
+	current.Store(Default())
+}
+
+// SetCurrent installs the ontology used by Known and Current.
+func SetCurrent(o *Ontology) {
+	current.Store(o)
+}
+
+// Current returns the active ontology.
+func Current() *Ontology {
+	return current.Load()
+}
+
+// Known reports whether name is a category of the active ontology.
+func Known(name string) bool {
+	_, ok := Current().Lookup(name)
+	return ok
+}
@@ -0,0 +1,62 @@
+package category
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, category string
+		want              bool
+	}{
+		{"", "network.dns", true},
+		{"network", "network", true},
+		{"network", "network.dns", true},
+		{"network", "networking", false},
+		{"network.*", "network", false},
+		{"network.*", "network.dns", true},
+		{"*.write", "file.write", true},
+		{"*.write", "file.read", false},
+		{"*", "process", true},
+		{"net*", "network.http", true},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.category); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.category, got, tt.want)
+		}
+	}
+}
+
+func TestOntology(t *testing.T) {
+	o, err := New([]Category{{Name: "rpc"}, {Name: "rpc.grpc"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Validate("rpc.grpc"); err != nil {
+		t.Error(err)
+	}
+	if err := o.ValidatePattern("*.grpc"); err != nil {
+		t.Error(err)
+	}
+	if err := o.ValidatePattern("queue"); err == nil {
+		t.Error("expected a pattern matching no category to be rejected")
+	}
+	if err := o.ValidatePattern("Network"); err == nil {
+		t.Error("expected an upper-case pattern to be rejected")
+	}
+
+	if _, err := New([]Category{{Name: "queue.kafka"}}); err == nil {
+		t.Error("expected a category without its parent to be rejected")
+	}
+	if _, err := New([]Category{{Name: "network"}}); err == nil {
+		t.Error("expected a duplicate category to be rejected")
+	}
+	if _, err := New([]Category{{Name: "rpc..grpc"}}); err == nil {
+		t.Error("expected an empty level to be rejected")
+	}
+
+	if got := Of("network.dns:example.com"); got != "network.dns" {
+		t.Errorf("Of: expected network.dns, got %q", got)
+	}
+	if got := Of("GET /login: 200"); got != "" {
+		t.Errorf("Of: expected no category of a log line, got %q", got)
+	}
+}
@@ -0,0 +1,134 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Defaults of a Skew.
+const (
+	DefaultSkewSamples   = 64
+	DefaultSkewTolerance = time.Second
+	DefaultSkewLimit     = 10 * time.Minute
+)
+
+// Skew estimates how far the clocks of the hosts events come from are off
+// from the local clock, so their times can be corrected before events of
+// several hosts are counted into the same intervals.
+//
+// Each event gives a sample of the host's offset: the time it reports
+// minus the time it is received. Transit only ever makes samples smaller,
+// so like NTP's clock filter the estimate is the largest of the last
+// Samples samples, the one delayed least. Estimates within Tolerance are
+// taken as no skew, and those beyond Limit as a backlog being replayed,
+// such as a log read from its start, rather than a clock that is off.
+type Skew struct {
+	Samples   int
+	Tolerance time.Duration
+	Limit     time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostClock
+}
+
+type hostClock struct {
+	samples []time.Duration
+	next    int
+	offset  time.Duration
+	fixed   bool
+}
+
+// NewSkew returns an estimator with the default samples, tolerance and
+// limit.
+func NewSkew() *Skew {
+	return &Skew{Samples: DefaultSkewSamples, Tolerance: DefaultSkewTolerance, Limit: DefaultSkewLimit}
+}
+
+// Fix sets the offset of host's clock, such as one measured out of band;
+// it is not estimated from the host's events.
+func (s *Skew) Fix(host string, offset time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.host(host)
+	h.offset, h.fixed = offset, true
+}
+
+// Observe records an event host reported at reported and that was
+// received at received, and returns the estimated offset of host's clock;
+// changed is set when host's clock starts or stops being off, or the
+// estimate moves by more than Tolerance.
+func (s *Skew) Observe(host string, reported, received time.Time) (offset time.Duration, changed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.host(host)
+	if h.fixed || reported.IsZero() {
+		return h.offset, false
+	}
+	sample := reported.Sub(received)
+	if len(h.samples) < max(s.Samples, 1) {
+		h.samples = append(h.samples, sample)
+	} else {
+		h.samples[h.next] = sample
+		h.next = (h.next + 1) % len(h.samples)
+	}
+	estimate := h.samples[0]
+	for _, sample := range h.samples[1:] {
+		estimate = max(estimate, sample)
+	}
+	if magnitude := max(estimate, -estimate); magnitude <= s.Tolerance || (s.Limit > 0 && magnitude > s.Limit) {
+		estimate = 0
+	}
+	drift := estimate - h.offset
+	changed = (estimate == 0) != (h.offset == 0) || max(drift, -drift) > s.Tolerance
+	h.offset = estimate
+	return estimate, changed
+}
+
+// Offset returns the estimated offset of host's clock: positive when it
+// is ahead of the local clock.
+func (s *Skew) Offset(host string) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h, ok := s.hosts[host]; ok {
+		return h.offset
+	}
+	return 0
+}
+
+// Offsets returns the offsets of the hosts whose clock is off.
+func (s *Skew) Offsets() map[string]time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	offsets := make(map[string]time.Duration)
+	for name, h := range s.hosts {
+		if h.offset != 0 {
+			offsets[name] = h.offset
+		}
+	}
+	return offsets
+}
+
+// Correct returns t, a time host reported, on the local clock.
+func (s *Skew) Correct(host string, t time.Time) time.Time {
+	return t.Add(-s.Offset(host))
+}
+
+func (s *Skew) host(name string) *hostClock {
+	if s.hosts == nil {
+		s.hosts = make(map[string]*hostClock)
+	}
+	h, ok := s.hosts[name]
+	if !ok {
+		h = &hostClock{}
+		s.hosts[name] = h
+	}
+	return h
+}
+
+// InZone returns the time whose wall clock reading in loc is that of t,
+// for times parsed without a zone, such as those of logs written in local
+// time, that belong to loc.
+func InZone(t time.Time, loc *time.Location) time.Time {
+	y, mo, d := t.Date()
+	return time.Date(y, mo, d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
@@ -0,0 +1,225 @@
+package collect
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+// DefaultCgroupRoot is where the cgroup v2 hierarchy is mounted.
+const DefaultCgroupRoot = "/sys/fs/cgroup"
+
+// MemoryEvents are the memory.events counters CgroupCollector reports.
+var MemoryEvents = []string{"high", "max", "oom", "oom_kill"}
+
+// containerCgroup matches the cgroup directory of a container under the
+// systemd driver (cri-containerd-<id>.scope, docker-<id>.scope,
+// crio-<id>.scope) and the cgroupfs driver (<id>).
+var containerCgroup = regexp.MustCompile(`^(?:[a-z-]+-)?([0-9a-f]{64})(?:\.scope)?$`)
+
+// CgroupStats is one reading of a cgroup's resource accounting.
+type CgroupStats struct {
+	// Pressure holds the 10-second pressure stall averages, in percent,
+	// keyed like "cpu.pressure" (some) and "memory.pressure.full".
+	Pressure map[string]float64
+	// MemoryEvents holds the memory.events counters, e.g. "oom_kill".
+	MemoryEvents map[string]int64
+	// ThrottledUsec is the time the cgroup's CPU quota throttled it.
+	ThrottledUsec int64
+	ReadAt        time.Time
+}
+
+// ContainerCgroups returns the cgroup directories under root that belong
+// to containers, keyed by container ID.
+func ContainerCgroups(root string) (map[string]string, error) {
+	cgroups := make(map[string]string)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			// Cgroups come and go while we walk.
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if m := containerCgroup.FindStringSubmatch(d.Name()); m != nil {
+			cgroups[m[1]] = path
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return cgroups, err
+}
+
+// ReadCgroupStats reads the pressure, memory.events and cpu.stat files of
+// the cgroup at dir. Files the kernel does not provide, such as pressure
+// files without CONFIG_PSI, are skipped.
+func ReadCgroupStats(dir string, now time.Time) (CgroupStats, error) {
+	stats := CgroupStats{Pressure: make(map[string]float64), MemoryEvents: make(map[string]int64), ReadAt: now}
+	for _, resource := range []string{"cpu", "memory", "io"} {
+		err := readKeyed(filepath.Join(dir, resource+".pressure"), func(fields []string) {
+			metric := resource + ".pressure"
+			if fields[0] == "full" {
+				metric += ".full"
+			} else if fields[0] != "some" {
+				return
+			}
+			for _, field := range fields[1:] {
+				if v, ok := strings.CutPrefix(field, "avg10="); ok {
+					if avg, err := strconv.ParseFloat(v, 64); err == nil {
+						stats.Pressure[metric] = avg
+					}
+				}
+			}
+		})
+		if err != nil {
+			return stats, err
+		}
+	}
+	err := readKeyed(filepath.Join(dir, "memory.events"), func(fields []string) {
+		if n, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			stats.MemoryEvents[fields[0]] = n
+		}
+	})
+	if err != nil {
+		return stats, err
+	}
+	err = readKeyed(filepath.Join(dir, "cpu.stat"), func(fields []string) {
+		if fields[0] == "throttled_usec" {
+			stats.ThrottledUsec, _ = strconv.ParseInt(fields[1], 10, 64)
+		}
+	})
+	return stats, err
+}
+
+// readKeyed calls fn with the fields of every line of path with at least
+// two. A missing file is not an error.
+func readKeyed(path string, fn func(fields []string)) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.Fields(scanner.Text()); len(fields) >= 2 {
+			fn(fields)
+		}
+	}
+	return scanner.Err()
+}
+
+// Events turns a reading into "resource" events for the container id, one
+// per measurement. Pressure is reported as read; memory events as the
+// number since prev and CPU throttling as the percentage of the time since
+// prev spent throttled. Without a previous reading, only pressure is
+// reported.
+func (s CgroupStats) Events(prev *CgroupStats, id, dir string) []detect.SystemEvent {
+	var events []detect.SystemEvent
+	emit := func(metric string, value float64) {
+		events = append(events, detect.SystemEvent{
+			Type:      "resource",
+			Timestamp: s.ReadAt,
+			Path:      dir,
+			Data:      map[string]interface{}{"metric": metric, "value": value, "container_id": id},
+			Labels:    map[string]string{"container_id": id},
+		})
+	}
+	metrics := make([]string, 0, len(s.Pressure))
+	for metric := range s.Pressure {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+	for _, metric := range metrics {
+		emit(metric, s.Pressure[metric])
+	}
+	if prev == nil {
+		return events
+	}
+	for _, name := range MemoryEvents {
+		if n, ok := s.MemoryEvents[name]; ok {
+			// Counters only go down when the cgroup was recreated.
+			emit("memory."+name, float64(max(n-prev.MemoryEvents[name], 0)))
+		}
+	}
+	if elapsed := s.ReadAt.Sub(prev.ReadAt).Microseconds(); elapsed > 0 {
+		throttled := max(s.ThrottledUsec-prev.ThrottledUsec, 0)
+		emit("cpu.throttled", min(100*float64(throttled)/float64(elapsed), 100))
+	}
+	return events
+}
+
+// CgroupCollector polls the cgroup v2 accounting of every container on the
+// host: pressure stall information for CPU, memory and I/O, memory events
+// such as OOM kills, and CPU throttling. Each reading is emitted as
+// "resource" events labeled with the container ID, so resource use can be
+// baselined per container like any other behavior.
+type CgroupCollector struct {
+	Root     string        // defaults to DefaultCgroupRoot
+	Interval time.Duration // defaults to 10s, the window of the PSI averages
+}
+
+// Run implements Collector.
+func (c *CgroupCollector) Run(ctx context.Context, events chan<- detect.SystemEvent) error {
+	root := c.Root
+	if root == "" {
+		root = DefaultCgroupRoot
+	}
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err != nil {
+		return fmt.Errorf("%s is not a cgroup v2 hierarchy: %w", root, err)
+	}
+	interval := c.Interval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	previous := make(map[string]*CgroupStats)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		cgroups, err := ContainerCgroups(root)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		current := make(map[string]*CgroupStats, len(cgroups))
+		for id, dir := range cgroups {
+			stats, err := ReadCgroupStats(dir, now)
+			if err != nil {
+				// The container exited between the walk and the read.
+				continue
+			}
+			current[id] = &stats
+			for _, event := range stats.Events(previous[id], id, dir) {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		}
+		previous = current
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
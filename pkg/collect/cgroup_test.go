@@ -0,0 +1,66 @@
+package collect
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCgroupStats(t *testing.T) {
+	root := t.TempDir()
+	id := "4f1c2a9b0d3e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b0c1d2e3f4a5b6c7d8e9f0a"
+	dir := filepath.Join(root, "kubepods.slice", "kubepods-burstable.slice", "cri-containerd-"+id+".scope")
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	write("cpu.pressure", "some avg10=12.50 avg60=3.00 avg300=1.00 total=123\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n")
+	write("memory.pressure", "some avg10=1.25 avg60=0.00 avg300=0.00 total=5\nfull avg10=0.75 avg60=0.00 avg300=0.00 total=2\n")
+	write("memory.events", "low 0\nhigh 4\nmax 0\noom 1\noom_kill 1\n")
+	write("cpu.stat", "usage_usec 900000\nnr_throttled 3\nthrottled_usec 100000\n")
+
+	cgroups, err := ContainerCgroups(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cgroups) != 1 || cgroups[id] != dir {
+		t.Fatalf("expected the container cgroup, got %v", cgroups)
+	}
+
+	start := time.Unix(0, 0)
+	first, err := ReadCgroupStats(dir, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Pressure["cpu.pressure"] != 12.5 || first.Pressure["memory.pressure.full"] != 0.75 {
+		t.Errorf("unexpected pressure %v", first.Pressure)
+	}
+	if events := first.Events(nil, id, dir); len(events) != 4 {
+		t.Errorf("expected only pressure without a previous reading, got %d events", len(events))
+	}
+
+	write("memory.events", "low 0\nhigh 4\nmax 0\noom 3\noom_kill 3\n")
+	write("cpu.stat", "usage_usec 1900000\nnr_throttled 9\nthrottled_usec 600000\n")
+	second, err := ReadCgroupStats(dir, start.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, e := range second.Events(&first, id, dir) {
+		if e.Labels["container_id"] != id {
+			t.Errorf("expected events labeled with the container, got %v", e.Labels)
+		}
+		values[e.ResourceKey()] = e.ResourceValue()
+	}
+	if values["resource:memory.oom_kill"] != 2 || values["resource:memory.high"] != 0 {
+		t.Errorf("expected memory events since the last reading, got %v", values)
+	}
+	if values["resource:cpu.throttled"] != 50 {
+		t.Errorf("expected 50%% throttled, got %v", values["resource:cpu.throttled"])
+	}
+}
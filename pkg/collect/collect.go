@@ -0,0 +1,13 @@
+// Package collect gathers live system events from runtime sources.
+package collect
+
+import (
+	"context"
+
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+// Collector streams system events until ctx is cancelled or the source fails.
+type Collector interface {
+	Run(ctx context.Context, events chan<- detect.SystemEvent) error
+}
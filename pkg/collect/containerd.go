@@ -0,0 +1,274 @@
+package collect
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+// ContainerdEvent is one event from the containerd events service.
+type ContainerdEvent struct {
+	Timestamp time.Time
+	Namespace string
+	Topic     string // e.g. /tasks/start, /tasks/exit, /containers/delete
+	Payload   map[string]interface{}
+}
+
+// ContainerID returns the container the event refers to.
+func (e ContainerdEvent) ContainerID() string {
+	for _, key := range []string{"container_id", "id"} {
+		if id, ok := e.Payload[key].(string); ok && id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+const ctrTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// ParseCtrEvent parses a line of `ctr events` output:
+//
+//	2026-10-16 12:00:00.123456789 +0000 UTC k8s.io /tasks/exit {"container_id":"...","pid":1234,"exit_status":137}
+func ParseCtrEvent(line string) (ContainerdEvent, error) {
+	fields := strings.SplitN(line, " ", 7)
+	if len(fields) < 6 {
+		return ContainerdEvent{}, fmt.Errorf("malformed containerd event: %q", line)
+	}
+
+	ts, err := time.Parse(ctrTimeLayout, strings.Join(fields[:4], " "))
+	if err != nil {
+		return ContainerdEvent{}, fmt.Errorf("invalid event timestamp: %w", err)
+	}
+
+	event := ContainerdEvent{
+		Timestamp: ts,
+		Namespace: fields[4],
+		Topic:     fields[5],
+		Payload:   make(map[string]interface{}),
+	}
+	if len(fields) == 7 && strings.TrimSpace(fields[6]) != "" {
+		if err := json.Unmarshal([]byte(fields[6]), &event.Payload); err != nil {
+			return ContainerdEvent{}, fmt.Errorf("invalid event payload: %w", err)
+		}
+	}
+	return event, nil
+}
+
+// Pod identifies the Kubernetes pod a container belongs to.
+type Pod struct {
+	Name      string
+	Namespace string
+	Container string
+}
+
+// PodResolver maps container IDs to pods.
+type PodResolver interface {
+	Resolve(ctx context.Context, containerID string) (Pod, error)
+}
+
+// CRIResolver resolves pods through the CRI using crictl, caching results
+// per container.
+type CRIResolver struct {
+	CrictlPath string
+
+	mu    sync.Mutex
+	cache map[string]Pod
+	// output runs a command and returns its standard output; tests
+	// replace it.
+	output func(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// Resolve implements PodResolver.
+func (r *CRIResolver) Resolve(ctx context.Context, containerID string) (Pod, error) {
+	r.mu.Lock()
+	if pod, ok := r.cache[containerID]; ok {
+		r.mu.Unlock()
+		return pod, nil
+	}
+	r.mu.Unlock()
+
+	path := r.CrictlPath
+	if path == "" {
+		path = "crictl"
+	}
+	output := r.output
+	if output == nil {
+		output = commandOutput
+	}
+	out, err := output(ctx, path, "inspect", "-o", "json", containerID)
+	if err != nil {
+		return Pod{}, fmt.Errorf("crictl inspect %s: %w", containerID, err)
+	}
+
+	var inspect struct {
+		Status struct {
+			Labels map[string]string `json:"labels"`
+		} `json:"status"`
+	}
+	if err := json.Unmarshal(out, &inspect); err != nil {
+		return Pod{}, fmt.Errorf("decoding crictl output: %w", err)
+	}
+
+	labels := inspect.Status.Labels
+	pod := Pod{
+		Name:      labels["io.kubernetes.pod.name"],
+		Namespace: labels["io.kubernetes.pod.namespace"],
+		Container: labels["io.kubernetes.container.name"],
+	}
+
+	r.mu.Lock()
+	if r.cache == nil {
+		r.cache = make(map[string]Pod)
+	}
+	r.cache[containerID] = pod
+	r.mu.Unlock()
+	return pod, nil
+}
+
+func commandOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).Output()
+}
+
+// ContainerdEvents is a subscription to containerd's events service.
+type ContainerdEvents interface {
+	// Subscribe sends events to out until ctx is cancelled or the
+	// subscription fails.
+	Subscribe(ctx context.Context, out chan<- ContainerdEvent) error
+}
+
+// CtrEvents subscribes to containerd's events through `ctr events`, which
+// speaks the containerd events gRPC API, so it works on clusters without
+// Docker and without linking the containerd client.
+type CtrEvents struct {
+	CtrPath   string // defaults to "ctr"
+	Address   string // containerd socket; empty uses ctr's default
+	Namespace string // containerd namespace, e.g. "k8s.io"; empty for all
+}
+
+// Subscribe implements ContainerdEvents. Lines ctr prints that are not
+// events are skipped.
+func (c *CtrEvents) Subscribe(ctx context.Context, out chan<- ContainerdEvent) error {
+	path := c.CtrPath
+	if path == "" {
+		path = "ctr"
+	}
+	var args []string
+	if c.Address != "" {
+		args = append(args, "--address", c.Address)
+	}
+	if c.Namespace != "" {
+		args = append(args, "--namespace", c.Namespace)
+	}
+	args = append(args, "events")
+
+	cmd := exec.CommandContext(ctx, path, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting %s: %w", path, err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		event, err := ParseCtrEvent(scanner.Text())
+		if err != nil {
+			continue
+		}
+		select {
+		case out <- event:
+		case <-ctx.Done():
+			cmd.Wait()
+			return ctx.Err()
+		}
+	}
+
+	if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("%s events: %w", path, err)
+	}
+	return ctx.Err()
+}
+
+// ContainerdCollector streams task and container lifecycle events from
+// containerd as "container" events, annotated with their pod when Resolver
+// is set.
+type ContainerdCollector struct {
+	Events   ContainerdEvents // defaults to ctr's, for the fields below
+	Resolver PodResolver
+
+	CtrPath   string // see CtrEvents
+	Address   string
+	Namespace string
+}
+
+// Run implements Collector.
+func (c *ContainerdCollector) Run(ctx context.Context, events chan<- detect.SystemEvent) error {
+	source := c.Events
+	if source == nil {
+		source = &CtrEvents{CtrPath: c.CtrPath, Address: c.Address, Namespace: c.Namespace}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	raw := make(chan ContainerdEvent)
+	errs := make(chan error, 1)
+	go func() { errs <- source.Subscribe(ctx, raw) }()
+	for {
+		select {
+		case event := <-raw:
+			select {
+			case events <- c.toSystemEvent(ctx, event):
+			case <-ctx.Done():
+				return <-errs
+			}
+		case err := <-errs:
+			return err
+		}
+	}
+}
+
+func (c *ContainerdCollector) toSystemEvent(ctx context.Context, event ContainerdEvent) detect.SystemEvent {
+	data := map[string]interface{}{
+		"source":    "containerd",
+		"namespace": event.Namespace,
+		"topic":     event.Topic,
+	}
+	id := event.ContainerID()
+	if id != "" {
+		data["container_id"] = id
+	}
+	if status, ok := event.Payload["exit_status"].(float64); ok {
+		data["exit_status"] = int(status)
+	} else if event.Topic == "/tasks/exit" {
+		// exit_status is omitted from the JSON encoding when zero.
+		data["exit_status"] = 0
+	}
+
+	if c.Resolver != nil && id != "" {
+		if pod, err := c.Resolver.Resolve(ctx, id); err == nil && pod.Name != "" {
+			data["pod"] = pod.Name
+			data["pod_namespace"] = pod.Namespace
+			data["container"] = pod.Container
+		}
+	}
+
+	pid := 0
+	if p, ok := event.Payload["pid"].(float64); ok {
+		pid = int(p)
+	}
+
+	return detect.SystemEvent{
+		Type:      "container",
+		Timestamp: event.Timestamp,
+		Data:      data,
+		PID:       pid,
+	}
+}
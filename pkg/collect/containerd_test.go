@@ -0,0 +1,181 @@
+package collect
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+const ctrExit = `2026-10-16 12:00:00.123456789 +0000 UTC k8s.io /tasks/exit {"container_id":"abc123","pid":1234,"exit_status":137}`
+
+func TestParseCtrEvent(t *testing.T) {
+	event, err := ParseCtrEvent(ctrExit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := time.Date(2026, 10, 16, 12, 0, 0, 123456789, time.UTC)
+	if !event.Timestamp.Equal(want) || event.Namespace != "k8s.io" || event.Topic != "/tasks/exit" || event.ContainerID() != "abc123" {
+		t.Errorf("unexpected event %+v", event)
+	}
+
+	event, err = ParseCtrEvent("2026-10-16 12:00:00 +0000 UTC k8s.io /containers/delete")
+	if err != nil || event.Topic != "/containers/delete" || len(event.Payload) != 0 {
+		t.Errorf("expected an event without payload, got %+v, %v", event, err)
+	}
+
+	for _, line := range []string{
+		"",
+		"not an event",
+		"yesterday at noon +0000 UTC k8s.io /tasks/exit",
+		`2026-10-16 12:00:00 +0000 UTC k8s.io /tasks/exit {"broken`,
+	} {
+		if _, err := ParseCtrEvent(line); err == nil {
+			t.Errorf("expected %q rejected", line)
+		}
+	}
+}
+
+type fakeEvents []ContainerdEvent
+
+func (f fakeEvents) Subscribe(ctx context.Context, out chan<- ContainerdEvent) error {
+	for _, event := range f {
+		select {
+		case out <- event:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return errors.New("subscription closed")
+}
+
+type fakeResolver map[string]Pod
+
+func (f fakeResolver) Resolve(ctx context.Context, id string) (Pod, error) {
+	pod, ok := f[id]
+	if !ok {
+		return Pod{}, errors.New("no such container")
+	}
+	return pod, nil
+}
+
+func TestContainerdCollector(t *testing.T) {
+	exit, err := ParseCtrEvent(ctrExit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &ContainerdCollector{
+		Events: fakeEvents{
+			exit,
+			{Topic: "/tasks/exit", Payload: map[string]interface{}{"container_id": "unknown"}},
+			{Topic: "/containers/create", Payload: map[string]interface{}{"id": "abc123"}},
+		},
+		Resolver: fakeResolver{"abc123": {Name: "web-0", Namespace: "shop", Container: "nginx"}},
+	}
+	events := make(chan detect.SystemEvent, 10)
+	if err := c.Run(context.Background(), events); err == nil || err.Error() != "subscription closed" {
+		t.Fatalf("expected the subscription error, got %v", err)
+	}
+	close(events)
+	var got []detect.SystemEvent
+	for event := range events {
+		got = append(got, event)
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %d events, want 3", len(got))
+	}
+
+	first := got[0]
+	if first.Type != "container" || first.PID != 1234 || first.Data["exit_status"] != 137 || first.Data["pod"] != "web-0" ||
+		first.Data["pod_namespace"] != "shop" || first.Data["container"] != "nginx" || first.Data["container_id"] != "abc123" {
+		t.Errorf("unexpected exit event %+v", first)
+	}
+	// exit_status is omitted from ctr's JSON when zero; unresolved
+	// containers carry no pod.
+	if got[1].Data["exit_status"] != 0 || got[1].Data["pod"] != nil {
+		t.Errorf("unexpected unresolved exit event %+v", got[1])
+	}
+	if got[2].Data["container_id"] != "abc123" || got[2].Data["exit_status"] != nil || got[2].Data["pod"] != "web-0" {
+		t.Errorf("unexpected create event %+v", got[2])
+	}
+}
+
+func TestContainerdCollectorCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &ContainerdCollector{Events: fakeEvents{{Topic: "/tasks/start"}, {Topic: "/tasks/exit"}}}
+	events := make(chan detect.SystemEvent) // never read
+	done := make(chan error)
+	go func() { done <- c.Run(ctx, events) }()
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
+
+func TestCtrEvents(t *testing.T) {
+	dir := t.TempDir()
+	args := filepath.Join(dir, "args")
+	ctr := filepath.Join(dir, "ctr")
+	script := "#!/bin/sh\necho \"$@\" > " + args + "\necho 'ctr: connecting'\necho '" + ctrExit + "'\n"
+	if err := os.WriteFile(ctr, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &CtrEvents{CtrPath: ctr, Address: "/run/containerd.sock", Namespace: "k8s.io"}
+	out := make(chan ContainerdEvent, 10)
+	if err := c.Subscribe(context.Background(), out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 {
+		t.Fatalf("got %d events, want 1", len(out))
+	}
+	if event := <-out; event.ContainerID() != "abc123" {
+		t.Errorf("unexpected event %+v", event)
+	}
+	got, err := os.ReadFile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "--address /run/containerd.sock --namespace k8s.io events\n" {
+		t.Errorf("ctr called with %q", got)
+	}
+
+	if err := (&CtrEvents{CtrPath: filepath.Join(dir, "missing")}).Subscribe(context.Background(), out); err == nil {
+		t.Error("expected a missing ctr reported")
+	}
+}
+
+func TestCRIResolver(t *testing.T) {
+	calls := 0
+	r := &CRIResolver{output: func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		calls++
+		if name != "crictl" || len(args) != 4 || args[3] != "abc123" {
+			return nil, errors.New("unexpected command")
+		}
+		return []byte(`{"status":{"labels":{"io.kubernetes.pod.name":"web-0","io.kubernetes.pod.namespace":"shop","io.kubernetes.container.name":"nginx"}}}`), nil
+	}}
+	for i := 0; i < 2; i++ {
+		pod, err := r.Resolve(context.Background(), "abc123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pod != (Pod{Name: "web-0", Namespace: "shop", Container: "nginx"}) {
+			t.Errorf("unexpected pod %+v", pod)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("crictl called %d times, want 1 (cached)", calls)
+	}
+	if _, err := r.Resolve(context.Background(), "other"); err == nil {
+		t.Error("expected a crictl failure reported")
+	}
+}
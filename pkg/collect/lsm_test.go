@@ -0,0 +1,121 @@
+package collect
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+func TestParseLSMLine(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		line, key, volumeKey string
+		bytes                int64
+	}{
+		{"exec\t4242\t1\t0\tsh\t/bin/sh", "process:/bin/sh", "", 0},
+		{"connect\t4242\t1\t0\tcurl\t203.0.113.7:443", "network:203.0.113.7:443", "network:203.0.113.7:443", 0},
+		{"open\t4242\t1\t0\tpostgres\t/var/lib/db/data", "", "file:/var/lib/db/data", 0},
+		{"write\t4242\t1\t0\tpostgres\t8192\t/var/lib/db/data", "", "file:/var/lib/db/data", 8192},
+		{"send\t4242\t1\t0\tcurl\t1460\t203.0.113.7:443", "", "network:203.0.113.7:443", 1460},
+	}
+	for _, tt := range tests {
+		event, ok := ParseLSMLine(tt.line, now)
+		if !ok {
+			t.Errorf("expected %q to parse", tt.line)
+			continue
+		}
+		if event.PID != 4242 || event.Key() != tt.key || event.VolumeKey() != tt.volumeKey || event.Bytes != tt.bytes {
+			t.Errorf("ParseLSMLine(%q) = key %q, volume %q, %d bytes, want %q, %q, %d",
+				tt.line, event.Key(), event.VolumeKey(), event.Bytes, tt.key, tt.volumeKey, tt.bytes)
+		}
+	}
+	for _, line := range []string{
+		"Attaching 5 probes...",
+		"write\t4242\t1\t0\tpostgres\tmany\t/var/lib/db/data",
+		"send\t4242\t1\t0\tcurl\t1460\tnot-an-address",
+	} {
+		if _, ok := ParseLSMLine(line, now); ok {
+			t.Errorf("expected %q to be rejected", line)
+		}
+	}
+}
+
+func TestParseLSMLineData(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	event, _ := ParseLSMLine("connect\t4242\t1\t1000\tcurl\t203.0.113.7:443", now)
+	want := map[string]interface{}{
+		"source": "lsm", "ppid": 1, "uid": 1000, "hook": "socket_connect", "action": "connect",
+		"destination": "203.0.113.7:443", "ip": "203.0.113.7", "port": 443,
+	}
+	if event.Type != "network" || event.ProcessName != "curl" || !event.Timestamp.Equal(now) || !reflect.DeepEqual(event.Data, want) {
+		t.Errorf("connect event %+v", event)
+	}
+	event, _ = ParseLSMLine("exec\t4242\t1\t0\tsh\t/bin/sh", now)
+	if event.Type != "process" || event.Path != "/bin/sh" || event.Data["hook"] != "bprm_check_security" || event.Data["action"] != "exec" {
+		t.Errorf("exec event %+v", event)
+	}
+	for _, line := range []string{
+		"exec\t4242\t1\t0\tsh",
+		"exec\tx\t1\t0\tsh\t/bin/sh",
+		"connect\t4242\t1\t0\tcurl\t203.0.113.7",
+		"unlink\t4242\t1\t0\trm\t/etc/passwd",
+	} {
+		if _, ok := ParseLSMLine(line, now); ok {
+			t.Errorf("expected %q to be rejected", line)
+		}
+	}
+}
+
+func TestLSMCollector(t *testing.T) {
+	dir := t.TempDir()
+	defer func(path string) { lsmList = path }(lsmList)
+	lsmList = filepath.Join(dir, "lsm")
+
+	os.WriteFile(lsmList, []byte("lockdown,capability,yama,apparmor\n"), 0o644)
+	if LSMAvailable() {
+		t.Error("expected the BPF LSM to be reported missing")
+	}
+	if err := (&LSMCollector{}).Run(context.Background(), nil); err == nil || !strings.Contains(err.Error(), "BPF LSM") {
+		t.Errorf("running without the BPF LSM: %v", err)
+	}
+	os.WriteFile(lsmList, []byte("lockdown,capability,yama,apparmor,bpf\n"), 0o644)
+	if !LSMAvailable() {
+		t.Fatal("expected the BPF LSM to be reported available")
+	}
+
+	// A stand-in for bpftrace printing events, including its own and
+	// those of an ignored process.
+	bpftrace := filepath.Join(dir, "bpftrace")
+	os.WriteFile(bpftrace, []byte(`#!/bin/sh
+echo "Attaching 5 probes..."
+printf 'exec\t10\t1\t0\tsh\t/bin/sh\n'
+printf 'open\t11\t1\t0\tbpftrace\t/sys/kernel/btf/vmlinux\n'
+printf 'open\t12\t1\t0\trunbook\t/etc/hosts\n'
+printf 'connect\t13\t10\t0\tcurl\t203.0.113.7:443\n'
+`), 0o755)
+	events := make(chan detect.SystemEvent, 10)
+	c := &LSMCollector{BpftracePath: bpftrace, IgnoreComms: []string{"runbook"}}
+	if err := c.Run(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+	close(events)
+	var keys []string
+	for e := range events {
+		keys = append(keys, e.Key())
+	}
+	if want := []string{"process:/bin/sh", "network:203.0.113.7:443"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("events %q, want %q", keys, want)
+	}
+
+	failing := filepath.Join(dir, "failing")
+	os.WriteFile(failing, []byte("#!/bin/sh\nexit 3\n"), 0o755)
+	if err := (&LSMCollector{BpftracePath: failing}).Run(context.Background(), events); err == nil {
+		t.Error("expected bpftrace failing to be reported")
+	}
+}
@@ -0,0 +1,241 @@
+package collect
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+// Runtime metric formats RuntimeCollector scrapes.
+const (
+	RuntimeExpvar     = "expvar"     // Go's expvar JSON, as served at /debug/vars
+	RuntimePrometheus = "prometheus" // the Prometheus text exposition format
+)
+
+// DefaultRuntimeInterval is how often RuntimeCollector scrapes by default.
+const DefaultRuntimeInterval = 15 * time.Second
+
+// DefaultRuntimeMetrics maps the runtime metrics RuntimeCollector reports
+// by default to the dimension each is baselined as, "resource:runtime."
+// and the dimension: the Prometheus metrics of Go, JVM and process
+// exporters, and expvar's memstats, whose nested fields are named with
+// dots. Applications publishing their goroutine count in expvar do so as
+// "goroutines".
+var DefaultRuntimeMetrics = map[string]string{
+	"go_goroutines":                 "goroutines",
+	"go_threads":                    "threads",
+	"go_memstats_heap_alloc_bytes":  "heap_bytes",
+	"process_resident_memory_bytes": "rss_bytes",
+	"process_open_fds":              "open_fds",
+	"jvm_threads_current":           "threads",
+	"jvm_threads_live_threads":      "threads",
+	"jvm_memory_bytes_used":         "heap_bytes",
+	"goroutines":                    "goroutines",
+	"memstats.HeapAlloc":            "heap_bytes",
+	"memstats.Sys":                  "sys_bytes",
+	"memstats.NumGC":                "gc_cycles",
+}
+
+// RuntimeCollector scrapes the runtime metrics applications expose, such
+// as goroutine and thread counts and heap size, from each target every
+// interval and emits a "resource" event per metric, so they are learned
+// and checked like the resource measurements of the cgroup source. Events
+// carry the metric as "runtime.<dimension>" and are labeled with the
+// target's host.
+type RuntimeCollector struct {
+	Targets []string // URLs to scrape
+	// Format is RuntimeExpvar or RuntimePrometheus; empty tells them
+	// apart by the response's content type.
+	Format string
+	// Metrics maps the metrics reported to their dimension; nil uses
+	// DefaultRuntimeMetrics. Metrics with several series, such as
+	// per-label ones, are summed.
+	Metrics  map[string]string
+	Interval time.Duration // defaults to DefaultRuntimeInterval
+	Client   *http.Client  // defaults to one timing out after the interval
+	// OnError, when set, is told about failed scrapes; the target is
+	// scraped again next interval either way.
+	OnError func(target string, err error)
+}
+
+// Run implements Collector.
+func (c *RuntimeCollector) Run(ctx context.Context, events chan<- detect.SystemEvent) error {
+	interval := c.Interval
+	if interval <= 0 {
+		interval = DefaultRuntimeInterval
+	}
+	client := c.Client
+	if client == nil {
+		client = &http.Client{Timeout: interval}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, target := range c.Targets {
+			values, err := c.scrape(ctx, client, target)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				if c.OnError != nil {
+					c.OnError(target, err)
+				}
+				continue
+			}
+			for _, event := range RuntimeEvents(values, target, time.Now()) {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// scrape fetches target and returns the values of its metrics by
+// dimension.
+func (c *RuntimeCollector) scrape(ctx context.Context, client *http.Client, target string) (map[string]float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/plain;version=0.0.4, application/json;q=0.9")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s: %s", target, resp.Status)
+	}
+	format := c.Format
+	if format == "" {
+		format = RuntimePrometheus
+		if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "application/json" {
+			format = RuntimeExpvar
+		}
+	}
+	metrics := c.Metrics
+	if metrics == nil {
+		metrics = DefaultRuntimeMetrics
+	}
+	body := io.LimitReader(resp.Body, 16<<20)
+	if format == RuntimeExpvar {
+		return ParseExpvar(body, metrics)
+	}
+	return ParsePrometheus(body, metrics)
+}
+
+// RuntimeEvents turns the values of a scrape of target into "resource"
+// events, in dimension order.
+func RuntimeEvents(values map[string]float64, target string, now time.Time) []detect.SystemEvent {
+	host := target
+	if u, err := url.Parse(target); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	dimensions := make([]string, 0, len(values))
+	for dimension := range values {
+		dimensions = append(dimensions, dimension)
+	}
+	sort.Strings(dimensions)
+	events := make([]detect.SystemEvent, len(dimensions))
+	for i, dimension := range dimensions {
+		events[i] = detect.SystemEvent{
+			Type:      "resource",
+			Timestamp: now,
+			Data:      map[string]interface{}{"metric": "runtime." + dimension, "value": values[dimension], "target": target},
+			Labels:    map[string]string{"target": host},
+		}
+	}
+	return events
+}
+
+// ParsePrometheus reads metrics in the Prometheus text exposition format
+// and returns the values of those in metrics by their dimension, summing
+// the series of a metric and the metrics of a dimension.
+func ParsePrometheus(r io.Reader, metrics map[string]string) (map[string]float64, error) {
+	values := make(map[string]float64)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		name, rest := line, ""
+		if i := strings.IndexAny(line, "{ \t"); i >= 0 {
+			name, rest = line[:i], line[i:]
+		}
+		dimension, ok := metrics[name]
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(rest, "{") {
+			// Label values may hold spaces and braces, but not an
+			// unescaped quote followed by the closing brace.
+			end := strings.LastIndex(rest, "}")
+			if end < 0 {
+				return nil, fmt.Errorf("malformed series %q", line)
+			}
+			rest = rest[end+1:]
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("series %q has no value", line)
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("series %q: %w", line, err)
+		}
+		values[dimension] += value
+	}
+	return values, scanner.Err()
+}
+
+// ParseExpvar reads Go's expvar JSON and returns the values of the numeric
+// variables in metrics by their dimension. Fields of object variables are
+// named with dots, such as "memstats.HeapAlloc".
+func ParseExpvar(r io.Reader, metrics map[string]string) (map[string]float64, error) {
+	var vars map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&vars); err != nil {
+		return nil, fmt.Errorf("decoding expvar: %w", err)
+	}
+	values := make(map[string]float64)
+	var walk func(prefix string, raw json.RawMessage)
+	walk = func(prefix string, raw json.RawMessage) {
+		var number float64
+		if err := json.Unmarshal(raw, &number); err == nil {
+			if dimension, ok := metrics[prefix]; ok {
+				values[dimension] += number
+			}
+			return
+		}
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &object); err == nil {
+			for name, field := range object {
+				walk(prefix+"."+name, field)
+			}
+		}
+	}
+	for name, raw := range vars {
+		walk(name, raw)
+	}
+	return values, nil
+}
@@ -0,0 +1,79 @@
+package collect
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+func TestRuntimeMetrics(t *testing.T) {
+	prometheus := `# HELP go_goroutines Number of goroutines that currently exist.
+# TYPE go_goroutines gauge
+go_goroutines 42
+go_threads 12
+go_memstats_heap_alloc_bytes 1.048576e+06
+jvm_threads_current{area="a b}c"} 3 1700000000000
+jvm_threads_current{area="d"} 4
+http_requests_total{code="200"} 1027
+`
+	values, err := ParsePrometheus(strings.NewReader(prometheus), DefaultRuntimeMetrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["goroutines"] != 42 || values["heap_bytes"] != 1<<20 || values["threads"] != 19 || len(values) != 3 {
+		t.Errorf("unexpected Prometheus values %v", values)
+	}
+
+	expvar := `{"cmdline": ["app"], "goroutines": 7, "memstats": {"HeapAlloc": 2048, "Sys": 4096, "BySize": [{"Size": 8}]}}`
+	values, err = ParseExpvar(strings.NewReader(expvar), DefaultRuntimeMetrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["goroutines"] != 7 || values["heap_bytes"] != 2048 || values["sys_bytes"] != 4096 || len(values) != 3 {
+		t.Errorf("unexpected expvar values %v", values)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/debug/vars" {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Write([]byte(expvar))
+			return
+		}
+		w.Write([]byte(prometheus))
+	}))
+	defer server.Close()
+
+	failed := make(chan string, 1)
+	c := &RuntimeCollector{
+		Targets:  []string{server.URL + "/metrics", server.URL + "/debug/vars", "http://127.0.0.1:1/metrics"},
+		Interval: time.Hour,
+		OnError:  func(target string, err error) { failed <- target },
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan detect.SystemEvent, 16)
+	done := make(chan error, 1)
+	go func() { done <- c.Run(ctx, events) }()
+	got := make(map[string]float64)
+	for i := 0; i < 6; i++ {
+		e := <-events
+		if e.Type != "resource" || e.Labels["target"] != strings.TrimPrefix(server.URL, "http://") {
+			t.Errorf("unexpected event %+v", e)
+		}
+		got[e.Data["target"].(string)+" "+e.ResourceKey()] = e.ResourceValue()
+	}
+	if target := <-failed; target != "http://127.0.0.1:1/metrics" {
+		t.Errorf("expected the unreachable target to be reported, got %s", target)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got[server.URL+"/metrics resource:runtime.threads"] != 19 || got[server.URL+"/debug/vars resource:runtime.goroutines"] != 7 {
+		t.Errorf("unexpected scraped values %v", got)
+	}
+}
@@ -0,0 +1,244 @@
+package collect
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/clock"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+// SamplingPolicy bounds how many events a collector forwards. Budget is the
+// event rate the pipeline can afford, which is what drives collector CPU
+// overhead. The daemon does not need one: given a CPU budget (see package
+// budget), it measures its CPU use and adapts its samplers' budgets to it
+// (see Sampler.Adapt).
+type SamplingPolicy struct {
+	// Budget is the number of events per second to forward. Zero disables
+	// sampling.
+	Budget float64
+	// Always lists sample keys recorded in full regardless of volume, such
+	// as "process:exec". Their volume still counts against the budget.
+	Always []string
+	// MaxRate caps the 1-in-N sampling rate. Defaults to 1000.
+	MaxRate int
+	// Window is how often rates are recomputed from observed volumes.
+	// Defaults to 10s; a negative Window leaves recomputing to Adapt.
+	Window time.Duration
+}
+
+// DefaultAlwaysSampled are the low-volume, high-value operations never
+// sampled by default.
+var DefaultAlwaysSampled = []string{"process:exec", "syscall:execve", "syscall:execveat", "network:connect"}
+
+// SampleKey identifies the stream an event is sampled in: its type and,
+// when present, its syscall or action, e.g. "syscall:read".
+func SampleKey(event detect.SystemEvent) string {
+	for _, field := range []string{"syscall", "action"} {
+		if name, ok := event.Data[field].(string); ok && name != "" {
+			return event.Type + ":" + name
+		}
+	}
+	return event.Type
+}
+
+// Sampler sub-samples high-volume event streams so the total forwarded
+// stays within a budget. Rates start at 1 (everything kept) and are
+// recomputed each window from the volumes seen in the previous one:
+// low-volume streams are kept in full and the remaining budget is split
+// evenly across the busiest streams. Kept events carry their SampleRate so
+// counts can be scaled back up with SystemEvent.Weight.
+type Sampler struct {
+	policy SamplingPolicy
+	always map[string]bool
+	clock  clock.Clock
+
+	mu          sync.Mutex
+	windowStart time.Time
+	counts      map[string]int // events seen per key this window
+	seen        map[string]int // events seen per key since its rate changed
+	rates       map[string]int
+}
+
+// NewSampler returns a sampler enforcing policy.
+func NewSampler(policy SamplingPolicy, c clock.Clock) *Sampler {
+	if policy.MaxRate <= 0 {
+		policy.MaxRate = 1000
+	}
+	if policy.Window == 0 {
+		policy.Window = 10 * time.Second
+	}
+	if c == nil {
+		c = clock.System
+	}
+	s := &Sampler{
+		policy: policy,
+		always: make(map[string]bool),
+		clock:  c,
+		counts: make(map[string]int),
+		seen:   make(map[string]int),
+		rates:  make(map[string]int),
+	}
+	for _, key := range policy.Always {
+		s.always[key] = true
+	}
+	s.windowStart = c.Now()
+	return s
+}
+
+// Sample reports whether event should be forwarded and, if so, returns it
+// with its SampleRate set. Selection is deterministic: every Nth event of a
+// stream is kept.
+func (s *Sampler) Sample(event detect.SystemEvent) (detect.SystemEvent, bool) {
+	if s.budget() <= 0 {
+		return event, true
+	}
+	rate, ok := s.Keep(SampleKey(event))
+	if ok && rate > 1 {
+		event.SampleRate = event.Weight() * rate
+	}
+	return event, ok
+}
+
+// Keep counts an event of the stream key and reports whether it is kept
+// and, if so, the 1-in-N rate it stands for.
+func (s *Sampler) Keep(key string) (rate int, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := s.clock.Now(); s.policy.Window > 0 && now.Sub(s.windowStart) >= s.policy.Window {
+		s.recompute(s.policy.Budget, now.Sub(s.windowStart))
+		s.windowStart = now
+	}
+	s.counts[key]++
+
+	rate = max(1, s.rates[key])
+	if rate == 1 {
+		return 1, true
+	}
+	s.seen[key]++
+	if s.seen[key]%rate != 0 {
+		return rate, false
+	}
+	return rate, true
+}
+
+// Adapt starts a new window now, with rates keeping about share of the
+// events counted since the last one (1 keeps all), rather than a fixed
+// budget. Callers measuring their own overhead, such as the daemon's
+// resource governor, call it as they measure, with a negative Window.
+func (s *Sampler) Adapt(share float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	elapsed := max(now.Sub(s.windowStart), time.Nanosecond)
+	budget := 0.0
+	if share < 1 {
+		total := 0
+		for _, n := range s.counts {
+			total += n
+		}
+		budget = share * float64(total) / elapsed.Seconds()
+	}
+	s.policy.Budget = budget
+	s.recompute(budget, elapsed)
+	s.windowStart = now
+}
+
+func (s *Sampler) budget() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.policy.Budget
+}
+
+// Rates returns the current 1-in-N rate of every sampled stream.
+func (s *Sampler) Rates() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rates := make(map[string]int, len(s.rates))
+	for key, rate := range s.rates {
+		if rate > 1 {
+			rates[key] = rate
+		}
+	}
+	return rates
+}
+
+// recompute sets rates for budget from the volumes counted over elapsed.
+// A window without events keeps the rates, and a budget of 0 keeps every
+// event.
+func (s *Sampler) recompute(budget float64, elapsed time.Duration) {
+	if len(s.counts) == 0 {
+		return
+	}
+	if budget <= 0 {
+		s.rates = make(map[string]int)
+		s.counts = make(map[string]int)
+		return
+	}
+	seconds := elapsed.Seconds()
+	remaining := budget
+	type stream struct {
+		key  string
+		rate float64 // events per second
+	}
+	var streams []stream
+	for key, count := range s.counts {
+		rate := float64(count) / seconds
+		if s.always[key] {
+			remaining -= rate
+			continue
+		}
+		streams = append(streams, stream{key, rate})
+	}
+	sort.Slice(streams, func(i, j int) bool { return streams[i].rate < streams[j].rate })
+
+	rates := make(map[string]int, len(streams))
+	for i, st := range streams {
+		share := math.Max(remaining, 0) / float64(len(streams)-i)
+		allowed := math.Min(st.rate, share)
+		remaining -= allowed
+		rate := s.policy.MaxRate
+		if allowed > 0 {
+			rate = int(math.Ceil(st.rate / allowed))
+		}
+		rates[st.key] = max(1, min(rate, s.policy.MaxRate))
+		if rates[st.key] != s.rates[st.key] {
+			s.seen[st.key] = 0
+		}
+	}
+	s.rates = rates
+	s.counts = make(map[string]int)
+}
+
+// Sampled wraps a collector so its events pass through sampler.
+func Sampled(c Collector, sampler *Sampler) Collector {
+	return &sampledCollector{inner: c, sampler: sampler}
+}
+
+type sampledCollector struct {
+	inner   Collector
+	sampler *Sampler
+}
+
+func (c *sampledCollector) Run(ctx context.Context, events chan<- detect.SystemEvent) error {
+	raw := make(chan detect.SystemEvent, 256)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.inner.Run(ctx, raw)
+		close(raw)
+	}()
+	for event := range raw {
+		event, ok := c.sampler.Sample(event)
+		if !ok {
+			continue
+		}
+		select {
+		case events <- event:
+		case <-ctx.Done():
+		}
+	}
+	return <-done
+}
@@ -0,0 +1,57 @@
+package collect
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/clock"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+func TestSamplerScalesHighVolumeStreams(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	sampler := NewSampler(SamplingPolicy{
+		Budget: 100,
+		Always: []string{"process:exec"},
+		Window: time.Second,
+	}, fake)
+
+	read := detect.SystemEvent{Type: "syscall", Data: map[string]interface{}{"syscall": "read"}}
+	exec := detect.SystemEvent{Type: "process", Data: map[string]interface{}{"action": "exec"}}
+
+	// Learn volumes over one window: 10000 reads/s and 20 execs/s.
+	for i := 0; i < 10000; i++ {
+		sampler.Sample(read)
+	}
+	for i := 0; i < 20; i++ {
+		sampler.Sample(exec)
+	}
+	fake.Advance(time.Second)
+
+	var kept []detect.SystemEvent
+	for i := 0; i < 10000; i++ {
+		if e, ok := sampler.Sample(read); ok {
+			kept = append(kept, e)
+		}
+	}
+	execs := 0
+	for i := 0; i < 20; i++ {
+		if e, ok := sampler.Sample(exec); ok {
+			kept = append(kept, e)
+			execs++
+		}
+	}
+
+	if execs != 20 {
+		t.Errorf("expected every exec kept, got %d", execs)
+	}
+	if len(kept) > 120 {
+		t.Errorf("expected about 100 events kept, got %d", len(kept))
+	}
+	if got := detect.TotalWeight(kept); got < 9900 || got > 10100 {
+		t.Errorf("expected scaled total near 10020, got %d", got)
+	}
+	if rate := sampler.Rates()["syscall:read"]; rate != 125 {
+		t.Errorf("expected read sampled 1 in 125, got %d", rate)
+	}
+}
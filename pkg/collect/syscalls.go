@@ -0,0 +1,168 @@
+package collect
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+// DefaultAggregatedSyscalls are the syscalls SyscallCollector counts by
+// default: the ones chatty workloads make thousands of times a second,
+// where streaming each call to userspace costs more than the workload.
+var DefaultAggregatedSyscalls = []string{
+	"read", "write", "readv", "writev", "pread64", "pwrite64",
+	"recvfrom", "sendto", "recvmsg", "sendmsg",
+	"openat", "close", "lseek", "mmap", "munmap",
+	"futex", "epoll_wait", "poll", "ppoll", "ioctl",
+}
+
+// DefaultAggregationInterval is how often SyscallCollector reads its counts
+// by default.
+const DefaultAggregationInterval = 10 * time.Second
+
+var syscallName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// SyscallScript returns the bpftrace program run by SyscallCollector: it
+// counts calls to the syscalls in a map keyed by process name, PID and
+// probe in the kernel, and prints and clears the map every interval.
+func SyscallScript(syscalls []string, interval time.Duration) (string, error) {
+	if len(syscalls) == 0 {
+		return "", fmt.Errorf("no syscalls to count")
+	}
+	if interval < 100*time.Millisecond {
+		return "", fmt.Errorf("aggregation interval %s is under 100ms", interval)
+	}
+	probes := make([]string, len(syscalls))
+	for i, name := range syscalls {
+		if !syscallName.MatchString(name) {
+			return "", fmt.Errorf("invalid syscall name %q", name)
+		}
+		probes[i] = "tracepoint:syscalls:sys_enter_" + name
+	}
+	return fmt.Sprintf(`
+%s
+{
+	@syscalls[comm, pid, probe] = count();
+}
+interval:ms:%d {
+	print(@syscalls);
+	clear(@syscalls);
+}
+`, strings.Join(probes, ",\n"), interval.Milliseconds()), nil
+}
+
+// SyscallCollector counts hot syscalls in an eBPF map, using bpftrace,
+// instead of streaming every call: every Interval it reports one "syscall"
+// event per process and syscall, whose SampleRate is the number of calls
+// it stands for. Learning and detection weigh it accordingly, at a small
+// fraction of the cost of a collector that reports each call.
+type SyscallCollector struct {
+	BpftracePath string        // defaults to "bpftrace"
+	Syscalls     []string      // defaults to DefaultAggregatedSyscalls
+	Interval     time.Duration // defaults to DefaultAggregationInterval
+	// IgnoreComms drops counts of these process names, e.g. the collector
+	// itself.
+	IgnoreComms []string
+}
+
+// Run implements Collector.
+func (c *SyscallCollector) Run(ctx context.Context, events chan<- detect.SystemEvent) error {
+	path := c.BpftracePath
+	if path == "" {
+		path = "bpftrace"
+	}
+	syscalls := c.Syscalls
+	if len(syscalls) == 0 {
+		syscalls = DefaultAggregatedSyscalls
+	}
+	interval := c.Interval
+	if interval == 0 {
+		interval = DefaultAggregationInterval
+	}
+	script, err := SyscallScript(syscalls, interval)
+	if err != nil {
+		return err
+	}
+	ignore := make(map[string]bool)
+	for _, comm := range append([]string{"bpftrace"}, c.IgnoreComms...) {
+		ignore[comm] = true
+	}
+
+	cmd := exec.CommandContext(ctx, path, "-e", script)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting %s: %w", path, err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		event, ok := ParseSyscallCount(scanner.Text(), time.Now(), interval)
+		if !ok || ignore[event.ProcessName] {
+			continue
+		}
+		select {
+		case events <- event:
+		case <-ctx.Done():
+			cmd.Wait()
+			return ctx.Err()
+		}
+	}
+
+	if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return ctx.Err()
+}
+
+// ParseSyscallCount parses one entry of the map SyscallScript prints, such
+// as "@syscalls[nginx, 1200, tracepoint:syscalls:sys_enter_read]: 4213",
+// into an event standing for the calls counted over the interval ending at
+// ts. Other lines are rejected.
+func ParseSyscallCount(line string, ts time.Time, interval time.Duration) (detect.SystemEvent, bool) {
+	key, value, ok := strings.Cut(strings.TrimPrefix(line, "@syscalls["), "]: ")
+	if !ok || len(key) == len(line) {
+		return detect.SystemEvent{}, false
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 1 {
+		return detect.SystemEvent{}, false
+	}
+	// The process name may itself contain ", ", so split from the right.
+	rest, probe, ok1 := cutLast(key, ", ")
+	comm, pidText, ok2 := cutLast(rest, ", ")
+	_, name, ok3 := strings.Cut(probe, ":sys_enter_")
+	pid, err := strconv.Atoi(pidText)
+	if !ok1 || !ok2 || !ok3 || err != nil {
+		return detect.SystemEvent{}, false
+	}
+	return detect.SystemEvent{
+		Type:        "syscall",
+		Timestamp:   ts,
+		ProcessName: comm,
+		PID:         pid,
+		SampleRate:  count,
+		Data: map[string]interface{}{
+			"source":     "ebpf",
+			"syscall":    name,
+			"aggregated": true,
+			"interval":   interval.String(),
+		},
+	}, true
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
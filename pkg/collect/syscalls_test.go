@@ -0,0 +1,64 @@
+package collect
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+func TestSyscallCollector(t *testing.T) {
+	script, err := SyscallScript([]string{"read", "futex"}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"tracepoint:syscalls:sys_enter_read,\ntracepoint:syscalls:sys_enter_futex", "interval:ms:5000", "clear(@syscalls)"} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script lacks %q:\n%s", want, script)
+		}
+	}
+	for _, bad := range [][]string{nil, {"read; system(\"id\")"}} {
+		if _, err := SyscallScript(bad, time.Second); err == nil {
+			t.Errorf("SyscallScript(%q) succeeded", bad)
+		}
+	}
+	if _, err := SyscallScript([]string{"read"}, time.Millisecond); err == nil {
+		t.Error("SyscallScript accepted a 1ms interval")
+	}
+
+	// A stand-in for bpftrace printing one interval's counts.
+	bpftrace := filepath.Join(t.TempDir(), "bpftrace")
+	os.WriteFile(bpftrace, []byte(`#!/bin/sh
+echo "Attaching 3 probes..."
+echo
+echo "@syscalls[nginx, 1200, tracepoint:syscalls:sys_enter_read]: 4213"
+echo "@syscalls[Web Content, 1, 2, tracepoint:syscalls:sys_enter_futex]: 17"
+echo "@syscalls[bpftrace, 99, tracepoint:syscalls:sys_enter_read]: 8"
+echo "@syscalls[nginx, x, tracepoint:syscalls:sys_enter_read]: 1"
+`), 0o755)
+	events := make(chan detect.SystemEvent, 10)
+	c := &SyscallCollector{BpftracePath: bpftrace, Interval: time.Second}
+	if err := c.Run(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+	close(events)
+	var got []detect.SystemEvent
+	for e := range events {
+		got = append(got, e)
+	}
+	if len(got) != 2 {
+		t.Fatalf("events = %+v", got)
+	}
+	read := got[0]
+	if read.Type != "syscall" || read.ProcessName != "nginx" || read.PID != 1200 || read.Weight() != 4213 ||
+		read.Data["syscall"] != "read" || read.Data["interval"] != "1s" || SampleKey(read) != "syscall:read" {
+		t.Errorf("read = %+v", read)
+	}
+	if futex := got[1]; futex.ProcessName != "Web Content, 1" || futex.PID != 2 || futex.Data["syscall"] != "futex" {
+		t.Errorf("futex = %+v", futex)
+	}
+}
@@ -0,0 +1,465 @@
+// Package config loads the runtimebase daemon configuration.
+package config
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/category"
+	"github.com/hallucinaut/runtimebase/pkg/enrich"
+	"github.com/hallucinaut/runtimebase/pkg/logging"
+	"github.com/hallucinaut/runtimebase/pkg/pipeline"
+	"github.com/hallucinaut/runtimebase/pkg/secrets"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+	"github.com/hallucinaut/runtimebase/pkg/storage"
+)
+
+// Config is the daemon configuration file.
+type Config struct {
+	DataDir     string          `yaml:"data_dir"`
+	MetricsAddr string          `yaml:"metrics_addr"`
+	Retention   RetentionConfig `yaml:"retention"`
+	API         APIConfig       `yaml:"api"`
+	// Severity replaces the built-in LOW/MEDIUM/HIGH/CRITICAL levels.
+	Severity []severity.Level `yaml:"severity"`
+	// Labels limits label segmentation of new baselines' statistics.
+	Labels *baseline.LabelPolicy `yaml:"labels"`
+	// Log selects the log level and format.
+	Log logging.Config `yaml:"log"`
+	// Encryption enables encryption of stored baselines.
+	Encryption EncryptionConfig `yaml:"encryption"`
+	// Enrich configures network peer reputation enrichment of anomalies.
+	Enrich EnrichConfig `yaml:"enrich"`
+	// Pipelines are the ingestion pipelines the daemon runs.
+	Pipelines []pipeline.Spec `yaml:"pipelines"`
+	// Remediation attaches runbooks, owners and automated responses to
+	// anomalies.
+	Remediation baseline.Remediations `yaml:"remediation"`
+	// Suppress silences anomalies of known, accepted behavior.
+	Suppress baseline.Suppressions `yaml:"suppress"`
+	// Categories adds custom categories to the built-in ontology. Parents
+	// must be defined, built in or here, before their children are used.
+	Categories []category.Category `yaml:"categories"`
+	// Learn lists files of observations the daemon learns incrementally.
+	Learn []LearnFileConfig `yaml:"learn"`
+	// Limits caps the daemon's own CPU and memory use.
+	Limits LimitsConfig `yaml:"limits"`
+
+	// Raw is the file content the configuration was loaded from.
+	Raw []byte `yaml:"-"`
+}
+
+// LearnFileConfig names a JSON lines file of observations the daemon
+// learns into a baseline, reading only what was appended since the last
+// pass (see baseline.Learner.LearnFromFile).
+type LearnFileConfig struct {
+	Baseline  string   `yaml:"baseline"`
+	Path      string   `yaml:"path"`
+	Namespace string   `yaml:"namespace"`
+	Interval  Duration `yaml:"interval"` // default 1m
+}
+
+// LimitsConfig is the daemon's resource budget (see package budget). Over
+// the CPU budget pipelines sample events, over the memory budget they drop
+// them, and both are counted in runtimebase_budget_dropped_total.
+type LimitsConfig struct {
+	// CPU is the budget in cores, e.g. 0.5; it also caps GOMAXPROCS.
+	CPU float64 `yaml:"cpu"`
+	// Memory is the budget, e.g. "512MiB".
+	Memory Size `yaml:"memory"`
+}
+
+// EncryptionConfig names the source of the key baselines are encrypted
+// with at rest. Without any field set, the key is read from
+// $RUNTIMEBASE_ENCRYPTION_KEY when it is set.
+type EncryptionConfig struct {
+	// Key is a secret reference, e.g. "vault:secret/data/runtimebase#key"
+	// or "awskms:/etc/runtimebase/key.enc".
+	Key string `yaml:"key"`
+	// KeyEnv names an environment variable holding a base64 or hex key.
+	KeyEnv string `yaml:"key_env"`
+	// KeyCommand is a KMS plugin command printing the key on stdout.
+	KeyCommand []string `yaml:"key_command"`
+}
+
+// Provider returns the configured key source, or nil when baselines are
+// stored in plaintext.
+func (e EncryptionConfig) Provider() storage.KeyProvider {
+	switch {
+	case e.Key != "":
+		return storage.SecretKey(e.Key)
+	case len(e.KeyCommand) > 0:
+		return storage.CommandKey(e.KeyCommand)
+	case e.KeyEnv != "":
+		return storage.EnvKey(e.KeyEnv)
+	case os.Getenv(storage.DefaultKeyEnv) != "":
+		return storage.EnvKey(storage.DefaultKeyEnv)
+	}
+	return nil
+}
+
+// EnrichConfig names the local databases and blocklists anomalies are
+// enriched from.
+type EnrichConfig struct {
+	// GeoIPDB and ASNDB are MaxMind DB files, e.g. GeoLite2-City.mmdb and
+	// GeoLite2-ASN.mmdb.
+	GeoIPDB    string            `yaml:"geoip_db"`
+	ASNDB      string            `yaml:"asn_db"`
+	Blocklists []BlocklistConfig `yaml:"blocklists"`
+}
+
+// BlocklistConfig is a file of IP addresses, CIDR ranges and domains.
+type BlocklistConfig struct {
+	Name string `yaml:"name"` // defaults to the file name
+	Path string `yaml:"path"`
+}
+
+// Enricher opens the configured databases and blocklists, or returns nil
+// when enrichment is not configured.
+func (e EnrichConfig) Enricher() (*enrich.Enricher, error) {
+	if e.GeoIPDB == "" && e.ASNDB == "" && len(e.Blocklists) == 0 {
+		return nil, nil
+	}
+	var err error
+	enricher := &enrich.Enricher{}
+	if e.GeoIPDB != "" {
+		if enricher.GeoIP, err = enrich.OpenMMDB(e.GeoIPDB); err != nil {
+			return nil, err
+		}
+	}
+	if e.ASNDB != "" {
+		if enricher.ASN, err = enrich.OpenMMDB(e.ASNDB); err != nil {
+			return nil, err
+		}
+	}
+	for _, bl := range e.Blocklists {
+		list, err := enrich.LoadBlocklist(bl.Name, bl.Path)
+		if err != nil {
+			return nil, err
+		}
+		enricher.Blocklists = append(enricher.Blocklists, list)
+	}
+	return enricher, nil
+}
+
+// APIConfig configures the HTTP API server.
+type APIConfig struct {
+	Addr   string        `yaml:"addr"`
+	Tokens []TokenConfig `yaml:"tokens"`
+	// TLSCert and TLSKey are secret references to the PEM certificate
+	// chain and private key, e.g. "file:/run/secrets/tls.crt". When set,
+	// the API is served over HTTPS.
+	TLSCert string `yaml:"tls_cert"`
+	TLSKey  string `yaml:"tls_key"`
+}
+
+// TokenConfig grants an API token a role on a set of baselines.
+type TokenConfig struct {
+	Name string `yaml:"name"`
+	// TokenSHA256 is the hex SHA-256 of the bearer token, so the
+	// configuration never holds the token itself.
+	TokenSHA256 string `yaml:"token_sha256"`
+	// Role is one of "read-only", "ingest-only" or "admin".
+	Role string `yaml:"role"`
+	// Baselines lists glob patterns of baseline names the token may access.
+	// Empty means all baselines.
+	Baselines []string `yaml:"baselines"`
+	// Namespaces lists glob patterns of the namespaces the token may
+	// access, "*" for all. Empty means only the default namespace.
+	Namespaces []string `yaml:"namespaces"`
+}
+
+// RetentionConfig controls how long baseline data is kept.
+type RetentionConfig struct {
+	// Interval is how often the janitor runs.
+	Interval Duration `yaml:"interval"`
+	// Default applies to every baseline without an override.
+	Default RetentionPolicy `yaml:"default"`
+	// Baselines overrides the default per baseline name. Zero fields
+	// inherit from Default.
+	Baselines map[string]RetentionPolicy `yaml:"baselines"`
+}
+
+// RetentionPolicy limits stored data for one baseline. Zero values disable
+// the corresponding limit.
+type RetentionPolicy struct {
+	MaxAnomalyHistory int      `yaml:"max_anomaly_history"`
+	MaxStatAge        Duration `yaml:"max_stat_age"`
+	ArchiveAfterIdle  Duration `yaml:"archive_after_idle"`
+	// RawStats and HourlyStats are how long stat history is kept at full
+	// and at hourly resolution before it is downsampled to hourly and
+	// daily points; zero uses 24h and 30d. History is never downsampled
+	// to nothing.
+	RawStats    Duration `yaml:"raw_stats"`
+	HourlyStats Duration `yaml:"hourly_stats"`
+}
+
+// PolicyFor returns the effective retention policy for a baseline.
+func (r RetentionConfig) PolicyFor(name string) RetentionPolicy {
+	policy := r.Default
+	override, ok := r.Baselines[name]
+	if !ok {
+		return policy
+	}
+	if override.MaxAnomalyHistory != 0 {
+		policy.MaxAnomalyHistory = override.MaxAnomalyHistory
+	}
+	if override.MaxStatAge != 0 {
+		policy.MaxStatAge = override.MaxStatAge
+	}
+	if override.ArchiveAfterIdle != 0 {
+		policy.ArchiveAfterIdle = override.ArchiveAfterIdle
+	}
+	if override.RawStats != 0 {
+		policy.RawStats = override.RawStats
+	}
+	if override.HourlyStats != 0 {
+		policy.HourlyStats = override.HourlyStats
+	}
+	return policy
+}
+
+// Default returns the configuration used when no file is given.
+func Default() *Config {
+	return &Config{
+		Retention: RetentionConfig{
+			Interval: Duration(time.Hour),
+		},
+	}
+}
+
+// Load reads a YAML configuration file on top of the defaults.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	cfg.Raw = data
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate checks the configuration for invalid values.
+func (c *Config) Validate() error {
+	if c.Retention.Interval < 0 {
+		return fmt.Errorf("retention.interval must not be negative")
+	}
+	policies := map[string]RetentionPolicy{"default": c.Retention.Default}
+	for name, policy := range c.Retention.Baselines {
+		policies["baselines."+name] = policy
+	}
+	for name, policy := range policies {
+		if policy.MaxAnomalyHistory < 0 || policy.MaxStatAge < 0 || policy.ArchiveAfterIdle < 0 {
+			return fmt.Errorf("retention.%s: limits must not be negative", name)
+		}
+	}
+	sources := 0
+	for _, set := range []bool{c.Encryption.Key != "", c.Encryption.KeyEnv != "", len(c.Encryption.KeyCommand) > 0} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return fmt.Errorf("encryption: key, key_env and key_command are mutually exclusive")
+	}
+	if c.Encryption.Key != "" {
+		if _, _, err := secrets.Parse(c.Encryption.Key); err != nil {
+			return fmt.Errorf("encryption.key: %w", err)
+		}
+	}
+	for i, lf := range c.Learn {
+		if lf.Baseline == "" || lf.Path == "" {
+			return fmt.Errorf("learn[%d]: baseline and path are required", i)
+		}
+		if lf.Interval < 0 {
+			return fmt.Errorf("learn[%d]: interval must not be negative", i)
+		}
+	}
+	if c.Limits.CPU < 0 || c.Limits.Memory < 0 {
+		return fmt.Errorf("limits: budgets must not be negative")
+	}
+	if (c.API.TLSCert == "") != (c.API.TLSKey == "") {
+		return fmt.Errorf("api: tls_cert and tls_key must be set together")
+	}
+	for field, ref := range map[string]string{"tls_cert": c.API.TLSCert, "tls_key": c.API.TLSKey} {
+		if ref == "" {
+			continue
+		}
+		if _, _, err := secrets.Parse(ref); err != nil {
+			return fmt.Errorf("api.%s: %w", field, err)
+		}
+	}
+	for i, bl := range c.Enrich.Blocklists {
+		if bl.Path == "" {
+			return fmt.Errorf("enrich.blocklists[%d]: path is required", i)
+		}
+	}
+	for _, spec := range c.Pipelines {
+		if err := spec.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := c.Log.Validate(); err != nil {
+		return fmt.Errorf("log: %w", err)
+	}
+	taxonomy := severity.Default()
+	if len(c.Severity) > 0 {
+		var err error
+		if taxonomy, err = severity.New(c.Severity); err != nil {
+			return err
+		}
+	}
+	ontology, err := category.New(c.Categories)
+	if err != nil {
+		return fmt.Errorf("categories: %w", err)
+	}
+	for i, rule := range c.Remediation {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("remediation[%d]: %w", i, err)
+		}
+		if _, ok := taxonomy.Lookup(rule.MinSeverity); rule.MinSeverity != "" && !ok {
+			return fmt.Errorf("remediation[%d]: unknown min_severity %q", i, rule.MinSeverity)
+		}
+		if rule.Category != "" {
+			if err := ontology.ValidatePattern(rule.Category); err != nil {
+				return fmt.Errorf("remediation[%d]: %w", i, err)
+			}
+		}
+	}
+	for i, s := range c.Suppress {
+		if err := s.Validate(); err != nil {
+			return fmt.Errorf("suppress[%d]: %w", i, err)
+		}
+		if _, ok := taxonomy.Lookup(s.MaxSeverity); s.MaxSeverity != "" && !ok {
+			return fmt.Errorf("suppress[%d]: unknown max_severity %q", i, s.MaxSeverity)
+		}
+		if s.Category != "" {
+			if err := ontology.ValidatePattern(s.Category); err != nil {
+				return fmt.Errorf("suppress[%d]: %w", i, err)
+			}
+		}
+	}
+	for i, token := range c.API.Tokens {
+		if token.TokenSHA256 == "" {
+			return fmt.Errorf("api.tokens[%d]: token_sha256 is required", i)
+		}
+		switch token.Role {
+		case "read-only", "ingest-only", "admin":
+		default:
+			return fmt.Errorf("api.tokens[%d]: unknown role %q", i, token.Role)
+		}
+	}
+	return nil
+}
+
+// Ontology returns the built-in categories with the configured custom
+// ones, or only the built-in ones if those are invalid.
+func (c *Config) Ontology() *category.Ontology {
+	o, err := category.New(c.Categories)
+	if err != nil {
+		return category.Default()
+	}
+	return o
+}
+
+// Taxonomy returns the configured severity taxonomy, or the default.
+func (c *Config) Taxonomy() *severity.Taxonomy {
+	if len(c.Severity) == 0 {
+		return severity.Default()
+	}
+	t, err := severity.New(c.Severity)
+	if err != nil {
+		return severity.Default()
+	}
+	return t
+}
+
+// Duration is a time.Duration that also accepts a "d" (days) suffix in YAML,
+// e.g. "30d".
+type Duration time.Duration
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+	parsed, err := ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+// ParseDuration parses a Go duration string, or a whole number of days
+// followed by "d".
+func ParseDuration(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Size is a number of bytes that also accepts a unit in YAML, e.g. "512MiB"
+// or "2G". Units are powers of 1024 with or without the "i".
+type Size int64
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (s *Size) UnmarshalYAML(node *yaml.Node) error {
+	var text string
+	if err := node.Decode(&text); err != nil {
+		return err
+	}
+	parsed, err := ParseSize(text)
+	if err != nil {
+		return err
+	}
+	*s = Size(parsed)
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (s Size) MarshalYAML() (interface{}, error) {
+	return int64(s), nil
+}
+
+// ParseSize parses a number of bytes with an optional unit: K, M, G or T,
+// optionally followed by "i" and "B".
+func ParseSize(s string) (int64, error) {
+	text := strings.TrimSuffix(strings.TrimSpace(s), "B")
+	text = strings.TrimSuffix(text, "i")
+	shift := 0
+	if n := len(text); n > 0 {
+		if i := strings.IndexByte("KMGT", text[n-1]); i >= 0 {
+			shift, text = 10*(i+1), text[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64>>shift {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n << shift, nil
+}
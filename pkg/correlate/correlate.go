@@ -0,0 +1,267 @@
+// Package correlate detects known-bad behavior by correlating events with
+// the process tree they happen in. Unlike baselines, which flag what an
+// application has not done before, its rules fire on indicators that are
+// suspicious anywhere, such as a shell whose standard input is a socket.
+//
+// Rules are grouped in packs, registered by name, and run by an Engine.
+package correlate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/proctree"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// Context is what a rule sees besides the event: the process tree, already
+// updated with the event, where to read live process state, and the
+// baseline of the application the event belongs to.
+type Context struct {
+	Tree     *proctree.Tree
+	ProcRoot string             // empty disables reading /proc
+	Baseline *baseline.Baseline // nil when the event has none
+}
+
+// Rule recognizes one indicator. Match returns the evidence for an event
+// that shows it.
+type Rule struct {
+	Name        string
+	Severity    string
+	Description string
+	Match       func(c *Context, e detect.SystemEvent) (evidence string, ok bool)
+}
+
+// Pack is a named set of rules. Rules returns fresh rules for each engine,
+// so rules that keep state across events do not share it. Optional packs
+// run only when asked for by name.
+type Pack struct {
+	Name        string
+	Description string
+	Optional    bool
+	Rules       func() []Rule
+}
+
+var (
+	mu    sync.RWMutex
+	packs = make(map[string]Pack)
+)
+
+// Register makes a pack available under its name, replacing any pack
+// already registered with it.
+func Register(p Pack) {
+	mu.Lock()
+	defer mu.Unlock()
+	packs[p.Name] = p
+}
+
+// Packs returns the names of the registered packs, sorted.
+func Packs() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(packs))
+	for name := range packs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the packs registered under names, or every registered
+// pack that is not optional when names is empty.
+func Lookup(names ...string) ([]Pack, error) {
+	all := len(names) == 0
+	if all {
+		names = Packs()
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	found := make([]Pack, 0, len(names))
+	for _, name := range names {
+		p, ok := packs[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown pack %q", name)
+		}
+		if all && p.Optional {
+			continue
+		}
+		found = append(found, p)
+	}
+	return found, nil
+}
+
+// Engine runs the rules of its packs over a stream of events.
+type Engine struct {
+	ctx    Context
+	rules  []Rule
+	now    func() time.Time
+	timing func(rule string, elapsed time.Duration)
+}
+
+// New returns an engine running the rules of packs. It keeps its own
+// process tree, which falls back to /proc for processes it has not seen
+// start.
+func New(packs ...Pack) *Engine {
+	e := &Engine{ctx: Context{Tree: proctree.New(), ProcRoot: "/proc"}, now: time.Now}
+	for _, p := range packs {
+		e.rules = append(e.rules, p.Rules()...)
+	}
+	return e
+}
+
+// WithTree makes the engine share a process tree, and its /proc fallback,
+// with other consumers.
+func (e *Engine) WithTree(t *proctree.Tree) *Engine {
+	e.ctx.Tree = t
+	e.ctx.ProcRoot = t.ProcRoot
+	return e
+}
+
+// WithTiming makes the engine report how long each rule takes to match
+// each event, so expensive rules can be found.
+func (e *Engine) WithTiming(observe func(rule string, elapsed time.Duration)) *Engine {
+	e.timing = observe
+	return e
+}
+
+// Observe updates the process tree with the event and returns an anomaly
+// for every rule it matches, with the lineage of the offending process. b
+// is the baseline the event belongs to, for rules that compare against
+// learned behavior; it may be nil.
+func (e *Engine) Observe(event detect.SystemEvent, b *baseline.Baseline) []baseline.Anomaly {
+	e.ctx.Tree.Observe(event)
+	e.ctx.Baseline = b
+	var anomalies []baseline.Anomaly
+	for _, rule := range e.rules {
+		var start time.Time
+		if e.timing != nil {
+			start = time.Now()
+		}
+		evidence, ok := rule.Match(&e.ctx, event)
+		if e.timing != nil {
+			e.timing(rule.Name, time.Since(start))
+		}
+		if !ok {
+			continue
+		}
+		at := event.Timestamp
+		if at.IsZero() {
+			at = e.now()
+		}
+		level := severity.Label(rule.Severity)
+		anomalies = append(anomalies, baseline.Anomaly{
+			Type:        rule.Name,
+			Description: rule.Description,
+			Severity:    level,
+			Evidence:    evidence,
+			Confidence:  0.9,
+			Timestamp:   at,
+			RiskLevel:   level,
+			PID:         event.PID,
+			Process:     event.ProcessName,
+		})
+	}
+	if traceID, spanID := detect.TraceContext(event); traceID != "" {
+		for i := range anomalies {
+			anomalies[i].TraceID, anomalies[i].SpanID = traceID, spanID
+		}
+	}
+	e.ctx.Tree.Annotate(anomalies)
+	return anomalies
+}
+
+// Cmdline returns the command line of the process an event is about, from
+// the event or, failing that, the process tree.
+func (c *Context) Cmdline(e detect.SystemEvent) string {
+	if cmdline, _ := e.Data["cmdline"].(string); cmdline != "" {
+		return cmdline
+	}
+	if e.PID > 0 {
+		if lineage := c.Tree.Lineage(e.PID); len(lineage) > 0 {
+			return lineage[0].Cmdline
+		}
+	}
+	return ""
+}
+
+// FD returns what file descriptor fd of the event's process refers to,
+// such as "socket:[4242]" or "/dev/pts/0": from the event's "fd<N>" field
+// (or "stdin", "stdout", "stderr" for 0 to 2) or, failing that, /proc. It
+// returns "" when unknown.
+func (c *Context) FD(e detect.SystemEvent, fd int) string {
+	keys := []string{"fd" + strconv.Itoa(fd)}
+	if fd < 3 {
+		keys = append(keys, []string{"stdin", "stdout", "stderr"}[fd])
+	}
+	for _, key := range keys {
+		if target, _ := e.Data[key].(string); target != "" {
+			return target
+		}
+	}
+	if c.ProcRoot == "" || e.PID <= 0 {
+		return ""
+	}
+	target, _ := os.Readlink(filepath.Join(c.ProcRoot, strconv.Itoa(e.PID), "fd", strconv.Itoa(fd)))
+	return target
+}
+
+// Exec returns the program an exec event started, by base name, and
+// whether the event is one.
+func Exec(e detect.SystemEvent) (string, bool) {
+	if e.Type != "process" {
+		return "", false
+	}
+	if action, _ := e.Data["action"].(string); action != "" && action != "exec" {
+		return "", false
+	}
+	name := e.ProcessName
+	if e.Path != "" {
+		name = e.Path
+	}
+	if name == "" {
+		return "", false
+	}
+	return filepath.Base(name), true
+}
+
+// Ancestor returns the nearest ancestor of the event's process whose
+// program base name is in names.
+func (c *Context) Ancestor(e detect.SystemEvent, names map[string]bool) (baseline.Process, bool) {
+	if e.PID <= 0 {
+		return baseline.Process{}, false
+	}
+	lineage := c.Tree.Lineage(e.PID)
+	for _, p := range lineage[min(1, len(lineage)):] {
+		if names[strings.TrimSuffix(filepath.Base(p.Exe), " (deleted)")] {
+			return p, true
+		}
+	}
+	return baseline.Process{}, false
+}
+
+// Learned reports whether the baseline has observed the operation key, such
+// as "process:/usr/bin/curl", under any labels. Without a baseline nothing
+// is learned.
+func (c *Context) Learned(key string) bool {
+	if c.Baseline == nil {
+		return false
+	}
+	if stat, ok := c.Baseline.Stats[key]; ok {
+		return stat.SampleCount > 0
+	}
+	for k, stat := range c.Baseline.Stats {
+		category, pattern, _ := baseline.ParseStatKey(k)
+		if category+":"+pattern == key && stat.SampleCount > 0 {
+			return true
+		}
+	}
+	return false
+}
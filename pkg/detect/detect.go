@@ -3,13 +3,33 @@ package detect
 
 import (
 	"fmt"
+	"log/slog"
+	"math"
 	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/clock"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
 )
 
 // Detector detects runtime anomalies.
 type Detector struct {
-	patterns []*Pattern
+	patterns  []*Pattern
+	threshold int
+	clock     clock.Clock
+	logger    *slog.Logger
+
+	// Learned thresholds; see WithBaselineThresholds.
+	source     func() *baseline.Baseline
+	percentile float64
+	margin     float64
+	mu         sync.Mutex
+	learnedAt  time.Time      // UpdatedAt of the baseline thresholds were derived from
+	learned    map[string]int // category → threshold
 }
 
 // Pattern defines a detection pattern.
@@ -19,6 +39,7 @@ type Pattern struct {
 	Category    string
 	Severity    string
 	Description string
+	Remediation baseline.Remediation
 }
 
 // SystemEvent represents a system event for analysis.
@@ -28,20 +49,283 @@ type SystemEvent struct {
 	Data        map[string]interface{}
 	ProcessName string
 	PID         int
+	Path        string            // file or executable path, when applicable
+	Hash        string            // SHA-256 of the file content, when known
+	Labels      map[string]string // low-cardinality segmentation, e.g. env, region, tenant
+	SampleRate  int               // events this one stands for when sampled 1 in N; 0 means 1
+	Bytes       int64             // bytes read, written or transferred, when known
+}
+
+// Weight returns the number of events e represents, scaling sampled events
+// back up to their estimated true volume.
+func (e SystemEvent) Weight() int {
+	if e.SampleRate > 1 {
+		return e.SampleRate
+	}
+	return 1
+}
+
+// Key returns the baseline statistics key for the operation e performs,
+// e.g. "syscall:openat", "network:10.0.0.9:443" or "process:/usr/bin/curl",
+// or "" when the event does not identify one. Network events of data
+// sent ("action" "send") only report the volume of a connection already
+// counted, and have no key. Events of other types reporting a pattern in
+// Data["pattern"], such as observations applications report themselves,
+// are keyed "type:pattern".
+func (e SystemEvent) Key() string {
+	switch e.Type {
+	case "syscall":
+		if name, _ := e.Data["syscall"].(string); name != "" {
+			return "syscall:" + name
+		}
+	case "network":
+		if action, _ := e.Data["action"].(string); action == "send" {
+			break
+		}
+		if dest, _ := e.Data["destination"].(string); dest != "" {
+			return "network:" + dest
+		}
+	case "process":
+		if e.Path != "" {
+			return "process:" + e.Path
+		}
+	default:
+		if pattern, _ := e.Data["pattern"].(string); pattern != "" && e.Type != "" {
+			return e.Type + ":" + pattern
+		}
+	}
+	return ""
+}
+
+// VolumeKey returns the key byte volumes of e are baselined under: the file
+// path ("file:/var/lib/db"), the network destination
+// ("network:10.0.0.9:443") or the path an HTTP request served, without its
+// query ("http:/api/export"), or "" when e has none.
+func (e SystemEvent) VolumeKey() string {
+	switch e.Type {
+	case "network":
+		if dest, _ := e.Data["destination"].(string); dest != "" {
+			return "network:" + dest
+		}
+	case "file", "syscall":
+		if e.Path != "" {
+			return "file:" + e.Path
+		}
+	case "http":
+		if path, _, _ := strings.Cut(e.Path, "?"); path != "" {
+			return "http:" + path
+		}
+	}
+	return ""
+}
+
+// IntervalCounts returns the mean count of each key per interval of event
+// time, the unit baselines learn counts in, over the intervals the events
+// span. A key missing from an interval counts 0 there. Events without a
+// timestamp fall in the first interval.
+func IntervalCounts(events []SystemEvent, interval time.Duration) map[string]float64 {
+	counts := make(map[string]float64)
+	var keyed []SystemEvent
+	for _, e := range events {
+		key := e.Key()
+		if key == "" {
+			continue
+		}
+		counts[key] += float64(e.Weight())
+		keyed = append(keyed, e)
+	}
+	intervals := Intervals(keyed, interval)
+	for key := range counts {
+		counts[key] /= intervals
+	}
+	return counts
+}
+
+// Intervals returns the number of intervals of event time the events
+// span, counting partial ones, for turning their counts into the per
+// interval rates baselines learn. Events without a timestamp fall in the
+// first interval; an interval <= 0 returns 1, leaving counts as they are.
+func Intervals(events []SystemEvent, interval time.Duration) float64 {
+	if interval <= 0 {
+		return 1
+	}
+	var first, last time.Time
+	for _, e := range events {
+		if e.Timestamp.IsZero() {
+			continue
+		}
+		if first.IsZero() || e.Timestamp.Before(first) {
+			first = e.Timestamp
+		}
+		if e.Timestamp.After(last) {
+			last = e.Timestamp
+		}
+	}
+	return float64(last.Sub(first)/interval + 1)
+}
+
+// LastSeen returns when each key was last seen and the time span of the
+// events, for checking silences with baseline.Silences. Events without a
+// timestamp are skipped.
+func LastSeen(events []SystemEvent) (lastSeen map[string]time.Time, first, last time.Time) {
+	lastSeen = make(map[string]time.Time)
+	for _, e := range events {
+		if e.Timestamp.IsZero() {
+			continue
+		}
+		if first.IsZero() || e.Timestamp.Before(first) {
+			first = e.Timestamp
+		}
+		if e.Timestamp.After(last) {
+			last = e.Timestamp
+		}
+		if key := e.Key(); key != "" && e.Timestamp.After(lastSeen[key]) {
+			lastSeen[key] = e.Timestamp
+		}
+	}
+	return lastSeen, first, last
+}
+
+// Volumes sums the bytes of events by VolumeKey, scaling sampled events
+// back up. Events without a byte count or volume key are skipped.
+func Volumes(events []SystemEvent) map[string]int64 {
+	volumes := make(map[string]int64)
+	for _, event := range events {
+		if event.Bytes <= 0 {
+			continue
+		}
+		if key := event.VolumeKey(); key != "" {
+			volumes[key] += event.Bytes * int64(event.Weight())
+		}
+	}
+	return volumes
+}
+
+// IntervalVolumes sums the bytes of events by VolumeKey per interval of
+// event time, as Volumes does over all of them, for comparing with volumes
+// learned per interval. The sums are in time order; intervals without
+// traffic are left out. Events without a timestamp fall in the first
+// interval, and an interval <= 0 sums all events as one.
+func IntervalVolumes(events []SystemEvent, interval time.Duration) []map[string]int64 {
+	var first time.Time
+	for _, e := range events {
+		if !e.Timestamp.IsZero() && (first.IsZero() || e.Timestamp.Before(first)) {
+			first = e.Timestamp
+		}
+	}
+	if interval > 0 {
+		first = first.Truncate(interval)
+	}
+	buckets := make(map[time.Time][]SystemEvent)
+	for _, e := range events {
+		start := first
+		if !e.Timestamp.IsZero() && interval > 0 {
+			start = e.Timestamp.Truncate(interval)
+		}
+		buckets[start] = append(buckets[start], e)
+	}
+	starts := make([]time.Time, 0, len(buckets))
+	for start := range buckets {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+	var volumes []map[string]int64
+	for _, start := range starts {
+		if v := Volumes(buckets[start]); len(v) > 0 {
+			volumes = append(volumes, v)
+		}
+	}
+	return volumes
+}
+
+// ResourceKey returns the key the measurement carried by a "resource"
+// event is baselined under, e.g. "resource:cpu.pressure", or "" for other
+// events.
+func (e SystemEvent) ResourceKey() string {
+	if e.Type != "resource" {
+		return ""
+	}
+	if metric, _ := e.Data["metric"].(string); metric != "" {
+		return "resource:" + metric
+	}
+	return ""
+}
+
+// ResourceValue returns the measurement a "resource" event carries.
+func (e SystemEvent) ResourceValue() float64 {
+	switch v := e.Data["value"].(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return 0
+}
+
+// Resources returns the peak of each resource measurement in events, keyed
+// by ResourceKey.
+func Resources(events []SystemEvent) map[string]float64 {
+	peaks := make(map[string]float64)
+	for _, event := range events {
+		if key := event.ResourceKey(); key != "" {
+			if value, seen := peaks[key]; !seen || event.ResourceValue() > value {
+				peaks[key] = event.ResourceValue()
+			}
+		}
+	}
+	return peaks
+}
+
+// TotalWeight returns the estimated number of events events represent.
+func TotalWeight(events []SystemEvent) int {
+	total := 0
+	for _, event := range events {
+		total += event.Weight()
+	}
+	return total
 }
 
 // AnomalyResult contains detection results.
 type AnomalyResult struct {
-	Pattern     string
-	Severity    string
-	Confidence  float64
-	Description string
+	Pattern        string
+	Category       string
+	Count          int // events of the category
+	Threshold      int // count above which the pattern fires
+	Severity       string
+	Confidence     float64
+	Description    string
 	Recommendation string
+	Timestamp      time.Time
+	Remediation    baseline.Remediation
+}
+
+// Anomaly returns the result as an anomaly, as pipelines report them.
+func (r AnomalyResult) Anomaly() baseline.Anomaly {
+	a := baseline.Anomaly{
+		Type:        r.Pattern,
+		Description: r.Description,
+		Severity:    r.Severity,
+		Evidence:    fmt.Sprintf("%d %s events, above the threshold of %d", r.Count, r.Category, r.Threshold),
+		Category:    r.Category,
+		Confidence:  math.Min(r.Confidence, 1),
+		Timestamp:   r.Timestamp,
+		RiskLevel:   r.Severity,
+	}
+	if !r.Remediation.IsZero() {
+		remediation := r.Remediation
+		a.Remediation = &remediation
+	}
+	return a
 }
 
 // NewDetector creates a new anomaly detector.
-func NewDetector() *Detector {
-	return &Detector{
+func NewDetector(opts ...Option) *Detector {
+	d := &Detector{
+		threshold: DefaultThreshold,
+		clock:     clock.System,
+		logger:    slog.Default(),
 		patterns: []*Pattern{
 			{
 				Name:        "Excessive File Access",
@@ -73,6 +357,10 @@ func NewDetector() *Detector {
 			},
 		},
 	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
 }
 
 // Detect detects anomalies in system events.
@@ -82,19 +370,27 @@ func (d *Detector) Detect(events []SystemEvent) []AnomalyResult {
 	// Count events by category
 	categoryCounts := make(map[string]int)
 	for _, event := range events {
-		categoryCounts[event.Type]++
+		categoryCounts[event.Type] += event.Weight()
 	}
 
 	// Check for anomalies
+	thresholds := d.Thresholds()
 	for _, pattern := range d.patterns {
 		count := categoryCounts[pattern.Category]
-		if count > 100 { // Threshold for detection
+		threshold := thresholds[pattern.Name]
+		if count > threshold {
+			d.logger.Debug("pattern fired", "component", "detect", "pattern", pattern.Name, "count", count, "threshold", threshold)
 			results = append(results, AnomalyResult{
-				Pattern:     pattern.Name,
-				Severity:    pattern.Severity,
-				Confidence:  float64(count) / 200.0,
-				Description: pattern.Description,
+				Pattern:        pattern.Name,
+				Category:       pattern.Category,
+				Count:          count,
+				Threshold:      threshold,
+				Severity:       severity.Label(pattern.Severity),
+				Confidence:     float64(count) / max(float64(2*threshold), 1),
+				Description:    pattern.Description,
 				Recommendation: "Review and investigate this activity",
+				Timestamp:      d.clock.Now(),
+				Remediation:    pattern.Remediation,
 			})
 		}
 	}
@@ -102,10 +398,53 @@ func (d *Detector) Detect(events []SystemEvent) []AnomalyResult {
 	return results
 }
 
+// Thresholds returns the event count above which each pattern fires, by
+// pattern name: learned from the baseline when WithBaselineThresholds is
+// set and the baseline has observed the pattern's category, and the fixed
+// threshold otherwise.
+func (d *Detector) Thresholds() map[string]int {
+	learned := d.learnedThresholds()
+	thresholds := make(map[string]int, len(d.patterns))
+	for _, pattern := range d.patterns {
+		threshold, ok := learned[pattern.Category]
+		if !ok {
+			threshold = d.threshold
+		}
+		thresholds[pattern.Name] = threshold
+	}
+	return thresholds
+}
+
+// learnedThresholds returns the per-category thresholds derived from the
+// baseline, deriving them again when it has been updated since.
+func (d *Detector) learnedThresholds() map[string]int {
+	if d.source == nil {
+		return nil
+	}
+	b := d.source()
+	if b == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.learned != nil && b.UpdatedAt.Equal(d.learnedAt) {
+		return d.learned
+	}
+	d.learned = make(map[string]int)
+	for _, pattern := range d.patterns {
+		if p, ok := b.CategoryPercentile(pattern.Category, d.percentile); ok {
+			d.learned[pattern.Category] = int(math.Ceil(p * (1 + d.margin)))
+		}
+	}
+	d.learnedAt = b.UpdatedAt
+	d.logger.Debug("derived thresholds from baseline", "component", "detect", "baseline", b.Name, "thresholds", d.learned)
+	return d.learned
+}
+
 // AnalyzeBehavior analyzes behavioral patterns.
 func AnalyzeBehavior(events []SystemEvent) map[string]interface{} {
 	analysis := map[string]interface{}{
-		"total_events": len(events),
+		"total_events": TotalWeight(events),
 		"by_category":  make(map[string]int),
 		"by_process":   make(map[string]int),
 		"time_range":   struct{ Start, End string }{},
@@ -114,8 +453,8 @@ func AnalyzeBehavior(events []SystemEvent) map[string]interface{} {
 	var timestamps []time.Time
 
 	for _, event := range events {
-		analysis["by_category"].(map[string]int)[event.Type]++
-		analysis["by_process"].(map[string]int)[event.ProcessName]++
+		analysis["by_category"].(map[string]int)[event.Type] += event.Weight()
+		analysis["by_process"].(map[string]int)[event.ProcessName] += event.Weight()
 
 		timestamps = append(timestamps, event.Timestamp)
 	}
@@ -146,7 +485,7 @@ func CalculateBehaviorScore(events []SystemEvent, baseline map[string]int) float
 		return 100.0
 	}
 
-	totalEvents := float64(len(events))
+	totalEvents := float64(TotalWeight(events))
 	totalBaseline := float64(0)
 	for _, count := range baseline {
 		totalBaseline += float64(count)
@@ -256,7 +595,14 @@ func GenerateReport(anomalies []AnomalyResult) string {
 		report += "    Severity: " + anomaly.Severity + "\n"
 		report += "    Confidence: " + fmt.Sprintf("%.0f%%", anomaly.Confidence*100) + "%\n"
 		report += "    Description: " + anomaly.Description + "\n"
-		report += "    Recommendation: " + anomaly.Recommendation + "\n\n"
+		report += "    Recommendation: " + anomaly.Recommendation + "\n"
+		if anomaly.Remediation.Runbook != "" {
+			report += "    Runbook: " + anomaly.Remediation.Runbook + "\n"
+		}
+		if anomaly.Remediation.Owner != "" {
+			report += "    Owner: " + anomaly.Remediation.Owner + "\n"
+		}
+		report += "\n"
 	}
 
 	return report
@@ -274,4 +620,4 @@ func max(a, b float64) float64 {
 		return a
 	}
 	return b
-}
\ No newline at end of file
+}
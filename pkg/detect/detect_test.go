@@ -0,0 +1,366 @@
+package detect
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+func TestBaselineThresholds(t *testing.T) {
+	b := baseline.NewLearner().CreateBaseline("web")
+	for _, count := range []int{9, 10, 11} {
+		b.RecordObservations([]baseline.Observation{{Category: "network", Pattern: "10.0.0.9:443", Count: count}})
+	}
+	d := NewDetector(WithBaselineThresholds(func() *baseline.Baseline { return b }, DefaultPercentile, DefaultMargin))
+
+	thresholds := d.Thresholds()
+	// p99 of mean 10, stddev 1 is 12.33; with a 20% margin, 14.8.
+	if got := thresholds["Network Connection Spike"]; got != 15 {
+		t.Errorf("expected a learned network threshold of 15, got %d", got)
+	}
+	if got := thresholds["System Call Spike"]; got != DefaultThreshold {
+		t.Errorf("expected unlearned categories to keep the fixed threshold, got %d", got)
+	}
+
+	events := make([]SystemEvent, 20)
+	for i := range events {
+		events[i] = SystemEvent{Type: "network"}
+	}
+	if results := d.Detect(events); len(results) != 1 || results[0].Pattern != "Network Connection Spike" {
+		t.Errorf("expected 20 connections to exceed the learned threshold, got %+v", results)
+	}
+
+	// Thresholds follow the baseline as it learns.
+	for i := 0; i < 20; i++ {
+		b.RecordObservations([]baseline.Observation{{Category: "network", Pattern: "10.0.0.9:443", Count: 30}})
+	}
+	if results := d.Detect(events); len(results) != 0 {
+		t.Errorf("expected no findings once the baseline learned busier intervals, got %+v", results)
+	}
+}
+
+func TestIntegrity(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "run.sh")
+	if err := os.WriteFile(script, []byte("hello\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("hello\n"))
+	want := hex.EncodeToString(sum[:])
+	if got, err := SHA256File(script); err != nil || got != want {
+		t.Fatalf("SHA256File = %q, %v; want %q", got, err, want)
+	}
+
+	if got := ParseSysmonHashes("SHA1=AB, MD5=CD,SHA256=EF,bogus"); !reflect.DeepEqual(got, map[string]string{"SHA1": "ab", "MD5": "cd", "SHA256": "ef"}) {
+		t.Errorf("ParseSysmonHashes = %v", got)
+	}
+
+	events := []SystemEvent{
+		{Type: "process", Path: "/usr/bin/sysmon", Data: map[string]interface{}{"Hashes": "MD5=00,SHA256=AAAA"}},
+		{Type: "process", Path: "/usr/bin/auditd", Data: map[string]interface{}{"sha256": "BBBB"}},
+		{Type: "file", Path: script},
+		{Type: "file", Path: filepath.Join(dir, "missing")},
+		{Type: "network", Path: script},
+		{Type: "process", Path: script, Hash: "preset"},
+	}
+	EnrichHashes(events, SHA256File)
+	var hashes []string
+	for _, e := range events {
+		hashes = append(hashes, e.Hash)
+	}
+	if want := []string{"aaaa", "bbbb", want, "", "", "preset"}; !reflect.DeepEqual(hashes, want) {
+		t.Errorf("enriched hashes %q, want %q", hashes, want)
+	}
+
+	b := baseline.NewLearner().CreateBaseline("web")
+	LearnIntegrity(events[:3], b)
+	if len(b.Integrity) != 3 {
+		t.Fatalf("expected three learned paths, got %+v", b.Integrity)
+	}
+	anomalies := DetectIntegrityAnomalies([]SystemEvent{
+		{Type: "process", Path: "/usr/bin/sysmon", Hash: "aaaa"},
+		{Type: "process", Path: "/usr/bin/auditd", Hash: "cccc"},
+		{Type: "file", Path: "/usr/bin/auditd", Hash: "cccc"},
+		{Type: "process", Path: "/tmp/dropper", Hash: "dddd"},
+	}, b)
+	if len(anomalies) != 2 || anomalies[0].Type != "Binary Hash Changed" || anomalies[1].Type != "Unknown Binary" {
+		t.Errorf("unexpected integrity anomalies %+v", anomalies)
+	}
+}
+
+func TestScoreBreakdown(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Ten minutes of 100 syscalls and 2 network connections a minute, as
+	// learned, and a process start in the last.
+	var events []SystemEvent
+	for minute := 0; minute < 10; minute++ {
+		at := start.Add(time.Duration(minute) * time.Minute)
+		events = append(events, SystemEvent{Type: "syscall", Timestamp: at, SampleRate: 100},
+			SystemEvent{Type: "network", Timestamp: at.Add(time.Second)}, SystemEvent{Type: "network", Timestamp: at.Add(2 * time.Second)})
+	}
+	events = append(events, SystemEvent{Type: "process", Timestamp: start.Add(9 * time.Minute)})
+	learned := map[string]int{"syscall": 100, "network": 2}
+
+	b := ScoreBreakdown(events, learned, time.Minute)
+	if b.Score < 99.9 {
+		t.Errorf("score %.2f for the learned rates and one process, want about 100", b.Score)
+	}
+	got := make(map[string]CategoryScore)
+	for _, cs := range b.Categories {
+		got[cs.Category] = cs
+	}
+	if cs := got["syscall"]; cs.Observed != 100 || cs.Expected != 100 || cs.Score != 100 || cs.Contribution != 0 {
+		t.Errorf("syscall %+v", cs)
+	}
+	if cs := got["process"]; !cs.Unexpected || cs.Observed != 0 || cs.Score != 0 || cs.Contribution <= 0 {
+		t.Errorf("process %+v", cs)
+	}
+	if len(b.TopContributors) != 1 || b.TopContributors[0].Category != "process" {
+		t.Errorf("top contributors %+v", b.TopContributors)
+	}
+	if w := b.Weights["network"]; math.Abs(w-2.0/102) > 1e-9 {
+		t.Errorf("network weight %f", w)
+	}
+
+	// Counted raw, the same events are ten times the baseline.
+	if raw := ScoreBreakdown(events, learned, 0); raw.Score != 0 || raw.Categories[2].Observed != 1000 {
+		t.Errorf("raw counts scored %.1f with %+v", raw.Score, raw.Categories)
+	}
+	// Twice the learned rate loses half the score.
+	if doubled := ScoreBreakdown(append(events, events...), learned, time.Minute); math.Abs(doubled.Score-49.9) > 0.1 {
+		t.Errorf("doubled rates scored %.2f, want about 49.9", doubled.Score)
+	}
+	if empty := ScoreBreakdown(events, nil, time.Minute); empty.Score != 100 || len(empty.Categories) != 0 {
+		t.Errorf("no baseline: %+v", empty)
+	}
+}
+
+func TestContributions(t *testing.T) {
+	b := baseline.NewLearner().CreateBaseline("web")
+	for _, clones := range []int{4, 6, 5, 5} {
+		b.RecordObservations([]baseline.Observation{{Category: "syscall", Pattern: "clone", Count: clones}, {Category: "syscall", Pattern: "read", Count: 100}})
+	}
+	start := time.Unix(0, 0)
+	var events []SystemEvent
+	for i := 0; i < 40; i++ {
+		events = append(events, SystemEvent{Type: "syscall", Timestamp: start, Data: map[string]interface{}{"syscall": "clone"}})
+	}
+	for i := 0; i < 90; i++ {
+		events = append(events, SystemEvent{Type: "syscall", Timestamp: start, Data: map[string]interface{}{"syscall": "read"}})
+	}
+	events = append(events,
+		SystemEvent{Type: "syscall", Timestamp: start, Data: map[string]interface{}{"syscall": "ptrace"}},
+		SystemEvent{Type: "file", Timestamp: start, Path: "/tmp/x"})
+
+	got := Contributions(events, b, 2)
+	if len(got) != 2 || got[0].Key != "syscall:clone" || got[1].Key != "file" {
+		t.Fatalf("expected clone then file, got %+v", got)
+	}
+	if want := "syscall:clone rate is 42.9σ above baseline (40.0 per interval, usually 5.0)"; got[0].Explanation != want {
+		t.Errorf("got %q, want %q", got[0].Explanation, want)
+	}
+	all := Contributions(events, b, 0)
+	if len(all) != 3 || all[2].Key != "syscall:ptrace" || !all[2].Unseen || all[2].Explanation != "syscall:ptrace was never seen in the baseline (1 event)" {
+		t.Errorf("expected reads, below their mean, left out and ptrace unseen, got %+v", all)
+	}
+
+	// Over ten minutes, 30 clones are 3 a minute: below the baseline
+	// however many they are in total, and removing no points.
+	var spread []SystemEvent
+	for i := 0; i < 30; i++ {
+		spread = append(spread, SystemEvent{Type: "syscall", Timestamp: start.Add(time.Duration(i) * 20 * time.Second), Data: map[string]interface{}{"syscall": "clone"}})
+	}
+	spread = append(spread, SystemEvent{Type: "syscall", Timestamp: start, Data: map[string]interface{}{"syscall": "ptrace"}})
+	got = Contributions(spread, b, 0)
+	if len(got) != 1 || got[0].Key != "syscall:ptrace" || got[0].Observed != 0.1 {
+		t.Fatalf("expected only ptrace, at 0.1 per interval, got %+v", got)
+	}
+	if want := 0.1 / 105 * 50; math.Abs(got[0].Points-want) > 1e-9 {
+		t.Errorf("ptrace removes %.4f points, want %.4f from its rate", got[0].Points, want)
+	}
+}
+
+func TestRollingScorer(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+	r := NewRollingScorer(30*time.Second, 10*time.Second, map[string]int{"syscall": 4}, 0)
+
+	var scores []WindowScore
+	for _, s := range []int{0, 1, 2, 12, 25, 26, 41} {
+		scores = append(scores, r.Add(SystemEvent{Type: "syscall", Timestamp: at(s)})...)
+	}
+	scores = append(scores, r.Tick(at(75))...)
+
+	type window struct {
+		end    time.Time
+		events int
+	}
+	var got []window
+	for _, ws := range scores {
+		got = append(got, window{ws.End, ws.Events})
+	}
+	// Windows end on interval boundaries and hold the events of the 30
+	// seconds before; events arriving later do not count.
+	want := []window{{at(10), 3}, {at(20), 4}, {at(30), 5}, {at(40), 3}, {at(50), 3}, {at(60), 1}, {at(70), 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("windows %v, want %v", got, want)
+	}
+	if scores[2].Score != 87.5 {
+		t.Errorf("5 events against 4 learned scored %.1f, want 87.5", scores[2].Score)
+	}
+	if len(r.Tick(at(75))) != 0 {
+		t.Error("expected no windows to be scored twice")
+	}
+
+	if last := r.Flush(); !last.End.Equal(at(41)) || last.Events != 1 {
+		t.Errorf("flushed window ending %s with %d events", last.End, last.Events)
+	}
+
+	// A window of five one-minute buckets of the learned rate scores as
+	// one bucket does, not as five times the rate.
+	wide := NewRollingScorer(5*time.Minute, time.Minute, map[string]int{"syscall": 100}, time.Minute)
+	var wideScores []WindowScore
+	for second := 0; second < 6*60; second += 3 {
+		wideScores = append(wideScores, wide.Add(SystemEvent{Type: "syscall", Timestamp: at(second), SampleRate: 5})...)
+	}
+	if len(wideScores) != 5 {
+		t.Fatalf("scored %d one-minute intervals, want 5", len(wideScores))
+	}
+	if full := wideScores[4]; full.Events != 500 || full.Score != 100 || full.Categories[0].Observed != 100 {
+		t.Errorf("full window of the learned rate: %d events, observed %d, score %.1f", full.Events, full.Categories[0].Observed, full.Score)
+	}
+	wide.Bucket = 0
+	if raw := wide.Flush(); raw.Score != 0 {
+		t.Errorf("window counted raw scored %.1f, want 0", raw.Score)
+	}
+
+	sampled := NewRollingScorer(time.Minute, 10*time.Second, nil, 0)
+	sampled.Add(SystemEvent{Type: "syscall", Timestamp: at(1), SampleRate: 10})
+	if ws := sampled.Add(SystemEvent{Type: "syscall", Timestamp: at(11)}); len(ws) != 1 || ws[0].Events != 10 || ws[0].Score != 100 {
+		t.Errorf("sampled window %+v", ws)
+	}
+}
+
+func TestTraceContext(t *testing.T) {
+	const trace, span = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	for _, tc := range []struct {
+		name        string
+		event       SystemEvent
+		trace, span string
+	}{
+		{"traceparent", SystemEvent{Data: map[string]interface{}{"traceparent": "00-" + trace + "-" + span + "-01"}}, trace, span},
+		{"otel fields", SystemEvent{Data: map[string]interface{}{"traceId": strings.ToUpper(trace), "spanId": span}}, trace, span},
+		{"labels", SystemEvent{Labels: map[string]string{"trace_id": trace}}, trace, ""},
+		{"zero trace", SystemEvent{Data: map[string]interface{}{"trace_id": strings.Repeat("0", 32), "span_id": span}}, "", ""},
+		{"malformed", SystemEvent{Data: map[string]interface{}{"traceparent": "00-xyz-" + span + "-01", "trace_id": trace[:31]}}, "", ""},
+		{"none", SystemEvent{}, "", ""},
+	} {
+		if traceID, spanID := TraceContext(tc.event); traceID != tc.trace || spanID != tc.span {
+			t.Errorf("%s: got %q %q, want %q %q", tc.name, traceID, spanID, tc.trace, tc.span)
+		}
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		event              SystemEvent
+		executable, status string
+	}{
+		{SystemEvent{Type: "process", Path: "/usr/bin/worker", Data: map[string]interface{}{"action": "exit", "exit_code": float64(2)}}, "/usr/bin/worker", "2"},
+		{SystemEvent{Type: "process", ProcessName: "worker", Data: map[string]interface{}{"action": "exit", "signal": "segv"}}, "worker", "SIGSEGV"},
+		{SystemEvent{Type: "process", Path: "/usr/bin/worker", Data: map[string]interface{}{"action": "exit", "signal": 9}}, "/usr/bin/worker", "SIGKILL"},
+		{SystemEvent{Type: "container", Data: map[string]interface{}{"topic": "/tasks/exit", "container": "api", "pod_namespace": "shop", "exit_status": 139}}, "container:shop/api", "SIGSEGV"},
+		{SystemEvent{Type: "container", Data: map[string]interface{}{"topic": "/tasks/exit", "container_id": "abc", "exit_status": 1}}, "container:abc", "1"},
+	}
+	for _, tt := range tests {
+		executable, status, ok := tt.event.ExitStatus()
+		if !ok || executable != tt.executable || status != tt.status {
+			t.Errorf("%+v: got %q %q %v, want %q %q", tt.event, executable, status, ok, tt.executable, tt.status)
+		}
+	}
+	if _, _, ok := (SystemEvent{Type: "process", Path: "/bin/sh", Data: map[string]interface{}{"action": "exec"}}).ExitStatus(); ok {
+		t.Error("expected an exec not to be an exit")
+	}
+	exits := Exits([]SystemEvent{tests[0].event, tests[0].event, tests[1].event})
+	if exits["/usr/bin/worker"]["2"] != 2 || exits["worker"]["SIGSEGV"] != 1 {
+		t.Errorf("exits = %v", exits)
+	}
+}
+
+func TestVolumeKey(t *testing.T) {
+	tests := []struct {
+		event     SystemEvent
+		key, want string
+	}{
+		{SystemEvent{Type: "file", Path: "/var/lib/db"}, "", "file:/var/lib/db"},
+		{SystemEvent{Type: "syscall", Path: "/var/lib/db", Data: map[string]interface{}{"syscall": "write"}}, "syscall:write", "file:/var/lib/db"},
+		{SystemEvent{Type: "network", Data: map[string]interface{}{"action": "connect", "destination": "10.0.0.9:443"}}, "network:10.0.0.9:443", "network:10.0.0.9:443"},
+		// Sends report the volume of a connection, not another connection.
+		{SystemEvent{Type: "network", Data: map[string]interface{}{"action": "send", "destination": "10.0.0.9:443"}}, "", "network:10.0.0.9:443"},
+		{SystemEvent{Type: "http", Path: "/api/export?page=2"}, "", "http:/api/export"},
+		{SystemEvent{Type: "process", Path: "/usr/bin/curl"}, "process:/usr/bin/curl", ""},
+	}
+	for _, tt := range tests {
+		if got := tt.event.Key(); got != tt.key {
+			t.Errorf("Key(%+v) = %q, want %q", tt.event, got, tt.key)
+		}
+		if got := tt.event.VolumeKey(); got != tt.want {
+			t.Errorf("VolumeKey(%+v) = %q, want %q", tt.event, got, tt.want)
+		}
+	}
+}
+
+func TestIntervalVolumes(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	write := func(offset time.Duration, bytes int64) SystemEvent {
+		e := SystemEvent{Type: "file", Path: "/var/lib/db", Bytes: bytes}
+		if offset >= 0 {
+			e.Timestamp = start.Add(offset)
+		}
+		return e
+	}
+	events := []SystemEvent{
+		write(150*time.Second, 300),
+		write(10*time.Second, 100),
+		write(-1, 5), // no timestamp: the first interval
+		write(50*time.Second, 100),
+		{Type: "process", Path: "/usr/bin/curl", Timestamp: start.Add(90 * time.Second)},
+	}
+	want := []map[string]int64{{"file:/var/lib/db": 205}, {"file:/var/lib/db": 300}}
+	if got := IntervalVolumes(events, time.Minute); !reflect.DeepEqual(got, want) {
+		t.Errorf("per minute %v, want %v", got, want)
+	}
+	if got := IntervalVolumes(events, 0); !reflect.DeepEqual(got, []map[string]int64{{"file:/var/lib/db": 505}}) {
+		t.Errorf("without an interval %v", got)
+	}
+	if got := IntervalVolumes(nil, time.Minute); len(got) != 0 {
+		t.Errorf("no events: %v", got)
+	}
+}
+
+func TestSequences(t *testing.T) {
+	op := func(pid int, syscall string) SystemEvent {
+		return SystemEvent{Type: "syscall", PID: pid, ProcessName: "app", Data: map[string]interface{}{"syscall": syscall}}
+	}
+	exit := SystemEvent{Type: "process", PID: 1, ProcessName: "app", Path: "/usr/bin/app", Data: map[string]interface{}{"action": "exit", "exit_code": 0}}
+	got := Sequences([]SystemEvent{
+		op(1, "openat"), op(2, "read"), op(1, "read"), op(1, "read"),
+		exit, op(1, "close"), // a new process reusing the PID
+		{Type: "syscall", ProcessName: "app", Data: map[string]interface{}{"syscall": "read"}},
+	})
+	want := map[baseline.Transition]int{
+		{Process: "app", From: "syscall:openat", To: "syscall:read"}: 1,
+		{Process: "app", From: "syscall:read", To: "syscall:read"}:   1,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Sequences() = %v, want %v", got, want)
+	}
+}
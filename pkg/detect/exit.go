@@ -0,0 +1,105 @@
+package detect
+
+import (
+	"strconv"
+	"strings"
+)
+
+// signalNames names the Linux signals processes commonly die of.
+var signalNames = map[int]string{
+	1: "SIGHUP", 2: "SIGINT", 3: "SIGQUIT", 4: "SIGILL", 5: "SIGTRAP", 6: "SIGABRT",
+	7: "SIGBUS", 8: "SIGFPE", 9: "SIGKILL", 10: "SIGUSR1", 11: "SIGSEGV", 12: "SIGUSR2",
+	13: "SIGPIPE", 14: "SIGALRM", 15: "SIGTERM", 24: "SIGXCPU", 25: "SIGXFSZ", 31: "SIGSYS",
+}
+
+// SignalName returns the name of a signal given by number ("11") or name
+// ("SEGV", "sigsegv"), such as "SIGSEGV".
+func SignalName(signal string) string {
+	if n, err := strconv.Atoi(signal); err == nil {
+		if name, ok := signalNames[n]; ok {
+			return name
+		}
+		return "SIG" + signal
+	}
+	name := strings.ToUpper(signal)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	return name
+}
+
+// ExitStatus returns what exited and how, for events reporting an exit:
+// "process" events whose action is "exit", which may carry exit_code or
+// signal, and containerd's /tasks/exit events, whose exit_status above 128
+// means death by signal. executable is the process's path or name, or
+// "container:" and the container's pod namespace and name, or ID. status
+// is the exit code, such as "0" or "1", the name of the signal that killed
+// the process, such as "SIGSEGV", or "" when the event does not say. ok is
+// false for other events.
+func (e SystemEvent) ExitStatus() (executable, status string, ok bool) {
+	switch e.Type {
+	case "process":
+		if action, _ := e.Data["action"].(string); action != "exit" {
+			return "", "", false
+		}
+		executable = e.Path
+		if executable == "" {
+			executable = e.ProcessName
+		}
+		if signal := fieldString(e.Data["signal"]); signal != "" && signal != "0" {
+			status = SignalName(signal)
+		} else {
+			status = fieldString(e.Data["exit_code"])
+		}
+	case "container":
+		if topic, _ := e.Data["topic"].(string); topic != "/tasks/exit" {
+			return "", "", false
+		}
+		if name, _ := e.Data["container"].(string); name != "" {
+			namespace, _ := e.Data["pod_namespace"].(string)
+			executable = "container:" + namespace + "/" + name
+		} else if id, _ := e.Data["container_id"].(string); id != "" {
+			executable = "container:" + id
+		}
+		status = fieldString(e.Data["exit_status"])
+		if code, err := strconv.Atoi(status); err == nil && code > 128 && code <= 128+64 {
+			status = SignalName(strconv.Itoa(code - 128))
+		}
+	default:
+		return "", "", false
+	}
+	return executable, status, executable != ""
+}
+
+// Exits counts the exits of events, such as those of one interval, by
+// executable and status; see ExitStatus.
+func Exits(events []SystemEvent) map[string]map[string]int {
+	exits := make(map[string]map[string]int)
+	for _, event := range events {
+		executable, status, ok := event.ExitStatus()
+		if !ok {
+			continue
+		}
+		if exits[executable] == nil {
+			exits[executable] = make(map[string]int)
+		}
+		exits[executable][status] += event.Weight()
+	}
+	return exits
+}
+
+// fieldString returns a string or numeric event field as a string, or ""
+// for other values.
+func fieldString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	}
+	return ""
+}
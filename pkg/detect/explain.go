@@ -0,0 +1,118 @@
+package detect
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+// DefaultContributions is how many contributions check lists by default.
+const DefaultContributions = 5
+
+// Contribution explains how much one operation pulls a behavior score
+// down.
+type Contribution struct {
+	// Key is the operation's statistics key, or the category of events
+	// identifying no operation, such as "file".
+	Key string `json:"key"`
+	// Observed is the operation's mean count per interval of the
+	// baseline's bucket width over the events; Expected the mean the
+	// baseline learned.
+	Observed float64 `json:"observed"`
+	Expected float64 `json:"expected"`
+	// Sigma is how many standard deviations Observed is from Expected;
+	// 0 when the baseline learned no variation.
+	Sigma float64 `json:"sigma,omitempty"`
+	// Points are the score points the operation removes, on the scale of
+	// CategoryScore.Contribution: the contributions of a category's
+	// operations add up to the category's.
+	Points float64 `json:"points"`
+	// Unseen is set for operations the baseline never observed.
+	Unseen      bool   `json:"unseen,omitempty"`
+	Explanation string `json:"explanation"`
+}
+
+// Contributions returns the k operations of events that remove the most
+// points from their behavior score against b, most first, each with a
+// sentence explaining it, such as "syscall:clone rate is 8.2σ above
+// baseline (41.0 per interval, usually 5.0)". Operations removing no
+// points are left out; k <= 0 returns them all.
+func Contributions(events []SystemEvent, b *baseline.Baseline, k int) []Contribution {
+	totalBaseline := 0
+	for _, count := range b.CategoryTotals() {
+		totalBaseline += count
+	}
+	if totalBaseline == 0 {
+		return nil
+	}
+	counts := make(map[string]int)
+	for _, e := range events {
+		// Events identifying no operation still count towards their
+		// category's contribution.
+		key := e.Key()
+		if key == "" {
+			key = e.Type
+		}
+		counts[key] += e.Weight()
+	}
+	// Rates are per interval over the span of all the events, as
+	// ScoreBreakdown counts them, so a category's operations add up to it.
+	intervals := Intervals(events, b.BucketWidthOrDefault())
+
+	var contributions []Contribution
+	for key, count := range counts {
+		stat, learned := b.Stats[key]
+		rate := float64(count) / intervals
+		c := Contribution{
+			Key:      key,
+			Observed: rate,
+			Expected: stat.Mean,
+			Points:   (rate - stat.Mean) / float64(totalBaseline) * 50,
+			Unseen:   !learned || stat.SampleCount == 0,
+		}
+		if c.Points <= 0 {
+			continue
+		}
+		if !c.Unseen && stat.StdDev > 0 {
+			c.Sigma = baseline.CalculateZScore(c.Observed, stat.Mean, stat.StdDev)
+		}
+		c.Explanation = c.explain(count)
+		contributions = append(contributions, c)
+	}
+	sort.Slice(contributions, func(i, j int) bool {
+		if contributions[i].Points != contributions[j].Points {
+			return contributions[i].Points > contributions[j].Points
+		}
+		return contributions[i].Key < contributions[j].Key
+	})
+	if k > 0 && len(contributions) > k {
+		contributions = contributions[:k]
+	}
+	return contributions
+}
+
+// explain returns the sentence explaining c, an operation seen count times.
+func (c Contribution) explain(count int) string {
+	switch {
+	case !strings.Contains(c.Key, ":"):
+		return fmt.Sprintf("%d %s %s identifying no operation the baseline could learn", count, c.Key, plural(count, "event", "events"))
+	case c.Unseen:
+		return fmt.Sprintf("%s was never seen in the baseline (%d %s)", c.Key, count, plural(count, "event", "events"))
+	case c.Sigma > 0:
+		return fmt.Sprintf("%s rate is %.1fσ above baseline (%.1f per interval, usually %.1f)", c.Key, c.Sigma, c.Observed, c.Expected)
+	case c.Sigma < 0:
+		return fmt.Sprintf("%s rate is %.1fσ below baseline (%.1f per interval, usually %.1f)", c.Key, -c.Sigma, c.Observed, c.Expected)
+	case c.Observed != c.Expected:
+		return fmt.Sprintf("%s rate is %.1f per interval, always %.1f in the baseline", c.Key, c.Observed, c.Expected)
+	}
+	return fmt.Sprintf("%s rate matches the baseline (%.1f per interval) but adds %d %s to the window", c.Key, c.Observed, count, plural(count, "event", "events"))
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
@@ -0,0 +1,130 @@
+package detect
+
+import (
+	"path/filepath"
+	"strconv"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+// maxTrackedPIDs bounds the process names an EdgeTracker remembers.
+const maxTrackedPIDs = 1 << 16
+
+// writeActions are the file actions and syscalls that write Path.
+var writeActions = map[string]bool{
+	"write": true, "pwrite64": true, "writev": true, "pwritev": true, "pwritev2": true,
+	"create": true, "creat": true, "truncate": true,
+}
+
+// EdgeTracker derives behavior graph edges from a stream of events. It
+// remembers the name of every process it sees, so a process spawned by
+// one seen earlier gets an edge from its parent.
+type EdgeTracker struct {
+	names map[int]string
+}
+
+// NewEdgeTracker returns an empty tracker.
+func NewEdgeTracker() *EdgeTracker {
+	return &EdgeTracker{names: make(map[int]string)}
+}
+
+// Edge returns the edge e adds to the behavior graph, if any:
+//
+//   - process exec events whose ppid is a process seen before: a spawn
+//     edge from the parent to the new program
+//   - network events with a destination: a connect edge
+//   - file and syscall events writing Path: a write edge
+//
+// Processes are named by executable base name, or ProcessName.
+func (t *EdgeTracker) Edge(e SystemEvent) (baseline.Edge, bool) {
+	edge := baseline.Edge{From: e.ProcessName, Count: e.Weight(), LastSeen: e.Timestamp}
+	if e.PID > 0 && e.ProcessName != "" {
+		if _, known := t.names[e.PID]; !known {
+			t.remember(e.PID, e.ProcessName)
+		}
+	}
+	action, _ := e.Data["action"].(string)
+	switch e.Type {
+	case "process":
+		if action == "exit" {
+			delete(t.names, e.PID)
+			return edge, false
+		}
+		if e.Path == "" || (action != "exec" && action != "") {
+			return edge, false
+		}
+		child := filepath.Base(e.Path)
+		if e.PID > 0 {
+			t.remember(e.PID, child)
+		}
+		parent := t.names[pidField(e.Data["ppid"])]
+		if parent == "" {
+			return edge, false
+		}
+		edge.Kind, edge.From, edge.To = baseline.EdgeSpawn, parent, child
+	case "network":
+		dest, _ := e.Data["destination"].(string)
+		if dest == "" {
+			return edge, false
+		}
+		edge.Kind, edge.To = baseline.EdgeConnect, dest
+	case "file", "syscall":
+		if action == "" {
+			action, _ = e.Data["syscall"].(string)
+		}
+		if e.Path == "" || !writeActions[action] {
+			return edge, false
+		}
+		edge.Kind, edge.To = baseline.EdgeWrite, e.Path
+	default:
+		return edge, false
+	}
+	if edge.From == "" {
+		edge.From = t.names[e.PID]
+	}
+	return edge, edge.From != ""
+}
+
+func (t *EdgeTracker) remember(pid int, name string) {
+	if len(t.names) >= maxTrackedPIDs {
+		t.names = make(map[int]string)
+	}
+	t.names[pid] = name
+}
+
+// Edges returns the behavior graph edges of events, merging repeats.
+func Edges(events []SystemEvent) []baseline.Edge {
+	t := NewEdgeTracker()
+	index := make(map[string]int)
+	var edges []baseline.Edge
+	for _, e := range events {
+		edge, ok := t.Edge(e)
+		if !ok {
+			continue
+		}
+		if i, seen := index[edge.Key()]; seen {
+			edges[i].Count += edge.Count
+			if edge.LastSeen.After(edges[i].LastSeen) {
+				edges[i].LastSeen = edge.LastSeen
+			}
+			continue
+		}
+		edge.FirstSeen = edge.LastSeen
+		index[edge.Key()] = len(edges)
+		edges = append(edges, edge)
+	}
+	return edges
+}
+
+func pidField(v interface{}) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case float64:
+		return int(n)
+	case string:
+		i, _ := strconv.Atoi(n)
+		return i
+	}
+	return 0
+}
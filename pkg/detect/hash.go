@@ -0,0 +1,100 @@
+package detect
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+// Hasher computes a content hash for a file path.
+type Hasher func(path string) (string, error)
+
+// SHA256File hashes the file at path with SHA-256.
+func SHA256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ParseSysmonHashes parses a Sysmon "Hashes" field such as
+// "SHA1=...,MD5=...,SHA256=..." into a map keyed by upper-case algorithm.
+func ParseSysmonHashes(field string) map[string]string {
+	hashes := make(map[string]string)
+	for _, part := range strings.Split(field, ",") {
+		algo, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || value == "" {
+			continue
+		}
+		hashes[strings.ToUpper(algo)] = strings.ToLower(value)
+	}
+	return hashes
+}
+
+// EnrichHashes fills in Hash for file and process events that carry a Path.
+// Hashes already reported by the source (a Sysmon "Hashes" field or an
+// auditd/collector "sha256" field in Data) are preferred; otherwise hasher is
+// used when non-nil. Files that cannot be hashed are left unchanged.
+func EnrichHashes(events []SystemEvent, hasher Hasher) {
+	for i := range events {
+		event := &events[i]
+		if event.Hash != "" || event.Path == "" {
+			continue
+		}
+		if event.Type != "file" && event.Type != "process" {
+			continue
+		}
+
+		if field, ok := event.Data["Hashes"].(string); ok {
+			if sum := ParseSysmonHashes(field)["SHA256"]; sum != "" {
+				event.Hash = sum
+				continue
+			}
+		}
+		if sum, ok := event.Data["sha256"].(string); ok && sum != "" {
+			event.Hash = strings.ToLower(sum)
+			continue
+		}
+
+		if hasher != nil {
+			if sum, err := hasher(event.Path); err == nil {
+				event.Hash = sum
+			}
+		}
+	}
+}
+
+// DetectIntegrityAnomalies checks executed binaries in events against the
+// baseline's learned hashes. Events should be hash-enriched first.
+func DetectIntegrityAnomalies(events []SystemEvent, b *baseline.Baseline) []baseline.Anomaly {
+	var anomalies []baseline.Anomaly
+	for _, event := range events {
+		if event.Type != "process" {
+			continue
+		}
+		if anomaly, ok := b.CheckExecutedBinary(event.Path, event.Hash); ok {
+			anomalies = append(anomalies, anomaly)
+		}
+	}
+	return anomalies
+}
+
+// LearnIntegrity records the hashes of file and process events into b.
+func LearnIntegrity(events []SystemEvent, b *baseline.Baseline) {
+	for _, event := range events {
+		if event.Type == "file" || event.Type == "process" {
+			b.RecordFileHash(event.Path, event.Hash)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package detect
+
+import (
+	"log/slog"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/clock"
+)
+
+// DefaultThreshold is the default per-category event count above which a
+// pattern fires.
+const DefaultThreshold = 100
+
+// Defaults of WithBaselineThresholds.
+const (
+	DefaultPercentile = 0.99
+	DefaultMargin     = 0.2
+)
+
+// Option configures a Detector.
+type Option func(*Detector)
+
+// WithThreshold sets the per-category event count above which a pattern
+// fires.
+func WithThreshold(count int) Option {
+	return func(d *Detector) { d.threshold = count }
+}
+
+// WithBaselineThresholds derives each pattern's threshold from the
+// baseline source returns: the percentile (e.g. DefaultPercentile) of its
+// category's learned per-interval event count, plus margin as a fraction
+// of it (e.g. DefaultMargin), rounded up. Thresholds are derived again
+// whenever the baseline's UpdatedAt changes, so a source reloading it from
+// storage keeps them current. Categories the baseline has not observed
+// keep the fixed threshold. Pass Detect one learned interval of events.
+func WithBaselineThresholds(source func() *baseline.Baseline, percentile, margin float64) Option {
+	return func(d *Detector) {
+		d.source, d.percentile, d.margin = source, percentile, margin
+	}
+}
+
+// WithClock sets the clock used to timestamp results.
+func WithClock(c clock.Clock) Option {
+	return func(d *Detector) { d.clock = c }
+}
+
+// WithLogger sets the logger.
+func WithLogger(l *slog.Logger) Option {
+	return func(d *Detector) { d.logger = l }
+}
@@ -0,0 +1,34 @@
+package detect
+
+import "sync"
+
+// maxPooledFields bounds the Data and Labels maps kept with pooled events,
+// so one unusually large event does not pin its map in the pool.
+const maxPooledFields = 64
+
+var eventPool = sync.Pool{New: func() any { return new(SystemEvent) }}
+
+// AcquireEvent returns a zero event from a pool. Its Data and Labels maps
+// are empty but may be kept from an earlier use, so decoding into them,
+// as json.Unmarshal does into a non-nil map, reuses their storage. Return
+// it with ReleaseEvent when done.
+func AcquireEvent() *SystemEvent {
+	return eventPool.Get().(*SystemEvent)
+}
+
+// ReleaseEvent clears e and returns it to the pool AcquireEvent takes
+// from. Neither e nor its Data and Labels maps may be used afterwards;
+// values copied out of them, such as strings, stay valid.
+func ReleaseEvent(e *SystemEvent) {
+	data, labels := e.Data, e.Labels
+	if len(data) > maxPooledFields {
+		data = nil
+	}
+	if len(labels) > maxPooledFields {
+		labels = nil
+	}
+	clear(data)
+	clear(labels)
+	*e = SystemEvent{Data: data, Labels: labels}
+	eventPool.Put(e)
+}
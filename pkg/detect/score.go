@@ -0,0 +1,126 @@
+package detect
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Breakdown explains a behavior score. The penalty that CalculateBehaviorScore
+// applies is the sum of the per-category Contribution values, so categories
+// can be ranked by how much they pull the score down.
+type Breakdown struct {
+	Score           float64            `json:"score"`
+	Categories      []CategoryScore    `json:"categories"`
+	TopContributors []CategoryScore    `json:"top_contributors"`
+	Weights         map[string]float64 `json:"weights"`
+	// Contributions explains the score per operation when filled in with
+	// Contributions, which needs the baseline's statistics.
+	Contributions []Contribution `json:"contributions,omitempty"`
+}
+
+// CategoryScore is the sub-score for one event category. Observed and
+// Expected are event counts per interval, rounded.
+type CategoryScore struct {
+	Category     string  `json:"category"`
+	Observed     int     `json:"observed"`
+	Expected     int     `json:"expected"`
+	Score        float64 `json:"score"`
+	Weight       float64 `json:"weight"`
+	Contribution float64 `json:"contribution"` // score points removed
+	Unexpected   bool    `json:"unexpected,omitempty"`
+}
+
+// maxTopContributors bounds Breakdown.TopContributors.
+const maxTopContributors = 5
+
+// ScoreBreakdown computes the behavior score with per-category sub-scores.
+// Each baseline category is weighted by its share of baseline activity;
+// categories absent from the baseline have no weight but still contribute
+// their full event volume to the penalty. Baseline totals are per interval
+// of event time, as CategoryTotals sums them, so events are counted per
+// interval too: their counts are divided by the intervals they span.
+func ScoreBreakdown(events []SystemEvent, baseline map[string]int, interval time.Duration) Breakdown {
+	observed := make(map[string]float64)
+	for _, event := range events {
+		observed[event.Type] += float64(event.Weight())
+	}
+	intervals := Intervals(events, interval)
+	for category := range observed {
+		observed[category] /= intervals
+	}
+	return scoreRates(observed, baseline)
+}
+
+// scoreRates computes the breakdown of observed events per interval by
+// category against baseline totals.
+func scoreRates(observed map[string]float64, baseline map[string]int) Breakdown {
+	breakdown := Breakdown{
+		Score:   100,
+		Weights: make(map[string]float64),
+	}
+
+	totalBaseline := 0
+	for _, count := range baseline {
+		totalBaseline += count
+	}
+	if totalBaseline == 0 {
+		return breakdown
+	}
+	totalObserved := 0.0
+	for _, rate := range observed {
+		totalObserved += rate
+	}
+	breakdown.Score = ratioScore(totalObserved / float64(totalBaseline))
+
+	categories := make(map[string]bool)
+	for category := range baseline {
+		categories[category] = true
+	}
+	for category := range observed {
+		categories[category] = true
+	}
+
+	for category := range categories {
+		expected, inBaseline := baseline[category]
+		cs := CategoryScore{
+			Category:     category,
+			Observed:     int(math.Round(observed[category])),
+			Expected:     expected,
+			Weight:       float64(expected) / float64(totalBaseline),
+			Contribution: (observed[category] - float64(expected)) / float64(totalBaseline) * 50,
+			Unexpected:   !inBaseline,
+		}
+		if expected > 0 {
+			cs.Score = ratioScore(observed[category] / float64(expected))
+		} else if observed[category] == 0 {
+			cs.Score = 100
+		}
+		breakdown.Weights[category] = cs.Weight
+		breakdown.Categories = append(breakdown.Categories, cs)
+	}
+
+	sort.Slice(breakdown.Categories, func(i, j int) bool {
+		return breakdown.Categories[i].Category < breakdown.Categories[j].Category
+	})
+
+	for _, cs := range breakdown.Categories {
+		if cs.Contribution > 0 {
+			breakdown.TopContributors = append(breakdown.TopContributors, cs)
+		}
+	}
+	sort.SliceStable(breakdown.TopContributors, func(i, j int) bool {
+		return breakdown.TopContributors[i].Contribution > breakdown.TopContributors[j].Contribution
+	})
+	if len(breakdown.TopContributors) > maxTopContributors {
+		breakdown.TopContributors = breakdown.TopContributors[:maxTopContributors]
+	}
+
+	return breakdown
+}
+
+// ratioScore scores the ratio of observed to expected activity: 100 up to
+// as much as expected, 50 points less for each multiple above it.
+func ratioScore(ratio float64) float64 {
+	return math.Max(0, math.Min(100, 100-(ratio-1)*50))
+}
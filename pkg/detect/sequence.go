@@ -0,0 +1,53 @@
+package detect
+
+import (
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+// SequenceTracker follows the operations of each process across events to
+// report the transitions between them, for the sequence model.
+type SequenceTracker struct {
+	last map[int]string // pid → key of its last operation
+}
+
+// NewSequenceTracker returns a tracker that has seen no events.
+func NewSequenceTracker() *SequenceTracker {
+	return &SequenceTracker{last: make(map[int]string)}
+}
+
+// Transition returns the transition event makes from the previous
+// operation of its process, by PID, which it remembers in its place. ok is
+// false for the first operation of a process, events without a PID, a
+// process name or a key, and exits, after which the PID is forgotten.
+func (t *SequenceTracker) Transition(event SystemEvent) (baseline.Transition, bool) {
+	if event.PID == 0 || event.ProcessName == "" {
+		return baseline.Transition{}, false
+	}
+	if _, _, exit := event.ExitStatus(); exit {
+		delete(t.last, event.PID)
+		return baseline.Transition{}, false
+	}
+	key := event.Key()
+	if key == "" {
+		return baseline.Transition{}, false
+	}
+	from, ok := t.last[event.PID]
+	t.last[event.PID] = key
+	if !ok {
+		return baseline.Transition{}, false
+	}
+	return baseline.Transition{Process: event.ProcessName, From: from, To: key}, true
+}
+
+// Sequences counts the transitions between the operations of events, in
+// order, such as those of one window; see SequenceTracker.
+func Sequences(events []SystemEvent) map[baseline.Transition]int {
+	t := NewSequenceTracker()
+	transitions := make(map[baseline.Transition]int)
+	for _, event := range events {
+		if tr, ok := t.Transition(event); ok {
+			transitions[tr] += event.Weight()
+		}
+	}
+	return transitions
+}
@@ -0,0 +1,54 @@
+package detect
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"strings"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+// Handshake returns the TLS client handshake a "network" event reports,
+// from collectors that see handshakes: its JA4 fingerprint in Data["ja4"],
+// or its JA3 in Data["ja3"], either the MD5 hash or the raw string, which
+// is hashed. Fingerprints are prefixed with their kind, like
+// "ja4:t13d1516h2_8daaf6152771_e5627efa2ab1". The process is the event's
+// executable path or name, and the destination the server name in
+// Data["sni"], or else Data["destination"]. ok is false for other events.
+func (e SystemEvent) Handshake() (h baseline.Handshake, ok bool) {
+	if e.Type != "network" {
+		return h, false
+	}
+	if ja4, _ := e.Data["ja4"].(string); ja4 != "" {
+		h.Fingerprint = "ja4:" + ja4
+	} else if ja3, _ := e.Data["ja3"].(string); ja3 != "" {
+		if strings.Contains(ja3, ",") {
+			sum := md5.Sum([]byte(ja3))
+			ja3 = hex.EncodeToString(sum[:])
+		}
+		h.Fingerprint = "ja3:" + strings.ToLower(ja3)
+	} else {
+		return h, false
+	}
+	h.Process = e.Path
+	if h.Process == "" {
+		h.Process = e.ProcessName
+	}
+	h.Destination, _ = e.Data["sni"].(string)
+	if h.Destination == "" {
+		h.Destination, _ = e.Data["destination"].(string)
+	}
+	return h, h.Process != ""
+}
+
+// Handshakes counts the TLS client handshakes of events, such as those of
+// one interval; see Handshake.
+func Handshakes(events []SystemEvent) map[baseline.Handshake]int {
+	handshakes := make(map[baseline.Handshake]int)
+	for _, event := range events {
+		if h, ok := event.Handshake(); ok {
+			handshakes[h] += event.Weight()
+		}
+	}
+	return handshakes
+}
@@ -0,0 +1,69 @@
+package detect
+
+import "strings"
+
+// Keys of the event Data or Labels that TraceContext reads, in order of
+// preference: the names OpenTelemetry log records and common structured
+// logging libraries use, and Elastic Common Schema's.
+var (
+	traceIDKeys = []string{"trace_id", "traceId", "traceID", "trace.id"}
+	spanIDKeys  = []string{"span_id", "spanId", "spanID", "span.id"}
+)
+
+// TraceContext returns the distributed trace and span an event belongs to,
+// when it came from an instrumented application: the W3C traceparent
+// field, or separate trace and span ID fields, of its Data or Labels. IDs
+// are returned in lowercase hex; malformed or all-zero IDs are ignored.
+func TraceContext(e SystemEvent) (traceID, spanID string) {
+	if parent := traceField(e, "traceparent"); parent != "" {
+		// version-traceid-parentid-flags
+		if fields := strings.Split(parent, "-"); len(fields) >= 4 && len(fields[0]) == 2 {
+			traceID, spanID = validID(fields[1], 32), validID(fields[2], 16)
+			if traceID != "" {
+				return traceID, spanID
+			}
+		}
+	}
+	for _, key := range traceIDKeys {
+		if traceID = validID(traceField(e, key), 32); traceID != "" {
+			break
+		}
+	}
+	if traceID == "" {
+		return "", ""
+	}
+	for _, key := range spanIDKeys {
+		if spanID = validID(traceField(e, key), 16); spanID != "" {
+			break
+		}
+	}
+	return traceID, spanID
+}
+
+func traceField(e SystemEvent, key string) string {
+	if s, ok := e.Data[key].(string); ok && s != "" {
+		return s
+	}
+	return e.Labels[key]
+}
+
+// validID returns id in lowercase if it is n hex digits, not all zero.
+func validID(id string, n int) string {
+	if len(id) != n {
+		return ""
+	}
+	zero := true
+	for i := 0; i < n; i++ {
+		switch c := id[i]; {
+		case c == '0':
+		case '1' <= c && c <= '9', 'a' <= c && c <= 'f', 'A' <= c && c <= 'F':
+			zero = false
+		default:
+			return ""
+		}
+	}
+	if zero {
+		return ""
+	}
+	return strings.ToLower(id)
+}
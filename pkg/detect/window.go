@@ -0,0 +1,100 @@
+package detect
+
+import (
+	"time"
+)
+
+// WindowScore is the behavior score of a sliding window of events.
+type WindowScore struct {
+	End    time.Time `json:"end"`
+	Events int       `json:"events"`
+	Breakdown
+}
+
+// RollingScorer computes the behavior score over a sliding window of
+// events every interval. Time is taken from the events themselves, so a
+// recorded stream replays with the same windows as it had live; Tick
+// advances time when no events arrive.
+type RollingScorer struct {
+	Window   time.Duration
+	Interval time.Duration
+	Baseline map[string]int
+	// Bucket is the interval the baseline totals are per: a window's
+	// counts are scaled by Bucket/Window before scoring, or taken as they
+	// are when it is 0.
+	Bucket time.Duration
+
+	events []SystemEvent
+	next   time.Time // end of the next interval to score
+}
+
+// NewRollingScorer returns a scorer over window-long windows, scored every
+// interval against baseline category totals per bucket.
+func NewRollingScorer(window, interval time.Duration, baseline map[string]int, bucket time.Duration) *RollingScorer {
+	return &RollingScorer{Window: window, Interval: interval, Baseline: baseline, Bucket: bucket}
+}
+
+// Add adds an event and returns the scores of any intervals that ended
+// before it. Events without a timestamp must be stamped by the caller.
+func (r *RollingScorer) Add(event SystemEvent) []WindowScore {
+	scores := r.Tick(event.Timestamp)
+	r.events = append(r.events, event)
+	return scores
+}
+
+// Tick returns the scores of the intervals that ended before now.
+func (r *RollingScorer) Tick(now time.Time) []WindowScore {
+	if r.next.IsZero() {
+		r.next = now.Truncate(r.Interval).Add(r.Interval)
+		return nil
+	}
+	var scores []WindowScore
+	for now.After(r.next) {
+		scores = append(scores, r.score(r.next))
+		r.next = r.next.Add(r.Interval)
+	}
+	return scores
+}
+
+// Flush scores the window ending at the last event and is meant for the
+// end of input, where the final interval is incomplete.
+func (r *RollingScorer) Flush() WindowScore {
+	end := r.next
+	if len(r.events) > 0 {
+		end = r.events[len(r.events)-1].Timestamp
+	}
+	return r.score(end)
+}
+
+// score evicts events older than the window ending at end and scores the
+// ones before end.
+func (r *RollingScorer) score(end time.Time) WindowScore {
+	start := end.Add(-r.Window)
+	kept := r.events[:0]
+	for _, e := range r.events {
+		if e.Timestamp.After(start) {
+			kept = append(kept, e)
+		}
+	}
+	r.events = kept
+
+	events := 0
+	rates := make(map[string]float64)
+	for _, e := range r.events {
+		if !e.Timestamp.After(end) {
+			events += e.Weight()
+			rates[e.Type] += float64(e.Weight())
+		}
+	}
+	if r.Bucket > 0 {
+		buckets := float64(r.Window) / float64(r.Bucket)
+		for category := range rates {
+			rates[category] /= buckets
+		}
+	}
+	return WindowScore{
+		End:       end,
+		Events:    events,
+		Breakdown: scoreRates(rates, r.Baseline),
+	}
+}
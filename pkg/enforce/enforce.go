@@ -0,0 +1,264 @@
+// Package enforce kills processes whose operations fall far outside a
+// baseline.
+//
+// Enforcement reacts to operations it is told about; it cannot block them.
+// The events come from hooks that observe the operation, such as the BPF
+// LSM hooks of collect.LSMCollector, which report it but leave the
+// kernel's decision alone, so by the time a process is killed the
+// operation has been allowed and may have completed.
+//
+// Enforcement is opt-in per category and always subordinate to a kill-switch:
+// while the kill-switch file exists, or after Disable is called, no process
+// is killed and decisions are only reported.
+package enforce
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// Verdict is the outcome of an enforcement decision.
+type Verdict int
+
+// Verdicts.
+const (
+	Allow Verdict = iota
+	// Report means the process would be killed but enforcement is off for
+	// its category or disabled by the kill-switch.
+	Report
+	// Kill means the process is killed for the operation, after it.
+	Kill
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case Allow:
+		return "allow"
+	case Report:
+		return "report"
+	case Kill:
+		return "kill"
+	}
+	return "unknown"
+}
+
+// Decision explains a verdict.
+type Decision struct {
+	Verdict  Verdict
+	Category string
+	Key      string // baseline key that was missing, e.g. network:10.0.0.9:443
+	Reason   string
+}
+
+// Responder carries out a kill verdict for an event.
+type Responder func(event detect.SystemEvent, d Decision) error
+
+// KillProcess is a Responder that sends SIGKILL to the offending process
+// once its operation has been observed. It stops the process from going
+// on, not the operation, which has already been allowed.
+func KillProcess(event detect.SystemEvent, d Decision) error {
+	if event.PID <= 1 || event.PID == os.Getpid() {
+		return fmt.Errorf("refusing to signal pid %d", event.PID)
+	}
+	return kill(event.PID)
+}
+
+// kill kills the process pid, with SIGKILL on Unix; tests replace it.
+var kill = func(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Kill()
+}
+
+// Categories are the categories Decide can find operations outside a
+// baseline in, by event type.
+var Categories = []string{"network", "process", "syscall"}
+
+// CheckCategories checks that every category is one of Categories and of
+// supported, such as the event types a collector produces, so enabling
+// enforcement for a category that never sees events is an error rather
+// than a silent no-op.
+func CheckCategories(categories, supported []string) error {
+	var known []string
+	for _, category := range Categories {
+		if slices.Contains(supported, category) {
+			known = append(known, category)
+		}
+	}
+	for _, category := range categories {
+		if !slices.Contains(known, category) {
+			return fmt.Errorf("cannot enforce category %q: want one of %s", category, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
+
+// Enforcer decides whether events are consistent with a baseline.
+type Enforcer struct {
+	Baseline *baseline.Baseline
+	// Categories lists the categories ("syscall", "network", "process") in
+	// which deviations kill the process rather than being reported.
+	Categories map[string]bool
+	// KillSwitchPath disables enforcement while the file exists.
+	KillSwitchPath string
+	Responder      Responder
+
+	disabled atomic.Bool
+}
+
+// New creates an enforcer killing processes for deviations in the given
+// categories.
+func New(b *baseline.Baseline, categories []string, killSwitchPath string, responder Responder) *Enforcer {
+	enabled := make(map[string]bool)
+	for _, category := range categories {
+		enabled[category] = true
+	}
+	return &Enforcer{
+		Baseline:       b,
+		Categories:     enabled,
+		KillSwitchPath: killSwitchPath,
+		Responder:      responder,
+	}
+}
+
+// Disable turns enforcement off until Enable is called.
+func (e *Enforcer) Disable() { e.disabled.Store(true) }
+
+// Enable turns enforcement back on.
+func (e *Enforcer) Enable() { e.disabled.Store(false) }
+
+// Active reports whether kills are currently carried out.
+func (e *Enforcer) Active() bool {
+	if e.disabled.Load() {
+		return false
+	}
+	if e.KillSwitchPath != "" {
+		if _, err := os.Stat(e.KillSwitchPath); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Decide evaluates an event. Only operations the baseline has never seen
+// are considered far enough outside it to kill for: unknown syscalls,
+// connections to unknown destinations and executions of unknown binaries.
+func (e *Enforcer) Decide(event detect.SystemEvent) Decision {
+	key, reason := e.unknown(event)
+	if key == "" {
+		return Decision{Verdict: Allow, Category: event.Type}
+	}
+
+	d := Decision{Verdict: Report, Category: event.Type, Key: key, Reason: reason}
+	if e.Categories[event.Type] && e.Active() {
+		d.Verdict = Kill
+	}
+	return d
+}
+
+// Enforce decides on an event and invokes the responder on a kill verdict.
+func (e *Enforcer) Enforce(event detect.SystemEvent) (Decision, error) {
+	d := e.Decide(event)
+	if d.Verdict == Kill && e.Responder != nil {
+		if err := e.Responder(event, d); err != nil {
+			return d, fmt.Errorf("enforcing %s: %w", d.Key, err)
+		}
+	}
+	return d, nil
+}
+
+func (e *Enforcer) unknown(event detect.SystemEvent) (string, string) {
+	key := event.Key()
+	if key == "" {
+		return "", ""
+	}
+	switch event.Type {
+	case "syscall":
+		if _, ok := e.Baseline.Stats[key]; !ok {
+			return key, "syscall never observed during learning"
+		}
+	case "network":
+		if _, ok := e.Baseline.Stats[key]; !ok {
+			return key, "connection to a destination never observed during learning"
+		}
+	case "process":
+		_, learned := e.Baseline.Stats[key]
+		_, hashed := e.Baseline.Integrity[event.Path]
+		if !learned && !hashed {
+			return key, "execution of a binary never observed during learning"
+		}
+	}
+	return "", ""
+}
+
+// Anomaly converts a non-allow decision into an anomaly for reporting.
+func (d Decision) Anomaly(event detect.SystemEvent) baseline.Anomaly {
+	level := severity.Label(severity.High)
+	description := "Operation outside baseline (enforcement would kill the process)"
+	if d.Verdict == Kill {
+		level = severity.Label(severity.Critical)
+		description = "Process killed by enforcement after an operation outside baseline"
+	}
+	a := baseline.Anomaly{
+		Type:        "Enforcement " + d.Verdict.String(),
+		Description: description + ": " + d.Reason,
+		Severity:    level,
+		Evidence:    d.Key,
+		Category:    d.Category,
+		Confidence:  1,
+		Timestamp:   event.Timestamp,
+		RiskLevel:   level,
+		PID:         event.PID,
+		Process:     event.ProcessName,
+	}
+	a.TraceID, a.SpanID = detect.TraceContext(event)
+	return a
+}
+
+// Unseen converts a non-allow decision into the "Unseen Behavior" anomaly
+// detection raises for an operation the baseline has never observed,
+// whatever the enforcer would do about it.
+func (d Decision) Unseen(event detect.SystemEvent) baseline.Anomaly {
+	level := severity.Label(severity.High)
+	a := baseline.Anomaly{
+		Type:        "Unseen Behavior",
+		Description: "Operation outside baseline: " + d.Reason,
+		Severity:    level,
+		Evidence:    d.Key,
+		Category:    d.Category,
+		Confidence:  1,
+		Timestamp:   event.Timestamp,
+		RiskLevel:   level,
+		PID:         event.PID,
+		Process:     event.ProcessName,
+	}
+	a.TraceID, a.SpanID = detect.TraceContext(event)
+	return a
+}
+
+// Unseen returns an "Unseen Behavior" anomaly for each operation of events
+// that b has never observed: unknown syscalls, destinations and
+// executables. Each operation is reported once, for its first event.
+func Unseen(b *baseline.Baseline, events []detect.SystemEvent) []baseline.Anomaly {
+	e := New(b, nil, "", nil)
+	reported := make(map[string]bool)
+	var anomalies []baseline.Anomaly
+	for _, event := range events {
+		d := e.Decide(event)
+		if d.Verdict == Allow || reported[d.Key] {
+			continue
+		}
+		reported[d.Key] = true
+		anomalies = append(anomalies, d.Unseen(event))
+	}
+	return anomalies
+}
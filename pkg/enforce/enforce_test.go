@@ -0,0 +1,197 @@
+package enforce
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/collect"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+func testBaseline() *baseline.Baseline {
+	b := baseline.NewLearner().CreateBaseline("app")
+	b.Stats["syscall:openat"] = baseline.Stat{Mean: 10, SampleCount: 5}
+	b.Stats["network:10.0.0.9:443"] = baseline.Stat{Mean: 1, SampleCount: 5}
+	b.Stats["process:/usr/bin/app"] = baseline.Stat{Mean: 1, SampleCount: 5}
+	b.Integrity = map[string]baseline.FileIntegrity{"/usr/bin/helper": {}}
+	return b
+}
+
+func network(dest string) detect.SystemEvent {
+	return detect.SystemEvent{Type: "network", PID: 4242, ProcessName: "app", Data: map[string]interface{}{"destination": dest}}
+}
+
+func TestDecide(t *testing.T) {
+	e := New(testBaseline(), []string{"network", "process"}, "", nil)
+	tests := []struct {
+		event detect.SystemEvent
+		want  Verdict
+		key   string
+	}{
+		{network("10.0.0.9:443"), Allow, ""},
+		{network("203.0.113.7:4444"), Kill, "network:203.0.113.7:4444"},
+		{detect.SystemEvent{Type: "process", Path: "/usr/bin/app"}, Allow, ""},
+		{detect.SystemEvent{Type: "process", Path: "/usr/bin/helper"}, Allow, ""},
+		{detect.SystemEvent{Type: "process", Path: "/tmp/x"}, Kill, "process:/tmp/x"},
+		{detect.SystemEvent{Type: "syscall", Data: map[string]interface{}{"syscall": "openat"}}, Allow, ""},
+		// Known deviation in a category enforcement is off for.
+		{detect.SystemEvent{Type: "syscall", Data: map[string]interface{}{"syscall": "ptrace"}}, Report, "syscall:ptrace"},
+		{detect.SystemEvent{Type: "file", Path: "/etc/shadow"}, Allow, ""},
+	}
+	for _, tt := range tests {
+		d := e.Decide(tt.event)
+		if d.Verdict != tt.want || d.Key != tt.key || d.Category != tt.event.Type {
+			t.Errorf("Decide(%s %q) = %+v, want %s for %q", tt.event.Type, tt.event.Key(), d, tt.want, tt.key)
+		}
+		if tt.want != Allow && d.Reason == "" {
+			t.Errorf("Decide(%q) has no reason", tt.event.Key())
+		}
+	}
+}
+
+func TestKillSwitch(t *testing.T) {
+	killSwitch := filepath.Join(t.TempDir(), "off")
+	e := New(testBaseline(), []string{"network"}, killSwitch, nil)
+	event := network("203.0.113.7:4444")
+
+	if d := e.Decide(event); d.Verdict != Kill {
+		t.Fatalf("expected kill, got %s", d.Verdict)
+	}
+	if err := os.WriteFile(killSwitch, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if d := e.Decide(event); d.Verdict != Report || e.Active() {
+		t.Errorf("expected report while the kill-switch exists, got %s", d.Verdict)
+	}
+	if err := os.Remove(killSwitch); err != nil {
+		t.Fatal(err)
+	}
+	e.Disable()
+	if d := e.Decide(event); d.Verdict != Report {
+		t.Errorf("expected report while disabled, got %s", d.Verdict)
+	}
+	e.Enable()
+	if d := e.Decide(event); d.Verdict != Kill {
+		t.Errorf("expected kill after Enable, got %s", d.Verdict)
+	}
+}
+
+func TestEnforce(t *testing.T) {
+	var killed []string
+	responder := func(event detect.SystemEvent, d Decision) error {
+		killed = append(killed, d.Key)
+		if strings.HasSuffix(d.Key, ":1") {
+			return errors.New("no such process")
+		}
+		return nil
+	}
+	e := New(testBaseline(), []string{"network"}, "", responder)
+
+	for _, event := range []detect.SystemEvent{
+		network("10.0.0.9:443"),
+		network("203.0.113.7:4444"),
+		{Type: "process", Path: "/tmp/x"},
+	} {
+		if _, err := e.Enforce(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(killed) != 1 || killed[0] != "network:203.0.113.7:4444" {
+		t.Errorf("expected the responder called for the kill verdict only, got %v", killed)
+	}
+
+	d, err := e.Enforce(network("203.0.113.7:1"))
+	if err == nil || !strings.Contains(err.Error(), "network:203.0.113.7:1") || d.Verdict != Kill {
+		t.Errorf("expected the responder error wrapped, got %v (%s)", err, d.Verdict)
+	}
+}
+
+func TestKillProcess(t *testing.T) {
+	var signalled []int
+	defer func(orig func(int) error) { kill = orig }(kill)
+	kill = func(pid int) error {
+		signalled = append(signalled, pid)
+		return nil
+	}
+
+	for _, pid := range []int{0, 1, os.Getpid()} {
+		if err := KillProcess(detect.SystemEvent{PID: pid}, Decision{}); err == nil {
+			t.Errorf("expected pid %d refused", pid)
+		}
+	}
+	if err := KillProcess(detect.SystemEvent{PID: 4242}, Decision{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(signalled) != 1 || signalled[0] != 4242 {
+		t.Errorf("signalled %v, want [4242]", signalled)
+	}
+}
+
+func TestCheckCategories(t *testing.T) {
+	if err := CheckCategories([]string{"network", "process"}, collect.LSMEventTypes); err != nil {
+		t.Error(err)
+	}
+	if err := CheckCategories(nil, collect.LSMEventTypes); err != nil {
+		t.Error(err)
+	}
+	// The LSM collector never produces syscall events, so enforcing them
+	// would silently never kill anything.
+	err := CheckCategories([]string{"network", "syscall"}, collect.LSMEventTypes)
+	if err == nil || !strings.Contains(err.Error(), `"syscall"`) || !strings.Contains(err.Error(), "network, process") {
+		t.Errorf("expected syscall rejected, got %v", err)
+	}
+	// Collected but not enforceable.
+	if err := CheckCategories([]string{"file"}, collect.LSMEventTypes); err == nil {
+		t.Error("expected file rejected")
+	}
+	if err := CheckCategories([]string{"syscall"}, []string{"syscall"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDecisionAnomaly(t *testing.T) {
+	e := New(testBaseline(), []string{"network"}, "", nil)
+	event := network("203.0.113.7:4444")
+
+	a := e.Decide(event).Anomaly(event)
+	if a.Type != "Enforcement kill" || a.Category != "network" || a.Evidence != "network:203.0.113.7:4444" || a.PID != 4242 {
+		t.Errorf("unexpected anomaly %+v", a)
+	}
+	if !strings.Contains(a.Description, "killed") || a.Severity != "CRITICAL" {
+		t.Errorf("unexpected kill description or severity: %q %s", a.Description, a.Severity)
+	}
+	if behavior, _ := a.Behavior(); behavior != "network" {
+		t.Errorf("Behavior() = %q, want network", behavior)
+	}
+
+	e.Disable()
+	a = e.Decide(event).Anomaly(event)
+	if a.Type != "Enforcement report" || !strings.Contains(a.Description, "would kill") || a.Severity != "HIGH" {
+		t.Errorf("unexpected report anomaly %+v", a)
+	}
+}
+
+func TestUnseen(t *testing.T) {
+	events := []detect.SystemEvent{
+		network("10.0.0.9:443"),
+		network("203.0.113.7:4444"),
+		network("203.0.113.7:4444"),
+		{Type: "syscall", Data: map[string]interface{}{"syscall": "dup2"}},
+	}
+	anomalies := Unseen(testBaseline(), events)
+	var keys []string
+	for _, a := range anomalies {
+		if a.Type != "Unseen Behavior" || a.Severity != "HIGH" || !strings.HasPrefix(a.Description, "Operation outside baseline: ") {
+			t.Errorf("unexpected anomaly %+v", a)
+		}
+		keys = append(keys, a.Evidence)
+	}
+	if want := []string{"network:203.0.113.7:4444", "syscall:dup2"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("reported %q, want each unseen operation once: %q", keys, want)
+	}
+}
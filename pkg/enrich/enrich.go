@@ -0,0 +1,267 @@
+// Package enrich annotates anomalies involving network peers with GeoIP
+// and ASN data from local MaxMind DB files, and checks the peers against
+// user-provided blocklists. Anomalies with a blocklisted peer have their
+// severity raised.
+package enrich
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/export"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// Annotation keys set in Anomaly.Enrichment.
+const (
+	KeyIP        = "ip"
+	KeyCountry   = "country"
+	KeyCity      = "city"
+	KeyASN       = "asn"
+	KeyASOrg     = "as_org"
+	KeyBlocklist = "blocklist"       // names of the matching lists
+	KeyMatched   = "blocklist_match" // the peer that matched
+)
+
+// Blocklist is a set of IP addresses, CIDR ranges and domains.
+type Blocklist struct {
+	Name     string
+	networks []*net.IPNet
+	domains  map[string]bool
+}
+
+// LoadBlocklist reads a blocklist with one IP address, CIDR range or domain
+// per line. Blank lines and text after "#" are ignored. The list is named
+// after the file unless name is set.
+func LoadBlocklist(name, path string) (*Blocklist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	list := &Blocklist{Name: name, domains: make(map[string]bool)}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		entry, _, _ := strings.Cut(scanner.Text(), "#")
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if err := list.Add(entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Add adds an IP address, CIDR range or domain to the list. A domain also
+// matches its subdomains.
+func (l *Blocklist) Add(entry string) error {
+	if l.domains == nil {
+		l.domains = make(map[string]bool)
+	}
+	if _, network, err := net.ParseCIDR(entry); err == nil {
+		l.networks = append(l.networks, network)
+		return nil
+	}
+	if ip := net.ParseIP(entry); ip != nil {
+		bits := 8 * len(ip.To16())
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		l.networks = append(l.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		return nil
+	}
+	if strings.ContainsAny(entry, " /:") || !strings.Contains(entry, ".") {
+		return fmt.Errorf("invalid entry %q: expected an IP address, CIDR range or domain", entry)
+	}
+	l.domains[strings.ToLower(strings.TrimSuffix(entry, "."))] = true
+	return nil
+}
+
+// ContainsIP reports whether ip is on the list.
+func (l *Blocklist) ContainsIP(ip net.IP) bool {
+	for _, network := range l.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsDomain reports whether domain or one of its parent domains is on
+// the list.
+func (l *Blocklist) ContainsDomain(domain string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	for domain != "" {
+		if l.domains[domain] {
+			return true
+		}
+		_, parent, ok := strings.Cut(domain, ".")
+		if !ok {
+			break
+		}
+		domain = parent
+	}
+	return false
+}
+
+// Enricher annotates anomalies with network peer reputation. Any of its
+// sources may be nil or empty.
+type Enricher struct {
+	GeoIP      *MMDB // GeoLite2/GeoIP2 Country or City database
+	ASN        *MMDB // GeoLite2/GeoIP2 ASN database
+	Blocklists []*Blocklist
+}
+
+// Lookup returns the GeoIP and ASN annotations for ip. Lookup errors leave
+// the affected fields unset.
+func (e *Enricher) Lookup(ip net.IP) map[string]string {
+	info := map[string]string{KeyIP: ip.String()}
+	if e.GeoIP != nil {
+		if record, err := e.GeoIP.Lookup(ip); err == nil && record != nil {
+			if code, ok := path(record, "country", "iso_code").(string); ok {
+				info[KeyCountry] = code
+			}
+			if city, ok := path(record, "city", "names", "en").(string); ok {
+				info[KeyCity] = city
+			}
+		}
+	}
+	if e.ASN != nil {
+		if record, err := e.ASN.Lookup(ip); err == nil && record != nil {
+			if asn, ok := record["autonomous_system_number"].(uint64); ok {
+				info[KeyASN] = "AS" + strconv.FormatUint(asn, 10)
+			}
+			if org, ok := record["autonomous_system_organization"].(string); ok {
+				info[KeyASOrg] = org
+			}
+		}
+	}
+	return info
+}
+
+// Blocked returns the names of the blocklists containing the indicator.
+func (e *Enricher) Blocked(indicator export.Indicator) []string {
+	var names []string
+	for _, list := range e.Blocklists {
+		switch indicator.Type {
+		case export.IndicatorIPv4, export.IndicatorIPv6:
+			if list.ContainsIP(net.ParseIP(indicator.Value)) {
+				names = append(names, list.Name)
+			}
+		case export.IndicatorDomain:
+			if list.ContainsDomain(indicator.Value) {
+				names = append(names, list.Name)
+			}
+		}
+	}
+	return names
+}
+
+// Indicators returns the annotations for a set of network peers: GeoIP and
+// ASN data for the first IP address, and the blocklists containing the
+// first listed peer. It returns nil when there is nothing to annotate.
+func (e *Enricher) Indicators(indicators []export.Indicator) map[string]string {
+	enrichment := make(map[string]string)
+	var looked, matched bool
+	for _, indicator := range indicators {
+		isIP := indicator.Type == export.IndicatorIPv4 || indicator.Type == export.IndicatorIPv6
+		if isIP && !looked {
+			for k, v := range e.Lookup(net.ParseIP(indicator.Value)) {
+				enrichment[k] = v
+			}
+			looked = true
+		}
+		if names := e.Blocked(indicator); len(names) > 0 && !matched {
+			enrichment[KeyBlocklist] = strings.Join(names, ",")
+			enrichment[KeyMatched] = indicator.Value
+			matched = true
+		}
+	}
+	if len(enrichment) == 0 {
+		return nil
+	}
+	return enrichment
+}
+
+// peerFields are the event data fields naming a network peer.
+var peerFields = []string{"destination", "remote", "ip", "host"}
+
+// Event returns the annotations for the network peer of an event, or nil
+// when it has none.
+func (e *Enricher) Event(event detect.SystemEvent) map[string]string {
+	var peers []string
+	for _, field := range peerFields {
+		if s, ok := event.Data[field].(string); ok && s != "" {
+			peers = append(peers, s)
+		}
+	}
+	if len(peers) == 0 {
+		return nil
+	}
+	return e.Indicators(export.ExtractIndicators(strings.Join(peers, " ")))
+}
+
+// Annotate enriches anomalies in place from the network peers in their
+// evidence, and returns the number with a blocklisted peer.
+func (e *Enricher) Annotate(anomalies []baseline.Anomaly) int {
+	blocked := 0
+	for i := range anomalies {
+		enrichment := e.Indicators(export.ExtractIndicators(anomalies[i].Evidence))
+		if Apply(&anomalies[i], enrichment) {
+			blocked++
+		}
+	}
+	return blocked
+}
+
+// Apply adds annotations to an anomaly. If they include a blocklist match,
+// the anomaly's severity is raised one level, and to at least HIGH, and
+// Apply reports true.
+func Apply(a *baseline.Anomaly, enrichment map[string]string) bool {
+	if len(enrichment) == 0 {
+		return false
+	}
+	if a.Enrichment == nil {
+		a.Enrichment = make(map[string]string)
+	}
+	for k, v := range enrichment {
+		a.Enrichment[k] = v
+	}
+	if enrichment[KeyBlocklist] == "" {
+		return false
+	}
+	a.Severity = severity.Raise(a.Severity)
+	if high := severity.Label(severity.High); !severity.AtLeast(a.Severity, high) {
+		a.Severity = high
+	}
+	return true
+}
+
+// path follows keys through nested MMDB maps.
+func path(record map[string]interface{}, keys ...string) interface{} {
+	var value interface{} = record
+	for _, key := range keys {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+	return value
+}
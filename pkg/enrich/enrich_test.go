@@ -0,0 +1,184 @@
+package enrich
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// The helpers below encode just enough of the MaxMind DB format to build
+// small test databases.
+
+func encString(s string) []byte {
+	if len(s) >= 29 {
+		return append([]byte{typeString<<5 | 29, byte(len(s) - 29)}, s...)
+	}
+	return append([]byte{byte(typeString<<5 | len(s))}, s...)
+}
+
+func encUint32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return append([]byte{byte(typeUint32<<5 | 4)}, b...)
+}
+
+func encMap(pairs ...[]byte) []byte {
+	out := []byte{byte(typeMap<<5 | len(pairs)/2)}
+	for _, p := range pairs {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// buildIPv4DB builds a database with 24-bit records mapping one IPv4
+// prefix to record.
+func buildIPv4DB(t *testing.T, prefix string, record []byte) []byte {
+	t.Helper()
+	_, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bits, _ := network.Mask.Size()
+	nodeCount := uint32(bits)
+	ip := network.IP.To4()
+
+	var tree []byte
+	for i := 0; i < bits; i++ {
+		next := uint32(i + 1)
+		if i == bits-1 {
+			next = nodeCount + 16 // data section offset 0
+		}
+		left, right := nodeCount, nodeCount
+		if ip[i/8]>>(7-uint(i%8))&1 == 0 {
+			left = next
+		} else {
+			right = next
+		}
+		for _, r := range []uint32{left, right} {
+			tree = append(tree, byte(r>>16), byte(r>>8), byte(r))
+		}
+	}
+
+	var db bytes.Buffer
+	db.Write(tree)
+	db.Write(make([]byte, 16))
+	db.Write(record)
+	db.Write(metadataMarker)
+	db.Write(encMap(
+		encString("node_count"), encUint32(nodeCount),
+		encString("record_size"), encUint32(24),
+		encString("ip_version"), encUint32(4),
+		encString("database_type"), encString("Test-City-ASN"),
+	))
+	return db.Bytes()
+}
+
+func testEnricher(t *testing.T) *Enricher {
+	record := encMap(
+		encString("country"), encMap(encString("iso_code"), encString("NL")),
+		encString("city"), encMap(encString("names"), encMap(encString("en"), encString("Amsterdam"))),
+		encString("autonomous_system_number"), encUint32(64500),
+		encString("autonomous_system_organization"), encString("Example Hosting"),
+	)
+	db, err := ParseMMDB(buildIPv4DB(t, "203.0.113.0/24", record))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.DatabaseType != "Test-City-ASN" {
+		t.Errorf("unexpected database type %q", db.DatabaseType)
+	}
+	return &Enricher{GeoIP: db, ASN: db}
+}
+
+func TestMMDBLookup(t *testing.T) {
+	e := testEnricher(t)
+
+	info := e.Lookup(net.ParseIP("203.0.113.77"))
+	want := map[string]string{
+		KeyIP: "203.0.113.77", KeyCountry: "NL", KeyCity: "Amsterdam",
+		KeyASN: "AS64500", KeyASOrg: "Example Hosting",
+	}
+	for k, v := range want {
+		if info[k] != v {
+			t.Errorf("%s: expected %q, got %q", k, v, info[k])
+		}
+	}
+
+	if record, err := e.GeoIP.Lookup(net.ParseIP("198.51.100.1")); err != nil || record != nil {
+		t.Errorf("expected no record outside the prefix, got %v, %v", record, err)
+	}
+	if _, err := ParseMMDB([]byte("not a database")); err == nil {
+		t.Error("expected an error for a file without metadata")
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "c2.txt")
+	content := "# known C2\n203.0.113.7\n192.0.2.0/24 # sinkhole\n\nevil.example\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	list, err := LoadBlocklist("", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list.Name != "c2" {
+		t.Errorf("expected list named after the file, got %q", list.Name)
+	}
+	for _, ip := range []string{"203.0.113.7", "192.0.2.200"} {
+		if !list.ContainsIP(net.ParseIP(ip)) {
+			t.Errorf("expected %s to be listed", ip)
+		}
+	}
+	if list.ContainsIP(net.ParseIP("203.0.113.8")) {
+		t.Error("expected 203.0.113.8 not to be listed")
+	}
+	if !list.ContainsDomain("cdn.Evil.example.") || list.ContainsDomain("notevil.example") {
+		t.Error("expected domains to match themselves and their subdomains only")
+	}
+
+	if err := os.WriteFile(path, []byte("not an entry\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadBlocklist("", path); err == nil {
+		t.Error("expected an invalid entry to be rejected")
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	e := testEnricher(t)
+	list := &Blocklist{Name: "c2"}
+	list.Add("203.0.113.7")
+	e.Blocklists = []*Blocklist{list}
+
+	anomalies := []baseline.Anomaly{
+		{Severity: severity.Low, Evidence: "network:connect 203.0.113.7:443"},
+		{Severity: severity.High, Evidence: "network:connect 203.0.113.9:443"},
+		{Severity: severity.Medium, Evidence: "syscall:open"},
+	}
+	if blocked := e.Annotate(anomalies); blocked != 1 {
+		t.Errorf("expected 1 blocklisted anomaly, got %d", blocked)
+	}
+
+	if got := anomalies[0].Severity; got != severity.High {
+		t.Errorf("expected blocklisted LOW anomaly to become HIGH, got %s", got)
+	}
+	if anomalies[0].Enrichment[KeyBlocklist] != "c2" || anomalies[0].Enrichment[KeyCountry] != "NL" {
+		t.Errorf("unexpected enrichment %v", anomalies[0].Enrichment)
+	}
+	if got := anomalies[1].Severity; got != severity.High {
+		t.Errorf("expected unlisted anomaly to keep its severity, got %s", got)
+	}
+	if anomalies[1].Enrichment[KeyASN] != "AS64500" {
+		t.Errorf("expected ASN annotation, got %v", anomalies[1].Enrichment)
+	}
+	if anomalies[2].Enrichment != nil {
+		t.Errorf("expected no enrichment without a network peer, got %v", anomalies[2].Enrichment)
+	}
+}
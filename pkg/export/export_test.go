@@ -0,0 +1,379 @@
+package export
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+func TestExtractIndicators(t *testing.T) {
+	evidence := "network:203.0.113.7:4444 evil.example.com app.log " +
+		"d41d8cd98f00b204e9800998ecf8427e network:203.0.113.7"
+
+	got := ExtractIndicators(evidence)
+	want := []Indicator{
+		{Type: IndicatorIPv4, Value: "203.0.113.7"},
+		{Type: IndicatorDomain, Value: "evil.example.com"},
+		{Type: IndicatorMD5, Value: "d41d8cd98f00b204e9800998ecf8427e"},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("expected %d indicators, got %d: %v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("indicator %d: expected %v, got %v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestStatsExport(t *testing.T) {
+	b := baseline.NewLearner().CreateBaseline("web")
+	b.AnomalyThreshold = 3
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	b.Stats["syscall:openat"] = baseline.Stat{Mean: 19.5, StdDev: 5.9, Min: 10, Max: 29, SampleCount: 20, M2: 665, LastSeen: now}
+	b.Stats["network:10.0.0.9:443{env=prod}"] = baseline.Stat{Mean: 3, Min: 3, Max: 3, SampleCount: 1}
+	b.Volume = map[string]baseline.Stat{"file:/var/lib/db": baseline.Stat{Mean: 1 << 20, SampleCount: 1, Seeded: true}}
+
+	rows := StatRows(b)
+	if len(rows) != 3 {
+		t.Fatalf("expected 3 rows, got %d: %+v", len(rows), rows)
+	}
+	if r := rows[0]; r.Table != "stats" || r.Category != "network" || r.Pattern != "10.0.0.9:443" || r.Labels != "env=prod" {
+		t.Errorf("unexpected first row %+v", r)
+	}
+	if r := rows[2]; r.Table != "volume" || !r.Seeded {
+		t.Errorf("unexpected volume row %+v", r)
+	}
+
+	var out bytes.Buffer
+	if err := WriteStatsCSV(&out, rows); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 || len(records[0]) != len(StatColumns) || records[0][3] != "key" {
+		t.Fatalf("unexpected CSV %v", records)
+	}
+	if got := records[2]; got[3] != "syscall:openat" || got[11] != "20" || got[13] != "2026-05-01T12:00:00Z" || got[15] != "3" {
+		t.Errorf("unexpected CSV row %v", got)
+	}
+	if got := records[1][13]; got != "" {
+		t.Errorf("expected empty last_seen for a never-seen stat, got %q", got)
+	}
+
+	out.Reset()
+	if err := WriteStatsParquet(&out, rows); err != nil {
+		t.Fatal(err)
+	}
+	data := out.Bytes()
+	if !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.HasSuffix(data, []byte("PAR1")) {
+		t.Fatal("missing Parquet magic")
+	}
+	footer := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	if footer <= 0 || footer > len(data)-12 {
+		t.Fatalf("bad footer length %d for %d bytes", footer, len(data))
+	}
+	if meta := data[len(data)-8-footer : len(data)-8]; !bytes.Contains(meta, []byte("sample_count")) || !bytes.Contains(meta, []byte("runtimebase")) {
+		t.Error("footer is missing the schema")
+	}
+}
+
+func TestNotebookExport(t *testing.T) {
+	b := baseline.NewLearner().CreateBaseline("web")
+	b.Stats["syscall:openat"] = baseline.Stat{Mean: 19.5, StdDev: 5.9, SampleCount: 20}
+	at := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	anomalies := []baseline.Anomaly{
+		{Type: "Unseen Behavior", Severity: "HIGH", Evidence: "process:/bin/sh", Timestamp: at, TraceID: "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{Type: "Volume Spike", Severity: "LOW", Evidence: "file:/var/lib/db"},
+	}
+
+	dir := t.TempDir()
+	files, err := WriteNotebook(dir, b, anomalies, "json", at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(files, " ") != "stats.json anomalies.json explore.ipynb" {
+		t.Fatalf("files = %v", files)
+	}
+	var rows []map[string]any
+	data, _ := os.ReadFile(filepath.Join(dir, "anomalies.json"))
+	if err := json.Unmarshal(data, &rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0]["category"] != "process" || rows[0]["trace_id"] == "" || rows[1]["time"] != nil {
+		t.Errorf("anomalies = %v", rows)
+	}
+	var notebook struct {
+		Cells []struct {
+			Type    string   `json:"cell_type"`
+			Source  []string `json:"source"`
+			Outputs []any    `json:"outputs"`
+		} `json:"cells"`
+		NBFormat int `json:"nbformat"`
+	}
+	data, _ = os.ReadFile(filepath.Join(dir, "explore.ipynb"))
+	if err := json.Unmarshal(data, &notebook); err != nil {
+		t.Fatal(err)
+	}
+	if notebook.NBFormat != 4 || len(notebook.Cells) < 2 || !strings.Contains(strings.Join(notebook.Cells[1].Source, ""), `pd.read_json("stats.json"`) {
+		t.Errorf("notebook = %s", data)
+	}
+	for _, cell := range notebook.Cells {
+		if cell.Type == "code" && cell.Outputs == nil {
+			t.Errorf("code cell without outputs: %v", cell.Source)
+		}
+	}
+
+	if _, err := WriteNotebook(dir, b, anomalies, "parquet", at); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(filepath.Join(dir, "anomalies.parquet"))
+	if !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.Contains(data, []byte("trace_id")) {
+		t.Error("anomalies.parquet is not a Parquet file of anomalies")
+	}
+	if _, err := WriteNotebook(dir, b, anomalies, "csv", at); err == nil {
+		t.Error("expected an unknown dataset format to be rejected")
+	}
+}
+
+func TestReadThresholdsCSV(t *testing.T) {
+	sheet := "namespace,baseline,table,key,mean,threshold\n" +
+		",web,stats,network:10.0.0.9:443,3,4.5\n" +
+		",web,volume,network:10.0.0.9:443,4096,4.5\n" +
+		",web,stats,syscall:openat,19.5,\n"
+	got, err := ReadThresholdsCSV(strings.NewReader(sheet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ThresholdOverride{
+		{Line: 2, Baseline: "web", Key: "network:10.0.0.9:443", Threshold: 4.5},
+		{Line: 4, Baseline: "web", Key: "syscall:openat"},
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	got, err = ReadThresholdsCSV(strings.NewReader("key,threshold,direction\nnetwork:connect,3,UP\nsyscall:openat,3,\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Direction != baseline.DirectionUp || got[1].Direction != baseline.DirectionBoth {
+		t.Errorf("expected directions up and both, got %+v", got)
+	}
+
+	for name, sheet := range map[string]string{
+		"missing column": "key,mean\nsyscall:openat,3\n",
+		"bad direction":  "key,threshold,direction\nsyscall:openat,3,sideways\n",
+		"bad threshold":  "key,threshold\nsyscall:openat,high\n",
+		"conflict":       "key,threshold\nsyscall:openat,4\nsyscall:openat,5\n",
+	} {
+		if _, err := ReadThresholdsCSV(strings.NewReader(sheet)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestGraphExport(t *testing.T) {
+	web := &baseline.Baseline{Name: "web", Graph: map[string]baseline.Edge{}}
+	db := &baseline.Baseline{Name: "db", Graph: map[string]baseline.Edge{}}
+	for _, e := range []baseline.Edge{
+		{Kind: baseline.EdgeSpawn, From: "nginx", To: "php-fpm", Count: 3},
+		{Kind: baseline.EdgeConnect, From: "nginx", To: "10.0.0.9:443", Count: 9},
+		{Kind: baseline.EdgeWrite, From: "php-fpm", To: `/tmp/"sess"&1`, Count: 1},
+	} {
+		web.Graph[e.Key()] = e
+		db.Graph[e.Key()] = e
+	}
+
+	var dot bytes.Buffer
+	if err := WriteGraphDOT(&dot, web); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`digraph "web" {`,
+		`"process:nginx" [label="nginx", shape=box];`,
+		`"destination:10.0.0.9:443" [label="10.0.0.9:443", shape=ellipse];`,
+		`"process:nginx" -> "process:php-fpm" [label="spawn (3)"];`,
+		`"file:/tmp/\"sess\"&1" [label="/tmp/\"sess\"&1", shape=note];`,
+	} {
+		if !strings.Contains(dot.String(), want) {
+			t.Errorf("expected DOT to contain %s, got\n%s", want, dot.String())
+		}
+	}
+
+	var graphml bytes.Buffer
+	if err := WriteGraphML(&graphml, web, db); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Graphs []struct {
+			ID    string `xml:"id,attr"`
+			Nodes []struct {
+				ID string `xml:"id,attr"`
+			} `xml:"node"`
+			Edges []struct {
+				ID     string `xml:"id,attr"`
+				Source string `xml:"source,attr"`
+			} `xml:"edge"`
+		} `xml:"graph"`
+	}
+	if err := xml.Unmarshal(graphml.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid GraphML: %v\n%s", err, graphml.String())
+	}
+	if len(doc.Graphs) != 2 {
+		t.Fatalf("expected a graph per baseline, got %d", len(doc.Graphs))
+	}
+	ids := make(map[string]bool)
+	for _, g := range doc.Graphs {
+		if len(g.Nodes) != 4 || len(g.Edges) != 3 {
+			t.Errorf("graph %s: expected 4 nodes and 3 edges, got %d and %d", g.ID, len(g.Nodes), len(g.Edges))
+		}
+		for _, n := range g.Nodes {
+			if ids[n.ID] {
+				t.Errorf("duplicate node id %q", n.ID)
+			}
+			ids[n.ID] = true
+		}
+		for _, e := range g.Edges {
+			if ids[e.ID] {
+				t.Errorf("duplicate edge id %q", e.ID)
+			}
+			ids[e.ID] = true
+		}
+	}
+}
+
+func TestSELinuxHints(t *testing.T) {
+	b := &baseline.Baseline{
+		Name: "web-1",
+		Stats: map[string]baseline.Stat{
+			"process:/usr/sbin/nginx":          {SampleCount: 3},
+			"network:10.0.0.9:443":             {SampleCount: 3},
+			"file.write:/var/log/nginx/access": {SampleCount: 3},
+			"syscall:openat":                   {SampleCount: 3},
+		},
+		Volume: map[string]baseline.Stat{
+			"file:/etc/nginx/nginx.conf": {SampleCount: 3},
+			"file:/etc/passwd":           {SampleCount: 3},
+			"network:10.0.0.20:8125":     {SampleCount: 3},
+		},
+		Graph: map[string]baseline.Edge{},
+	}
+	for _, e := range []baseline.Edge{
+		{Kind: baseline.EdgeWrite, From: "nginx", To: "/tmp/cache", Count: 1},
+		{Kind: baseline.EdgeConnect, From: "nginx", To: "10.0.0.53:53", Count: 1},
+	} {
+		b.Graph[e.Key()] = e
+	}
+
+	domain := SELinuxDomain(b.Name)
+	if domain != "web_1_t" {
+		t.Errorf("expected domain web_1_t, got %s", domain)
+	}
+	var out bytes.Buffer
+	if err := WriteSELinuxHints(&out, b, domain); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"module runtimebase_web_1 1.0;",
+		"\ttype web_1_t;",
+		"\tclass tcp_socket name_connect;",
+		"# /usr/sbin/nginx\nallow web_1_t bin_t:file { execute execute_no_trans getattr map open read };",
+		"# /etc/nginx/nginx.conf\nallow web_1_t etc_t:file { getattr open read };",
+		"allow web_1_t passwd_file_t:file { getattr open read };",
+		"# /var/log/nginx/access\nallow web_1_t var_log_t:file { append create getattr open read write };",
+		"allow web_1_t var_log_t:dir { add_name getattr search write };",
+		"# /tmp/cache\nallow web_1_t tmp_t:file { append create getattr open read write };",
+		"# 10.0.0.9:443\nallow web_1_t http_port_t:tcp_socket name_connect;",
+		"# 10.0.0.53:53\nallow web_1_t dns_port_t:tcp_socket name_connect;",
+		"# 10.0.0.20:8125\nallow web_1_t unreserved_port_t:tcp_socket name_connect;",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected the hints to contain %q, got\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "openat") {
+		t.Errorf("expected syscalls to be left out, got\n%s", out.String())
+	}
+}
+
+func TestRegoBundle(t *testing.T) {
+	web := &baseline.Baseline{
+		Name: "web",
+		Stats: map[string]baseline.Stat{
+			"network:10.0.0.9:443":          {SampleCount: 3},
+			"network:10.0.0.9:443{pod=web}": {SampleCount: 3},
+			"syscall:openat":                {SampleCount: 3},
+		},
+		Integrity: map[string]baseline.FileIntegrity{"/usr/sbin/nginx": {}},
+		Graph:     map[string]baseline.Edge{"spawn:nginx->php-fpm": {Kind: baseline.EdgeSpawn, From: "nginx", To: "php-fpm"}},
+	}
+	db := &baseline.Baseline{Name: "db"}
+
+	var out bytes.Buffer
+	if err := WriteRegoBundle(&out, web, db); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if files[h.Name], err = io.ReadAll(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if string(files[".manifest"]) != `{"roots":["runtimebase"]}` {
+		t.Errorf("unexpected manifest %s", files[".manifest"])
+	}
+	if string(files["runtimebase/policy.rego"]) != RegoPolicy {
+		t.Errorf("expected the policy template in the bundle")
+	}
+	if _, ok := files["runtimebase/baselines/db/data.json"]; !ok {
+		t.Errorf("expected a data document per baseline, got %d files", len(files))
+	}
+
+	var doc RegoBaseline
+	if err := json.Unmarshal(files["runtimebase/baselines/web/data.json"], &doc); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"network:10.0.0.9:443": true, "syscall:openat": true, "process:/usr/sbin/nginx": true}
+	if len(doc.Behaviors) != len(want) {
+		t.Errorf("expected behaviors %v without labels, got %v", want, doc.Behaviors)
+	}
+	for key := range want {
+		if !doc.Behaviors[key] {
+			t.Errorf("expected behavior %s, got %v", key, doc.Behaviors)
+		}
+	}
+	if got := doc.Categories["network"]; len(got) != 1 || got[0] != "10.0.0.9:443" {
+		t.Errorf("expected one network pattern, got %v", got)
+	}
+	if !doc.Edges["spawn:nginx->php-fpm"] || !strings.Contains(string(files["runtimebase/baselines/web/data.json"]), "nginx->php-fpm") {
+		t.Errorf("expected the spawn edge, unescaped, got %v", doc.Edges)
+	}
+}
@@ -0,0 +1,146 @@
+package export
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+// Node kinds of the behavior graph.
+const (
+	NodeProcess     = "process"
+	NodeDestination = "destination"
+	NodeFile        = "file"
+)
+
+// GraphNode is a node of a baseline's behavior graph.
+type GraphNode struct {
+	ID    string // kind:name, unique within the graph
+	Kind  string
+	Label string
+}
+
+// GraphNodes returns the nodes of a baseline's behavior graph, sorted by
+// ID, and its edges sorted by key.
+func GraphNodes(b *baseline.Baseline) ([]GraphNode, []baseline.Edge) {
+	nodes := make(map[string]GraphNode)
+	edges := make([]baseline.Edge, 0, len(b.Graph))
+	for _, e := range b.Graph {
+		for _, n := range edgeNodes(e) {
+			nodes[n.ID] = n
+		}
+		edges = append(edges, e)
+	}
+	sort.Slice(edges, func(i, j int) bool { return edges[i].Key() < edges[j].Key() })
+	sorted := make([]GraphNode, 0, len(nodes))
+	for _, n := range nodes {
+		sorted = append(sorted, n)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	return sorted, edges
+}
+
+// edgeNodes returns the source and target nodes of e.
+func edgeNodes(e baseline.Edge) [2]GraphNode {
+	to := NodeProcess
+	switch e.Kind {
+	case baseline.EdgeConnect:
+		to = NodeDestination
+	case baseline.EdgeWrite:
+		to = NodeFile
+	}
+	return [2]GraphNode{
+		{ID: NodeProcess + ":" + e.From, Kind: NodeProcess, Label: e.From},
+		{ID: to + ":" + e.To, Kind: to, Label: e.To},
+	}
+}
+
+// WriteGraphDOT writes the behavior graphs of baselines in Graphviz DOT,
+// one digraph per baseline: processes as boxes, destinations as
+// ellipses and files as notes, with edges labeled by kind and count.
+func WriteGraphDOT(w io.Writer, baselines ...*baseline.Baseline) error {
+	bw := bufio.NewWriter(w)
+	shapes := map[string]string{NodeProcess: "box", NodeDestination: "ellipse", NodeFile: "note"}
+	for _, b := range baselines {
+		nodes, edges := GraphNodes(b)
+		fmt.Fprintf(bw, "digraph %s {\n\trankdir=LR;\n", dotQuote(b.Name))
+		for _, n := range nodes {
+			fmt.Fprintf(bw, "\t%s [label=%s, shape=%s];\n", dotQuote(n.ID), dotQuote(n.Label), shapes[n.Kind])
+		}
+		for _, e := range edges {
+			ends := edgeNodes(e)
+			fmt.Fprintf(bw, "\t%s -> %s [label=%s];\n", dotQuote(ends[0].ID), dotQuote(ends[1].ID),
+				dotQuote(fmt.Sprintf("%s (%d)", e.Kind, e.Count)))
+		}
+		fmt.Fprintln(bw, "}")
+	}
+	return bw.Flush()
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// WriteGraphML writes the behavior graphs of baselines as GraphML, one
+// directed graph per baseline. Node IDs are the baseline name and the
+// GraphNode ID, e.g. "web/process:nginx", as GraphML wants them unique
+// across the document. Nodes carry their kind and label; edges their kind,
+// count and first and last time seen (RFC 3339).
+func WriteGraphML(w io.Writer, baselines ...*baseline.Baseline) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(xml.Header)
+	bw.WriteString(`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">` + "\n")
+	for _, k := range []struct{ id, on, name, typ string }{
+		{"kind", "node", "kind", "string"},
+		{"label", "node", "label", "string"},
+		{"edge_kind", "edge", "kind", "string"},
+		{"count", "edge", "count", "long"},
+		{"first_seen", "edge", "first_seen", "string"},
+		{"last_seen", "edge", "last_seen", "string"},
+	} {
+		fmt.Fprintf(bw, "  <key id=%q for=%q attr.name=%q attr.type=%q/>\n", k.id, k.on, k.name, k.typ)
+	}
+	timestamp := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	edgeID := 0
+	for _, b := range baselines {
+		nodes, edges := GraphNodes(b)
+		id := func(n GraphNode) string { return xmlAttr(b.Name + "/" + n.ID) }
+		fmt.Fprintf(bw, "  <graph id=%s edgedefault=\"directed\">\n", xmlAttr(b.Name))
+		for _, n := range nodes {
+			fmt.Fprintf(bw, "    <node id=%s><data key=\"kind\">%s</data><data key=\"label\">%s</data></node>\n",
+				id(n), xmlText(n.Kind), xmlText(n.Label))
+		}
+		for _, e := range edges {
+			ends := edgeNodes(e)
+			edgeID++
+			fmt.Fprintf(bw, "    <edge id=\"e%d\" source=%s target=%s>", edgeID, id(ends[0]), id(ends[1]))
+			fmt.Fprintf(bw, "<data key=\"edge_kind\">%s</data><data key=\"count\">%d</data>", xmlText(e.Kind), e.Count)
+			fmt.Fprintf(bw, "<data key=\"first_seen\">%s</data><data key=\"last_seen\">%s</data></edge>\n",
+				timestamp(e.FirstSeen), timestamp(e.LastSeen))
+		}
+		bw.WriteString("  </graph>\n")
+	}
+	bw.WriteString("</graphml>\n")
+	return bw.Flush()
+}
+
+func xmlText(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func xmlAttr(s string) string {
+	return `"` + xmlText(s) + `"`
+}
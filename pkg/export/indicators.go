@@ -0,0 +1,111 @@
+// Package export converts runtimebase findings into formats consumed by
+// external security tooling.
+package export
+
+import (
+	"net"
+	"regexp"
+	"strings"
+)
+
+// IndicatorType identifies the kind of observable extracted from evidence.
+type IndicatorType string
+
+// Supported indicator types.
+const (
+	IndicatorIPv4   IndicatorType = "ipv4"
+	IndicatorIPv6   IndicatorType = "ipv6"
+	IndicatorDomain IndicatorType = "domain"
+	IndicatorMD5    IndicatorType = "md5"
+	IndicatorSHA1   IndicatorType = "sha1"
+	IndicatorSHA256 IndicatorType = "sha256"
+)
+
+// Indicator is an observable found in anomaly evidence.
+type Indicator struct {
+	Type  IndicatorType
+	Value string
+}
+
+var (
+	hashPattern   = regexp.MustCompile(`^[a-fA-F0-9]+$`)
+	domainPattern = regexp.MustCompile(`^(?i)([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+	splitPattern  = regexp.MustCompile(`[\s,;|"'()\[\]<>=]+`)
+)
+
+// ExtractIndicators finds IP addresses, domains and file hashes in text.
+// Duplicates are returned once, in order of first appearance.
+func ExtractIndicators(text string) []Indicator {
+	var indicators []Indicator
+	seen := make(map[Indicator]bool)
+
+	for _, token := range splitPattern.Split(text, -1) {
+		indicator, ok := classify(token)
+		if !ok || seen[indicator] {
+			continue
+		}
+		seen[indicator] = true
+		indicators = append(indicators, indicator)
+	}
+
+	return indicators
+}
+
+func classify(token string) (Indicator, bool) {
+	token = strings.Trim(token, ".:/")
+	if token == "" {
+		return Indicator{}, false
+	}
+
+	// Strip a URL scheme and path, and a trailing port.
+	if i := strings.Index(token, "://"); i >= 0 {
+		token = token[i+3:]
+	}
+	if i := strings.IndexByte(token, '/'); i >= 0 {
+		token = token[:i]
+	}
+	// Evidence is often keyed as category:value, optionally with a port.
+	for strings.Contains(token, ":") && net.ParseIP(token) == nil {
+		if host, _, err := net.SplitHostPort(token); err == nil {
+			token = host
+			continue
+		}
+		token = token[strings.IndexByte(token, ':')+1:]
+	}
+
+	if ip := net.ParseIP(token); ip != nil {
+		if ip.To4() != nil {
+			return Indicator{Type: IndicatorIPv4, Value: ip.String()}, true
+		}
+		return Indicator{Type: IndicatorIPv6, Value: ip.String()}, true
+	}
+
+	if hashPattern.MatchString(token) {
+		switch len(token) {
+		case 32:
+			return Indicator{Type: IndicatorMD5, Value: strings.ToLower(token)}, true
+		case 40:
+			return Indicator{Type: IndicatorSHA1, Value: strings.ToLower(token)}, true
+		case 64:
+			return Indicator{Type: IndicatorSHA256, Value: strings.ToLower(token)}, true
+		}
+		return Indicator{}, false
+	}
+
+	if domainPattern.MatchString(token) && !looksLikeFile(token) {
+		return Indicator{Type: IndicatorDomain, Value: strings.ToLower(token)}, true
+	}
+
+	return Indicator{}, false
+}
+
+// looksLikeFile filters out tokens such as "app.log" that are syntactically
+// valid domains but almost certainly file names.
+func looksLikeFile(token string) bool {
+	ext := token[strings.LastIndexByte(token, '.')+1:]
+	switch strings.ToLower(ext) {
+	case "log", "txt", "conf", "json", "yaml", "yml", "so", "sh", "py", "go", "tmp", "pid", "sock", "lock", "db":
+		return true
+	}
+	return false
+}
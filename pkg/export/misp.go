@@ -0,0 +1,111 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// MISPEvent is the top-level MISP event document.
+type MISPEvent struct {
+	Event MISPEventBody `json:"Event"`
+}
+
+// MISPEventBody holds MISP event fields.
+type MISPEventBody struct {
+	UUID          string          `json:"uuid"`
+	Info          string          `json:"info"`
+	Date          string          `json:"date"`
+	ThreatLevelID string          `json:"threat_level_id"`
+	Analysis      string          `json:"analysis"`
+	Distribution  string          `json:"distribution"`
+	Attribute     []MISPAttribute `json:"Attribute"`
+	Tag           []MISPTag       `json:"Tag,omitempty"`
+}
+
+// MISPAttribute is a single MISP attribute.
+type MISPAttribute struct {
+	UUID     string `json:"uuid"`
+	Type     string `json:"type"`
+	Category string `json:"category"`
+	Value    string `json:"value"`
+	ToIDS    bool   `json:"to_ids"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// MISPTag is a MISP tag.
+type MISPTag struct {
+	Name string `json:"name"`
+}
+
+// ToMISP converts anomalies into a single MISP event. The event threat level
+// follows the most severe anomaly.
+func ToMISP(info string, anomalies []baseline.Anomaly) MISPEvent {
+	event := MISPEventBody{
+		UUID:          newUUID(),
+		Info:          info,
+		Date:          time.Now().UTC().Format("2006-01-02"),
+		ThreatLevelID: "4",
+		Analysis:      "0",
+		Distribution:  "0",
+		Attribute:     []MISPAttribute{},
+		Tag:           []MISPTag{{Name: "tool:runtimebase"}},
+	}
+
+	for _, anomaly := range anomalies {
+		if level := mispThreatLevel(anomaly.Severity); level < event.ThreatLevelID {
+			event.ThreatLevelID = level
+		}
+
+		for _, indicator := range ExtractIndicators(anomaly.Evidence) {
+			attrType, category := mispType(indicator.Type)
+			event.Attribute = append(event.Attribute, MISPAttribute{
+				UUID:     newUUID(),
+				Type:     attrType,
+				Category: category,
+				Value:    indicator.Value,
+				ToIDS:    severity.AtLeast(anomaly.Severity, severity.High),
+				Comment:  fmt.Sprintf("%s (%s, confidence %.0f%%)", anomaly.Description, anomaly.Severity, anomaly.Confidence*100),
+			})
+		}
+	}
+
+	return MISPEvent{Event: event}
+}
+
+// WriteMISP returns the MISP event for anomalies as indented JSON.
+func WriteMISP(info string, anomalies []baseline.Anomaly) ([]byte, error) {
+	return json.MarshalIndent(ToMISP(info, anomalies), "", "  ")
+}
+
+// mispThreatLevel maps severities to MISP threat levels (1 high .. 4 undefined).
+func mispThreatLevel(name string) string {
+	switch {
+	case !severity.Known(name):
+		return "4"
+	case severity.AtLeast(name, severity.High):
+		return "1"
+	case severity.AtLeast(name, severity.Medium):
+		return "2"
+	}
+	return "3"
+}
+
+func mispType(t IndicatorType) (string, string) {
+	switch t {
+	case IndicatorIPv4, IndicatorIPv6:
+		return "ip-dst", "Network activity"
+	case IndicatorDomain:
+		return "domain", "Network activity"
+	case IndicatorMD5:
+		return "md5", "Payload delivery"
+	case IndicatorSHA1:
+		return "sha1", "Payload delivery"
+	case IndicatorSHA256:
+		return "sha256", "Payload delivery"
+	}
+	return "text", "Other"
+}
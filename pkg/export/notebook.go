@@ -0,0 +1,267 @@
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+// AnomalyRow is one anomaly of a baseline's history, flattened for
+// analysis next to its StatRows.
+type AnomalyRow struct {
+	Namespace   string
+	Baseline    string
+	Time        time.Time
+	Type        string
+	Severity    string
+	Category    string
+	Pattern     string
+	Evidence    string
+	Confidence  float64
+	Process     string
+	PID         int64
+	Description string
+	TraceID     string
+}
+
+// AnomalyColumns are the column names of the anomaly datasets, in order.
+var AnomalyColumns = []string{
+	"namespace", "baseline", "time", "type", "severity", "category", "pattern",
+	"evidence", "confidence", "process", "pid", "description", "trace_id",
+}
+
+// AnomalyRows flattens the anomalies of b into rows, in order.
+func AnomalyRows(b *baseline.Baseline, anomalies []baseline.Anomaly) []AnomalyRow {
+	rows := make([]AnomalyRow, len(anomalies))
+	for i, a := range anomalies {
+		category, pattern, _ := baseline.ParseStatKey(a.Evidence)
+		rows[i] = AnomalyRow{
+			Namespace:   b.Namespace,
+			Baseline:    b.Name,
+			Time:        a.Timestamp,
+			Type:        a.Type,
+			Severity:    a.Severity,
+			Category:    category,
+			Pattern:     pattern,
+			Evidence:    a.Evidence,
+			Confidence:  a.Confidence,
+			Process:     a.Process,
+			PID:         int64(a.PID),
+			Description: a.Description,
+			TraceID:     a.TraceID,
+		}
+	}
+	return rows
+}
+
+// WriteAnomaliesParquet writes rows as a Parquet file with the columns of
+// AnomalyColumns, time as a nullable millisecond timestamp.
+func WriteAnomaliesParquet(w io.Writer, rows []AnomalyRow) error {
+	str := func(get func(AnomalyRow) string) func(*parquetBuffer, AnomalyRow) bool {
+		return func(b *parquetBuffer, r AnomalyRow) bool { b.bytes(get(r)); return true }
+	}
+	columns := []parquetColumn[AnomalyRow]{
+		{"namespace", parquetByteArray, parquetUTF8, false, str(func(r AnomalyRow) string { return r.Namespace })},
+		{"baseline", parquetByteArray, parquetUTF8, false, str(func(r AnomalyRow) string { return r.Baseline })},
+		{"time", parquetInt64, parquetTimestampMillis, true, func(b *parquetBuffer, r AnomalyRow) bool {
+			if r.Time.IsZero() {
+				return false
+			}
+			b.int64(r.Time.UnixMilli())
+			return true
+		}},
+		{"type", parquetByteArray, parquetUTF8, false, str(func(r AnomalyRow) string { return r.Type })},
+		{"severity", parquetByteArray, parquetUTF8, false, str(func(r AnomalyRow) string { return r.Severity })},
+		{"category", parquetByteArray, parquetUTF8, false, str(func(r AnomalyRow) string { return r.Category })},
+		{"pattern", parquetByteArray, parquetUTF8, false, str(func(r AnomalyRow) string { return r.Pattern })},
+		{"evidence", parquetByteArray, parquetUTF8, false, str(func(r AnomalyRow) string { return r.Evidence })},
+		{"confidence", parquetDouble, noConvertedType, false, func(b *parquetBuffer, r AnomalyRow) bool {
+			b.double(r.Confidence)
+			return true
+		}},
+		{"process", parquetByteArray, parquetUTF8, false, str(func(r AnomalyRow) string { return r.Process })},
+		{"pid", parquetInt64, noConvertedType, false, func(b *parquetBuffer, r AnomalyRow) bool {
+			b.int64(r.PID)
+			return true
+		}},
+		{"description", parquetByteArray, parquetUTF8, false, str(func(r AnomalyRow) string { return r.Description })},
+		{"trace_id", parquetByteArray, parquetUTF8, false, str(func(r AnomalyRow) string { return r.TraceID })},
+	}
+	return writeParquet(w, columns, rows)
+}
+
+// WriteNotebook writes, into dir, the statistics and anomalies of b as
+// stats and anomalies datasets in Parquet or JSON, and explore.ipynb, a
+// Jupyter notebook loading them with pandas and charting the learned
+// distributions and the anomalies, as a starting point for exploring the
+// baseline in Python. It returns the names of the files written.
+func WriteNotebook(dir string, b *baseline.Baseline, anomalies []baseline.Anomaly, dataset string, now time.Time) ([]string, error) {
+	if dataset != "parquet" && dataset != "json" {
+		return nil, fmt.Errorf("unknown dataset format %q: want parquet or json", dataset)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	stats, rows := StatRows(b), AnomalyRows(b, anomalies)
+	files := []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{"stats." + dataset, func(w io.Writer) error {
+			if dataset == "json" {
+				return writeStatsJSON(w, stats)
+			}
+			return WriteStatsParquet(w, stats)
+		}},
+		{"anomalies." + dataset, func(w io.Writer) error {
+			if dataset == "json" {
+				return writeAnomaliesJSON(w, rows)
+			}
+			return WriteAnomaliesParquet(w, rows)
+		}},
+		{"explore.ipynb", func(w io.Writer) error {
+			return writeNotebook(w, b.Name, dataset, len(stats), len(rows), now)
+		}},
+	}
+	var written []string
+	for _, file := range files {
+		f, err := os.Create(filepath.Join(dir, file.name))
+		if err != nil {
+			return written, err
+		}
+		err = file.write(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return written, fmt.Errorf("%s: %w", file.name, err)
+		}
+		written = append(written, file.name)
+	}
+	return written, nil
+}
+
+// jsonTime is a time that encodes as null when zero, which pandas reads
+// as NaT.
+type jsonTime time.Time
+
+func (t jsonTime) MarshalJSON() ([]byte, error) {
+	if time.Time(t).IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(time.Time(t).UTC())
+}
+
+// writeStatsJSON writes rows as a JSON array of records keyed by
+// StatColumns.
+func writeStatsJSON(w io.Writer, rows []StatRow) error {
+	records := make([]map[string]any, len(rows))
+	for i, r := range rows {
+		records[i] = map[string]any{
+			"namespace": r.Namespace, "baseline": r.Baseline, "table": r.Table, "key": r.Key,
+			"category": r.Category, "pattern": r.Pattern, "labels": r.Labels,
+			"mean": r.Mean, "stddev": r.StdDev, "min": r.Min, "max": r.Max,
+			"sample_count": r.SampleCount, "m2": r.M2, "last_seen": jsonTime(r.LastSeen),
+			"seeded": r.Seeded, "threshold": r.Threshold, "direction": r.Direction,
+		}
+	}
+	return json.NewEncoder(w).Encode(records)
+}
+
+// writeAnomaliesJSON writes rows as a JSON array of records keyed by
+// AnomalyColumns.
+func writeAnomaliesJSON(w io.Writer, rows []AnomalyRow) error {
+	records := make([]map[string]any, len(rows))
+	for i, r := range rows {
+		records[i] = map[string]any{
+			"namespace": r.Namespace, "baseline": r.Baseline, "time": jsonTime(r.Time),
+			"type": r.Type, "severity": r.Severity, "category": r.Category, "pattern": r.Pattern,
+			"evidence": r.Evidence, "confidence": r.Confidence, "process": r.Process, "pid": r.PID,
+			"description": r.Description, "trace_id": r.TraceID,
+		}
+	}
+	return json.NewEncoder(w).Encode(records)
+}
+
+// writeNotebook writes the starter notebook over the datasets.
+func writeNotebook(w io.Writer, name, dataset string, stats, anomalies int, now time.Time) error {
+	load := `stats = pd.read_parquet("stats.parquet")
+anomalies = pd.read_parquet("anomalies.parquet")`
+	if dataset == "json" {
+		load = `stats = pd.read_json("stats.json", convert_dates=["last_seen"])
+anomalies = pd.read_json("anomalies.json", convert_dates=["time"])`
+	}
+	cells := []struct{ kind, source string }{
+		{"markdown", fmt.Sprintf("# Baseline `%s`\n\nExported by runtimebase on %s: %d learned statistics and %d anomalies from the baseline's history. "+
+			"`stats` has one row per learned distribution (`table` is `stats` for operation counts per interval, `volume` for bytes, `resource` for resource peaks); "+
+			"`anomalies` has one row per anomaly, its `evidence` being the statistic key it deviated from.",
+			name, now.UTC().Format("2006-01-02 15:04 MST"), stats, anomalies)},
+		{"code", "import pandas as pd\nimport matplotlib.pyplot as plt\n\n" + load + "\nstats.head()"},
+		{"markdown", "## Learned distributions"},
+		{"code", `stats.groupby(["table", "category"]).agg(keys=("key", "size"), median_mean=("mean", "median"), samples=("sample_count", "sum"))`},
+		{"code", `# Where each key starts alerting, and the noisiest keys by coefficient of variation.
+counts = stats[stats.table == "stats"].copy()
+counts["alert_above"] = counts["mean"] + counts["threshold"] * counts["stddev"]
+counts["cv"] = counts["stddev"] / counts["mean"].where(counts["mean"] > 0)
+counts.sort_values("cv", ascending=False)[["key", "mean", "stddev", "sample_count", "threshold", "alert_above"]].head(20)`},
+		{"code", `ax = counts["mean"].plot.hist(bins=50, log=True, title="Mean count per interval")
+ax.set_xlabel("mean count")
+plt.show()`},
+		{"markdown", "## Anomalies"},
+		{"code", `if anomalies.empty:
+    print("No anomalies recorded")
+else:
+    by_day = anomalies.groupby([pd.Grouper(key="time", freq="1D"), "severity"]).size().unstack(fill_value=0)
+    by_day.plot.bar(stacked=True, title="Anomalies per day")
+    plt.show()`},
+		{"code", `anomalies.groupby(["type", "evidence"]).size().sort_values(ascending=False).head(20)`},
+		{"code", `# Anomalies next to the distribution they deviated from.
+anomalies.merge(counts[["key", "mean", "stddev", "threshold", "alert_above"]], left_on="evidence", right_on="key", how="left").head(20)`},
+	}
+
+	notebook := struct {
+		Cells         []map[string]any `json:"cells"`
+		Metadata      any              `json:"metadata"`
+		NBFormat      int              `json:"nbformat"`
+		NBFormatMinor int              `json:"nbformat_minor"`
+	}{
+		Metadata: map[string]any{
+			"kernelspec":    map[string]string{"display_name": "Python 3", "language": "python", "name": "python3"},
+			"language_info": map[string]string{"name": "python"},
+		},
+		NBFormat:      4,
+		NBFormatMinor: 5,
+	}
+	for i, c := range cells {
+		cell := map[string]any{
+			"cell_type": c.kind,
+			"id":        fmt.Sprintf("cell-%d", i+1),
+			"metadata":  struct{}{},
+			"source":    notebookLines(c.source),
+		}
+		if c.kind == "code" {
+			cell["execution_count"] = nil
+			cell["outputs"] = []any{}
+		}
+		notebook.Cells = append(notebook.Cells, cell)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", " ")
+	return enc.Encode(notebook)
+}
+
+// notebookLines splits source into lines as notebooks store it, each but
+// the last keeping its newline.
+func notebookLines(source string) []string {
+	lines := strings.SplitAfter(source, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
@@ -0,0 +1,296 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// This file implements the subset of the Parquet format the exports need:
+// flat schemas of required or optional columns, PLAIN encoding, no
+// compression, one data page per column in a single row group. Metadata is
+// written with the Thrift compact protocol.
+
+// Parquet physical types.
+const (
+	parquetBoolean   int32 = 0
+	parquetInt64     int32 = 2
+	parquetDouble    int32 = 5
+	parquetByteArray int32 = 6
+)
+
+// Parquet converted types, or noConvertedType for none.
+const (
+	noConvertedType        int32 = -1
+	parquetUTF8            int32 = 0
+	parquetTimestampMillis int32 = 9
+)
+
+const (
+	parquetPlain        int32 = 0
+	parquetRLE          int32 = 3
+	parquetUncompressed int32 = 0
+	parquetDataPage     int32 = 0
+	parquetRequired     int32 = 0
+	parquetOptional     int32 = 1
+)
+
+var parquetMagic = []byte("PAR1")
+
+// parquetColumn describes one column. write appends the PLAIN encoding of
+// the row's value and returns false, appending nothing, for null.
+type parquetColumn[T any] struct {
+	name      string
+	physical  int32
+	converted int32
+	optional  bool
+	write     func(b *parquetBuffer, row T) bool
+}
+
+// parquetBuffer accumulates PLAIN-encoded values. Booleans are collected
+// and bit-packed when the page is finished.
+type parquetBuffer struct {
+	bytes.Buffer
+	bools []bool
+}
+
+func (b *parquetBuffer) bytes(s string) {
+	binary.Write(b, binary.LittleEndian, uint32(len(s)))
+	b.WriteString(s)
+}
+
+func (b *parquetBuffer) double(f float64) {
+	binary.Write(b, binary.LittleEndian, math.Float64bits(f))
+}
+
+func (b *parquetBuffer) int64(v int64) {
+	binary.Write(b, binary.LittleEndian, v)
+}
+
+func (b *parquetBuffer) boolean(v bool) {
+	b.bools = append(b.bools, v)
+}
+
+// values returns the encoded values, bit-packing booleans least
+// significant bit first.
+func (b *parquetBuffer) values() []byte {
+	if b.bools == nil {
+		return b.Bytes()
+	}
+	packed := make([]byte, (len(b.bools)+7)/8)
+	for i, v := range b.bools {
+		if v {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+	return packed
+}
+
+// writeParquet writes rows as a Parquet file with the given columns.
+func writeParquet[T any](w io.Writer, columns []parquetColumn[T], rows []T) error {
+	var file bytes.Buffer
+	file.Write(parquetMagic)
+
+	type chunk struct {
+		offset, size int64
+		nonNull      int
+	}
+	chunks := make([]chunk, len(columns))
+	for i, col := range columns {
+		var values parquetBuffer
+		present := make([]bool, len(rows))
+		nonNull := 0
+		for j, row := range rows {
+			if present[j] = col.write(&values, row); present[j] {
+				nonNull++
+			}
+		}
+		var page bytes.Buffer
+		if col.optional {
+			levels := definitionLevels(present)
+			binary.Write(&page, binary.LittleEndian, uint32(len(levels)))
+			page.Write(levels)
+		}
+		page.Write(values.values())
+
+		var header thriftWriter
+		header.i32(1, parquetDataPage)
+		header.i32(2, int32(page.Len()))
+		header.i32(3, int32(page.Len()))
+		header.beginStruct(5)
+		header.i32(1, int32(len(rows)))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.endStruct()
+		header.stop()
+
+		chunks[i] = chunk{offset: int64(file.Len()), size: int64(header.Len() + page.Len()), nonNull: nonNull}
+		file.Write(header.Bytes())
+		file.Write(page.Bytes())
+	}
+
+	var meta thriftWriter
+	meta.i32(1, 1)
+	meta.list(2, thriftStruct, len(columns)+1)
+	meta.beginElement()
+	meta.str(4, "schema")
+	meta.i32(5, int32(len(columns)))
+	meta.endElement()
+	for _, col := range columns {
+		meta.beginElement()
+		meta.i32(1, col.physical)
+		repetition := parquetRequired
+		if col.optional {
+			repetition = parquetOptional
+		}
+		meta.i32(3, repetition)
+		meta.str(4, col.name)
+		if col.converted != noConvertedType {
+			meta.i32(6, col.converted)
+		}
+		meta.endElement()
+	}
+	meta.i64(3, int64(len(rows)))
+	meta.list(4, thriftStruct, 1)
+	meta.beginElement()
+	meta.list(1, thriftStruct, len(columns))
+	var total int64
+	for i, col := range columns {
+		c := chunks[i]
+		total += c.size
+		meta.beginElement()
+		meta.i64(2, c.offset)
+		meta.beginStruct(3)
+		meta.i32(1, col.physical)
+		meta.list(2, thriftI32, 2)
+		meta.varint(int64(parquetPlain))
+		meta.varint(int64(parquetRLE))
+		meta.list(3, thriftBinary, 1)
+		meta.binary(col.name)
+		meta.i32(4, parquetUncompressed)
+		meta.i64(5, int64(len(rows)))
+		meta.i64(6, c.size)
+		meta.i64(7, c.size)
+		meta.i64(9, c.offset)
+		meta.endStruct()
+		meta.endElement()
+	}
+	meta.i64(2, total)
+	meta.i64(3, int64(len(rows)))
+	meta.endElement()
+	meta.str(6, "runtimebase")
+	meta.stop()
+
+	file.Write(meta.Bytes())
+	binary.Write(&file, binary.LittleEndian, uint32(meta.Len()))
+	file.Write(parquetMagic)
+	_, err := w.Write(file.Bytes())
+	return err
+}
+
+// definitionLevels encodes whether each value is present with the
+// RLE/bit-packing hybrid at bit width 1, as runs of equal levels.
+func definitionLevels(present []bool) []byte {
+	var out []byte
+	for i := 0; i < len(present); {
+		j := i
+		for j < len(present) && present[j] == present[i] {
+			j++
+		}
+		out = binary.AppendUvarint(out, uint64(j-i)<<1)
+		if present[i] {
+			out = append(out, 1)
+		} else {
+			out = append(out, 0)
+		}
+		i = j
+	}
+	return out
+}
+
+// Thrift compact protocol types.
+const (
+	thriftI32    byte = 5
+	thriftI64    byte = 6
+	thriftBinary byte = 8
+	thriftList   byte = 9
+	thriftStruct byte = 12
+)
+
+// thriftWriter encodes structs with the Thrift compact protocol. Fields
+// must be written in increasing id order within each struct.
+type thriftWriter struct {
+	bytes.Buffer
+	last  int16
+	stack []int16
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.last; delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.WriteByte(typ)
+		t.varint(int64(id))
+	}
+	t.last = id
+}
+
+// varint writes a zigzag-encoded variable-length integer.
+func (t *thriftWriter) varint(v int64) {
+	t.Write(binary.AppendUvarint(nil, uint64(v<<1)^uint64(v>>63)))
+}
+
+func (t *thriftWriter) binary(s string) {
+	t.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	t.WriteString(s)
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) str(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.binary(s)
+}
+
+// list writes the header of a list field of n elements; the elements
+// follow, structs between beginElement and endElement.
+func (t *thriftWriter) list(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.WriteByte(byte(n)<<4 | elem)
+		return
+	}
+	t.WriteByte(0xf0 | elem)
+	t.Write(binary.AppendUvarint(nil, uint64(n)))
+}
+
+func (t *thriftWriter) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.beginElement()
+}
+
+func (t *thriftWriter) endStruct() { t.endElement() }
+
+func (t *thriftWriter) beginElement() {
+	t.stack = append(t.stack, t.last)
+	t.last = 0
+}
+
+func (t *thriftWriter) endElement() {
+	t.stop()
+	t.last = t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+// stop ends the current struct.
+func (t *thriftWriter) stop() { t.WriteByte(0) }
@@ -0,0 +1,305 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+// thriftReader decodes the Thrift compact protocol into maps of field id
+// to value: int64 for integers, string for binary, []any for lists and
+// map[int16]any for structs.
+type thriftReader struct {
+	*bytes.Reader
+}
+
+func (t thriftReader) uvarint() uint64 {
+	v, err := binary.ReadUvarint(t)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func (t thriftReader) zigzag() int64 {
+	v := t.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (t thriftReader) byte() byte {
+	b, err := t.ReadByte()
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func (t thriftReader) structure() map[int16]any {
+	fields := make(map[int16]any)
+	var last int16
+	for {
+		b := t.byte()
+		if b == 0 {
+			return fields
+		}
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			id = int16(t.zigzag())
+		}
+		fields[id] = t.value(b & 0x0f)
+		last = id
+	}
+}
+
+func (t thriftReader) value(typ byte) any {
+	switch typ {
+	case 1, 2: // boolean true, false
+		return typ == 1
+	case 3:
+		return int64(t.byte())
+	case 4, 5, 6: // i16, i32, i64
+		return t.zigzag()
+	case 7:
+		var f float64
+		binary.Read(t, binary.LittleEndian, &f)
+		return f
+	case thriftBinary:
+		s := make([]byte, t.uvarint())
+		io.ReadFull(t, s)
+		return string(s)
+	case thriftList:
+		header := t.byte()
+		n := uint64(header >> 4)
+		if n == 15 {
+			n = t.uvarint()
+		}
+		list := make([]any, n)
+		for i := range list {
+			list[i] = t.value(header & 0x0f)
+		}
+		return list
+	case thriftStruct:
+		return t.structure()
+	}
+	panic(fmt.Sprintf("unexpected thrift type %d", typ))
+}
+
+type parquetSchemaElement struct {
+	name       string
+	physical   int64
+	repetition int64
+	converted  int64 // -1 for none
+}
+
+// readParquet decodes a file written by writeParquet: its schema and the
+// values of each column, nil for nulls.
+func readParquet(t *testing.T, data []byte) ([]parquetSchemaElement, [][]any) {
+	t.Helper()
+	if !bytes.HasPrefix(data, parquetMagic) || !bytes.HasSuffix(data, parquetMagic) {
+		t.Fatal("missing Parquet magic")
+	}
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	meta := thriftReader{bytes.NewReader(data[len(data)-8-size : len(data)-8])}.structure()
+	if meta[1] != int64(1) || meta[6] != "runtimebase" {
+		t.Errorf("unexpected file metadata version %v, created by %v", meta[1], meta[6])
+	}
+	rows := int(meta[3].(int64))
+
+	elements := meta[2].([]any)
+	root := elements[0].(map[int16]any)
+	if root[4] != "schema" || root[5] != int64(len(elements)-1) {
+		t.Fatalf("unexpected schema root %v", root)
+	}
+	var schema []parquetSchemaElement
+	for _, e := range elements[1:] {
+		fields := e.(map[int16]any)
+		element := parquetSchemaElement{
+			name:       fields[4].(string),
+			physical:   fields[1].(int64),
+			repetition: fields[3].(int64),
+			converted:  -1,
+		}
+		if c, ok := fields[6]; ok {
+			element.converted = c.(int64)
+		}
+		schema = append(schema, element)
+	}
+
+	groups := meta[4].([]any)
+	if len(groups) != 1 {
+		t.Fatalf("expected one row group, got %d", len(groups))
+	}
+	group := groups[0].(map[int16]any)
+	if group[3] != int64(rows) {
+		t.Errorf("row group has %v rows, file %d", group[3], rows)
+	}
+	chunks := group[1].([]any)
+	if len(chunks) != len(schema) {
+		t.Fatalf("%d column chunks for %d columns", len(chunks), len(schema))
+	}
+
+	columns := make([][]any, len(schema))
+	for i, c := range chunks {
+		column := c.(map[int16]any)[3].(map[int16]any)
+		if column[3].([]any)[0] != schema[i].name || column[1] != schema[i].physical || column[5] != int64(rows) {
+			t.Errorf("column chunk %d does not match its schema: %v", i, column)
+		}
+		offset := column[9].(int64)
+		r := thriftReader{bytes.NewReader(data[offset:])}
+		header := r.structure()
+		page := header[5].(map[int16]any)
+		if header[1] != int64(parquetDataPage) || page[1] != int64(rows) || page[2] != int64(parquetPlain) {
+			t.Fatalf("unexpected page header %v", header)
+		}
+		start := int(offset) + len(data[offset:]) - r.Len()
+		body := data[start : start+int(header[3].(int64))]
+		if int64(len(body)+start-int(offset)) != column[6] {
+			t.Errorf("column %s: chunk size %v, page ends at %d", schema[i].name, column[6], len(body)+start-int(offset))
+		}
+		columns[i] = readPage(t, schema[i], body, rows)
+	}
+	return schema, columns
+}
+
+// readPage decodes a PLAIN page of n values, led by RLE definition levels
+// for optional columns.
+func readPage(t *testing.T, element parquetSchemaElement, page []byte, n int) []any {
+	t.Helper()
+	present := make([]bool, 0, n)
+	r := bytes.NewReader(page)
+	if element.repetition == int64(parquetOptional) {
+		var size uint32
+		binary.Read(r, binary.LittleEndian, &size)
+		levels := thriftReader{bytes.NewReader(page[4 : 4+size])}
+		for levels.Len() > 0 {
+			header := levels.uvarint()
+			if header&1 != 0 {
+				t.Fatalf("column %s: unexpected bit-packed definition levels", element.name)
+			}
+			level := levels.byte()
+			for j := uint64(0); j < header>>1; j++ {
+				present = append(present, level == 1)
+			}
+		}
+		r.Seek(int64(size), io.SeekCurrent)
+	} else {
+		for len(present) < n {
+			present = append(present, true)
+		}
+	}
+	if len(present) != n {
+		t.Fatalf("column %s: %d definition levels for %d rows", element.name, len(present), n)
+	}
+
+	values := make([]any, n)
+	for i := range values {
+		if !present[i] {
+			continue
+		}
+		switch element.physical {
+		case int64(parquetByteArray):
+			var size uint32
+			binary.Read(r, binary.LittleEndian, &size)
+			s := make([]byte, size)
+			io.ReadFull(r, s)
+			values[i] = string(s)
+		case int64(parquetInt64):
+			var v int64
+			binary.Read(r, binary.LittleEndian, &v)
+			values[i] = v
+		case int64(parquetDouble):
+			var bits uint64
+			binary.Read(r, binary.LittleEndian, &bits)
+			values[i] = math.Float64frombits(bits)
+		case int64(parquetBoolean):
+			values[i] = page[len(page)-r.Len()+i/8]>>(i%8)&1 == 1
+		}
+	}
+	if element.physical == int64(parquetBoolean) {
+		r.Seek(int64((n+7)/8), io.SeekCurrent)
+	}
+	if r.Len() != 0 {
+		t.Errorf("column %s: %d bytes left over", element.name, r.Len())
+	}
+	return values
+}
+
+func TestStatsParquetRoundTrip(t *testing.T) {
+	b := baseline.NewLearner().CreateBaseline("web")
+	b.Namespace = "team-a"
+	b.AnomalyThreshold = 3
+	seen := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 11; i++ {
+		stat := baseline.Stat{Mean: float64(i) + 0.5, StdDev: 1.25, Min: -1, Max: float64(10 * i), SampleCount: i + 1, M2: 3.5, Seeded: i%3 == 0}
+		if i%2 == 0 {
+			stat.LastSeen = seen.Add(time.Duration(i) * time.Minute)
+		}
+		b.Stats[fmt.Sprintf("syscall:call%02d{env=prod}", i)] = stat
+	}
+	b.Thresholds = map[string]float64{"syscall:call03{env=prod}": 4.5}
+	rows := StatRows(b)
+
+	var out bytes.Buffer
+	if err := WriteStatsParquet(&out, rows); err != nil {
+		t.Fatal(err)
+	}
+	schema, columns := readParquet(t, out.Bytes())
+
+	if len(schema) != len(StatColumns) {
+		t.Fatalf("schema has %d columns, want %d", len(schema), len(StatColumns))
+	}
+	for i, element := range schema {
+		if element.name != StatColumns[i] {
+			t.Errorf("column %d is %q, want %q", i, element.name, StatColumns[i])
+		}
+		want := parquetSchemaElement{name: element.name, repetition: int64(parquetRequired), converted: -1}
+		switch element.name {
+		case "mean", "stddev", "min", "max", "m2", "threshold":
+			want.physical = int64(parquetDouble)
+		case "sample_count":
+			want.physical = int64(parquetInt64)
+		case "last_seen":
+			want.physical, want.repetition, want.converted = int64(parquetInt64), int64(parquetOptional), int64(parquetTimestampMillis)
+		case "seeded":
+			want.physical = int64(parquetBoolean)
+		default:
+			want.physical, want.converted = int64(parquetByteArray), int64(parquetUTF8)
+		}
+		if element != want {
+			t.Errorf("schema element %+v, want %+v", element, want)
+		}
+	}
+
+	got := make([]StatRow, len(columns[0]))
+	for i := range got {
+		r := &got[i]
+		v := func(name string) any {
+			for c, element := range schema {
+				if element.name == name {
+					return columns[c][i]
+				}
+			}
+			t.Fatalf("no column %s", name)
+			return nil
+		}
+		r.Namespace, r.Baseline, r.Table = v("namespace").(string), v("baseline").(string), v("table").(string)
+		r.Key, r.Category, r.Pattern, r.Labels = v("key").(string), v("category").(string), v("pattern").(string), v("labels").(string)
+		r.Mean, r.StdDev, r.Min, r.Max = v("mean").(float64), v("stddev").(float64), v("min").(float64), v("max").(float64)
+		r.SampleCount, r.M2 = v("sample_count").(int64), v("m2").(float64)
+		if ms, ok := v("last_seen").(int64); ok {
+			r.LastSeen = time.UnixMilli(ms).UTC()
+		}
+		r.Seeded, r.Threshold = v("seeded").(bool), v("threshold").(float64)
+		r.Direction = baseline.Direction(v("direction").(string))
+	}
+	if !reflect.DeepEqual(got, rows) {
+		t.Errorf("decoded rows differ:\n got %+v\nwant %+v", got, rows)
+	}
+}
@@ -0,0 +1,165 @@
+package export
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+// RegoRoot is the data path baselines are exported under in Rego bundles:
+// data.runtimebase.
+const RegoRoot = "runtimebase"
+
+// RegoBaseline is the Rego data document of a baseline: the behaviors it
+// learned as sets, so policies can look them up in constant time.
+type RegoBaseline struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace,omitempty"`
+	// Behaviors are the category:pattern keys learned, without labels,
+	// e.g. "process:/usr/sbin/nginx" or "network:10.0.0.9:443", plus
+	// "process:<path>" for binaries whose content hashes were learned.
+	Behaviors map[string]bool `json:"behaviors"`
+	// Categories lists the patterns learned per category, e.g. the
+	// destinations under "network".
+	Categories map[string][]string `json:"categories"`
+	// Edges are the behavior graph edges learned, by key, e.g.
+	// "spawn:nginx->php-fpm".
+	Edges     map[string]bool `json:"edges"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// RegoData builds the Rego data document of b.
+func RegoData(b *baseline.Baseline) RegoBaseline {
+	doc := RegoBaseline{
+		Name:       b.Name,
+		Namespace:  b.Namespace,
+		Behaviors:  make(map[string]bool),
+		Categories: make(map[string][]string),
+		Edges:      make(map[string]bool, len(b.Graph)),
+		UpdatedAt:  b.UpdatedAt,
+	}
+	add := func(category, pattern string) {
+		key := category + ":" + pattern
+		if !doc.Behaviors[key] {
+			doc.Behaviors[key] = true
+			doc.Categories[category] = append(doc.Categories[category], pattern)
+		}
+	}
+	for key := range b.Stats {
+		category, pattern, _ := baseline.ParseStatKey(key)
+		add(category, pattern)
+	}
+	for path := range b.Integrity {
+		add("process", path)
+	}
+	for _, patterns := range doc.Categories {
+		sort.Strings(patterns)
+	}
+	for key := range b.Graph {
+		doc.Edges[key] = true
+	}
+	return doc
+}
+
+// RegoPolicy is the policy template of Rego bundles. Its input names a
+// baseline and the behavior to decide on, either as a key or as a
+// category and pattern, or as a behavior graph edge:
+//
+//	{"baseline": "web", "key": "process:/bin/sh"}
+//	{"baseline": "web", "category": "network", "pattern": "10.0.0.9:443"}
+//	{"baseline": "web", "edge": "spawn:nginx->sh"}
+//
+// data.runtimebase.allow is true only for behaviors present in the
+// baseline, and data.runtimebase.deny holds the reasons for denying the
+// others; unknown baselines deny everything.
+const RegoPolicy = `# Generated by runtimebase: allow only behaviors present in a baseline.
+package runtimebase
+
+import rego.v1
+
+default allow := false
+
+baseline := data.runtimebase.baselines[input.baseline]
+
+behavior := input.key if input.key
+
+behavior := concat(":", [input.category, input.pattern]) if {
+	not input.key
+	input.category
+}
+
+allow if baseline.behaviors[behavior]
+
+allow if baseline.edges[input.edge]
+
+deny contains msg if {
+	not baseline
+	msg := sprintf("no baseline named %q", [input.baseline])
+}
+
+deny contains msg if {
+	baseline
+	not allow
+	input.edge
+	msg := sprintf("edge %s is not in baseline %s", [input.edge, input.baseline])
+}
+
+deny contains msg if {
+	baseline
+	not allow
+	behavior
+	msg := sprintf("%s is not in baseline %s", [behavior, input.baseline])
+}
+`
+
+// WriteRegoBundle writes an OPA bundle (a gzipped tarball) of baselines:
+// a .manifest claiming the runtimebase root, the data document of each
+// baseline at runtimebase/baselines/<name>/data.json, so it is
+// data.runtimebase.baselines[name], and RegoPolicy as
+// runtimebase/policy.rego. Admission controllers and gateways running OPA
+// can load it with --bundle or from a bundle server.
+func WriteRegoBundle(w io.Writer, baselines ...*baseline.Baseline) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	file := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: now}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	manifest, err := json.Marshal(map[string]any{"roots": []string{RegoRoot}})
+	if err != nil {
+		return err
+	}
+	if err := file(".manifest", manifest); err != nil {
+		return err
+	}
+	if err := file(RegoRoot+"/policy.rego", []byte(RegoPolicy)); err != nil {
+		return err
+	}
+	for _, b := range baselines {
+		var data bytes.Buffer
+		enc := json.NewEncoder(&data)
+		enc.SetEscapeHTML(false) // keep edges such as "spawn:nginx->sh" readable
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(RegoData(b)); err != nil {
+			return err
+		}
+		if err := file(RegoRoot+"/baselines/"+b.Name+"/data.json", data.Bytes()); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,264 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+// SELinuxRule is an allow rule suggested for a workload's domain by the
+// behavior its baseline learned.
+type SELinuxRule struct {
+	Type  string // target type, guessed from paths or ports
+	Class string // object class: file, dir or tcp_socket
+	Perms []string
+	// Sources are the paths or destinations the rule covers, sorted.
+	Sources []string
+}
+
+// selinuxPathTypes maps path prefixes to the types the reference policy
+// usually labels them with, most specific first.
+var selinuxPathTypes = []struct{ prefix, typ string }{
+	{"/etc/shadow", "shadow_t"},
+	{"/etc/passwd", "passwd_file_t"},
+	{"/etc/group", "passwd_file_t"},
+	{"/etc/resolv.conf", "net_conf_t"},
+	{"/etc/hosts", "net_conf_t"},
+	{"/etc/pki", "cert_t"},
+	{"/etc/ssl", "cert_t"},
+	{"/etc", "etc_t"},
+	{"/usr/local/bin", "bin_t"},
+	{"/usr/local/sbin", "bin_t"},
+	{"/usr/local/lib", "lib_t"},
+	{"/usr/bin", "bin_t"},
+	{"/usr/sbin", "bin_t"},
+	{"/usr/lib", "lib_t"},
+	{"/usr/lib64", "lib_t"},
+	{"/usr/libexec", "bin_t"},
+	{"/usr", "usr_t"},
+	{"/bin", "bin_t"},
+	{"/sbin", "bin_t"},
+	{"/lib", "lib_t"},
+	{"/lib64", "lib_t"},
+	{"/opt", "usr_t"},
+	{"/tmp", "tmp_t"},
+	{"/var/tmp", "tmp_t"},
+	{"/var/log", "var_log_t"},
+	{"/var/lib", "var_lib_t"},
+	{"/var/run", "var_run_t"},
+	{"/var/cache", "var_t"},
+	{"/var", "var_t"},
+	{"/run", "var_run_t"},
+	{"/proc", "proc_t"},
+	{"/sys", "sysfs_t"},
+	{"/dev/shm", "tmpfs_t"},
+	{"/dev", "device_t"},
+	{"/home", "user_home_t"},
+	{"/root", "admin_home_t"},
+}
+
+// selinuxPortTypes maps well-known TCP ports to their port types.
+var selinuxPortTypes = map[int]string{
+	22: "ssh_port_t", 25: "smtp_port_t", 53: "dns_port_t",
+	80: "http_port_t", 443: "http_port_t", 8443: "http_port_t",
+	389: "ldap_port_t", 636: "ldap_port_t", 465: "smtp_port_t", 587: "smtp_port_t",
+	3306: "mysqld_port_t", 5432: "postgresql_port_t", 6379: "redis_port_t",
+	8080: "http_cache_port_t", 11211: "memcache_port_t", 27017: "mongod_port_t",
+}
+
+// SELinuxPathType guesses the type of a file from its path, or returns
+// default_t for paths outside the usual hierarchy.
+func SELinuxPathType(path string) string {
+	for _, p := range selinuxPathTypes {
+		if path == p.prefix || strings.HasPrefix(path, p.prefix+"/") {
+			return p.typ
+		}
+	}
+	return "default_t"
+}
+
+// SELinuxPortType returns the type of a TCP port: its well-known type, or
+// reserved_port_t below 1024 and unreserved_port_t above.
+func SELinuxPortType(port int) string {
+	if t, ok := selinuxPortTypes[port]; ok {
+		return t
+	}
+	if port < 1024 {
+		return "reserved_port_t"
+	}
+	return "unreserved_port_t"
+}
+
+// SELinuxDomain returns the domain type suggested for a baseline, e.g.
+// "web_t" for "web".
+func SELinuxDomain(name string) string {
+	domain := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		}
+		return '_'
+	}, name)
+	if domain == "" || domain[0] < 'a' || domain[0] > 'z' {
+		domain = "rb_" + domain
+	}
+	return domain + "_t"
+}
+
+var (
+	selinuxRead  = []string{"getattr", "open", "read"}
+	selinuxWrite = []string{"append", "create", "getattr", "open", "read", "write"}
+	selinuxExec  = []string{"execute", "execute_no_trans", "getattr", "map", "open", "read"}
+	selinuxDirs  = []string{"getattr", "search"}
+	selinuxAdd   = []string{"add_name", "getattr", "search", "write"}
+)
+
+// SELinuxRules derives allow rules from the behavior b learned: reads of
+// the files it has statistics or volumes for, writes of those its
+// behavior graph or file.write keys record, executions of its process
+// keys and integrity hashes, and connections to its network
+// destinations. Rules are merged per type and class, and sorted.
+func SELinuxRules(b *baseline.Baseline) []SELinuxRule {
+	type target struct{ typ, class string }
+	perms := make(map[target]map[string]bool)
+	sources := make(map[target]map[string]bool)
+	add := func(typ, class, source string, ps []string) {
+		t := target{typ, class}
+		if perms[t] == nil {
+			perms[t], sources[t] = make(map[string]bool), make(map[string]bool)
+		}
+		for _, p := range ps {
+			perms[t][p] = true
+		}
+		if source != "" {
+			sources[t][source] = true
+		}
+	}
+	file := func(path string, ps, dir []string) {
+		if !strings.HasPrefix(path, "/") {
+			return
+		}
+		typ := SELinuxPathType(path)
+		add(typ, "file", path, ps)
+		add(typ, "dir", "", dir)
+	}
+	connect := func(dest string) {
+		_, port, err := net.SplitHostPort(dest)
+		if n, perr := strconv.Atoi(port); err == nil && perr == nil {
+			add(SELinuxPortType(n), "tcp_socket", dest, []string{"name_connect"})
+		}
+	}
+
+	for _, table := range []map[string]baseline.Stat{b.Stats, b.Volume} {
+		for key := range table {
+			category, pattern, _ := baseline.ParseStatKey(key)
+			switch category {
+			case "file", "file.read":
+				file(pattern, selinuxRead, selinuxDirs)
+			case "file.write":
+				file(pattern, selinuxWrite, selinuxAdd)
+			case "process":
+				file(pattern, selinuxExec, selinuxDirs)
+			case "network":
+				connect(pattern)
+			}
+		}
+	}
+	for path := range b.Integrity {
+		file(path, selinuxExec, selinuxDirs)
+	}
+	for _, e := range b.Graph {
+		switch e.Kind {
+		case baseline.EdgeWrite:
+			file(e.To, selinuxWrite, selinuxAdd)
+		case baseline.EdgeConnect:
+			connect(e.To)
+		}
+	}
+
+	rules := make([]SELinuxRule, 0, len(perms))
+	for t, ps := range perms {
+		rules = append(rules, SELinuxRule{Type: t.typ, Class: t.class, Perms: sortedKeys(ps), Sources: sortedKeys(sources[t])})
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Type != rules[j].Type {
+			return rules[i].Type < rules[j].Type
+		}
+		return rules[i].Class < rules[j].Class
+	})
+	return rules
+}
+
+func sortedKeys[V any](set map[string]V) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// maxSELinuxSources bounds the sources listed above a rule.
+const maxSELinuxSources = 5
+
+// WriteSELinuxHints writes the rules SELinuxRules derives from b as a type
+// enforcement module for domain in the style of audit2allow, each rule
+// preceded by the paths or destinations it covers. The target types are
+// guesses to review against the labels on the host (ls -Z, semanage port
+// -l) before building the module with checkmodule and semodule_package.
+func WriteSELinuxHints(w io.Writer, b *baseline.Baseline, domain string) error {
+	rules := SELinuxRules(b)
+	types := map[string]bool{domain: true}
+	classes := make(map[string]map[string]bool)
+	for _, r := range rules {
+		types[r.Type] = true
+		if classes[r.Class] == nil {
+			classes[r.Class] = make(map[string]bool)
+		}
+		for _, p := range r.Perms {
+			classes[r.Class][p] = true
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# SELinux policy hints derived from the behavior baseline %q learned.\n", b.Name)
+	fmt.Fprintf(bw, "# Types are guessed from paths and ports: check them with ls -Z and\n")
+	fmt.Fprintf(bw, "# semanage port -l, and grant only what the workload needs.\n\n")
+	fmt.Fprintf(bw, "module runtimebase_%s 1.0;\n\nrequire {\n", strings.TrimSuffix(domain, "_t"))
+	for _, t := range sortedKeys(types) {
+		fmt.Fprintf(bw, "\ttype %s;\n", t)
+	}
+	for _, c := range sortedKeys(classes) {
+		fmt.Fprintf(bw, "\tclass %s %s;\n", c, permSet(sortedKeys(classes[c])))
+	}
+	fmt.Fprintf(bw, "}\n\n#============= %s ==============\n", domain)
+	for _, r := range rules {
+		bw.WriteString("\n")
+		if len(r.Sources) > 0 {
+			listed := r.Sources[:min(len(r.Sources), maxSELinuxSources)]
+			fmt.Fprintf(bw, "# %s", strings.Join(listed, ", "))
+			if more := len(r.Sources) - len(listed); more > 0 {
+				fmt.Fprintf(bw, " and %d more", more)
+			}
+			bw.WriteString("\n")
+		}
+		fmt.Fprintf(bw, "allow %s %s:%s %s;\n", domain, r.Type, r.Class, permSet(r.Perms))
+	}
+	return bw.Flush()
+}
+
+// permSet formats permissions as SELinux does: one bare, several braced.
+func permSet(perms []string) string {
+	if len(perms) == 1 {
+		return perms[0]
+	}
+	return "{ " + strings.Join(perms, " ") + " }"
+}
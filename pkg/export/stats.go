@@ -0,0 +1,265 @@
+package export
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+// StatRow is one learned distribution of a baseline, flattened for
+// analysis in tools such as pandas or Spark.
+type StatRow struct {
+	Namespace string
+	Baseline  string
+	// Table is the map the statistic is kept in: "stats" for operation
+	// counts, "volume" for byte volumes, "resource" for resource peaks.
+	Table       string
+	Key         string
+	Category    string
+	Pattern     string
+	Labels      string // name=value pairs, comma-separated and sorted
+	Mean        float64
+	StdDev      float64
+	Min         float64
+	Max         float64
+	SampleCount int64
+	M2          float64
+	LastSeen    time.Time // zero when never observed
+	Seeded      bool
+	// Threshold is the anomaly threshold in standard deviations applying
+	// to the key: its override, or the baseline's. Edited exports can be
+	// read back with ReadThresholdsCSV.
+	Threshold float64
+	// Direction is the direction in which the key alerts: both, up or
+	// down.
+	Direction baseline.Direction
+}
+
+// StatColumns are the column names of the CSV and Parquet exports, in
+// order.
+var StatColumns = []string{
+	"namespace", "baseline", "table", "key", "category", "pattern", "labels",
+	"mean", "stddev", "min", "max", "sample_count", "m2", "last_seen", "seeded",
+	"threshold", "direction",
+}
+
+// StatRows flattens the statistics of baselines into rows, ordered by
+// baseline, table and key.
+func StatRows(baselines ...*baseline.Baseline) []StatRow {
+	var rows []StatRow
+	for _, b := range baselines {
+		for _, table := range []struct {
+			name  string
+			stats map[string]baseline.Stat
+		}{{"stats", b.Stats}, {"volume", b.Volume}, {"resource", b.Resource}} {
+			keys := make([]string, 0, len(table.stats))
+			for key := range table.stats {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				stat := table.stats[key]
+				category, pattern, labels := baseline.ParseStatKey(key)
+				rows = append(rows, StatRow{
+					Namespace:   b.Namespace,
+					Baseline:    b.Name,
+					Table:       table.name,
+					Key:         key,
+					Category:    category,
+					Pattern:     pattern,
+					Labels:      joinLabels(labels),
+					Mean:        stat.Mean,
+					StdDev:      stat.StdDev,
+					Min:         stat.Min,
+					Max:         stat.Max,
+					SampleCount: int64(stat.SampleCount),
+					M2:          stat.M2,
+					LastSeen:    stat.LastSeen,
+					Seeded:      stat.Seeded,
+					Threshold:   b.ThresholdFor(key),
+					Direction:   b.DirectionFor(key),
+				})
+			}
+		}
+	}
+	return rows
+}
+
+func joinLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// WriteStatsCSV writes rows as CSV with a header of StatColumns. Times are
+// RFC 3339 and empty when zero.
+func WriteStatsCSV(w io.Writer, rows []StatRow) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(StatColumns); err != nil {
+		return err
+	}
+	float := func(f float64) string { return strconv.FormatFloat(f, 'g', -1, 64) }
+	for _, r := range rows {
+		lastSeen := ""
+		if !r.LastSeen.IsZero() {
+			lastSeen = r.LastSeen.UTC().Format(time.RFC3339Nano)
+		}
+		err := cw.Write([]string{
+			r.Namespace, r.Baseline, r.Table, r.Key, r.Category, r.Pattern, r.Labels,
+			float(r.Mean), float(r.StdDev), float(r.Min), float(r.Max),
+			strconv.FormatInt(r.SampleCount, 10), float(r.M2), lastSeen,
+			strconv.FormatBool(r.Seeded), float(r.Threshold), string(r.Direction),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteStatsParquet writes rows as a Parquet file with the columns of
+// StatColumns: strings as UTF-8 byte arrays, last_seen as a nullable
+// millisecond timestamp, in one uncompressed row group.
+func WriteStatsParquet(w io.Writer, rows []StatRow) error {
+	str := func(get func(StatRow) string) func(*parquetBuffer, StatRow) bool {
+		return func(b *parquetBuffer, r StatRow) bool { b.bytes(get(r)); return true }
+	}
+	dbl := func(get func(StatRow) float64) func(*parquetBuffer, StatRow) bool {
+		return func(b *parquetBuffer, r StatRow) bool { b.double(get(r)); return true }
+	}
+	columns := []parquetColumn[StatRow]{
+		{"namespace", parquetByteArray, parquetUTF8, false, str(func(r StatRow) string { return r.Namespace })},
+		{"baseline", parquetByteArray, parquetUTF8, false, str(func(r StatRow) string { return r.Baseline })},
+		{"table", parquetByteArray, parquetUTF8, false, str(func(r StatRow) string { return r.Table })},
+		{"key", parquetByteArray, parquetUTF8, false, str(func(r StatRow) string { return r.Key })},
+		{"category", parquetByteArray, parquetUTF8, false, str(func(r StatRow) string { return r.Category })},
+		{"pattern", parquetByteArray, parquetUTF8, false, str(func(r StatRow) string { return r.Pattern })},
+		{"labels", parquetByteArray, parquetUTF8, false, str(func(r StatRow) string { return r.Labels })},
+		{"mean", parquetDouble, noConvertedType, false, dbl(func(r StatRow) float64 { return r.Mean })},
+		{"stddev", parquetDouble, noConvertedType, false, dbl(func(r StatRow) float64 { return r.StdDev })},
+		{"min", parquetDouble, noConvertedType, false, dbl(func(r StatRow) float64 { return r.Min })},
+		{"max", parquetDouble, noConvertedType, false, dbl(func(r StatRow) float64 { return r.Max })},
+		{"sample_count", parquetInt64, noConvertedType, false, func(b *parquetBuffer, r StatRow) bool {
+			b.int64(r.SampleCount)
+			return true
+		}},
+		{"m2", parquetDouble, noConvertedType, false, dbl(func(r StatRow) float64 { return r.M2 })},
+		{"last_seen", parquetInt64, parquetTimestampMillis, true, func(b *parquetBuffer, r StatRow) bool {
+			if r.LastSeen.IsZero() {
+				return false
+			}
+			b.int64(r.LastSeen.UnixMilli())
+			return true
+		}},
+		{"seeded", parquetBoolean, noConvertedType, false, func(b *parquetBuffer, r StatRow) bool {
+			b.boolean(r.Seeded)
+			return true
+		}},
+		{"threshold", parquetDouble, noConvertedType, false, dbl(func(r StatRow) float64 { return r.Threshold })},
+		{"direction", parquetByteArray, parquetUTF8, false, str(func(r StatRow) string { return string(r.Direction) })},
+	}
+	return writeParquet(w, columns, rows)
+}
+
+// ThresholdOverride is one row of a reviewed threshold spreadsheet.
+type ThresholdOverride struct {
+	// Line is the row's line number, for error messages.
+	Line     int
+	Baseline string // empty when the sheet has no baseline column
+	Key      string
+	// Threshold is in standard deviations; 0, from an empty cell, removes
+	// the key's override.
+	Threshold float64
+	// Direction is empty when the sheet has no direction column, and
+	// both for an empty cell.
+	Direction baseline.Direction
+}
+
+// ReadThresholdsCSV reads threshold overrides from CSV with a header row
+// naming at least the key and threshold columns, and optionally baseline
+// and direction.
+// Other columns are ignored, so an edited WriteStatsCSV export can be read
+// back as is. A key listed more than once for a baseline, as keys learned
+// both as counts and as volumes are, must be given the same threshold and
+// direction each time. Malformed and conflicting rows are reported together.
+func ReadThresholdsCSV(r io.Reader) ([]ThresholdOverride, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("empty threshold sheet")
+	}
+	if err != nil {
+		return nil, err
+	}
+	column := map[string]int{"baseline": -1, "key": -1, "threshold": -1, "direction": -1}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := column[name]; ok {
+			column[name] = i
+		}
+	}
+	if column["key"] < 0 || column["threshold"] < 0 {
+		return nil, errors.New("threshold sheet needs key and threshold columns")
+	}
+	cell := func(record []string, name string) string {
+		if i := column[name]; i >= 0 && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var overrides []ThresholdOverride
+	var errs []error
+	seen := make(map[[2]string]ThresholdOverride)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		o := ThresholdOverride{Line: line, Baseline: cell(record, "baseline"), Key: cell(record, "key")}
+		if o.Key == "" {
+			errs = append(errs, fmt.Errorf("line %d: missing key", line))
+			continue
+		}
+		if t := cell(record, "threshold"); t != "" {
+			if o.Threshold, err = strconv.ParseFloat(t, 64); err != nil {
+				errs = append(errs, fmt.Errorf("line %d: invalid threshold %q", line, t))
+				continue
+			}
+		}
+		if column["direction"] >= 0 {
+			if o.Direction, err = baseline.ParseDirection(cell(record, "direction")); err != nil {
+				errs = append(errs, fmt.Errorf("line %d: %w", line, err))
+				continue
+			}
+		}
+		id := [2]string{o.Baseline, o.Key}
+		if first, dup := seen[id]; dup {
+			if first.Threshold != o.Threshold {
+				errs = append(errs, fmt.Errorf("line %d: threshold of %s conflicts with line %d", line, o.Key, first.Line))
+			} else if first.Direction != o.Direction {
+				errs = append(errs, fmt.Errorf("line %d: direction of %s conflicts with line %d", line, o.Key, first.Line))
+			}
+			continue
+		}
+		seen[id] = o
+		overrides = append(overrides, o)
+	}
+	return overrides, errors.Join(errs...)
+}
@@ -0,0 +1,123 @@
+package export
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+// STIXBundle is a STIX 2.1 bundle.
+type STIXBundle struct {
+	Type    string       `json:"type"`
+	ID      string       `json:"id"`
+	Objects []STIXObject `json:"objects"`
+}
+
+// STIXObject is a STIX 2.1 domain object. Only the properties runtimebase
+// emits are modelled.
+type STIXObject struct {
+	Type           string   `json:"type"`
+	SpecVersion    string   `json:"spec_version"`
+	ID             string   `json:"id"`
+	Created        string   `json:"created"`
+	Modified       string   `json:"modified"`
+	Name           string   `json:"name,omitempty"`
+	Description    string   `json:"description,omitempty"`
+	IdentityClass  string   `json:"identity_class,omitempty"`
+	CreatedByRef   string   `json:"created_by_ref,omitempty"`
+	Pattern        string   `json:"pattern,omitempty"`
+	PatternType    string   `json:"pattern_type,omitempty"`
+	ValidFrom      string   `json:"valid_from,omitempty"`
+	IndicatorTypes []string `json:"indicator_types,omitempty"`
+	Confidence     int      `json:"confidence,omitempty"`
+	Labels         []string `json:"labels,omitempty"`
+}
+
+// ToSTIX converts anomalies into a STIX 2.1 bundle with one indicator per
+// observable found in their evidence. Anomalies without observables are
+// skipped.
+func ToSTIX(anomalies []baseline.Anomaly) STIXBundle {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	identity := STIXObject{
+		Type:          "identity",
+		SpecVersion:   "2.1",
+		ID:            "identity--" + newUUID(),
+		Created:       now,
+		Modified:      now,
+		Name:          "runtimebase",
+		IdentityClass: "system",
+	}
+
+	bundle := STIXBundle{
+		Type:    "bundle",
+		ID:      "bundle--" + newUUID(),
+		Objects: []STIXObject{identity},
+	}
+
+	for _, anomaly := range anomalies {
+		validFrom := anomaly.Timestamp
+		if validFrom.IsZero() {
+			validFrom = time.Now()
+		}
+
+		for _, indicator := range ExtractIndicators(anomaly.Evidence) {
+			bundle.Objects = append(bundle.Objects, STIXObject{
+				Type:           "indicator",
+				SpecVersion:    "2.1",
+				ID:             "indicator--" + newUUID(),
+				Created:        now,
+				Modified:       now,
+				Name:           fmt.Sprintf("%s: %s", anomaly.Type, indicator.Value),
+				Description:    anomaly.Description,
+				CreatedByRef:   identity.ID,
+				Pattern:        stixPattern(indicator),
+				PatternType:    "stix",
+				ValidFrom:      validFrom.UTC().Format(time.RFC3339Nano),
+				IndicatorTypes: []string{"anomalous-activity"},
+				Confidence:     int(anomaly.Confidence * 100),
+				Labels:         []string{strings.ToLower(anomaly.Severity)},
+			})
+		}
+	}
+
+	return bundle
+}
+
+// WriteSTIX returns the STIX bundle for anomalies as indented JSON.
+func WriteSTIX(anomalies []baseline.Anomaly) ([]byte, error) {
+	return json.MarshalIndent(ToSTIX(anomalies), "", "  ")
+}
+
+func stixPattern(indicator Indicator) string {
+	value := strings.ReplaceAll(indicator.Value, "'", "\\'")
+	switch indicator.Type {
+	case IndicatorIPv4:
+		return fmt.Sprintf("[ipv4-addr:value = '%s']", value)
+	case IndicatorIPv6:
+		return fmt.Sprintf("[ipv6-addr:value = '%s']", value)
+	case IndicatorDomain:
+		return fmt.Sprintf("[domain-name:value = '%s']", value)
+	case IndicatorMD5:
+		return fmt.Sprintf("[file:hashes.MD5 = '%s']", value)
+	case IndicatorSHA1:
+		return fmt.Sprintf("[file:hashes.'SHA-1' = '%s']", value)
+	case IndicatorSHA256:
+		return fmt.Sprintf("[file:hashes.'SHA-256' = '%s']", value)
+	}
+	return ""
+}
+
+// newUUID returns a random RFC 4122 version 4 UUID.
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
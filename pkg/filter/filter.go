@@ -0,0 +1,191 @@
+// Package filter scopes analysis to a time window and workload: a range of
+// timestamps, process IDs, process names and event categories.
+package filter
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/category"
+	"github.com/hallucinaut/runtimebase/pkg/config"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/storage"
+)
+
+// Filter selects events, anomalies and log lines. Zero fields do not
+// restrict; within a field, any value matches.
+type Filter struct {
+	Since time.Time // inclusive
+	Until time.Time // exclusive
+	PIDs  []int
+	// Processes are process names, or glob patterns such as "nginx*".
+	Processes []string
+	// Categories are category patterns (see category.Match), e.g.
+	// "network", which includes network.dns, "file.*" or "*.write".
+	Categories []string
+}
+
+// Empty reports whether the filter matches everything.
+func (f Filter) Empty() bool {
+	return f.Since.IsZero() && f.Until.IsZero() && len(f.PIDs) == 0 && len(f.Processes) == 0 && len(f.Categories) == 0
+}
+
+// Time reports whether t is within the time range. An unknown (zero) time
+// matches only when no range is set.
+func (f Filter) Time(t time.Time) bool {
+	if t.IsZero() {
+		return f.Since.IsZero() && f.Until.IsZero()
+	}
+	return (f.Since.IsZero() || !t.Before(f.Since)) && (f.Until.IsZero() || t.Before(f.Until))
+}
+
+// Process reports whether a process matches the PID and name filters.
+func (f Filter) Process(pid int, name string) bool {
+	if len(f.PIDs) > 0 && !containsInt(f.PIDs, pid) {
+		return false
+	}
+	if len(f.Processes) == 0 {
+		return true
+	}
+	for _, pattern := range f.Processes {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Category reports whether a category matches the category filter.
+func (f Filter) Category(name string) bool {
+	if len(f.Categories) == 0 {
+		return true
+	}
+	for _, c := range f.Categories {
+		if category.Match(strings.ToLower(c), strings.ToLower(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Event reports whether an event matches. Its category is its type.
+func (f Filter) Event(e detect.SystemEvent) bool {
+	return f.Time(e.Timestamp) && f.Process(e.PID, e.ProcessName) && f.Category(e.Type)
+}
+
+// Anomaly reports whether an anomaly matches. Its category is that of the
+// behavior it reports, such as "network" for a connection rate.
+func (f Filter) Anomaly(a baseline.Anomaly) bool {
+	return f.Time(a.Timestamp) && f.Process(a.PID, a.Process) && f.Category(AnomalyCategory(a))
+}
+
+// Record reports whether a history record matches.
+func (f Filter) Record(r storage.Record) bool {
+	switch {
+	case r.Anomaly != nil:
+		return f.Anomaly(*r.Anomaly)
+	case r.Event != nil:
+		return f.Event(*r.Event)
+	}
+	return f.Time(r.Time)
+}
+
+// Line reports whether a log line with the given metadata matches. Log
+// lines have the category "log".
+func (f Filter) Line(m LineMeta) bool {
+	return f.Time(m.Time) && f.Process(m.PID, m.Process) && f.Category("log")
+}
+
+// AnomalyCategory returns the category of the behavior an anomaly
+// reports, or "" if it reports none; see baseline.Anomaly.Behavior.
+func AnomalyCategory(a baseline.Anomaly) string {
+	c, _ := a.Behavior()
+	return c
+}
+
+func containsInt(values []int, v int) bool {
+	for _, x := range values {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTime parses a time bound: an RFC 3339 timestamp, a date such as
+// 2024-01-31, or a duration such as 90m or 7d meaning that long before now.
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, now.Location()); err == nil {
+		return t, nil
+	}
+	if d, err := config.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: want an RFC 3339 timestamp, a date or a duration such as 24h", s)
+}
+
+// ParsePIDs parses a comma-separated list of process IDs.
+func ParsePIDs(s string) ([]int, error) {
+	var pids []int
+	for _, field := range splitList(s) {
+		pid, err := strconv.Atoi(field)
+		if err != nil || pid < 0 {
+			return nil, fmt.Errorf("invalid pid %q", field)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+func splitList(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// Parse builds a filter from its textual form, as given on the command line
+// or in query parameters. Empty strings do not restrict.
+func Parse(since, until, pids, processes, categories string, now time.Time) (Filter, error) {
+	var f Filter
+	var err error
+	if since != "" {
+		if f.Since, err = ParseTime(since, now); err != nil {
+			return f, fmt.Errorf("since: %w", err)
+		}
+	}
+	if until != "" {
+		if f.Until, err = ParseTime(until, now); err != nil {
+			return f, fmt.Errorf("until: %w", err)
+		}
+	}
+	if f.PIDs, err = ParsePIDs(pids); err != nil {
+		return f, err
+	}
+	f.Processes = splitList(processes)
+	f.Categories = splitList(categories)
+	for _, c := range f.Categories {
+		if err := category.ValidatePatternSyntax(strings.ToLower(c)); err != nil {
+			return f, err
+		}
+	}
+	return f, nil
+}
+
+// FromQuery builds a filter from the query parameters since, until, pid,
+// process and category. List parameters may be repeated or comma-separated.
+func FromQuery(q url.Values, now time.Time) (Filter, error) {
+	join := func(key string) string { return strings.Join(q[key], ",") }
+	return Parse(q.Get("since"), q.Get("until"), join("pid"), join("process"), join("category"), now)
+}
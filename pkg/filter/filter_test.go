@@ -0,0 +1,125 @@
+package filter
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line    string
+		time    time.Time
+		pid     int
+		process string
+	}{
+		{"2024-01-31T12:00:00Z INFO started", time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC), 0, ""},
+		{"2024-01-31 12:00:00,250 ERROR failed", time.Date(2024, 1, 31, 12, 0, 0, 250e6, time.UTC), 0, ""},
+		{"Jan  5 08:30:00 host sshd[1234]: Accepted publickey", time.Date(2024, 1, 5, 8, 30, 0, 0, time.UTC), 1234, "sshd"},
+		{`10.0.0.1 - - [31/Jan/2024:12:00:00 +0000] "GET / HTTP/1.1" 200 12`, time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC), 0, ""},
+		{"    at com.example.Main(Main.java:10)", time.Time{}, 0, ""},
+	}
+	for _, tt := range tests {
+		m := ParseLine(tt.line, 2024, time.UTC)
+		if !m.Time.Equal(tt.time) || m.PID != tt.pid || m.Process != tt.process {
+			t.Errorf("%q: got %+v", tt.line, m)
+		}
+	}
+}
+
+func TestLinesContinuation(t *testing.T) {
+	since := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	l := &Lines{Filter: Filter{Since: since}, Year: 2024, Loc: time.UTC}
+	input := []struct {
+		line string
+		want bool
+	}{
+		{"2024-01-31T11:59:00Z ERROR old", false},
+		{"    at old.frame", false},
+		{"2024-01-31T12:00:00Z ERROR new", true},
+		{"    at new.frame", true},
+	}
+	for _, in := range input {
+		if got := l.Match(in.line); got != in.want {
+			t.Errorf("%q: expected %v, got %v", in.line, in.want, got)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	tests := map[string]time.Time{
+		"2024-01-30T10:00:00Z": time.Date(2024, 1, 30, 10, 0, 0, 0, time.UTC),
+		"2024-01-30":           time.Date(2024, 1, 30, 0, 0, 0, 0, time.UTC),
+		"2h":                   now.Add(-2 * time.Hour),
+		"1d":                   now.Add(-24 * time.Hour),
+	}
+	for in, want := range tests {
+		got, err := ParseTime(in, now)
+		if err != nil || !got.Equal(want) {
+			t.Errorf("%s: expected %v, got %v (%v)", in, want, got, err)
+		}
+	}
+	if _, err := ParseTime("yesterday", now); err == nil {
+		t.Error("expected an error for an unknown time")
+	}
+}
+
+func TestFilterEventsAndAnomalies(t *testing.T) {
+	now := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	q := url.Values{"since": {"1h"}, "process": {"nginx*"}, "category": {"network,file"}}
+	f, err := FromQuery(q, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := []struct {
+		event detect.SystemEvent
+		want  bool
+	}{
+		{detect.SystemEvent{Timestamp: now.Add(-time.Minute), Type: "network", ProcessName: "nginx-worker"}, true},
+		{detect.SystemEvent{Timestamp: now.Add(-2 * time.Hour), Type: "network", ProcessName: "nginx"}, false},
+		{detect.SystemEvent{Timestamp: now.Add(-time.Minute), Type: "syscall", ProcessName: "nginx"}, false},
+		{detect.SystemEvent{Timestamp: now.Add(-time.Minute), Type: "file", ProcessName: "sshd"}, false},
+	}
+	for i, e := range events {
+		if got := f.Event(e.event); got != e.want {
+			t.Errorf("event %d: expected %v, got %v", i, e.want, got)
+		}
+	}
+
+	a := baseline.Anomaly{Timestamp: now, Process: "nginx", Evidence: "network:10.0.0.9:443"}
+	if !f.Anomaly(a) {
+		t.Error("expected the network anomaly to match")
+	}
+	a.Evidence = "syscall:ptrace"
+	if f.Anomaly(a) {
+		t.Error("expected the syscall anomaly not to match")
+	}
+
+	// Rate anomalies carry the pattern as evidence and their category
+	// apart.
+	learner := baseline.NewLearner()
+	b := learner.CreateBaseline("web")
+	for _, count := range []int{98, 100, 102, 99, 101} {
+		b.RecordObservation("network", "10.0.0.9:443", count)
+		b.RecordObservation("syscall", "openat", count)
+	}
+	rates := append(learner.DetectAnomaly("web", "network", "10.0.0.9:443", 200), learner.DetectAnomaly("web", "syscall", "openat", 200)...)
+	if len(rates) != 2 {
+		t.Fatalf("expected two rate anomalies, got %+v", rates)
+	}
+	for _, a := range rates {
+		a.Timestamp, a.Process = now, "nginx"
+		if got, want := f.Anomaly(a), a.Category == "network"; got != want {
+			t.Errorf("rate anomaly of %s %s: expected %v, got %v", a.Category, a.Evidence, want, got)
+		}
+	}
+
+	if _, err := FromQuery(url.Values{"pid": {"12,x"}}, now); err == nil {
+		t.Error("expected an error for an invalid pid")
+	}
+}
@@ -0,0 +1,93 @@
+package filter
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LineMeta is what can be recovered about a log line from its text.
+type LineMeta struct {
+	Time    time.Time // zero if the line has no recognizable timestamp
+	PID     int
+	Process string
+}
+
+var (
+	isoTime = regexp.MustCompile(`^\[?(\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?)`)
+	// Jan  2 15:04:05, as written by syslog (RFC 3164), without a year.
+	syslogTime = regexp.MustCompile(`^([A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2})`)
+	// [31/Jan/2024:12:00:00 +0000], as in web server access logs.
+	clfTime = regexp.MustCompile(`\[(\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})\]`)
+	// sshd[1234]: at the start of a syslog message.
+	processTag = regexp.MustCompile(`(?:^|\s)([A-Za-z0-9_./-]+)\[(\d+)\]:`)
+)
+
+var isoLayouts = []string{
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999Z0700",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// ParseLine extracts the timestamp and process of a log line in the common
+// ISO 8601, syslog and access log formats. Times without a zone are taken
+// to be in loc, and syslog times, which have no year, in the given year.
+func ParseLine(line string, year int, loc *time.Location) LineMeta {
+	var m LineMeta
+	switch {
+	case isoTime.MatchString(line):
+		s := isoTime.FindStringSubmatch(line)[1]
+		s = strings.Replace(strings.Replace(s, ",", ".", 1), " ", "T", 1)
+		for _, layout := range isoLayouts {
+			if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+				m.Time = t
+				break
+			}
+		}
+	case syslogTime.MatchString(line):
+		s := syslogTime.FindStringSubmatch(line)[1]
+		if t, err := time.ParseInLocation("Jan _2 15:04:05", s, loc); err == nil {
+			m.Time = t.AddDate(year, 0, 0)
+		}
+	default:
+		if s := clfTime.FindStringSubmatch(line); s != nil {
+			if t, err := time.Parse("02/Jan/2006:15:04:05 -0700", s[1]); err == nil {
+				m.Time = t
+			}
+		}
+	}
+	if s := processTag.FindStringSubmatch(line); s != nil {
+		m.Process = s[1]
+		m.PID, _ = strconv.Atoi(s[2])
+	}
+	return m
+}
+
+// Lines filters a stream of log lines. Lines without a timestamp or process,
+// such as the continuation lines of a stack trace, take those of the line
+// before them.
+type Lines struct {
+	Filter Filter
+	Year   int
+	Loc    *time.Location
+
+	last LineMeta
+}
+
+// Match reports whether the line is selected.
+func (l *Lines) Match(line string) bool {
+	if l.Filter.Empty() {
+		return true
+	}
+	loc := l.Loc
+	if loc == nil {
+		loc = time.Local
+	}
+	m := ParseLine(line, l.Year, loc)
+	if m.Time.IsZero() && m.Process == "" {
+		m = l.last
+	}
+	l.last = m
+	return l.Filter.Line(m)
+}
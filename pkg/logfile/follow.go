@@ -0,0 +1,163 @@
+package logfile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// Follower tails a file like `tail -F`: it keeps reading as lines are
+// appended, starts over when the file is truncated, and when the file is
+// renamed or replaced, as by log rotation, finishes the old file and
+// continues with the new one.
+type Follower struct {
+	Path string
+	// Poll is how often the file is checked for new data. Default 250ms.
+	Poll time.Duration
+	// FromStart reads the existing content first instead of only lines
+	// appended after Run starts.
+	FromStart bool
+
+	f       *os.File
+	info    os.FileInfo
+	offset  int64
+	partial []byte
+}
+
+// Run calls fn with each complete line until ctx is done. A file that does
+// not exist yet is waited for.
+func (t *Follower) Run(ctx context.Context, fn func(line string)) error {
+	poll := t.Poll
+	if poll <= 0 {
+		poll = 250 * time.Millisecond
+	}
+	defer func() {
+		if t.f != nil {
+			t.f.Close()
+		}
+	}()
+
+	first := true
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		if err := t.step(fn, first && !t.FromStart); err != nil {
+			return err
+		}
+		first = false
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// step reads new data and handles truncation and replacement of the file.
+func (t *Follower) step(fn func(string), atEnd bool) error {
+	if t.f == nil {
+		if err := t.open(atEnd); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+	}
+	if err := t.read(fn); err != nil {
+		return err
+	}
+
+	current, err := os.Stat(t.Path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// Renamed away and not yet recreated: keep the old file open, it
+		// may still be written to until the writer reopens.
+		return nil
+	case err != nil:
+		return err
+	case !os.SameFile(t.info, current):
+		// Replaced: finish the old file, then start the new one from its
+		// beginning.
+		if err := t.read(fn); err != nil {
+			return err
+		}
+		t.flushPartial(fn)
+		t.f.Close()
+		t.f = nil
+		if err := t.open(false); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if t.f != nil {
+			return t.read(fn)
+		}
+	case current.Size() < t.offset:
+		// Truncated in place, e.g. by copytruncate.
+		if _, err := t.f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		t.offset = 0
+		t.partial = nil
+		return t.read(fn)
+	}
+	return nil
+}
+
+func (t *Follower) open(atEnd bool) error {
+	f, err := os.Open(t.Path)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	t.offset = 0
+	if atEnd {
+		if t.offset, err = f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	t.f, t.info, t.partial = f, info, nil
+	return nil
+}
+
+// read passes the complete lines appended since the last read to fn,
+// keeping a trailing partial line for later.
+func (t *Follower) read(fn func(string)) error {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := t.f.Read(buf)
+		if n > 0 {
+			t.offset += int64(n)
+			data := append(t.partial, buf[:n]...)
+			for {
+				i := bytes.IndexByte(data, '\n')
+				if i < 0 {
+					break
+				}
+				fn(string(bytes.TrimSuffix(data[:i], []byte("\r"))))
+				data = data[i+1:]
+			}
+			t.partial = append([]byte(nil), data...)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// flushPartial passes an unterminated last line to fn.
+func (t *Follower) flushPartial(fn func(string)) {
+	if len(t.partial) > 0 {
+		fn(string(t.partial))
+		t.partial = nil
+	}
+}
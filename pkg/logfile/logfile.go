@@ -0,0 +1,282 @@
+// Package logfile reads log files the way they exist on disk: compressed
+// with gzip or zstd, split across a logrotate sequence, or still being
+// written to.
+package logfile
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Open opens a log file for reading, decompressing gzip and zstd files
+// transparently. Compression is detected from the content, not the name.
+// Reading zstd requires the zstd command on the PATH. "-" reads standard
+// input, which closing the returned reader leaves open.
+func Open(path string) (io.ReadCloser, error) {
+	var in io.Reader
+	var closeIn func() error
+	if path == "-" {
+		in, closeIn = os.Stdin, func() error { return nil }
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		in, closeIn = f, f.Close
+	}
+	br := bufio.NewReader(in)
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			closeIn()
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return &readCloser{Reader: gz, close: func() error { gz.Close(); return closeIn() }}, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		return openZstd(path, br, closeIn)
+	}
+	return &readCloser{Reader: br, close: closeIn}, nil
+}
+
+// openZstd decompresses r through the zstd command, calling closeIn once
+// done with the input.
+func openZstd(path string, r io.Reader, closeIn func() error) (io.ReadCloser, error) {
+	zstd, err := exec.LookPath("zstd")
+	if err != nil {
+		closeIn()
+		return nil, fmt.Errorf("%s: reading zstd files requires the zstd command", path)
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(zstd, "-dcq")
+	cmd.Stdin = r
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		closeIn()
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		closeIn()
+		return nil, err
+	}
+	return &readCloser{Reader: out, close: func() error {
+		// Drain what was not read so zstd exits instead of failing on a
+		// closed pipe.
+		io.Copy(io.Discard, out)
+		err := cmd.Wait()
+		closeIn()
+		if err != nil {
+			return fmt.Errorf("%s: zstd: %w: %s", path, err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	}}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	close  func() error
+	closed bool
+	err    error
+}
+
+// Close closes the file once; later calls return the first result.
+func (r *readCloser) Close() error {
+	if !r.closed {
+		r.closed = true
+		r.err = r.close()
+	}
+	return r.err
+}
+
+// IsStream reports whether path is read as it arrives and cannot be
+// reopened: standard input ("-"), a named pipe, a socket or a character
+// device such as /dev/stdin on a terminal. Streams have no rotated
+// predecessors and cannot be followed.
+func IsStream(path string) bool {
+	if path == "-" {
+		return true
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&(os.ModeNamedPipe|os.ModeSocket|os.ModeCharDevice) != 0
+}
+
+// rotatedSuffix matches the suffixes logrotate appends: a number
+// (app.log.1) or a date (app.log-20240101), optionally compressed.
+var rotatedSuffix = regexp.MustCompile(`^(?:\.(\d+)|-(\d{8,10}))(?:\.gz|\.zst)?$`)
+
+// Rotated returns the rotated predecessors of path followed by path itself,
+// oldest first: dated files (app.log-20240101.gz) in date order, then
+// numbered files from the highest number down (app.log.2.gz, app.log.1).
+// Path itself is included even if it does not exist yet.
+func Rotated(path string) ([]string, error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type rotated struct {
+		path   string
+		number int    // numbered rotation, 0 for dated
+		date   string // dated rotation
+	}
+	var files []rotated
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, base) || name == base {
+			continue
+		}
+		m := rotatedSuffix.FindStringSubmatch(name[len(base):])
+		if m == nil {
+			continue
+		}
+		r := rotated{path: filepath.Join(filepath.Dir(path), name), date: m[2]}
+		if m[1] != "" {
+			r.number, _ = strconv.Atoi(m[1])
+		}
+		files = append(files, r)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if (a.date == "") != (b.date == "") {
+			return a.date != "" // dated files predate numbered ones
+		}
+		if a.date != "" {
+			return a.date < b.date
+		}
+		return a.number > b.number
+	})
+
+	paths := make([]string, 0, len(files)+1)
+	for _, f := range files {
+		paths = append(paths, f.path)
+	}
+	return append(paths, path), nil
+}
+
+// ScanLines calls fn with every line of the files in order, decompressing
+// as needed. Missing files are skipped if skipMissing is set. fn may return
+// an error to stop.
+func ScanLines(paths []string, skipMissing bool, fn func(line string) error) error {
+	return ScanBytes(paths, skipMissing, func(line []byte) error {
+		return fn(string(line))
+	})
+}
+
+// MmapThreshold is the size from which ScanBytes maps uncompressed files
+// into memory instead of reading them through a buffer.
+var MmapThreshold int64 = 4 << 20
+
+// ScanBytes is ScanLines without a string copy per line: line is only
+// valid until fn returns. Large uncompressed files are mapped into memory
+// where supported, so their lines are never copied at all.
+func ScanBytes(paths []string, skipMissing bool, fn func(line []byte) error) error {
+	for _, path := range paths {
+		if err := scanFile(path, skipMissing, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanFile(path string, skipMissing bool, fn func(line []byte) error) error {
+	if path != "-" {
+		if done, err := scanMapped(path, fn); done || err != nil {
+			if errors.Is(err, os.ErrNotExist) && skipMissing {
+				return nil
+			}
+			return err
+		}
+	}
+	r, err := Open(path)
+	if errors.Is(err, os.ErrNotExist) && skipMissing {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if err := fn(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return r.Close()
+}
+
+// scanMapped scans a regular, uncompressed file of at least MmapThreshold
+// bytes through a memory mapping, splitting lines as bufio.ScanLines does.
+// It reports false, without error, for files it leaves to scanFile.
+func scanMapped(path string, fn func(line []byte) error) (done bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() || info.Size() < MmapThreshold || int64(int(info.Size())) != info.Size() {
+		return false, err
+	}
+	data, unmap, err := mapFile(f, int(info.Size()))
+	if err != nil {
+		return false, nil // not supported here; read it instead
+	}
+	defer unmap()
+	if bytes.HasPrefix(data, gzipMagic) || bytes.HasPrefix(data, zstdMagic) {
+		return false, nil
+	}
+
+	// A file truncated while mapped faults on access past its new end;
+	// report that as an error rather than crashing.
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		r := recover()
+		if _, fault := r.(interface{ Addr() uintptr }); fault {
+			done, err = true, fmt.Errorf("%s: file changed while reading: %v", path, r)
+		} else if r != nil {
+			panic(r)
+		}
+	}()
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+		} else {
+			data = nil
+		}
+		if n := len(line); n > 0 && line[n-1] == '\r' {
+			line = line[:n-1]
+		}
+		if err := fn(line); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
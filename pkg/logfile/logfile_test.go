@@ -0,0 +1,271 @@
+package logfile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeGzip(t *testing.T, path, content string) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(content))
+	gz.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRotatedSequence(t *testing.T) {
+	dir := t.TempDir()
+	live := filepath.Join(dir, "app.log")
+	os.WriteFile(live, []byte("line 5\n"), 0o600)
+	os.WriteFile(live+".1", []byte("line 4\n"), 0o600)
+	writeGzip(t, live+".2.gz", "line 3\n")
+	writeGzip(t, live+".10.gz", "line 2\n")
+	writeGzip(t, live+"-20240101.gz", "line 1")                 // no trailing newline
+	os.WriteFile(filepath.Join(dir, "app.log.bak"), nil, 0o600) // not a rotation
+	os.WriteFile(filepath.Join(dir, "other.log.1"), nil, 0o600)
+
+	paths, err := Rotated(live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{live + "-20240101.gz", live + ".10.gz", live + ".2.gz", live + ".1", live}
+	if !reflect.DeepEqual(paths, want) {
+		t.Fatalf("expected %v, got %v", want, paths)
+	}
+
+	var lines []string
+	if err := ScanLines(paths, false, func(line string) error {
+		lines = append(lines, line)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"line 1", "line 2", "line 3", "line 4", "line 5"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("expected %v, got %v", want, lines)
+	}
+}
+
+func TestOpenZstd(t *testing.T) {
+	zstd, err := exec.LookPath("zstd")
+	if err != nil {
+		t.Skip("zstd not installed")
+	}
+	path := filepath.Join(t.TempDir(), "app.log.zst")
+	cmd := exec.Command(zstd, "-q", "-o", path)
+	cmd.Stdin = bytes.NewBufferString("hello\nworld\n")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	if err := ScanLines([]string{path}, false, func(line string) error {
+		lines = append(lines, line)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"hello", "world"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("expected %v, got %v", want, lines)
+	}
+}
+
+func TestOpenStdinLeavesStdinOpen(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+
+	fmt.Fprint(w, "hello\n")
+	w.Close()
+	var lines []string
+	if err := ScanLines([]string{"-"}, false, func(line string) error {
+		lines = append(lines, line)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"hello"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("expected %v, got %v", want, lines)
+	}
+	if _, err := r.Stat(); err != nil {
+		t.Errorf("standard input was closed: %v", err)
+	}
+}
+
+func TestFollowTruncateAndRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("one\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var lines []string
+	seen := func(n int) bool {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			mu.Lock()
+			got := len(lines)
+			mu.Unlock()
+			if got >= n {
+				return true
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return false
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	f := &Follower{Path: path, Poll: 5 * time.Millisecond, FromStart: true}
+	go func() {
+		done <- f.Run(ctx, func(line string) {
+			mu.Lock()
+			lines = append(lines, line)
+			mu.Unlock()
+		})
+	}()
+
+	appendTo := func(s string) {
+		w, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.WriteString(s)
+		w.Close()
+	}
+	if !seen(1) {
+		t.Fatal("existing line not read")
+	}
+	appendTo("tw")
+	appendTo("o\n")
+	if !seen(2) {
+		t.Fatal("appended line not read")
+	}
+	// Truncate in place, then write shorter content.
+	if err := os.WriteFile(path, []byte("3\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if !seen(3) {
+		t.Fatal("line after truncation not read")
+	}
+	// Rotate: rename away and recreate.
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendTo("four\n")
+	if !seen(4) {
+		t.Fatal("line in recreated file not read")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"one", "two", "3", "four"}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("expected %v, got %v", want, lines)
+	}
+}
+
+func TestReadFrom(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	read := func(from Offset) ([]string, Offset) {
+		t.Helper()
+		var lines []string
+		pos, err := ReadFrom(path, from, func(line string) error {
+			lines = append(lines, line)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lines, pos
+	}
+
+	os.WriteFile(path, []byte("a\nb\npart"), 0o600)
+	lines, pos := read(Offset{})
+	if !reflect.DeepEqual(lines, []string{"a", "b"}) || pos.Offset != 4 {
+		t.Fatalf("expected the complete lines, got %q at %+v", lines, pos)
+	}
+
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString("ial\nc\n")
+	f.Close()
+	if lines, pos = read(pos); !reflect.DeepEqual(lines, []string{"partial", "c"}) {
+		t.Fatalf("expected to resume with the finished line, got %q", lines)
+	}
+
+	os.WriteFile(path, []byte("x\n"), 0o600)
+	if lines, _ := read(pos); !reflect.DeepEqual(lines, []string{"x"}) {
+		t.Errorf("expected a truncated file to be read from the start, got %q", lines)
+	}
+
+	os.Rename(path, path+".1")
+	os.WriteFile(path, []byte("new file, same size\n"), 0o600)
+	if lines, _ := read(Offset{Inode: pos.Inode + 1, Offset: 2}); len(lines) != 1 {
+		t.Errorf("expected another file to be read from the start, got %q", lines)
+	}
+}
+
+func TestScanBytesMapped(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	os.WriteFile(path, []byte("one\r\ntwo\n\nthree"), 0o600)
+	writeGzip(t, path+".gz", "one\r\ntwo\n\nthree")
+
+	defer func(threshold int64) { MmapThreshold = threshold }(MmapThreshold)
+	for _, threshold := range []int64{1, 1 << 40} {
+		MmapThreshold = threshold
+		for _, p := range []string{path, path + ".gz"} {
+			var lines []string
+			err := ScanBytes([]string{p}, false, func(line []byte) error {
+				lines = append(lines, string(line))
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"one", "two", "", "three"}; !reflect.DeepEqual(lines, want) {
+				t.Errorf("threshold %d, %s: expected %q, got %q", threshold, filepath.Base(p), want, lines)
+			}
+		}
+	}
+	if err := ScanBytes([]string{filepath.Join(dir, "missing.log")}, true, nil); err != nil {
+		t.Errorf("expected a missing file to be skipped, got %v", err)
+	}
+}
+
+// BenchmarkScanBytes measures scanning a mapped auditd log.
+func BenchmarkScanBytes(b *testing.B) {
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < 32<<20; i++ {
+		fmt.Fprintf(&buf, "type=SYSCALL msg=audit(1760000000.%03d:%d): arch=c000003e syscall=59 success=yes exit=0 a0=55d0 a1=55d1 a2=55d2 a3=0 items=2 ppid=%d pid=%d auid=1000 uid=0 gid=0 euid=0 comm=\"bash\" exe=\"/usr/bin/bash\" key=\"exec\"\n", i%1000, i, 1000+i%50, 2000+i)
+	}
+	path := filepath.Join(b.TempDir(), "audit.log")
+	os.WriteFile(path, buf.Bytes(), 0o600)
+	b.SetBytes(int64(buf.Len()))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n := 0
+		ScanBytes([]string{path}, false, func(line []byte) error {
+			n += len(line)
+			return nil
+		})
+	}
+}
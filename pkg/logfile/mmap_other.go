@@ -0,0 +1,13 @@
+//go:build !unix
+
+package logfile
+
+import (
+	"errors"
+	"os"
+)
+
+// mapFile fails: files are read through a buffer on this platform.
+func mapFile(f *os.File, size int) ([]byte, func() error, error) {
+	return nil, nil, errors.ErrUnsupported
+}
@@ -0,0 +1,63 @@
+package logfile
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Offset is a position in a file, tied to the file's identity so that
+// reading resumes only in the same file: after rotation replaced it, or
+// truncation shrank it below the offset, the file is read from the start.
+type Offset struct {
+	Inode  uint64 `json:",omitempty"` // 0 where the platform has no inodes
+	Offset int64
+}
+
+// ReadFrom calls fn with every complete line of the plain-text file at
+// path after from, and returns the offset just past the last line fn
+// accepted. A trailing line without a newline is left for the next call,
+// as the writer may not have finished it. Compressed files cannot be read
+// from an offset and are rejected.
+func ReadFrom(path string, from Offset, fn func(line string) error) (Offset, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return from, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return from, err
+	}
+
+	magic := make([]byte, 4)
+	if n, _ := f.ReadAt(magic, 0); bytes.HasPrefix(magic[:n], gzipMagic) || bytes.HasPrefix(magic[:n], zstdMagic) {
+		return from, fmt.Errorf("%s: compressed files cannot be read incrementally", path)
+	}
+
+	pos := Offset{Inode: inode(info), Offset: from.Offset}
+	if from.Inode != pos.Inode || info.Size() < from.Offset {
+		pos.Offset = 0
+	}
+	if _, err := f.Seek(pos.Offset, io.SeekStart); err != nil {
+		return from, err
+	}
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			return pos, nil
+		}
+		if err != nil {
+			return pos, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := fn(strings.TrimRight(line, "\r\n")); err != nil {
+			return pos, err
+		}
+		pos.Offset += int64(len(line))
+	}
+}
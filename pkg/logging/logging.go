@@ -0,0 +1,190 @@
+// Package logging configures structured logging for runtimebase.
+//
+// Output is JSON or logfmt-style text for daemons and pipelines, and a
+// terse human format when writing to an interactive terminal.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Formats accepted by Config.Format.
+const (
+	FormatAuto  = "auto"  // human on a terminal, JSON otherwise
+	FormatHuman = "human" // "Error: message key=value"
+	FormatText  = "text"  // slog key=value lines
+	FormatJSON  = "json"  // one JSON object per line
+)
+
+// Config selects the log level and format.
+type Config struct {
+	// Level is one of debug, info, warn or error. Empty means info.
+	Level string `yaml:"level"`
+	// Format is one of auto, human, text or json. Empty means auto.
+	Format string `yaml:"format"`
+}
+
+// ParseLevel parses a level name.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q", s)
+	}
+	return level, nil
+}
+
+// Validate reports whether the level and format are known.
+func (c Config) Validate() error {
+	if _, err := ParseLevel(c.Level); err != nil {
+		return err
+	}
+	switch c.Format {
+	case "", FormatAuto, FormatHuman, FormatText, FormatJSON:
+		return nil
+	}
+	return fmt.Errorf("invalid log format %q", c.Format)
+}
+
+// New returns a logger writing to w as configured.
+func New(w io.Writer, cfg Config) (*slog.Logger, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	level, _ := ParseLevel(cfg.Level)
+	opts := &slog.HandlerOptions{Level: level}
+	format := cfg.Format
+	if format == "" || format == FormatAuto {
+		format = FormatJSON
+		if IsTerminal(w) {
+			format = FormatHuman
+		}
+	}
+	switch format {
+	case FormatHuman:
+		return slog.New(NewHumanHandler(w, opts)), nil
+	case FormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+}
+
+// IsTerminal reports whether w is a character device such as a tty.
+func IsTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// HumanHandler writes records as "Error: message: err key=value", without
+// timestamps, for interactive use. A top-level "error" attribute follows
+// the message the way a wrapped error would.
+type HumanHandler struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	level  slog.Leveler
+	attrs  []slog.Attr
+	prefix string
+}
+
+// NewHumanHandler returns a HumanHandler writing to w.
+func NewHumanHandler(w io.Writer, opts *slog.HandlerOptions) *HumanHandler {
+	h := &HumanHandler{w: w, mu: &sync.Mutex{}, level: slog.LevelInfo}
+	if opts != nil && opts.Level != nil {
+		h.level = opts.Level
+	}
+	return h
+}
+
+// Enabled implements slog.Handler.
+func (h *HumanHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle implements slog.Handler.
+func (h *HumanHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	switch {
+	case r.Level >= slog.LevelError:
+		b.WriteString("Error: ")
+	case r.Level >= slog.LevelWarn:
+		b.WriteString("Warning: ")
+	case r.Level < slog.LevelInfo:
+		b.WriteString("debug: ")
+	}
+	b.WriteString(r.Message)
+	var rest strings.Builder
+	for _, a := range h.attrs {
+		writeAttr(&rest, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		if h.prefix == "" && a.Key == "error" {
+			b.WriteString(": ")
+			b.WriteString(a.Value.String())
+			return true
+		}
+		writeAttr(&rest, h.prefix, a)
+		return true
+	})
+	b.WriteString(rest.String())
+	b.WriteByte('\n')
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+// WithAttrs implements slog.Handler.
+func (h *HumanHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append([]slog.Attr(nil), h.attrs...)
+	for _, a := range attrs {
+		a.Key = h.prefix + a.Key
+		clone.attrs = append(clone.attrs, a)
+	}
+	return &clone
+}
+
+// WithGroup implements slog.Handler.
+func (h *HumanHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	clone := *h
+	clone.prefix = h.prefix + name + "."
+	return &clone
+}
+
+func writeAttr(b *strings.Builder, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	// The component is implied by the message in interactive output.
+	if prefix == "" && a.Key == "component" {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		for _, ga := range a.Value.Group() {
+			writeAttr(b, prefix+a.Key+".", ga)
+		}
+		return
+	}
+	s := a.Value.String()
+	if strings.ContainsAny(s, " \t\"=") {
+		s = fmt.Sprintf("%q", s)
+	}
+	fmt.Fprintf(b, " %s%s=%s", prefix, a.Key, s)
+}
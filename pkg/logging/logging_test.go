@@ -0,0 +1,41 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestHumanHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, Config{Format: FormatHuman})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger = logger.With("component", "janitor")
+
+	logger.Warn("could not record history", "error", errors.New("disk full"), "baseline", "my app")
+	logger.Debug("hidden")
+
+	want := "Warning: could not record history: disk full baseline=\"my app\"\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestNewJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, Config{Level: "debug", Format: FormatJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("pattern fired", "component", "detect")
+	if !strings.Contains(buf.String(), `"component":"detect"`) {
+		t.Errorf("expected JSON component field, got %q", buf.String())
+	}
+
+	if _, err := New(&buf, Config{Format: "xml"}); err == nil {
+		t.Error("expected unknown format to be rejected")
+	}
+}
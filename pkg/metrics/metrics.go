@@ -0,0 +1,184 @@
+// Package metrics provides counters exposed in the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds named counters with optional labels.
+type Registry struct {
+	mu       sync.Mutex
+	help     map[string]string
+	counters map[string]map[string]float64 // name -> label set -> value
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		help:     make(map[string]string),
+		counters: make(map[string]map[string]float64),
+	}
+}
+
+// Describe sets the help text for a metric.
+func (r *Registry) Describe(name, help string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.help[name] = help
+}
+
+// Add increments a counter. Labels are given as alternating key, value pairs.
+func (r *Registry) Add(name string, delta float64, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	series, ok := r.counters[name]
+	if !ok {
+		series = make(map[string]float64)
+		r.counters[name] = series
+	}
+	series[formatLabels(labels)] += delta
+}
+
+// Value returns the current value of a counter.
+func (r *Registry) Value(name string, labels ...string) float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counters[name][formatLabels(labels)]
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.counters))
+	for name := range r.counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		if help := r.help[name]; help != "" {
+			fmt.Fprintf(&b, "# HELP %s %s\n", name, help)
+		}
+		fmt.Fprintf(&b, "# TYPE %s counter\n", name)
+
+		series := r.counters[name]
+		keys := make([]string, 0, len(series))
+		for key := range series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&b, "%s%s %g\n", name, key, series[key])
+		}
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// Handler serves the registry over HTTP.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		r.WriteTo(w)
+	})
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Sample is a value of one series, as read back by Parse.
+type Sample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// Parse reads the samples of the Prometheus text exposition format, such
+// as WriteTo writes or another exporter serves. Comments, and timestamps
+// after values, are skipped.
+func Parse(r io.Reader) ([]Sample, error) {
+	var samples []Sample
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		s, err := parseSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		samples = append(samples, s)
+	}
+	return samples, scanner.Err()
+}
+
+func parseSample(line string) (Sample, error) {
+	s := Sample{Labels: make(map[string]string)}
+	end := strings.IndexAny(line, "{ ")
+	if end <= 0 {
+		return s, fmt.Errorf("malformed sample %q", line)
+	}
+	s.Name, line = line[:end], line[end:]
+	if line[0] == '{' {
+		line = line[1:]
+		for {
+			line = strings.TrimLeft(line, " ,")
+			if strings.HasPrefix(line, "}") {
+				line = line[1:]
+				break
+			}
+			name, rest, ok := strings.Cut(line, `="`)
+			if !ok {
+				return s, fmt.Errorf("malformed labels of %s", s.Name)
+			}
+			var value strings.Builder
+			i := 0
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+					if rest[i] == 'n' {
+						value.WriteByte('\n')
+						continue
+					}
+				}
+				value.WriteByte(rest[i])
+			}
+			if i == len(rest) {
+				return s, fmt.Errorf("unterminated label %s of %s", name, s.Name)
+			}
+			s.Labels[strings.TrimSpace(name)] = value.String()
+			line = rest[i+1:]
+		}
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return s, fmt.Errorf("%s has no value", s.Name)
+	}
+	var err error
+	if s.Value, err = strconv.ParseFloat(fields[0], 64); err != nil {
+		return s, fmt.Errorf("value of %s: %w", s.Name, err)
+	}
+	return s, nil
+}
@@ -0,0 +1,83 @@
+package mining
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// Category is the baseline category of mined log templates.
+const Category = "log"
+
+// NewTemplate is the type of anomalies about templates a baseline has
+// never seen.
+const NewTemplate = "New Log Template"
+
+// Match assigns clusters to the patterns of category in b, by matching each
+// cluster's sample line against the pattern regexes. It returns the line
+// count per matched pattern name and the clusters matching no pattern.
+func Match(b *baseline.Baseline, clusters []*Cluster, category string) (map[string]int, []*Cluster) {
+	counts := make(map[string]int)
+	var novel []*Cluster
+	for _, c := range clusters {
+		name := ""
+		for _, p := range b.Patterns {
+			if p.Category == category && p.Regex != nil && p.Regex.MatchString(c.Sample) {
+				name = p.Name
+				break
+			}
+		}
+		if name == "" {
+			novel = append(novel, c)
+			continue
+		}
+		counts[name] += c.Count
+	}
+	return counts, novel
+}
+
+// Learn adds templates seen at least minCount times to b's patterns and
+// records the line count of every known template as an observation. It
+// returns the number of patterns added.
+func Learn(b *baseline.Baseline, clusters []*Cluster, category string, minCount int) int {
+	counts, novel := Match(b, clusters, category)
+	added := Patterns(novel, category, minCount)
+	b.Patterns = append(b.Patterns, added...)
+	for _, p := range added {
+		counts[p.Name] += int(p.NormalCount)
+	}
+	for name, count := range counts {
+		b.RecordObservation(category, name, count)
+	}
+	return len(added)
+}
+
+// Detect reports templates of category that are new to the named baseline,
+// and known templates whose line counts deviate from it.
+func Detect(l *baseline.Learner, name string, clusters []*Cluster, category string) []baseline.Anomaly {
+	b := l.GetBaseline(name)
+	if b == nil {
+		return nil
+	}
+	counts, novel := Match(b, clusters, category)
+
+	var anomalies []baseline.Anomaly
+	for _, c := range novel {
+		anomalies = append(anomalies, baseline.Anomaly{
+			Type:        NewTemplate,
+			Description: fmt.Sprintf("Log message template never seen in the baseline (%d lines)", c.Count),
+			Severity:    severity.Label(severity.Medium),
+			Evidence:    c.String(),
+			Category:    category,
+			Confidence:  0.8,
+			RiskLevel:   severity.Label(severity.Medium),
+			Timestamp:   time.Now(),
+		})
+	}
+	for pattern, count := range counts {
+		anomalies = append(anomalies, l.DetectAnomaly(name, category, pattern, count)...)
+	}
+	return anomalies
+}
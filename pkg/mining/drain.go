@@ -0,0 +1,298 @@
+// Package mining discovers recurring message templates in unstructured log
+// lines, using the Drain fixed-depth parse tree (He et al., ICWS 2017).
+//
+// Each line is tokenized on whitespace and routed through the tree by its
+// token count and leading tokens to a small set of candidate clusters. The
+// line joins the most similar cluster, whose template generalizes to "<*>"
+// wherever the two differ, or starts a new one.
+package mining
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+// Wildcard marks a variable position in a template.
+const Wildcard = "<*>"
+
+// Cluster is a group of log lines sharing a template.
+type Cluster struct {
+	ID       int
+	Template []string
+	Count    int
+	Sample   string // first line seen
+}
+
+// String returns the template as text.
+func (c *Cluster) String() string {
+	return strings.Join(c.Template, " ")
+}
+
+// Regex returns a regular expression matching lines of the template.
+func (c *Cluster) Regex() *regexp.Regexp {
+	return TemplateRegex(c.Template)
+}
+
+// TemplateRegex compiles a template into an anchored regular expression in
+// which wildcards match any single token.
+func TemplateRegex(template []string) *regexp.Regexp {
+	parts := make([]string, len(template))
+	for i, token := range template {
+		if token == Wildcard {
+			parts[i] = `\S+`
+		} else {
+			parts[i] = regexp.QuoteMeta(token)
+		}
+	}
+	return regexp.MustCompile(`^\s*` + strings.Join(parts, `\s+`) + `\s*$`)
+}
+
+// Miner clusters log lines into templates.
+type Miner struct {
+	// Depth is the depth of the parse tree including the root and length
+	// layers; lines are routed by their first Depth-2 tokens. Default 4.
+	Depth int
+	// Similarity is the minimum fraction of matching tokens for a line to
+	// join a cluster. Default 0.4.
+	Similarity float64
+	// MaxChildren bounds the children of a tree node; further distinct
+	// tokens share a wildcard child. Default 100.
+	MaxChildren int
+
+	root     map[int]*node // by token count
+	clusters []*Cluster
+	spans    []span // tokens of the line being added, reused
+}
+
+type node struct {
+	children map[string]*node
+	clusters []*Cluster
+}
+
+// span locates a token in a line; wild tokens are masked as variable.
+type span struct {
+	start, end int
+	wild       bool
+}
+
+// NewMiner returns a miner with the default parameters.
+func NewMiner() *Miner {
+	return &Miner{Depth: 4, Similarity: 0.4, MaxChildren: 100}
+}
+
+// Tokenize splits a line into tokens, masking tokens that are obviously
+// variable, such as numbers, addresses and identifiers containing digits.
+func Tokenize(line string) []string {
+	spans := fields(nil, line)
+	tokens := make([]string, len(spans))
+	for i, sp := range spans {
+		tokens[i] = token(line, sp)
+	}
+	return tokens
+}
+
+// fields appends the whitespace-separated tokens of line to spans, as
+// strings.Fields splits them, marking those Tokenize masks: tokens with a
+// digit and hex strings of at least 8 characters.
+func fields[T string | []byte](spans []span, line T) []span {
+	for i := 0; i < len(line); {
+		if c := line[i]; c < utf8.RuneSelf {
+			if class[c] == isSpace {
+				i++
+				continue
+			}
+		} else if space, size := spaceAt(line, i); space {
+			i += size
+			continue
+		}
+		start, digits, hex := i, false, true
+		for i < len(line) {
+			c := line[i]
+			if c >= utf8.RuneSelf {
+				space, size := spaceAt(line, i)
+				if space {
+					break
+				}
+				hex = false
+				i += size
+				continue
+			}
+			k := class[c]
+			if k == isSpace {
+				break
+			}
+			digits = digits || k == isDigit
+			hex = hex && k != 0
+			i++
+		}
+		spans = append(spans, span{start, i, digits || hex && i-start >= 8})
+	}
+	return spans
+}
+
+// Byte classes of fields; isDigit characters are hex digits too.
+const (
+	isSpace = 1 + iota
+	isDigit
+	isHex
+)
+
+var class = func() (t [utf8.RuneSelf]byte) {
+	for _, c := range "\t\n\v\f\r " {
+		t[c] = isSpace
+	}
+	for c := '0'; c <= '9'; c++ {
+		t[c] = isDigit
+	}
+	for c := 'a'; c <= 'f'; c++ {
+		t[c], t[c-'a'+'A'] = isHex, isHex
+	}
+	return t
+}()
+
+// spaceAt decodes the rune at line[i] and reports whether it is a space.
+func spaceAt[T string | []byte](line T, i int) (bool, int) {
+	r, size := utf8.DecodeRuneInString(string(line[i:min(i+utf8.UTFMax, len(line))]))
+	return unicode.IsSpace(r), size
+}
+
+// token returns the token at sp as a string, or Wildcard when masked.
+func token[T string | []byte](line T, sp span) string {
+	if sp.wild {
+		return Wildcard
+	}
+	return string(line[sp.start:sp.end])
+}
+
+// Add adds a line and returns the cluster it joined, or nil for blank lines.
+func (m *Miner) Add(line string) *Cluster {
+	return add(m, line)
+}
+
+// AddBytes is Add for a line in a buffer the caller may reuse once it
+// returns. The line is tokenized in place and copied only into the
+// template and sample of a new cluster, so lines joining existing
+// clusters cost no allocations.
+func (m *Miner) AddBytes(line []byte) *Cluster {
+	return add(m, line)
+}
+
+func add[T string | []byte](m *Miner, line T) *Cluster {
+	m.spans = fields(m.spans[:0], line)
+	spans := m.spans
+	if len(spans) == 0 {
+		return nil
+	}
+	if m.root == nil {
+		m.root = make(map[int]*node)
+	}
+
+	leaf := leaf(m, line, spans)
+	if c := match(m, leaf.clusters, line, spans); c != nil {
+		for i, sp := range spans {
+			if t := c.Template[i]; t != Wildcard && (sp.wild || t != string(line[sp.start:sp.end])) {
+				c.Template[i] = Wildcard
+			}
+		}
+		c.Count++
+		return c
+	}
+
+	template := make([]string, len(spans))
+	for i, sp := range spans {
+		template[i] = token(line, sp)
+	}
+	c := &Cluster{ID: len(m.clusters) + 1, Template: template, Count: 1, Sample: string(line)}
+	leaf.clusters = append(leaf.clusters, c)
+	m.clusters = append(m.clusters, c)
+	return c
+}
+
+// leaf walks, creating as needed, the tree path for the tokens of line.
+func leaf[T string | []byte](m *Miner, line T, spans []span) *node {
+	n, ok := m.root[len(spans)]
+	if !ok {
+		n = &node{children: make(map[string]*node)}
+		m.root[len(spans)] = n
+	}
+	depth := max(m.Depth-2, 1)
+	for i := 0; i < depth && i < len(spans); i++ {
+		sp := spans[i]
+		var child *node
+		if sp.wild {
+			child = n.children[Wildcard]
+		} else {
+			child = n.children[string(line[sp.start:sp.end])]
+		}
+		if child == nil {
+			key := token(line, sp)
+			if key != Wildcard && len(n.children) >= m.MaxChildren {
+				key = Wildcard
+				child = n.children[key]
+			}
+			if child == nil {
+				child = &node{children: make(map[string]*node)}
+				n.children[key] = child
+			}
+		}
+		n = child
+	}
+	return n
+}
+
+// match returns the most similar cluster meeting the similarity threshold.
+func match[T string | []byte](m *Miner, clusters []*Cluster, line T, spans []span) *Cluster {
+	var best *Cluster
+	bestSim, bestWild := -1.0, -1
+	for _, c := range clusters {
+		equal, wild := 0, 0
+		for i, t := range c.Template {
+			sp := spans[i]
+			switch {
+			case t == Wildcard:
+				wild++
+			case !sp.wild && t == string(line[sp.start:sp.end]):
+				equal++
+			}
+		}
+		sim := float64(equal) / float64(len(spans))
+		// Prefer the more specific template among equally similar ones.
+		if sim > bestSim || (sim == bestSim && wild < bestWild) {
+			best, bestSim, bestWild = c, sim, wild
+		}
+	}
+	if best == nil || bestSim < m.Similarity {
+		return nil
+	}
+	return best
+}
+
+// Clusters returns the clusters found so far, most frequent first.
+func (m *Miner) Clusters() []*Cluster {
+	clusters := append([]*Cluster(nil), m.clusters...)
+	sort.SliceStable(clusters, func(i, j int) bool { return clusters[i].Count > clusters[j].Count })
+	return clusters
+}
+
+// Patterns converts clusters seen at least minCount times into behavior
+// patterns of the given category. NormalCount is the cluster's size.
+func Patterns(clusters []*Cluster, category string, minCount int) []baseline.BehaviorPattern {
+	var patterns []baseline.BehaviorPattern
+	for _, c := range clusters {
+		if c.Count < minCount {
+			continue
+		}
+		patterns = append(patterns, baseline.BehaviorPattern{
+			Name:        c.String(),
+			Regex:       c.Regex(),
+			Category:    category,
+			NormalCount: float64(c.Count),
+		})
+	}
+	return patterns
+}
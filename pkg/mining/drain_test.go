@@ -0,0 +1,93 @@
+package mining
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMinerDiscoversTemplates(t *testing.T) {
+	m := NewMiner()
+	lines := []string{
+		"Accepted password for alice from 10.0.0.1 port 5022",
+		"Accepted password for bob from 10.0.0.7 port 6022",
+		"Accepted password for carol from 10.0.0.9 port 7022",
+		"Connection closed by 10.0.0.1",
+		"Connection closed by 10.0.0.2",
+		"session opened for user root",
+	}
+	for _, line := range lines {
+		m.Add(line)
+	}
+
+	clusters := m.Clusters()
+	if len(clusters) != 3 {
+		for _, c := range clusters {
+			t.Logf("%d %s", c.Count, c)
+		}
+		t.Fatalf("expected 3 templates, got %d", len(clusters))
+	}
+	if got, want := clusters[0].String(), "Accepted password for <*> from <*> port <*>"; got != want {
+		t.Errorf("got template %q, want %q", got, want)
+	}
+	if clusters[0].Count != 3 {
+		t.Errorf("expected 3 lines in the top template, got %d", clusters[0].Count)
+	}
+	if !clusters[0].Regex().MatchString("Accepted password for dave from 192.168.1.1 port 22") {
+		t.Error("expected template regex to match a new line of the same shape")
+	}
+
+	patterns := Patterns(clusters, "log", 2)
+	if len(patterns) != 2 {
+		t.Errorf("expected 2 patterns seen at least twice, got %d", len(patterns))
+	}
+}
+
+// auditLines returns n auditd records of a few recurring shapes.
+func auditLines(n int) [][]byte {
+	lines := make([][]byte, n)
+	for i := range lines {
+		var line string
+		switch i % 3 {
+		case 0:
+			line = fmt.Sprintf(`type=SYSCALL msg=audit(1700000000.%03d:%d): arch=c000003e syscall=257 success=yes exit=3 a0=ffffff9c a1=7ffd%08x a2=0 a3=0 items=1 ppid=%d pid=%d auid=1000 uid=0 gid=0 euid=0 suid=0 fsuid=0 egid=0 sgid=0 fsgid=0 tty=pts0 ses=2 comm="cat" exe="/usr/bin/cat" key="passwd"`, i%1000, i, i, 1000+i%50, 2000+i%50)
+		case 1:
+			line = fmt.Sprintf(`type=PATH msg=audit(1700000000.%03d:%d): item=0 name="/etc/passwd" inode=%d dev=fd:00 mode=0100644 ouid=0 ogid=0 rdev=00:00 nametype=NORMAL cap_fp=0 cap_fi=0 cap_fe=0 cap_fver=0`, i%1000, i, 130000+i)
+		default:
+			line = fmt.Sprintf(`type=USER_LOGIN msg=audit(1700000000.%03d:%d): pid=%d uid=0 auid=1000 ses=3 msg='op=login id=1000 exe="/usr/sbin/sshd" hostname=? addr=10.0.%d.%d terminal=sshd res=success'`, i%1000, i, 3000+i, i%255, i%7)
+		}
+		lines[i] = []byte(line)
+	}
+	return lines
+}
+
+// BenchmarkFields measures tokenizing auditd records in place.
+func BenchmarkFields(b *testing.B) {
+	lines := auditLines(10000)
+	size := 0
+	for _, line := range lines {
+		size += len(line)
+	}
+	var spans []span
+	b.SetBytes(int64(size / len(lines)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		spans = fields(spans[:0], lines[i%len(lines)])
+	}
+}
+
+// BenchmarkAddBytes measures clustering lines that join existing
+// templates, which should not allocate.
+func BenchmarkAddBytes(b *testing.B) {
+	lines := make([][]byte, 1000)
+	for i := range lines {
+		lines[i] = []byte(fmt.Sprintf("sshd[%d]: Accepted publickey for deploy from 10.0.%d.%d port %d ssh2: ED25519 SHA256:%x", 4000+i, i%255, i%7, 40000+i, i*7919))
+	}
+	m := NewMiner()
+	b.SetBytes(int64(len(lines[0])))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.AddBytes(lines[i%len(lines)])
+	}
+}
@@ -0,0 +1,250 @@
+// Package ocihook implements an OCI runtime hook that starts a monitoring
+// session for each container launched by runc or crun.
+package ocihook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/storage"
+)
+
+// BaselineAnnotation names the baseline for a container explicitly.
+const BaselineAnnotation = "io.runtimebase.baseline"
+
+// imageAnnotations are checked, in order, for the container image when no
+// baseline annotation is set.
+var imageAnnotations = []string{
+	"io.kubernetes.cri.image-name",
+	"io.kubernetes.cri-o.ImageName",
+	"org.opencontainers.image.ref.name",
+	"org.opencontainers.image.base.name",
+}
+
+// State is the container state an OCI runtime passes to hooks on stdin.
+type State struct {
+	OCIVersion  string            `json:"ociVersion"`
+	ID          string            `json:"id"`
+	Status      string            `json:"status"`
+	PID         int               `json:"pid"`
+	Bundle      string            `json:"bundle"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// ReadState decodes the hook state from r.
+func ReadState(r io.Reader) (*State, error) {
+	var state State
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return nil, fmt.Errorf("decoding OCI state: %w", err)
+	}
+	if state.ID == "" {
+		return nil, errors.New("OCI state has no container id")
+	}
+	return &state, nil
+}
+
+// bundleAnnotations reads annotations from the bundle's config.json, which
+// some runtimes do not copy into the hook state.
+func bundleAnnotations(bundle string) map[string]string {
+	data, err := os.ReadFile(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		return nil
+	}
+	var spec struct {
+		Annotations map[string]string `json:"annotations"`
+	}
+	if json.Unmarshal(data, &spec) != nil {
+		return nil
+	}
+	return spec.Annotations
+}
+
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// ImageBaselineName turns an image reference into a baseline name by
+// dropping the registry, tag and digest: "registry.io/team/api:1.2" becomes
+// "team-api".
+func ImageBaselineName(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	if parts := strings.SplitN(image, "/", 2); len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		image = parts[1]
+	}
+	return strings.Trim(unsafeName.ReplaceAllString(strings.ReplaceAll(image, "/", "-"), "-"), "-.")
+}
+
+// ResolveBaseline picks the baseline for a container: the explicit
+// annotation, otherwise a name derived from the image.
+func ResolveBaseline(state *State) (string, error) {
+	annotations := make(map[string]string)
+	for k, v := range bundleAnnotations(state.Bundle) {
+		annotations[k] = v
+	}
+	for k, v := range state.Annotations {
+		annotations[k] = v
+	}
+
+	if name := annotations[BaselineAnnotation]; name != "" {
+		return name, nil
+	}
+	for _, key := range imageAnnotations {
+		if image := annotations[key]; image != "" {
+			if name := ImageBaselineName(image); name != "" {
+				return name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("container %s has no %s annotation or image name", state.ID, BaselineAnnotation)
+}
+
+// Session ties a running container to the baseline it is monitored against.
+// PID is the container's init process.
+type Session struct {
+	ContainerID string            `json:"container_id"`
+	PID         int               `json:"pid"`
+	Baseline    string            `json:"baseline"`
+	Bundle      string            `json:"bundle"`
+	StartedAt   time.Time         `json:"started_at"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Sessions stores active sessions as files in a directory, so the hook (a
+// short-lived process) and the daemon can share them. The daemon's
+// pipelines route container events to session baselines with the
+// "session" router.
+type Sessions struct {
+	Dir string
+}
+
+// DefaultDir returns the directory sessions are kept in by default,
+// "sessions" in the default store directory.
+func DefaultDir() string {
+	return filepath.Join(storage.DefaultDir(), "sessions")
+}
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+func (s *Sessions) path(id string) (string, error) {
+	if !validID.MatchString(id) {
+		return "", fmt.Errorf("invalid container id %q", id)
+	}
+	return filepath.Join(s.Dir, id+".json"), nil
+}
+
+// Start records a session.
+func (s *Sessions) Start(session Session) error {
+	path, err := s.path(session.ContainerID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0o700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Stop removes a session. Stopping an unknown session is not an error.
+func (s *Sessions) Stop(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List returns active sessions ordered by start time.
+func (s *Sessions) List() ([]Session, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sessions []Session
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.Dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		var session Session
+		if json.Unmarshal(data, &session) == nil {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].StartedAt.Before(sessions[j].StartedAt) })
+	return sessions, nil
+}
+
+// Run handles one hook invocation. Stages "createRuntime", "poststart" and
+// "prestart" start a session; "poststop" ends it.
+func Run(stage string, stdin io.Reader, sessions *Sessions) (*Session, error) {
+	state, err := ReadState(stdin)
+	if err != nil {
+		return nil, err
+	}
+
+	switch stage {
+	case "prestart", "createRuntime", "createContainer", "startContainer", "poststart":
+		name, err := ResolveBaseline(state)
+		if err != nil {
+			return nil, err
+		}
+		session := Session{
+			ContainerID: state.ID,
+			PID:         state.PID,
+			Baseline:    name,
+			Bundle:      state.Bundle,
+			StartedAt:   time.Now(),
+			Annotations: state.Annotations,
+		}
+		return &session, sessions.Start(session)
+	case "poststop":
+		return nil, sessions.Stop(state.ID)
+	}
+	return nil, fmt.Errorf("unsupported hook stage %q", stage)
+}
+
+// HookConfig returns an OCI hooks.d configuration (as read by Podman and
+// CRI-O) that runs binary at createRuntime and poststop for every container.
+func HookConfig(binary string) ([]byte, []byte) {
+	config := func(stage string) []byte {
+		data, _ := json.MarshalIndent(map[string]interface{}{
+			"version": "1.0.0",
+			"hook": map[string]interface{}{
+				"path": binary,
+				"args": []string{filepath.Base(binary), "oci-hook", "--stage", stage},
+			},
+			"when":   map[string]interface{}{"always": true},
+			"stages": []string{stage},
+		}, "", "  ")
+		return data
+	}
+	return config("createRuntime"), config("poststop")
+}
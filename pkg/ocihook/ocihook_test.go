@@ -0,0 +1,44 @@
+package ocihook
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestImageBaselineName(t *testing.T) {
+	tests := map[string]string{
+		"registry.example.com:5000/team/api:1.2.3": "team-api",
+		"docker.io/library/nginx@sha256:abcd":      "library-nginx",
+		"redis:7":                                  "redis",
+		"localhost/app":                            "app",
+	}
+	for image, want := range tests {
+		if got := ImageBaselineName(image); got != want {
+			t.Errorf("ImageBaselineName(%q) = %q, want %q", image, got, want)
+		}
+	}
+}
+
+func TestRunStartsAndStopsSession(t *testing.T) {
+	sessions := &Sessions{Dir: t.TempDir()}
+	state := `{"ociVersion":"1.0.2","id":"abc123","status":"created","pid":4242,"bundle":"/nonexistent",` +
+		`"annotations":{"io.runtimebase.baseline":"payments"}}`
+
+	session, err := Run("createRuntime", strings.NewReader(state), sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Baseline != "payments" || session.PID != 4242 {
+		t.Errorf("unexpected session: %+v", session)
+	}
+	if list, _ := sessions.List(); len(list) != 1 {
+		t.Fatalf("expected 1 active session, got %d", len(list))
+	}
+
+	if _, err := Run("poststop", strings.NewReader(state), sessions); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := sessions.List(); len(list) != 0 {
+		t.Errorf("expected no active sessions, got %d", len(list))
+	}
+}
@@ -0,0 +1,89 @@
+// Package parse converts raw log and trace formats into system events.
+package parse
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+// AccessLogEntry is one request from an Nginx or Apache access log.
+type AccessLogEntry struct {
+	RemoteAddr string
+	User       string
+	Time       time.Time
+	Method     string
+	Path       string
+	Protocol   string
+	Status     int
+	Bytes      int64
+	Referer    string
+	UserAgent  string
+}
+
+// combinedPattern matches the Combined Log Format; the referer and user agent
+// are optional so Common Log Format lines are accepted too.
+var combinedPattern = regexp.MustCompile(
+	`^(\S+) \S+ (\S+) \[([^\]]+)\] "([^"]*)" (\d{3}) (\d+|-)(?: "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)")?`)
+
+const accessLogTime = "02/Jan/2006:15:04:05 -0700"
+
+// ParseAccessLog parses a Combined (or Common) Log Format line.
+func ParseAccessLog(line string) (AccessLogEntry, error) {
+	m := combinedPattern.FindStringSubmatch(line)
+	if m == nil {
+		return AccessLogEntry{}, fmt.Errorf("not an access log line: %q", line)
+	}
+
+	ts, err := time.Parse(accessLogTime, m[3])
+	if err != nil {
+		return AccessLogEntry{}, fmt.Errorf("invalid timestamp %q: %w", m[3], err)
+	}
+
+	entry := AccessLogEntry{
+		RemoteAddr: m[1],
+		User:       m[2],
+		Time:       ts,
+		Referer:    m[7],
+		UserAgent:  m[8],
+	}
+	entry.Status, _ = strconv.Atoi(m[5])
+	if m[6] != "-" {
+		entry.Bytes, _ = strconv.ParseInt(m[6], 10, 64)
+	}
+
+	// The request line may be malformed ("-" or garbage from scanners).
+	request := strings.Fields(m[4])
+	switch len(request) {
+	case 3:
+		entry.Method, entry.Path, entry.Protocol = request[0], request[1], request[2]
+	case 2:
+		entry.Method, entry.Path = request[0], request[1]
+	default:
+		entry.Path = m[4]
+	}
+
+	return entry, nil
+}
+
+// Event converts the entry into an "http" system event.
+func (e AccessLogEntry) Event() detect.SystemEvent {
+	return detect.SystemEvent{
+		Type:      "http",
+		Timestamp: e.Time,
+		Path:      e.Path,
+		Bytes:     e.Bytes,
+		Data: map[string]interface{}{
+			"remote_addr": e.RemoteAddr,
+			"method":      e.Method,
+			"status":      e.Status,
+			"bytes":       e.Bytes,
+			"referer":     e.Referer,
+			"user_agent":  e.UserAgent,
+		},
+	}
+}
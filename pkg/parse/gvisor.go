@@ -0,0 +1,117 @@
+package parse
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+// gvisorStracePattern matches syscall entry lines written by runsc with
+// --strace, e.g.
+//
+//	I0601 12:00:00.123456  1234 strace.go:567] [   1:   1] bash E openat(AT_FDCWD /etc/passwd, O_RDONLY, 0o0)
+//
+// Exit ("X") lines are ignored so each call is counted once.
+var gvisorStracePattern = regexp.MustCompile(
+	`^[IWED](\d{4} \d{2}:\d{2}:\d{2}\.\d+)\s+\d+ \S+\] \[\s*(\d+):\s*\d+\] (\S+) E (\w+)\((.*)\)\s*$`)
+
+// gvisorPathArg finds the first absolute path in a formatted argument list.
+var gvisorPathArg = regexp.MustCompile(`(?:^|[\s,(])(/[^\s,)]*)`)
+
+// ParseGVisorStrace parses a runsc --strace debug log line. It returns
+// ok == false for lines that are not syscall entries.
+func ParseGVisorStrace(line string, year int) (detect.SystemEvent, bool) {
+	m := gvisorStracePattern.FindStringSubmatch(line)
+	if m == nil {
+		return detect.SystemEvent{}, false
+	}
+
+	// glog timestamps omit the year.
+	ts, err := time.Parse("2006 0102 15:04:05.000000", fmt.Sprintf("%d %s", year, m[1]))
+	if err != nil {
+		ts = time.Time{}
+	}
+	pid, _ := strconv.Atoi(m[2])
+
+	event := detect.SystemEvent{
+		Type:        "syscall",
+		Timestamp:   ts,
+		ProcessName: m[3],
+		PID:         pid,
+		Data:        map[string]interface{}{"syscall": m[4], "source": "gvisor"},
+	}
+	if p := gvisorPathArg.FindStringSubmatch(m[5]); p != nil {
+		event.Path = p[1]
+	}
+	return event, true
+}
+
+// gvisorPoint is the subset of a seccheck point, encoded with protojson,
+// that runtimebase uses. Syscall points carry "sysno"; others, such as
+// sentry/clone or container/start, are identified by their message type.
+type gvisorPoint struct {
+	Type        string `json:"@type"`
+	ContextData struct {
+		TimeNs      string `json:"timeNs"`
+		ThreadGroup int    `json:"threadGroupId"`
+		ProcessName string `json:"processName"`
+		ContainerID string `json:"containerId"`
+		Cwd         string `json:"cwd"`
+	} `json:"contextData"`
+	Sysno    int    `json:"sysno"`
+	Pathname string `json:"pathname"`
+	Exit     *struct {
+		Result string `json:"result"`
+	} `json:"exit"`
+}
+
+// ParseGVisorPoint parses a gVisor seccheck point encoded as JSON (for
+// example, the output of a remote sink server that re-encodes the protobuf
+// messages with protojson). Syscall exit points are skipped so each call is
+// counted once.
+func ParseGVisorPoint(line []byte) (detect.SystemEvent, bool, error) {
+	var point gvisorPoint
+	if err := json.Unmarshal(line, &point); err != nil {
+		return detect.SystemEvent{}, false, err
+	}
+	if point.Exit != nil {
+		return detect.SystemEvent{}, false, nil
+	}
+
+	name := point.Type[strings.LastIndexByte(point.Type, '.')+1:]
+	eventType := "syscall"
+	switch {
+	case strings.HasPrefix(point.Type, "type.googleapis.com/gvisor.sentry."):
+		eventType = "process"
+	case strings.HasPrefix(point.Type, "type.googleapis.com/gvisor.container."):
+		eventType = "container"
+	case strings.HasSuffix(point.Type, ".Syscall") && point.Sysno != 0:
+		name = "sysno_" + strconv.Itoa(point.Sysno)
+	}
+	name = strings.ToLower(name)
+
+	event := detect.SystemEvent{
+		Type:        eventType,
+		ProcessName: point.ContextData.ProcessName,
+		PID:         point.ContextData.ThreadGroup,
+		Path:        point.Pathname,
+		Data: map[string]interface{}{
+			"syscall":      name,
+			"source":       "gvisor",
+			"container_id": point.ContextData.ContainerID,
+		},
+	}
+	if eventType != "syscall" {
+		event.Data["action"] = name
+		delete(event.Data, "syscall")
+	}
+	if ns, err := strconv.ParseInt(point.ContextData.TimeNs, 10, 64); err == nil {
+		event.Timestamp = time.Unix(0, ns)
+	}
+	return event, true, nil
+}
@@ -0,0 +1,92 @@
+package parse
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+// MaxObservationCount bounds the count of one observation line, so a
+// misbehaving client cannot swamp a baseline with a single line.
+const MaxObservationCount = 1 << 20
+
+var observationCategory = regexp.MustCompile(`^[a-z][a-z0-9_.-]*$`)
+
+// ParseObservation parses one line of the observation protocol, with which
+// applications report their own behavior:
+//
+//	category:pattern|count|labels|time
+//
+// e.g. "rpc:payments.Charge|3|env=prod,region=eu". The count defaults to 1
+// and labels, name=value pairs separated by commas, are optional:
+// "flag:checkout.v2" is a valid line. The time the observation was made, in
+// RFC 3339 or Unix seconds, is optional too; without it the event has no
+// timestamp and the receiver's clock applies. The event has the category as
+// its type, the pattern in Data["pattern"] and the count as its weight, so
+// it is baselined under the key "category:pattern". Patterns of the
+// built-in categories also fill in the field those are keyed on (the
+// syscall name, network destination or path), so self-reports merge with
+// collected events.
+func ParseObservation(line string) (detect.SystemEvent, error) {
+	fields := strings.Split(strings.TrimSpace(line), "|")
+	if len(fields) > 4 {
+		return detect.SystemEvent{}, fmt.Errorf("observation %q: too many fields", line)
+	}
+	category, pattern, ok := strings.Cut(fields[0], ":")
+	if !ok || pattern == "" || !observationCategory.MatchString(category) {
+		return detect.SystemEvent{}, fmt.Errorf("observation %q: want category:pattern", line)
+	}
+	e := detect.SystemEvent{
+		Type:       category,
+		Data:       map[string]interface{}{"pattern": pattern},
+		SampleRate: 1,
+	}
+	if len(fields) > 1 && fields[1] != "" {
+		count, err := strconv.Atoi(fields[1])
+		if err != nil || count < 1 || count > MaxObservationCount {
+			return detect.SystemEvent{}, fmt.Errorf("observation %q: invalid count %q", line, fields[1])
+		}
+		e.SampleRate = count
+	}
+	if len(fields) > 2 && fields[2] != "" {
+		e.Labels = make(map[string]string)
+		for _, pair := range strings.Split(fields[2], ",") {
+			name, value, ok := strings.Cut(pair, "=")
+			if !ok || name == "" {
+				return detect.SystemEvent{}, fmt.Errorf("observation %q: invalid label %q", line, pair)
+			}
+			e.Labels[name] = value
+		}
+	}
+	if len(fields) > 3 && fields[3] != "" {
+		t, err := parseObservationTime(fields[3])
+		if err != nil {
+			return detect.SystemEvent{}, fmt.Errorf("observation %q: invalid time %q", line, fields[3])
+		}
+		e.Timestamp = t
+	}
+	switch category {
+	case "syscall":
+		e.Data["syscall"] = pattern
+	case "network":
+		e.Data["destination"] = pattern
+	case "process", "file":
+		e.Path = pattern
+	}
+	return e, nil
+}
+
+// parseObservationTime parses an RFC 3339 time or Unix seconds, possibly
+// fractional.
+func parseObservationTime(s string) (time.Time, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		whole, frac := math.Modf(secs)
+		return time.Unix(int64(whole), int64(frac*1e9)).UTC(), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
@@ -0,0 +1,136 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAccessLog(t *testing.T) {
+	line := `203.0.113.9 - alice [10/Oct/2026:13:55:36 +0000] "GET /api/users/42?x=1 HTTP/1.1" 200 2326 "https://example.com/" "curl/8.4.0"`
+
+	entry, err := ParseAccessLog(line)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if entry.Method != "GET" || entry.Path != "/api/users/42?x=1" || entry.Status != 200 || entry.Bytes != 2326 {
+		t.Errorf("unexpected entry: %+v", entry)
+	}
+	if entry.UserAgent != "curl/8.4.0" || entry.User != "alice" {
+		t.Errorf("unexpected user fields: %+v", entry)
+	}
+
+	if _, err := ParseAccessLog("not a log line"); err == nil {
+		t.Error("expected error for malformed line")
+	}
+}
+
+func TestParseGVisorStrace(t *testing.T) {
+	line := `I0601 12:00:00.123456  1234 strace.go:567] [   7:   9] bash E openat(AT_FDCWD /etc/passwd, O_RDONLY, 0o0)`
+
+	event, ok := ParseGVisorStrace(line, 2026)
+	if !ok {
+		t.Fatal("expected syscall entry to parse")
+	}
+	if event.Data["syscall"] != "openat" || event.PID != 7 || event.ProcessName != "bash" || event.Path != "/etc/passwd" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+
+	exit := `I0601 12:00:00.123500  1234 strace.go:605] [   7:   9] bash X openat(AT_FDCWD /etc/passwd, O_RDONLY, 0o0) = 3 (0x3) (12.3µs)`
+	if _, ok := ParseGVisorStrace(exit, 2026); ok {
+		t.Error("expected exit line to be skipped")
+	}
+}
+
+func TestParseStrace(t *testing.T) {
+	day := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	event, ok := ParseStrace(`[pid  4242] 12:00:00.123456 openat(AT_FDCWD, "/etc/passwd", O_RDONLY|O_CLOEXEC) = 3`, day)
+	if !ok || event.Data["syscall"] != "openat" || event.PID != 4242 || event.Path != "/etc/passwd" {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+	if want := day.Add(12*time.Hour + 123456*time.Microsecond); !event.Timestamp.Equal(want) {
+		t.Errorf("expected %v, got %v", want, event.Timestamp)
+	}
+
+	event, ok = ParseStrace(`connect(3, {sa_family=AF_INET, sin_port=htons(4444), sin_addr=inet_addr("203.0.113.7")}, 16) = 0`, day)
+	if !ok || event.Key() != "network:203.0.113.7:4444" {
+		t.Errorf("expected a network event, got %+v", event)
+	}
+
+	event, _ = ParseStrace(`4243  execve("/usr/bin/curl", ["curl", "example.com"], 0x7ffd /* 20 vars */) = 0`, day)
+	if event.Key() != "process:/usr/bin/curl" || event.ProcessName != "curl" || event.PID != 4243 {
+		t.Errorf("expected a process event, got %+v", event)
+	}
+	event, _ = ParseStrace(`execve("/usr/local/bin/curl", ["curl"], 0x7ffd /* 20 vars */) = -1 ENOENT (No such file or directory)`, day)
+	if event.Type != "syscall" {
+		t.Errorf("expected a failed execve to stay a syscall, got %+v", event)
+	}
+	event, _ = ParseStrace(`write(5</data/b.pdf.locked>, "\x8a\x1f"..., 65536) = 4096`, day)
+	if event.Path != "/data/b.pdf.locked" || event.Bytes != 4096 || event.VolumeKey() != "file:/data/b.pdf.locked" {
+		t.Errorf("expected a 4096 byte write to /data/b.pdf.locked, got %+v", event)
+	}
+	event, _ = ParseStrace(`renameat2(AT_FDCWD, "/data/a.pdf", AT_FDCWD, "/data/a.pdf.locked", 0) = 0`, day)
+	if event.Path != "/data/a.pdf" || event.Data["new_path"] != "/data/a.pdf.locked" {
+		t.Errorf("expected a rename from /data/a.pdf to /data/a.pdf.locked, got %+v", event)
+	}
+
+	for _, line := range []string{
+		`[pid  4242] <... read resumed>"abc", 3) = 3`,
+		`--- SIGCHLD {si_signo=SIGCHLD, si_code=CLD_EXITED} ---`,
+		`+++ exited with 0 +++`,
+	} {
+		if _, ok := ParseStrace(line, day); ok {
+			t.Errorf("expected %q to be skipped", line)
+		}
+	}
+}
+
+func TestStraceFiles(t *testing.T) {
+	day := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	files := NewStraceFiles()
+	var got []string
+	for _, line := range []string{
+		`42  openat(AT_FDCWD, "/data/a.pdf", O_RDONLY) = 3`,
+		`42  read(3, "/not/a/path\n"..., 4096) = 4096`,
+		`43  read(3, "abc", 3) = 3`, // another process's descriptor 3
+		`42  dup2(3, 7) = 7`,
+		`42  close(3) = 0`,
+		`42  read(3, "", 4096) = -1 EBADF (Bad file descriptor)`,
+		`42  pread64(7, "abc", 3, 0) = 3`,
+		`42  write(5</data/b.pdf.locked>, "abc", 3) = 3`, // strace -y
+		`42  +++ exited with 0 +++`,
+		`42  read(7, "abc", 3) = 3`,
+	} {
+		if event, ok := files.Parse(line, day); ok {
+			got = append(got, event.Data["syscall"].(string)+" "+event.Path)
+		}
+	}
+	want := "openat /data/a.pdf,read /data/a.pdf,read ,dup2 /data/a.pdf,close /data/a.pdf,read ,pread64 /data/a.pdf,write /data/b.pdf.locked,read "
+	if strings.Join(got, ",") != want {
+		t.Errorf("got %q, want %q", strings.Join(got, ","), want)
+	}
+}
+
+func TestParseObservation(t *testing.T) {
+	e, err := ParseObservation("rpc:payments.Charge|3|env=prod,region=eu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Key() != "rpc:payments.Charge" || e.Weight() != 3 || e.Labels["region"] != "eu" {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if e, err = ParseObservation("network:10.0.0.9:443"); err != nil || e.Key() != "network:10.0.0.9:443" || e.Weight() != 1 {
+		t.Errorf("expected a network observation to merge with collected events, got %+v, %v", e, err)
+	}
+	for _, line := range []string{"rpc:x|2||2026-01-01T00:00:30Z", "rpc:x|2||1767225630"} {
+		if e, err = ParseObservation(line); err != nil || !e.Timestamp.Equal(time.Date(2026, 1, 1, 0, 0, 30, 0, time.UTC)) {
+			t.Errorf("%q: expected the observation time, got %v, %v", line, e.Timestamp, err)
+		}
+	}
+	for _, line := range []string{"", "rpc", "RPC:x", "rpc:x|0", "rpc:x|many", "rpc:x|1|env", "rpc:x|1|a=b|extra", "rpc:x|1||0|extra"} {
+		if _, err := ParseObservation(line); err == nil {
+			t.Errorf("%q: expected an error", line)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package parse
+
+import (
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+// stracePattern matches a syscall line of strace output, with the optional
+// process ID prefix of -f ("[pid  42] " on a terminal, "42  " with -o) and
+// timestamp of -t, -tt or -ttt, e.g.
+//
+//	[pid  4242] 12:00:00.123456 openat(AT_FDCWD, "/etc/passwd", O_RDONLY) = 3
+//
+// Resumed calls ("<... read resumed>"), signals and exits do not match, so
+// each call is counted once, at entry.
+var stracePattern = regexp.MustCompile(
+	`^(?:\[pid\s+(\d+)\]\s+|(\d+)\s+)?(?:(\d+\.\d+|\d{2}:\d{2}:\d{2}(?:\.\d+)?)\s+)?(\w+)\((.*)$`)
+
+var (
+	straceString = regexp.MustCompile(`"(/[^"]*)"`)
+	stracePort   = regexp.MustCompile(`sin6?_port=htons\((\d+)\)`)
+	straceAddr   = regexp.MustCompile(`inet_addr\("([^"]+)"\)|inet_pton\(AF_INET6, "([^"]+)"`)
+	straceFailed = regexp.MustCompile(`\) = -1 [A-Z]+`)
+	// straceFD matches a descriptor argument, with the path -y annotates
+	// it with: "3" or "3</etc/passwd>".
+	straceFD = regexp.MustCompile(`^\s*(\d+)(?:<([^>]*)>)?`)
+	// straceResult matches the descriptor a call returned.
+	straceResult = regexp.MustCompile(`\)\s+=\s+(\d+)(?:<[^>]*>)?\s*$`)
+	straceExit   = regexp.MustCompile(`^(?:\[pid\s+(\d+)\]\s+|(\d+)\s+)?\+\+\+ (?:exited|killed)`)
+)
+
+// straceFDCalls are the calls whose first argument is the descriptor of
+// the file they act on, rather than a path. Their quoted arguments are
+// data, such as the buffer read, and never their path.
+var straceFDCalls = map[string]bool{
+	"read": true, "pread64": true, "readv": true, "preadv": true, "preadv2": true,
+	"write": true, "pwrite64": true, "writev": true, "pwritev": true, "pwritev2": true,
+	"ftruncate": true, "fsync": true, "fdatasync": true, "fchmod": true, "fchown": true,
+	"close": true, "dup": true, "dup2": true, "dup3": true, "fcntl": true,
+}
+
+// straceTransfers are the descriptor calls returning the bytes they read
+// or wrote.
+var straceTransfers = map[string]bool{
+	"read": true, "pread64": true, "readv": true, "preadv": true, "preadv2": true,
+	"write": true, "pwrite64": true, "writev": true, "pwritev": true, "pwritev2": true,
+}
+
+// ParseStrace parses a line of strace output. Calls become syscall events
+// with the first absolute path argument as Path, and for renames the second
+// as "new_path"; connect to an IPv4 or IPv6 address becomes a network event
+// and a successful execve a process event. Reads, writes and other calls
+// on a descriptor take their Path from the descriptor's -y annotation
+// ("3</etc/passwd>"), if any; StraceFiles resolves plain descriptors.
+// Reads and writes carry the bytes they returned as Bytes.
+// Times of day (-t, -tt) are placed on day. It returns ok == false for lines
+// that are not syscall entries.
+func ParseStrace(line string, day time.Time) (detect.SystemEvent, bool) {
+	m := stracePattern.FindStringSubmatch(line)
+	if m == nil {
+		return detect.SystemEvent{}, false
+	}
+	pid, _ := strconv.Atoi(m[1] + m[2])
+	name, args := m[4], m[5]
+
+	event := detect.SystemEvent{
+		Type: "syscall",
+		PID:  pid,
+		Data: map[string]interface{}{"syscall": name, "source": "strace"},
+	}
+	event.Timestamp = straceTime(m[3], day)
+	var paths [][]string
+	if straceFDCalls[name] {
+		if fd := straceFD.FindStringSubmatch(args); fd != nil {
+			event.Data["fd"], _ = strconv.Atoi(fd[1])
+			if strings.HasPrefix(fd[2], "/") {
+				event.Path = fd[2]
+			}
+		}
+		if m := straceResult.FindStringSubmatch(args); m != nil && straceTransfers[name] {
+			event.Bytes, _ = strconv.ParseInt(m[1], 10, 64)
+		}
+	} else if paths = straceString.FindAllStringSubmatch(args, 2); len(paths) > 0 {
+		event.Path = paths[0][1]
+	}
+
+	switch name {
+	case "execve", "execveat":
+		if event.Path == "" || straceFailed.MatchString(args) {
+			// Shells try every $PATH entry; only the program that ran
+			// is behavior.
+			break
+		}
+		event.Type = "process"
+		event.ProcessName = filepath.Base(event.Path)
+		event.Data["action"] = "exec"
+	case "rename", "renameat", "renameat2":
+		if len(paths) == 2 {
+			event.Data["new_path"] = paths[1][1]
+		}
+	case "connect":
+		port, addr := stracePort.FindStringSubmatch(args), straceAddr.FindStringSubmatch(args)
+		if port == nil || addr == nil {
+			break
+		}
+		destination := net.JoinHostPort(addr[1]+addr[2], port[1])
+		event.Type = "network"
+		event.Data["action"] = "connect"
+		event.Data["destination"] = destination
+		event.Data["ip"] = addr[1] + addr[2]
+		event.Data["port"], _ = strconv.Atoi(port[1])
+	}
+	return event, true
+}
+
+// StraceFiles parses strace output like ParseStrace, following the files
+// each process opens so that calls on a descriptor, such as reads and
+// writes, get the path it was opened with as their Path. Descriptors are
+// learned from successful open, openat, openat2 and creat calls and dup
+// calls, and forgotten on close and when the process exits. Calls strace
+// splits into "unfinished" and "resumed" lines are not followed, and
+// descriptors inherited across fork or opened before tracing began stay
+// unresolved. A StraceFiles is not safe for concurrent use.
+type StraceFiles struct {
+	open map[int]map[int]string // pid → descriptor → path
+}
+
+// NewStraceFiles returns a parser following no open files yet.
+func NewStraceFiles() *StraceFiles {
+	return &StraceFiles{open: make(map[int]map[int]string)}
+}
+
+// Parse parses a line of strace output like ParseStrace, resolving
+// descriptors to the paths they were opened with.
+func (s *StraceFiles) Parse(line string, day time.Time) (detect.SystemEvent, bool) {
+	event, ok := ParseStrace(line, day)
+	if !ok {
+		if m := straceExit.FindStringSubmatch(line); m != nil {
+			pid, _ := strconv.Atoi(m[1] + m[2])
+			delete(s.open, pid)
+		}
+		return event, false
+	}
+	name, _ := event.Data["syscall"].(string)
+	fd, onFD := event.Data["fd"].(int)
+	files := s.open[event.PID]
+	if onFD && event.Path == "" {
+		event.Path = files[fd]
+	}
+	result := -1
+	if m := straceResult.FindStringSubmatch(line); m != nil {
+		result, _ = strconv.Atoi(m[1])
+	}
+	switch {
+	case name == "close" && onFD:
+		delete(files, fd)
+	case result < 0 || event.Path == "":
+	case name == "open" || name == "openat" || name == "openat2" || name == "creat",
+		name == "dup" || name == "dup2" || name == "dup3",
+		name == "fcntl" && strings.Contains(line, "F_DUPFD"):
+		if files == nil {
+			files = make(map[int]string)
+			s.open[event.PID] = files
+		}
+		files[result] = event.Path
+	}
+	return event, true
+}
+
+// straceTime parses an strace timestamp: seconds since the epoch (-ttt) or
+// a time of day (-t, -tt) on day. It returns the zero time for none.
+func straceTime(s string, day time.Time) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	if !strings.Contains(s, ":") {
+		sec, frac, _ := strings.Cut(s, ".")
+		secs, err := strconv.ParseInt(sec, 10, 64)
+		if err != nil {
+			return time.Time{}
+		}
+		nanos, _ := strconv.ParseInt((frac + "000000000")[:9], 10, 64)
+		return time.Unix(secs, nanos)
+	}
+	t, err := time.Parse("15:04:05.999999", s)
+	if err != nil {
+		return time.Time{}
+	}
+	y, mo, d := day.Date()
+	return time.Date(y, mo, d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), day.Location())
+}
@@ -0,0 +1,61 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// AlertTestType is the type of the anomaly AlertTest injects.
+const AlertTestType = "Alert Test"
+
+// AlertTestLabel is the label set on the event of an alert test, so routes
+// and receivers can tell it apart from real findings.
+const AlertTestLabel = "runtimebase_alert_test"
+
+// AlertTest returns a synthetic record carrying one anomaly of the given
+// severity, for verifying that alerts reach the people paged by them. Its
+// event carries labels, for label routes, and AlertTestLabel; the record
+// starts out assigned to baselineName, which routers may replace.
+func AlertTest(level, baselineName string, labels map[string]string, now time.Time) *Record {
+	label := severity.Label(level)
+	eventLabels := map[string]string{AlertTestLabel: "true"}
+	for k, v := range labels {
+		eventLabels[k] = v
+	}
+	return &Record{
+		Source:   "alert-test",
+		Baseline: baselineName,
+		Event: &detect.SystemEvent{
+			Type:        "alert-test",
+			Timestamp:   now,
+			ProcessName: "runtimebase",
+			Data:        map[string]interface{}{},
+			Labels:      eventLabels,
+		},
+		Anomalies: []baseline.Anomaly{{
+			Type:        AlertTestType,
+			Description: "Synthetic anomaly injected by runtimebase alert-test; no action is needed",
+			Severity:    label,
+			Evidence:    "alert-test",
+			Confidence:  1,
+			Timestamp:   now,
+			RiskLevel:   label,
+		}},
+		Synthetic: true,
+	}
+}
+
+// Test passes a synthetic record, such as one from AlertTest, through the
+// pipeline's stages and sinks and flushes them. It reports whether the
+// record reached the sinks, and the first error.
+func (p *Pipeline) Test(ctx context.Context, r *Record) (bool, error) {
+	delivered, err := p.handle(ctx, r)
+	if ferr := p.Flush(ctx); err == nil {
+		err = ferr
+	}
+	return delivered, err
+}
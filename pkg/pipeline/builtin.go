@@ -0,0 +1,2216 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/category"
+	"github.com/hallucinaut/runtimebase/pkg/clock"
+	"github.com/hallucinaut/runtimebase/pkg/collect"
+	"github.com/hallucinaut/runtimebase/pkg/correlate"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/enforce"
+	"github.com/hallucinaut/runtimebase/pkg/enrich"
+	"github.com/hallucinaut/runtimebase/pkg/logfile"
+	"github.com/hallucinaut/runtimebase/pkg/ocihook"
+	"github.com/hallucinaut/runtimebase/pkg/parse"
+	"github.com/hallucinaut/runtimebase/pkg/proctree"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+	"github.com/hallucinaut/runtimebase/pkg/storage"
+)
+
+func init() {
+	Sources.Register("file", func(env *Env, opts Options) (Source, error) {
+		path, err := opts.Required("path")
+		if err != nil {
+			return nil, err
+		}
+		return File{Path: path, Follow: opts["follow"] == "true"}, nil
+	})
+	Sources.Register("datagram", func(env *Env, opts Options) (Source, error) {
+		return newDatagram(opts)
+	})
+	Sources.RegisterCheck("datagram", func(opts Options) error {
+		_, err := newDatagram(opts)
+		return err
+	})
+	Sources.Register("lsm", func(env *Env, opts Options) (Source, error) {
+		return FromCollector("lsm", &collect.LSMCollector{
+			BpftracePath: opts["bpftrace"],
+			IgnoreComms:  []string{"runtimebase"},
+		}), nil
+	})
+	Sources.Register("syscalls", func(env *Env, opts Options) (Source, error) {
+		c, err := syscallCollector(opts)
+		if err != nil {
+			return nil, err
+		}
+		return FromCollector("syscalls", c), nil
+	})
+	Sources.RegisterCheck("syscalls", func(opts Options) error {
+		_, err := syscallCollector(opts)
+		return err
+	})
+	Sources.Register("cgroup", func(env *Env, opts Options) (Source, error) {
+		interval, err := opts.Duration("interval", 10*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		return FromCollector("cgroup", &collect.CgroupCollector{Root: opts["root"], Interval: interval}), nil
+	})
+	Sources.Register("runtime", func(env *Env, opts Options) (Source, error) {
+		c, err := runtimeCollector(opts)
+		if err != nil {
+			return nil, err
+		}
+		logger := env.logger()
+		c.OnError = func(target string, err error) {
+			logger.Warn("scraping runtime metrics failed", "target", target, "error", err)
+		}
+		return FromCollector("runtime", c), nil
+	})
+	Sources.RegisterCheck("runtime", func(opts Options) error {
+		_, err := runtimeCollector(opts)
+		return err
+	})
+	Sources.Register("containerd", func(env *Env, opts Options) (Source, error) {
+		return FromCollector("containerd", &collect.ContainerdCollector{
+			CtrPath:   opts["ctr"],
+			Address:   opts["address"],
+			Namespace: opts["namespace"],
+			Resolver:  &collect.CRIResolver{CrictlPath: opts["crictl"]},
+		}), nil
+	})
+
+	Parsers.Register("jsonl", func(env *Env, opts Options) (Stage, error) {
+		return ParserInto(func(raw []byte, event *detect.SystemEvent) (bool, error) {
+			return true, json.Unmarshal(raw, event)
+		}), nil
+	})
+	Parsers.Register("observation", func(env *Env, opts Options) (Stage, error) {
+		// strict rejects observations of categories outside the ontology.
+		strict := opts["strict"] == "true"
+		return Parser(func(raw []byte) (detect.SystemEvent, bool, error) {
+			event, err := parse.ParseObservation(string(raw))
+			if err != nil {
+				return event, false, err
+			}
+			if strict && !category.Known(event.Type) {
+				return event, false, fmt.Errorf("observation %q: unknown category %q", raw, event.Type)
+			}
+			if event.Timestamp.IsZero() {
+				event.Timestamp = env.now()
+			}
+			return event, true, nil
+		}), nil
+	})
+	Parsers.Register("accesslog", func(env *Env, opts Options) (Stage, error) {
+		return Parser(func(raw []byte) (detect.SystemEvent, bool, error) {
+			entry, err := parse.ParseAccessLog(string(raw))
+			if err != nil {
+				return detect.SystemEvent{}, false, err
+			}
+			return entry.Event(), true, nil
+		}), nil
+	})
+	Parsers.Register("gvisor-strace", func(env *Env, opts Options) (Stage, error) {
+		year := env.now().Year()
+		if v := opts["year"]; v != "" {
+			var err error
+			if year, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("option \"year\": %w", err)
+			}
+		}
+		// gVisor writes times without a zone; timezone is the one they
+		// are in, UTC by default.
+		loc, err := opts.Location("timezone")
+		if err != nil {
+			return nil, err
+		}
+		return Parser(func(raw []byte) (detect.SystemEvent, bool, error) {
+			event, ok := parse.ParseGVisorStrace(string(raw), year)
+			if ok && loc != nil && !event.Timestamp.IsZero() {
+				event.Timestamp = clock.InZone(event.Timestamp, loc)
+			}
+			return event, ok, nil
+		}), nil
+	})
+	Parsers.Register("strace", func(env *Env, opts Options) (Stage, error) {
+		// strace writes times without a zone; timezone is the one of the
+		// traced host, the local one by default.
+		loc, err := opts.Location("timezone")
+		if err != nil {
+			return nil, err
+		}
+		day := env.now()
+		if loc != nil {
+			day = day.In(loc)
+		} else {
+			loc = time.Local
+		}
+		if v := opts["date"]; v != "" {
+			if day, err = time.ParseInLocation(time.DateOnly, v, loc); err != nil {
+				return nil, fmt.Errorf("option \"date\": %w", err)
+			}
+		}
+		files := parse.NewStraceFiles()
+		return Parser(func(raw []byte) (detect.SystemEvent, bool, error) {
+			event, ok := files.Parse(string(raw), day)
+			return event, ok, nil
+		}), nil
+	})
+	Parsers.Register("gvisor-point", func(env *Env, opts Options) (Stage, error) {
+		return Parser(parse.ParseGVisorPoint), nil
+	})
+	Parsers.Register("lsm", func(env *Env, opts Options) (Stage, error) {
+		return Parser(func(raw []byte) (detect.SystemEvent, bool, error) {
+			event, ok := collect.ParseLSMLine(string(raw), env.now())
+			return event, ok, nil
+		}), nil
+	})
+
+	Normalizers.Register("defaults", func(env *Env, opts Options) (Stage, error) {
+		return Defaults(env.now), nil
+	})
+	Normalizers.Register("labels", func(env *Env, opts Options) (Stage, error) {
+		return Labels(opts), nil
+	})
+	Normalizers.Register("clock", func(env *Env, opts Options) (Stage, error) {
+		loc, skew, estimate, err := clockOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		return Clock(loc, skew, estimate, opts.String("host", "host"), env.now, env.logger()), nil
+	})
+	Normalizers.RegisterCheck("clock", func(opts Options) error {
+		_, _, _, err := clockOptions(opts)
+		return err
+	})
+	Normalizers.Register("dedup", func(env *Env, opts Options) (Stage, error) {
+		d, err := dedupOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		if d.Metrics = env.Metrics; d.Metrics != nil {
+			d.Metrics.Describe(MetricDuplicates, "Events dropped as reported twice, by event type and the origin reporting them second.")
+		}
+		return d, nil
+	})
+	Normalizers.RegisterCheck("dedup", func(opts Options) error {
+		_, err := dedupOptions(opts)
+		return err
+	})
+
+	Enrichers.Register("reputation", func(env *Env, opts Options) (Stage, error) {
+		if env.Enricher == nil {
+			return nil, errors.New("no GeoIP, ASN or blocklist sources are configured")
+		}
+		return Reputation(env.Enricher), nil
+	})
+
+	Routers.Register("static", func(env *Env, opts Options) (Stage, error) {
+		name, err := opts.Required("baseline")
+		if err != nil {
+			return nil, err
+		}
+		return StaticRoute(name), nil
+	})
+	Routers.Register("label", func(env *Env, opts Options) (Stage, error) {
+		key, err := opts.Required("key")
+		if err != nil {
+			return nil, err
+		}
+		return LabelRoute(key, opts["prefix"], opts["default"]), nil
+	})
+	Routers.Register("session", func(env *Env, opts Options) (Stage, error) {
+		reload, err := opts.Duration("reload", 5*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		sessions := &ocihook.Sessions{Dir: opts.String("dir", ocihook.DefaultDir())}
+		return SessionRoute(sessions, proctree.New(), reload, opts["default"], env.logger()), nil
+	})
+
+	Processors.Register("learn", func(env *Env, opts Options) (Stage, error) {
+		interval, err := opts.Duration("interval", time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		lateness, err := opts.Duration("lateness", 30*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		// label.<key> options set metadata on the learned baselines.
+		metadata := make(map[string]string)
+		for key, value := range opts {
+			if name, ok := strings.CutPrefix(key, "label."); ok {
+				metadata[name] = value
+			}
+		}
+		// cold_start turns cold start on for the learned baselines, for
+		// DefaultColdStart or a duration, or off.
+		var coldStart time.Duration
+		switch v := opts["cold_start"]; v {
+		case "on":
+			coldStart = baseline.DefaultColdStart
+		case "off":
+			coldStart = -1
+		default:
+			if coldStart, err = opts.Duration("cold_start", 0); err != nil {
+				return nil, err
+			}
+			if coldStart < 0 {
+				return nil, fmt.Errorf("option cold_start: want on, off or a duration, got %q", v)
+			}
+		}
+		l := newLearner(env.Store, interval, lateness)
+		l.metadata, l.coldStart = metadata, coldStart
+		return l, nil
+	})
+	Processors.Register("detect", func(env *Env, opts Options) (Stage, error) {
+		reload, err := opts.Duration("reload", time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		return Detect(env.Store, reload, env.logger()), nil
+	})
+
+	Processors.Register("volume", func(env *Env, opts Options) (Stage, error) {
+		interval, err := opts.Duration("interval", time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		reload, err := opts.Duration("reload", time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		return Volume(env.Store, interval, reload, env.logger()), nil
+	})
+
+	Processors.Register("spikes", func(env *Env, opts Options) (Stage, error) {
+		interval, err := opts.Duration("interval", time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		reload, err := opts.Duration("reload", time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		percentile, margin := detect.DefaultPercentile, detect.DefaultMargin
+		if p := opts["percentile"]; p != "" {
+			if percentile, err = strconv.ParseFloat(p, 64); err != nil || percentile <= 0 || percentile >= 1 {
+				return nil, fmt.Errorf("option percentile: want a fraction between 0 and 1, got %q", p)
+			}
+		}
+		if m := opts["margin"]; m != "" {
+			if margin, err = strconv.ParseFloat(m, 64); err != nil || margin < 0 {
+				return nil, fmt.Errorf("option margin: want a non-negative fraction, got %q", m)
+			}
+		}
+		return Spikes(env.Store, interval, percentile, margin, reload, env.logger()), nil
+	})
+
+	Processors.Register("resource", func(env *Env, opts Options) (Stage, error) {
+		interval, err := opts.Duration("interval", time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		reload, err := opts.Duration("reload", time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		sustained := baseline.DefaultSustainedIntervals
+		if s := opts["sustained"]; s != "" {
+			if sustained, err = strconv.Atoi(s); err != nil || sustained < 1 {
+				return nil, fmt.Errorf("option sustained: want a positive number of intervals, got %q", s)
+			}
+		}
+		return Resource(env.Store, interval, sustained, reload, env.logger()), nil
+	})
+
+	Processors.Register("exits", func(env *Env, opts Options) (Stage, error) {
+		interval, err := opts.Duration("interval", time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		reload, err := opts.Duration("reload", time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("option interval: want a positive duration, got %s", interval)
+		}
+		return Exits(env.Store, interval, reload, env.logger()), nil
+	})
+
+	Processors.Register("tls", func(env *Env, opts Options) (Stage, error) {
+		interval, err := opts.Duration("interval", time.Hour)
+		if err != nil {
+			return nil, err
+		}
+		reload, err := opts.Duration("reload", time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("option interval: want a positive duration, got %s", interval)
+		}
+		return TLS(env.Store, interval, reload, env.logger()), nil
+	})
+
+	Processors.Register("silence", func(env *Env, opts Options) (Stage, error) {
+		every, err := opts.Duration("check", time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		reload, err := opts.Duration("reload", time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		if s := opts["baselines"]; s != "" {
+			names = strings.Split(s, ",")
+		}
+		return Silence(env.Store, names, every, reload, env.now, env.logger()), nil
+	})
+
+	Processors.Register("graph", func(env *Env, opts Options) (Stage, error) {
+		reload, err := opts.Duration("reload", time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		return Graph(env.Store, reload, env.logger()), nil
+	})
+
+	Processors.Register("cluster", func(env *Env, opts Options) (Stage, error) {
+		window, err := opts.Duration("window", baseline.DefaultIncidentWindow)
+		if err != nil {
+			return nil, err
+		}
+		return Cluster(window), nil
+	})
+
+	Processors.Register("escalate", func(env *Env, opts Options) (Stage, error) {
+		window, err := opts.Duration("window", baseline.DefaultEscalationWindow)
+		if err != nil {
+			return nil, err
+		}
+		if window <= 0 {
+			return nil, fmt.Errorf("option window: want a positive duration, got %s", window)
+		}
+		policy, err := escalationPolicy(opts)
+		if err != nil {
+			return nil, err
+		}
+		return Escalate(window, policy, env.now), nil
+	})
+	Processors.RegisterCheck("escalate", func(opts Options) error {
+		_, err := escalationPolicy(opts)
+		return err
+	})
+
+	Processors.Register("correlate", func(env *Env, opts Options) (Stage, error) {
+		var names []string
+		if s := opts["packs"]; s != "" {
+			names = strings.Split(s, ",")
+		}
+		packs, err := correlate.Lookup(names...)
+		if err != nil {
+			return nil, err
+		}
+		level := opts["severity"]
+		if level != "" && !severity.Known(level) {
+			return nil, fmt.Errorf("option severity: unknown severity %q", level)
+		}
+		reload, err := opts.Duration("reload", time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		engine := correlate.New(packs...)
+		if env.Metrics != nil {
+			engine.WithTiming(timeRules(env.Metrics))
+		}
+		return Correlate(engine, level, env.Store, reload, env.logger()), nil
+	})
+
+	Processors.Register("shadow", func(env *Env, opts Options) (Stage, error) {
+		interval, err := opts.Duration("interval", time.Minute)
+		if err != nil {
+			return nil, err
+		}
+		report, err := opts.Duration("report", time.Hour)
+		if err != nil {
+			return nil, err
+		}
+		threshold := DefaultDivergenceThreshold
+		if s := opts["threshold"]; s != "" {
+			if threshold, err = strconv.ParseFloat(s, 64); err != nil || threshold < 0 || threshold > 1 {
+				return nil, fmt.Errorf("option threshold: want a fraction between 0 and 1, got %q", s)
+			}
+		}
+		return Shadow(env.Store, opts.String("suffix", DefaultCandidateSuffix), interval, report, threshold, env.logger()), nil
+	})
+
+	Processors.Register("drift", func(env *Env, opts Options) (Stage, error) {
+		spec, err := driftSpec(opts)
+		if err != nil {
+			return nil, err
+		}
+		return Drift(env.Store, spec, env.logger()), nil
+	})
+	Processors.RegisterCheck("drift", func(opts Options) error {
+		_, err := driftSpec(opts)
+		return err
+	})
+
+	Processors.Register("capture", func(env *Env, opts Options) (Stage, error) {
+		var dir string
+		if env.Store != nil {
+			dir = filepath.Join(env.Store.Dir, "captures")
+		}
+		spec, err := captureSpec(opts, dir)
+		if err != nil {
+			return nil, err
+		}
+		if env.Store != nil && env.Store.Cipher != nil {
+			spec.Seal = env.Store.SealCapture
+		}
+		return Capture(spec, env.now, env.logger()), nil
+	})
+	Processors.RegisterCheck("capture", func(opts Options) error {
+		_, err := captureSpec(opts, "captures")
+		return err
+	})
+
+	Sinks.Register("history", func(env *Env, opts Options) (Sink, error) {
+		return History(env.Store), nil
+	})
+	Sinks.Register("jsonl", func(env *Env, opts Options) (Sink, error) {
+		path := opts.String("path", "-")
+		if path == "-" {
+			return JSONLines(os.Stdout), nil
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		return JSONLines(f), nil
+	})
+	Sinks.Register("log", func(env *Env, opts Options) (Sink, error) {
+		return Log(env.logger()), nil
+	})
+	Sinks.Register("webhook", func(env *Env, opts Options) (Sink, error) {
+		w, err := newWebhook(opts, true)
+		if err != nil {
+			return nil, err
+		}
+		if env.Store != nil {
+			w.Namespace = env.Store.Namespace
+		}
+		return w, nil
+	})
+	Sinks.RegisterCheck("webhook", func(opts Options) error {
+		_, err := newWebhook(opts, false)
+		return err
+	})
+	Sinks.Register("email", func(env *Env, opts Options) (Sink, error) {
+		e, err := newEmail(opts, true)
+		if err != nil {
+			return nil, err
+		}
+		if env.Store != nil {
+			e.Namespace = env.Store.Namespace
+		}
+		return e, nil
+	})
+	Sinks.RegisterCheck("email", func(opts Options) error {
+		_, err := newEmail(opts, false)
+		return err
+	})
+	Sinks.Register("syslog", func(env *Env, opts Options) (Sink, error) {
+		s, err := newSyslog(opts)
+		if err != nil {
+			return nil, err
+		}
+		if env.Store != nil {
+			s.Namespace = env.Store.Namespace
+		}
+		return s, nil
+	})
+	Sinks.RegisterCheck("syslog", func(opts Options) error {
+		_, err := newSyslog(opts)
+		return err
+	})
+	Sinks.Register("github", func(env *Env, opts Options) (Sink, error) {
+		g, err := newGitHubIssues(opts, true)
+		if err != nil {
+			return nil, err
+		}
+		return env.tickets(g, opts, "github", g.Repo)
+	})
+	Sinks.RegisterCheck("github", func(opts Options) error {
+		g, err := newGitHubIssues(opts, false)
+		if err == nil {
+			_, err = newTickets(g, opts, "", "github", g.Repo)
+		}
+		return err
+	})
+	Sinks.Register("jira", func(env *Env, opts Options) (Sink, error) {
+		j, err := newJira(opts, true)
+		if err != nil {
+			return nil, err
+		}
+		return env.tickets(j, opts, "jira", j.Project)
+	})
+	Sinks.RegisterCheck("jira", func(opts Options) error {
+		j, err := newJira(opts, false)
+		if err == nil {
+			_, err = newTickets(j, opts, "", "jira", j.Project)
+		}
+		return err
+	})
+}
+
+// tickets returns a ticket sink for tracker, keeping its open tickets in
+// the store.
+func (e *Env) tickets(tracker Tracker, opts Options, kind, target string) (Sink, error) {
+	var dir string
+	if e.Store != nil {
+		dir = e.Store.Dir
+	}
+	t, err := newTickets(tracker, opts, dir, kind, target)
+	if err != nil {
+		return nil, err
+	}
+	if e.Store != nil {
+		t.Namespace = e.Store.Namespace
+	}
+	t.Clock = e.Clock
+	return t, nil
+}
+
+func (e *Env) logger() *slog.Logger {
+	if e.Logger != nil {
+		return e.Logger
+	}
+	return slog.Default().With("component", "pipeline")
+}
+
+// File is a source reading lines from a file, a named pipe, or standard
+// input for "-", as raw records. Compressed files are decompressed. With
+// Follow, a regular file is tailed like `tail -F` until the context is
+// cancelled.
+type File struct {
+	Path   string
+	Follow bool
+}
+
+// Run implements Source.
+func (f File) Run(ctx context.Context, out chan<- *Record) error {
+	emit := func(line string) bool {
+		if line == "" {
+			return true
+		}
+		select {
+		case out <- NewRecord(f.Path, []byte(line)):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	if f.Follow && !logfile.IsStream(f.Path) {
+		follower := &logfile.Follower{Path: f.Path, FromStart: true}
+		return follower.Run(ctx, func(line string) { emit(line) })
+	}
+	err := logfile.ScanLines([]string{f.Path}, false, func(line string) error {
+		if !emit(line) {
+			return ctx.Err()
+		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// Parser returns a parse stage decoding raw records with fn. Records that
+// already carry an event pass through; records fn reports as not an event
+// are dropped.
+func Parser(fn func(raw []byte) (detect.SystemEvent, bool, error)) Stage {
+	return StageFunc(func(_ context.Context, r *Record) (bool, error) {
+		if r.Event != nil {
+			return true, nil
+		}
+		event, ok, err := fn(r.Raw)
+		if err != nil || !ok {
+			return false, err
+		}
+		r.Event = &event
+		return true, nil
+	})
+}
+
+// ParserInto is Parser for decoders that fill in an event, which is taken
+// from the pool of detect.AcquireEvent so its maps are reused.
+func ParserInto(fn func(raw []byte, e *detect.SystemEvent) (bool, error)) Stage {
+	return StageFunc(func(_ context.Context, r *Record) (bool, error) {
+		if r.Event != nil {
+			return true, nil
+		}
+		event := detect.AcquireEvent()
+		ok, err := fn(r.Raw, event)
+		if err != nil || !ok {
+			detect.ReleaseEvent(event)
+			return false, err
+		}
+		r.Event = event
+		return true, nil
+	})
+}
+
+// Defaults returns a normalizer that timestamps events without a time,
+// ensures Data is set and lower-cases the event type.
+func Defaults(now func() time.Time) Stage {
+	return StageFunc(func(_ context.Context, r *Record) (bool, error) {
+		e := r.Event
+		if e.Timestamp.IsZero() {
+			e.Timestamp = now()
+		}
+		if e.Data == nil {
+			e.Data = make(map[string]interface{})
+		}
+		e.Type = strings.ToLower(strings.TrimSpace(e.Type))
+		return true, nil
+	})
+}
+
+// Labels returns a normalizer adding labels to events that do not already
+// have them.
+func Labels(labels map[string]string) Stage {
+	return StageFunc(func(_ context.Context, r *Record) (bool, error) {
+		if r.Event.Labels == nil {
+			r.Event.Labels = make(map[string]string, len(labels))
+		}
+		for k, v := range labels {
+			if _, set := r.Event.Labels[k]; !set {
+				r.Event.Labels[k] = v
+			}
+		}
+		return true, nil
+	})
+}
+
+// Clock returns a normalizer lining up the times of events from hosts
+// whose clocks disagree. Times of events are read in loc, when set, as
+// written without a zone; then, when estimate is set, the offset of the
+// event's host's clock is estimated from when events arrive (see
+// clock.Skew) and taken off, as are offsets fixed in skew. An event's host
+// is its label hostLabel, or else the source of its record.
+func Clock(loc *time.Location, skew *clock.Skew, estimate bool, hostLabel string, now func() time.Time, logger *slog.Logger) Stage {
+	return StageFunc(func(_ context.Context, r *Record) (bool, error) {
+		e := r.Event
+		if e.Timestamp.IsZero() {
+			return true, nil
+		}
+		if loc != nil {
+			e.Timestamp = clock.InZone(e.Timestamp, loc)
+		}
+		host := e.Labels[hostLabel]
+		if host == "" {
+			host = r.Source
+		}
+		offset := skew.Offset(host)
+		if estimate {
+			var changed bool
+			if offset, changed = skew.Observe(host, e.Timestamp, now()); changed {
+				logger.Info("clock skew", "host", host, "offset", offset)
+			}
+		}
+		e.Timestamp = e.Timestamp.Add(-offset)
+		return true, nil
+	})
+}
+
+// clockOptions returns the clock normalizer's settings from its options:
+// the timezone times are written in, whether skew is "auto"-estimated or
+// "off", the number of samples, tolerance and limit of estimates, and
+// offsets fixed per host, as comma-separated "host=offset" pairs.
+func clockOptions(opts Options) (*time.Location, *clock.Skew, bool, error) {
+	loc, err := opts.Location("timezone")
+	if err != nil {
+		return nil, nil, false, err
+	}
+	skew := clock.NewSkew()
+	var estimate bool
+	switch v := opts.String("skew", "off"); v {
+	case "auto":
+		estimate = true
+	case "off":
+	default:
+		return nil, nil, false, fmt.Errorf("option skew: want auto or off, got %q", v)
+	}
+	if s := opts["samples"]; s != "" {
+		if skew.Samples, err = strconv.Atoi(s); err != nil || skew.Samples < 1 {
+			return nil, nil, false, fmt.Errorf("option samples: want a positive number, got %q", s)
+		}
+	}
+	if skew.Tolerance, err = opts.Duration("tolerance", clock.DefaultSkewTolerance); err != nil {
+		return nil, nil, false, err
+	}
+	if skew.Limit, err = opts.Duration("limit", clock.DefaultSkewLimit); err != nil {
+		return nil, nil, false, err
+	}
+	if list := opts["offsets"]; list != "" {
+		for _, pair := range strings.Split(list, ",") {
+			host, v, _ := strings.Cut(strings.TrimSpace(pair), "=")
+			offset, err := time.ParseDuration(v)
+			if host == "" || err != nil {
+				return nil, nil, false, fmt.Errorf("option offsets: want host=offset pairs, got %q", pair)
+			}
+			skew.Fix(host, offset)
+		}
+	}
+	if loc == nil && !estimate && opts["offsets"] == "" {
+		return nil, nil, false, errors.New("one of options timezone, skew and offsets is required")
+	}
+	return loc, skew, estimate, nil
+}
+
+// Reputation returns an enricher annotating records with the reputation of
+// their event's network peer.
+func Reputation(e *enrich.Enricher) Stage {
+	return StageFunc(func(_ context.Context, r *Record) (bool, error) {
+		if enrichment := e.Event(*r.Event); enrichment != nil {
+			if r.Enrichment == nil {
+				r.Enrichment = make(map[string]string)
+			}
+			for k, v := range enrichment {
+				r.Enrichment[k] = v
+			}
+		}
+		return true, nil
+	})
+}
+
+// StaticRoute returns a router sending every record to one baseline.
+func StaticRoute(name string) Stage {
+	return StageFunc(func(_ context.Context, r *Record) (bool, error) {
+		r.Baseline = name
+		return true, nil
+	})
+}
+
+// LabelRoute returns a router sending records to the baseline named by
+// prefix and the value of an event label, e.g. label "app" and prefix
+// "prod-" route app=web to prod-web. Events without the label go to def,
+// or are dropped if def is empty.
+func LabelRoute(key, prefix, def string) Stage {
+	return StageFunc(func(_ context.Context, r *Record) (bool, error) {
+		if value := r.Event.Labels[key]; value != "" {
+			r.Baseline = prefix + value
+		} else {
+			r.Baseline = def
+		}
+		return r.Baseline != "", nil
+	})
+}
+
+// learner aggregates operation counts, byte volumes, resource peaks and
+// graph edges per baseline over an interval and records them as
+// observations.
+type learner struct {
+	store    *storage.Store
+	interval time.Duration
+	lateness time.Duration
+	metadata map[string]string
+	// coldStart, when set, is the cold start of the learned baselines;
+	// see baseline.Baseline.ColdStart.
+	coldStart time.Duration
+
+	mu        sync.Mutex
+	start     time.Time
+	watermark time.Time                             // latest event time
+	late      map[string]int                        // baseline → events too late for their interval
+	counts    map[string]map[bucketKey]int          // baseline → bucket and key → count
+	active    map[string]map[string]time.Time       // baseline → key → first event time, without an interval
+	volumes   map[string]map[string]int64           // baseline → volume key → bytes
+	resources map[string]map[string]float64         // baseline → resource key → peak
+	edges     map[string]map[string]baseline.Edge   // baseline → edge key → edge
+	exits     map[string]map[string]map[string]int  // baseline → executable → status → exits
+	tls       map[string]map[baseline.Handshake]int // baseline → TLS handshake → count
+	open      map[string]bool                       // baselines with intervals left open
+	tracker   *detect.EdgeTracker
+}
+
+// bucketKey is a statistic key in the interval of event time starting at
+// start.
+type bucketKey struct {
+	start time.Time
+	key   string
+}
+
+// Learn returns a processor counting each baseline's operations and the
+// bytes they move over intervals of event time and recording each
+// interval's counts as observations, when each operation was active as
+// activity (see baseline.RecordActivity), volumes as volume samples and
+// the peak of each resource measurement as a resource sample, and the
+// exits of each executable by status (see baseline.RecordExits) and the
+// TLS client fingerprints of each process (see baseline.RecordTLS), saving
+// the baselines as it goes. It also learns the behavior graph: processes
+// spawned, destinations connected to and files written, per process.
+// Missing baselines are created.
+//
+// Operations are counted into the interval their event time falls in, so
+// events arriving out of order by up to lateness still count where they
+// belong; see baseline.RecordObservationAt. Later ones are dropped and
+// counted in the baseline's Late. metadata is set on the baselines learned;
+// see baseline.SetMetadata.
+func Learn(store *storage.Store, interval, lateness time.Duration, metadata map[string]string) Stage {
+	l := newLearner(store, interval, lateness)
+	l.metadata = metadata
+	return l
+}
+
+func newLearner(store *storage.Store, interval, lateness time.Duration) *learner {
+	return &learner{
+		store:     store,
+		interval:  interval,
+		lateness:  lateness,
+		counts:    make(map[string]map[bucketKey]int),
+		active:    make(map[string]map[string]time.Time),
+		volumes:   make(map[string]map[string]int64),
+		resources: make(map[string]map[string]float64),
+		edges:     make(map[string]map[string]baseline.Edge),
+		exits:     make(map[string]map[string]map[string]int),
+		tls:       make(map[string]map[baseline.Handshake]int),
+		open:      make(map[string]bool),
+		late:      make(map[string]int),
+		tracker:   detect.NewEdgeTracker(),
+	}
+}
+
+// Process implements Stage.
+func (l *learner) Process(ctx context.Context, r *Record) (bool, error) {
+	if r.Synthetic {
+		return true, nil
+	}
+	key := r.Event.Key()
+	volumeKey := r.Event.VolumeKey()
+	if r.Event.Bytes <= 0 {
+		volumeKey = ""
+	}
+	resourceKey := r.Event.ResourceKey()
+	executable, status, isExit := r.Event.ExitStatus()
+	handshake, isHandshake := r.Event.Handshake()
+	l.mu.Lock()
+	edge, isEdge := l.tracker.Edge(*r.Event)
+	if key == "" && volumeKey == "" && resourceKey == "" && !isEdge && !isExit && !isHandshake {
+		l.mu.Unlock()
+		return true, nil
+	}
+	if l.start.IsZero() {
+		l.start = r.Event.Timestamp
+	}
+	var err error
+	if l.interval > 0 && r.Event.Timestamp.Sub(l.start) >= l.interval {
+		err = l.flushLocked()
+		l.start = r.Event.Timestamp
+	}
+	var start time.Time
+	if key != "" && l.interval > 0 {
+		if r.Event.Timestamp.After(l.watermark) {
+			l.watermark = r.Event.Timestamp
+		}
+		start = r.Event.Timestamp.Truncate(l.interval)
+		if !start.Add(l.interval).After(l.watermark.Add(-l.lateness)) {
+			l.late[r.Baseline]++
+			key = ""
+		}
+	}
+	if key != "" {
+		counts := l.counts[r.Baseline]
+		if counts == nil {
+			counts = make(map[bucketKey]int)
+			l.counts[r.Baseline] = counts
+		}
+		if l.interval <= 0 {
+			active := l.active[r.Baseline]
+			if active == nil {
+				active = make(map[string]time.Time)
+				l.active[r.Baseline] = active
+			}
+			if _, seen := active[key]; !seen {
+				active[key] = r.Event.Timestamp
+			}
+		}
+		counts[bucketKey{start, key}] += r.Event.Weight()
+	}
+	if volumeKey != "" {
+		addVolume(l.volumes, r.Baseline, volumeKey, r.Event.Bytes*int64(r.Event.Weight()))
+	}
+	if resourceKey != "" {
+		addPeak(l.resources, r.Baseline, resourceKey, r.Event.ResourceValue())
+	}
+	if isEdge {
+		addEdge(l.edges, r.Baseline, edge)
+	}
+	if isExit {
+		addExit(l.exits, r.Baseline, executable, status, r.Event.Weight())
+	}
+	if isHandshake {
+		if l.tls[r.Baseline] == nil {
+			l.tls[r.Baseline] = make(map[baseline.Handshake]int)
+		}
+		l.tls[r.Baseline][handshake] += r.Event.Weight()
+	}
+	l.mu.Unlock()
+	return true, err
+}
+
+// Flush implements Flusher. It closes the baselines' open intervals, as no
+// more events are expected.
+func (l *learner) Flush(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.save(true)
+}
+
+func (l *learner) flushLocked() error {
+	return l.save(false)
+}
+
+// save records what was counted into the baselines and saves them,
+// closing all their open intervals when closeAll is set.
+func (l *learner) save(closeAll bool) error {
+	var first error
+	names := make(map[string]bool)
+	for name := range l.counts {
+		names[name] = true
+	}
+	for name := range l.volumes {
+		names[name] = true
+	}
+	for name := range l.resources {
+		names[name] = true
+	}
+	for name := range l.edges {
+		names[name] = true
+	}
+	for name := range l.late {
+		names[name] = true
+	}
+	for name := range l.exits {
+		names[name] = true
+	}
+	for name := range l.tls {
+		names[name] = true
+	}
+	if closeAll {
+		for name := range l.open {
+			names[name] = true
+		}
+	}
+	for name := range names {
+		counts := l.counts[name]
+		// Update holds the store's lock, so a concurrent override or
+		// promotion by the CLI is not lost.
+		err := l.store.Update(name, func(b *baseline.Baseline) (*baseline.Baseline, error) {
+			if b == nil {
+				b = baseline.NewLearner().CreateBaseline(name, baseline.WithBucketWidth(l.interval))
+			}
+			if l.interval > 0 && len(b.Open) == 0 {
+				b.BucketWidth = l.interval
+			}
+			b.Lateness = l.lateness
+			b.SetMetadata(l.metadata)
+			if l.coldStart != 0 {
+				b.ColdStart = l.coldStart
+			}
+			observations := make([]baseline.Observation, 0, len(counts))
+			for bk, count := range counts {
+				category, pattern, _ := strings.Cut(bk.key, ":")
+				observations = append(observations, baseline.Observation{Category: category, Pattern: pattern, Count: count, Time: bk.start})
+			}
+			b.RecordObservations(observations)
+			b.Late += l.late[name]
+			if l.interval > 0 {
+				b.Advance(l.watermark)
+			}
+			if closeAll {
+				b.CloseBuckets()
+			}
+			if len(b.Open) > 0 {
+				l.open[name] = true
+			} else {
+				delete(l.open, name)
+			}
+			b.RecordActivity(l.active[name])
+			b.RecordVolumes(l.volumes[name])
+			b.RecordResources(l.resources[name])
+			edges := make([]baseline.Edge, 0, len(l.edges[name]))
+			for _, edge := range l.edges[name] {
+				edges = append(edges, edge)
+			}
+			b.RecordEdges(edges)
+			b.RecordExits(l.exits[name])
+			b.RecordTLS(l.tls[name])
+			return b, nil
+		})
+		if err != nil && first == nil {
+			first = fmt.Errorf("learning %s: %w", name, err)
+		}
+	}
+	l.counts = make(map[string]map[bucketKey]int)
+	l.late = make(map[string]int)
+	l.active = make(map[string]map[string]time.Time)
+	l.volumes = make(map[string]map[string]int64)
+	l.resources = make(map[string]map[string]float64)
+	l.exits = make(map[string]map[string]map[string]int)
+	l.tls = make(map[string]map[baseline.Handshake]int)
+	l.edges = make(map[string]map[string]baseline.Edge)
+	return first
+}
+
+func addVolume(volumes map[string]map[string]int64, name, key string, bytes int64) {
+	perKey := volumes[name]
+	if perKey == nil {
+		perKey = make(map[string]int64)
+		volumes[name] = perKey
+	}
+	perKey[key] += bytes
+}
+
+func addEdge(edges map[string]map[string]baseline.Edge, name string, edge baseline.Edge) {
+	perKey := edges[name]
+	if perKey == nil {
+		perKey = make(map[string]baseline.Edge)
+		edges[name] = perKey
+	}
+	key := edge.Key()
+	if known, seen := perKey[key]; seen {
+		known.Count += edge.Count
+		known.LastSeen = edge.LastSeen
+		edge = known
+	} else {
+		edge.FirstSeen = edge.LastSeen
+	}
+	perKey[key] = edge
+}
+
+func addPeak(peaks map[string]map[string]float64, name, key string, value float64) {
+	perKey := peaks[name]
+	if perKey == nil {
+		perKey = make(map[string]float64)
+		peaks[name] = perKey
+	}
+	if peak, seen := perKey[key]; !seen || value > peak {
+		perKey[key] = value
+	}
+}
+
+func addExit(exits map[string]map[string]map[string]int, name, executable, status string, n int) {
+	perExecutable := exits[name]
+	if perExecutable == nil {
+		perExecutable = make(map[string]map[string]int)
+		exits[name] = perExecutable
+	}
+	if perExecutable[executable] == nil {
+		perExecutable[executable] = make(map[string]int)
+	}
+	perExecutable[executable][status] += n
+}
+
+// baselineCache loads baselines from a store, reloading them every
+// reload.
+type baselineCache struct {
+	store  *storage.Store
+	reload time.Duration
+	logger *slog.Logger
+	// what the baselines are used for, for the warning logged when one
+	// cannot be loaded, e.g. "volumes".
+	what      string
+	baselines map[string]*cachedBaseline
+}
+
+type cachedBaseline struct {
+	baseline *baseline.Baseline // nil when the baseline could not be loaded
+	loadedAt time.Time
+}
+
+func newBaselineCache(store *storage.Store, reload time.Duration, logger *slog.Logger, what string) *baselineCache {
+	return &baselineCache{store: store, reload: reload, logger: logger, what: what, baselines: make(map[string]*cachedBaseline)}
+}
+
+// get returns the named baseline, loading it when it is not cached or is
+// stale, or nil when it cannot be loaded. Callers serialize access.
+func (c *baselineCache) get(name string) *baseline.Baseline {
+	cached := c.baselines[name]
+	if cached != nil && time.Since(cached.loadedAt) < c.reload {
+		return cached.baseline
+	}
+	b, err := c.store.LoadBaseline(name)
+	if err != nil {
+		if cached == nil || cached.baseline != nil {
+			c.logger.Warn("not checking "+c.what+" against baseline", "baseline", name, "error", err)
+		}
+		b = nil
+	}
+	c.baselines[name] = &cachedBaseline{baseline: b, loadedAt: time.Now()}
+	return b
+}
+
+// volumeDetector compares each baseline's byte volumes over intervals of
+// event time with its learned volumes.
+type volumeDetector struct {
+	interval  time.Duration
+	baselines *baselineCache
+
+	mu      sync.Mutex
+	start   map[string]time.Time        // baseline → interval start
+	volumes map[string]map[string]int64 // baseline → volume key → bytes
+}
+
+// Volume returns a processor summing the bytes each baseline's events move
+// per file path and destination over intervals of event time. When an
+// interval ends, volumes far above those learned are raised as anomalies
+// on the record that ended it. Baselines are reloaded every reload.
+func Volume(store *storage.Store, interval, reload time.Duration, logger *slog.Logger) Stage {
+	return &volumeDetector{
+		interval:  interval,
+		baselines: newBaselineCache(store, reload, logger, "volumes"),
+		start:     make(map[string]time.Time),
+		volumes:   make(map[string]map[string]int64),
+	}
+}
+
+// Process implements Stage.
+func (v *volumeDetector) Process(ctx context.Context, r *Record) (bool, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	start, started := v.start[r.Baseline]
+	if !started {
+		v.start[r.Baseline] = r.Event.Timestamp
+	} else if r.Event.Timestamp.Sub(start) >= v.interval {
+		if b := v.baselines.get(r.Baseline); b != nil {
+			for _, a := range b.VolumeAnomalies(v.volumes[r.Baseline]) {
+				a.Timestamp = r.Event.Timestamp
+				r.AddAnomalies(a)
+			}
+		}
+		delete(v.volumes, r.Baseline)
+		v.start[r.Baseline] = r.Event.Timestamp
+	}
+	if key := r.Event.VolumeKey(); key != "" && r.Event.Bytes > 0 {
+		addVolume(v.volumes, r.Baseline, key, r.Event.Bytes*int64(r.Event.Weight()))
+	}
+	return true, nil
+}
+
+// spikeDetector runs a detect.Detector per baseline over the event counts
+// of each interval of event time.
+type spikeDetector struct {
+	interval           time.Duration
+	percentile, margin float64
+	baselines          *baselineCache
+	logger             *slog.Logger
+
+	mu        sync.Mutex
+	start     map[string]time.Time        // baseline → interval start
+	counts    map[string]map[string]int   // baseline → category → events
+	detectors map[string]*detect.Detector // baseline → detector
+}
+
+// Spikes returns a processor counting each baseline's events by category
+// over intervals of event time. When an interval ends, categories whose
+// count exceeds the threshold learned from the baseline (the percentile
+// of its per-interval count plus margin, see detect.WithBaselineThresholds)
+// are raised as anomalies on the record that ended it. Baselines are
+// reloaded every reload, and thresholds follow them.
+func Spikes(store *storage.Store, interval time.Duration, percentile, margin float64, reload time.Duration, logger *slog.Logger) Stage {
+	return &spikeDetector{
+		interval:   interval,
+		percentile: percentile,
+		margin:     margin,
+		baselines:  newBaselineCache(store, reload, logger, "event counts"),
+		logger:     logger,
+		start:      make(map[string]time.Time),
+		counts:     make(map[string]map[string]int),
+		detectors:  make(map[string]*detect.Detector),
+	}
+}
+
+// Process implements Stage.
+func (d *spikeDetector) Process(ctx context.Context, r *Record) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	start, started := d.start[r.Baseline]
+	if !started {
+		d.start[r.Baseline] = r.Event.Timestamp
+	} else if r.Event.Timestamp.Sub(start) >= d.interval {
+		for _, result := range d.detector(r.Baseline).Detect(d.events(r.Baseline)) {
+			a := result.Anomaly()
+			a.Timestamp = r.Event.Timestamp
+			r.AddAnomalies(a)
+		}
+		delete(d.counts, r.Baseline)
+		d.start[r.Baseline] = r.Event.Timestamp
+	}
+	counts := d.counts[r.Baseline]
+	if counts == nil {
+		counts = make(map[string]int)
+		d.counts[r.Baseline] = counts
+	}
+	counts[r.Event.Type] += r.Event.Weight()
+	return true, nil
+}
+
+// detector returns the baseline's detector, whose thresholds come from
+// the cached baseline. Callers hold d.mu.
+func (d *spikeDetector) detector(name string) *detect.Detector {
+	if det, ok := d.detectors[name]; ok {
+		return det
+	}
+	source := func() *baseline.Baseline { return d.baselines.get(name) }
+	det := detect.NewDetector(detect.WithBaselineThresholds(source, d.percentile, d.margin), detect.WithLogger(d.logger))
+	d.detectors[name] = det
+	return det
+}
+
+// events stands the baseline's counts of the interval in for its events,
+// one sampled event per category.
+func (d *spikeDetector) events(name string) []detect.SystemEvent {
+	var events []detect.SystemEvent
+	for category, n := range d.counts[name] {
+		events = append(events, detect.SystemEvent{Type: category, SampleRate: n})
+	}
+	return events
+}
+
+// resourceDetector compares each baseline's peak resource measurements
+// over intervals of event time with its learned ones.
+type resourceDetector struct {
+	interval  time.Duration
+	sustained int
+	baselines *baselineCache
+
+	mu      sync.Mutex
+	start   map[string]time.Time          // baseline → interval start
+	peaks   map[string]map[string]float64 // baseline → resource key → peak
+	streaks map[string]map[string]int     // baseline → resource key → elevated intervals
+}
+
+// Resource returns a processor taking the peak of each baseline's resource
+// measurements, such as pressure stall averages from the cgroup source,
+// over intervals of event time. A measurement elevated above the learned
+// ones for sustained consecutive intervals is raised as an anomaly on the
+// record that ended the last of them (see baseline.ResourceAnomalies).
+// Baselines are reloaded every reload.
+func Resource(store *storage.Store, interval time.Duration, sustained int, reload time.Duration, logger *slog.Logger) Stage {
+	return &resourceDetector{
+		interval:  interval,
+		sustained: sustained,
+		baselines: newBaselineCache(store, reload, logger, "resources"),
+		start:     make(map[string]time.Time),
+		peaks:     make(map[string]map[string]float64),
+		streaks:   make(map[string]map[string]int),
+	}
+}
+
+// Process implements Stage.
+func (d *resourceDetector) Process(ctx context.Context, r *Record) (bool, error) {
+	key := r.Event.ResourceKey()
+	if key == "" {
+		return true, nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	start, started := d.start[r.Baseline]
+	if !started {
+		d.start[r.Baseline] = r.Event.Timestamp
+	} else if r.Event.Timestamp.Sub(start) >= d.interval {
+		if b := d.baselines.get(r.Baseline); b != nil {
+			streaks := d.streaks[r.Baseline]
+			if streaks == nil {
+				streaks = make(map[string]int)
+				d.streaks[r.Baseline] = streaks
+			}
+			for _, a := range b.ResourceAnomalies(d.peaks[r.Baseline], streaks, d.sustained) {
+				a.Timestamp = r.Event.Timestamp
+				r.AddAnomalies(a)
+			}
+		}
+		delete(d.peaks, r.Baseline)
+		d.start[r.Baseline] = r.Event.Timestamp
+	}
+	addPeak(d.peaks, r.Baseline, key, r.Event.ResourceValue())
+	return true, nil
+}
+
+// exitDetector compares each baseline's process exits with the exits it
+// learned.
+type exitDetector struct {
+	interval  time.Duration
+	baselines *baselineCache
+
+	mu       sync.Mutex
+	start    map[string]time.Time       // baseline → interval start
+	exits    map[string]map[string]int  // baseline → executable → exits in the interval
+	reported map[string]map[string]bool // baseline → evidence raised in the interval
+}
+
+// Exits returns a processor raising, on the exit event itself, anomalies
+// for exits with a status the executable was not seen to exit with, such
+// as a new exit code or a crash (see baseline.ExitAnomaly), and for
+// executables exiting more often in an interval of event time than they
+// usually do, such as a crash-looping service (see
+// baseline.RestartAnomaly). Each is raised once per interval. Baselines are
+// reloaded every reload.
+func Exits(store *storage.Store, interval, reload time.Duration, logger *slog.Logger) Stage {
+	return &exitDetector{
+		interval:  interval,
+		baselines: newBaselineCache(store, reload, logger, "exits"),
+		start:     make(map[string]time.Time),
+		exits:     make(map[string]map[string]int),
+		reported:  make(map[string]map[string]bool),
+	}
+}
+
+// Process implements Stage.
+func (d *exitDetector) Process(ctx context.Context, r *Record) (bool, error) {
+	executable, status, ok := r.Event.ExitStatus()
+	if !ok {
+		return true, nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if start, started := d.start[r.Baseline]; !started || r.Event.Timestamp.Sub(start) >= d.interval {
+		d.start[r.Baseline] = r.Event.Timestamp
+		d.exits[r.Baseline] = make(map[string]int)
+		d.reported[r.Baseline] = make(map[string]bool)
+	}
+	d.exits[r.Baseline][executable] += r.Event.Weight()
+	b := d.baselines.get(r.Baseline)
+	if b == nil {
+		return true, nil
+	}
+	var found []baseline.Anomaly
+	if a, ok := b.ExitAnomaly(executable, status); ok {
+		found = append(found, a)
+	}
+	if a, ok := b.RestartAnomaly(executable, d.exits[r.Baseline][executable]); ok {
+		found = append(found, a)
+	}
+	reported := d.reported[r.Baseline]
+	for _, a := range found {
+		if reported[a.Evidence] {
+			continue
+		}
+		reported[a.Evidence] = true
+		a.Timestamp = r.Event.Timestamp
+		a.PID, a.Process = r.Event.PID, r.Event.ProcessName
+		a.TraceID, a.SpanID = detect.TraceContext(*r.Event)
+		r.AddAnomalies(a)
+	}
+	return true, nil
+}
+
+// tlsDetector compares each baseline's TLS handshakes with the client
+// fingerprints it learned.
+type tlsDetector struct {
+	interval  time.Duration
+	baselines *baselineCache
+
+	mu       sync.Mutex
+	start    map[string]time.Time       // baseline → interval start
+	reported map[string]map[string]bool // baseline → evidence raised in the interval
+}
+
+// TLS returns a processor raising, on the handshake itself, an anomaly for
+// a TLS client fingerprint the process was not seen to use, or one new to
+// the destination (see baseline.TLSAnomaly). Each is raised once per
+// interval of event time, however many connections the new client makes.
+// Baselines are reloaded every reload.
+func TLS(store *storage.Store, interval, reload time.Duration, logger *slog.Logger) Stage {
+	return &tlsDetector{
+		interval:  interval,
+		baselines: newBaselineCache(store, reload, logger, "tls"),
+		start:     make(map[string]time.Time),
+		reported:  make(map[string]map[string]bool),
+	}
+}
+
+// Process implements Stage.
+func (d *tlsDetector) Process(ctx context.Context, r *Record) (bool, error) {
+	h, ok := r.Event.Handshake()
+	if !ok {
+		return true, nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if start, started := d.start[r.Baseline]; !started || r.Event.Timestamp.Sub(start) >= d.interval {
+		d.start[r.Baseline] = r.Event.Timestamp
+		d.reported[r.Baseline] = make(map[string]bool)
+	}
+	b := d.baselines.get(r.Baseline)
+	if b == nil {
+		return true, nil
+	}
+	a, ok := b.TLSAnomaly(h)
+	if !ok || d.reported[r.Baseline][a.Evidence] {
+		return true, nil
+	}
+	d.reported[r.Baseline][a.Evidence] = true
+	a.Timestamp = r.Event.Timestamp
+	a.PID, a.Process = r.Event.PID, r.Event.ProcessName
+	a.TraceID, a.SpanID = detect.TraceContext(*r.Event)
+	r.AddAnomalies(a)
+	return true, nil
+}
+
+// silenceDetector raises an anomaly when a regularly recurring operation
+// of a baseline goes quiet for longer than its learned gaps.
+type silenceDetector struct {
+	store     *storage.Store
+	names     []string // baselines checked on ticks; all when empty
+	every     time.Duration
+	now       func() time.Time
+	started   time.Time // when the detector started, in wall time
+	baselines *baselineCache
+
+	mu       sync.Mutex
+	since    map[string]time.Time            // baseline → first event time
+	checked  map[string]time.Time            // baseline → last check
+	latest   map[string]time.Time            // baseline → latest event time
+	heard    map[string]time.Time            // baseline → wall time of its latest record
+	lastSeen map[string]map[string]time.Time // baseline → key → last event time
+	reported map[string]map[string]bool      // baseline → keys reported silent
+}
+
+// Silence returns a processor tracking when each baseline's operations
+// were last seen, in event time, and checking every period of event time
+// for operations silent beyond their learned gaps (see
+// baseline.Silences). Each silence is raised once, on the record that
+// found it, until the operation is seen again. Between records, it also
+// checks every period of wall time the named baselines, or every stored
+// baseline when names is empty, that have had no records for a period:
+// their event time is taken to have moved on with the wall clock, and a
+// baseline with no records at all is checked as if it went quiet when the
+// detector started. Those silences are raised on records of their own.
+// Baselines are reloaded every reload.
+func Silence(store *storage.Store, names []string, every, reload time.Duration, now func() time.Time, logger *slog.Logger) Stage {
+	return &silenceDetector{
+		store:     store,
+		names:     names,
+		every:     every,
+		now:       now,
+		started:   now(),
+		baselines: newBaselineCache(store, reload, logger, "silences"),
+		since:     make(map[string]time.Time),
+		checked:   make(map[string]time.Time),
+		latest:    make(map[string]time.Time),
+		heard:     make(map[string]time.Time),
+		lastSeen:  make(map[string]map[string]time.Time),
+		reported:  make(map[string]map[string]bool),
+	}
+}
+
+// Process implements Stage.
+func (d *silenceDetector) Process(ctx context.Context, r *Record) (bool, error) {
+	now := r.Event.Timestamp
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, started := d.since[r.Baseline]; !started {
+		d.since[r.Baseline] = now
+		d.checked[r.Baseline] = now
+	}
+	d.start(r.Baseline)
+	lastSeen, reported := d.lastSeen[r.Baseline], d.reported[r.Baseline]
+	if key := r.Event.Key(); key != "" {
+		lastSeen[key] = now
+		delete(reported, key)
+	}
+	if now.After(d.latest[r.Baseline]) {
+		d.latest[r.Baseline] = now
+	}
+	d.heard[r.Baseline] = d.now()
+	if now.Sub(d.checked[r.Baseline]) < d.every {
+		return true, nil
+	}
+	d.checked[r.Baseline] = now
+	r.AddAnomalies(d.silences(r.Baseline, d.since[r.Baseline], now)...)
+	return true, nil
+}
+
+// TickEvery implements Ticker.
+func (d *silenceDetector) TickEvery() time.Duration {
+	return d.every
+}
+
+// Tick implements Ticker, checking the baselines whose records stopped.
+func (d *silenceDetector) Tick(ctx context.Context, now time.Time) ([]*Record, error) {
+	names := d.names
+	if len(names) == 0 {
+		var err error
+		if names, err = d.store.ListBaselines(); err != nil {
+			return nil, fmt.Errorf("silence: %w", err)
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var records []*Record
+	for _, name := range names {
+		since, eventNow := d.started, now
+		if heard, ok := d.heard[name]; ok {
+			if now.Sub(heard) < d.every {
+				continue // records are checked as they arrive
+			}
+			since, eventNow = d.since[name], d.latest[name].Add(now.Sub(heard))
+		}
+		d.start(name)
+		anomalies := d.silences(name, since, eventNow)
+		if len(anomalies) == 0 {
+			continue
+		}
+		records = append(records, &Record{
+			Source:    "silence",
+			Baseline:  name,
+			Event:     &detect.SystemEvent{Type: "silence", Timestamp: eventNow, Data: map[string]interface{}{}},
+			Anomalies: anomalies,
+		})
+	}
+	return records, nil
+}
+
+// start sets up tracking the named baseline. Callers hold d.mu.
+func (d *silenceDetector) start(name string) {
+	if _, ok := d.lastSeen[name]; !ok {
+		d.lastSeen[name] = make(map[string]time.Time)
+		d.reported[name] = make(map[string]bool)
+	}
+}
+
+// silences returns the named baseline's silences at now not yet reported,
+// and marks them reported. Callers hold d.mu.
+func (d *silenceDetector) silences(name string, since, now time.Time) []baseline.Anomaly {
+	b := d.baselines.get(name)
+	if b == nil {
+		return nil
+	}
+	reported := d.reported[name]
+	var anomalies []baseline.Anomaly
+	for _, a := range b.Silences(d.lastSeen[name], since, now) {
+		if !reported[a.Evidence] {
+			reported[a.Evidence] = true
+			anomalies = append(anomalies, a)
+		}
+	}
+	return anomalies
+}
+
+// maxReportedEdges bounds the new edges a graph detector remembers having
+// reported.
+const maxReportedEdges = 10000
+
+// graphDetector flags behavior graph edges a baseline has never seen.
+type graphDetector struct {
+	baselines *baselineCache
+
+	mu       sync.Mutex
+	tracker  *detect.EdgeTracker
+	reported map[string]bool // baseline and edge keys already raised
+}
+
+// Graph returns a processor raising an anomaly, with graph context, for
+// each edge of the behavior graph (see detect.EdgeTracker) that the
+// record's baseline has not learned, once per edge. Baselines without a
+// learned graph are not checked. Baselines are reloaded every reload.
+func Graph(store *storage.Store, reload time.Duration, logger *slog.Logger) Stage {
+	return &graphDetector{
+		baselines: newBaselineCache(store, reload, logger, "graph edges"),
+		tracker:   detect.NewEdgeTracker(),
+		reported:  make(map[string]bool),
+	}
+}
+
+// Process implements Stage.
+func (g *graphDetector) Process(ctx context.Context, r *Record) (bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	edge, ok := g.tracker.Edge(*r.Event)
+	if !ok {
+		return true, nil
+	}
+	id := r.Baseline + " " + edge.Key()
+	if g.reported[id] {
+		return true, nil
+	}
+	b := g.baselines.get(r.Baseline)
+	if b == nil {
+		return true, nil
+	}
+	a, isNew := b.EdgeAnomaly(edge)
+	if !isNew {
+		return true, nil
+	}
+	if len(g.reported) >= maxReportedEdges {
+		g.reported = make(map[string]bool)
+	}
+	g.reported[id] = true
+	a.PID, a.Process = r.Event.PID, r.Event.ProcessName
+	a.TraceID, a.SpanID = detect.TraceContext(*r.Event)
+	r.AddAnomalies(a)
+	return true, nil
+}
+
+// clusterStage groups each baseline's anomalies into incidents.
+type clusterStage struct {
+	window time.Duration
+
+	mu       sync.Mutex
+	clusters map[string]*baseline.Clusterer
+	alerted  map[string]alertedIncident // baseline and incident ID → last alert
+}
+
+type alertedIncident struct {
+	severity string
+	end      time.Time
+}
+
+// Cluster returns a processor replacing the anomalies of each record with
+// the incidents they belong to (see baseline.Clusterer), so related
+// anomalies raise one alert instead of one each. An incident is alerted
+// when it opens, as its first anomaly, and again, as the incident, only
+// when its combined severity rises; other anomalies joining it are
+// dropped, though the incident keeps them. List it after the processors
+// whose anomalies it groups.
+func Cluster(window time.Duration) Stage {
+	return &clusterStage{
+		window:   window,
+		clusters: make(map[string]*baseline.Clusterer),
+		alerted:  make(map[string]alertedIncident),
+	}
+}
+
+// Process implements Stage.
+func (c *clusterStage) Process(ctx context.Context, r *Record) (bool, error) {
+	if len(r.Anomalies) == 0 || r.Synthetic {
+		return true, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clusterer := c.clusters[r.Baseline]
+	if clusterer == nil {
+		clusterer = baseline.NewClusterer(c.window)
+		c.clusters[r.Baseline] = clusterer
+	}
+	var alerts []baseline.Anomaly
+	index := make(map[string]int) // baseline and incident ID → alert
+	var latest time.Time
+	for _, a := range r.Anomalies {
+		inc, _ := clusterer.Add(a)
+		key := r.Baseline + " " + inc.ID
+		if i, ok := index[key]; ok {
+			alerts[i] = inc.Anomaly()
+		} else if last, ok := c.alerted[key]; !ok || !severity.AtLeast(last.severity, inc.Severity) {
+			index[key] = len(alerts)
+			alerts = append(alerts, inc.Anomaly())
+		}
+		c.alerted[key] = alertedIncident{severity: inc.Severity, end: inc.End}
+		if inc.End.After(latest) {
+			latest = inc.End
+		}
+	}
+	for key, last := range c.alerted {
+		if latest.Sub(last.end) > c.window {
+			delete(c.alerted, key)
+		}
+	}
+	r.Anomalies = alerts
+	return true, nil
+}
+
+// escalateStage escalates each baseline's recurring anomalies.
+type escalateStage struct {
+	window time.Duration
+	policy baseline.EscalationPolicy
+	now    func() time.Time
+
+	mu         sync.Mutex
+	escalators map[string]*baseline.Escalator
+}
+
+// Escalate returns a processor tracking how each baseline's anomalies
+// recur across windows and escalating, by policy, those that keep coming
+// back (see baseline.Escalator). Anomalies without a timestamp are placed
+// at now. List it after the processors whose anomalies it escalates and
+// before cluster, so incidents combine the escalated severities.
+func Escalate(window time.Duration, policy baseline.EscalationPolicy, now func() time.Time) Stage {
+	return &escalateStage{window: window, policy: policy, now: now, escalators: make(map[string]*baseline.Escalator)}
+}
+
+// Process implements Stage.
+func (e *escalateStage) Process(ctx context.Context, r *Record) (bool, error) {
+	if len(r.Anomalies) == 0 || r.Synthetic {
+		return true, nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	escalator := e.escalators[r.Baseline]
+	if escalator == nil {
+		escalator = baseline.NewEscalator(e.window, e.policy)
+		e.escalators[r.Baseline] = escalator
+	}
+	for i, a := range r.Anomalies {
+		if a.Timestamp.IsZero() {
+			a.Timestamp = e.now()
+		}
+		r.Anomalies[i] = escalator.Observe(a)
+	}
+	return true, nil
+}
+
+// escalationPolicy returns the policy the escalate processor's options
+// name: step (the default) raises one level every after windows, up to
+// the severity to; threshold raises to to, HIGH by default, after after
+// windows.
+func escalationPolicy(opts Options) (baseline.EscalationPolicy, error) {
+	after := baseline.DefaultEscalationAfter
+	if s := opts["after"]; s != "" {
+		var err error
+		if after, err = strconv.Atoi(s); err != nil || after < 1 {
+			return nil, fmt.Errorf("option after: want a positive number of windows, got %q", s)
+		}
+	}
+	to := opts["to"]
+	if to != "" && !severity.Known(to) {
+		return nil, fmt.Errorf("option to: unknown severity %q", to)
+	}
+	switch policy := opts.String("policy", "step"); policy {
+	case "step":
+		return baseline.StepEscalation(after, to), nil
+	case "threshold":
+		if to == "" {
+			to = severity.Label(severity.High)
+		}
+		return baseline.ThresholdEscalation(after, to), nil
+	default:
+		return nil, fmt.Errorf("option policy: want step or threshold, got %q", policy)
+	}
+}
+
+// syscallCollector returns the collector the syscalls source's options
+// configure: the comma-separated syscalls to count, every interval.
+func syscallCollector(opts Options) (*collect.SyscallCollector, error) {
+	interval, err := opts.Duration("interval", collect.DefaultAggregationInterval)
+	if err != nil {
+		return nil, err
+	}
+	c := &collect.SyscallCollector{
+		BpftracePath: opts["bpftrace"],
+		Syscalls:     collect.DefaultAggregatedSyscalls,
+		Interval:     interval,
+		IgnoreComms:  []string{"runtimebase"},
+	}
+	if list := opts["syscalls"]; list != "" {
+		c.Syscalls = strings.Split(strings.ReplaceAll(list, " ", ""), ",")
+	}
+	if _, err := collect.SyscallScript(c.Syscalls, c.Interval); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// runtimeCollector returns the runtime source's collector from its
+// options: the comma-separated targets, their format, the metrics to
+// report besides the defaults, as "name" or "name=dimension", and the
+// scrape interval and timeout.
+func runtimeCollector(opts Options) (*collect.RuntimeCollector, error) {
+	list, err := opts.Required("targets")
+	if err != nil {
+		return nil, err
+	}
+	c := &collect.RuntimeCollector{Format: opts["format"]}
+	for _, target := range strings.Split(list, ",") {
+		target = strings.TrimSpace(target)
+		if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("option targets: want http(s) URLs, got %q", target)
+		}
+		c.Targets = append(c.Targets, target)
+	}
+	switch c.Format {
+	case "", collect.RuntimeExpvar, collect.RuntimePrometheus:
+	default:
+		return nil, fmt.Errorf("option format: want %s or %s, got %q", collect.RuntimeExpvar, collect.RuntimePrometheus, c.Format)
+	}
+	if extra := opts["metrics"]; extra != "" {
+		c.Metrics = maps.Clone(collect.DefaultRuntimeMetrics)
+		for _, metric := range strings.Split(extra, ",") {
+			name, dimension, _ := strings.Cut(strings.TrimSpace(metric), "=")
+			if dimension == "" {
+				dimension = name
+			}
+			if name == "" {
+				return nil, fmt.Errorf("option metrics: empty metric name in %q", extra)
+			}
+			c.Metrics[name] = dimension
+		}
+	}
+	if c.Interval, err = opts.Duration("interval", collect.DefaultRuntimeInterval); err != nil {
+		return nil, err
+	}
+	if c.Interval <= 0 {
+		return nil, fmt.Errorf("option interval: want a positive duration, got %s", c.Interval)
+	}
+	timeout, err := opts.Duration("timeout", c.Interval)
+	if err != nil {
+		return nil, err
+	}
+	c.Client = &http.Client{Timeout: timeout}
+	return c, nil
+}
+
+// driftSpec returns the drift processor's settings from its options.
+func driftSpec(opts Options) (DriftSpec, error) {
+	spec := DriftSpec{
+		Share:      DefaultDriftShare,
+		Sustained:  baseline.DefaultSustainedIntervals,
+		Categories: DefaultDriftCategories,
+		Deploy:     opts["deploy"],
+		Candidate:  opts["candidate"] == "true",
+		Suffix:     opts.String("suffix", DefaultCandidateSuffix),
+	}
+	var err error
+	if spec.Interval, err = opts.Duration("interval", DefaultDriftInterval); err != nil {
+		return spec, err
+	}
+	if spec.Interval <= 0 {
+		return spec, fmt.Errorf("option interval: want a positive duration, got %s", spec.Interval)
+	}
+	if s := opts["share"]; s != "" {
+		if spec.Share, err = strconv.ParseFloat(s, 64); err != nil || spec.Share <= 0 || spec.Share > 1 {
+			return spec, fmt.Errorf("option share: want a fraction between 0 and 1, got %q", s)
+		}
+	}
+	for _, o := range []struct {
+		name string
+		n    *int
+	}{{"sustained", &spec.Sustained}, {"categories", &spec.Categories}} {
+		if s := opts[o.name]; s != "" {
+			if *o.n, err = strconv.Atoi(s); err != nil || *o.n < 1 {
+				return spec, fmt.Errorf("option %s: want a positive number, got %q", o.name, s)
+			}
+		}
+	}
+	return spec, nil
+}
+
+// detector flags operations a baseline has never seen.
+type detector struct {
+	store  *storage.Store
+	reload time.Duration
+	logger *slog.Logger
+
+	mu        sync.Mutex
+	enforcers map[string]*cachedEnforcer
+	index     *baseline.Index
+	indexedAt time.Time
+}
+
+type cachedEnforcer struct {
+	enforcer *enforce.Enforcer // nil when the baseline could not be loaded
+	loadedAt time.Time
+}
+
+// Detect returns a processor raising an anomaly for every operation the
+// record's baseline has never observed: unknown syscalls, destinations and
+// executables. Operations other baselines have observed are scored lower,
+// by the store's fleet-wide index (see baseline.Index.Adjust). Baselines
+// and the index are reloaded from the store every reload.
+func Detect(store *storage.Store, reload time.Duration, logger *slog.Logger) Stage {
+	return &detector{store: store, reload: reload, logger: logger, enforcers: make(map[string]*cachedEnforcer)}
+}
+
+// Process implements Stage.
+func (d *detector) Process(ctx context.Context, r *Record) (bool, error) {
+	e := d.enforcer(r.Baseline)
+	if e == nil {
+		return true, nil
+	}
+	decision := e.Decide(*r.Event)
+	if decision.Verdict == enforce.Allow {
+		return true, nil
+	}
+	a := decision.Unseen(*r.Event)
+	d.fleetIndex().Adjust(&a, r.Baseline, decision.Key)
+	r.AddAnomalies(a)
+	return true, nil
+}
+
+// fleetIndex returns the store's pattern index, reloading it when stale,
+// or nil when it cannot be loaded.
+func (d *detector) fleetIndex() *baseline.Index {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.indexedAt.IsZero() && time.Since(d.indexedAt) < d.reload {
+		return d.index
+	}
+	index, err := d.store.Index()
+	if err != nil {
+		d.logger.Warn("not scoring against the fleet index", "error", err)
+	}
+	d.index, d.indexedAt = index, time.Now()
+	return index
+}
+
+// enforcer returns a report-only enforcer for the named baseline, loading
+// it when it is not cached or is stale.
+func (d *detector) enforcer(name string) *enforce.Enforcer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cached := d.enforcers[name]
+	if cached != nil && time.Since(cached.loadedAt) < d.reload {
+		return cached.enforcer
+	}
+	b, err := d.store.LoadBaseline(name)
+	if err != nil {
+		if cached == nil || cached.enforcer != nil {
+			d.logger.Warn("not detecting against baseline", "baseline", name, "error", err)
+		}
+		d.enforcers[name] = &cachedEnforcer{loadedAt: time.Now()}
+		return nil
+	}
+	e := enforce.New(b, nil, "", nil)
+	d.enforcers[name] = &cachedEnforcer{enforcer: e, loadedAt: time.Now()}
+	return e
+}
+
+// History returns a sink recording anomalies in their baseline's history.
+// Synthetic records are left out, so alert tests do not skew trends.
+func History(store *storage.Store) Sink {
+	return SinkFunc(func(_ context.Context, r *Record) error {
+		if len(r.Anomalies) == 0 || r.Synthetic {
+			return nil
+		}
+		return store.AppendAnomalies(r.Baseline, r.Anomalies)
+	})
+}
+
+// JSONLines returns a sink writing anomalies to w as JSON lines.
+func JSONLines(w io.Writer) Sink {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return SinkFunc(func(_ context.Context, r *Record) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, anomaly := range r.Anomalies {
+			if err := enc.Encode(anomaly); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Log returns a sink logging anomalies as warnings.
+func Log(logger *slog.Logger) Sink {
+	return SinkFunc(func(_ context.Context, r *Record) error {
+		for _, a := range r.Anomalies {
+			args := []any{"baseline", r.Baseline, "severity", a.Severity, "evidence", a.Evidence, "pid", a.PID, "process", a.Process}
+			if a.TraceID != "" {
+				args = append(args, "trace_id", a.TraceID, "span_id", a.SpanID)
+			}
+			if a.Capture != "" {
+				args = append(args, "capture", a.Capture)
+			}
+			if rem := a.Remediation; rem != nil {
+				args = append(args, "runbook", rem.Runbook, "owner", rem.Owner, "action", rem.Action)
+			}
+			logger.Warn(a.Type, args...)
+		}
+		return nil
+	})
+}
+
+// Correlate returns a stage adding the anomalies the correlation engine's
+// rules raise for each event. Rules that compare against learned behavior
+// see the record's baseline from store, reloaded every reload; with a nil
+// store they see none. A non-empty level replaces the severity the rules
+// set.
+func Correlate(engine *correlate.Engine, level string, store *storage.Store, reload time.Duration, logger *slog.Logger) Stage {
+	var baselines *baselineCache
+	if store != nil {
+		baselines = newBaselineCache(store, reload, logger, "correlations")
+	}
+	var mu sync.Mutex
+	return StageFunc(func(_ context.Context, r *Record) (bool, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		var b *baseline.Baseline
+		if baselines != nil && r.Baseline != "" {
+			b = baselines.get(r.Baseline)
+		}
+		anomalies := engine.Observe(*r.Event, b)
+		if level != "" {
+			for i := range anomalies {
+				anomalies[i].Severity = severity.Label(level)
+				anomalies[i].RiskLevel = anomalies[i].Severity
+			}
+		}
+		r.AddAnomalies(anomalies...)
+		return true, nil
+	})
+}
+
+// DefaultCandidateSuffix is appended to a baseline's name to name the
+// candidate baseline learned in shadow mode.
+const DefaultCandidateSuffix = ".candidate"
+
+// DefaultDivergenceThreshold is the divergence score at which shadow mode
+// raises an anomaly recommending a re-baseline.
+const DefaultDivergenceThreshold = 0.2
+
+// shadow learns a candidate baseline next to each active one and reports
+// how far they diverge.
+type shadow struct {
+	store     *storage.Store
+	suffix    string
+	report    time.Duration
+	threshold float64
+	logger    *slog.Logger
+	learner   *learner
+
+	mu       sync.Mutex
+	reported map[string]time.Time // active baseline → event time of the last report
+}
+
+// Shadow returns a processor for shadow mode: it learns each record's
+// event into a candidate baseline, named by appending suffix to the
+// record's baseline, and every report of event time compares the
+// candidate with the active baseline. Divergence is logged, and raised as
+// an anomaly once at least threshold of the candidate's patterns are new or
+// shifted, so operators know when to promote the candidate. The active
+// baseline is left alone; pair shadow with detect to keep checking
+// against it.
+func Shadow(store *storage.Store, suffix string, interval, report time.Duration, threshold float64, logger *slog.Logger) Stage {
+	return &shadow{
+		store:     store,
+		suffix:    suffix,
+		report:    report,
+		threshold: threshold,
+		logger:    logger,
+		learner:   newLearner(store, interval, 0),
+		reported:  make(map[string]time.Time),
+	}
+}
+
+// Process implements Stage.
+func (s *shadow) Process(ctx context.Context, r *Record) (bool, error) {
+	if r.Synthetic {
+		return true, nil
+	}
+	active := r.Baseline
+	candidate := active + s.suffix
+	s.mu.Lock()
+	last, seen := s.reported[active]
+	if !seen {
+		s.reported[active] = r.Event.Timestamp
+	}
+	s.mu.Unlock()
+	if !seen {
+		if err := createCandidate(s.store, active, candidate, nil); err != nil {
+			return true, err
+		}
+	}
+
+	shadowed := *r
+	shadowed.Baseline = candidate
+	if _, err := s.learner.Process(ctx, &shadowed); err != nil {
+		return true, err
+	}
+
+	if !seen || r.Event.Timestamp.Sub(last) < s.report {
+		return true, nil
+	}
+	s.mu.Lock()
+	s.reported[active] = r.Event.Timestamp
+	s.mu.Unlock()
+	// Intervals still open are left for later events to complete.
+	s.learner.mu.Lock()
+	err := s.learner.flushLocked()
+	s.learner.mu.Unlock()
+	if err != nil {
+		return true, err
+	}
+	d, err := s.diverge(active, candidate)
+	if err != nil {
+		s.logger.Warn("shadow comparison failed", "baseline", active, "candidate", candidate, "error", err)
+		return true, nil
+	}
+	s.logger.Info("shadow divergence", "baseline", active, "candidate", candidate, "score", d.Score,
+		"new", len(d.New), "shifted", len(d.Shifted), "missing", len(d.Missing))
+	if d.Observed > 0 && d.Score >= s.threshold {
+		level := severity.Label(severity.Low)
+		r.AddAnomalies(baseline.Anomaly{
+			Type: "Baseline Divergence",
+			Description: fmt.Sprintf("%.0f%% of current behavior is new or shifted (%d new, %d shifted, %d not seen lately); consider promoting %s",
+				d.Score*100, len(d.New), len(d.Shifted), len(d.Missing), candidate),
+			Severity:   level,
+			Evidence:   candidate,
+			Confidence: d.Score,
+			Timestamp:  r.Event.Timestamp,
+			RiskLevel:  level,
+		})
+	}
+	return true, nil
+}
+
+// Flush implements Flusher.
+func (s *shadow) Flush(ctx context.Context) error {
+	return s.learner.Flush(ctx)
+}
+
+// createCandidate creates the candidate baseline of active unless it
+// exists, marked so the fleet index leaves it out, with active's
+// thresholds and metadata, updated with metadata.
+func createCandidate(store *storage.Store, active, candidate string, metadata map[string]string) error {
+	_, err := store.LoadBaseline(candidate)
+	if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	b := baseline.NewLearner().CreateBaseline(candidate)
+	b.CandidateFor = active
+	if a, err := store.LoadBaseline(active); err == nil {
+		b.AnomalyThreshold = a.AnomalyThreshold
+		b.Thresholds = a.Thresholds
+		b.SetMetadata(a.Metadata)
+	}
+	b.SetMetadata(metadata)
+	return store.SaveBaseline(b)
+}
+
+func (s *shadow) diverge(active, candidate string) (baseline.Divergence, error) {
+	a, err := s.store.LoadBaseline(active)
+	if err != nil {
+		return baseline.Divergence{}, err
+	}
+	c, err := s.store.LoadBaseline(candidate)
+	if err != nil {
+		return baseline.Divergence{}, err
+	}
+	return baseline.Diverge(a, c), nil
+}
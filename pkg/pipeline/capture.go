@@ -0,0 +1,200 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// Defaults of the capture processor.
+const (
+	DefaultCaptureWindow = 5 * time.Minute
+	DefaultCaptureEvents = 1000
+)
+
+// CaptureSpec configures the capture processor.
+type CaptureSpec struct {
+	// Dir receives the captures, in a directory per baseline.
+	Dir string
+	// Window is how far back the events of a process are kept.
+	Window time.Duration
+	// Events bounds the events kept per process, the oldest going first.
+	Events int
+	// Severity is the least severe anomaly that writes a capture.
+	Severity string
+	// Seal, if set, encrypts the captures of a baseline, which are then
+	// written with an .enc suffix.
+	Seal func(baseline string, data []byte) ([]byte, error)
+}
+
+// captured is an event a capture buffer holds, encoded when it was seen
+// since the event itself is recycled.
+type captured struct {
+	at   time.Time
+	line []byte
+}
+
+// captureKey identifies a process of a baseline.
+type captureKey struct {
+	baseline string
+	pid      int
+}
+
+type captureStage struct {
+	spec   CaptureSpec
+	now    func() time.Time
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	buffers map[captureKey][]captured
+	swept   time.Time
+}
+
+// Capture returns a processor keeping, per process, a rolling buffer of
+// the raw events of the last spec.Window, which it writes to a JSON lines
+// file under spec.Dir when the process raises an anomaly at least as
+// severe as spec.Severity, so responders see what led up to it. The
+// anomalies that triggered the capture carry the file's path in Capture.
+// The buffer starts over after a capture. Place it after the processors
+// raising anomalies.
+func Capture(spec CaptureSpec, now func() time.Time, logger *slog.Logger) Stage {
+	return &captureStage{spec: spec, now: now, logger: logger, buffers: make(map[captureKey][]captured)}
+}
+
+// Process implements Stage.
+func (c *captureStage) Process(ctx context.Context, r *Record) (bool, error) {
+	if r.Event == nil || r.Synthetic {
+		return true, nil
+	}
+	line, err := json.Marshal(r.Event)
+	if err != nil {
+		c.logger.Warn("capturing event failed", "baseline", r.Baseline, "error", err)
+		return true, nil
+	}
+	at := r.Event.Timestamp
+	if at.IsZero() {
+		at = c.now()
+	}
+	key := captureKey{r.Baseline, r.Event.PID}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	buf := c.trim(append(c.buffers[key], captured{at, line}), at)
+	c.buffers[key] = buf
+	c.sweep(at)
+
+	triggered := false
+	for _, a := range r.Anomalies {
+		triggered = triggered || severity.AtLeast(a.Severity, c.spec.Severity)
+	}
+	if !triggered {
+		return true, nil
+	}
+	path, err := c.write(key, at, buf)
+	if err != nil {
+		c.logger.Warn("writing capture failed", "baseline", r.Baseline, "pid", key.pid, "error", err)
+		return true, nil
+	}
+	delete(c.buffers, key)
+	for i, a := range r.Anomalies {
+		if severity.AtLeast(a.Severity, c.spec.Severity) {
+			r.Anomalies[i].Capture = path
+		}
+	}
+	return true, nil
+}
+
+// trim drops the events of buf from before the window ending at now and
+// those beyond the buffer's bound.
+func (c *captureStage) trim(buf []captured, now time.Time) []captured {
+	cutoff := now.Add(-c.spec.Window)
+	start := 0
+	for start < len(buf) && buf[start].at.Before(cutoff) {
+		start++
+	}
+	start = max(start, len(buf)-c.spec.Events)
+	return buf[start:]
+}
+
+// sweep forgets, at most once a window, the processes that have been
+// quiet for longer than one.
+func (c *captureStage) sweep(now time.Time) {
+	if now.Sub(c.swept) < c.spec.Window {
+		return
+	}
+	c.swept = now
+	for key, buf := range c.buffers {
+		if now.Sub(buf[len(buf)-1].at) > c.spec.Window {
+			delete(c.buffers, key)
+		}
+	}
+}
+
+// write writes buf to a new capture file of the process and returns its
+// path.
+func (c *captureStage) write(key captureKey, at time.Time, buf []captured) (string, error) {
+	name := key.baseline
+	if name == "" {
+		name = "unrouted"
+	}
+	dir := filepath.Join(c.spec.Dir, name)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	var data bytes.Buffer
+	for _, e := range buf {
+		data.Write(e.line)
+		data.WriteByte('\n')
+	}
+	content := data.Bytes()
+	path := filepath.Join(dir, at.UTC().Format("20060102T150405.000Z")+"-"+strconv.Itoa(key.pid)+".jsonl")
+	if c.spec.Seal != nil {
+		sealed, err := c.spec.Seal(name, content)
+		if err != nil {
+			return "", err
+		}
+		content, path = sealed, path+".enc"
+	}
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// captureSpec returns the capture processor's configuration from its
+// options, capturing into dir unless they name another directory.
+func captureSpec(opts Options, dir string) (CaptureSpec, error) {
+	spec := CaptureSpec{
+		Dir:      opts.String("dir", dir),
+		Severity: opts.String("severity", severity.Label(severity.High)),
+	}
+	var err error
+	if spec.Window, err = opts.Duration("window", DefaultCaptureWindow); err != nil {
+		return spec, err
+	}
+	if spec.Window <= 0 {
+		return spec, fmt.Errorf("option window: want a positive duration, got %s", spec.Window)
+	}
+	spec.Events = DefaultCaptureEvents
+	if s := opts["events"]; s != "" {
+		if spec.Events, err = strconv.Atoi(s); err != nil || spec.Events < 1 {
+			return spec, fmt.Errorf("option events: want a positive number of events, got %q", s)
+		}
+	}
+	if !severity.Known(spec.Severity) {
+		return spec, fmt.Errorf("option severity: unknown severity %q", spec.Severity)
+	}
+	if spec.Dir == "" {
+		return spec, fmt.Errorf("option %q is required", "dir")
+	}
+	return spec, nil
+}
@@ -0,0 +1,114 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MaxDatagram is the largest datagram the datagram source reads; longer
+// ones are truncated.
+const MaxDatagram = 64 << 10
+
+// Datagram is a source listening for datagrams on a Unix socket or UDP
+// port, e.g. observations applications report in the line protocol of
+// parse.ParseObservation. Each line of a datagram becomes a raw record, so
+// a client may batch lines into one datagram. Nothing is acknowledged:
+// senders never wait for the pipeline, and datagrams arriving faster than
+// it keeps up are dropped by the kernel.
+type Datagram struct {
+	// Network is "unixgram" or "udp".
+	Network string
+	// Address is the socket path or host:port.
+	Address string
+	// Mode is the permission of the Unix socket, e.g. 0o660.
+	Mode os.FileMode
+}
+
+// ParseDatagramAddress parses a listen address of the form
+// unix:///run/runtimebase/observe.sock or udp://127.0.0.1:8126.
+func ParseDatagramAddress(address string) (Datagram, error) {
+	scheme, rest, ok := strings.Cut(address, "://")
+	switch {
+	case !ok || rest == "":
+		return Datagram{}, fmt.Errorf("listen address %q: want unix:///path or udp://host:port", address)
+	case scheme == "unix":
+		return Datagram{Network: "unixgram", Address: rest, Mode: 0o660}, nil
+	case scheme == "udp":
+		if _, _, err := net.SplitHostPort(rest); err != nil {
+			return Datagram{}, fmt.Errorf("listen address %q: %w", address, err)
+		}
+		return Datagram{Network: "udp", Address: rest}, nil
+	}
+	return Datagram{}, fmt.Errorf("listen address %q: unsupported scheme %q", address, scheme)
+}
+
+func newDatagram(opts Options) (Datagram, error) {
+	listen, err := opts.Required("listen")
+	if err != nil {
+		return Datagram{}, err
+	}
+	d, err := ParseDatagramAddress(listen)
+	if err != nil {
+		return Datagram{}, err
+	}
+	if v := opts["mode"]; v != "" {
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil || mode > 0o777 {
+			return Datagram{}, fmt.Errorf("option \"mode\": invalid permission %q", v)
+		}
+		d.Mode = os.FileMode(mode)
+	}
+	return d, nil
+}
+
+// Run implements Source.
+func (d Datagram) Run(ctx context.Context, out chan<- *Record) error {
+	if d.Network == "unixgram" {
+		// A socket left behind by an unclean exit would fail the bind.
+		if info, err := os.Lstat(d.Address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(d.Address)
+		}
+	}
+	conn, err := net.ListenPacket(d.Network, d.Address)
+	if err != nil {
+		return err
+	}
+	if d.Network == "unixgram" {
+		defer os.Remove(d.Address)
+		if err := os.Chmod(d.Address, d.Mode); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer conn.Close()
+
+	source := d.Network + ":" + d.Address
+	buf := make([]byte, MaxDatagram)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		for _, line := range bytes.Split(buf[:n], []byte("\n")) {
+			if line = bytes.TrimSpace(line); len(line) == 0 {
+				continue
+			}
+			select {
+			case out <- NewRecord(source, line):
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/metrics"
+)
+
+// DefaultDedupWindow is the timestamp bucket width of the dedup
+// normalizer.
+const DefaultDedupWindow = time.Second
+
+// MetricDuplicates counts the events the dedup normalizer dropped, by
+// event type and the origin that reported them second.
+const MetricDuplicates = "runtimebase_duplicates_dropped_total"
+
+// Origins of the dedup normalizer other than event labels.
+const (
+	OriginSource = "source" // the record's source
+	OriginNone   = "none"   // every repeat is a duplicate
+)
+
+// Dedup drops events reported twice, such as by both auditd and Sysmon
+// watching the same host. Events are the same action when they have the
+// same PID, type and object (the operation, or the file path of file
+// events) and their times fall into the same bucket of Window. Only
+// reports of different origins are duplicates: a process repeating an
+// operation is behavior to learn, not noise, so for each action the first
+// origin to report it is kept in full and each other origin has up to as
+// many reports dropped.
+type Dedup struct {
+	Window time.Duration
+	// Origin tells reporters apart: OriginSource, OriginNone, or
+	// "label.<key>" for an event label naming the collector, for sources
+	// carrying the events of several.
+	Origin string
+	// Metrics, when set, counts the dropped events under MetricDuplicates.
+	Metrics *metrics.Registry
+
+	dropped atomic.Int64
+
+	mu      sync.Mutex
+	buckets map[int64]map[dedupKey]*dedupEntry // bucket → actions reported in it
+	newest  int64
+}
+
+type dedupKey struct {
+	pid    int
+	typ    string
+	object string
+}
+
+type dedupEntry struct {
+	origin  string         // origin first reporting the action
+	reports int            // its reports
+	dropped map[string]int // other origin → its reports dropped
+}
+
+// Dropped returns the events dropped so far.
+func (d *Dedup) Dropped() int { return int(d.dropped.Load()) }
+
+// Process implements Stage.
+func (d *Dedup) Process(_ context.Context, r *Record) (bool, error) {
+	e := r.Event
+	if e.Timestamp.IsZero() {
+		return true, nil
+	}
+	key := dedupKey{pid: e.PID, typ: e.Type, object: e.Key()}
+	if key.object == "" {
+		key.object = e.Path
+	}
+	origin := r.Source
+	if label, ok := strings.CutPrefix(d.Origin, "label."); ok {
+		origin = e.Labels[label]
+	}
+	bucket := e.Timestamp.UnixNano() / int64(max(d.Window, 1))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.buckets == nil || bucket > d.newest {
+		d.advance(bucket)
+	}
+	actions, ok := d.buckets[bucket]
+	if !ok {
+		if bucket < d.newest-1 {
+			return true, nil // too late to tell
+		}
+		actions = make(map[dedupKey]*dedupEntry)
+		d.buckets[bucket] = actions
+	}
+	entry := actions[key]
+	switch {
+	case entry == nil:
+		actions[key] = &dedupEntry{origin: origin, reports: 1}
+		return true, nil
+	case d.Origin != OriginNone && origin == entry.origin:
+		entry.reports++
+		return true, nil
+	case d.Origin != OriginNone && entry.dropped[origin] >= entry.reports:
+		return true, nil // more reports than the first origin made
+	}
+	if entry.dropped == nil {
+		entry.dropped = make(map[string]int)
+	}
+	entry.dropped[origin]++
+	d.dropped.Add(1)
+	if d.Metrics != nil {
+		d.Metrics.Add(MetricDuplicates, 1, "type", e.Type, "origin", origin)
+	}
+	return false, nil
+}
+
+// advance makes bucket the newest, forgetting all but the one before it,
+// which still takes events arriving slightly out of order.
+func (d *Dedup) advance(bucket int64) {
+	if d.buckets == nil {
+		d.buckets = make(map[int64]map[dedupKey]*dedupEntry)
+	}
+	d.newest = bucket
+	for b := range d.buckets {
+		if b < bucket-1 {
+			delete(d.buckets, b)
+		}
+	}
+}
+
+// dedupOptions returns the dedup normalizer from its options: the bucket
+// width, window, and the origin.
+func dedupOptions(opts Options) (*Dedup, error) {
+	window, err := opts.Duration("window", DefaultDedupWindow)
+	if err != nil {
+		return nil, err
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("option window: want a positive duration, got %s", window)
+	}
+	origin := opts.String("origin", OriginSource)
+	if label, ok := strings.CutPrefix(origin, "label."); (!ok || label == "") && origin != OriginSource && origin != OriginNone {
+		return nil, fmt.Errorf("option origin: want %s, %s or label.<key>, got %q", OriginSource, OriginNone, origin)
+	}
+	return &Dedup{Window: window, Origin: origin}, nil
+}
@@ -0,0 +1,226 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/clock"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// MaxDigestAlerts bounds the alerts a digest carries; later ones still
+// count towards Count and Severities.
+const MaxDigestAlerts = 500
+
+// Digest summarizes the anomalies a sink batched over a period instead of
+// sending them one by one.
+type Digest struct {
+	Namespace  string
+	Start      time.Time
+	End        time.Time
+	Count      int            // anomalies in the period, including those not kept
+	Severities map[string]int // anomalies per severity
+	Baselines  map[string]int // anomalies per baseline
+	Alerts     []Alert        // the first MaxDigestAlerts
+}
+
+// Summary describes the digest in one line, such as "12 anomalies from
+// 14:00 to 15:00: 9 LOW, 3 MEDIUM".
+func (d Digest) Summary() string {
+	names := make([]string, 0, len(d.Severities))
+	for name := range d.Severities {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return severity.Score(names[i]) < severity.Score(names[j]) })
+	counts := make([]string, len(names))
+	for i, name := range names {
+		counts[i] = fmt.Sprintf("%d %s", d.Severities[name], name)
+	}
+	layout := "15:04"
+	if d.End.Sub(d.Start) >= 24*time.Hour || d.Start.YearDay() != d.End.YearDay() {
+		layout = "2006-01-02 15:04"
+	}
+	noun := "anomalies"
+	if d.Count == 1 {
+		noun = "anomaly"
+	}
+	s := fmt.Sprintf("%d %s from %s to %s", d.Count, noun, d.Start.Format(layout), d.End.Format(layout))
+	if len(counts) > 0 {
+		s += ": " + strings.Join(counts, ", ")
+	}
+	return s
+}
+
+// DigestWriter is implemented by sinks that can deliver a digest, such as
+// the webhook sink.
+type DigestWriter interface {
+	WriteDigest(ctx context.Context, d Digest) error
+}
+
+// digestSink holds back the anomalies below a severity and sends them to
+// a DigestWriter as one digest per period; anomalies at or above it pass
+// straight through.
+type digestSink struct {
+	sink      Sink
+	digests   DigestWriter
+	every     time.Duration
+	immediate string
+	clock     clock.Clock
+
+	mu      sync.Mutex
+	pending *Digest
+}
+
+// digestTick is how often a pipeline checks whether a digest is due, so a
+// quiet pipeline sends it at most this late.
+const digestTick = time.Minute
+
+// WithDigest returns a sink passing anomalies at or above severity
+// immediate to sink and batching the others into a digest per period of
+// every, aligned to UTC: hourly digests cover whole hours and daily ones
+// whole days. A digest is sent once its period ends, with the next record
+// or within a minute when the pipeline ticks (see Ticker), or when the
+// pipeline is flushed. sink must implement DigestWriter.
+func WithDigest(sink Sink, every time.Duration, immediate string, c clock.Clock) (Sink, error) {
+	digests, ok := sink.(DigestWriter)
+	if !ok {
+		return nil, fmt.Errorf("the sink does not support digests")
+	}
+	if c == nil {
+		c = clock.System
+	}
+	return &digestSink{sink: sink, digests: digests, every: every, immediate: immediate, clock: c}, nil
+}
+
+// Write implements Sink.
+func (s *digestSink) Write(ctx context.Context, r *Record) error {
+	now := s.clock.Now()
+	var due *Digest
+	immediate := *r
+	immediate.Anomalies = nil
+
+	s.mu.Lock()
+	if s.pending != nil && !now.Before(s.pending.End) {
+		due, s.pending = s.pending, nil
+	}
+	for _, a := range r.Anomalies {
+		if severity.AtLeast(a.Severity, s.immediate) {
+			immediate.Anomalies = append(immediate.Anomalies, a)
+			continue
+		}
+		if s.pending == nil {
+			start := now.Truncate(s.every)
+			s.pending = &Digest{
+				Start:      start,
+				End:        start.Add(s.every),
+				Severities: make(map[string]int),
+				Baselines:  make(map[string]int),
+			}
+		}
+		s.pending.add(r, a)
+	}
+	s.mu.Unlock()
+
+	var err error
+	if due != nil {
+		err = s.digests.WriteDigest(ctx, *due)
+	}
+	if len(immediate.Anomalies) > 0 || len(r.Anomalies) == 0 {
+		if werr := s.sink.Write(ctx, &immediate); err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+// TickEvery implements Ticker.
+func (s *digestSink) TickEvery() time.Duration { return digestTick }
+
+// Tick implements Ticker, sending the pending digest if its period ended
+// by now.
+func (s *digestSink) Tick(ctx context.Context, now time.Time) ([]*Record, error) {
+	s.mu.Lock()
+	due := s.pending
+	if due != nil && now.Before(due.End) {
+		due = nil
+	}
+	if due != nil {
+		s.pending = nil
+	}
+	s.mu.Unlock()
+	if due == nil {
+		return nil, nil
+	}
+	return nil, s.digests.WriteDigest(ctx, *due)
+}
+
+// Flush sends the pending digest, cut short at the current time, and
+// flushes the sink.
+func (s *digestSink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	due := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	var err error
+	if due != nil {
+		if now := s.clock.Now(); now.Before(due.End) {
+			due.End = now
+		}
+		err = s.digests.WriteDigest(ctx, *due)
+	}
+	if f, ok := s.sink.(Flusher); ok {
+		if ferr := f.Flush(ctx); err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
+
+func (d *Digest) add(r *Record, a baseline.Anomaly) {
+	d.Count++
+	d.Severities[a.Severity]++
+	d.Baselines[r.Baseline]++
+	if len(d.Alerts) < MaxDigestAlerts {
+		alert := Alert{Baseline: r.Baseline, Source: r.Source, Anomaly: a}
+		if r.Event != nil {
+			alert.Event = *r.Event
+		}
+		d.Alerts = append(d.Alerts, alert)
+	}
+}
+
+// digestOptions parses the digest options shared by every sink: digest,
+// the period (hourly, daily or a duration; unset for no digest), and
+// digest_immediate, the lowest severity still sent immediately (default
+// HIGH).
+func digestOptions(opts Options) (time.Duration, string, error) {
+	var every time.Duration
+	switch v := opts["digest"]; v {
+	case "":
+		if opts["digest_immediate"] != "" {
+			return 0, "", fmt.Errorf("option \"digest_immediate\" needs \"digest\"")
+		}
+		return 0, "", nil
+	case "hourly":
+		every = time.Hour
+	case "daily":
+		every = 24 * time.Hour
+	default:
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Minute {
+			return 0, "", fmt.Errorf("option \"digest\": want hourly, daily or a duration of at least 1m, got %q", v)
+		}
+		every = d
+	}
+	immediate := opts.String("digest_immediate", severity.High)
+	if !severity.Known(immediate) {
+		return 0, "", fmt.Errorf("option \"digest_immediate\": unknown severity %q", immediate)
+	}
+	return every, immediate, nil
+}
@@ -0,0 +1,244 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+	"github.com/hallucinaut/runtimebase/pkg/storage"
+)
+
+// Defaults of the drift processor.
+const (
+	DefaultDriftInterval   = 5 * time.Minute
+	DefaultDriftShare      = 0.5
+	DefaultDriftCategories = 3
+)
+
+// RebaselineRecommended is the Type of the advisories the drift processor
+// raises.
+const RebaselineRecommended = "Re-baseline Recommended"
+
+// DriftSpec configures the drift processor.
+type DriftSpec struct {
+	// Interval is the interval of event time behavior is compared over.
+	Interval time.Duration
+	// Share is the part of a category's operations that must be new,
+	// shifted or missing for the category to count as shifted in an
+	// interval (see baseline.CategoryShifts), and Sustained the
+	// consecutive intervals it must stay shifted to count as drifting.
+	Share     float64
+	Sustained int
+	// Categories is how many categories must drift together.
+	Categories int
+	// Deploy, when set, is the event label naming the deployed version,
+	// such as "version" or "image"; drift is then only recommended for
+	// re-baselining once the label changed, from the baseline's metadata
+	// under the same key or from the first value seen.
+	Deploy string
+	// Candidate starts learning a candidate baseline, named by appending
+	// Suffix to the baseline's name, when re-baselining is recommended;
+	// compare and promote it with the shadow command.
+	Candidate bool
+	Suffix    string
+}
+
+// drift recommends re-baselining workloads whose behavior changed as a
+// whole.
+type drift struct {
+	spec      DriftSpec
+	store     *storage.Store
+	baselines *baselineCache
+	learner   *learner
+	logger    *slog.Logger
+
+	mu    sync.Mutex
+	state map[string]*driftState // baseline → its drift
+}
+
+type driftState struct {
+	start    time.Time          // start of the interval of event time being counted
+	counts   map[string]float64 // statistics key → operations in the interval
+	critical bool               // whether the interval raised critical anomalies
+	streaks  map[string]int     // category → consecutive shifted intervals
+	shares   map[string]float64 // category → share shifted in the last interval
+	version  string             // deployed version, see DriftSpec.Deploy
+	previous string             // version before the last change
+	deployed time.Time          // event time of the last change
+	reported bool               // whether the current drift was reported
+	learning string             // candidate baseline being learned
+}
+
+// Drift returns a processor recommending a re-baseline when a workload's
+// behavior drifts as a whole: at least spec.Categories categories shifted
+// together for spec.Sustained intervals, after a deploy when spec.Deploy
+// is set, with no critical anomaly, such as a correlation rule firing or a
+// blocklisted peer, raised meanwhile. Such drift is more likely a new
+// version behaving differently than an attack, and alerts against the old
+// baseline are noise until it is learned again. The advisory, a LOW
+// anomaly of type RebaselineRecommended, is raised once per drift with the
+// categories' shifts as evidence. Place drift after the detecting
+// processors, whose anomalies it reads.
+func Drift(store *storage.Store, spec DriftSpec, logger *slog.Logger) Stage {
+	d := &drift{
+		spec:      spec,
+		store:     store,
+		baselines: newBaselineCache(store, time.Minute, logger, "drift"),
+		logger:    logger,
+		state:     make(map[string]*driftState),
+	}
+	if spec.Candidate {
+		d.learner = newLearner(store, time.Minute, 0)
+	}
+	return d
+}
+
+// Process implements Stage.
+func (d *drift) Process(ctx context.Context, r *Record) (bool, error) {
+	if r.Synthetic {
+		return true, nil
+	}
+	at := r.Event.Timestamp
+	d.mu.Lock()
+	s := d.state[r.Baseline]
+	if s == nil {
+		s = &driftState{start: at.Truncate(d.spec.Interval), counts: make(map[string]float64), streaks: make(map[string]int)}
+		d.state[r.Baseline] = s
+	}
+	if !at.Before(s.start.Add(d.spec.Interval)) {
+		if a, ok := d.close(r.Baseline, s, at); ok {
+			r.AddAnomalies(a)
+		}
+		s.start = at.Truncate(d.spec.Interval)
+	}
+	if key := r.Event.Key(); key != "" {
+		s.counts[key] += float64(r.Event.Weight())
+	}
+	for _, a := range r.Anomalies {
+		if a.Type != RebaselineRecommended && severity.AtLeast(a.Severity, severity.Critical) {
+			s.critical = true
+		}
+	}
+	if version := r.Event.Labels[d.spec.Deploy]; d.spec.Deploy != "" && version != "" {
+		if s.version == "" {
+			s.version = version
+			if b := d.baselines.get(r.Baseline); b != nil && b.Metadata[d.spec.Deploy] != "" {
+				s.version = b.Metadata[d.spec.Deploy]
+			}
+		}
+		if version != s.version {
+			s.previous, s.version, s.deployed = s.version, version, at
+		}
+	}
+	candidate := s.learning
+	d.mu.Unlock()
+
+	if candidate == "" {
+		return true, nil
+	}
+	learned := *r
+	learned.Baseline = candidate
+	_, err := d.learner.Process(ctx, &learned)
+	return true, err
+}
+
+// close compares the interval of s ending at or before now with the
+// baseline and returns the advisory to raise, if any.
+func (d *drift) close(name string, s *driftState, now time.Time) (baseline.Anomaly, bool) {
+	counts, critical := s.counts, s.critical
+	s.counts, s.critical = make(map[string]float64), false
+	b := d.baselines.get(name)
+	if b == nil || b.CandidateFor != "" {
+		return baseline.Anomaly{}, false
+	}
+	if critical {
+		// Drift raising critical anomalies is for detectors to report.
+		clear(s.streaks)
+		return baseline.Anomaly{}, false
+	}
+	scale := float64(b.BucketWidthOrDefault()) / float64(d.spec.Interval)
+	for key := range counts {
+		counts[key] *= scale
+	}
+	s.shares = make(map[string]float64)
+	for _, shift := range b.CategoryShifts(counts) {
+		if shift.Share >= d.spec.Share {
+			s.shares[shift.Category] = shift.Share
+		}
+	}
+	for category := range s.streaks {
+		if _, shifted := s.shares[category]; !shifted {
+			delete(s.streaks, category)
+		}
+	}
+	for category := range s.shares {
+		s.streaks[category]++
+	}
+	var drifting []string
+	var share float64
+	for category, streak := range s.streaks {
+		if streak >= d.spec.Sustained {
+			drifting = append(drifting, category)
+			share += s.shares[category]
+		}
+	}
+	if len(drifting) < d.spec.Categories {
+		if len(drifting) == 0 {
+			s.reported = false
+		}
+		return baseline.Anomaly{}, false
+	}
+	if s.reported || (d.spec.Deploy != "" && s.previous == "") {
+		return baseline.Anomaly{}, false
+	}
+	s.reported = true
+	slices.Sort(drifting)
+
+	shifts := make([]string, len(drifting))
+	for i, category := range drifting {
+		shifts[i] = fmt.Sprintf("%s %.0f%%", category, s.shares[category]*100)
+	}
+	description := fmt.Sprintf("%d categories shifted together for %d intervals of %s", len(drifting), d.spec.Sustained, d.spec.Interval)
+	if s.previous != "" {
+		description += fmt.Sprintf(" since %s changed from %s to %s at %s", d.spec.Deploy, s.previous, s.version, s.deployed.Format(time.RFC3339))
+	}
+	description += fmt.Sprintf(", with no critical anomaly (%s of operations new, shifted or missing); re-baseline recommended", strings.Join(shifts, ", "))
+	if d.spec.Candidate {
+		candidate := name + d.spec.Suffix
+		var metadata map[string]string
+		if d.spec.Deploy != "" {
+			metadata = map[string]string{d.spec.Deploy: s.version}
+		}
+		if err := createCandidate(d.store, name, candidate, metadata); err != nil {
+			d.logger.Warn("could not start candidate baseline", "baseline", name, "candidate", candidate, "error", err)
+		} else {
+			s.learning = candidate
+			description += fmt.Sprintf("; learning candidate %s, compare and promote it with `runtimebase shadow %s`", candidate, name)
+		}
+	}
+	d.logger.Info("re-baseline recommended", "baseline", name, "categories", drifting, "version", s.version)
+	level := severity.Label(severity.Low)
+	return baseline.Anomaly{
+		Type:        RebaselineRecommended,
+		Description: description,
+		Severity:    level,
+		Evidence:    strings.Join(drifting, ","),
+		Confidence:  share / float64(len(drifting)),
+		Timestamp:   now,
+		RiskLevel:   level,
+	}, true
+}
+
+// Flush implements Flusher.
+func (d *drift) Flush(ctx context.Context) error {
+	if d.learner == nil {
+		return nil
+	}
+	return d.learner.Flush(ctx)
+}
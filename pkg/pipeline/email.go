@@ -0,0 +1,427 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/secrets"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// Email transport security modes.
+const (
+	EmailSTARTTLS = "starttls" // upgrade a plain connection, usually on port 587
+	EmailTLS      = "tls"      // implicit TLS, usually on port 465
+	EmailPlain    = "none"     // no encryption, for local relays only
+)
+
+// Recipients are the addresses mailed about anomalies at or above
+// MinSeverity; an empty MinSeverity means every anomaly.
+type Recipients struct {
+	MinSeverity string
+	Addresses   []string
+}
+
+// Email is a sink mailing each anomaly over SMTP as an HTML message, to
+// the recipients of its severity. Subject is a text/template and Body an
+// html/template, both executed with the Alert; digests (see WithDigest) use
+// DigestSubject and DigestBody, executed with the Digest, and go to the
+// recipients of the digest's most severe anomaly.
+type Email struct {
+	Addr       string // host:port of the SMTP server
+	Security   string // EmailSTARTTLS (the default), EmailTLS or EmailPlain
+	Username   string // authenticates with PLAIN when set
+	Password   string
+	From       string
+	Recipients []Recipients
+
+	Subject       *template.Template
+	Body          *htmltemplate.Template
+	DigestSubject *template.Template
+	DigestBody    *htmltemplate.Template
+
+	Namespace string
+	Timeout   time.Duration // defaults to 30s
+	TLSConfig *tls.Config   // defaults to verifying the server's host name
+}
+
+// emailFuncs are available to email templates besides TemplateFuncs.
+var emailFuncs = map[string]any{
+	// color returns the CSS color of a severity.
+	"color": func(name string) string {
+		switch {
+		case severity.AtLeast(name, severity.Critical):
+			return "#8b0000"
+		case severity.AtLeast(name, severity.High):
+			return "#d32f2f"
+		case severity.AtLeast(name, severity.Medium):
+			return "#f57c00"
+		}
+		return "#1976d2"
+	},
+}
+
+const defaultEmailSubject = `[runtimebase] {{.Anomaly.Severity}} {{.Anomaly.Type}} on {{.Baseline}}`
+
+const defaultEmailBody = `<html><body style="font-family: sans-serif">
+<h2 style="color: {{color .Anomaly.Severity}}">{{.Anomaly.Severity}}: {{.Anomaly.Type}}</h2>
+<p>{{.Anomaly.Description}}</p>
+<table cellpadding="4">
+{{- if .Namespace}}<tr><th align="left">Namespace</th><td>{{.Namespace}}</td></tr>{{end}}
+<tr><th align="left">Baseline</th><td>{{.Baseline}}</td></tr>
+<tr><th align="left">Evidence</th><td><code>{{.Anomaly.Evidence}}</code></td></tr>
+{{- if .Anomaly.Process}}<tr><th align="left">Process</th><td>{{.Anomaly.Process}}{{if .Anomaly.PID}} (pid {{.Anomaly.PID}}){{end}}</td></tr>{{end}}
+<tr><th align="left">Time</th><td>{{.Anomaly.Timestamp.UTC.Format "2006-01-02 15:04:05 UTC"}}</td></tr>
+{{- with .Anomaly.Remediation}}
+{{- if .Runbook}}<tr><th align="left">Runbook</th><td><a href="{{.Runbook}}">{{.Runbook}}</a></td></tr>{{end}}
+{{- if .Owner}}<tr><th align="left">Owner</th><td>{{.Owner}}</td></tr>{{end}}
+{{- if .Action}}<tr><th align="left">Response</th><td>{{.Action}}</td></tr>{{end}}
+{{- end}}
+</table>
+</body></html>
+`
+
+const defaultDigestSubject = `[runtimebase] {{.Summary}}`
+
+const defaultDigestBody = `<html><body style="font-family: sans-serif">
+<h2>{{.Summary}}</h2>
+<table cellpadding="4">
+<tr><th align="left">Time</th><th align="left">Baseline</th><th align="left">Severity</th><th align="left">Anomaly</th><th align="left">Evidence</th></tr>
+{{- range .Alerts}}
+<tr><td>{{.Anomaly.Timestamp.UTC.Format "15:04:05"}}</td><td>{{.Baseline}}</td><td style="color: {{color .Anomaly.Severity}}">{{.Anomaly.Severity}}</td><td>{{.Anomaly.Description}}</td><td><code>{{.Anomaly.Evidence}}</code></td></tr>
+{{- end}}
+</table>
+{{- if gt .Count (len .Alerts)}}
+<p>{{len .Alerts}} of {{.Count}} anomalies shown.</p>
+{{- end}}
+</body></html>
+`
+
+// Write implements Sink.
+func (e *Email) Write(ctx context.Context, r *Record) error {
+	for _, anomaly := range r.Anomalies {
+		alert := Alert{Namespace: e.Namespace, Baseline: r.Baseline, Source: r.Source, Anomaly: anomaly}
+		if r.Event != nil {
+			alert.Event = *r.Event
+		}
+		to := e.recipients(anomaly.Severity)
+		if len(to) == 0 {
+			continue
+		}
+		if err := e.render(ctx, to, e.Subject, e.Body, alert); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteDigest implements DigestWriter.
+func (e *Email) WriteDigest(ctx context.Context, d Digest) error {
+	d.Namespace = e.Namespace
+	for i := range d.Alerts {
+		d.Alerts[i].Namespace = e.Namespace
+	}
+	worst := ""
+	for name := range d.Severities {
+		if worst == "" || severity.Score(name) > severity.Score(worst) {
+			worst = name
+		}
+	}
+	to := e.recipients(worst)
+	if len(to) == 0 {
+		return nil
+	}
+	return e.render(ctx, to, e.DigestSubject, e.DigestBody, d)
+}
+
+// recipients returns the addresses to mail about an anomaly of a severity.
+func (e *Email) recipients(name string) []string {
+	seen := make(map[string]bool)
+	var to []string
+	for _, r := range e.Recipients {
+		if r.MinSeverity != "" && !severity.AtLeast(name, r.MinSeverity) {
+			continue
+		}
+		for _, addr := range r.Addresses {
+			if !seen[addr] {
+				seen[addr] = true
+				to = append(to, addr)
+			}
+		}
+	}
+	return to
+}
+
+func (e *Email) render(ctx context.Context, to []string, subject *template.Template, body *htmltemplate.Template, data any) error {
+	var s, b bytes.Buffer
+	if err := subject.Execute(&s, data); err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
+	if err := body.Execute(&b, data); err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
+	// A subject spanning lines would inject headers.
+	line := strings.Join(strings.Fields(s.String()), " ")
+	return e.send(ctx, to, line, b.Bytes())
+}
+
+// message returns the MIME message of an HTML mail.
+func (e *Email) message(to []string, subject string, html []byte) ([]byte, error) {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	domain := "runtimebase"
+	if at := strings.LastIndexByte(e.From, '@'); at >= 0 {
+		domain = e.From[at+1:]
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&msg)
+	if _, err := qp.Write(html); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+func (e *Email) send(ctx context.Context, to []string, subject string, html []byte) error {
+	msg, err := e.message(to, subject, html)
+	if err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
+	if err := e.deliver(ctx, to, msg); err != nil {
+		return fmt.Errorf("email: %s: %w", e.Addr, err)
+	}
+	return nil
+}
+
+func (e *Email) deliver(ctx context.Context, to []string, msg []byte) error {
+	host, _, err := net.SplitHostPort(e.Addr)
+	if err != nil {
+		return err
+	}
+	timeout := e.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	tlsConfig := e.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: host}
+	}
+
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	if e.Security == EmailTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", e.Addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", e.Addr)
+	}
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if e.Security == "" || e.Security == EmailSTARTTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("server does not offer STARTTLS")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if e.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.Username, e.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(e.From); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// emailTemplates parses the subject and body templates the options
+// configure, or the defaults, checking them against a sample alert or
+// digest.
+func emailTemplates(opts Options, subjectKey, bodyKey, defaultSubject, defaultBody string, sample any) (*template.Template, *htmltemplate.Template, error) {
+	subject, err := template.New(subjectKey).Funcs(TemplateFuncs).Funcs(emailFuncs).Option("missingkey=error").
+		Parse(opts.String(subjectKey, defaultSubject))
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := subject.Execute(&bytes.Buffer{}, sample); err != nil {
+		return nil, nil, err
+	}
+
+	text, file := opts[bodyKey], opts[bodyKey+"_file"]
+	if text != "" && file != "" {
+		return nil, nil, fmt.Errorf("options %q and %q are mutually exclusive", bodyKey, bodyKey+"_file")
+	}
+	name := bodyKey
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		text, name = string(b), file
+	}
+	if text == "" {
+		text = defaultBody
+	}
+	body, err := htmltemplate.New(name).Funcs(htmltemplate.FuncMap(TemplateFuncs)).Funcs(emailFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := body.Execute(&bytes.Buffer{}, sample); err != nil {
+		return nil, nil, err
+	}
+	return subject, body, nil
+}
+
+// emailRecipients reads the "to" option, mailed about every anomaly, and
+// the "to.<SEVERITY>" options, mailed about anomalies at or above it.
+func emailRecipients(opts Options) ([]Recipients, error) {
+	keys := make([]string, 0, len(opts))
+	for key := range opts {
+		if key == "to" || strings.HasPrefix(key, "to.") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var recipients []Recipients
+	for _, key := range keys {
+		r := Recipients{MinSeverity: strings.TrimPrefix(strings.TrimPrefix(key, "to"), ".")}
+		if r.MinSeverity != "" && !severity.Known(r.MinSeverity) {
+			return nil, fmt.Errorf("option %q: unknown severity %q", key, r.MinSeverity)
+		}
+		list, err := mail.ParseAddressList(opts[key])
+		if err != nil {
+			return nil, fmt.Errorf("option %q: %w", key, err)
+		}
+		for _, addr := range list {
+			r.Addresses = append(r.Addresses, addr.Address)
+		}
+		recipients = append(recipients, r)
+	}
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("option \"to\" or \"to.<SEVERITY>\" is required")
+	}
+	return recipients, nil
+}
+
+// newEmail creates an email sink from its options: addr and from
+// (required), to and to.<SEVERITY> (at least one), tls (starttls, tls or
+// none), username, password (a secret reference such as env:SMTP_PASSWORD),
+// timeout, subject, template or template_file, digest_subject, and
+// digest_template or digest_template_file. With resolve false the password
+// reference is only checked, not fetched.
+func newEmail(opts Options, resolve bool) (*Email, error) {
+	addr, err := opts.Required("addr")
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("option \"addr\": %w", err)
+	}
+	from, err := opts.Required("from")
+	if err != nil {
+		return nil, err
+	}
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("option \"from\": %w", err)
+	}
+	recipients, err := emailRecipients(opts)
+	if err != nil {
+		return nil, err
+	}
+	security := opts.String("tls", EmailSTARTTLS)
+	switch security {
+	case EmailSTARTTLS, EmailTLS, EmailPlain:
+	default:
+		return nil, fmt.Errorf("option \"tls\": want %s, %s or %s, got %q", EmailSTARTTLS, EmailTLS, EmailPlain, security)
+	}
+	timeout, err := opts.Duration("timeout", 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	e := &Email{
+		Addr:       addr,
+		Security:   security,
+		Username:   opts["username"],
+		From:       sender.Address,
+		Recipients: recipients,
+		Timeout:    timeout,
+	}
+	if ref := opts["password"]; ref != "" {
+		if e.Username == "" {
+			return nil, fmt.Errorf("option \"password\" needs \"username\"")
+		}
+		if _, _, err := secrets.Parse(ref); err != nil {
+			return nil, fmt.Errorf("option \"password\": %w", err)
+		}
+		if resolve {
+			password, err := secrets.Resolve(context.Background(), ref)
+			if err != nil {
+				return nil, fmt.Errorf("option \"password\": %w", err)
+			}
+			e.Password = string(password)
+		}
+	}
+	if e.Subject, e.Body, err = emailTemplates(opts, "subject", "template", defaultEmailSubject, defaultEmailBody, sampleAlert()); err != nil {
+		return nil, err
+	}
+	if e.DigestSubject, e.DigestBody, err = emailTemplates(opts, "digest_subject", "digest_template", defaultDigestSubject, defaultDigestBody, sampleDigest()); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
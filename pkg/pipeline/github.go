@@ -0,0 +1,84 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// GitHubIssues is a Tracker of the issues of a GitHub repository.
+type GitHubIssues struct {
+	URL   string // API URL, https://api.github.com by default
+	Repo  string // owner/name
+	Token string
+	// Client defaults to one with a 10s timeout.
+	Client *http.Client
+}
+
+// Open implements Tracker.
+func (g *GitHubIssues) Open(ctx context.Context, issue Issue) (string, error) {
+	var created struct {
+		Number int `json:"number"`
+	}
+	body := map[string]any{"title": issue.Title, "body": issue.Body, "labels": issue.Labels}
+	if err := g.client().do(ctx, http.MethodPost, "/repos/"+g.Repo+"/issues", body, &created); err != nil {
+		return "", err
+	}
+	return strconv.Itoa(created.Number), nil
+}
+
+// Comment implements Tracker.
+func (g *GitHubIssues) Comment(ctx context.Context, id, body string) error {
+	return g.client().do(ctx, http.MethodPost, "/repos/"+g.Repo+"/issues/"+id+"/comments", map[string]string{"body": body}, nil)
+}
+
+// Close implements Tracker.
+func (g *GitHubIssues) Close(ctx context.Context, id, body string) error {
+	if err := g.Comment(ctx, id, body); err != nil {
+		return err
+	}
+	update := map[string]string{"state": "closed", "state_reason": "completed"}
+	return g.client().do(ctx, http.MethodPatch, "/repos/"+g.Repo+"/issues/"+id, update, nil)
+}
+
+func (g *GitHubIssues) client() *trackerClient {
+	base := g.URL
+	if base == "" {
+		base = "https://api.github.com"
+	}
+	return &trackerClient{
+		name: "github",
+		base: base,
+		headers: map[string]string{
+			"Accept":               "application/vnd.github+json",
+			"Authorization":        "Bearer " + g.Token,
+			"X-GitHub-Api-Version": "2022-11-28",
+		},
+		client: httpClient(g.Client),
+	}
+}
+
+// newGitHubIssues creates a GitHub issues tracker from its options: repo
+// (owner/name) and token (a secret reference such as env:GITHUB_TOKEN),
+// both required, url for GitHub Enterprise and timeout. With resolve false
+// the token reference is only checked, not fetched.
+func newGitHubIssues(opts Options, resolve bool) (*GitHubIssues, error) {
+	repo, err := opts.Required("repo")
+	if err != nil {
+		return nil, err
+	}
+	if owner, name, ok := strings.Cut(repo, "/"); !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("option \"repo\": want owner/name, got %q", repo)
+	}
+	token, err := trackerToken(opts, "token", resolve)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := opts.Duration("timeout", defaultTrackerTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &GitHubIssues{URL: opts["url"], Repo: repo, Token: token, Client: &http.Client{Timeout: timeout}}, nil
+}
@@ -0,0 +1,129 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Jira is a Tracker of the issues of a Jira project, through its REST API
+// version 2, which Jira Cloud and Data Center both serve.
+type Jira struct {
+	URL       string // site URL, such as https://acme.atlassian.net
+	Project   string // project key
+	IssueType string // "Task" by default
+	// User and Token authenticate with basic authentication, as Jira
+	// Cloud's API tokens do; a Token alone is sent as a bearer token, as
+	// Data Center's personal access tokens are.
+	User  string
+	Token string
+	// CloseTransition names the workflow transition closing an issue,
+	// "Done" by default.
+	CloseTransition string
+	// Client defaults to one with a 10s timeout.
+	Client *http.Client
+}
+
+// Open implements Tracker.
+func (j *Jira) Open(ctx context.Context, issue Issue) (string, error) {
+	issueType := j.IssueType
+	if issueType == "" {
+		issueType = "Task"
+	}
+	labels := make([]string, len(issue.Labels))
+	for i, label := range issue.Labels {
+		labels[i] = strings.ReplaceAll(label, " ", "-") // Jira labels have no spaces
+	}
+	fields := map[string]any{
+		"project":     map[string]string{"key": j.Project},
+		"issuetype":   map[string]string{"name": issueType},
+		"summary":     issue.Title,
+		"description": issue.Body,
+		"labels":      labels,
+	}
+	var created struct {
+		Key string `json:"key"`
+	}
+	if err := j.client().do(ctx, http.MethodPost, "/rest/api/2/issue", map[string]any{"fields": fields}, &created); err != nil {
+		return "", err
+	}
+	return created.Key, nil
+}
+
+// Comment implements Tracker.
+func (j *Jira) Comment(ctx context.Context, id, body string) error {
+	return j.client().do(ctx, http.MethodPost, "/rest/api/2/issue/"+url.PathEscape(id)+"/comment", map[string]string{"body": body}, nil)
+}
+
+// Close implements Tracker, moving the issue through CloseTransition.
+func (j *Jira) Close(ctx context.Context, id, body string) error {
+	name := j.CloseTransition
+	if name == "" {
+		name = "Done"
+	}
+	path := "/rest/api/2/issue/" + url.PathEscape(id) + "/transitions"
+	var available struct {
+		Transitions []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"transitions"`
+	}
+	if err := j.client().do(ctx, http.MethodGet, path, nil, &available); err != nil {
+		return err
+	}
+	for _, t := range available.Transitions {
+		if strings.EqualFold(t.Name, name) {
+			if err := j.Comment(ctx, id, body); err != nil {
+				return err
+			}
+			return j.client().do(ctx, http.MethodPost, path, map[string]any{"transition": map[string]string{"id": t.ID}}, nil)
+		}
+	}
+	return fmt.Errorf("jira: issue %s has no transition %q", id, name)
+}
+
+func (j *Jira) client() *trackerClient {
+	auth := "Bearer " + j.Token
+	if j.User != "" {
+		auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(j.User+":"+j.Token))
+	}
+	return &trackerClient{name: "jira", base: j.URL, headers: map[string]string{"Authorization": auth}, client: httpClient(j.Client)}
+}
+
+// newJira creates a Jira tracker from its options: url, project and token
+// (a secret reference such as env:JIRA_TOKEN), all required, user,
+// issue_type, close_transition and timeout. With resolve false the token
+// reference is only checked, not fetched.
+func newJira(opts Options, resolve bool) (*Jira, error) {
+	site, err := opts.Required("url")
+	if err != nil {
+		return nil, err
+	}
+	if u, err := url.Parse(site); err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("option \"url\": want the site URL, such as https://acme.atlassian.net, got %q", site)
+	}
+	project, err := opts.Required("project")
+	if err != nil {
+		return nil, err
+	}
+	token, err := trackerToken(opts, "token", resolve)
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := opts.Duration("timeout", defaultTrackerTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Jira{
+		URL:             site,
+		Project:         project,
+		IssueType:       opts["issue_type"],
+		User:            opts["user"],
+		Token:           token,
+		CloseTransition: opts["close_transition"],
+		Client:          &http.Client{Timeout: timeout},
+	}, nil
+}
@@ -0,0 +1,119 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/hallucinaut/runtimebase/pkg/logfile"
+)
+
+// mergeBuffer is how many parsed records each input of a Merged source
+// reads ahead.
+const mergeBuffer = 256
+
+// Merged is a source reading several logs at once, such as the audit log
+// and an application's JSON log, and emitting their events merged into
+// timestamp order, so stages see them as they happened across logs. Each
+// log is parsed as it is read, by its own parse stage, and is expected to
+// be in order itself; events without a timestamp go out as soon as they
+// are read.
+type Merged struct {
+	// Inputs are the logs to merge, each the files read in order, such as
+	// a log's rotated predecessors and the log itself (see
+	// logfile.Rotated).
+	Inputs [][]string
+	// Parser returns the parse stage of an input; each input gets its own,
+	// since parsers may keep state between lines.
+	Parser func() (Stage, error)
+
+	lines   atomic.Int64
+	skipped atomic.Int64
+}
+
+// Lines returns the lines read so far.
+func (m *Merged) Lines() int { return int(m.lines.Load()) }
+
+// Skipped returns the lines read so far that could not be parsed.
+func (m *Merged) Skipped() int { return int(m.skipped.Load()) }
+
+// Run implements Source. Records carry their event, parsed, and the name
+// of the log they were read from.
+func (m *Merged) Run(ctx context.Context, out chan<- *Record) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	inputs := make([]chan *Record, len(m.Inputs))
+	errs := make([]error, len(m.Inputs))
+	for i, paths := range m.Inputs {
+		parser, err := m.Parser()
+		if err != nil {
+			return err
+		}
+		inputs[i] = make(chan *Record, mergeBuffer)
+		go func(i int, paths []string, parser Stage) {
+			defer close(inputs[i])
+			name := paths[len(paths)-1]
+			errs[i] = logfile.ScanBytes(paths, false, func(line []byte) error {
+				m.lines.Add(1)
+				if len(line) == 0 {
+					return nil
+				}
+				r := NewRecord(name, line)
+				keep, err := parser.Process(ctx, r)
+				if err != nil {
+					m.skipped.Add(1)
+				}
+				if err != nil || !keep || r.Event == nil {
+					recycle(r)
+					return nil
+				}
+				select {
+				case inputs[i] <- r:
+					return nil
+				case <-ctx.Done():
+					recycle(r)
+					return ctx.Err()
+				}
+			})
+		}(i, paths, parser)
+	}
+
+	// heads holds the next record of each input still open.
+	heads := make([]*Record, len(inputs))
+	next := func(i int) {
+		heads[i] = nil
+		select {
+		case r, ok := <-inputs[i]:
+			if ok {
+				heads[i] = r
+			} else {
+				inputs[i] = nil
+			}
+		case <-ctx.Done():
+		}
+	}
+	for i := range inputs {
+		next(i)
+	}
+	for ctx.Err() == nil {
+		first := -1
+		for i, r := range heads {
+			if r != nil && (first < 0 || r.Event.Timestamp.Before(heads[first].Event.Timestamp)) {
+				first = i
+			}
+		}
+		if first < 0 {
+			break
+		}
+		select {
+		case out <- heads[first]:
+		case <-ctx.Done():
+			return nil
+		}
+		next(first)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,83 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// NamedSource is one of the sources of a Multiplexed source.
+type NamedSource struct {
+	Name   string
+	Source Source
+}
+
+// Multiplexed is a source reading several sources at once, such as an eBPF
+// collector and a log parsed heuristically watching the same workload.
+// Records go out as the sources produce them, their Source set to the name
+// of the one they came from, so stages such as Trust and Dedup can tell
+// the sources apart. A source failing is logged and the others go on; Run
+// returns once every source has ended.
+type Multiplexed struct {
+	Sources []NamedSource
+	Logger  *slog.Logger
+}
+
+// Run implements Source.
+func (m *Multiplexed) Run(ctx context.Context, out chan<- *Record) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(m.Sources))
+	for i, s := range m.Sources {
+		in := make(chan *Record)
+		wg.Add(2)
+		go func(i int, s NamedSource) {
+			defer wg.Done()
+			defer close(in)
+			if err := s.Source.Run(ctx, in); err != nil {
+				errs[i] = fmt.Errorf("source %s: %w", s.Name, err)
+				if m.Logger != nil {
+					m.Logger.Warn("source failed", "source", s.Name, "error", err)
+				}
+			}
+		}(i, s)
+		go func(name string) {
+			defer wg.Done()
+			// Drain in until its source ends, even once cancelled.
+			for r := range in {
+				r.Source = name
+				select {
+				case out <- r:
+				case <-ctx.Done():
+					recycle(r)
+				}
+			}
+		}(s.Name)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Trust returns a stage weighing the confidence of the anomalies a record
+// raised by how far its source is trusted, from 0 to 1, so that a noisy
+// source, such as one guessing operations from log lines, cannot dominate
+// a precise one, such as an eBPF collector, in incident scores and the
+// confidence alerts report. Sources without a weight are trusted fully;
+// anomalies without a confidence count as fully confident.
+func Trust(weights map[string]float64) Stage {
+	return StageFunc(func(_ context.Context, r *Record) (bool, error) {
+		weight, ok := weights[r.Source]
+		if !ok {
+			return true, nil
+		}
+		for i := range r.Anomalies {
+			a := &r.Anomalies[i]
+			if a.Confidence <= 0 || a.Confidence > 1 {
+				a.Confidence = 1
+			}
+			a.Confidence *= weight
+		}
+		return true, nil
+	})
+}
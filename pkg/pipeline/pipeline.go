@@ -0,0 +1,397 @@
+// Package pipeline composes event ingestion from pluggable stages:
+//
+//	source → parse → normalize → enrich → route → process → sinks
+//
+// A source produces records, either raw input for a parser or ready-made
+// events from a collector. Parsers turn raw input into events, normalizers
+// fill in defaults, enrichers annotate events, routers pick the baseline a
+// record belongs to, and processors learn from or detect against it. Sinks
+// receive every record that made it through, with any anomalies found.
+//
+// Each stage kind has a registry, so a pipeline can be described by name in
+// configuration (see Spec) and new sources, parsers or sinks plug in with
+// Register without changes to the pipeline itself.
+//
+// The daemon and the streaming commands (analyze, enforce, canary and
+// simulate) ingest through pipelines. Batch paths that learn or score
+// counts all at once, such as the detect and check commands and the HTTP
+// API, use the baseline package directly.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/collect"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/enrich"
+	"github.com/hallucinaut/runtimebase/pkg/metrics"
+)
+
+// Record is one item moving through a pipeline.
+type Record struct {
+	Source string // name of the source that produced the record
+	Raw    []byte // undecoded input, for the parse stage
+
+	// Event is set by the parse stage, or by sources producing events.
+	Event *detect.SystemEvent
+	// Enrichment holds annotations of the event's network peer, applied
+	// to any anomaly the record raises.
+	Enrichment map[string]string
+	// Baseline is the baseline the record is routed to.
+	Baseline string
+	// Anomalies are the findings of the process stage.
+	Anomalies []baseline.Anomaly
+	// SampleRate is how many records this one stands for when sampled 1
+	// in N before parsing (see Govern); its event is weighed accordingly.
+	SampleRate int
+	// Synthetic marks records injected to test alerting (see AlertTest).
+	// Stages that change baselines and the history sink ignore them.
+	Synthetic bool
+}
+
+// maxPooledRaw bounds the raw input buffers kept with pooled records.
+const maxPooledRaw = 64 << 10
+
+var recordPool = sync.Pool{New: func() any { return new(Record) }}
+
+// NewRecord returns a raw record of source holding a copy of raw, taken
+// from a pool that Run returns the records it is done with to. Sources
+// must not use a record after sending it.
+func NewRecord(source string, raw []byte) *Record {
+	r := recordPool.Get().(*Record)
+	r.Source = source
+	r.Raw = append(r.Raw[:0], raw...)
+	return r
+}
+
+// recycle returns a record Run is done with, and its event, to their
+// pools. Records with anomalies are left to the garbage collector, since
+// sinks such as digests keep the alerts, which share the event's maps.
+func recycle(r *Record) {
+	if len(r.Anomalies) > 0 || r.Synthetic {
+		return
+	}
+	if r.Event != nil {
+		detect.ReleaseEvent(r.Event)
+	}
+	raw := r.Raw[:0]
+	if cap(raw) > maxPooledRaw {
+		raw = nil
+	}
+	*r = Record{Raw: raw}
+	recordPool.Put(r)
+}
+
+// AddAnomalies attaches anomalies to the record, annotated with its
+// enrichment.
+func (r *Record) AddAnomalies(anomalies ...baseline.Anomaly) {
+	for i := range anomalies {
+		enrich.Apply(&anomalies[i], r.Enrichment)
+	}
+	r.Anomalies = append(r.Anomalies, anomalies...)
+}
+
+// Source produces records until ctx is cancelled or its input ends.
+type Source interface {
+	Run(ctx context.Context, out chan<- *Record) error
+}
+
+// Stage transforms a record in place. It returns false to drop the record.
+type Stage interface {
+	Process(ctx context.Context, r *Record) (bool, error)
+}
+
+// StageFunc adapts a function to Stage.
+type StageFunc func(ctx context.Context, r *Record) (bool, error)
+
+// Process implements Stage.
+func (f StageFunc) Process(ctx context.Context, r *Record) (bool, error) {
+	return f(ctx, r)
+}
+
+// Sink receives the records that passed every stage.
+type Sink interface {
+	Write(ctx context.Context, r *Record) error
+}
+
+// SinkFunc adapts a function to Sink.
+type SinkFunc func(ctx context.Context, r *Record) error
+
+// Write implements Sink.
+func (f SinkFunc) Write(ctx context.Context, r *Record) error {
+	return f(ctx, r)
+}
+
+// Flusher is implemented by stages and sinks that buffer work, such as
+// learning, which must be completed when the source ends.
+type Flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// Ticker is implemented by stages and sinks with work due on the clock
+// rather than on records, such as sending a digest whose period ended or
+// noticing that a baseline went quiet. Run calls Tick every TickEvery,
+// never while it handles a record, until the source ends.
+type Ticker interface {
+	TickEvery() time.Duration
+	// Tick does the work due at now. A stage returns the records it
+	// raised, which go through the stages after it and to the sinks; a
+	// sink returns none.
+	Tick(ctx context.Context, now time.Time) ([]*Record, error)
+}
+
+// Pipeline connects a source to stages and sinks.
+type Pipeline struct {
+	Name   string
+	Source Source
+	Stages []Stage
+	Sinks  []Sink
+	// Queue configures the queue between the source and the stages.
+	Queue QueueSpec
+	// Metrics, when set, counts the records the queue drops under
+	// MetricQueueDropped.
+	Metrics *metrics.Registry
+	Logger  *slog.Logger
+
+	mu sync.Mutex // serializes handling records and ticks in Run
+}
+
+// MetricQueueDropped counts the records pipeline queues dropped, by
+// pipeline.
+const MetricQueueDropped = "runtimebase_queue_dropped_total"
+
+// Run feeds the source's records through the pipeline until the source
+// ends or ctx is cancelled, then flushes buffered work. Records wait for
+// the stages in the pipeline's queue, whose policy decides whether a full
+// queue blocks the source or drops records. A record that fails a stage or
+// sink is logged and skipped; only source errors are returned. Records
+// that raised no anomalies are recycled once handled, so stages and sinks
+// must copy what they keep of a record or its event. Stages and sinks
+// implementing Ticker are ticked in between records.
+func (p *Pipeline) Run(ctx context.Context) error {
+	logger := p.logger()
+	queue, err := NewQueue(p.Queue)
+	if err != nil {
+		return fmt.Errorf("pipeline %s: %w", p.Name, err)
+	}
+	records := make(chan *Record)
+	errs := make(chan error, 1)
+	go func() { errs <- p.Source.Run(ctx, records) }()
+
+	var sourceErr error
+	go func() {
+		defer queue.Close()
+		dropped := 0
+		for {
+			select {
+			case r := <-records:
+				d := queue.Push(ctx, r)
+				if d == nil {
+					continue
+				}
+				recycle(d)
+				if ctx.Err() != nil {
+					continue // shutting down, not overloaded
+				}
+				if p.Metrics != nil {
+					p.Metrics.Add(MetricQueueDropped, 1, "pipeline", p.Name)
+				}
+				// Log the 1st, 2nd, 4th, 8th... drop.
+				if dropped++; dropped&(dropped-1) == 0 {
+					logger.Warn("queue full, dropping records", "pipeline", p.Name, "policy", queue.policy, "dropped", dropped)
+				}
+			case sourceErr = <-errs:
+				return
+			}
+		}
+	}()
+
+	tickCtx, stopTicks := context.WithCancel(ctx)
+	var ticking sync.WaitGroup
+	for _, t := range p.tickers() {
+		ticking.Add(1)
+		go func(t ticker) {
+			defer ticking.Done()
+			tk := time.NewTicker(t.TickEvery())
+			defer tk.Stop()
+			for {
+				select {
+				case now := <-tk.C:
+					p.mu.Lock()
+					err := p.tick(tickCtx, t, now)
+					p.mu.Unlock()
+					if err != nil {
+						logger.Warn("tick failed", "pipeline", p.Name, "error", err)
+					}
+				case <-tickCtx.Done():
+					return
+				}
+			}
+		}(t)
+	}
+
+	for r := queue.Pop(); r != nil; r = queue.Pop() {
+		p.mu.Lock()
+		err := p.Handle(ctx, r)
+		p.mu.Unlock()
+		if err != nil {
+			logger.Warn("dropped record", "pipeline", p.Name, "source", r.Source, "error", err)
+		}
+		recycle(r)
+	}
+	stopTicks()
+	ticking.Wait()
+	if ferr := p.Flush(context.WithoutCancel(ctx)); ferr != nil {
+		logger.Warn("flush failed", "pipeline", p.Name, "error", ferr)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return sourceErr
+}
+
+// Handle passes one record through the stages and, unless a stage drops
+// it, to every sink.
+func (p *Pipeline) Handle(ctx context.Context, r *Record) error {
+	_, err := p.handle(ctx, r)
+	return err
+}
+
+// handle is Handle, also reporting whether the record reached the sinks.
+func (p *Pipeline) handle(ctx context.Context, r *Record) (bool, error) {
+	return p.handleFrom(ctx, r, p.Stages)
+}
+
+// handleFrom passes a record through stages, the pipeline's stages from
+// some point on, and to every sink.
+func (p *Pipeline) handleFrom(ctx context.Context, r *Record, stages []Stage) (bool, error) {
+	for _, stage := range stages {
+		keep, err := stage.Process(ctx, r)
+		if err != nil {
+			return false, err
+		}
+		if !keep {
+			return false, nil
+		}
+	}
+	if r.Event == nil {
+		return false, nil
+	}
+	for _, sink := range p.Sinks {
+		if err := sink.Write(ctx, r); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// Tick runs every stage and sink implementing Ticker as if its work was
+// due at now, passing the records stages raise through the rest of the
+// pipeline, and returns the errors. Run ticks each on its own interval.
+func (p *Pipeline) Tick(ctx context.Context, now time.Time) error {
+	var errs []error
+	for _, t := range p.tickers() {
+		errs = append(errs, p.tick(ctx, t, now))
+	}
+	return errors.Join(errs...)
+}
+
+// ticker is a stage or sink with work due on the clock, with the stages
+// after it.
+type ticker struct {
+	Ticker
+	next []Stage
+}
+
+func (p *Pipeline) tickers() []ticker {
+	var tickers []ticker
+	for i, stage := range p.Stages {
+		if t, ok := stage.(Ticker); ok && t.TickEvery() > 0 {
+			tickers = append(tickers, ticker{t, p.Stages[i+1:]})
+		}
+	}
+	for _, sink := range p.Sinks {
+		if t, ok := sink.(Ticker); ok && t.TickEvery() > 0 {
+			tickers = append(tickers, ticker{Ticker: t})
+		}
+	}
+	return tickers
+}
+
+// tick runs t at now and passes the records it raised on.
+func (p *Pipeline) tick(ctx context.Context, t ticker, now time.Time) error {
+	records, err := t.Tick(ctx, now)
+	errs := []error{err}
+	for _, r := range records {
+		_, err := p.handleFrom(ctx, r, t.next)
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Flush completes buffered work in stages and sinks, in pipeline order.
+func (p *Pipeline) Flush(ctx context.Context) error {
+	var first error
+	for _, stage := range p.Stages {
+		if f, ok := stage.(Flusher); ok {
+			if err := f.Flush(ctx); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	for _, sink := range p.Sinks {
+		if f, ok := sink.(Flusher); ok {
+			if err := f.Flush(ctx); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+func (p *Pipeline) logger() *slog.Logger {
+	if p.Logger != nil {
+		return p.Logger
+	}
+	return slog.Default().With("component", "pipeline")
+}
+
+// FromCollector adapts a collector into a source of event records.
+func FromCollector(name string, c collect.Collector) Source {
+	return &collectorSource{name: name, collector: c}
+}
+
+type collectorSource struct {
+	name      string
+	collector collect.Collector
+}
+
+func (s *collectorSource) Run(ctx context.Context, out chan<- *Record) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events := make(chan detect.SystemEvent, 1024)
+	errs := make(chan error, 1)
+	go func() { errs <- s.collector.Run(ctx, events) }()
+	for {
+		select {
+		case event := <-events:
+			select {
+			case out <- &Record{Source: s.name, Event: &event}:
+			case <-ctx.Done():
+				return <-errs
+			}
+		case err := <-errs:
+			for len(events) > 0 {
+				event := <-events
+				out <- &Record{Source: s.name, Event: &event}
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,2060 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	netmail "net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/budget"
+	"github.com/hallucinaut/runtimebase/pkg/clock"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/enrich"
+	"github.com/hallucinaut/runtimebase/pkg/metrics"
+	"github.com/hallucinaut/runtimebase/pkg/ocihook"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+	"github.com/hallucinaut/runtimebase/pkg/storage"
+)
+
+func writeLines(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLearnThenDetect(t *testing.T) {
+	store, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocklist := &enrich.Blocklist{Name: "c2"}
+	blocklist.Add("203.0.113.7")
+	env := &Env{
+		Store:    store,
+		Enricher: &enrich.Enricher{Blocklists: []*enrich.Blocklist{blocklist}},
+		Clock:    clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)),
+	}
+
+	normal := writeLines(t,
+		`{"Type":"syscall","Data":{"syscall":"openat"}}`,
+		`{"Type":"network","Data":{"destination":"10.0.0.9:443"}}`,
+		`not json`,
+	)
+	learn := Spec{
+		Name:      "learn",
+		Source:    StageSpec{Type: "file", Options: Options{"path": normal}},
+		Parser:    StageSpec{Type: "jsonl"},
+		Normalize: []StageSpec{{Type: "defaults"}},
+		Route:     StageSpec{Type: "static", Options: Options{"baseline": "web"}},
+		Process:   []StageSpec{{Type: "learn", Options: Options{"label.owner": "payments", "label.env": "prod"}}},
+	}
+	p, err := Build(learn, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := store.LoadBaseline("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.Stats["network:10.0.0.9:443"]; !ok {
+		t.Fatalf("expected the destination to be learned, got %v", b.Stats)
+	}
+	if !b.MatchMetadata(map[string]string{"owner": "payments", "env": "prod"}) {
+		t.Fatalf("expected the labels to be set, got %v", b.Metadata)
+	}
+
+	suspect := writeLines(t,
+		`{"Type":"syscall","Data":{"syscall":"openat"}}`,
+		`{"Type":"network","Data":{"destination":"203.0.113.7:4444"}}`,
+	)
+	detect := learn
+	detect.Name = "detect"
+	detect.Source.Options = Options{"path": suspect}
+	detect.Enrich = []StageSpec{{Type: "reputation"}}
+	detect.Process = []StageSpec{{Type: "detect"}}
+	detect.Sinks = []StageSpec{{Type: "history"}}
+	if p, err = Build(detect, env); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := store.History("web", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Anomaly == nil {
+		t.Fatalf("expected one anomaly in history, got %+v", records)
+	}
+	a := records[0].Anomaly
+	if a.Evidence != "network:203.0.113.7:4444" {
+		t.Errorf("unexpected evidence %q", a.Evidence)
+	}
+	if a.Severity != severity.Critical || a.Enrichment[enrich.KeyBlocklist] != "c2" {
+		t.Errorf("expected blocklisted peer to raise severity to CRITICAL, got %s %v", a.Severity, a.Enrichment)
+	}
+	if a.Metadata["owner"] != "payments" {
+		t.Errorf("expected the baseline's labels on the anomaly, got %v", a.Metadata)
+	}
+}
+
+func TestVolume(t *testing.T) {
+	store, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &Env{Store: store}
+	write := func(bytes int) string {
+		return fmt.Sprintf(`{"Type":"file","Timestamp":"2026-01-01T00:00:00Z","Path":"/var/lib/db","Bytes":%d}`, bytes)
+	}
+	next := `{"Type":"file","Timestamp":"2026-01-01T00:01:00Z","Path":"/var/lib/db"}`
+
+	spec := Spec{
+		Name:    "learn",
+		Source:  StageSpec{Type: "file", Options: Options{"path": writeLines(t, write(4096), write(4096))}},
+		Parser:  StageSpec{Type: "jsonl"},
+		Route:   StageSpec{Type: "static", Options: Options{"baseline": "db"}},
+		Process: []StageSpec{{Type: "learn"}},
+	}
+	for i := 0; i < 2; i++ {
+		p, err := Build(spec, env)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := store.LoadBaseline("db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat := b.Volume["file:/var/lib/db"]; stat.SampleCount != 2 || stat.Mean != 8192 {
+		t.Fatalf("expected two 8 KiB samples, got %+v", stat)
+	}
+
+	// Access logs report the bytes each request served, by path.
+	access := func(path string, bytes int) string {
+		return fmt.Sprintf(`203.0.113.9 - - [01/Jan/2026:00:00:00 +0000] "GET %s HTTP/1.1" 200 %d "-" "curl/8.4.0"`, path, bytes)
+	}
+	logSpec := Spec{
+		Name:    "access",
+		Source:  StageSpec{Type: "file", Options: Options{"path": writeLines(t, access("/export?page=1", 1000), access("/export?page=2", 3000))}},
+		Parser:  StageSpec{Type: "accesslog"},
+		Route:   StageSpec{Type: "static", Options: Options{"baseline": "web"}},
+		Process: []StageSpec{{Type: "learn"}},
+	}
+	p, err := Build(logSpec, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	web, err := store.LoadBaseline("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat := web.Volume["http:/export"]; stat.SampleCount != 1 || stat.Mean != 4000 {
+		t.Fatalf("expected a 4000 byte sample of /export, got %+v", web.Volume)
+	}
+
+	spec.Name = "volume"
+	spec.Source.Options = Options{"path": writeLines(t, write(800<<10), next)}
+	spec.Process = []StageSpec{{Type: "volume"}}
+	spec.Sinks = []StageSpec{{Type: "history"}}
+	if p, err = Build(spec, env); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	records, err := store.History("db", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Anomaly == nil || records[0].Anomaly.Evidence != "file:/var/lib/db" {
+		t.Fatalf("expected a volume anomaly for /var/lib/db, got %+v", records)
+	}
+}
+
+func TestSpikes(t *testing.T) {
+	b := baseline.NewLearner().CreateBaseline("web")
+	for _, count := range []int{9, 10, 11} {
+		b.RecordObservations([]baseline.Observation{{Category: "network", Pattern: "10.0.0.9:443", Count: count}})
+	}
+
+	run := func(connections int) []storage.Record {
+		t.Helper()
+		store, err := storage.Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.SaveBaseline(b); err != nil {
+			t.Fatal(err)
+		}
+		lines := make([]string, 0, connections+1)
+		for i := 0; i < connections; i++ {
+			lines = append(lines, fmt.Sprintf(`{"Type":"network","Timestamp":"2026-01-01T00:00:%02dZ"}`, i))
+		}
+		lines = append(lines, `{"Type":"network","Timestamp":"2026-01-01T00:01:00Z"}`)
+		p, err := Build(Spec{
+			Name:    "spikes",
+			Source:  StageSpec{Type: "file", Options: Options{"path": writeLines(t, lines...)}},
+			Parser:  StageSpec{Type: "jsonl"},
+			Route:   StageSpec{Type: "static", Options: Options{"baseline": "web"}},
+			Process: []StageSpec{{Type: "spikes"}},
+			Sinks:   []StageSpec{{Type: "history"}},
+		}, &Env{Store: store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Run(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		records, err := store.History("web", time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return records
+	}
+
+	// p99 of mean 10, stddev 1 is 12.33; with a 20% margin, 15.
+	if records := run(12); len(records) != 0 {
+		t.Errorf("expected 12 connections to stay under the learned threshold, got %+v", records)
+	}
+	records := run(20)
+	if len(records) != 1 || records[0].Anomaly == nil {
+		t.Fatalf("expected one spike anomaly, got %+v", records)
+	}
+	if a := records[0].Anomaly; a.Type != "Network Connection Spike" || a.Evidence != "20 network events, above the threshold of 15" {
+		t.Errorf("unexpected anomaly %+v", a)
+	}
+
+	_, err := Build(Spec{
+		Name:    "bad",
+		Source:  StageSpec{Type: "file", Options: Options{"path": "-"}},
+		Process: []StageSpec{{Type: "spikes", Options: Options{"percentile": "99"}}},
+	}, &Env{})
+	if err == nil {
+		t.Error("expected a percentile above 1 to be rejected")
+	} else if !strings.Contains(err.Error(), "percentile") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestSilence(t *testing.T) {
+	store, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &Env{Store: store}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	event := func(syscall string, minute int) string {
+		return fmt.Sprintf(`{"Type":"syscall","Data":{"syscall":%q},"Timestamp":%q}`, syscall, start.Add(time.Duration(minute)*time.Minute).Format(time.RFC3339))
+	}
+	var learn, quiet []string
+	for minute := 0; minute < 60; minute++ {
+		learn = append(learn, event("read", minute))
+		if minute%5 == 0 {
+			learn = append(learn, event("cron", minute))
+		}
+	}
+	for minute := 60; minute < 80; minute++ {
+		quiet = append(quiet, event("read", minute))
+	}
+
+	spec := Spec{
+		Name:    "learn",
+		Source:  StageSpec{Type: "file", Options: Options{"path": writeLines(t, learn...)}},
+		Parser:  StageSpec{Type: "jsonl"},
+		Route:   StageSpec{Type: "static", Options: Options{"baseline": "svc"}},
+		Process: []StageSpec{{Type: "learn"}},
+	}
+	p, err := Build(spec, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := store.LoadBaseline("svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limit, ok := b.SilenceLimit("syscall:cron"); !ok || limit != 7*time.Minute+30*time.Second {
+		t.Fatalf("expected cron to be silent after 7m30s, got %v %v (%+v)", limit, ok, b.Gaps["syscall:cron"])
+	}
+
+	spec.Name = "silence"
+	spec.Source.Options = Options{"path": writeLines(t, quiet...)}
+	spec.Process = []StageSpec{{Type: "silence"}}
+	spec.Sinks = []StageSpec{{Type: "history"}}
+	if p, err = Build(spec, env); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	records, err := store.History("svc", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Anomaly.Type != baseline.SilenceAnomaly || records[0].Anomaly.Evidence != "syscall:cron" {
+		t.Fatalf("expected cron to be reported silent once, got %+v", records)
+	}
+
+	// Without records, ticks check the baseline against the wall clock: a
+	// service that never shows up is silent from when the detector started.
+	wall := clock.NewFake(start.Add(24 * time.Hour))
+	tickEnv := &Env{Store: store, Clock: wall}
+	silent := func(stage Stage, after time.Duration) []*Record {
+		t.Helper()
+		records, err := stage.(Ticker).Tick(context.Background(), wall.Now().Add(after))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cron []*Record
+		for _, r := range records {
+			for _, a := range r.Anomalies {
+				if a.Evidence == "syscall:cron" {
+					cron = append(cron, r)
+				}
+			}
+		}
+		return cron
+	}
+	stage, err := Processors.New("silence", tickEnv, Options{"check": "1m", "baselines": "svc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if every := stage.(Ticker).TickEvery(); every != time.Minute {
+		t.Fatalf("TickEvery() = %s, want the check period", every)
+	}
+	if cron := silent(stage, 5*time.Minute); len(cron) != 0 {
+		t.Fatalf("expected no silence within the limit, got %+v", cron)
+	}
+	cron := silent(stage, 8*time.Minute)
+	if len(cron) != 1 || cron[0].Baseline != "svc" || cron[0].Event == nil {
+		t.Fatalf("expected a dead service reported silent on a tick, got %+v", cron)
+	}
+	if cron := silent(stage, 9*time.Minute); len(cron) != 0 {
+		t.Fatalf("expected the silence reported once, got %+v", cron)
+	}
+
+	// Once records stop, event time moves on from the latest event.
+	stage, err = Processors.New("silence", tickEnv, Options{"check": "1m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r Record
+	if err := json.Unmarshal([]byte(event("cron", 100)), &r.Event); err != nil {
+		t.Fatal(err)
+	}
+	r.Baseline = "svc"
+	if _, err := stage.Process(context.Background(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if cron := silent(stage, 7*time.Minute); len(cron) != 0 {
+		t.Fatalf("expected no silence within the limit, got %+v", cron)
+	}
+	cron = silent(stage, 8*time.Minute)
+	if want := start.Add(108 * time.Minute); len(cron) != 1 || !cron[0].Event.Timestamp.Equal(want) {
+		t.Fatalf("expected cron reported silent at %s, got %+v", want, cron)
+	}
+}
+
+func TestLateness(t *testing.T) {
+	store, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	event := func(seconds int) string {
+		return fmt.Sprintf(`{"Type":"syscall","Data":{"syscall":"read"},"Timestamp":%q}`, start.Add(time.Duration(seconds)*time.Second).Format(time.RFC3339))
+	}
+	spec := Spec{
+		Name: "learn",
+		// The event at 0:50 arrives 20s late and still counts in the
+		// first minute; the one at 0:40 arrives after it closed.
+		Source:  StageSpec{Type: "file", Options: Options{"path": writeLines(t, event(10), event(70), event(50), event(100), event(40), event(130))}},
+		Parser:  StageSpec{Type: "jsonl"},
+		Route:   StageSpec{Type: "static", Options: Options{"baseline": "svc"}},
+		Process: []StageSpec{{Type: "learn", Options: Options{"lateness": "30s"}}},
+	}
+	p, err := Build(spec, &Env{Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := store.LoadBaseline("svc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := b.Stats["syscall:read"]; s.SampleCount != 3 || s.Max != 2 || s.Min != 1 {
+		t.Errorf("expected minutes of 2, 2 and 1 reads, got %+v", s)
+	}
+	if b.Late != 1 || len(b.Open) != 0 {
+		t.Errorf("expected one late event and no open minutes, got %d late, %d open", b.Late, len(b.Open))
+	}
+}
+
+func TestShadow(t *testing.T) {
+	store, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &Env{Store: store}
+	event := func(minute int, syscall string) string {
+		return fmt.Sprintf(`{"Type":"syscall","Timestamp":"2026-01-01T00:%02d:00Z","Data":{"syscall":%q}}`, minute, syscall)
+	}
+
+	learn := Spec{
+		Name:    "learn",
+		Source:  StageSpec{Type: "file", Options: Options{"path": writeLines(t, event(0, "read"), event(0, "write"))}},
+		Parser:  StageSpec{Type: "jsonl"},
+		Route:   StageSpec{Type: "static", Options: Options{"baseline": "app"}},
+		Process: []StageSpec{{Type: "learn"}},
+	}
+	p, err := Build(learn, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// After a release the app reads and also calls io_uring_enter.
+	shadow := learn
+	shadow.Name = "shadow"
+	shadow.Source.Options = Options{"path": writeLines(t,
+		event(0, "read"), event(0, "io_uring_enter"), event(1, "read"), event(1, "io_uring_enter"), event(2, "read"))}
+	shadow.Process = []StageSpec{{Type: "shadow", Options: Options{"report": "2m"}}}
+	shadow.Sinks = []StageSpec{{Type: "history"}}
+	if p, err = Build(shadow, env); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	candidate, err := store.LoadBaseline("app.candidate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if candidate.CandidateFor != "app" {
+		t.Errorf("expected the candidate to shadow app, got %q", candidate.CandidateFor)
+	}
+	if _, ok := candidate.Stats["syscall:io_uring_enter"]; !ok {
+		t.Errorf("expected the candidate to learn the new syscall, got %v", candidate.Stats)
+	}
+	active, err := store.LoadBaseline("app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := active.Stats["syscall:io_uring_enter"]; ok {
+		t.Error("expected the active baseline to be left alone")
+	}
+	if index, err := store.Index(); err != nil {
+		t.Fatal(err)
+	} else if seen, _ := index.Prevalence("syscall:io_uring_enter", "app"); seen != 0 {
+		t.Error("expected the candidate to be left out of the fleet index")
+	}
+
+	records, err := store.History("app", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Anomaly == nil || records[0].Anomaly.Type != "Baseline Divergence" {
+		t.Fatalf("expected a divergence report, got %+v", records)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	spec := Spec{Name: "custom", Source: StageSpec{Type: "file"}, Sinks: []StageSpec{{Type: "test-sink"}}}
+	if err := spec.Validate(); err == nil {
+		t.Fatal("expected unknown sink to be rejected")
+	}
+
+	var got []string
+	Sinks.Register("test-sink", func(env *Env, opts Options) (Sink, error) {
+		return SinkFunc(func(_ context.Context, r *Record) error {
+			got = append(got, r.Event.Type+"/"+r.Event.Labels["app"])
+			return nil
+		}), nil
+	})
+	defer func() {
+		Sinks.mu.Lock()
+		delete(Sinks.factories, "test-sink")
+		Sinks.mu.Unlock()
+	}()
+
+	spec.Source.Options = Options{"path": writeLines(t, `{"Type":"FILE"}`)}
+	spec.Parser = StageSpec{Type: "jsonl"}
+	spec.Normalize = []StageSpec{{Type: "defaults"}, {Type: "labels", Options: Options{"app": "api"}}}
+	p, err := Build(spec, &Env{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "file/api" {
+		t.Errorf("expected normalized event at the custom sink, got %v", got)
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	bodies := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies <- r.Header.Get("Authorization") + " " + string(b)
+	}))
+	defer srv.Close()
+
+	t.Setenv("TEST_OPSGENIE_AUTH", "GenieKey k\n")
+	sink, err := Sinks.New("webhook", &Env{}, Options{
+		"url":                  srv.URL,
+		"header.Authorization": "env:TEST_OPSGENIE_AUTH",
+		"template":             `{"message": {{json .Anomaly.Description}}, "priority": "{{if eq .Anomaly.Severity "HIGH"}}P1{{else}}P3{{end}}", "alias": "{{.Baseline}}/{{.Anomaly.Evidence}}"}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Record{Baseline: "web", Anomalies: []baseline.Anomaly{{Description: `new "openat"`, Severity: "HIGH", Evidence: "syscall:openat"}}}
+	if err := sink.Write(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	want := `GenieKey k {"message": "new \"openat\"", "priority": "P1", "alias": "web/syscall:openat"}`
+	if got := <-bodies; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	for _, template := range []string{`{"a": {{.Anomaly.Sevrity}}}`, `{"a": {{.Baseline}}}`, `{{.Baseline`} {
+		spec := Spec{Name: "p", Source: StageSpec{Type: "file"}, Sinks: []StageSpec{{Type: "webhook", Options: Options{"url": srv.URL, "template": template}}}}
+		if err := spec.Validate(); err == nil {
+			t.Errorf("expected template %s to be rejected", template)
+		}
+	}
+
+	// Header values are secret references, checked without resolving them.
+	if err := Sinks.Check("webhook", Options{"url": srv.URL, "header.Authorization": "env:TEST_UNSET_WEBHOOK_AUTH"}); err != nil {
+		t.Error(err)
+	}
+	if err := Sinks.Check("webhook", Options{"url": srv.URL, "header.Authorization": "GenieKey k"}); err == nil {
+		t.Error("expected a literal header value to be rejected")
+	}
+	if _, err := Sinks.New("webhook", &Env{}, Options{"url": srv.URL, "header.Authorization": "env:TEST_UNSET_WEBHOOK_AUTH"}); err == nil {
+		t.Error("expected an unresolvable header secret to fail")
+	}
+}
+
+func TestAlertTest(t *testing.T) {
+	store, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "alerts.jsonl")
+	spec := Spec{
+		Name:    "web",
+		Source:  StageSpec{Type: "file", Options: Options{"path": os.DevNull}},
+		Route:   StageSpec{Type: "label", Options: Options{"key": "app"}},
+		Process: []StageSpec{{Type: "learn"}, {Type: "shadow"}, {Type: "detect"}},
+		Sinks:   []StageSpec{{Type: "jsonl", Options: Options{"path": out}}, {Type: "history"}},
+	}
+	p, err := Build(spec, &Env{Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if delivered, err := p.Test(context.Background(), AlertTest(severity.Critical, "", nil, now)); delivered || err != nil {
+		t.Errorf("expected an unrouted alert test to be dropped, got %v, %v", delivered, err)
+	}
+	if delivered, err := p.Test(context.Background(), AlertTest(severity.Critical, "", map[string]string{"app": "web"}, now)); !delivered || err != nil {
+		t.Fatalf("expected the alert test to reach the sinks, got %v, %v", delivered, err)
+	}
+	if b, _ := os.ReadFile(out); !strings.Contains(string(b), `"Type":"Alert Test","Description"`) || !strings.Contains(string(b), `"Severity":"CRITICAL"`) {
+		t.Errorf("expected the synthetic anomaly in the sink, got %s", b)
+	}
+	if names, _ := store.ListBaselines(); len(names) != 0 {
+		t.Errorf("expected no baselines to be learned, got %v", names)
+	}
+	if history, _ := store.History("web", time.Time{}); len(history) != 0 {
+		t.Errorf("expected no anomaly history, got %v", history)
+	}
+}
+
+func TestDatagram(t *testing.T) {
+	store, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Socket paths are limited to about 100 bytes; t.TempDir may be longer.
+	dir, err := os.MkdirTemp("", "rb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "observe.sock")
+
+	spec := Spec{
+		Name:    "observe",
+		Source:  StageSpec{Type: "datagram", Options: Options{"listen": "unix://" + socket}},
+		Parser:  StageSpec{Type: "observation"},
+		Route:   StageSpec{Type: "static", Options: Options{"baseline": "app"}},
+		Process: []StageSpec{{Type: "learn", Options: Options{"interval": "0"}}},
+	}
+	if err := spec.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	bad := spec
+	bad.Source.Options = Options{"listen": "tcp://127.0.0.1:1"}
+	if bad.Validate() == nil {
+		t.Error("expected a stream address to be rejected")
+	}
+
+	p, err := Build(spec, &Env{Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(chan string, 2)
+	p.Sinks = append(p.Sinks, SinkFunc(func(_ context.Context, r *Record) error {
+		seen <- r.Event.Key()
+		return nil
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- p.Run(ctx) }()
+
+	var conn net.Conn
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("unixgram", socket); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Write([]byte("rpc:payments.Charge|3|env=prod\nnot an observation\nflag:checkout.v2\n")); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	// Wait for both observations to pass through before stopping the
+	// pipeline, which flushes what was learned.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-seen:
+		case <-time.After(5 * time.Second):
+			t.Fatal("observations did not reach the sinks")
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := store.LoadBaseline("app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := b.Stats["rpc:payments.Charge"]; s.Mean != 3 {
+		t.Errorf("expected the reported count to be learned, got %+v", b.Stats)
+	}
+	if _, ok := b.Stats["flag:checkout.v2"]; !ok {
+		t.Errorf("expected the flag path to be learned, got %+v", b.Stats)
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("expected the socket to be removed, got %v", err)
+	}
+}
+
+func TestGraph(t *testing.T) {
+	store, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &Env{Store: store}
+	learn := Spec{
+		Name: "learn",
+		Source: StageSpec{Type: "file", Options: Options{"path": writeLines(t,
+			`{"Type":"process","PID":10,"Path":"/usr/sbin/nginx","Data":{"action":"exec","ppid":1}}`,
+			`{"Type":"process","PID":11,"Path":"/usr/sbin/php-fpm","Data":{"action":"exec","ppid":10}}`,
+			`{"Type":"network","PID":10,"Data":{"destination":"10.0.0.9:443"}}`,
+		)}},
+		Parser:  StageSpec{Type: "jsonl"},
+		Route:   StageSpec{Type: "static", Options: Options{"baseline": "web"}},
+		Process: []StageSpec{{Type: "learn"}},
+	}
+	p, err := Build(learn, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := store.LoadBaseline("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.Graph["spawn:nginx->php-fpm"]; !ok || len(b.Graph) != 2 {
+		t.Fatalf("expected a spawn and a connect edge, got %v", b.Graph)
+	}
+
+	detect := learn
+	detect.Name = "detect"
+	detect.Source.Options = Options{"path": writeLines(t,
+		`{"Type":"process","PID":10,"Path":"/usr/sbin/nginx","Data":{"action":"exec","ppid":1}}`,
+		`{"Type":"process","PID":12,"Path":"/bin/sh","Data":{"action":"exec","ppid":10}}`,
+		`{"Type":"process","PID":13,"Path":"/bin/sh","Data":{"action":"exec","ppid":10}}`,
+		`{"Type":"network","PID":10,"Data":{"destination":"10.0.0.9:443"}}`,
+	)}
+	detect.Process = []StageSpec{{Type: "graph"}}
+	detect.Sinks = []StageSpec{{Type: "history"}}
+	if p, err = Build(detect, env); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	records, err := store.History("web", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Anomaly == nil || records[0].Anomaly.Evidence != "spawn:nginx->sh" {
+		t.Fatalf("expected the new spawn to be reported once, got %+v", records)
+	}
+	if got := records[0].Anomaly.GraphContext; len(got) != 1 || got[0].To != "php-fpm" {
+		t.Errorf("expected nginx's usual child as context, got %+v", got)
+	}
+}
+
+func TestCluster(t *testing.T) {
+	stage := Cluster(5 * time.Minute)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	process := func(minutes int, anomalies ...baseline.Anomaly) []baseline.Anomaly {
+		t.Helper()
+		for i := range anomalies {
+			anomalies[i].Timestamp = start.Add(time.Duration(minutes) * time.Minute)
+		}
+		r := &Record{Baseline: "web", Event: &detect.SystemEvent{}, Anomalies: anomalies}
+		if _, err := stage.Process(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		return r.Anomalies
+	}
+	shell := func(evidence, level string) baseline.Anomaly {
+		return baseline.Anomaly{Type: "Unseen Behavior", Evidence: evidence, Severity: level, PID: 12, Process: "sh"}
+	}
+
+	got := process(0, shell("process:/bin/sh", "MEDIUM"))
+	if len(got) != 1 || got[0].Type != "Unseen Behavior" || got[0].Incident == nil {
+		t.Fatalf("expected the first anomaly to alert as itself, got %+v", got)
+	}
+	id := got[0].Incident.ID
+	// Repeats do not raise the combined severity, so they do not alert.
+	for i := 1; i <= 3; i++ {
+		if got := process(i, shell("process:/bin/sh", "MEDIUM")); len(got) != 0 {
+			t.Fatalf("expected repeats to be absorbed, got %+v", got)
+		}
+	}
+	got = process(4, shell("network:203.0.113.7:4444", "HIGH"), shell("syscall:ptrace", "HIGH"))
+	if len(got) != 1 || got[0].Type != "Incident" || got[0].Evidence != id || got[0].Incident.Count != 6 {
+		t.Fatalf("expected one escalated incident, got %+v", got)
+	}
+	if got := process(30, shell("syscall:ptrace", "HIGH")); len(got) != 1 || got[0].Incident.ID == id {
+		t.Errorf("expected a new incident after the window, got %+v", got)
+	}
+}
+
+type sourceFunc func(ctx context.Context, out chan<- *Record) error
+
+func (f sourceFunc) Run(ctx context.Context, out chan<- *Record) error { return f(ctx, out) }
+
+// tickStage raises an anomaly on a record of its own every tick.
+type tickStage struct{ every time.Duration }
+
+func (s tickStage) Process(ctx context.Context, r *Record) (bool, error) { return true, nil }
+
+func (s tickStage) TickEvery() time.Duration { return s.every }
+
+func (s tickStage) Tick(ctx context.Context, now time.Time) ([]*Record, error) {
+	return []*Record{{
+		Baseline:  "web",
+		Event:     &detect.SystemEvent{Type: "tick", Timestamp: now},
+		Anomalies: []baseline.Anomaly{{Type: "Tick", Timestamp: now}},
+	}}, nil
+}
+
+func TestTicker(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	count := func(name string) Stage {
+		return StageFunc(func(_ context.Context, r *Record) (bool, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			seen[name]++
+			return true, nil
+		})
+	}
+	ticked := make(chan *Record, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pipeline{
+		Name: "quiet",
+		// A source without records until it is stopped.
+		Source: sourceFunc(func(ctx context.Context, out chan<- *Record) error {
+			<-ctx.Done()
+			return nil
+		}),
+		Stages: []Stage{count("before"), tickStage{every: time.Millisecond}, count("after")},
+		Sinks: []Sink{SinkFunc(func(_ context.Context, r *Record) error {
+			ticked <- r
+			return nil
+		})},
+	}
+	done := make(chan error)
+	go func() { done <- p.Run(ctx) }()
+	r := <-ticked
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Anomalies) != 1 || r.Anomalies[0].Type != "Tick" {
+		t.Errorf("expected the ticked anomaly at the sink, got %+v", r.Anomalies)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if seen["before"] != 0 || seen["after"] == 0 {
+		t.Errorf("expected ticked records to pass only the stages after the ticker, got %v", seen)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	bodies := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies <- string(b)
+	}))
+	defer srv.Close()
+
+	now := clock.NewFake(time.Date(2024, 5, 1, 10, 15, 0, 0, time.UTC))
+	spec := Spec{
+		Name:   "web",
+		Source: StageSpec{Type: "file", Options: Options{"path": os.DevNull}},
+		Sinks: []StageSpec{{Type: "webhook", Options: Options{
+			"url":             srv.URL,
+			"template":        `{{.Anomaly.Severity}}`,
+			"digest":          "hourly",
+			"digest_template": `{{.Summary}} ({{len .Alerts}} kept)`,
+			"content_type":    "text/plain",
+		}}},
+	}
+	p, err := Build(spec, &Env{Clock: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle := func(severities ...string) {
+		t.Helper()
+		r := &Record{Baseline: "web", Event: &detect.SystemEvent{Type: "syscall"}}
+		for _, s := range severities {
+			r.Anomalies = append(r.Anomalies, baseline.Anomaly{Severity: s})
+		}
+		if err := p.Handle(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := func(want ...string) {
+		t.Helper()
+		for _, w := range want {
+			select {
+			case got := <-bodies:
+				if got != w {
+					t.Errorf("expected %q, got %q", w, got)
+				}
+			default:
+				t.Errorf("expected %q, got nothing", w)
+			}
+		}
+		if len(bodies) > 0 {
+			t.Errorf("unexpected %q", <-bodies)
+		}
+	}
+
+	handle(severity.Low, severity.High)
+	expect("HIGH")
+	now.Advance(25 * time.Minute)
+	handle(severity.Medium)
+	expect()
+	now.Advance(25 * time.Minute)
+	handle()
+	expect("2 anomalies from 10:00 to 11:00: 1 LOW, 1 MEDIUM (2 kept)")
+	handle(severity.Critical, severity.Low)
+	expect("CRITICAL")
+	now.Advance(10 * time.Minute)
+	if err := p.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expect("1 anomaly from 11:00 to 11:15: 1 LOW (1 kept)")
+
+	// A quiet pipeline sends the digest when ticked after its period.
+	handle(severity.Low)
+	if err := p.Tick(context.Background(), now.Now().Add(30*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	expect()
+	if err := p.Tick(context.Background(), time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	expect("1 anomaly from 11:00 to 12:00: 1 LOW (1 kept)")
+
+	spec.Sinks = []StageSpec{{Type: "jsonl", Options: Options{"path": filepath.Join(t.TempDir(), "out.jsonl"), "digest": "daily"}}}
+	if _, err := Build(spec, &Env{}); err == nil {
+		t.Error("expected a digest on a sink without digest support to be rejected")
+	}
+	for _, opts := range []Options{{"digest": "10s"}, {"digest": "weekly"}, {"digest": "1h", "digest_immediate": "SEVERE"}, {"digest_immediate": "HIGH"}} {
+		spec.Sinks = []StageSpec{{Type: "log", Options: opts}}
+		if err := spec.Validate(); err == nil {
+			t.Errorf("expected %v to be rejected", opts)
+		}
+	}
+}
+
+// smtpServer is a minimal SMTP server recording the mails it receives.
+type smtpServer struct {
+	addr  string
+	auth  chan string
+	mails chan smtpMail
+}
+
+type smtpMail struct {
+	to  []string
+	msg string
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &smtpServer{addr: ln.Addr().String(), auth: make(chan string, 10), mails: make(chan smtpMail, 10)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+	tp.PrintfLine("220 localhost ESMTP")
+	var mail smtpMail
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			tp.PrintfLine("250-localhost")
+			tp.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			s.auth <- arg
+			tp.PrintfLine("235 2.7.0 Authentication successful")
+		case "MAIL":
+			mail = smtpMail{}
+			tp.PrintfLine("250 OK")
+		case "RCPT":
+			mail.to = append(mail.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			tp.PrintfLine("250 OK")
+		case "DATA":
+			tp.PrintfLine("354 Go ahead")
+			b, _ := tp.ReadDotBytes()
+			mail.msg = string(b)
+			s.mails <- mail
+			tp.PrintfLine("250 OK")
+		case "QUIT":
+			tp.PrintfLine("221 Bye")
+			return
+		default:
+			tp.PrintfLine("502 Not implemented")
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	srv := newSMTPServer(t)
+	t.Setenv("TEST_SMTP_PASSWORD", "s3cret")
+	opts := Options{
+		"addr":     srv.addr,
+		"tls":      "none",
+		"from":     "runtimebase <alerts@example.com>",
+		"to":       "team@example.com",
+		"to.HIGH":  "oncall@example.com, team@example.com",
+		"username": "alerts",
+		"password": "env:TEST_SMTP_PASSWORD",
+	}
+	sink, err := Sinks.New("email", &Env{}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Record{Baseline: "web", Anomalies: []baseline.Anomaly{
+		{Type: "New Behavior", Severity: severity.Low, Description: "new <script>", Evidence: "file:/tmp/x"},
+		{Type: "New Behavior", Severity: severity.High, Description: "spawned sh", Evidence: "process:/bin/sh",
+			Remediation: &baseline.Remediation{Runbook: "https://runbooks.example.com/shell"}},
+	}}
+	if err := sink.Write(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	if auth := <-srv.auth; !strings.Contains(auth, base64.StdEncoding.EncodeToString([]byte("\x00alerts\x00s3cret"))) {
+		t.Errorf("expected PLAIN authentication with the resolved password, got %q", auth)
+	}
+	decode := func(msg string) (string, string) {
+		t.Helper()
+		m, err := netmail.ReadMessage(strings.NewReader(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(quotedprintable.NewReader(m.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m.Header.Get("Subject"), string(body)
+	}
+	low := <-srv.mails
+	if strings.Join(low.to, ",") != "team@example.com" {
+		t.Errorf("expected the LOW anomaly to go to the team only, got %v", low.to)
+	}
+	if subject, body := decode(low.msg); subject != "[runtimebase] LOW New Behavior on web" || !strings.Contains(body, "new &lt;script&gt;") {
+		t.Errorf("unexpected LOW mail %q:\n%s", subject, body)
+	}
+	high := <-srv.mails
+	if strings.Join(high.to, ",") != "team@example.com,oncall@example.com" {
+		t.Errorf("expected the HIGH anomaly to go to the team and on-call, got %v", high.to)
+	}
+	if _, body := decode(high.msg); !strings.Contains(body, `<a href="https://runbooks.example.com/shell">`) {
+		t.Errorf("expected the runbook link in the mail, got\n%s", body)
+	}
+
+	digests := sink.(DigestWriter)
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	if err := digests.WriteDigest(context.Background(), Digest{Start: start, End: start.Add(time.Hour), Count: 1,
+		Severities: map[string]int{severity.Low: 1}, Alerts: []Alert{{Baseline: "web", Anomaly: r.Anomalies[0]}}}); err != nil {
+		t.Fatal(err)
+	}
+	<-srv.auth
+	if subject, _ := decode((<-srv.mails).msg); subject != "[runtimebase] 1 anomaly from 10:00 to 11:00: 1 LOW" {
+		t.Errorf("unexpected digest subject %q", subject)
+	}
+
+	opts["tls"] = "starttls"
+	sink, err = Sinks.New("email", &Env{}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(context.Background(), r); err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("expected a server without STARTTLS to be refused, got %v", err)
+	}
+
+	for _, bad := range []Options{
+		{"addr": srv.addr, "to": "a@example.com"},
+		{"addr": srv.addr, "from": "b@example.com"},
+		{"addr": srv.addr, "from": "b@example.com", "to.SEVERE": "a@example.com"},
+		{"addr": srv.addr, "from": "b@example.com", "to": "a@example.com", "subject": "{{.Anomaly.Sevrity}}"},
+		{"addr": srv.addr, "from": "b@example.com", "to": "a@example.com", "password": "env:X"},
+		{"addr": "localhost", "from": "b@example.com", "to": "a@example.com"},
+	} {
+		spec := Spec{Name: "p", Source: StageSpec{Type: "file"}, Sinks: []StageSpec{{Type: "email", Options: bad}}}
+		if err := spec.Validate(); err == nil {
+			t.Errorf("expected %v to be rejected", bad)
+		}
+	}
+}
+
+func TestSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	opts := Options{
+		"address":         "udp://" + conn.LocalAddr().String(),
+		"facility":        "local4",
+		"hostname":        "node 1",
+		"severity.MEDIUM": "err",
+	}
+	sink, err := Sinks.New("syslog", &Env{}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	r := &Record{Baseline: "web", Source: "events.jsonl", Anomalies: []baseline.Anomaly{
+		{Type: "New Behavior", Severity: severity.Critical, Timestamp: at, Description: "spawned sh",
+			Evidence: `process:/bin/sh -c "id"]`, Confidence: 0.9},
+		{Type: "Frequency Anomaly", Severity: severity.Medium, Timestamp: at, Description: "more reads"},
+	}}
+	if err := sink.Write(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func() string {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 4096)
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+	// local4 is 20: CRITICAL maps to crit, 20*8+2.
+	want := fmt.Sprintf(`<162>1 2024-05-01T10:00:00.000000Z node1 runtimebase %d anomaly `, os.Getpid()) +
+		`[runtimebase@32473 baseline="web" source="events.jsonl" type="New Behavior" severity="CRITICAL" ` +
+		`evidence="process:/bin/sh -c \"id\"\]" confidence="0.90"] spawned sh`
+	if msg := read(); msg != want {
+		t.Errorf("unexpected message\n got %s\nwant %s", msg, want)
+	}
+	if msg := read(); !strings.HasPrefix(msg, "<163>1 ") {
+		t.Errorf("expected MEDIUM mapped to err by its option, got %s", msg)
+	}
+
+	for _, bad := range []Options{
+		{"address": "udp://localhost"},
+		{"address": "http://localhost:514"},
+		{"facility": "local9"},
+		{"severity.HIGH": "severe"},
+		{"sd_id": "runtimebase"},
+	} {
+		spec := Spec{Name: "p", Source: StageSpec{Type: "file"}, Sinks: []StageSpec{{Type: "syslog", Options: bad}}}
+		if err := spec.Validate(); err == nil {
+			t.Errorf("expected %v to be rejected", bad)
+		}
+	}
+}
+
+// rawSource sends n copies of a raw line, pooled or freshly allocated.
+type rawSource struct {
+	line   []byte
+	n      int
+	pooled bool
+}
+
+func (s rawSource) Run(ctx context.Context, out chan<- *Record) error {
+	for i := 0; i < s.n; i++ {
+		r := &Record{Source: "bench", Raw: append([]byte(nil), s.line...)}
+		if s.pooled {
+			r = NewRecord("bench", s.line)
+		}
+		out <- r
+	}
+	return nil
+}
+
+// BenchmarkIngest measures parsing and routing JSON lines events, with
+// records and events pooled as Run recycles them and, for comparison,
+// allocated afresh per event. It reports the events per second and the
+// garbage collections per million events.
+func BenchmarkIngest(b *testing.B) {
+	line := []byte(`{"Type":"network","Timestamp":"2026-10-01T12:00:00Z","ProcessName":"nginx","PID":1200,` +
+		`"Data":{"source":"ebpf","destination":"10.0.0.9:443","protocol":"tcp","syscall":"connect","fd":12},` +
+		`"Labels":{"env":"prod","region":"eu-west-1"},"Bytes":512}`)
+	for _, pooled := range []bool{true, false} {
+		name := "pooled"
+		parse := ParserInto(func(raw []byte, e *detect.SystemEvent) (bool, error) {
+			return true, json.Unmarshal(raw, e)
+		})
+		if !pooled {
+			name = "unpooled"
+			parse = Parser(func(raw []byte) (detect.SystemEvent, bool, error) {
+				var e detect.SystemEvent
+				return e, true, json.Unmarshal(raw, &e)
+			})
+		}
+		b.Run(name, func(b *testing.B) {
+			var events int
+			p := &Pipeline{
+				Name:   "bench",
+				Source: rawSource{line: line, n: b.N, pooled: pooled},
+				Stages: []Stage{parse, Defaults(time.Now), StaticRoute("web")},
+				Sinks: []Sink{SinkFunc(func(_ context.Context, r *Record) error {
+					events++
+					return nil
+				})},
+			}
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			b.ReportAllocs()
+			b.ResetTimer()
+			if err := p.Run(context.Background()); err != nil {
+				b.Fatal(err)
+			}
+			b.StopTimer()
+			runtime.ReadMemStats(&after)
+			if events != b.N {
+				b.Fatalf("handled %d of %d events", events, b.N)
+			}
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "events/s")
+			b.ReportMetric(float64(after.NumGC-before.NumGC)/float64(b.N)*1e6, "gc/Mevents")
+		})
+	}
+}
+
+func TestEscalate(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	stage, err := Processors.New("escalate", &Env{Clock: clock.NewFake(now)}, Options{"window": "10m", "after": "2", "policy": "threshold"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got baseline.Anomaly
+	for i := 0; i < 2; i++ {
+		r := &Record{Baseline: "web", Anomalies: []baseline.Anomaly{{Type: "Unseen Behavior", Evidence: "process:/bin/sh", Severity: "LOW",
+			Timestamp: now.Add(time.Duration(i) * 10 * time.Minute)}}}
+		if _, err := stage.Process(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		got = r.Anomalies[0]
+	}
+	if got.Severity != "HIGH" || got.Recurrence == nil || got.Recurrence.Windows != 2 {
+		t.Errorf("escalated = %s %+v", got.Severity, got.Recurrence)
+	}
+	for _, opts := range []Options{{"policy": "exponential"}, {"after": "0"}, {"to": "LOUD"}} {
+		if err := Processors.Check("escalate", opts); err == nil {
+			t.Errorf("expected %v to be rejected", opts)
+		}
+	}
+}
+
+func TestClock(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	stage, err := Normalizers.New("clock", &Env{Clock: clock.NewFake(now)}, Options{"skew": "auto", "offsets": "db=-2m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	correct := func(source, host string, reported time.Time) time.Time {
+		t.Helper()
+		r := &Record{Source: source, Event: &detect.SystemEvent{Type: "syscall", Timestamp: reported, Labels: map[string]string{"host": host}}}
+		if _, err := stage.Process(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+		return r.Event.Timestamp
+	}
+	// web's clock is 30s ahead; events arrive 50 to 200ms after they
+	// happen.
+	for _, delay := range []time.Duration{200, 50, 120} {
+		correct("agent", "web", now.Add(30*time.Second-delay*time.Millisecond))
+	}
+	if got := correct("agent", "web", now.Add(29*time.Second)); !got.Equal(now.Add(-950 * time.Millisecond)) {
+		t.Errorf("web event corrected to %s, want %s", got, now.Add(-950*time.Millisecond))
+	}
+	// Delays within the tolerance are not skew, nor is a backlog.
+	if got := correct("agent", "cache", now.Add(-300*time.Millisecond)); !got.Equal(now.Add(-300 * time.Millisecond)) {
+		t.Errorf("cache event corrected to %s", got)
+	}
+	if got := correct("replay", "", now.Add(-48*time.Hour)); !got.Equal(now.Add(-48 * time.Hour)) {
+		t.Errorf("replayed event corrected to %s", got)
+	}
+	if got := correct("agent", "db", now.Add(-2*time.Minute)); !got.Equal(now) {
+		t.Errorf("db event corrected to %s, want its fixed offset taken off", got)
+	}
+
+	berlin, err := Normalizers.New("clock", &Env{}, Options{"timezone": "Europe/Berlin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Record{Event: &detect.SystemEvent{Type: "syscall", Timestamp: time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)}}
+	if _, err := berlin.Process(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC); !r.Event.Timestamp.Equal(want) {
+		t.Errorf("Berlin wall clock time read as %s, want %s", r.Event.Timestamp.UTC(), want)
+	}
+	for _, opts := range []Options{{}, {"timezone": "Mars/Olympus"}, {"skew": "ntp"}, {"offsets": "web"}, {"skew": "auto", "samples": "0"}} {
+		if err := Normalizers.Check("clock", opts); err == nil {
+			t.Errorf("expected %v to be rejected", opts)
+		}
+	}
+}
+
+func TestDedup(t *testing.T) {
+	reg := metrics.NewRegistry()
+	stage, err := Normalizers.New("dedup", &Env{Metrics: reg}, Options{"window": "1s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	kept := func(source string, pid int, path string, at time.Duration) bool {
+		t.Helper()
+		r := &Record{Source: source, Event: &detect.SystemEvent{Type: "file", PID: pid, Path: path, Timestamp: start.Add(at)}}
+		keep, err := stage.Process(context.Background(), r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keep
+	}
+	// auditd and Sysmon both see pid 7 write the config twice.
+	for _, at := range []time.Duration{100, 300} {
+		if !kept("audit.log", 7, "/etc/app.conf", at*time.Millisecond) {
+			t.Errorf("expected the first report at %dms kept", at)
+		}
+	}
+	if kept("sysmon.log", 7, "/etc/app.conf", 120*time.Millisecond) || kept("sysmon.log", 7, "/etc/app.conf", 310*time.Millisecond) {
+		t.Error("expected the second reports dropped")
+	}
+	if !kept("sysmon.log", 7, "/etc/app.conf", 400*time.Millisecond) {
+		t.Error("expected a third write only Sysmon saw kept")
+	}
+	if !kept("sysmon.log", 8, "/etc/app.conf", 150*time.Millisecond) || !kept("sysmon.log", 7, "/etc/hosts", 150*time.Millisecond) || !kept("sysmon.log", 7, "/etc/app.conf", 1200*time.Millisecond) {
+		t.Error("expected other processes, objects and buckets kept")
+	}
+	if got := stage.(*Dedup).Dropped(); got != 2 {
+		t.Errorf("Dropped() = %d, want 2", got)
+	}
+	if got := reg.Value(MetricDuplicates, "type", "file", "origin", "sysmon.log"); got != 2 {
+		t.Errorf("%s = %v, want 2", MetricDuplicates, got)
+	}
+
+	// One stream carrying both collectors' events tells them apart by label.
+	byLabel, err := Normalizers.New("dedup", &Env{}, Options{"origin": "label.collector"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keeps []bool
+	for _, collector := range []string{"auditd", "auditd", "sysmon", "sysmon", "sysmon"} {
+		r := &Record{Source: "-", Event: &detect.SystemEvent{Type: "syscall", PID: 7, Data: map[string]interface{}{"syscall": "connect"}, Timestamp: start, Labels: map[string]string{"collector": collector}}}
+		keep, _ := byLabel.Process(context.Background(), r)
+		keeps = append(keeps, keep)
+	}
+	if want := []bool{true, true, false, false, true}; !slices.Equal(keeps, want) {
+		t.Errorf("kept %v, want %v", keeps, want)
+	}
+	for _, opts := range []Options{{"window": "0s"}, {"origin": "collector"}, {"origin": "label."}} {
+		if err := Normalizers.Check("dedup", opts); err == nil {
+			t.Errorf("expected %v to be rejected", opts)
+		}
+	}
+}
+
+func TestDrift(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	stable := func(n int) baseline.Stat {
+		return baseline.Stat{Mean: float64(n), StdDev: 1, Min: float64(n - 1), Max: float64(n + 1), SampleCount: 30}
+	}
+	// Version 2 reads through io_uring, moved its database and runs its
+	// workers from another path.
+	versions := map[string][]detect.SystemEvent{
+		"v1": {
+			{Type: "syscall", Data: map[string]interface{}{"syscall": "read"}},
+			{Type: "network", Data: map[string]interface{}{"destination": "10.0.0.5:5432"}},
+			{Type: "process", Path: "/usr/bin/worker"},
+		},
+		"v2": {
+			{Type: "syscall", Data: map[string]interface{}{"syscall": "io_uring_enter"}},
+			{Type: "network", Data: map[string]interface{}{"destination": "10.0.0.6:5432"}},
+			{Type: "process", Path: "/opt/app/worker"},
+		},
+	}
+	run := func(critical bool) ([]baseline.Anomaly, *storage.Store) {
+		store, err := storage.Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := baseline.NewLearner().CreateBaseline("web")
+		b.Stats = map[string]baseline.Stat{"syscall:read": stable(10), "network:10.0.0.5:5432": stable(10), "process:/usr/bin/worker": stable(10)}
+		b.SetMetadata(map[string]string{"version": "v1", "owner": "payments"})
+		if err := store.SaveBaseline(b); err != nil {
+			t.Fatal(err)
+		}
+		stage, err := Processors.New("drift", &Env{Store: store}, Options{"interval": "1m", "sustained": "2", "deploy": "version", "candidate": "true"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var raised []baseline.Anomaly
+		for minute := 0; minute < 8; minute++ {
+			version := "v1"
+			if minute >= 3 {
+				version = "v2"
+			}
+			for i := 0; i < 10; i++ {
+				for _, e := range versions[version] {
+					e.Timestamp = start.Add(time.Duration(minute)*time.Minute + time.Duration(i)*time.Second)
+					e.Labels = map[string]string{"version": version}
+					r := &Record{Baseline: "web", Event: &e}
+					if critical && minute >= 3 && i == 0 {
+						r.Anomalies = []baseline.Anomaly{{Type: "Reverse Shell", Severity: severity.Critical}}
+					}
+					if _, err := stage.Process(context.Background(), r); err != nil {
+						t.Fatal(err)
+					}
+					for _, a := range r.Anomalies {
+						if a.Type == RebaselineRecommended {
+							raised = append(raised, a)
+						}
+					}
+				}
+			}
+		}
+		if err := stage.(Flusher).Flush(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return raised, store
+	}
+
+	raised, store := run(false)
+	if len(raised) != 1 {
+		t.Fatalf("expected one advisory, got %+v", raised)
+	}
+	a := raised[0]
+	if a.Evidence != "network,process,syscall" || !a.Timestamp.Equal(start.Add(5*time.Minute)) || !strings.Contains(a.Description, "from v1 to v2") {
+		t.Errorf("unexpected advisory %+v", a)
+	}
+	candidate, err := store.LoadBaseline("web" + DefaultCandidateSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if candidate.CandidateFor != "web" || candidate.Metadata["version"] != "v2" || candidate.Metadata["owner"] != "payments" {
+		t.Errorf("expected a candidate for web labeled v2, got %s with %v", candidate.CandidateFor, candidate.Metadata)
+	}
+	if _, ok := candidate.Stats["syscall:io_uring_enter"]; !ok {
+		t.Errorf("expected the candidate to learn version 2, got %v", candidate.Stats)
+	}
+
+	if raised, _ := run(true); len(raised) != 0 {
+		t.Errorf("expected no advisory for drift raising critical anomalies, got %+v", raised)
+	}
+	for _, opts := range []Options{{"share": "2"}, {"sustained": "0"}, {"interval": "-1m"}} {
+		if err := Processors.Check("drift", opts); err == nil {
+			t.Errorf("expected %v to be rejected", opts)
+		}
+	}
+}
+
+func TestQueue(t *testing.T) {
+	ctx := context.Background()
+	rec := func(i int) *Record { return &Record{Source: fmt.Sprint(i)} }
+	drain := func(q *Queue) string {
+		q.Close()
+		var got []string
+		for r := q.Pop(); r != nil; r = q.Pop() {
+			got = append(got, r.Source)
+		}
+		return strings.Join(got, ",")
+	}
+
+	newest, _ := NewQueue(QueueSpec{Size: 2, Policy: QueueDropNewest})
+	oldest, _ := NewQueue(QueueSpec{Size: 2, Policy: QueueDropOldest})
+	var droppedNewest, droppedOldest []string
+	for i := 1; i <= 3; i++ {
+		if d := newest.Push(ctx, rec(i)); d != nil {
+			droppedNewest = append(droppedNewest, d.Source)
+		}
+		if d := oldest.Push(ctx, rec(i)); d != nil {
+			droppedOldest = append(droppedOldest, d.Source)
+		}
+	}
+	if got := drain(newest); got != "1,2" || fmt.Sprint(droppedNewest) != "[3]" {
+		t.Errorf("drop-newest kept %s, dropped %v", got, droppedNewest)
+	}
+	if got := drain(oldest); got != "2,3" || fmt.Sprint(droppedOldest) != "[1]" {
+		t.Errorf("drop-oldest kept %s, dropped %v", got, droppedOldest)
+	}
+
+	block, _ := NewQueue(QueueSpec{Size: 1})
+	block.Push(ctx, rec(1))
+	pushed := make(chan *Record)
+	go func() { pushed <- block.Push(ctx, rec(2)) }()
+	select {
+	case <-pushed:
+		t.Fatal("push to a full blocking queue returned")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if r := block.Pop(); r.Source != "1" {
+		t.Fatalf("popped %s", r.Source)
+	}
+	if d := <-pushed; d != nil {
+		t.Errorf("blocked push dropped %s", d.Source)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if d := block.Push(cancelled, rec(3)); d == nil || d.Source != "3" {
+		t.Errorf("push after cancel dropped %v", d)
+	}
+	if got := drain(block); got != "2" {
+		t.Errorf("blocking queue kept %s", got)
+	}
+
+	for _, bad := range []QueueSpec{{Size: -1}, {Policy: "spill"}} {
+		if _, err := NewQueue(bad); err == nil {
+			t.Errorf("NewQueue(%+v) succeeded", bad)
+		}
+	}
+
+	// A sink slower than the source loses records to a dropping queue
+	// rather than holding the source up, and the losses are counted.
+	reg := metrics.NewRegistry()
+	written := 0
+	p := &Pipeline{
+		Name:    "slow",
+		Source:  rawSource{line: []byte("x"), n: 100},
+		Stages:  []Stage{StageFunc(func(_ context.Context, r *Record) (bool, error) { r.Event = &detect.SystemEvent{}; return true, nil })},
+		Queue:   QueueSpec{Size: 4, Policy: QueueDropOldest},
+		Metrics: reg,
+		Sinks: []Sink{SinkFunc(func(context.Context, *Record) error {
+			time.Sleep(time.Millisecond)
+			written++
+			return nil
+		})},
+	}
+	if err := p.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	dropped := reg.Value(MetricQueueDropped, "pipeline", "slow")
+	if dropped == 0 || written+int(dropped) != 100 {
+		t.Errorf("wrote %d and dropped %v of 100 records", written, dropped)
+	}
+}
+
+func TestCapture(t *testing.T) {
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	stage, err := Processors.New("capture", &Env{Clock: clock.NewFake(now)}, Options{"dir": dir, "window": "1m", "events": "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := func(pid, sec int, syscall string) *Record {
+		e := &detect.SystemEvent{Type: "syscall", PID: pid, Timestamp: now.Add(time.Duration(sec) * time.Second), Data: map[string]interface{}{"syscall": syscall}}
+		return &Record{Baseline: "web", Event: e}
+	}
+	low, high := event(1, 80, "write"), event(1, 85, "connect")
+	low.Anomalies = []baseline.Anomaly{{Severity: "LOW"}}
+	high.Anomalies = []baseline.Anomaly{{Severity: "CRITICAL"}, {Severity: "LOW"}}
+	records := []*Record{
+		event(1, 0, "read"),  // outside the window when the anomaly fires
+		event(2, 70, "read"), // another process
+		event(1, 70, "open"), // beyond the buffer's bound of 3
+		event(1, 75, "stat"),
+		event(1, 80, "mmap"),
+		low,
+		high,
+	}
+	for _, r := range records {
+		if _, err := stage.Process(context.Background(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if low.Anomalies[0].Capture != "" || high.Anomalies[1].Capture != "" {
+		t.Error("expected only anomalies at or above the severity to capture")
+	}
+	data, err := os.ReadFile(high.Anomalies[0].Capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var e detect.SystemEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, e.Data["syscall"].(string))
+	}
+	if strings.Join(got, ",") != "mmap,write,connect" {
+		t.Errorf("captured %v", got)
+	}
+	if err := Processors.Check("capture", Options{"severity": "LOUD"}); err == nil {
+		t.Error("expected an unknown severity to be rejected")
+	}
+
+	// Captures into an encrypted store are encrypted too.
+	t.Setenv("TEST_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	plain, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := plain.WithEncryption(storage.EnvKey("TEST_KEY"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stage, err = Processors.New("capture", &Env{Store: store, Clock: clock.NewFake(now)}, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	sealed := event(3, 0, "connect")
+	sealed.Anomalies = []baseline.Anomaly{{Severity: "HIGH"}}
+	if _, err := stage.Process(context.Background(), sealed); err != nil {
+		t.Fatal(err)
+	}
+	path := sealed.Anomalies[0].Capture
+	if !strings.HasPrefix(path, filepath.Join(store.Dir, "captures", "web")) || !strings.HasSuffix(path, ".jsonl.enc") {
+		t.Fatalf("unexpected encrypted capture path %q", path)
+	}
+	if data, err = os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if !storage.IsEncrypted(data) || strings.Contains(string(data), "connect") {
+		t.Error("expected the capture encrypted at rest")
+	}
+	if opened, err := store.OpenCapture("web", data); err != nil || !strings.Contains(string(opened), `"connect"`) {
+		t.Errorf("expected the capture decrypted, got %q: %v", opened, err)
+	}
+	if _, err := plain.OpenCapture("web", data); !errors.Is(err, storage.ErrEncrypted) {
+		t.Errorf("expected ErrEncrypted without a key, got %v", err)
+	}
+}
+
+func TestTickets(t *testing.T) {
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path+" "+r.Header.Get("Authorization"))
+		switch {
+		case r.URL.Path == "/repos/acme/web/issues":
+			fmt.Fprint(w, `{"number": 7}`)
+		case r.URL.Path == "/rest/api/2/issue":
+			fmt.Fprint(w, `{"key": "OPS-7"}`)
+		case strings.HasSuffix(r.URL.Path, "/transitions") && r.Method == http.MethodGet:
+			fmt.Fprint(w, `{"transitions": [{"id": "11", "name": "In Progress"}, {"id": "31", "name": "Done"}]}`)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("RB_TEST_TRACKER_TOKEN", "s3cret")
+	now := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	c := clock.NewFake(now)
+	state := filepath.Join(t.TempDir(), "tickets.json")
+	opts := Options{"url": srv.URL, "repo": "acme/web", "token": "env:RB_TEST_TRACKER_TOKEN", "close_after": "1h", "state": state}
+	anomaly := func(level string) *Record {
+		return &Record{Baseline: "web", Anomalies: []baseline.Anomaly{{Type: "Unseen Behavior", Evidence: "process:/bin/sh", Severity: level}}}
+	}
+
+	sink, err := Sinks.New("github", &Env{Clock: c}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, level := range []string{"LOW", "HIGH", "HIGH"} {
+		if err := sink.Write(context.Background(), anomaly(level)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A restart picks the open ticket up from the state file.
+	if sink, err = Sinks.New("github", &Env{Clock: c}, opts); err != nil {
+		t.Fatal(err)
+	}
+	c.Advance(30 * time.Minute)
+	sink.Write(context.Background(), anomaly("CRITICAL"))
+	c.Advance(30 * time.Minute)
+	sink.(Flusher).Flush(context.Background()) // not quiet for an hour yet
+	c.Advance(30 * time.Minute)
+	if err := sink.(Flusher).Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"POST /repos/acme/web/issues Bearer s3cret",
+		"POST /repos/acme/web/issues/7/comments Bearer s3cret", // severity rose
+		"POST /repos/acme/web/issues/7/comments Bearer s3cret", // closing
+		"PATCH /repos/acme/web/issues/7 Bearer s3cret",
+	}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("calls:\n%s\nwant:\n%s", strings.Join(calls, "\n"), strings.Join(want, "\n"))
+	}
+
+	calls = nil
+	jira, err := Sinks.New("jira", &Env{Clock: c}, Options{"url": srv.URL, "project": "OPS", "user": "bot@acme.test", "token": "env:RB_TEST_TRACKER_TOKEN", "close_after": "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jira.Write(context.Background(), anomaly("HIGH"))
+	// Quiet incidents are closed on ticks, without waiting for records.
+	if every := jira.(Ticker).TickEvery(); every != ticketTick {
+		t.Fatalf("TickEvery() = %s, want %s", every, ticketTick)
+	}
+	if _, err := jira.(Ticker).Tick(context.Background(), c.Now().Add(2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("bot@acme.test:s3cret"))
+	want = []string{
+		"POST /rest/api/2/issue " + basic,
+		"GET /rest/api/2/issue/OPS-7/transitions " + basic,
+		"POST /rest/api/2/issue/OPS-7/comment " + basic,
+		"POST /rest/api/2/issue/OPS-7/transitions " + basic,
+	}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("jira calls:\n%s\nwant:\n%s", strings.Join(calls, "\n"), strings.Join(want, "\n"))
+	}
+
+	opts["close_after"] = "0"
+	if sink, err = Sinks.New("github", &Env{Clock: c}, opts); err != nil {
+		t.Fatal(err)
+	}
+	if every := sink.(Ticker).TickEvery(); every != 0 {
+		t.Errorf("expected no ticks for tickets that never close, got %s", every)
+	}
+
+	for _, opts := range []Options{{"repo": "acme", "token": "env:T"}, {"repo": "acme/web"}, {"repo": "acme/web", "token": "env:T", "severity": "LOUD"}} {
+		if err := Sinks.Check("github", opts); err == nil {
+			t.Errorf("expected %v to be rejected", opts)
+		}
+	}
+}
+
+func TestExits(t *testing.T) {
+	store, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &Env{Store: store}
+	exit := func(sec int, code string) string {
+		return fmt.Sprintf(`{"Type":"process","Timestamp":"2026-01-01T00:00:%02dZ","Path":"/usr/bin/worker","PID":%d,"Data":{"action":"exit",%s}}`, sec, 100+sec, code)
+	}
+	spec := Spec{
+		Name:    "learn",
+		Source:  StageSpec{Type: "file", Options: Options{"path": writeLines(t, exit(0, `"exit_code":0`), exit(1, `"exit_code":1`))}},
+		Parser:  StageSpec{Type: "jsonl"},
+		Route:   StageSpec{Type: "static", Options: Options{"baseline": "web"}},
+		Process: []StageSpec{{Type: "learn"}},
+	}
+	p, err := Build(spec, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spec.Name = "exits"
+	spec.Source.Options = Options{"path": writeLines(t,
+		exit(10, `"exit_code":1`), exit(11, `"signal":"SIGSEGV"`), exit(12, `"signal":11`), exit(13, `"exit_code":0`))}
+	spec.Process = []StageSpec{{Type: "exits"}}
+	spec.Sinks = []StageSpec{{Type: "history"}}
+	if p, err = Build(spec, env); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	records, err := store.History("web", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range records {
+		got = append(got, r.Anomaly.Type+" "+r.Anomaly.Evidence)
+	}
+	want := "Process Crash exit:/usr/bin/worker:SIGSEGV,Crash Loop restart:/usr/bin/worker"
+	if strings.Join(got, ",") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+}
+
+func TestMerged(t *testing.T) {
+	event := func(minute int, syscall string) string {
+		return fmt.Sprintf(`{"Type":"syscall","Timestamp":"2026-01-01T00:%02d:00Z","Data":{"syscall":%q}}`, minute, syscall)
+	}
+	rotated := writeLines(t, event(0, "open"))
+	audit := writeLines(t, event(2, "read"), "not json", event(4, "read"))
+	app := writeLines(t, event(1, "write"), event(3, "write"), event(5, "write"))
+	m := &Merged{
+		Inputs: [][]string{{rotated, audit}, {app}},
+		Parser: func() (Stage, error) { return Parsers.New("jsonl", &Env{}, nil) },
+	}
+	records := make(chan *Record)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- m.Run(context.Background(), records)
+		close(records)
+	}()
+	var got []string
+	for r := range records {
+		got = append(got, r.Event.Timestamp.Format("04")+" "+r.Event.Key())
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	want := "00 syscall:open,01 syscall:write,02 syscall:read,03 syscall:write,04 syscall:read,05 syscall:write"
+	if strings.Join(got, ",") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+	if m.Lines() != 7 || m.Skipped() != 1 {
+		t.Errorf("expected 7 lines with 1 skipped, got %d and %d", m.Lines(), m.Skipped())
+	}
+}
+
+func TestMultiplexed(t *testing.T) {
+	store, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := baseline.NewLearner().CreateBaseline("web")
+	b.Stats = map[string]baseline.Stat{"syscall:openat": {Mean: 1, SampleCount: 10}}
+	if err := store.SaveBaseline(b); err != nil {
+		t.Fatal(err)
+	}
+	spec := Spec{
+		Name: "web",
+		Sources: []SourceSpec{
+			{Name: "ebpf", Type: "file", Options: Options{"path": writeLines(t, `{"Type":"syscall","Data":{"syscall":"ptrace"}}`)}},
+			{Name: "heuristic", Type: "file", Trust: 0.25, Options: Options{"path": writeLines(t, `{"Type":"syscall","Data":{"syscall":"mount"}}`)}},
+		},
+		Parser:  StageSpec{Type: "jsonl"},
+		Route:   StageSpec{Type: "static", Options: Options{"baseline": "web"}},
+		Process: []StageSpec{{Type: "detect"}},
+	}
+	p, err := Build(spec, &Env{Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	confidence := make(map[string]float64)
+	p.Sinks = append(p.Sinks, SinkFunc(func(_ context.Context, r *Record) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, a := range r.Anomalies {
+			confidence[r.Source+" "+a.Evidence] = a.Confidence
+		}
+		return nil
+	}))
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	full := confidence["ebpf syscall:ptrace"]
+	if full == 0 || len(confidence) != 2 {
+		t.Fatalf("expected an anomaly from each source, got %v", confidence)
+	}
+	if got := confidence["heuristic syscall:mount"]; math.Abs(got-full/4) > 1e-9 {
+		t.Errorf("heuristic anomaly confidence %v, want a quarter of %v", got, full)
+	}
+
+	for _, bad := range []Spec{
+		{Name: "both", Source: StageSpec{Type: "lsm"}, Sources: []SourceSpec{{Type: "file"}}},
+		{Name: "duplicate", Sources: []SourceSpec{{Type: "file"}, {Type: "file"}}},
+		{Name: "trust", Sources: []SourceSpec{{Type: "lsm", Trust: 1.5}}},
+		{Name: "unknown", Sources: []SourceSpec{{Type: "carrier-pigeon"}}},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("expected pipeline %s to be rejected", bad.Name)
+		}
+	}
+}
+
+func TestTLS(t *testing.T) {
+	store, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &Env{Store: store}
+	handshake := func(sec int, fingerprint, sni string) string {
+		return fmt.Sprintf(`{"Type":"network","Timestamp":"2026-01-01T00:00:%02dZ","ProcessName":"app","PID":7,"Data":{"destination":"10.0.0.9:443","sni":%q,%s}}`, sec, sni, fingerprint)
+	}
+	spec := Spec{
+		Name:    "learn",
+		Source:  StageSpec{Type: "file", Options: Options{"path": writeLines(t, handshake(0, `"ja4":"t13d1516h2_8daaf6152771_e5627efa2ab1"`, "api.example.com"))}},
+		Parser:  StageSpec{Type: "jsonl"},
+		Route:   StageSpec{Type: "static", Options: Options{"baseline": "web"}},
+		Process: []StageSpec{{Type: "learn"}},
+	}
+	p, err := Build(spec, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	spec.Name = "tls"
+	spec.Source.Options = Options{"path": writeLines(t,
+		handshake(10, `"ja4":"t13d1516h2_8daaf6152771_e5627efa2ab1"`, "api.example.com"),
+		handshake(11, `"ja3":"771,4865-4866,0-23,29-23,0"`, "c2.example.net"),
+		handshake(12, `"ja3":"771,4865-4866,0-23,29-23,0"`, "c2.example.net"))}
+	spec.Process = []StageSpec{{Type: "tls"}}
+	spec.Sinks = []StageSpec{{Type: "history"}}
+	if p, err = Build(spec, env); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	records, err := store.History("web", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Anomaly.Type != "New TLS Fingerprint" || !strings.HasPrefix(records[0].Anomaly.Evidence, "tls:app:ja3:") {
+		t.Errorf("expected the new fingerprint reported once, got %+v", records)
+	}
+}
+
+func TestColdStart(t *testing.T) {
+	store, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &Env{Store: store, Clock: clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))}
+	var lines []string
+	for i := 0; i < 4; i++ {
+		lines = append(lines, fmt.Sprintf(`{"Type":"network","Timestamp":"2026-01-01T00:%02d:10Z","Data":{"destination":"10.0.0.9:443"}}`, i))
+	}
+	learn := Spec{
+		Name:    "learn",
+		Source:  StageSpec{Type: "file", Options: Options{"path": writeLines(t, lines...)}},
+		Parser:  StageSpec{Type: "jsonl"},
+		Route:   StageSpec{Type: "static", Options: Options{"baseline": "web"}},
+		Process: []StageSpec{{Type: "learn", Options: Options{"cold_start": "on"}}},
+	}
+	p, err := Build(learn, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := store.LoadBaseline("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Learned() != 4*time.Minute || b.ColdStartStatus() != "cold start, 4m of 24h learned" {
+		t.Fatalf("got learned %s, status %q", b.Learned(), b.ColdStartStatus())
+	}
+
+	detect := learn
+	detect.Name = "detect"
+	detect.Source.Options = Options{"path": writeLines(t, `{"Type":"network","Timestamp":"2026-01-01T00:05:00Z","Data":{"destination":"203.0.113.7:4444"}}`)}
+	detect.Process = []StageSpec{{Type: "detect"}}
+	detect.Sinks = []StageSpec{{Type: "history"}}
+	if p, err = Build(detect, env); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	records, err := store.History("web", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Anomaly == nil || !records[0].Anomaly.ColdStart {
+		t.Fatalf("expected one anomaly marked as cold start, got %+v", records)
+	}
+
+	for _, value := range []string{"-1h", "soon"} {
+		learn.Process[0].Options["cold_start"] = value
+		if _, err := Build(learn, env); err == nil {
+			t.Errorf("expected cold_start %q to be rejected", value)
+		}
+	}
+}
+
+func TestSessionRoute(t *testing.T) {
+	dir := t.TempDir()
+	sessions := &ocihook.Sessions{Dir: dir}
+	if err := sessions.Start(ocihook.Session{ContainerID: "abc123", PID: 4242, Baseline: "payments"}); err != nil {
+		t.Fatal(err)
+	}
+	router, err := Routers.New("session", &Env{}, Options{"dir": dir, "reload": "0s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	route := func(e detect.SystemEvent) string {
+		t.Helper()
+		r := &Record{Event: &e}
+		keep, err := router.Process(context.Background(), r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !keep {
+			return "dropped"
+		}
+		return r.Baseline
+	}
+
+	if got := route(detect.SystemEvent{Type: "resource", Labels: map[string]string{"container_id": "abc123"}}); got != "payments" {
+		t.Errorf("cgroup event routed to %s, want payments", got)
+	}
+	if got := route(detect.SystemEvent{Type: "process", Data: map[string]interface{}{"container_id": "abc123"}}); got != "payments" {
+		t.Errorf("containerd event routed to %s, want payments", got)
+	}
+	// A process the container's init started, seen exec'ing.
+	route(detect.SystemEvent{Type: "process", PID: 4300, Path: "/bin/sh", Data: map[string]interface{}{"action": "exec", "ppid": 4242}})
+	if got := route(detect.SystemEvent{Type: "network", PID: 4300, Data: map[string]interface{}{"destination": "10.0.0.9:443"}}); got != "payments" {
+		t.Errorf("event of a container process routed to %s, want payments", got)
+	}
+	if got := route(detect.SystemEvent{Type: "resource", Labels: map[string]string{"container_id": "other"}}); got != "dropped" {
+		t.Errorf("event of no session routed to %s, want it dropped", got)
+	}
+
+	// Sessions the hook stops are picked up on reload.
+	if err := sessions.Stop("abc123"); err != nil {
+		t.Fatal(err)
+	}
+	if got := route(detect.SystemEvent{Type: "resource", Labels: map[string]string{"container_id": "abc123"}}); got != "dropped" {
+		t.Errorf("event of a stopped session routed to %s, want it dropped", got)
+	}
+	if router, err = Routers.New("session", &Env{}, Options{"dir": dir, "default": "host"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := route(detect.SystemEvent{Type: "resource"}); got != "host" {
+		t.Errorf("event of no session routed to %s, want the default", got)
+	}
+}
+
+func TestGovern(t *testing.T) {
+	g := budget.New(0.5, 0, metrics.NewRegistry(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	stage := Govern(g, "host")
+	read := detect.SystemEvent{Type: "syscall", Data: map[string]interface{}{"syscall": "read"}}
+	exec := detect.SystemEvent{Type: "process", Path: "/bin/sh", Data: map[string]interface{}{"action": "exec"}}
+	feed := func(event detect.SystemEvent, n int) (kept, weight int) {
+		for i := 0; i < n; i++ {
+			e := event
+			r := &Record{Source: "lsm", Event: &e}
+			ok, err := stage.Process(context.Background(), r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok {
+				kept++
+				weight += r.SampleRate
+			}
+		}
+		return kept, weight
+	}
+	feed(read, 1000)
+	feed(exec, 10)
+	g.Adjust(2, 0, time.Second) // 4 times the CPU budget
+
+	// Collector events are sampled by stream: execs are never shed.
+	if kept, weight := feed(read, 1000); kept >= 1000 || weight < 990 || weight > 1010 {
+		t.Errorf("kept %d reads weighing %d, want them sampled and weighing about 1000", kept, weight)
+	}
+	if kept, _ := feed(exec, 10); kept != 10 {
+		t.Errorf("kept %d of 10 execs, want all of them", kept)
+	}
+}
@@ -0,0 +1,159 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Queue policies: what Push does when the queue is full.
+const (
+	// QueueBlock waits for room, slowing the source down to the pace of
+	// the stages and sinks.
+	QueueBlock = "block"
+	// QueueDropNewest drops the record being pushed.
+	QueueDropNewest = "drop-newest"
+	// QueueDropOldest drops the oldest queued record to make room.
+	QueueDropOldest = "drop-oldest"
+)
+
+// DefaultQueueSize is the number of records a pipeline queues between its
+// source and its stages by default.
+const DefaultQueueSize = 1024
+
+// QueueSpec configures the queue between a pipeline's source and its
+// stages. The zero value queues DefaultQueueSize records and blocks the
+// source when they are all waiting.
+type QueueSpec struct {
+	Size   int    `yaml:"size"`
+	Policy string `yaml:"policy"` // block (default), drop-newest or drop-oldest
+}
+
+// Validate checks the size and policy.
+func (s QueueSpec) Validate() error {
+	if s.Size < 0 {
+		return fmt.Errorf("queue size must not be negative, got %d", s.Size)
+	}
+	switch s.Policy {
+	case "", QueueBlock, QueueDropNewest, QueueDropOldest:
+		return nil
+	}
+	return fmt.Errorf("unknown queue policy %q: want %s, %s or %s", s.Policy, QueueBlock, QueueDropNewest, QueueDropOldest)
+}
+
+// Queue is a bounded ring buffer of records between a source and the
+// stages, so a slow stage or sink cannot stall collection or grow memory
+// without bound when its policy drops records. A queue has one goroutine
+// pushing and one popping.
+type Queue struct {
+	policy string
+
+	mu     sync.Mutex
+	ring   []*Record
+	head   int // index of the oldest record
+	n      int
+	closed bool
+	ready  chan struct{} // signalled when a record is pushed or the queue closed
+	room   chan struct{} // signalled when a record is popped
+}
+
+// NewQueue returns an empty queue of the spec's size and policy.
+func NewQueue(spec QueueSpec) (*Queue, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	size := spec.Size
+	if size == 0 {
+		size = DefaultQueueSize
+	}
+	policy := spec.Policy
+	if policy == "" {
+		policy = QueueBlock
+	}
+	return &Queue{
+		policy: policy,
+		ring:   make([]*Record, size),
+		ready:  make(chan struct{}, 1),
+		room:   make(chan struct{}, 1),
+	}, nil
+}
+
+// Push queues r and returns the record the policy dropped to do so, if
+// any: r itself, the oldest queued record, or, under QueueBlock, r when
+// ctx is done before there is room.
+func (q *Queue) Push(ctx context.Context, r *Record) (dropped *Record) {
+	for {
+		q.mu.Lock()
+		if q.n == len(q.ring) {
+			switch q.policy {
+			case QueueDropNewest:
+				q.mu.Unlock()
+				return r
+			case QueueDropOldest:
+				dropped = q.ring[q.head]
+				q.ring[q.head] = nil
+				q.head = (q.head + 1) % len(q.ring)
+				q.n--
+			}
+		}
+		if q.n < len(q.ring) {
+			q.ring[(q.head+q.n)%len(q.ring)] = r
+			q.n++
+			q.mu.Unlock()
+			signal(q.ready)
+			return dropped
+		}
+		q.mu.Unlock()
+		select {
+		case <-q.room:
+		case <-ctx.Done():
+			return r
+		}
+	}
+}
+
+// Pop removes and returns the oldest record, waiting for one, or returns
+// nil once the queue is closed and empty.
+func (q *Queue) Pop() *Record {
+	for {
+		q.mu.Lock()
+		if q.n > 0 {
+			r := q.ring[q.head]
+			q.ring[q.head] = nil
+			q.head = (q.head + 1) % len(q.ring)
+			q.n--
+			q.mu.Unlock()
+			signal(q.room)
+			return r
+		}
+		closed := q.closed
+		q.mu.Unlock()
+		if closed {
+			return nil
+		}
+		<-q.ready
+	}
+}
+
+// Len returns the number of queued records.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.n
+}
+
+// Close ends the queue: Pop returns the records still queued, then nil.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	signal(q.ready)
+}
+
+// signal wakes a waiter on c, if there is none waiting already.
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,187 @@
+package pipeline
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/budget"
+	"github.com/hallucinaut/runtimebase/pkg/clock"
+	"github.com/hallucinaut/runtimebase/pkg/enrich"
+	"github.com/hallucinaut/runtimebase/pkg/metrics"
+	"github.com/hallucinaut/runtimebase/pkg/storage"
+)
+
+// Env holds the shared services stage factories may use.
+type Env struct {
+	Store    *storage.Store
+	Enricher *enrich.Enricher // nil when enrichment is not configured
+	// Remediation is applied to the anomalies processors raise.
+	Remediation baseline.Remediations
+	// Suppressions drop the anomalies of accepted behavior before
+	// remediation is applied.
+	Suppressions baseline.Suppressions
+	// Metrics, when set, receives the metrics of detection rules; see
+	// CountRules.
+	Metrics *metrics.Registry
+	// Budget, when set, sheds events to keep the process within its
+	// resource budget; see Govern.
+	Budget *budget.Governor
+	Clock  clock.Clock
+	Logger *slog.Logger
+}
+
+func (e *Env) now() time.Time {
+	if e.Clock == nil {
+		return time.Now()
+	}
+	return e.Clock.Now()
+}
+
+// Options are a stage's settings from its configuration.
+type Options map[string]string
+
+// String returns the option key, or def when it is unset.
+func (o Options) String(key, def string) string {
+	if v, ok := o[key]; ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// Required returns the option key, or an error when it is unset.
+func (o Options) Required(key string) (string, error) {
+	v := o[key]
+	if v == "" {
+		return "", fmt.Errorf("option %q is required", key)
+	}
+	return v, nil
+}
+
+// Duration parses the option key as a duration, or returns def when it is
+// unset.
+func (o Options) Duration(key string, def time.Duration) (time.Duration, error) {
+	v := o[key]
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("option %q: %w", key, err)
+	}
+	return d, nil
+}
+
+// Location loads the option key as a time zone name, such as
+// "Europe/Berlin" or "UTC", or returns nil when it is unset.
+func (o Options) Location(key string) (*time.Location, error) {
+	v := o[key]
+	if v == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(v)
+	if err != nil {
+		return nil, fmt.Errorf("option %q: %w", key, err)
+	}
+	return loc, nil
+}
+
+// Factory creates a stage from its options.
+type Factory[T any] func(env *Env, opts Options) (T, error)
+
+// Registry maps stage names to factories for one kind of stage.
+type Registry[T any] struct {
+	kind      string
+	mu        sync.RWMutex
+	factories map[string]Factory[T]
+	checks    map[string]func(Options) error
+}
+
+func newRegistry[T any](kind string) *Registry[T] {
+	return &Registry[T]{kind: kind, factories: make(map[string]Factory[T]), checks: make(map[string]func(Options) error)}
+}
+
+// Registries for each kind of stage. The built-in stages are registered in
+// builtin.go.
+var (
+	Sources     = newRegistry[Source]("source")
+	Parsers     = newRegistry[Stage]("parser")
+	Normalizers = newRegistry[Stage]("normalizer")
+	Enrichers   = newRegistry[Stage]("enricher")
+	Routers     = newRegistry[Stage]("router")
+	Processors  = newRegistry[Stage]("processor")
+	Sinks       = newRegistry[Sink]("sink")
+)
+
+// Register makes a stage available under name, replacing any stage already
+// registered with it.
+func (r *Registry[T]) Register(name string, f Factory[T]) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.factories[name] = f
+}
+
+// RegisterCheck sets how the options of the stage registered under name
+// are validated when a configuration is loaded, before the stage is
+// created. Checks must not have side effects such as opening files for
+// writing or connecting to services.
+func (r *Registry[T]) RegisterCheck(name string, check func(Options) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks[name] = check
+}
+
+// Check validates the options of the stage registered under name with its
+// check, if it has one.
+func (r *Registry[T]) Check(name string, opts Options) error {
+	r.mu.RLock()
+	check := r.checks[name]
+	r.mu.RUnlock()
+	if check == nil {
+		return nil
+	}
+	if err := check(opts); err != nil {
+		return fmt.Errorf("%s %s: %w", r.kind, name, err)
+	}
+	return nil
+}
+
+// Names returns the registered names, sorted.
+func (r *Registry[T]) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Has reports whether name is registered.
+func (r *Registry[T]) Has(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.factories[name]
+	return ok
+}
+
+// New creates the stage registered under name.
+func (r *Registry[T]) New(name string, env *Env, opts Options) (T, error) {
+	r.mu.RLock()
+	f, ok := r.factories[name]
+	r.mu.RUnlock()
+	if !ok {
+		var zero T
+		return zero, fmt.Errorf("unknown %s %q: want one of %s", r.kind, name, strings.Join(r.Names(), ", "))
+	}
+	stage, err := f(env, opts)
+	if err != nil {
+		return stage, fmt.Errorf("%s %s: %w", r.kind, name, err)
+	}
+	return stage, nil
+}
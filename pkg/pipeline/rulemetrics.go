@@ -0,0 +1,87 @@
+package pipeline
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/metrics"
+)
+
+// Metrics of detection rules, by the rule (anomaly type) and the category
+// of the evidence. A rule's average confidence is its confidence sum over
+// its fired count, and its average latency its evaluation seconds over its
+// evaluations; only correlation rules report the latter.
+const (
+	MetricRuleFired       = "runtimebase_rule_fired_total"
+	MetricRuleSuppressed  = "runtimebase_rule_suppressed_total"
+	MetricRuleConfidence  = "runtimebase_rule_confidence_sum"
+	MetricRuleEvaluations = "runtimebase_rule_evaluations_total"
+	MetricRuleSeconds     = "runtimebase_rule_evaluation_seconds_total"
+)
+
+// DescribeRuleMetrics sets the help texts of the rule metrics.
+func DescribeRuleMetrics(reg *metrics.Registry) {
+	reg.Describe(MetricRuleFired, "Anomalies raised, by rule and category, before suppression.")
+	reg.Describe(MetricRuleSuppressed, "Anomalies dropped by suppressions, by rule and category.")
+	reg.Describe(MetricRuleConfidence, "Sum of the confidence of the anomalies raised, by rule and category.")
+	reg.Describe(MetricRuleEvaluations, "Events correlation rules were evaluated against, by rule.")
+	reg.Describe(MetricRuleSeconds, "Time correlation rules spent evaluating events, by rule.")
+}
+
+// ruleLabels returns the metric labels of the rule that raised a.
+func ruleLabels(a baseline.Anomaly) []string {
+	behavior, _ := a.Behavior()
+	return []string{"rule", a.Type, "category", behavior}
+}
+
+// CountRules returns a stage counting the anomalies the processors raised,
+// and their confidence, by rule and category.
+func CountRules(reg *metrics.Registry) Stage {
+	return StageFunc(func(_ context.Context, r *Record) (bool, error) {
+		for _, a := range r.Anomalies {
+			labels := ruleLabels(a)
+			reg.Add(MetricRuleFired, 1, labels...)
+			reg.Add(MetricRuleConfidence, a.Confidence, labels...)
+		}
+		return true, nil
+	})
+}
+
+// timeRules returns the timing hook of correlation engines recording rule
+// latency in reg.
+func timeRules(reg *metrics.Registry) func(string, time.Duration) {
+	return func(rule string, elapsed time.Duration) {
+		reg.Add(MetricRuleEvaluations, 1, "rule", rule)
+		reg.Add(MetricRuleSeconds, elapsed.Seconds(), "rule", rule)
+	}
+}
+
+// countSuppressed is Suppress counting what each rule had suppressed.
+func countSuppressed(rules baseline.Suppressions, reg *metrics.Registry, logger *slog.Logger) Stage {
+	return StageFunc(func(_ context.Context, r *Record) (bool, error) {
+		kept := r.Anomalies[:0:0]
+		for _, a := range r.Anomalies {
+			if !suppressed(rules, a) {
+				kept = append(kept, a)
+				continue
+			}
+			reg.Add(MetricRuleSuppressed, 1, ruleLabels(a)...)
+		}
+		if n := len(r.Anomalies) - len(kept); n > 0 && logger != nil {
+			logger.Debug("suppressed anomalies", "count", n, "baseline", r.Baseline)
+		}
+		r.Anomalies = kept
+		return true, nil
+	})
+}
+
+func suppressed(rules baseline.Suppressions, a baseline.Anomaly) bool {
+	for _, s := range rules {
+		if s.Matches(a) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package pipeline
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/ocihook"
+	"github.com/hallucinaut/runtimebase/pkg/proctree"
+)
+
+// sessionRouter routes records to the baselines of the container sessions
+// the OCI hook records.
+type sessionRouter struct {
+	sessions *ocihook.Sessions
+	tree     *proctree.Tree
+	reload   time.Duration
+	def      string
+	logger   *slog.Logger
+
+	mu         sync.Mutex
+	loadedAt   time.Time
+	containers map[string]string // container ID → baseline
+	pids       map[int]string    // container init PID → baseline
+}
+
+// SessionRoute returns a router sending each record to the baseline of the
+// container session (see ocihook.Sessions) its event belongs to: the
+// session of the event's "container_id" label or field, as cgroup and
+// containerd events carry, or else the session whose container init
+// process is the event's process or one of its ancestors, followed in
+// tree. Events of no session go to def, or are dropped if def is empty.
+// Sessions are reread every reload, so containers the hook starts and
+// stops are picked up while the pipeline runs.
+func SessionRoute(sessions *ocihook.Sessions, tree *proctree.Tree, reload time.Duration, def string, logger *slog.Logger) Stage {
+	return &sessionRouter{sessions: sessions, tree: tree, reload: reload, def: def, logger: logger}
+}
+
+// Process implements Stage.
+func (s *sessionRouter) Process(_ context.Context, r *Record) (bool, error) {
+	s.tree.Observe(*r.Event)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.load()
+	r.Baseline = s.def
+	id := r.Event.Labels["container_id"]
+	if id == "" {
+		id, _ = r.Event.Data["container_id"].(string)
+	}
+	if name, ok := s.containers[id]; ok {
+		r.Baseline = name
+	} else if r.Event.PID > 0 && len(s.pids) > 0 {
+		for _, p := range s.tree.Lineage(r.Event.PID) {
+			if name, ok := s.pids[p.PID]; ok {
+				r.Baseline = name
+				break
+			}
+		}
+	}
+	return r.Baseline != "", nil
+}
+
+// load rereads the sessions when they are stale, and forgets processes
+// long exited. Callers hold s.mu.
+func (s *sessionRouter) load() {
+	if s.containers != nil && time.Since(s.loadedAt) < s.reload {
+		return
+	}
+	s.loadedAt = time.Now()
+	s.tree.Prune(s.loadedAt)
+	sessions, err := s.sessions.List()
+	if err != nil {
+		s.logger.Warn("reading container sessions failed", "dir", s.sessions.Dir, "error", err)
+		if s.containers != nil {
+			return
+		}
+	}
+	s.containers = make(map[string]string, len(sessions))
+	s.pids = make(map[int]string, len(sessions))
+	for _, session := range sessions {
+		s.containers[session.ContainerID] = session.Baseline
+		if session.PID > 0 {
+			s.pids[session.PID] = session.Baseline
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/budget"
+	"github.com/hallucinaut/runtimebase/pkg/collect"
+	"github.com/hallucinaut/runtimebase/pkg/storage"
+)
+
+// StageSpec names a registered stage and its options.
+type StageSpec struct {
+	Type    string  `yaml:"type"`
+	Options Options `yaml:"options"`
+}
+
+// Spec describes a pipeline in configuration, e.g.
+//
+//	name: web
+//	source: {type: file, options: {path: /var/log/nginx/access.log}}
+//	parser: {type: accesslog}
+//	normalize: [{type: defaults}]
+//	enrich: [{type: reputation}]
+//	route: {type: static, options: {baseline: web}}
+//	process: [{type: detect}]
+//	sinks: [{type: history}]
+//
+// Parser and Route may be omitted when the source produces events and
+// they all belong to the same baseline respectively; records that reach
+// the process stage without a baseline are dropped. Sources, instead of
+// Source, reads several sources at once, each trusted to its own degree:
+//
+//	sources:
+//	  - {type: lsm, name: ebpf}
+//	  - {type: file, name: heuristic, trust: 0.3, options: {path: /var/log/guess.jsonl}}
+//
+// Namespace places the
+// baselines the pipeline routes to in a tenant namespace. Queue bounds the
+// records waiting between the source and the stages and says what happens
+// when it is full.
+type Spec struct {
+	Name      string       `yaml:"name"`
+	Namespace string       `yaml:"namespace"`
+	Source    StageSpec    `yaml:"source"`
+	Sources   []SourceSpec `yaml:"sources"`
+	Parser    StageSpec    `yaml:"parser"`
+	Normalize []StageSpec  `yaml:"normalize"`
+	Enrich    []StageSpec  `yaml:"enrich"`
+	Route     StageSpec    `yaml:"route"`
+	Process   []StageSpec  `yaml:"process"`
+	Sinks     []StageSpec  `yaml:"sinks"`
+	Queue     QueueSpec    `yaml:"queue"`
+}
+
+// SourceSpec is one of the sources of a pipeline reading several. Name,
+// by default the type, tells its records apart (see Multiplexed); Trust,
+// from 0 to 1 and 1 when unset, weighs the confidence of the anomalies
+// they raise (see Trust).
+type SourceSpec struct {
+	Name    string  `yaml:"name"`
+	Type    string  `yaml:"type"`
+	Options Options `yaml:"options"`
+	Trust   float64 `yaml:"trust"`
+}
+
+func (s SourceSpec) name() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.Type
+}
+
+// Validate checks that every stage the spec names is registered and that
+// the options of stages with a check, such as webhook templates, are valid.
+func (s Spec) Validate() error {
+	switch {
+	case s.Source.Type == "" && len(s.Sources) == 0:
+		return fmt.Errorf("pipeline %s: source is required", s.Name)
+	case s.Source.Type != "" && len(s.Sources) > 0:
+		return fmt.Errorf("pipeline %s: source and sources are exclusive", s.Name)
+	}
+	names := make(map[string]bool, len(s.Sources))
+	for _, source := range s.Sources {
+		if source.Type == "" {
+			return fmt.Errorf("pipeline %s: sources: type is required", s.Name)
+		}
+		if names[source.name()] {
+			return fmt.Errorf("pipeline %s: sources: duplicate name %q", s.Name, source.name())
+		}
+		names[source.name()] = true
+		if source.Trust < 0 || source.Trust > 1 {
+			return fmt.Errorf("pipeline %s: source %s: trust: want 0 to 1, got %g", s.Name, source.name(), source.Trust)
+		}
+	}
+	if err := s.Queue.Validate(); err != nil {
+		return fmt.Errorf("pipeline %s: %w", s.Name, err)
+	}
+	checks := []struct {
+		has   func(string) bool
+		check func(string, Options) error
+		kind  string
+		specs []StageSpec
+	}{
+		{Sources.Has, Sources.Check, "source", s.sourceStages()},
+		{Parsers.Has, Parsers.Check, "parser", optional(s.Parser)},
+		{Normalizers.Has, Normalizers.Check, "normalizer", s.Normalize},
+		{Enrichers.Has, Enrichers.Check, "enricher", s.Enrich},
+		{Routers.Has, Routers.Check, "router", optional(s.Route)},
+		{Processors.Has, Processors.Check, "processor", s.Process},
+		{Sinks.Has, Sinks.Check, "sink", s.Sinks},
+	}
+	for _, c := range checks {
+		for _, spec := range c.specs {
+			if !c.has(spec.Type) {
+				return fmt.Errorf("pipeline %s: unknown %s %q", s.Name, c.kind, spec.Type)
+			}
+			if err := c.check(spec.Type, spec.Options); err != nil {
+				return fmt.Errorf("pipeline %s: %w", s.Name, err)
+			}
+		}
+	}
+	for _, spec := range s.Sinks {
+		if _, _, err := digestOptions(spec.Options); err != nil {
+			return fmt.Errorf("pipeline %s: sink %s: %w", s.Name, spec.Type, err)
+		}
+	}
+	return nil
+}
+
+// sourceStages returns the specs of the pipeline's sources.
+func (s Spec) sourceStages() []StageSpec {
+	if len(s.Sources) == 0 {
+		return []StageSpec{s.Source}
+	}
+	specs := make([]StageSpec, len(s.Sources))
+	for i, source := range s.Sources {
+		specs[i] = StageSpec{Type: source.Type, Options: source.Options}
+	}
+	return specs
+}
+
+func optional(spec StageSpec) []StageSpec {
+	if spec.Type == "" {
+		return nil
+	}
+	return []StageSpec{spec}
+}
+
+// Build creates the pipeline a spec describes.
+func Build(s Spec, env *Env) (*Pipeline, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	p := &Pipeline{Name: s.Name, Queue: s.Queue, Metrics: env.Metrics, Logger: env.Logger}
+	if env.Metrics != nil {
+		env.Metrics.Describe(MetricQueueDropped, "Records dropped because the pipeline's queue was full, by pipeline.")
+	}
+	wrap := func(err error) error { return fmt.Errorf("pipeline %s: %w", s.Name, err) }
+
+	var err error
+	if s.Namespace != "" && env.Store != nil {
+		scoped := *env
+		if scoped.Store, err = env.Store.WithNamespace(s.Namespace); err != nil {
+			return nil, wrap(err)
+		}
+		env = &scoped
+	}
+	var trust map[string]float64
+	if len(s.Sources) == 0 {
+		if p.Source, err = Sources.New(s.Source.Type, env, s.Source.Options); err != nil {
+			return nil, wrap(err)
+		}
+	} else {
+		m := &Multiplexed{Logger: env.Logger}
+		for _, spec := range s.Sources {
+			source, err := Sources.New(spec.Type, env, spec.Options)
+			if err != nil {
+				return nil, wrap(fmt.Errorf("source %s: %w", spec.name(), err))
+			}
+			m.Sources = append(m.Sources, NamedSource{Name: spec.name(), Source: source})
+			if spec.Trust > 0 && spec.Trust < 1 {
+				if trust == nil {
+					trust = make(map[string]float64)
+				}
+				trust[spec.name()] = spec.Trust
+			}
+		}
+		p.Source = m
+	}
+	if env.Budget != nil {
+		p.Stages = append(p.Stages, Govern(env.Budget, s.Name))
+	}
+	stages := []struct {
+		registry *Registry[Stage]
+		specs    []StageSpec
+	}{
+		{Parsers, optional(s.Parser)},
+		{Normalizers, s.Normalize},
+		{Enrichers, s.Enrich},
+		{Routers, optional(s.Route)},
+		{Processors, s.Process},
+	}
+	for i, group := range stages {
+		if group.registry == Processors && len(group.specs) > 0 {
+			p.Stages = append(p.Stages, requireBaseline)
+		}
+		for _, spec := range group.specs {
+			stage, err := group.registry.New(spec.Type, env, spec.Options)
+			if err != nil {
+				return nil, wrap(err)
+			}
+			p.Stages = append(p.Stages, stage)
+		}
+		if i == 0 {
+			p.Stages = append(p.Stages, requireEvent)
+		}
+	}
+	if len(s.Process) > 0 && len(trust) > 0 {
+		p.Stages = append(p.Stages, Trust(trust))
+	}
+	if len(s.Process) > 0 && env.Store != nil {
+		p.Stages = append(p.Stages, ColdStart(env.Store, time.Minute, env.logger()), Metadata(env.Store, time.Minute, env.logger()),
+			Overrides(env.Store, time.Minute, env.now, env.logger()))
+	}
+	if len(s.Process) > 0 && env.Metrics != nil {
+		DescribeRuleMetrics(env.Metrics)
+		p.Stages = append(p.Stages, CountRules(env.Metrics))
+		if len(env.Suppressions) > 0 {
+			p.Stages = append(p.Stages, countSuppressed(env.Suppressions, env.Metrics, env.Logger))
+		}
+	} else if len(s.Process) > 0 && len(env.Suppressions) > 0 {
+		p.Stages = append(p.Stages, Suppress(env.Suppressions, env.Logger))
+	}
+	if len(s.Process) > 0 && len(env.Remediation) > 0 {
+		p.Stages = append(p.Stages, Remediate(env.Remediation))
+	}
+	for _, spec := range s.Sinks {
+		sink, err := Sinks.New(spec.Type, env, spec.Options)
+		if err != nil {
+			return nil, wrap(err)
+		}
+		if every, immediate, _ := digestOptions(spec.Options); every > 0 {
+			if sink, err = WithDigest(sink, every, immediate, env.Clock); err != nil {
+				return nil, wrap(fmt.Errorf("sink %s: %w", spec.Type, err))
+			}
+		}
+		p.Sinks = append(p.Sinks, sink)
+	}
+	return p, nil
+}
+
+// Remediate returns a stage attaching remediation to the record's
+// anomalies.
+func Remediate(rules baseline.Remediations) Stage {
+	return StageFunc(func(_ context.Context, r *Record) (bool, error) {
+		rules.Apply(r.Anomalies)
+		return true, nil
+	})
+}
+
+// Suppress returns a stage removing the record's anomalies that a
+// suppression matches. The record itself is kept.
+func Suppress(rules baseline.Suppressions, logger *slog.Logger) Stage {
+	return StageFunc(func(_ context.Context, r *Record) (bool, error) {
+		var n int
+		if r.Anomalies, n = rules.Filter(r.Anomalies); n > 0 && logger != nil {
+			logger.Debug("suppressed anomalies", "count", n, "baseline", r.Baseline)
+		}
+		return true, nil
+	})
+}
+
+// Metadata returns a stage adding the metadata of the record's baseline to
+// its anomalies (see baseline.ApplyMetadata), so suppressions, remediation
+// and sinks can tell whose they are. Baselines are reloaded every reload.
+func Metadata(store *storage.Store, reload time.Duration, logger *slog.Logger) Stage {
+	var mu sync.Mutex
+	baselines := newBaselineCache(store, reload, logger, "metadata")
+	return StageFunc(func(_ context.Context, r *Record) (bool, error) {
+		if len(r.Anomalies) == 0 {
+			return true, nil
+		}
+		mu.Lock()
+		b := baselines.get(r.Baseline)
+		mu.Unlock()
+		b.ApplyMetadata(r.Anomalies)
+		return true, nil
+	})
+}
+
+// ColdStart returns a stage dropping the rate anomalies of records whose
+// baseline is in cold start and marking the others (see
+// baseline.FilterColdStart). Baselines are reloaded every reload, so the
+// switch to full detection takes effect within it once they have learned
+// enough, and is logged.
+func ColdStart(store *storage.Store, reload time.Duration, logger *slog.Logger) Stage {
+	var mu sync.Mutex
+	baselines := newBaselineCache(store, reload, logger, "cold start")
+	cold := make(map[string]bool)
+	return StageFunc(func(_ context.Context, r *Record) (bool, error) {
+		if len(r.Anomalies) == 0 {
+			return true, nil
+		}
+		mu.Lock()
+		b := baselines.get(r.Baseline)
+		was, seen := cold[r.Baseline]
+		cold[r.Baseline] = b.InColdStart()
+		mu.Unlock()
+		if seen && was && !b.InColdStart() && logger != nil {
+			logger.Info("cold start over, detecting in full", "baseline", r.Baseline, "learned", b.Learned())
+		}
+		r.Anomalies, _ = b.FilterColdStart(r.Anomalies)
+		return true, nil
+	})
+}
+
+// Overrides returns a stage removing the record's anomalies that an
+// override of its baseline, active at now, covers (see
+// baseline.FilterOverrides). Baselines are reloaded every reload, so new
+// overrides take effect within it; expired ones stop applying at once.
+func Overrides(store *storage.Store, reload time.Duration, now func() time.Time, logger *slog.Logger) Stage {
+	var mu sync.Mutex
+	baselines := newBaselineCache(store, reload, logger, "overrides")
+	return StageFunc(func(_ context.Context, r *Record) (bool, error) {
+		if len(r.Anomalies) == 0 {
+			return true, nil
+		}
+		mu.Lock()
+		b := baselines.get(r.Baseline)
+		mu.Unlock()
+		var n int
+		if r.Anomalies, n = b.FilterOverrides(r.Anomalies, now()); n > 0 && logger != nil {
+			logger.Debug("overridden anomalies", "count", n, "baseline", r.Baseline)
+		}
+		return true, nil
+	})
+}
+
+// Govern returns a stage shedding the records of the named pipeline that
+// the governor does not admit, to keep the process within its resource
+// budget. It goes first, so shed records are not even parsed; records kept
+// while sampling stand for the ones shed, in their SampleRate. The events
+// of collector sources are sampled in streams by collect.SampleKey, so
+// execs and connects are kept in full while the busiest operations are
+// sampled; raw records in a stream per source. Synthetic records are
+// always admitted.
+func Govern(g *budget.Governor, name string) Stage {
+	return StageFunc(func(_ context.Context, r *Record) (bool, error) {
+		if r.Synthetic {
+			return true, nil
+		}
+		key := r.Source
+		if r.Event != nil {
+			key = collect.SampleKey(*r.Event)
+		}
+		weight, ok := g.Admit(name, key)
+		r.SampleRate = weight
+		return ok, nil
+	})
+}
+
+// requireEvent drops records the parse stage could not turn into events,
+// and weighs the events of sampled records.
+var requireEvent = StageFunc(func(_ context.Context, r *Record) (bool, error) {
+	if r.Event == nil {
+		return false, nil
+	}
+	if r.SampleRate > 1 {
+		r.Event.SampleRate = r.Event.Weight() * r.SampleRate
+	}
+	return true, nil
+})
+
+// requireBaseline drops records no router assigned a baseline.
+var requireBaseline = StageFunc(func(_ context.Context, r *Record) (bool, error) {
+	return r.Baseline != "", nil
+})
@@ -0,0 +1,293 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// DefaultSyslogSDID is the structured data ID of syslog messages: the SD
+// element carrying the anomaly's fields. 32473 is the private enterprise
+// number reserved for documentation; set sd_id to your own to be
+// strictly RFC 5424 compliant.
+const DefaultSyslogSDID = "runtimebase@32473"
+
+// syslogFacilities maps facility names to their codes.
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"ntp": 12, "security": 13, "console": 14, "solaris-cron": 15,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSeverities maps syslog severity names to their codes.
+var syslogSeverities = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3,
+	"warning": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// Syslog is a sink sending each anomaly as an RFC 5424 message to the
+// local syslog daemon or a remote collector. The anomaly's fields are
+// structured data under SDID and the message is its description.
+// Datagram transports send a message per datagram; stream transports
+// frame them by octet counting (RFC 6587, RFC 5425 for TLS).
+type Syslog struct {
+	// Network is "unixgram", "udp", "tcp" or "tls".
+	Network string
+	// Address is the socket path or host:port.
+	Address  string
+	Facility int // defaults to 0, kern; newSyslog defaults to local0
+	// Severities maps runtimebase severities to syslog severity codes;
+	// unmapped ones are mapped by rank, see SyslogSeverity.
+	Severities map[string]int
+	Hostname   string
+	AppName    string
+	SDID       string
+	Namespace  string
+	Timeout    time.Duration // defaults to 10s
+	TLSConfig  *tls.Config
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// SyslogSeverity maps a severity of the active taxonomy to a syslog
+// severity: critical to crit, high to err, medium to warning and anything
+// lower to notice.
+func SyslogSeverity(name string) int {
+	switch {
+	case severity.AtLeast(name, severity.Critical):
+		return syslogSeverities["crit"]
+	case severity.AtLeast(name, severity.High):
+		return syslogSeverities["err"]
+	case severity.AtLeast(name, severity.Medium):
+		return syslogSeverities["warning"]
+	}
+	return syslogSeverities["notice"]
+}
+
+// Write implements Sink.
+func (s *Syslog) Write(ctx context.Context, r *Record) error {
+	for _, anomaly := range r.Anomalies {
+		alert := Alert{Namespace: s.Namespace, Baseline: r.Baseline, Source: r.Source, Anomaly: anomaly}
+		if err := s.send(ctx, s.Format(alert)); err != nil {
+			return fmt.Errorf("syslog: %w", err)
+		}
+	}
+	return nil
+}
+
+// Format renders an alert as an RFC 5424 message, without framing.
+func (s *Syslog) Format(alert Alert) []byte {
+	a := alert.Anomaly
+	level, ok := s.Severities[a.Severity]
+	if !ok {
+		level = SyslogSeverity(a.Severity)
+	}
+	timestamp := a.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d anomaly [%s", s.Facility*8+level,
+		timestamp.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeader(s.Hostname, 255), syslogHeader(s.AppName, 48), os.Getpid(),
+		s.sdID())
+	param := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, " %s=\"%s\"", name, sdEscaper.Replace(value))
+		}
+	}
+	param("namespace", alert.Namespace)
+	param("baseline", alert.Baseline)
+	param("source", alert.Source)
+	param("type", a.Type)
+	param("severity", a.Severity)
+	param("evidence", a.Evidence)
+	param("confidence", strconv.FormatFloat(a.Confidence, 'f', 2, 64))
+	param("risk", a.RiskLevel)
+	if rem := a.Remediation; rem != nil {
+		param("runbook", rem.Runbook)
+		param("owner", rem.Owner)
+		param("action", rem.Action)
+	}
+	if a.Incident != nil {
+		param("incident", a.Incident.ID)
+	}
+	param("trace_id", a.TraceID)
+	param("span_id", a.SpanID)
+	// Metadata keys are valid parameter names, but may be too long.
+	keys := make([]string, 0, len(a.Metadata))
+	for key := range a.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if name := "label." + key; len(name) <= 32 {
+			param(name, a.Metadata[key])
+		}
+	}
+	b.WriteString("] ")
+	b.WriteString(a.Description)
+	return b.Bytes()
+}
+
+// sdEscaper escapes the characters RFC 5424 reserves in parameter values.
+var sdEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogHeader returns a header field: printable ASCII without spaces, at
+// most max long, or "-" when empty.
+func syslogHeader(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, s)
+	if s == "" {
+		return "-"
+	}
+	if len(s) > max {
+		s = s[:max]
+	}
+	return s
+}
+
+func (s *Syslog) sdID() string {
+	if s.SDID == "" {
+		return DefaultSyslogSDID
+	}
+	return s.SDID
+}
+
+// send writes one message, reconnecting once if the connection was lost.
+func (s *Syslog) send(ctx context.Context, msg []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Network == "tcp" || s.Network == "tls" {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if s.conn, err = s.dial(ctx); err != nil {
+				return err
+			}
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(s.timeout()))
+		if _, err = s.conn.Write(msg); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+func (s *Syslog) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: s.timeout()}
+	if s.Network == "tls" {
+		config := s.TLSConfig
+		if config == nil {
+			host, _, _ := net.SplitHostPort(s.Address)
+			config = &tls.Config{ServerName: host}
+		}
+		return (&tls.Dialer{NetDialer: dialer, Config: config}).DialContext(ctx, "tcp", s.Address)
+	}
+	return dialer.DialContext(ctx, s.Network, s.Address)
+}
+
+func (s *Syslog) timeout() time.Duration {
+	if s.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return s.Timeout
+}
+
+// Close closes the connection, if any.
+func (s *Syslog) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// ParseSyslogAddress parses a syslog destination of the form
+// unix:///dev/log, udp://host:514, tcp://host:601 or tls://host:6514.
+func ParseSyslogAddress(address string) (network, addr string, err error) {
+	scheme, rest, ok := strings.Cut(address, "://")
+	switch {
+	case !ok || rest == "":
+		return "", "", fmt.Errorf("syslog address %q: want unix:///path or udp://, tcp:// or tls://host:port", address)
+	case scheme == "unix":
+		return "unixgram", rest, nil
+	case scheme == "udp" || scheme == "tcp" || scheme == "tls":
+		if _, _, err := net.SplitHostPort(rest); err != nil {
+			return "", "", fmt.Errorf("syslog address %q: %w", address, err)
+		}
+		return scheme, rest, nil
+	}
+	return "", "", fmt.Errorf("syslog address %q: unsupported scheme %q", address, scheme)
+}
+
+// newSyslog creates a syslog sink from its options: address (default
+// unix:///dev/log), facility (default local0), app_name, hostname, sd_id,
+// timeout, and a "severity.<NAME>" option per runtimebase severity mapped
+// to a syslog severity such as crit or warning.
+func newSyslog(opts Options) (*Syslog, error) {
+	network, addr, err := ParseSyslogAddress(opts.String("address", "unix:///dev/log"))
+	if err != nil {
+		return nil, err
+	}
+	facility, ok := syslogFacilities[opts.String("facility", "local0")]
+	if !ok {
+		return nil, fmt.Errorf("option \"facility\": unknown facility %q", opts["facility"])
+	}
+	timeout, err := opts.Duration("timeout", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	hostname := opts["hostname"]
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+	s := &Syslog{
+		Network:    network,
+		Address:    addr,
+		Facility:   facility,
+		Severities: make(map[string]int),
+		Hostname:   hostname,
+		AppName:    opts.String("app_name", "runtimebase"),
+		SDID:       opts["sd_id"],
+		Timeout:    timeout,
+	}
+	if id := s.sdID(); !strings.Contains(id, "@") || strings.ContainsAny(id, ` ="]`) {
+		return nil, fmt.Errorf("option \"sd_id\": want name@enterprise-number, got %q", id)
+	}
+	for key, value := range opts {
+		if name, ok := strings.CutPrefix(key, "severity."); ok {
+			level, ok := syslogSeverities[value]
+			if !ok {
+				return nil, fmt.Errorf("option %q: unknown syslog severity %q", key, value)
+			}
+			s.Severities[name] = level
+		}
+	}
+	return s, nil
+}
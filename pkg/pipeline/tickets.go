@@ -0,0 +1,410 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/clock"
+	"github.com/hallucinaut/runtimebase/pkg/secrets"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// DefaultTicketCloseAfter is how long an incident must stay quiet before
+// its ticket is closed.
+const DefaultTicketCloseAfter = 24 * time.Hour
+
+// Tracker opens, comments on and closes issues in an issue tracker, such
+// as GitHub Issues or Jira.
+type Tracker interface {
+	// Open opens an issue and returns its ID in the tracker.
+	Open(ctx context.Context, issue Issue) (string, error)
+	Comment(ctx context.Context, id, body string) error
+	// Close closes the issue, commenting body on it first.
+	Close(ctx context.Context, id, body string) error
+}
+
+// Issue is the content of a ticket.
+type Issue struct {
+	Title  string
+	Body   string
+	Labels []string
+}
+
+// Ticket is an open issue a ticket sink keeps for an incident.
+type Ticket struct {
+	ID       string    // the tracker's ID, such as "42" or "OPS-17"
+	Opened   time.Time // when the ticket was opened
+	LastSeen time.Time // when the incident last recurred
+	Count    int       // anomalies of the incident since the ticket was opened
+	Severity string    // the highest severity reported
+}
+
+// Tickets is a sink opening a ticket per incident in a Tracker. Anomalies
+// of an incident that already has an open ticket update it instead, with a
+// comment when the incident's severity rises, and a ticket is closed once
+// its incident has not recurred for CloseAfter, checked as records arrive
+// and every minute the pipeline ticks (see Ticker). Anomalies are grouped
+// into incidents by TicketKey, so the cluster processor's incidents each
+// get one ticket. Open tickets are kept in State, when set, so that a
+// restart does not open duplicates.
+type Tickets struct {
+	Tracker    Tracker
+	Namespace  string
+	Severity   string        // the least severe anomaly opening a ticket
+	CloseAfter time.Duration // 0 never closes tickets
+	Labels     []string      // added to every ticket
+	State      string        // file keeping the open tickets
+	Clock      clock.Clock
+
+	mu     sync.Mutex
+	open   map[string]*Ticket // key → ticket
+	loaded bool
+}
+
+// TicketKey returns the key deduplicating the tickets of an anomaly: its
+// incident, when the cluster processor grouped it into one, or else its
+// type and evidence, within its namespace and baseline.
+func TicketKey(namespace, baselineName string, a baseline.Anomaly) string {
+	key := a.Type + " " + a.Evidence
+	if a.Incident != nil {
+		key = a.Incident.ID
+	}
+	return strings.Join([]string{namespace, baselineName, key}, "/")
+}
+
+// Write implements Sink.
+func (t *Tickets) Write(ctx context.Context, r *Record) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.load(); err != nil {
+		return err
+	}
+	now := t.now()
+	changed := false
+	var errs []error
+	for _, a := range r.Anomalies {
+		if !severity.AtLeast(a.Severity, t.Severity) {
+			continue
+		}
+		if r.Synthetic {
+			// Open one every time, untracked, so that alert tests show.
+			if _, err := t.Tracker.Open(ctx, t.issue(r.Baseline, a)); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		key := TicketKey(t.Namespace, r.Baseline, a)
+		ticket := t.open[key]
+		if ticket == nil {
+			id, err := t.Tracker.Open(ctx, t.issue(r.Baseline, a))
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			t.open[key] = &Ticket{ID: id, Opened: now, LastSeen: now, Count: 1, Severity: a.Severity}
+			changed = true
+			continue
+		}
+		ticket.LastSeen = now
+		ticket.Count++
+		changed = true
+		if severity.Score(a.Severity) > severity.Score(ticket.Severity) {
+			body := fmt.Sprintf("Severity rose from %s to %s (%d anomalies so far).\n\n%s", ticket.Severity, a.Severity, ticket.Count, issueBody(t.Namespace, r.Baseline, a))
+			if err := t.Tracker.Comment(ctx, ticket.ID, body); err != nil {
+				errs = append(errs, err)
+			}
+			ticket.Severity = a.Severity
+		}
+	}
+	closed, err := t.closeQuiet(ctx, now)
+	errs = append(errs, err)
+	if changed || closed {
+		errs = append(errs, t.save())
+	}
+	return errors.Join(errs...)
+}
+
+// ticketTick is how often a pipeline checks for tickets to close, so a
+// quiet incident's ticket is closed at most this late.
+const ticketTick = time.Minute
+
+// Flush implements Flusher, closing the tickets of incidents that have
+// gone quiet.
+func (t *Tickets) Flush(ctx context.Context) error {
+	return t.closeDue(ctx, t.now())
+}
+
+// TickEvery implements Ticker; tickets that never close need no ticks.
+func (t *Tickets) TickEvery() time.Duration {
+	if t.CloseAfter <= 0 {
+		return 0
+	}
+	return ticketTick
+}
+
+// Tick implements Ticker, closing the tickets of incidents that have gone
+// quiet by now, even when no records arrive.
+func (t *Tickets) Tick(ctx context.Context, now time.Time) ([]*Record, error) {
+	return nil, t.closeDue(ctx, now)
+}
+
+// closeDue loads the open tickets and closes those quiet at now.
+func (t *Tickets) closeDue(ctx context.Context, now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.load(); err != nil {
+		return err
+	}
+	closed, err := t.closeQuiet(ctx, now)
+	if closed {
+		err = errors.Join(err, t.save())
+	}
+	return err
+}
+
+// closeQuiet closes the tickets whose incidents have not recurred for
+// CloseAfter, reporting whether it closed any.
+func (t *Tickets) closeQuiet(ctx context.Context, now time.Time) (bool, error) {
+	if t.CloseAfter <= 0 {
+		return false, nil
+	}
+	closed := false
+	var errs []error
+	for key, ticket := range t.open {
+		if now.Sub(ticket.LastSeen) < t.CloseAfter {
+			continue
+		}
+		body := fmt.Sprintf("Closed by runtimebase: no recurrence since %s (%d anomalies from %s).",
+			ticket.LastSeen.UTC().Format(time.RFC3339), ticket.Count, ticket.Opened.UTC().Format(time.RFC3339))
+		if err := t.Tracker.Close(ctx, ticket.ID, body); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delete(t.open, key)
+		closed = true
+	}
+	return closed, errors.Join(errs...)
+}
+
+func (t *Tickets) issue(baselineName string, a baseline.Anomaly) Issue {
+	subject := a.Evidence
+	if a.Incident != nil && a.Incident.Count > 1 {
+		subject = fmt.Sprintf("incident of %d anomalies", a.Incident.Count)
+	}
+	labels := append([]string{"runtimebase", "severity:" + strings.ToLower(a.Severity)}, t.Labels...)
+	return Issue{
+		Title:  fmt.Sprintf("[runtimebase] %s %s on %s: %s", a.Severity, a.Type, qualify(t.Namespace, baselineName), subject),
+		Body:   issueBody(t.Namespace, baselineName, a),
+		Labels: labels,
+	}
+}
+
+// issueBody describes a in plain text, which renders as is in both
+// Markdown and Jira's wiki markup.
+func issueBody(namespace, baselineName string, a baseline.Anomaly) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n", a.Description)
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "- %s: %s\n", name, value)
+		}
+	}
+	field("Baseline", qualify(namespace, baselineName))
+	field("Severity", a.Severity)
+	field("Evidence", a.Evidence)
+	if a.Process != "" || a.PID != 0 {
+		field("Process", fmt.Sprintf("%s (pid %d)", a.Process, a.PID))
+	}
+	if !a.Timestamp.IsZero() {
+		field("Time", a.Timestamp.UTC().Format(time.RFC3339))
+	}
+	if inc := a.Incident; inc != nil {
+		field("Incident", inc.ID)
+		field("Processes", strings.Join(inc.Processes, ", "))
+		field("Destinations", strings.Join(inc.Destinations, ", "))
+	}
+	field("Trace", a.TraceID)
+	field("Capture", a.Capture)
+	if rem := a.Remediation; rem != nil {
+		field("Runbook", rem.Runbook)
+		field("Owner", rem.Owner)
+	}
+	return b.String()
+}
+
+func qualify(namespace, name string) string {
+	if namespace == "" {
+		return name
+	}
+	return namespace + "/" + name
+}
+
+func (t *Tickets) now() time.Time {
+	if t.Clock == nil {
+		return time.Now()
+	}
+	return t.Clock.Now()
+}
+
+// load reads the open tickets from State on first use.
+func (t *Tickets) load() error {
+	if t.loaded {
+		return nil
+	}
+	t.open = make(map[string]*Ticket)
+	if t.State != "" {
+		data, err := os.ReadFile(t.State)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("tickets: %w", err)
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &t.open); err != nil {
+				return fmt.Errorf("tickets: %s: %w", t.State, err)
+			}
+		}
+	}
+	t.loaded = true
+	return nil
+}
+
+// save writes the open tickets to State, replacing it atomically.
+func (t *Tickets) save() error {
+	if t.State == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(t.open, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(t.State), 0o700); err != nil {
+		return fmt.Errorf("tickets: %w", err)
+	}
+	tmp := t.State + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("tickets: %w", err)
+	}
+	if err := os.Rename(tmp, t.State); err != nil {
+		return fmt.Errorf("tickets: %w", err)
+	}
+	return nil
+}
+
+// defaultTrackerTimeout bounds the requests to issue trackers.
+const defaultTrackerTimeout = 10 * time.Second
+
+func httpClient(c *http.Client) *http.Client {
+	if c == nil {
+		return &http.Client{Timeout: defaultTrackerTimeout}
+	}
+	return c
+}
+
+// trackerClient sends the JSON requests of a tracker's REST API.
+type trackerClient struct {
+	name    string // for errors, e.g. "github"
+	base    string // URL the request paths are relative to
+	headers map[string]string
+	client  *http.Client
+}
+
+// do sends body, when not nil, as JSON and decodes the response into out,
+// when not nil.
+func (c *trackerClient) do(ctx context.Context, method, path string, body, out any) error {
+	var payload io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.name, err)
+		}
+		payload = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.base, "/")+path, payload)
+	if err != nil {
+		return fmt.Errorf("%s: %w", c.name, err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range c.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", c.name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s %s: %s %s", c.name, method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s: decoding %s %s: %w", c.name, method, path, err)
+	}
+	return nil
+}
+
+// ticketStateName matches the characters allowed in the default names of
+// ticket state files.
+var ticketStateName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// newTickets creates a ticket sink for tracker from the options shared by
+// the ticket sinks: severity, close_after, labels and state. Without a
+// state option, open tickets are kept in dir, when set, in a file named
+// after kind and target, such as github-acme-web.json.
+func newTickets(tracker Tracker, opts Options, dir, kind, target string) (*Tickets, error) {
+	t := &Tickets{
+		Tracker:  tracker,
+		Severity: opts.String("severity", severity.Label(severity.High)),
+		State:    opts["state"],
+	}
+	if !severity.Known(t.Severity) {
+		return nil, fmt.Errorf("option \"severity\": unknown severity %q", t.Severity)
+	}
+	var err error
+	if t.CloseAfter, err = opts.Duration("close_after", DefaultTicketCloseAfter); err != nil {
+		return nil, err
+	}
+	if s := opts["labels"]; s != "" {
+		t.Labels = strings.Split(s, ",")
+	}
+	if t.State == "" && dir != "" {
+		t.State = filepath.Join(dir, "tickets", ticketStateName.ReplaceAllString(kind+"-"+target, "-")+".json")
+	}
+	return t, nil
+}
+
+// trackerToken returns the token the secret reference in option key
+// names, only checking the reference unless resolve is set.
+func trackerToken(opts Options, key string, resolve bool) (string, error) {
+	ref, err := opts.Required(key)
+	if err != nil {
+		return "", err
+	}
+	if _, _, err := secrets.Parse(ref); err != nil {
+		return "", fmt.Errorf("option %q: %w", key, err)
+	}
+	if !resolve {
+		return "", nil
+	}
+	token, err := secrets.Resolve(context.Background(), ref)
+	if err != nil {
+		return "", fmt.Errorf("option %q: %w", key, err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
@@ -0,0 +1,275 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/secrets"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// Alert is what a webhook template is executed with: one anomaly and the
+// record it was raised on.
+type Alert struct {
+	Namespace string
+	Baseline  string
+	Source    string
+	Anomaly   baseline.Anomaly
+	Event     detect.SystemEvent
+}
+
+// TemplateFuncs are the functions available to webhook templates besides
+// the text/template built-ins:
+//
+//	json    encodes a value as JSON, e.g. "text": {{json .Anomaly.Description}}
+//	upper   upper-cases a string
+//	lower   lower-cases a string
+//	default returns its first argument when the second is empty
+var TemplateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"default": func(def, v any) any {
+		if v == nil || v == "" {
+			return def
+		}
+		return v
+	},
+}
+
+// ParseTemplate parses a webhook payload template and checks it by
+// executing it against a sample alert, so a misspelled field fails when
+// the configuration is loaded rather than on the first anomaly. When
+// contentType is JSON, the sample payload must also be valid JSON.
+func ParseTemplate(name, text, contentType string) (*template.Template, error) {
+	return parseTemplate(name, text, contentType, sampleAlert())
+}
+
+// ParseDigestTemplate is ParseTemplate for templates executed with a
+// Digest.
+func ParseDigestTemplate(name, text, contentType string) (*template.Template, error) {
+	return parseTemplate(name, text, contentType, sampleDigest())
+}
+
+func parseTemplate(name, text, contentType string, sample any) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(TemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, sample); err != nil {
+		return nil, err
+	}
+	if isJSON(contentType) && !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("template %s does not produce valid JSON: %s", name, buf.String())
+	}
+	return tmpl, nil
+}
+
+func sampleAlert() Alert {
+	now := time.Unix(0, 0).UTC()
+	return Alert{
+		Baseline: "sample",
+		Source:   "sample",
+		Anomaly: baseline.Anomaly{
+			Type:        "New Behavior",
+			Description: `sample "anomaly"`,
+			Severity:    severity.Label(severity.High),
+			Evidence:    "syscall:openat",
+			Category:    "syscall",
+			Timestamp:   now,
+			RiskLevel:   severity.Label(severity.High),
+		},
+		Event: detect.SystemEvent{Type: "syscall", Timestamp: now, Data: map[string]interface{}{}},
+	}
+}
+
+func sampleDigest() Digest {
+	alert := sampleAlert()
+	return Digest{
+		Start:      alert.Anomaly.Timestamp,
+		End:        alert.Anomaly.Timestamp.Add(time.Hour),
+		Count:      1,
+		Severities: map[string]int{alert.Anomaly.Severity: 1},
+		Baselines:  map[string]int{alert.Baseline: 1},
+		Alerts:     []Alert{alert},
+	}
+}
+
+func isJSON(contentType string) bool {
+	return strings.HasPrefix(contentType, "application/json") || strings.HasSuffix(strings.SplitN(contentType, ";", 2)[0], "+json")
+}
+
+// Webhook is a sink posting each anomaly to an HTTP endpoint. Without a
+// Template the payload is the Alert as JSON; with one, it is whatever the
+// template renders, such as a Teams card, a Jira issue or an Opsgenie alert.
+// Digests (see WithDigest) are posted the same way, rendered with
+// DigestTemplate.
+type Webhook struct {
+	URL            string
+	Method         string // defaults to POST
+	ContentType    string // defaults to application/json
+	Headers        map[string]string
+	Template       *template.Template
+	DigestTemplate *template.Template
+	Namespace      string
+	Client         *http.Client // defaults to one with a 10s timeout
+}
+
+// Write implements Sink.
+func (w *Webhook) Write(ctx context.Context, r *Record) error {
+	for _, anomaly := range r.Anomalies {
+		alert := Alert{Namespace: w.Namespace, Baseline: r.Baseline, Source: r.Source, Anomaly: anomaly}
+		if r.Event != nil {
+			alert.Event = *r.Event
+		}
+		if err := w.send(ctx, alert); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteDigest implements DigestWriter.
+func (w *Webhook) WriteDigest(ctx context.Context, d Digest) error {
+	d.Namespace = w.Namespace
+	for i := range d.Alerts {
+		d.Alerts[i].Namespace = w.Namespace
+	}
+	return w.post(ctx, w.DigestTemplate, d)
+}
+
+func (w *Webhook) send(ctx context.Context, alert Alert) error {
+	return w.post(ctx, w.Template, alert)
+}
+
+// post sends data rendered with tmpl, or as JSON without one.
+func (w *Webhook) post(ctx context.Context, tmpl *template.Template, data any) error {
+	var body bytes.Buffer
+	if tmpl != nil {
+		if err := tmpl.Execute(&body, data); err != nil {
+			return fmt.Errorf("webhook: %w", err)
+		}
+	} else if err := json.NewEncoder(&body).Encode(data); err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+
+	method := w.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(ctx, method, w.URL, &body)
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	contentType := w.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range w.Headers {
+		req.Header.Set(name, value)
+	}
+
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook: %s: %s %s", w.URL, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// webhookTemplate returns the payload template the options configure,
+// inline as key (such as "template") or in the file key+"_file", or nil
+// for none. parse parses and checks it.
+func webhookTemplate(opts Options, key string, parse func(name, text, contentType string) (*template.Template, error)) (*template.Template, error) {
+	text, file := opts[key], opts[key+"_file"]
+	if text != "" && file != "" {
+		return nil, fmt.Errorf("options %q and %q are mutually exclusive", key, key+"_file")
+	}
+	name := key
+	if file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		text, name = string(b), file
+	}
+	if text == "" {
+		return nil, nil
+	}
+	return parse(name, text, opts.String("content_type", "application/json"))
+}
+
+// newWebhook creates a webhook sink from its options: url (required),
+// method, content_type, timeout, template or template_file,
+// digest_template or digest_template_file, and a "header.<Name>" option
+// per request header, holding a secret reference to its value since
+// headers usually carry credentials. References are only checked unless
+// resolve is set.
+func newWebhook(opts Options, resolve bool) (*Webhook, error) {
+	url, err := opts.Required("url")
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := opts.Duration("timeout", 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := webhookTemplate(opts, "template", ParseTemplate)
+	if err != nil {
+		return nil, err
+	}
+	digestTmpl, err := webhookTemplate(opts, "digest_template", ParseDigestTemplate)
+	if err != nil {
+		return nil, err
+	}
+	w := &Webhook{
+		URL:            url,
+		Method:         strings.ToUpper(opts["method"]),
+		ContentType:    opts["content_type"],
+		Headers:        make(map[string]string),
+		Template:       tmpl,
+		DigestTemplate: digestTmpl,
+		Client:         &http.Client{Timeout: timeout},
+	}
+	for key, ref := range opts {
+		name, ok := strings.CutPrefix(key, "header.")
+		if !ok {
+			continue
+		}
+		if _, _, err := secrets.Parse(ref); err != nil {
+			return nil, fmt.Errorf("option %q: %w", key, err)
+		}
+		if !resolve {
+			continue
+		}
+		value, err := secrets.Resolve(context.Background(), ref)
+		if err != nil {
+			return nil, fmt.Errorf("option %q: %w", key, err)
+		}
+		w.Headers[name] = strings.TrimSpace(string(value))
+	}
+	return w, nil
+}
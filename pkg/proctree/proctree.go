@@ -0,0 +1,182 @@
+// Package proctree maintains a process tree from exec and exit events so
+// anomalies can carry the lineage of the process that caused them.
+package proctree
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+// maxDepth bounds lineage walks in case of PID reuse loops.
+const maxDepth = 64
+
+// Tree is a concurrency-safe cache of live and recently exited processes.
+type Tree struct {
+	// ExitTTL is how long exited processes are kept so their children's
+	// lineage stays complete.
+	ExitTTL time.Duration
+	// ProcRoot is used to fill in processes the tree has not seen exec
+	// events for. Empty disables the fallback.
+	ProcRoot string
+
+	mu    sync.Mutex
+	procs map[int]*entry
+}
+
+type entry struct {
+	baseline.Process
+	exitedAt time.Time
+}
+
+// New creates a tree that falls back to /proc for unknown processes.
+func New() *Tree {
+	return &Tree{
+		ExitTTL:  5 * time.Minute,
+		ProcRoot: "/proc",
+		procs:    make(map[int]*entry),
+	}
+}
+
+// Exec records a process start, replacing any previous process with the pid.
+func (t *Tree) Exec(pid, ppid int, exe, cmdline string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.procs[pid] = &entry{Process: baseline.Process{PID: pid, PPID: ppid, Exe: exe, Cmdline: cmdline}}
+}
+
+// Exit records a process exit.
+func (t *Tree) Exit(pid int, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if e, ok := t.procs[pid]; ok {
+		e.exitedAt = at
+	}
+}
+
+// Observe updates the tree from a system event. Process events whose action
+// is "exec" or "exit" (or "fork"/"clone") are used; other events are ignored.
+func (t *Tree) Observe(event detect.SystemEvent) {
+	if event.Type != "process" || event.PID == 0 {
+		return
+	}
+	action, _ := event.Data["action"].(string)
+	switch action {
+	case "exec", "fork", "clone", "spawn", "":
+		cmdline, _ := event.Data["cmdline"].(string)
+		exe := event.Path
+		if exe == "" {
+			exe = event.ProcessName
+		}
+		t.Exec(event.PID, intField(event.Data["ppid"]), exe, cmdline)
+	case "exit":
+		at := event.Timestamp
+		if at.IsZero() {
+			at = time.Now()
+		}
+		t.Exit(event.PID, at)
+	}
+}
+
+// Lineage returns the process and its ancestors, stopping at init or a
+// containerd shim.
+func (t *Tree) Lineage(pid int) []baseline.Process {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var chain []baseline.Process
+	seen := make(map[int]bool)
+	for pid > 0 && !seen[pid] && len(chain) < maxDepth {
+		seen[pid] = true
+		e, ok := t.procs[pid]
+		if !ok {
+			e = t.fromProc(pid)
+			if e == nil {
+				chain = append(chain, baseline.Process{PID: pid})
+				break
+			}
+			t.procs[pid] = e
+		}
+		chain = append(chain, e.Process)
+		if pid == 1 || strings.HasPrefix(filepath.Base(e.Exe), "containerd-shim") {
+			break
+		}
+		pid = e.PPID
+	}
+	return chain
+}
+
+// Annotate attaches lineage to every anomaly that names a process and has no
+// lineage yet.
+func (t *Tree) Annotate(anomalies []baseline.Anomaly) {
+	for i := range anomalies {
+		if anomalies[i].PID > 0 && len(anomalies[i].Lineage) == 0 {
+			anomalies[i].Lineage = t.Lineage(anomalies[i].PID)
+		}
+	}
+}
+
+// Prune drops processes that exited more than ExitTTL before now.
+func (t *Tree) Prune(now time.Time) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pruned := 0
+	for pid, e := range t.procs {
+		if !e.exitedAt.IsZero() && now.Sub(e.exitedAt) > t.ExitTTL {
+			delete(t.procs, pid)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+// fromProc reads a process from procfs. Callers hold t.mu.
+func (t *Tree) fromProc(pid int) *entry {
+	if t.ProcRoot == "" {
+		return nil
+	}
+	dir := filepath.Join(t.ProcRoot, strconv.Itoa(pid))
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return nil
+	}
+	// The comm field is parenthesised and may contain spaces; fields after
+	// the last ')' are state, ppid, ...
+	rest := string(stat)
+	if i := strings.LastIndexByte(rest, ')'); i >= 0 {
+		rest = rest[i+1:]
+	}
+	fields := strings.Fields(rest)
+	if len(fields) < 2 {
+		return nil
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+
+	exe, _ := os.Readlink(filepath.Join(dir, "exe"))
+	cmdline, _ := os.ReadFile(filepath.Join(dir, "cmdline"))
+	return &entry{Process: baseline.Process{
+		PID:     pid,
+		PPID:    ppid,
+		Exe:     exe,
+		Cmdline: strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " ")),
+	}}
+}
+
+func intField(v interface{}) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case float64:
+		return int(n)
+	case string:
+		i, _ := strconv.Atoi(n)
+		return i
+	}
+	return 0
+}
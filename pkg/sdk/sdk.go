@@ -0,0 +1,368 @@
+// Package sdk lets Go services report their own behavior, such as the RPC
+// targets they call, the files they open or the feature-flag paths they
+// take, to a local runtimebase daemon, which baselines it like collected
+// events.
+//
+// Observations travel over the daemon's datagram source in the line
+// protocol "category:pattern|count|labels" (see parse.ParseObservation).
+// The client never blocks its caller: observations are queued, aggregated
+// per flush interval and sent in batches, and dropped when the queue is
+// full or the daemon is unreachable. The package has no dependencies
+// beyond the standard library.
+//
+//	client, err := sdk.New("unix:///run/runtimebase/observe.sock", sdk.WithBaseline("checkout"))
+//	if err != nil { ... }
+//	defer client.Close()
+//	client.RPC("payments.Charge")
+package sdk
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultAddress is where the daemon's datagram source conventionally
+// listens.
+const DefaultAddress = "unix:///run/runtimebase/observe.sock"
+
+// BaselineLabel is the label WithBaseline sets; route on it with the label
+// router ({type: label, options: {key: baseline}}).
+const BaselineLabel = "baseline"
+
+// maxCount is the largest count one protocol line may carry.
+const maxCount = 1 << 20
+
+// Defaults of the client options.
+const (
+	DefaultFlushInterval = time.Second
+	DefaultBufferSize    = 4096
+	DefaultMaxDatagram   = 8 << 10
+)
+
+var validCategory = regexp.MustCompile(`^[a-z][a-z0-9_.-]*$`)
+
+// Option configures a Client.
+type Option func(*options)
+
+type options struct {
+	labels      map[string]string
+	sampleRate  int
+	interval    time.Duration
+	buffer      int
+	maxDatagram int
+}
+
+// WithBaseline routes the client's observations to the named baseline, by
+// setting BaselineLabel on them.
+func WithBaseline(name string) Option {
+	return WithLabels(map[string]string{BaselineLabel: name})
+}
+
+// WithLabels adds labels to every observation, e.g. env=prod. Labels
+// passed to Observe take precedence.
+func WithLabels(labels map[string]string) Option {
+	return func(o *options) {
+		for k, v := range labels {
+			o.labels[k] = v
+		}
+	}
+}
+
+// WithSampling keeps one in n observations, at random, and reports each
+// kept one as n, so hot paths cost less while counts stay right on
+// average. n <= 1 keeps every observation.
+func WithSampling(n int) Option {
+	return func(o *options) { o.sampleRate = n }
+}
+
+// WithFlushInterval sets how often aggregated observations are sent.
+func WithFlushInterval(d time.Duration) Option {
+	return func(o *options) { o.interval = d }
+}
+
+// WithBufferSize sets how many observations may wait for aggregation
+// before new ones are dropped.
+func WithBufferSize(n int) Option {
+	return func(o *options) { o.buffer = n }
+}
+
+// WithMaxDatagram sets the largest datagram sent, in bytes. Keep it under
+// the path MTU for UDP.
+func WithMaxDatagram(n int) Option {
+	return func(o *options) { o.maxDatagram = n }
+}
+
+type observation struct {
+	key    string // category:pattern
+	labels string
+	count  int
+	done   chan struct{} // set on flush requests, closed once sent
+}
+
+// Client reports observations to a daemon. It is safe for concurrent use.
+type Client struct {
+	network, address string
+	opts             options
+	queue            chan observation
+	dropped          atomic.Uint64
+	closed           chan struct{}
+	stopped          chan struct{}
+	closeOnce        sync.Once
+
+	conn net.Conn // owned by the run goroutine
+}
+
+// New returns a client sending to address, unix:///path/to.sock or
+// udp://host:port. The daemon does not need to be running yet:
+// observations made while it is unreachable are dropped. The flush
+// interval, buffer size and max datagram must be positive.
+func New(address string, opts ...Option) (*Client, error) {
+	scheme, rest, ok := strings.Cut(address, "://")
+	network := map[string]string{"unix": "unixgram", "udp": "udp"}[scheme]
+	if !ok || rest == "" || network == "" {
+		return nil, fmt.Errorf("sdk: address %q: want unix:///path or udp://host:port", address)
+	}
+	o := options{
+		labels:      make(map[string]string),
+		interval:    DefaultFlushInterval,
+		buffer:      DefaultBufferSize,
+		maxDatagram: DefaultMaxDatagram,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	switch {
+	case o.interval <= 0:
+		return nil, fmt.Errorf("sdk: flush interval %s: must be positive", o.interval)
+	case o.buffer < 1:
+		return nil, fmt.Errorf("sdk: buffer size %d: must be positive", o.buffer)
+	case o.maxDatagram < 1:
+		return nil, fmt.Errorf("sdk: max datagram %d: must be positive", o.maxDatagram)
+	}
+	c := &Client{
+		network: network,
+		address: rest,
+		opts:    o,
+		queue:   make(chan observation, o.buffer),
+		closed:  make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go c.run()
+	return c, nil
+}
+
+// Observe reports that the application did pattern of category once, e.g.
+// Observe("rpc", "payments.Charge", nil). Categories are lower-case
+// identifiers; observations with invalid ones are dropped.
+func (c *Client) Observe(category, pattern string, labels map[string]string) {
+	c.ObserveN(category, pattern, 1, labels)
+}
+
+// ObserveN reports count occurrences at once.
+func (c *Client) ObserveN(category, pattern string, count int, labels map[string]string) {
+	if count < 1 {
+		return
+	}
+	if !validCategory.MatchString(category) || pattern == "" {
+		c.dropped.Add(uint64(count))
+		return
+	}
+	if n := c.opts.sampleRate; n > 1 {
+		if rand.Intn(n) != 0 {
+			return
+		}
+		count *= n
+	}
+	o := observation{key: category + ":" + clean(pattern, "|\n"), labels: c.formatLabels(labels), count: count}
+	select {
+	case <-c.closed:
+		c.dropped.Add(uint64(count))
+		return
+	default:
+	}
+	select {
+	case c.queue <- o:
+	default:
+		c.dropped.Add(uint64(count))
+	}
+}
+
+// RPC reports a call to target, e.g. "payments.Charge" or
+// "inventory-service:8080".
+func (c *Client) RPC(target string) { c.Observe("rpc", target, nil) }
+
+// FileOpened reports that the application opened path. It shares its key
+// with collected file events.
+func (c *Client) FileOpened(path string) { c.Observe("file", path, nil) }
+
+// Flag reports the variant of a feature flag a request took, as
+// "flag:name/variant".
+func (c *Client) Flag(name, variant string) { c.Observe("flag", name+"/"+variant, nil) }
+
+// Dropped returns how many observations were dropped: made while the
+// buffer was full or after Close, unsendable, or invalid.
+func (c *Client) Dropped() uint64 { return c.dropped.Load() }
+
+// Flush sends the observations made so far and waits until they are sent
+// or dropped.
+func (c *Client) Flush() {
+	done := make(chan struct{})
+	select {
+	case <-c.closed:
+		<-c.stopped
+		return
+	default:
+	}
+	select {
+	case c.queue <- observation{done: done}:
+	case <-c.stopped:
+		return
+	}
+	select {
+	case <-done:
+	case <-c.stopped:
+	}
+}
+
+// Close sends pending observations and stops the client.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	<-c.stopped
+	return nil
+}
+
+func (c *Client) run() {
+	defer close(c.stopped)
+	ticker := time.NewTicker(c.opts.interval)
+	defer ticker.Stop()
+	pending := make(map[[2]string]int)
+	add := func(o observation) {
+		if o.done != nil {
+			c.send(pending)
+			close(o.done)
+			return
+		}
+		pending[[2]string{o.key, o.labels}] += o.count
+	}
+	for {
+		select {
+		case o := <-c.queue:
+			add(o)
+		case <-ticker.C:
+			c.send(pending)
+		case <-c.closed:
+			for len(c.queue) > 0 {
+				add(<-c.queue)
+			}
+			c.send(pending)
+			if c.conn != nil {
+				c.conn.Close()
+			}
+			return
+		}
+	}
+}
+
+// send writes pending observations in as few datagrams as fit and empties
+// pending.
+func (c *Client) send(pending map[[2]string]int) {
+	if len(pending) == 0 {
+		return
+	}
+	ids := make([][2]string, 0, len(pending))
+	for id := range pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i][0]+ids[i][1] < ids[j][0]+ids[j][1] })
+
+	var datagram bytes.Buffer
+	unsent := 0 // observations in datagram
+	write := func() {
+		if datagram.Len() > 0 && !c.write(datagram.Bytes()) {
+			c.dropped.Add(uint64(unsent))
+		}
+		datagram.Reset()
+		unsent = 0
+	}
+	for _, id := range ids {
+		for count := pending[id]; count > 0; count -= maxCount {
+			n := min(count, maxCount)
+			line := id[0] + "|" + strconv.Itoa(n)
+			if id[1] != "" {
+				line += "|" + id[1]
+			}
+			if datagram.Len()+len(line)+1 > c.opts.maxDatagram {
+				write()
+			}
+			datagram.WriteString(line)
+			datagram.WriteByte('\n')
+			unsent += n
+		}
+		delete(pending, id)
+	}
+	write()
+}
+
+// write sends one datagram, dialing the daemon when not connected.
+func (c *Client) write(datagram []byte) bool {
+	if c.conn == nil {
+		conn, err := net.Dial(c.network, c.address)
+		if err != nil {
+			return false
+		}
+		c.conn = conn
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := c.conn.Write(datagram); err != nil {
+		// Redial next time, e.g. after the daemon restarted.
+		c.conn.Close()
+		c.conn = nil
+		return false
+	}
+	return true
+}
+
+// formatLabels renders the client's and the call's labels as sorted
+// name=value pairs.
+func (c *Client) formatLabels(labels map[string]string) string {
+	if len(c.opts.labels) == 0 && len(labels) == 0 {
+		return ""
+	}
+	merged := make(map[string]string, len(c.opts.labels)+len(labels))
+	for k, v := range c.opts.labels {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	pairs := make([]string, 0, len(merged))
+	for k, v := range merged {
+		if k = clean(k, "|,=\n"); k != "" {
+			pairs = append(pairs, k+"="+clean(v, "|,=\n"))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// clean replaces the characters of the protocol's syntax in s.
+func clean(s, syntax string) string {
+	if !strings.ContainsAny(s, syntax) {
+		return s
+	}
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(syntax, r) {
+			return '_'
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,148 @@
+package sdk
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/parse"
+)
+
+// listen returns a Unix datagram socket and a function reading the lines
+// of the datagrams received, waiting up to a second for the first.
+func listen(t *testing.T) (string, func() []string) {
+	t.Helper()
+	// Socket paths are limited to about 100 bytes; t.TempDir may be longer.
+	dir, err := os.MkdirTemp("", "rbsdk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "observe.sock")
+	conn, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return "unix://" + path, func() []string {
+		var lines []string
+		buf := make([]byte, 64<<10)
+		deadline := time.Now().Add(time.Second)
+		for {
+			conn.SetReadDeadline(deadline)
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return lines
+			}
+			lines = append(lines, strings.Split(strings.TrimSpace(string(buf[:n])), "\n")...)
+			deadline = time.Now().Add(50 * time.Millisecond)
+		}
+	}
+}
+
+func TestClient(t *testing.T) {
+	address, read := listen(t)
+	c, err := New(address, WithBaseline("checkout"), WithLabels(map[string]string{"env": "prod"}), WithFlushInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		c.RPC("payments.Charge")
+	}
+	c.Flag("checkout", "v2")
+	c.Observe("file", "/etc/app|conf", map[string]string{"env": "canary"})
+	c.Observe("Bad Category", "x", nil)
+	c.Flush()
+
+	want := []string{
+		"file:/etc/app_conf|1|baseline=checkout,env=canary",
+		"flag:checkout/v2|1|baseline=checkout,env=prod",
+		"rpc:payments.Charge|3|baseline=checkout,env=prod",
+	}
+	got := read()
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("expected lines\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+	for _, line := range got {
+		if _, err := parse.ParseObservation(line); err != nil {
+			t.Errorf("daemon would reject %q: %v", line, err)
+		}
+	}
+	if c.Dropped() != 1 {
+		t.Errorf("expected the invalid category to be dropped, got %d", c.Dropped())
+	}
+
+	c.Close()
+	c.RPC("after.Close")
+	c.Flush()
+	if c.Dropped() != 2 {
+		t.Errorf("expected observations after Close to be dropped, got %d", c.Dropped())
+	}
+}
+
+func TestClientSamplingAndBatching(t *testing.T) {
+	address, read := listen(t)
+	sampled, err := New(address, WithSampling(4), WithFlushInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4000; i++ {
+		sampled.RPC("hot.Path")
+	}
+	sampled.Close()
+	small, err := New(address, WithMaxDatagram(64), WithFlushInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 8; i++ {
+		small.Observe("rpc", "target-"+string(rune('a'+i)), nil)
+	}
+	small.Close()
+
+	total, targets := 0, 0
+	for _, line := range read() {
+		e, err := parse.ParseObservation(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Key() == "rpc:hot.Path" {
+			total += e.Weight()
+		} else {
+			targets++
+		}
+	}
+	if total%4 != 0 || total < 3000 || total > 5000 {
+		t.Errorf("expected about 4000 sampled calls in multiples of 4, got %d", total)
+	}
+	if targets != 8 {
+		t.Errorf("expected 8 targets split across small datagrams, got %d", targets)
+	}
+}
+
+func TestClientDaemonDown(t *testing.T) {
+	c, err := New("unix://" + filepath.Join(t.TempDir(), "missing.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.RPC("payments.Charge")
+	c.Close()
+	if c.Dropped() != 1 {
+		t.Errorf("expected observations to be dropped while the daemon is down, got %d", c.Dropped())
+	}
+	if _, err := New("tcp://127.0.0.1:1"); err == nil {
+		t.Error("expected stream addresses to be rejected")
+	}
+}
+
+func TestClientOptionsValidated(t *testing.T) {
+	address := "unix://" + filepath.Join(t.TempDir(), "missing.sock")
+	for _, opt := range []Option{WithFlushInterval(0), WithFlushInterval(-time.Second), WithBufferSize(0), WithBufferSize(-1), WithMaxDatagram(0)} {
+		if c, err := New(address, opt); err == nil {
+			c.Close()
+			t.Errorf("expected an error for %+v", c.opts)
+		}
+	}
+}
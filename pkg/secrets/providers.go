@@ -0,0 +1,161 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Env reads secrets from environment variables.
+type Env struct{}
+
+// Get implements Provider.
+func (Env) Get(_ context.Context, name string) ([]byte, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return nil, fmt.Errorf("%s is not set", name)
+	}
+	return []byte(value), nil
+}
+
+// File reads secrets from files, such as Kubernetes secret mounts. A single
+// trailing newline is removed.
+type File struct{}
+
+// Get implements Provider.
+func (File) Get(_ context.Context, path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(data, []byte("\n")), nil
+}
+
+// Vault reads fields of HashiCorp Vault KV secrets. Locations are
+// "<path>#<field>", e.g. "secret/data/runtimebase#encryption_key"; both KV
+// version 1 and 2 paths work. The server and token come from VAULT_ADDR
+// and VAULT_TOKEN (or the token file in VAULT_TOKEN_FILE), so they never
+// appear in runtimebase configuration.
+type Vault struct {
+	Addr   string // defaults to $VAULT_ADDR
+	Token  string // defaults to $VAULT_TOKEN or the file named by $VAULT_TOKEN_FILE
+	Client *http.Client
+}
+
+// Get implements Provider.
+func (v *Vault) Get(ctx context.Context, location string) ([]byte, error) {
+	path, field, ok := strings.Cut(location, "#")
+	if !ok || field == "" {
+		return nil, fmt.Errorf("vault location %q must be <path>#<field>", location)
+	}
+	addr := v.Addr
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if addr == "" {
+		return nil, errors.New("VAULT_ADDR is not set")
+	}
+	token, err := v.token()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	client := v.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault returned %s for %s", resp.Status, path)
+	}
+
+	var body struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding vault response: %w", err)
+	}
+	fields := body.Data
+	// KV version 2 nests the secret's fields under data.data.
+	if nested, ok := body.Data["data"]; ok {
+		if err := json.Unmarshal(nested, &fields); err != nil {
+			return nil, fmt.Errorf("decoding vault response: %w", err)
+		}
+	}
+	raw, ok := fields[field]
+	if !ok {
+		return nil, fmt.Errorf("vault secret %s has no field %q", path, field)
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, fmt.Errorf("vault field %q is not a string", field)
+	}
+	return []byte(value), nil
+}
+
+func (v *Vault) token() (string, error) {
+	if v.Token != "" {
+		return v.Token, nil
+	}
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+	if path := os.Getenv("VAULT_TOKEN_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return "", errors.New("VAULT_TOKEN is not set")
+}
+
+// AWSKMS decrypts a KMS-encrypted blob stored in a file, using the aws CLI
+// and its usual credential chain (environment, profile or instance role).
+// The blob is what `aws kms encrypt --output text --query CiphertextBlob`
+// returns, base64 decoded.
+type AWSKMS struct {
+	AWSPath string // defaults to "aws"
+}
+
+// Get implements Provider.
+func (k AWSKMS) Get(ctx context.Context, path string) ([]byte, error) {
+	aws := k.AWSPath
+	if aws == "" {
+		aws = "aws"
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, aws, "kms", "decrypt",
+		"--ciphertext-blob", "fileb://"+path,
+		"--query", "Plaintext", "--output", "text")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("aws kms decrypt: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(out)))
+	if err != nil {
+		return nil, fmt.Errorf("aws kms decrypt: unexpected output: %w", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,96 @@
+// Package secrets resolves references to credentials kept outside the
+// configuration file.
+//
+// A reference names a provider and a location within it:
+//
+//	env:RUNTIMEBASE_ENCRYPTION_KEY         environment variable
+//	file:/run/secrets/tls.key              file, e.g. a mounted secret
+//	vault:secret/data/runtimebase#key      HashiCorp Vault KV field
+//	awskms:/etc/runtimebase/key.enc        AWS KMS encrypted blob
+//
+// Configuration files hold only references, never the secrets themselves.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Provider fetches secrets from one backend. The location is the part of
+// the reference after the scheme.
+type Provider interface {
+	Get(ctx context.Context, location string) ([]byte, error)
+}
+
+// ProviderFunc adapts a function to Provider.
+type ProviderFunc func(ctx context.Context, location string) ([]byte, error)
+
+// Get implements Provider.
+func (f ProviderFunc) Get(ctx context.Context, location string) ([]byte, error) {
+	return f(ctx, location)
+}
+
+var (
+	mu        sync.RWMutex
+	providers = map[string]Provider{
+		"env":    Env{},
+		"file":   File{},
+		"vault":  &Vault{},
+		"awskms": AWSKMS{},
+	}
+)
+
+// Register makes a provider available under scheme, replacing any
+// provider already registered for it.
+func Register(scheme string, p Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+	providers[scheme] = p
+}
+
+// Schemes returns the registered schemes, sorted.
+func Schemes() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	schemes := make([]string, 0, len(providers))
+	for scheme := range providers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Parse splits a reference into its scheme and location and checks that
+// the scheme is registered.
+func Parse(ref string) (scheme, location string, err error) {
+	scheme, location, ok := strings.Cut(ref, ":")
+	if !ok || location == "" {
+		return "", "", fmt.Errorf("invalid secret reference %q: want scheme:location with scheme one of %s", ref, strings.Join(Schemes(), ", "))
+	}
+	mu.RLock()
+	_, known := providers[scheme]
+	mu.RUnlock()
+	if !known {
+		return "", "", fmt.Errorf("invalid secret reference %q: unknown scheme %q", ref, scheme)
+	}
+	return scheme, location, nil
+}
+
+// Resolve fetches the secret a reference points to.
+func Resolve(ctx context.Context, ref string) ([]byte, error) {
+	scheme, location, err := Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	mu.RLock()
+	p := providers[scheme]
+	mu.RUnlock()
+	secret, err := p.Get(ctx, location)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s secret: %w", scheme, err)
+	}
+	return secret, nil
+}
@@ -0,0 +1,52 @@
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("TEST_SECRET", "s3cret")
+	if got, err := Resolve(ctx, "env:TEST_SECRET"); err != nil || string(got) != "s3cret" {
+		t.Errorf("env: got %q, %v", got, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Resolve(ctx, "file:"+path); err != nil || string(got) != "from-file" {
+		t.Errorf("file: got %q, %v", got, err)
+	}
+
+	for _, ref := range []string{"plaintext-password", "ssm:/param", "env:"} {
+		if _, err := Resolve(ctx, ref); err == nil {
+			t.Errorf("expected %q to be rejected", ref)
+		}
+	}
+}
+
+func TestVaultKV2(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" || r.URL.Path != "/v1/secret/data/runtimebase" {
+			http.Error(w, "denied", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"data":{"data":{"key":"vault-value"},"metadata":{"version":3}}}`))
+	}))
+	defer srv.Close()
+
+	v := &Vault{Addr: srv.URL, Token: "root"}
+	got, err := v.Get(context.Background(), "secret/data/runtimebase#key")
+	if err != nil || string(got) != "vault-value" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	if _, err := v.Get(context.Background(), "secret/data/runtimebase#missing"); err == nil {
+		t.Error("expected missing field to fail")
+	}
+}
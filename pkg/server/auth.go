@@ -0,0 +1,115 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/hallucinaut/runtimebase/pkg/config"
+	"github.com/hallucinaut/runtimebase/pkg/storage"
+)
+
+// Permission is an action a token may perform.
+type Permission int
+
+// Permissions granted by roles.
+const (
+	PermRead Permission = 1 << iota
+	PermIngest
+	PermAdmin
+)
+
+var rolePermissions = map[string]Permission{
+	"read-only":   PermRead,
+	"ingest-only": PermIngest,
+	"admin":       PermRead | PermIngest | PermAdmin,
+}
+
+// Principal is an authenticated API caller.
+type Principal struct {
+	Name        string
+	Permissions Permission
+	Baselines   []string // glob patterns; empty allows all
+	Namespaces  []string // glob patterns; empty allows only the default namespace
+}
+
+// Can reports whether the principal holds perm on the named baseline. An
+// empty name checks the permission without a baseline scope.
+func (p *Principal) Can(perm Permission, name string) bool {
+	if p.Permissions&perm != perm {
+		return false
+	}
+	if name == "" || len(p.Baselines) == 0 {
+		return true
+	}
+	for _, pattern := range p.Baselines {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// InNamespace reports whether the principal may access the namespace at
+// all. Baselines then scopes access within it.
+func (p *Principal) InNamespace(ns string) bool {
+	if len(p.Namespaces) == 0 {
+		return ns == storage.DefaultNamespace
+	}
+	for _, pattern := range p.Namespaces {
+		if ok, _ := path.Match(pattern, ns); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator resolves bearer tokens to principals.
+type Authenticator struct {
+	tokens []tokenEntry
+}
+
+type tokenEntry struct {
+	hash      []byte
+	principal Principal
+}
+
+// NewAuthenticator builds an authenticator from configured tokens.
+func NewAuthenticator(tokens []config.TokenConfig) *Authenticator {
+	a := &Authenticator{}
+	for _, token := range tokens {
+		hash, err := hex.DecodeString(strings.ToLower(token.TokenSHA256))
+		if err != nil || len(hash) != sha256.Size {
+			continue
+		}
+		a.tokens = append(a.tokens, tokenEntry{
+			hash: hash,
+			principal: Principal{
+				Name:        token.Name,
+				Permissions: rolePermissions[token.Role],
+				Baselines:   token.Baselines,
+				Namespaces:  token.Namespaces,
+			},
+		})
+	}
+	return a
+}
+
+// Authenticate returns the principal for the request's bearer token.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, false
+	}
+	sum := sha256.Sum256([]byte(token))
+
+	for i := range a.tokens {
+		if subtle.ConstantTimeCompare(sum[:], a.tokens[i].hash) == 1 {
+			return &a.tokens[i].principal, true
+		}
+	}
+	return nil, false
+}
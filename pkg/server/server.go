@@ -0,0 +1,521 @@
+// Package server exposes baselines over an authenticated HTTP API.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/config"
+	"github.com/hallucinaut/runtimebase/pkg/filter"
+	"github.com/hallucinaut/runtimebase/pkg/pipeline"
+	"github.com/hallucinaut/runtimebase/pkg/storage"
+)
+
+// Server serves the runtimebase HTTP API:
+//
+//	GET    /v1/baselines                      list visible baselines (read)
+//	GET    /v1/baselines/{name}               fetch a baseline (read)
+//	GET    /v1/baselines/{name}/history       anomaly and event history (read)
+//	                                          filtered by since, until, pid, process, category
+//	POST   /v1/baselines/{name}/observations  record observations (ingest)
+//	GET    /v1/baselines/{name}/scores        behavior score series (read)
+//	GET    /v1/baselines/{name}/divergence    divergence of a candidate, for rollouts (read)
+//	POST   /v1/baselines/{name}/scores        record behavior scores (ingest)
+//	POST   /v1/observations                   record observations for many baselines (ingest)
+//	PUT    /v1/baselines/{name}               create a baseline (admin)
+//	DELETE /v1/baselines/{name}               delete a baseline (admin)
+//	GET    /v1/namespaces                     list visible namespaces (read)
+//
+// These routes serve the default namespace. Prefixed with
+// /v1/namespaces/{ns}, as in /v1/namespaces/team-a/baselines/web, they
+// serve the baselines of namespace ns, isolated from every other
+// namespace; tokens only reach the namespaces they are granted.
+//
+// Batched ingestion is HTTP only: there is no gRPC endpoint, which would
+// take the gRPC and protobuf modules as dependencies. Agents stream large
+// batches as newline-delimited JSON instead.
+type Server struct {
+	Store *storage.Store
+	Auth  *Authenticator
+	// LabelPolicy is applied to baselines created through the API.
+	LabelPolicy *baseline.LabelPolicy
+	Logger      *slog.Logger
+
+	mu *sync.Mutex // serializes read-modify-write of stored baselines
+}
+
+// New creates an API server.
+func New(store *storage.Store, auth *Authenticator) *Server {
+	return &Server{Store: store, Auth: auth, Logger: slog.Default(), mu: &sync.Mutex{}}
+}
+
+// Observation is one observation in an ingest request. Baseline is only
+// used by the multi-baseline endpoint.
+type Observation struct {
+	Baseline string            `json:"baseline,omitempty"`
+	Category string            `json:"category"`
+	Pattern  string            `json:"pattern"`
+	Count    int               `json:"count"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	principal, ok := s.Auth.Authenticate(r)
+	if !ok {
+		s.Logger.Warn("rejected unauthenticated request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="runtimebase"`)
+		writeError(w, http.StatusUnauthorized, "missing or invalid token")
+		return
+	}
+
+	urlPath, ns := r.URL.Path, storage.DefaultNamespace
+	if rest, ok := cutSegments(urlPath, "/v1/namespaces"); ok {
+		rest = strings.Trim(rest, "/")
+		if rest == "" && r.Method == http.MethodGet {
+			s.namespaces(w, principal)
+			return
+		}
+		ns, rest, _ = strings.Cut(rest, "/")
+		urlPath = "/v1/" + rest
+	}
+	if !principal.InNamespace(ns) {
+		s.Logger.Warn("denied request", "token", principal.Name, "namespace", ns)
+		writeError(w, http.StatusForbidden, "token not permitted for this namespace")
+		return
+	}
+	store, err := s.Store.WithNamespace(ns)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Attribute mutations made by this request to the token.
+	logger := s.Logger.With("token", principal.Name)
+	if ns != storage.DefaultNamespace {
+		logger = logger.With("namespace", ns)
+	}
+	s = &Server{Store: store.WithActor("api:" + principal.Name), Auth: s.Auth, LabelPolicy: s.LabelPolicy, Logger: logger, mu: s.mu}
+	s.Logger.Debug("request", "method", r.Method, "path", r.URL.Path)
+
+	if urlPath == "/v1/observations" && r.Method == http.MethodPost {
+		s.ingest(w, r, principal, "")
+		return
+	}
+
+	rest, ok := cutSegments(urlPath, "/v1/baselines")
+	if !ok {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+
+	switch {
+	case parts[0] == "" && r.Method == http.MethodGet:
+		s.list(w, r, principal)
+	case len(parts) == 1 && parts[0] != "":
+		s.baseline(w, r, principal, parts[0])
+	case len(parts) == 2 && parts[1] == "history" && r.Method == http.MethodGet:
+		if s.authorize(w, principal, PermRead, parts[0]) {
+			s.history(w, r, parts[0])
+		}
+	case len(parts) == 2 && parts[1] == "scores" && r.Method == http.MethodGet:
+		if s.authorize(w, principal, PermRead, parts[0]) {
+			s.scores(w, r, parts[0])
+		}
+	case len(parts) == 2 && parts[1] == "scores" && r.Method == http.MethodPost:
+		if s.authorize(w, principal, PermIngest, parts[0]) {
+			s.recordScores(w, r, parts[0])
+		}
+	case len(parts) == 2 && parts[1] == "divergence" && (r.Method == http.MethodGet || r.Method == http.MethodPost):
+		s.divergence(w, r, principal, parts[0])
+	case len(parts) == 2 && parts[1] == "observations" && r.Method == http.MethodPost:
+		if s.authorize(w, principal, PermIngest, parts[0]) {
+			s.ingest(w, r, principal, parts[0])
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// cutSegments is strings.CutPrefix for prefixes of whole path segments:
+// "/v1/baselines" is a prefix of "/v1/baselines/web" but not of
+// "/v1/baselinesfoo".
+func cutSegments(path, prefix string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok || (rest != "" && rest[0] != '/') {
+		return "", false
+	}
+	return rest, true
+}
+
+func (s *Server) authorize(w http.ResponseWriter, p *Principal, perm Permission, name string) bool {
+	if !p.Can(perm, name) {
+		s.Logger.Warn("denied request", "baseline", name)
+		writeError(w, http.StatusForbidden, "token not permitted for this operation")
+		return false
+	}
+	return true
+}
+
+// namespaces lists the namespaces the principal may access, the default
+// namespace as "".
+func (s *Server) namespaces(w http.ResponseWriter, p *Principal) {
+	if !s.authorize(w, p, PermRead, "") {
+		return
+	}
+	names, err := s.Store.ListNamespaces()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	visible := []string{}
+	for _, ns := range append([]string{storage.DefaultNamespace}, names...) {
+		if p.InNamespace(ns) {
+			visible = append(visible, ns)
+		}
+	}
+	writeJSON(w, http.StatusOK, visible)
+}
+
+// list lists the baselines p can read; the label query parameter, like
+// "env=prod,owner=payments", keeps those with that metadata.
+func (s *Server) list(w http.ResponseWriter, r *http.Request, p *Principal) {
+	if !s.authorize(w, p, PermRead, "") {
+		return
+	}
+	selector, err := baseline.ParseMetadata(r.URL.Query().Get("label"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	names, err := s.Store.ListBaselines()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	visible := []string{}
+	for _, name := range names {
+		if !p.Can(PermRead, name) {
+			continue
+		}
+		if len(selector) > 0 {
+			if b, err := s.Store.LoadBaseline(name); err != nil || !b.MatchMetadata(selector) {
+				continue
+			}
+		}
+		visible = append(visible, name)
+	}
+	writeJSON(w, http.StatusOK, visible)
+}
+
+func (s *Server) baseline(w http.ResponseWriter, r *http.Request, p *Principal, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		if !s.authorize(w, p, PermRead, name) {
+			return
+		}
+		b, err := s.Store.LoadBaseline(name)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, b)
+
+	case http.MethodPut:
+		if !s.authorize(w, p, PermAdmin, name) {
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, err := s.Store.LoadBaseline(name); err == nil {
+			writeError(w, http.StatusConflict, "baseline already exists")
+			return
+		}
+		b := baseline.NewLearner().CreateBaseline(name)
+		b.LabelPolicy = s.LabelPolicy
+		if err := s.Store.SaveBaseline(b); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, b)
+
+	case http.MethodDelete:
+		if !s.authorize(w, p, PermAdmin, name) {
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err := s.Store.DeleteBaseline(name); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) history(w http.ResponseWriter, r *http.Request, name string) {
+	f, err := filter.FromQuery(r.URL.Query(), time.Now())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	records, err := s.Store.History(name, f.Since)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	matched := []storage.Record{}
+	for _, record := range records {
+		if f.Record(record) {
+			matched = append(matched, record)
+		}
+	}
+	writeJSON(w, http.StatusOK, matched)
+}
+
+// scores returns the baseline's behavior scores. Query parameters: since,
+// a duration such as 7d (default all), and bucket, a duration to average
+// scores over.
+func (s *Server) scores(w http.ResponseWriter, r *http.Request, name string) {
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		d, err := config.ParseDuration(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid since duration")
+			return
+		}
+		since = time.Now().Add(-d)
+	}
+	var bucket time.Duration
+	if v := r.URL.Query().Get("bucket"); v != "" {
+		d, err := config.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid bucket duration")
+			return
+		}
+		bucket = d
+	}
+	if _, err := s.Store.LoadBaseline(name); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	points, err := s.Store.Scores(name, since)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	points = storage.Bucket(points, bucket)
+	if points == nil {
+		points = []storage.ScorePoint{}
+	}
+	writeJSON(w, http.StatusOK, points)
+}
+
+// recordScores appends a JSON array of score points to the baseline's
+// series.
+func (s *Server) recordScores(w http.ResponseWriter, r *http.Request, name string) {
+	var points []storage.ScorePoint
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxIngestBytes)).Decode(&points); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid scores: %v", err))
+		return
+	}
+	for _, p := range points {
+		if p.Score < 0 || p.Score > 100 {
+			writeError(w, http.StatusBadRequest, "score must be between 0 and 100")
+			return
+		}
+	}
+	if _, err := s.Store.LoadBaseline(name); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if err := s.Store.AppendScores(name, points...); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"recorded": len(points)})
+}
+
+// Divergence is how far a candidate baseline, such as a canary's, has
+// diverged from a baseline, shaped for rollout analysis: Argo Rollouts web
+// metrics can read $.score or $.pass, and Flagger webhooks gate on the
+// status code with gate=true.
+type Divergence struct {
+	Baseline  string  `json:"baseline"`
+	Candidate string  `json:"candidate"`
+	Score     float64 `json:"score"` // share of the candidate's patterns new or shifted, 0 to 1
+	Threshold float64 `json:"threshold"`
+	Pass      bool    `json:"pass"` // score below threshold
+	Observed  int     `json:"observed"`
+	New       int     `json:"new"`
+	Shifted   int     `json:"shifted"`
+	Missing   int     `json:"missing"`
+}
+
+// divergence compares a candidate with the baseline. Query parameters:
+// candidate, the candidate baseline (default the shadow mode candidate,
+// name.candidate); threshold, the score at which it fails (default
+// pipeline.DefaultDivergenceThreshold); and gate=true, answering 412
+// Precondition Failed instead of 200 when it fails. POST is accepted, and
+// its body ignored, for webhooks that post their context.
+func (s *Server) divergence(w http.ResponseWriter, r *http.Request, p *Principal, name string) {
+	query := r.URL.Query()
+	candidate := query.Get("candidate")
+	if candidate == "" {
+		candidate = name + pipeline.DefaultCandidateSuffix
+	}
+	if !s.authorize(w, p, PermRead, name) || !s.authorize(w, p, PermRead, candidate) {
+		return
+	}
+	threshold := pipeline.DefaultDivergenceThreshold
+	if v := query.Get("threshold"); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t <= 0 || t > 1 {
+			writeError(w, http.StatusBadRequest, "threshold must be a number in (0, 1]")
+			return
+		}
+		threshold = t
+	}
+	gate := false
+	if v := query.Get("gate"); v != "" {
+		var err error
+		if gate, err = strconv.ParseBool(v); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid gate")
+			return
+		}
+	}
+
+	active, err := s.Store.LoadBaseline(name)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	learned, err := s.Store.LoadBaseline(candidate)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	d := baseline.Diverge(active, learned)
+	resp := Divergence{Baseline: name, Candidate: candidate, Score: d.Score, Threshold: threshold, Pass: d.Score < threshold,
+		Observed: d.Observed, New: len(d.New), Shifted: len(d.Shifted), Missing: len(d.Missing)}
+	status := http.StatusOK
+	if gate && !resp.Pass {
+		status = http.StatusPreconditionFailed
+	}
+	writeJSON(w, status, resp)
+}
+
+// maxIngestBytes bounds an ingest request body.
+const maxIngestBytes = 32 << 20
+
+// ingest records observations. With a name, every observation goes to that
+// baseline; otherwise each names its own baseline. Observations are grouped
+// so each baseline is loaded and saved once per request. The body is a JSON
+// array, or newline-delimited JSON objects with Content-Type
+// application/x-ndjson.
+func (s *Server) ingest(w http.ResponseWriter, r *http.Request, p *Principal, name string) {
+	observations, err := decodeObservations(r.Header.Get("Content-Type"), http.MaxBytesReader(w, r.Body, maxIngestBytes))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid observations: %v", err))
+		return
+	}
+
+	groups := make(map[string][]baseline.Observation)
+	for _, o := range observations {
+		if o.Category == "" || o.Pattern == "" {
+			writeError(w, http.StatusBadRequest, "category and pattern are required")
+			return
+		}
+		target := name
+		if target == "" {
+			target = o.Baseline
+		}
+		if target == "" {
+			writeError(w, http.StatusBadRequest, "baseline is required")
+			return
+		}
+		if _, seen := groups[target]; !seen && !s.authorize(w, p, PermIngest, target) {
+			return
+		}
+		groups[target] = append(groups[target], baseline.Observation{
+			Category: o.Category,
+			Pattern:  o.Pattern,
+			Labels:   o.Labels,
+			Count:    o.Count,
+		})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	recorded := make(map[string]int, len(groups))
+	for target, batch := range groups {
+		b, err := s.Store.LoadBaseline(target)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		b.RecordObservations(batch)
+		if err := s.Store.SaveLearned(b); err != nil {
+			writeStoreError(w, err)
+			return
+		}
+		recorded[target] = len(batch)
+	}
+
+	if name != "" {
+		writeJSON(w, http.StatusOK, map[string]int{"recorded": recorded[name]})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"recorded": len(observations), "baselines": recorded})
+}
+
+func decodeObservations(contentType string, body io.Reader) ([]Observation, error) {
+	if !strings.HasPrefix(contentType, "application/x-ndjson") {
+		var observations []Observation
+		err := json.NewDecoder(body).Decode(&observations)
+		return observations, err
+	}
+
+	var observations []Observation
+	dec := json.NewDecoder(body)
+	for {
+		var o Observation
+		if err := dec.Decode(&o); err == io.EOF {
+			return observations, nil
+		} else if err != nil {
+			return nil, err
+		}
+		observations = append(observations, o)
+	}
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/config"
+	"github.com/hallucinaut/runtimebase/pkg/storage"
+)
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestAuthorization(t *testing.T) {
+	store, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"team-a-api", "team-b-api"} {
+		if err := store.SaveBaseline(baseline.NewLearner().CreateBaseline(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := New(store, NewAuthenticator([]config.TokenConfig{
+		{Name: "reader", TokenSHA256: hashToken("r"), Role: "read-only", Baselines: []string{"team-a-*"}},
+		{Name: "agent", TokenSHA256: hashToken("i"), Role: "ingest-only"},
+		{Name: "ops", TokenSHA256: hashToken("a"), Role: "admin"},
+		{Name: "team-c", TokenSHA256: hashToken("c"), Role: "admin", Namespaces: []string{"team-c"}},
+	}))
+
+	tests := []struct {
+		token, method, path, body string
+		want                      int
+	}{
+		{"", "GET", "/v1/baselines", "", http.StatusUnauthorized},
+		{"wrong", "GET", "/v1/baselines", "", http.StatusUnauthorized},
+		{"r", "GET", "/v1/baselines/team-a-api", "", http.StatusOK},
+		{"r", "GET", "/v1/baselines/team-b-api", "", http.StatusForbidden},
+		{"r", "POST", "/v1/baselines/team-a-api/observations", `[]`, http.StatusForbidden},
+		{"i", "GET", "/v1/baselines/team-a-api", "", http.StatusForbidden},
+		{"i", "POST", "/v1/baselines/team-b-api/observations", `[{"category":"syscall","pattern":"open","count":3}]`, http.StatusOK},
+		{"i", "POST", "/v1/observations", `[{"baseline":"team-a-api","category":"file","pattern":"read","count":1},{"baseline":"team-b-api","category":"file","pattern":"read","count":2}]`, http.StatusOK},
+		{"r", "POST", "/v1/observations", `[{"baseline":"team-a-api","category":"file","pattern":"read","count":1}]`, http.StatusForbidden},
+		{"i", "DELETE", "/v1/baselines/team-b-api", "", http.StatusForbidden},
+		{"a", "DELETE", "/v1/baselines/team-b-api", "", http.StatusNoContent},
+		{"c", "GET", "/v1/baselines", "", http.StatusForbidden},
+		{"c", "PUT", "/v1/namespaces/team-c/baselines/team-a-api", "", http.StatusCreated},
+		{"c", "GET", "/v1/namespaces/team-c/baselines/team-a-api", "", http.StatusOK},
+		{"c", "POST", "/v1/namespaces/team-c/observations", `[{"baseline":"team-a-api","category":"file","pattern":"read","count":1}]`, http.StatusOK},
+		{"c", "GET", "/v1/namespaces/team-d/baselines", "", http.StatusForbidden},
+		{"a", "GET", "/v1/namespaces/team-c/baselines", "", http.StatusForbidden},
+		{"r", "GET", "/v1/baselines/team-a-api", "", http.StatusOK},
+		{"r", "GET", "/v1/baselinesteam-a-api", "", http.StatusNotFound},
+		{"a", "GET", "/v1/namespacesteam-c/baselines", "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s with token %q: expected %d, got %d (%s)", tt.method, tt.path, tt.token, tt.want, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestDivergence(t *testing.T) {
+	store, err := storage.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stable := baseline.NewLearner().CreateBaseline("web")
+	canary := baseline.NewLearner().CreateBaseline("web.candidate")
+	for i := 0; i < 3; i++ {
+		stable.RecordObservation("syscall", "read", 10)
+		stable.RecordObservation("network", "10.0.0.9:443", 2)
+		canary.RecordObservation("syscall", "read", 10)
+		canary.RecordObservation("process", "/bin/sh", 1)
+	}
+	for _, b := range []*baseline.Baseline{stable, canary} {
+		if err := store.SaveBaseline(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := New(store, NewAuthenticator([]config.TokenConfig{
+		{Name: "reader", TokenSHA256: hashToken("r"), Role: "read-only", Baselines: []string{"web"}},
+		{Name: "ops", TokenSHA256: hashToken("a"), Role: "admin"},
+	}))
+	get := func(token, method, path string) (*httptest.ResponseRecorder, Divergence) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"name":"web","phase":"Progressing"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		var d Divergence
+		json.Unmarshal(rec.Body.Bytes(), &d)
+		return rec, d
+	}
+
+	rec, d := get("a", "GET", "/v1/baselines/web/divergence")
+	if rec.Code != http.StatusOK || d.Candidate != "web.candidate" || d.Score != 0.5 || d.Pass || d.New != 1 || d.Missing != 1 {
+		t.Errorf("unexpected divergence %d %+v", rec.Code, d)
+	}
+	if rec, _ := get("a", "POST", "/v1/baselines/web/divergence?gate=true"); rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected a failing gate to answer 412, got %d", rec.Code)
+	}
+	if rec, d := get("a", "POST", "/v1/baselines/web/divergence?gate=true&threshold=0.6"); rec.Code != http.StatusOK || !d.Pass {
+		t.Errorf("expected a passing gate under a higher threshold, got %d %+v", rec.Code, d)
+	}
+	for path, want := range map[string]int{
+		"/v1/baselines/web/divergence":                           http.StatusForbidden, // candidate not readable
+		"/v1/baselines/web/divergence?candidate=web":             http.StatusOK,
+		"/v1/baselines/web/divergence?candidate=web&gate=x":      http.StatusBadRequest,
+		"/v1/baselines/web/divergence?candidate=web&threshold=2": http.StatusBadRequest,
+	} {
+		if rec, _ := get("r", "GET", path); rec.Code != want {
+			t.Errorf("GET %s: expected %d, got %d (%s)", path, want, rec.Code, rec.Body)
+		}
+	}
+	if rec, _ := get("a", "GET", "/v1/baselines/web/divergence?candidate=missing"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected a missing candidate to answer 404, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// Run runs the daemon as the Windows service name. Other systems have no
+// Service Control Manager, so it fails.
+func Run(name string, run func(ctx context.Context) error) error {
+	return fmt.Errorf("service %s: running as a Windows service is not supported on %s", name, runtime.GOOS)
+}
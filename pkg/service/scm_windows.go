@@ -0,0 +1,163 @@
+//go:build windows
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32                          = syscall.NewLazyDLL("advapi32.dll")
+	procStartServiceCtrlDispatcherW   = advapi32.NewProc("StartServiceCtrlDispatcherW")
+	procRegisterServiceCtrlHandlerExW = advapi32.NewProc("RegisterServiceCtrlHandlerExW")
+	procSetServiceStatus              = advapi32.NewProc("SetServiceStatus")
+)
+
+// Service control protocol constants, from winsvc.h.
+const (
+	serviceWin32OwnProcess = 0x10
+
+	serviceStopped      = 1
+	serviceStartPending = 2
+	serviceStopPending  = 3
+	serviceRunning      = 4
+
+	serviceAcceptStop     = 0x1
+	serviceAcceptShutdown = 0x4
+
+	serviceControlStop        = 1
+	serviceControlInterrogate = 4
+	serviceControlShutdown    = 5
+
+	errorCallNotImplemented             = 120
+	errorServiceSpecificError           = 1066
+	errorFailedServiceControllerConnect = 1063
+	waitHintMillis                      = 30000
+)
+
+type serviceTableEntry struct {
+	name *uint16
+	proc uintptr
+}
+
+type serviceStatus struct {
+	serviceType             uint32
+	currentState            uint32
+	controlsAccepted        uint32
+	win32ExitCode           uint32
+	serviceSpecificExitCode uint32
+	checkPoint              uint32
+	waitHint                uint32
+}
+
+// scm is the one service the process runs. The Service Control Manager
+// calls back into it on threads of its own.
+var scm struct {
+	mu     sync.Mutex
+	name   *uint16
+	run    func(ctx context.Context) error
+	handle uintptr
+	cancel context.CancelFunc
+	state  uint32
+	err    error
+}
+
+var (
+	serviceMainCallback = syscall.NewCallback(serviceMain)
+	handlerCallback     = syscall.NewCallback(serviceHandler)
+)
+
+// Run runs the daemon as the Windows service name: it connects to the
+// Service Control Manager, reports the service running while run runs,
+// cancels run's context when the service is stopped or the system shuts
+// down, and returns run's error once the service is reported stopped. It
+// fails when the process was not started by the Service Control Manager.
+func Run(name string, run func(ctx context.Context) error) error {
+	serviceName, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	scm.mu.Lock()
+	scm.name, scm.run = serviceName, run
+	scm.mu.Unlock()
+
+	table := []serviceTableEntry{{name: serviceName, proc: serviceMainCallback}, {}}
+	if ok, _, err := procStartServiceCtrlDispatcherW.Call(uintptr(unsafe.Pointer(&table[0]))); ok == 0 {
+		if errors.Is(err, syscall.Errno(errorFailedServiceControllerConnect)) {
+			return fmt.Errorf("service %s: not started by the Service Control Manager", name)
+		}
+		return fmt.Errorf("service %s: %w", name, err)
+	}
+	scm.mu.Lock()
+	defer scm.mu.Unlock()
+	return scm.err
+}
+
+func serviceMain(argc, argv uintptr) uintptr {
+	scm.mu.Lock()
+	handle, _, _ := procRegisterServiceCtrlHandlerExW.Call(uintptr(unsafe.Pointer(scm.name)), handlerCallback, 0)
+	if handle == 0 {
+		scm.mu.Unlock()
+		return 0
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	scm.handle, scm.cancel = handle, cancel
+	setStatus(serviceStartPending, 0)
+	setStatus(serviceRunning, 0)
+	run := scm.run
+	scm.mu.Unlock()
+
+	err := run(ctx)
+	cancel()
+
+	scm.mu.Lock()
+	defer scm.mu.Unlock()
+	scm.err = err
+	code := uint32(0)
+	if err != nil {
+		code = 1
+	}
+	setStatus(serviceStopped, code)
+	return 0
+}
+
+func serviceHandler(control, eventType, eventData, context uintptr) uintptr {
+	scm.mu.Lock()
+	defer scm.mu.Unlock()
+	switch control {
+	case serviceControlStop, serviceControlShutdown:
+		if scm.state == serviceRunning {
+			setStatus(serviceStopPending, 0)
+			scm.cancel()
+		}
+	case serviceControlInterrogate:
+		setStatus(scm.state, 0)
+	default:
+		return errorCallNotImplemented
+	}
+	return 0
+}
+
+// setStatus reports the service's state, with a service-specific exit
+// code once it stopped; scm.mu must be held.
+func setStatus(state, code uint32) {
+	scm.state = state
+	status := serviceStatus{serviceType: serviceWin32OwnProcess, currentState: state}
+	switch state {
+	case serviceRunning:
+		status.controlsAccepted = serviceAcceptStop | serviceAcceptShutdown
+	case serviceStartPending, serviceStopPending:
+		status.waitHint = waitHintMillis
+	case serviceStopped:
+		if code != 0 {
+			status.win32ExitCode = errorServiceSpecificError
+			status.serviceSpecificExitCode = code
+		}
+	}
+	procSetServiceStatus.Call(scm.handle, uintptr(unsafe.Pointer(&status)))
+}
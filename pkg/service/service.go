@@ -0,0 +1,300 @@
+// Package service installs the runtimebase daemon as a system service: a
+// systemd unit with sandboxing on Linux, and a service of the Service
+// Control Manager on Windows, which the daemon then speaks the service
+// control protocol to (see Run).
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// Defaults of Spec.
+const (
+	DefaultName    = "runtimebase"
+	DefaultConfig  = "/etc/runtimebase/runtimebase.yaml"
+	DefaultUser    = "runtimebase"
+	DefaultHome    = "/var/lib/runtimebase"
+	DefaultUnitDir = "/etc/systemd/system"
+)
+
+// DefaultCapabilities are the capabilities the daemon keeps under systemd:
+// loading eBPF programs and reading perf buffers for the LSM collector,
+// raising the locked memory limit they need, and reading other processes'
+// /proc entries and files for the process tree and hashing.
+var DefaultCapabilities = []string{
+	"CAP_BPF", "CAP_PERFMON", "CAP_SYS_RESOURCE", "CAP_DAC_READ_SEARCH", "CAP_SYS_PTRACE",
+}
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.@-]*$`)
+
+// Spec describes the service to install.
+type Spec struct {
+	Name   string // service name, DefaultName if empty
+	Binary string // absolute path of the runtimebase executable
+	Config string // daemon configuration file; empty runs the defaults
+	// User is the account the daemon runs as; empty or "root" runs it as
+	// root. With DynamicUser, systemd allocates the account.
+	User        string
+	DynamicUser bool
+	// Home is the data directory, passed as RUNTIMEBASE_HOME. The daemon
+	// may write only there.
+	Home         string
+	Capabilities []string // DefaultCapabilities if nil
+}
+
+// withDefaults validates s and fills in defaults.
+func (s Spec) withDefaults() (Spec, error) {
+	if s.Name == "" {
+		s.Name = DefaultName
+	}
+	if s.Home == "" {
+		s.Home = DefaultHome
+	}
+	if s.Capabilities == nil {
+		s.Capabilities = DefaultCapabilities
+	}
+	if s.User == "root" {
+		s.User = ""
+	}
+	var errs []error
+	if !validName.MatchString(s.Name) {
+		errs = append(errs, fmt.Errorf("service name %q: use letters, digits and _.@-", s.Name))
+	}
+	for what, path := range map[string]string{"binary": s.Binary, "home": s.Home, "config": s.Config} {
+		if path != "" && !filepath.IsAbs(path) {
+			errs = append(errs, fmt.Errorf("%s %q: want an absolute path", what, path))
+		}
+	}
+	if s.Binary == "" {
+		errs = append(errs, errors.New("binary: path of the runtimebase executable required"))
+	}
+	if s.User != "" && !validName.MatchString(s.User) {
+		errs = append(errs, fmt.Errorf("user %q: not a valid account name", s.User))
+	}
+	for _, c := range s.Capabilities {
+		if !strings.HasPrefix(c, "CAP_") {
+			errs = append(errs, fmt.Errorf("capability %q: want CAP_*", c))
+		}
+	}
+	return s, errors.Join(errs...)
+}
+
+var unitTemplate = template.Must(template.New("unit").Funcs(template.FuncMap{
+	"join":  strings.Join,
+	"quote": systemdQuote,
+}).Parse(`[Unit]
+Description=runtimebase runtime behavior baseline daemon
+Documentation=https://github.com/hallucinaut/runtimebase
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+ExecStart={{quote .Binary}} daemon{{with .Config}} --config {{quote .}}{{end}}
+Environment={{quote (print "RUNTIMEBASE_HOME=" .Home)}}
+{{- if .User}}
+User={{.User}}
+{{- if .DynamicUser}}
+DynamicUser=yes
+{{- end}}
+{{- end}}
+{{- with .StateDirectory}}
+StateDirectory={{.}}
+StateDirectoryMode=0700
+{{- else}}
+ReadWritePaths={{quote .Home}}
+{{- end}}
+RuntimeDirectory={{.Name}}
+RuntimeDirectoryMode=0750
+Restart=on-failure
+RestartSec=5s
+UMask=0077
+
+# Sandboxing: read-only system, no new privileges, only the capabilities
+# the collectors need.
+{{- if .Capabilities}}
+CapabilityBoundingSet={{join .Capabilities " "}}
+{{- if .User}}
+AmbientCapabilities={{join .Capabilities " "}}
+{{- end}}
+{{- else}}
+CapabilityBoundingSet=
+{{- end}}
+NoNewPrivileges=yes
+ProtectSystem=strict
+ProtectHome=read-only
+PrivateTmp=yes
+PrivateDevices=yes
+ProtectClock=yes
+ProtectHostname=yes
+ProtectKernelModules=yes
+ProtectKernelLogs=yes
+ProtectControlGroups=yes
+RestrictNamespaces=yes
+RestrictRealtime=yes
+RestrictSUIDSGID=yes
+LockPersonality=yes
+MemoryDenyWriteExecute=yes
+SystemCallArchitectures=native
+RestrictAddressFamilies=AF_UNIX AF_INET AF_INET6 AF_NETLINK
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+// SystemdUnit renders the systemd unit running the daemon described by s.
+func SystemdUnit(s Spec) ([]byte, error) {
+	s, err := s.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	data := struct {
+		Spec
+		StateDirectory string
+	}{Spec: s}
+	// systemd creates, and hands to a dynamic user, directories under
+	// /var/lib it manages as the state directory.
+	if rel, ok := strings.CutPrefix(filepath.Clean(s.Home), "/var/lib/"); ok && rel != "" {
+		data.StateDirectory = rel
+	}
+	var buf bytes.Buffer
+	if err := unitTemplate.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// systemdQuote quotes s for a unit file when it contains spaces, quotes or
+// backslashes, and escapes % specifiers.
+func systemdQuote(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	if !strings.ContainsAny(s, " \t\"'\\") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// Installer installs and removes the service on the host.
+type Installer struct {
+	// OS selects systemd ("linux") or the Service Control Manager
+	// ("windows").
+	OS string
+	// UnitDir is where systemd units are written, DefaultUnitDir if
+	// empty.
+	UnitDir string
+	// Run runs a command, exec.Command's by default. Tests replace it.
+	Run func(name string, args ...string) error
+	// Log receives a line for each step taken.
+	Log func(format string, args ...interface{})
+}
+
+func (in *Installer) run(name string, args ...string) error {
+	in.log("running %s %s", name, strings.Join(args, " "))
+	if in.Run != nil {
+		return in.Run(name, args...)
+	}
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (in *Installer) log(format string, args ...interface{}) {
+	if in.Log != nil {
+		in.Log(format, args...)
+	}
+}
+
+// UnitPath returns the path the systemd unit of the named service is
+// installed at.
+func (in *Installer) UnitPath(name string) string {
+	dir := in.UnitDir
+	if dir == "" {
+		dir = DefaultUnitDir
+	}
+	if name == "" {
+		name = DefaultName
+	}
+	return filepath.Join(dir, name+".service")
+}
+
+// Install installs the service and, with start, enables and starts it.
+func (in *Installer) Install(s Spec, start bool) error {
+	switch in.OS {
+	case "linux":
+		unit, err := SystemdUnit(s)
+		if err != nil {
+			return err
+		}
+		path := in.UnitPath(s.Name)
+		if err := os.WriteFile(path, unit, 0o644); err != nil {
+			return err
+		}
+		in.log("wrote %s", path)
+		if err := in.run("systemctl", "daemon-reload"); err != nil {
+			return err
+		}
+		args := []string{"enable", filepath.Base(path)}
+		if start {
+			args = []string{"enable", "--now", filepath.Base(path)}
+		}
+		return in.run("systemctl", args...)
+	case "windows":
+		commands, err := WindowsCommands(s)
+		if err != nil {
+			return err
+		}
+		for _, command := range commands {
+			if err := in.run(command[0], command[1:]...); err != nil {
+				return err
+			}
+		}
+		if start {
+			return in.run("sc.exe", "start", s.Name)
+		}
+		return nil
+	}
+	return fmt.Errorf("installing a service is not supported on %s", in.OS)
+}
+
+// Uninstall stops and removes the named service. Removing a service that
+// is not installed is not an error.
+func (in *Installer) Uninstall(name string) error {
+	if name == "" {
+		name = DefaultName
+	}
+	if !validName.MatchString(name) {
+		return fmt.Errorf("service name %q: use letters, digits and _.@-", name)
+	}
+	switch in.OS {
+	case "linux":
+		path := in.UnitPath(name)
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			in.log("%s is not installed", path)
+			return nil
+		}
+		if err := in.run("systemctl", "disable", "--now", filepath.Base(path)); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		in.log("removed %s", path)
+		return in.run("systemctl", "daemon-reload")
+	case "windows":
+		// Stopping a service that is not running fails; deleting it is
+		// what matters.
+		in.run("sc.exe", "stop", name)
+		return in.run("sc.exe", "delete", name)
+	}
+	return fmt.Errorf("removing a service is not supported on %s", in.OS)
+}
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSystemdUnit(t *testing.T) {
+	unit, err := SystemdUnit(Spec{Binary: "/opt/run time/runtimebase", Config: "/etc/rb.yaml", User: "root", Home: "/srv/rb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`ExecStart="/opt/run time/runtimebase" daemon --config /etc/rb.yaml`,
+		"Environment=RUNTIMEBASE_HOME=/srv/rb",
+		"ReadWritePaths=/srv/rb",
+		"CapabilityBoundingSet=CAP_BPF ",
+		"ProtectSystem=strict",
+		"NoNewPrivileges=yes",
+	} {
+		if !strings.Contains(string(unit), want) {
+			t.Errorf("expected the unit to contain %q, got\n%s", want, unit)
+		}
+	}
+	for _, unwanted := range []string{"User=", "AmbientCapabilities", "StateDirectory"} {
+		if strings.Contains(string(unit), unwanted) {
+			t.Errorf("expected a root unit outside /var/lib without %s, got\n%s", unwanted, unit)
+		}
+	}
+
+	unit, err = SystemdUnit(Spec{Binary: "/usr/bin/runtimebase", User: "runtimebase", DynamicUser: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"User=runtimebase\nDynamicUser=yes", "StateDirectory=runtimebase", "AmbientCapabilities=CAP_BPF"} {
+		if !strings.Contains(string(unit), want) {
+			t.Errorf("expected the unit to contain %q, got\n%s", want, unit)
+		}
+	}
+
+	_, err = SystemdUnit(Spec{Name: "bad name", Binary: "runtimebase", Home: "data"})
+	if err == nil || strings.Count(err.Error(), "\n") != 2 {
+		t.Errorf("expected the name, binary and home to be rejected together, got %v", err)
+	}
+}
+
+func TestWindowsCommands(t *testing.T) {
+	spec := Spec{Binary: `C:\Program Files\runtimebase\runtimebase.exe`, Config: `C:\rb "co".yaml`, Home: `C:\ProgramData\runtimebase`}
+	commands, err := WindowsCommands(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, command := range commands {
+		lines = append(lines, strings.Join(command, " | "))
+	}
+	want := []string{
+		`sc.exe | create | runtimebase | binPath= | "C:\Program Files\runtimebase\runtimebase.exe" daemon --service runtimebase --config "C:\rb \"co\".yaml" | start= | auto | DisplayName= | runtimebase`,
+		`sc.exe | description | runtimebase | runtimebase runtime behavior baseline daemon`,
+		`sc.exe | failure | runtimebase | reset= | 86400 | actions= | restart/60000/restart/60000/restart/60000`,
+		`reg.exe | add | HKLM\SYSTEM\CurrentControlSet\Services\runtimebase | /v | Environment | /t | REG_MULTI_SZ | /d | RUNTIMEBASE_HOME=C:\ProgramData\runtimebase | /f`,
+	}
+	if got := strings.Join(lines, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("expected commands\n%s\ngot\n%s", strings.Join(want, "\n"), got)
+	}
+
+	spec.User, spec.Home = `NT AUTHORITY\LocalService`, ""
+	commands, err = WindowsCommands(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if create := commands[0]; len(commands) != 3 || create[len(create)-2] != "obj=" || create[len(create)-1] != spec.User {
+		t.Errorf("expected the service created as %s without an environment, got %q", spec.User, commands)
+	}
+
+	script, err := WindowsScript(Spec{Binary: `C:\rb\runtimebase.exe`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first, _, _ := strings.Cut(string(script), "\r\n"); first != `sc.exe create runtimebase binPath= "C:\rb\runtimebase.exe daemon --service runtimebase" start= auto DisplayName= runtimebase` {
+		t.Errorf("unexpected script line %s", first)
+	}
+	if _, err := WindowsCommands(Spec{Name: "bad name", Binary: `C:\rb.exe`}); err == nil {
+		t.Error("expected an invalid name rejected")
+	}
+}
+
+func TestWindowsQuote(t *testing.T) {
+	for arg, want := range map[string]string{
+		`C:\rb\rb.exe`:      `C:\rb\rb.exe`,
+		`C:\Program Files\`: `"C:\Program Files\\"`,
+		`say "hi"`:          `"say \"hi\""`,
+		`a\"b c`:            `"a\\\"b c"`,
+		``:                  `""`,
+	} {
+		if got := windowsQuote(arg); got != want {
+			t.Errorf("windowsQuote(%s) = %s, want %s", arg, got, want)
+		}
+	}
+}
+
+func TestInstaller(t *testing.T) {
+	dir := t.TempDir()
+	var commands []string
+	in := &Installer{OS: "linux", UnitDir: dir, Run: func(name string, args ...string) error {
+		commands = append(commands, name+" "+strings.Join(args, " "))
+		return nil
+	}}
+	if err := in.Install(Spec{Name: "rb", Binary: "/usr/bin/runtimebase"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "rb.service")); err != nil {
+		t.Fatal(err)
+	}
+	if err := in.Uninstall("rb"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "rb.service")); !os.IsNotExist(err) {
+		t.Errorf("expected the unit to be removed, got %v", err)
+	}
+	if err := in.Uninstall("rb"); err != nil {
+		t.Errorf("expected removing a missing service to succeed, got %v", err)
+	}
+	want := []string{
+		"systemctl daemon-reload",
+		"systemctl enable --now rb.service",
+		"systemctl disable --now rb.service",
+		"systemctl daemon-reload",
+	}
+	if strings.Join(commands, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected commands\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(commands, "\n"))
+	}
+
+	commands = nil
+	in.OS = "windows"
+	if err := in.Install(Spec{Name: "rb", Binary: `C:\rb.exe`}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := in.Uninstall("rb"); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{
+		`sc.exe create rb binPath= C:\rb.exe daemon --service rb start= auto DisplayName= rb`,
+		"sc.exe description rb runtimebase runtime behavior baseline daemon",
+		"sc.exe failure rb reset= 86400 actions= restart/60000/restart/60000/restart/60000",
+		"sc.exe start rb",
+		"sc.exe stop rb",
+		"sc.exe delete rb",
+	}
+	if strings.Join(commands, "\n") != strings.Join(want, "\n") {
+		t.Errorf("expected commands\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(commands, "\n"))
+	}
+	if err := (&Installer{OS: "plan9"}).Install(Spec{Binary: "/bin/rb"}, false); err == nil {
+		t.Error("expected unsupported systems to be rejected")
+	}
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// WindowsCommands returns the commands registering the daemon described
+// by s with the Service Control Manager: created to start at boot as
+// s.User, or else LocalSystem, restarted a minute after it fails, with
+// RUNTIMEBASE_HOME set to s.Home in the service's environment. s.User must
+// be an account without a password, such as NT AUTHORITY\LocalService or
+// the virtual account NT SERVICE\<name>. The daemon is started with
+// --service, to report to the Service Control Manager (see Run). Windows
+// paths are taken as they are.
+func WindowsCommands(s Spec) ([][]string, error) {
+	if s.Name == "" {
+		s.Name = DefaultName
+	}
+	if s.User == "root" {
+		s.User = ""
+	}
+	if !validName.MatchString(s.Name) {
+		return nil, fmt.Errorf("service name %q: use letters, digits and _.@-", s.Name)
+	}
+	if s.Binary == "" {
+		return nil, errors.New("binary: path of the runtimebase executable required")
+	}
+
+	command := windowsQuote(s.Binary) + " daemon --service " + s.Name
+	if s.Config != "" {
+		command += " --config " + windowsQuote(s.Config)
+	}
+	create := []string{"sc.exe", "create", s.Name, "binPath=", command, "start=", "auto", "DisplayName=", s.Name}
+	if s.User != "" {
+		create = append(create, "obj=", s.User)
+	}
+	commands := [][]string{
+		create,
+		{"sc.exe", "description", s.Name, "runtimebase runtime behavior baseline daemon"},
+		{"sc.exe", "failure", s.Name, "reset=", "86400", "actions=", "restart/60000/restart/60000/restart/60000"},
+	}
+	if s.Home != "" {
+		commands = append(commands, []string{
+			"reg.exe", "add", `HKLM\SYSTEM\CurrentControlSet\Services\` + s.Name,
+			"/v", "Environment", "/t", "REG_MULTI_SZ", "/d", "RUNTIMEBASE_HOME=" + s.Home, "/f",
+		})
+	}
+	return commands, nil
+}
+
+// WindowsScript renders WindowsCommands as a script of command lines.
+func WindowsScript(s Spec) ([]byte, error) {
+	commands, err := WindowsCommands(s)
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	for _, command := range commands {
+		quoted := make([]string, len(command))
+		for i, arg := range command {
+			quoted[i] = windowsQuote(arg)
+		}
+		b.WriteString(strings.Join(quoted, " ") + "\r\n")
+	}
+	return []byte(b.String()), nil
+}
+
+// windowsQuote quotes s as one argument of a Windows command line, as
+// CommandLineToArgvW splits them: in double quotes when it contains
+// spaces, tabs or quotes, with quotes and the backslashes before them
+// escaped.
+func windowsQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"") {
+		return s
+	}
+	var b strings.Builder
+	b.WriteByte('"')
+	slashes := 0
+	for _, c := range s {
+		switch c {
+		case '\\':
+			slashes++
+		case '"':
+			b.WriteString(strings.Repeat(`\`, slashes+1))
+			slashes = 0
+		default:
+			slashes = 0
+		}
+		b.WriteRune(c)
+	}
+	b.WriteString(strings.Repeat(`\`, slashes))
+	b.WriteByte('"')
+	return b.String()
+}
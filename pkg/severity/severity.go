@@ -0,0 +1,223 @@
+// Package severity maps internal anomaly scores to a configurable severity
+// taxonomy, so findings can use an organisation's existing scale.
+package severity
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Built-in severity names used inside runtimebase. They are translated
+// through the active taxonomy before being shown to users.
+const (
+	Low      = "LOW"
+	Medium   = "MEDIUM"
+	High     = "HIGH"
+	Critical = "CRITICAL"
+)
+
+// builtinScores are the internal scores (0-100) of the built-in severities.
+var builtinScores = map[string]float64{
+	Low:      25,
+	Medium:   60,
+	High:     80,
+	Critical: 95,
+}
+
+// Level is one severity in a taxonomy.
+type Level struct {
+	Name string `yaml:"name"`
+	// Value is the numeric level reported alongside the name.
+	Value int `yaml:"value"`
+	// MinScore is the internal score (0-100) a finding must exceed to
+	// receive this level. The lowest level applies to every score.
+	MinScore float64 `yaml:"min_score"`
+}
+
+// Taxonomy is an ordered set of severity levels.
+type Taxonomy struct {
+	levels []Level // ascending by MinScore
+}
+
+// New builds a taxonomy from levels in any order.
+func New(levels []Level) (*Taxonomy, error) {
+	if len(levels) == 0 {
+		return nil, fmt.Errorf("severity taxonomy needs at least one level")
+	}
+	sorted := append([]Level(nil), levels...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].MinScore < sorted[j].MinScore })
+
+	names := make(map[string]bool)
+	for i, level := range sorted {
+		if level.Name == "" {
+			return nil, fmt.Errorf("severity level %d has no name", i)
+		}
+		if names[strings.ToUpper(level.Name)] {
+			return nil, fmt.Errorf("duplicate severity level %q", level.Name)
+		}
+		names[strings.ToUpper(level.Name)] = true
+		if level.MinScore < 0 || level.MinScore > 100 {
+			return nil, fmt.Errorf("severity level %q: min_score must be within 0-100", level.Name)
+		}
+		if i > 0 && level.MinScore == sorted[i-1].MinScore {
+			return nil, fmt.Errorf("severity levels %q and %q have the same min_score", sorted[i-1].Name, level.Name)
+		}
+	}
+	return &Taxonomy{levels: sorted}, nil
+}
+
+// Default returns the built-in LOW/MEDIUM/HIGH/CRITICAL taxonomy.
+func Default() *Taxonomy {
+	t, _ := New([]Level{
+		{Name: Low, Value: 1, MinScore: 0},
+		{Name: Medium, Value: 2, MinScore: 50},
+		{Name: High, Value: 3, MinScore: 75},
+		{Name: Critical, Value: 4, MinScore: 90},
+	})
+	return t
+}
+
+// Levels returns the taxonomy's levels, lowest first.
+func (t *Taxonomy) Levels() []Level {
+	return append([]Level(nil), t.levels...)
+}
+
+// ForScore returns the level for an internal score.
+func (t *Taxonomy) ForScore(score float64) Level {
+	level := t.levels[0]
+	for _, l := range t.levels[1:] {
+		if score > l.MinScore {
+			level = l
+		}
+	}
+	return level
+}
+
+// Lookup finds a level by name, case-insensitively. Built-in names that are
+// not part of the taxonomy resolve through their internal score.
+func (t *Taxonomy) Lookup(name string) (Level, bool) {
+	for _, l := range t.levels {
+		if strings.EqualFold(l.Name, name) {
+			return l, true
+		}
+	}
+	if score, ok := builtinScores[strings.ToUpper(name)]; ok {
+		return t.ForScore(score), true
+	}
+	return Level{}, false
+}
+
+var current atomic.Pointer[Taxonomy]
+
+func init() {
+	current.Store(Default())
+}
+
+// SetCurrent installs the taxonomy used by Label, Of and friends.
+func SetCurrent(t *Taxonomy) {
+	current.Store(t)
+}
+
+// Current returns the active taxonomy.
+func Current() *Taxonomy {
+	return current.Load()
+}
+
+// Label translates a built-in severity into the active taxonomy's name.
+func Label(builtin string) string {
+	if level, ok := Current().Lookup(builtin); ok {
+		return level.Name
+	}
+	return builtin
+}
+
+// Of returns the numeric value of a severity name in the active taxonomy,
+// or 0 if it is unknown.
+func Of(name string) int {
+	level, _ := Current().Lookup(name)
+	return level.Value
+}
+
+// Score returns the internal score (0-100) of a severity name: the built-in
+// score of built-in names and, for other levels of the active taxonomy, the
+// score at which the level starts. Unknown names score 0.
+func Score(name string) float64 {
+	if score, ok := builtinScores[strings.ToUpper(name)]; ok {
+		return score
+	}
+	level, _ := Current().Lookup(name)
+	return level.MinScore
+}
+
+// Known reports whether name is a severity in the active taxonomy or a
+// built-in severity.
+func Known(name string) bool {
+	_, ok := Current().Lookup(name)
+	return ok
+}
+
+// AtLeast reports whether severity name ranks at or above threshold under
+// the active taxonomy. Ranking follows MinScore, so taxonomies whose numeric
+// values run in either direction (P1 most severe, or 4 most severe) compare
+// correctly. Unknown names rank lowest.
+func AtLeast(name, threshold string) bool {
+	t := Current()
+	return t.rank(name) >= t.rank(threshold)
+}
+
+// Raise returns the severity one level above name in the active taxonomy,
+// or the highest level when name is already the highest.
+func Raise(name string) string {
+	t := Current()
+	i := min(t.rank(name)+1, len(t.levels)-1)
+	return t.levels[i].Name
+}
+
+// Lower returns the severity one level below name in the active taxonomy,
+// or the lowest level when name is already the lowest or unknown.
+func Lower(name string) string {
+	t := Current()
+	i := max(t.rank(name)-1, 0)
+	return t.levels[i].Name
+}
+
+func (t *Taxonomy) rank(name string) int {
+	level, ok := t.Lookup(name)
+	if !ok {
+		return -1
+	}
+	for i, l := range t.levels {
+		if l.Name == level.Name {
+			return i
+		}
+	}
+	return -1
+}
+
+// FromZScore converts a z-score into an internal score. The mapping is
+// piecewise linear so that the default taxonomy assigns MEDIUM above 2σ,
+// HIGH above 3σ and CRITICAL above 5σ; deviations below the mean score 0.
+func FromZScore(z float64) float64 {
+	switch {
+	case z <= 0:
+		return 0
+	case z <= 2:
+		return z * 25
+	case z <= 3:
+		return 50 + (z-2)*25
+	case z <= 5:
+		return 75 + (z-3)*7.5
+	}
+	score := 90 + (z-5)*2
+	if score > 100 {
+		score = 100
+	}
+	return score
+}
+
+// ForZScore returns the active taxonomy's severity name for a z-score.
+func ForZScore(z float64) string {
+	return Current().ForScore(FromZScore(z)).Name
+}
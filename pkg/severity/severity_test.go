@@ -0,0 +1,70 @@
+package severity
+
+import "testing"
+
+func TestDefaultTaxonomyMatchesZScoreBands(t *testing.T) {
+	tests := []struct {
+		z    float64
+		want string
+	}{
+		{-4, Low}, {1.5, Low}, {2, Low}, {2.5, Medium}, {3, Medium},
+		{3.5, High}, {5, High}, {5.1, Critical}, {50, Critical},
+	}
+	taxonomy := Default()
+	for _, tt := range tests {
+		if got := taxonomy.ForScore(FromZScore(tt.z)).Name; got != tt.want {
+			t.Errorf("z=%.1f: expected %s, got %s", tt.z, tt.want, got)
+		}
+	}
+}
+
+func TestCustomTaxonomy(t *testing.T) {
+	custom, err := New([]Level{
+		{Name: "P1", Value: 1, MinScore: 85},
+		{Name: "P2", Value: 2, MinScore: 60},
+		{Name: "P3", Value: 3, MinScore: 0},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetCurrent(custom)
+	defer SetCurrent(Default())
+
+	if got := Label(Critical); got != "P1" {
+		t.Errorf("expected CRITICAL to map to P1, got %s", got)
+	}
+	if got := Label(Low); got != "P3" {
+		t.Errorf("expected LOW to map to P3, got %s", got)
+	}
+	if !AtLeast("P1", High) || AtLeast("P3", High) {
+		t.Error("expected ranking to follow min_score, not numeric value")
+	}
+	if Score("P2") != 60 || Score(High) != 80 || Score("bogus") != 0 {
+		t.Errorf("unexpected scores P2=%v HIGH=%v bogus=%v", Score("P2"), Score(High), Score("bogus"))
+	}
+	if _, err := New([]Level{{Name: "A"}, {Name: "a", MinScore: 10}}); err == nil {
+		t.Error("expected duplicate names to be rejected")
+	}
+}
+
+func TestRaise(t *testing.T) {
+	tests := []struct{ from, want string }{
+		{Low, Medium}, {Medium, High}, {High, Critical}, {Critical, Critical}, {"bogus", Low},
+	}
+	for _, tt := range tests {
+		if got := Raise(tt.from); got != tt.want {
+			t.Errorf("Raise(%s): expected %s, got %s", tt.from, tt.want, got)
+		}
+	}
+}
+
+func TestLower(t *testing.T) {
+	tests := []struct{ from, want string }{
+		{Critical, High}, {High, Medium}, {Medium, Low}, {Low, Low}, {"bogus", Low},
+	}
+	for _, tt := range tests {
+		if got := Lower(tt.from); got != tt.want {
+			t.Errorf("Lower(%s): expected %s, got %s", tt.from, tt.want, got)
+		}
+	}
+}
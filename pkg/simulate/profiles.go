@@ -0,0 +1,134 @@
+package simulate
+
+import (
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+// Profile is a normal workload: a main process and the operations it
+// performs, drawn in proportion to their weights.
+type Profile struct {
+	Name        string
+	Description string
+	Process     string
+	Exe         string
+	PID         int
+	DataDir     string // where the workload keeps its data, for file churn scenarios
+	Operations  []Operation
+}
+
+// Operation is one kind of event a profile produces.
+type Operation struct {
+	Weight      int
+	Type        string // syscall, file, network or process
+	Syscall     string
+	Path        string
+	Destination string // host:port
+	Bytes       int64  // typical bytes moved, varied by ±25%
+}
+
+// Profiles are the built-in workloads by name.
+var Profiles = map[string]*Profile{
+	"web": {
+		Name:        "web",
+		Description: "nginx serving static files and proxying to an API backend",
+		Process:     "nginx",
+		Exe:         "/usr/sbin/nginx",
+		PID:         1200,
+		DataDir:     "/var/www/html",
+		Operations: []Operation{
+			{Weight: 30, Type: "syscall", Syscall: "epoll_wait"},
+			{Weight: 20, Type: "syscall", Syscall: "recvfrom"},
+			{Weight: 20, Type: "syscall", Syscall: "writev"},
+			{Weight: 10, Type: "syscall", Syscall: "openat"},
+			{Weight: 10, Type: "syscall", Syscall: "close"},
+			{Weight: 8, Type: "file", Path: "/var/www/html/index.html", Bytes: 12 << 10},
+			{Weight: 4, Type: "file", Path: "/var/www/html/app.js", Bytes: 180 << 10},
+			{Weight: 10, Type: "file", Path: "/var/log/nginx/access.log", Bytes: 200},
+			{Weight: 6, Type: "network", Destination: "10.0.0.20:8080", Bytes: 4 << 10},
+			{Weight: 1, Type: "network", Destination: "10.0.0.53:53", Bytes: 120},
+		},
+	},
+	"db": {
+		Name:        "db",
+		Description: "PostgreSQL serving queries and writing its WAL",
+		Process:     "postgres",
+		Exe:         "/usr/lib/postgresql/16/bin/postgres",
+		PID:         800,
+		DataDir:     "/var/lib/postgresql/data/base",
+		Operations: []Operation{
+			{Weight: 25, Type: "syscall", Syscall: "pread64"},
+			{Weight: 15, Type: "syscall", Syscall: "pwrite64"},
+			{Weight: 15, Type: "syscall", Syscall: "recvfrom"},
+			{Weight: 15, Type: "syscall", Syscall: "sendto"},
+			{Weight: 5, Type: "syscall", Syscall: "fdatasync"},
+			{Weight: 12, Type: "file", Path: "/var/lib/postgresql/data/base/16384/2619", Bytes: 8 << 10},
+			{Weight: 6, Type: "file", Path: "/var/lib/postgresql/data/pg_wal/000000010000000000000001", Bytes: 16 << 10},
+			{Weight: 8, Type: "network", Destination: "10.0.0.21:5432", Bytes: 2 << 10},
+		},
+	},
+}
+
+// ProfileNames returns the names of the built-in profiles, sorted.
+func ProfileNames() []string {
+	names := make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// event draws one normal event at t.
+func (p *Profile) event(rng *rand.Rand, t time.Time) detect.SystemEvent {
+	total := 0
+	for _, op := range p.Operations {
+		total += op.Weight
+	}
+	n := rng.Intn(total)
+	op := p.Operations[len(p.Operations)-1]
+	for _, candidate := range p.Operations {
+		if n < candidate.Weight {
+			op = candidate
+			break
+		}
+		n -= candidate.Weight
+	}
+	e := p.newEvent(op.Type, t)
+	e.Bytes = jitter(rng, op.Bytes)
+	switch op.Type {
+	case "syscall":
+		e.Data["syscall"] = op.Syscall
+	case "file":
+		e.Path = op.Path
+	case "network":
+		setDestination(e, op.Destination)
+	}
+	return e
+}
+
+// newEvent returns an event of the profile's main process.
+func (p *Profile) newEvent(eventType string, t time.Time) detect.SystemEvent {
+	return detect.SystemEvent{
+		Type:        eventType,
+		Timestamp:   t,
+		ProcessName: p.Process,
+		PID:         p.PID,
+		Data:        map[string]interface{}{"source": "simulate"},
+	}
+}
+
+// setDestination fills in the network fields collectors set for a
+// connection to dest.
+func setDestination(e detect.SystemEvent, dest string) {
+	e.Data["destination"] = dest
+	if host, port, err := net.SplitHostPort(dest); err == nil {
+		e.Data["ip"] = host
+		e.Data["port"], _ = strconv.Atoi(port)
+	}
+}
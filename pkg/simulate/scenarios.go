@@ -0,0 +1,154 @@
+package simulate
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+// Scenario is an attack injected into a workload.
+type Scenario struct {
+	Name        string
+	Description string
+	// Events returns the scenario's events for a workload, starting at.
+	Events func(rng *rand.Rand, p *Profile, at time.Time) []detect.SystemEvent
+}
+
+// Scenarios are the built-in attacks by name.
+var Scenarios = map[string]*Scenario{
+	"reverse-shell": {
+		Name:        "reverse-shell",
+		Description: "the workload spawns a shell whose standard streams are a TCP connection to the attacker",
+		Events:      reverseShell,
+	},
+	"cryptominer": {
+		Name:        "cryptominer",
+		Description: "a miner is dropped in /tmp and keeps a stratum connection to a mining pool",
+		Events:      cryptominer,
+	},
+	"ransomware": {
+		Name:        "ransomware",
+		Description: "a process reads every file in the data directory, writes an encrypted copy and deletes the original",
+		Events:      ransomware,
+	},
+}
+
+// ScenarioNames returns the names of the built-in scenarios, sorted.
+func ScenarioNames() []string {
+	names := make([]string, 0, len(Scenarios))
+	for name := range Scenarios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// attacker is the process a scenario runs as.
+type attacker struct {
+	Profile
+	t time.Time
+}
+
+func newAttacker(p *Profile, name, exe string, pid int, at time.Time) *attacker {
+	return &attacker{Profile: Profile{Process: name, Exe: exe, PID: pid}, t: at}
+}
+
+// next returns an event of the attacker's process, advancing its clock by
+// step.
+func (a *attacker) next(eventType string, step time.Duration) detect.SystemEvent {
+	e := a.newEvent(eventType, a.t)
+	a.t = a.t.Add(step)
+	return e
+}
+
+func (a *attacker) exec(parent *Profile, step time.Duration) detect.SystemEvent {
+	e := a.next("process", step)
+	e.Path = a.Exe
+	e.Data["action"] = "exec"
+	e.Data["ppid"] = parent.PID
+	return e
+}
+
+func (a *attacker) syscall(name string, step time.Duration) detect.SystemEvent {
+	e := a.next("syscall", step)
+	e.Data["syscall"] = name
+	return e
+}
+
+func (a *attacker) connect(dest string, bytes int64, step time.Duration) detect.SystemEvent {
+	e := a.next("network", step)
+	e.Data["action"] = "connect"
+	e.Bytes = bytes
+	setDestination(e, dest)
+	return e
+}
+
+func (a *attacker) file(path string, bytes int64, step time.Duration) detect.SystemEvent {
+	e := a.next("file", step)
+	e.Path = path
+	e.Bytes = bytes
+	return e
+}
+
+func reverseShell(rng *rand.Rand, p *Profile, at time.Time) []detect.SystemEvent {
+	sh := newAttacker(p, "sh", "/bin/sh", 31337, at)
+	events := []detect.SystemEvent{
+		sh.exec(p, 50*time.Millisecond),
+		sh.syscall("socket", time.Millisecond),
+		sh.connect("203.0.113.7:4444", 0, time.Millisecond),
+		sh.syscall("dup2", time.Millisecond),
+		sh.syscall("dup2", time.Millisecond),
+		sh.syscall("dup2", 2*time.Second),
+	}
+	// Hands-on-keyboard reconnaissance over the shell.
+	for _, cmd := range []struct{ name, exe, read string }{
+		{"id", "/usr/bin/id", ""},
+		{"cat", "/bin/cat", "/etc/passwd"},
+		{"uname", "/bin/uname", ""},
+	} {
+		child := newAttacker(p, cmd.name, cmd.exe, sh.PID+1+len(events), sh.t)
+		events = append(events, child.exec(&sh.Profile, 10*time.Millisecond))
+		if cmd.read != "" {
+			events = append(events, child.file(cmd.read, 2<<10, 0))
+		}
+		events = append(events, sh.connect("203.0.113.7:4444", jitter(rng, 512), time.Duration(1+rng.Intn(5))*time.Second))
+	}
+	return events
+}
+
+func cryptominer(rng *rand.Rand, p *Profile, at time.Time) []detect.SystemEvent {
+	dropper := newAttacker(p, "curl", "/usr/bin/curl", 40001, at)
+	miner := newAttacker(p, "kworker", "/tmp/.x/xmrig", 40002, at.Add(3*time.Second))
+	events := []detect.SystemEvent{
+		dropper.exec(p, 100*time.Millisecond),
+		dropper.connect("198.51.100.10:443", 3<<20, 2*time.Second),
+		dropper.file(miner.Exe, 3<<20, 0),
+		miner.exec(&dropper.Profile, 500*time.Millisecond),
+	}
+	// Periodic share submissions to the pool; the miner itself is CPU
+	// bound and otherwise quiet.
+	for i := 0; i < 20; i++ {
+		events = append(events,
+			miner.connect("198.51.100.23:3333", jitter(rng, 300), 0),
+			miner.syscall("sched_yield", time.Duration(5+rng.Intn(10))*time.Second))
+	}
+	return events
+}
+
+func ransomware(rng *rand.Rand, p *Profile, at time.Time) []detect.SystemEvent {
+	enc := newAttacker(p, "enc", "/tmp/enc", 50001, at)
+	events := []detect.SystemEvent{enc.exec(p, 200*time.Millisecond)}
+	for i := 0; i < 200; i++ {
+		path := fmt.Sprintf("%s/file-%03d", p.DataDir, i)
+		size := jitter(rng, 4<<20)
+		read, write := enc.file(path, size, 5*time.Millisecond), enc.file(path+".locked", size, 5*time.Millisecond)
+		read.Data["action"], write.Data["action"] = "read", "write"
+		rename, unlink := enc.syscall("renameat2", time.Millisecond), enc.syscall("unlinkat", 20*time.Millisecond)
+		unlink.Path = path
+		events = append(events, read, write, rename, unlink)
+	}
+	return events
+}
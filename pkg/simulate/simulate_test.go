@@ -0,0 +1,113 @@
+package simulate
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/pipeline"
+	"github.com/hallucinaut/runtimebase/pkg/storage"
+)
+
+var start = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func generate(t *testing.T, g Generator) []detect.SystemEvent {
+	t.Helper()
+	events, err := g.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func TestGenerateDeterministic(t *testing.T) {
+	g := Generator{Start: start, Duration: time.Minute, Seed: 7, Inject: []Injection{{Scenario: "ransomware", At: 30 * time.Second}}}
+	a, b := generate(t, g), generate(t, g)
+	if !reflect.DeepEqual(a, b) {
+		t.Fatal("expected the same stream for the same seed")
+	}
+	for i := 1; i < len(a); i++ {
+		if a[i].Timestamp.Before(a[i-1].Timestamp) {
+			t.Fatalf("event %d is out of order", i)
+		}
+	}
+	injected := 0
+	for _, e := range a {
+		if ScenarioOf(e) == "ransomware" {
+			injected++
+		}
+	}
+	if injected == 0 || injected == len(a) {
+		t.Fatalf("expected a mix of normal and injected events, got %d of %d injected", injected, len(a))
+	}
+}
+
+func TestParseInjections(t *testing.T) {
+	got, err := ParseInjections("reverse-shell@5m, cryptominer", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Injection{{"reverse-shell", 5 * time.Minute}, {"cryptominer", time.Minute}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if _, err := ParseInjections("rootkit", 0); err == nil {
+		t.Error("expected an unknown scenario to be rejected")
+	}
+}
+
+// TestDetectionAccuracy learns a clean stream of every profile, then checks
+// that every scenario is detected and that a fresh clean stream raises no
+// false positives.
+func TestDetectionAccuracy(t *testing.T) {
+	for _, profile := range ProfileNames() {
+		t.Run(profile, func(t *testing.T) {
+			store, err := storage.Open(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			run := func(events []detect.SystemEvent, stages ...pipeline.Stage) []*pipeline.Record {
+				p := &pipeline.Pipeline{Stages: append([]pipeline.Stage{pipeline.StaticRoute(profile)}, stages...)}
+				var records []*pipeline.Record
+				for i := range events {
+					r := &pipeline.Record{Event: &events[i]}
+					if err := p.Handle(context.Background(), r); err != nil {
+						t.Fatal(err)
+					}
+					records = append(records, r)
+				}
+				if err := p.Flush(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+				return records
+			}
+
+			run(generate(t, Generator{Profile: profile, Start: start, Duration: time.Hour, Seed: 1}), pipeline.Learn(store, time.Minute, 0, nil))
+
+			var injections []Injection
+			for i, name := range ScenarioNames() {
+				injections = append(injections, Injection{Scenario: name, At: time.Duration(i+1) * 5 * time.Minute})
+			}
+			attack := generate(t, Generator{Profile: profile, Start: start.Add(2 * time.Hour), Duration: 20 * time.Minute, Seed: 2, Inject: injections})
+			detected := make(map[string]bool)
+			for _, r := range run(attack, pipeline.Detect(store, time.Hour, slog.Default())) {
+				if len(r.Anomalies) == 0 {
+					continue
+				}
+				scenario := ScenarioOf(*r.Event)
+				if scenario == "" {
+					t.Errorf("false positive on normal event %+v: %s", *r.Event, r.Anomalies[0].Evidence)
+				}
+				detected[scenario] = true
+			}
+			for _, name := range ScenarioNames() {
+				if !detected[name] {
+					t.Errorf("scenario %s was not detected", name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/secrets"
+)
+
+// DefaultKeyEnv is the environment variable holding the baseline encryption
+// key when no other key source is configured.
+const DefaultKeyEnv = "RUNTIMEBASE_ENCRYPTION_KEY"
+
+// encryptedMagic prefixes baseline files encrypted at rest.
+var encryptedMagic = []byte("RBENC1\n")
+
+// sealedLinePrefix prefixes the base64 encoded, encrypted lines of JSON
+// lines files, such as histories, stored at rest.
+var sealedLinePrefix = []byte("enc:")
+
+// ErrEncrypted is returned when reading an encrypted baseline from a store
+// without a key.
+var ErrEncrypted = errors.New("baseline is encrypted and no key is configured")
+
+// KeyProvider supplies the AES-256 key used to encrypt baselines at rest.
+type KeyProvider interface {
+	Key() ([]byte, error)
+}
+
+// EnvKey reads a base64 or hex encoded key from the named environment
+// variable.
+type EnvKey string
+
+// Key implements KeyProvider.
+func (e EnvKey) Key() ([]byte, error) {
+	value := os.Getenv(string(e))
+	if value == "" {
+		return nil, fmt.Errorf("%s is not set", string(e))
+	}
+	return DecodeKey(value)
+}
+
+// CommandKey runs a KMS plugin command and reads a base64 or hex encoded key
+// from its standard output, e.g.
+//
+//	["sh", "-c", "aws kms decrypt --ciphertext-blob fileb://key.enc --query Plaintext --output text"]
+type CommandKey []string
+
+// Key implements KeyProvider.
+func (c CommandKey) Key() ([]byte, error) {
+	if len(c) == 0 {
+		return nil, errors.New("empty key command")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c[0], c[1:]...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("key command %s: %w: %s", c[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return DecodeKey(string(out))
+}
+
+// SecretKey reads the key through a secret reference such as
+// "vault:secret/data/runtimebase#key" (see package secrets). The secret may
+// be the raw 32 bytes, as returned by KMS, or their base64 or hex encoding.
+type SecretKey string
+
+// Key implements KeyProvider.
+func (r SecretKey) Key() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	secret, err := secrets.Resolve(ctx, string(r))
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) == 32 {
+		return secret, nil
+	}
+	return DecodeKey(string(secret))
+}
+
+// DecodeKey decodes a 32-byte key written as base64 or hex.
+func DecodeKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(key) != 32 {
+		if hexKey, hexErr := hex.DecodeString(s); hexErr == nil {
+			key, err = hexKey, nil
+		}
+	}
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes, base64 or hex encoded")
+	}
+	return key, nil
+}
+
+// Cipher encrypts baseline documents with AES-256-GCM. The baseline name is
+// authenticated with each document, so an encrypted file cannot be passed
+// off as another baseline.
+type Cipher struct {
+	aead cipher.AEAD
+	mac  []byte // key of Tag, derived from the encryption key
+}
+
+// NewCipher returns a cipher using a 32-byte key.
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("runtimebase audit redaction"))
+	return &Cipher{aead: aead, mac: mac.Sum(nil)}, nil
+}
+
+// Tag returns a short keyed hash of s, which identifies s, such as a stat
+// key, without revealing it to anyone lacking the key.
+func (c *Cipher) Tag(s string) string {
+	mac := hmac.New(sha256.New, c.mac)
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// Seal encrypts the document of the named baseline.
+func (c *Cipher) Seal(name string, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append([]byte{}, encryptedMagic...)
+	out = append(out, nonce...)
+	return c.aead.Seal(out, nonce, plaintext, []byte(name)), nil
+}
+
+// Open decrypts a document sealed for the named baseline.
+func (c *Cipher) Open(name string, data []byte) ([]byte, error) {
+	data, ok := bytes.CutPrefix(data, encryptedMagic)
+	if !ok || len(data) < c.aead.NonceSize() {
+		return nil, errors.New("not an encrypted baseline")
+	}
+	nonce, ciphertext := data[:c.aead.NonceSize()], data[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("decrypting baseline %s: wrong key or corrupted file", name)
+	}
+	return plaintext, nil
+}
+
+// IsEncrypted reports whether a stored baseline document is encrypted.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
+
+// WithEncryption returns a view of the store that encrypts baselines it
+// writes with the key from kp. Plaintext baselines written before
+// encryption was enabled stay readable and are encrypted on their next
+// save.
+func (s *Store) WithEncryption(kp KeyProvider) (*Store, error) {
+	key, err := kp.Key()
+	if err != nil {
+		return nil, fmt.Errorf("loading encryption key: %w", err)
+	}
+	c, err := NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	view := *s
+	view.Cipher = c
+	return &view, nil
+}
+
+// seal encrypts a baseline document if the store has a cipher.
+func (s *Store) seal(name string, data []byte) ([]byte, error) {
+	if s.Cipher == nil {
+		return data, nil
+	}
+	return s.Cipher.Seal(s.Qualify(name), data)
+}
+
+// unseal decrypts a baseline document if it is encrypted.
+func (s *Store) unseal(name string, data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+	if s.Cipher == nil {
+		return nil, fmt.Errorf("%w: %s", ErrEncrypted, name)
+	}
+	return s.Cipher.Open(s.Qualify(name), data)
+}
+
+// SealCapture encrypts a capture of the named baseline's raw events if
+// the store has a cipher.
+func (s *Store) SealCapture(name string, data []byte) ([]byte, error) {
+	return s.seal("capture:"+name, data)
+}
+
+// OpenCapture decrypts a capture of the named baseline's raw events if it
+// is encrypted, and returns plaintext captures as they are.
+func (s *Store) OpenCapture(name string, data []byte) ([]byte, error) {
+	return s.unseal("capture:"+name, data)
+}
+
+// encodeLine marshals v as a line of the baseline's file of the given kind
+// ("history", "scores" or "series"), encrypted if the store has a cipher.
+// The kind is authenticated with the line, so lines cannot be moved
+// between files.
+func (s *Store) encodeLine(kind, name string, v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || s.Cipher == nil {
+		return append(data, '\n'), err
+	}
+	if data, err = s.seal(kind+":"+name, data); err != nil {
+		return nil, err
+	}
+	line := append([]byte(nil), sealedLinePrefix...)
+	line = append(line, base64.StdEncoding.EncodeToString(data)...)
+	return append(line, '\n'), nil
+}
+
+// decodeLine unmarshals a line written by encodeLine into v. It reports
+// false for malformed lines, which readers skip, and fails for encrypted
+// lines it cannot decrypt.
+func (s *Store) decodeLine(kind, name string, line []byte, v any) (bool, error) {
+	if encoded, ok := bytes.CutPrefix(line, sealedLinePrefix); ok {
+		data, err := base64.StdEncoding.DecodeString(string(encoded))
+		if err != nil || !IsEncrypted(data) {
+			return false, nil
+		}
+		if line, err = s.unseal(kind+":"+name, data); err != nil {
+			return false, err
+		}
+	}
+	return json.Unmarshal(line, v) == nil, nil
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+// indexName authenticates the encrypted index. It is not a valid baseline
+// name, so no baseline document can be swapped in for the index.
+const indexName = ".index"
+
+// indexMu serializes read-modify-write cycles of index files.
+var indexMu sync.Mutex
+
+func (s *Store) indexPath() string {
+	return filepath.Join(s.Dir, "index.json")
+}
+
+// Index returns the fleet-wide index of the patterns each baseline has
+// observed, kept in <dir>/index.json. It is built from the stored
+// baselines the first time it is needed.
+func (s *Store) Index() (*baseline.Index, error) {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	return s.loadIndex()
+}
+
+// RebuildIndex rebuilds the index from the stored baselines and saves it.
+func (s *Store) RebuildIndex() (*baseline.Index, error) {
+	unlock, err := s.lock(true)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	return s.rebuildIndex()
+}
+
+func (s *Store) loadIndex() (*baseline.Index, error) {
+	data, err := os.ReadFile(s.indexPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return s.rebuildIndex()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if data, err = s.unseal(indexName, data); err != nil {
+		return nil, err
+	}
+	x := baseline.NewIndex()
+	if err := json.Unmarshal(data, x); err != nil {
+		return nil, fmt.Errorf("decoding index: %w", err)
+	}
+	if x.Patterns == nil {
+		x.Patterns = make(map[string][]string)
+	}
+	return x, nil
+}
+
+func (s *Store) rebuildIndex() (*baseline.Index, error) {
+	names, err := s.ListBaselines()
+	if err != nil {
+		return nil, err
+	}
+	x := baseline.NewIndex()
+	for _, name := range names {
+		b, err := s.LoadBaseline(name)
+		if err != nil {
+			// Baselines this store cannot read, e.g. encrypted with a
+			// key it does not have, are left out rather than failing
+			// every caller.
+			continue
+		}
+		x.Update(b)
+	}
+	if s.ReadOnly {
+		return x, nil
+	}
+	return x, s.saveIndex(x)
+}
+
+func (s *Store) saveIndex(x *baseline.Index) error {
+	data, err := json.Marshal(x)
+	if err != nil {
+		return err
+	}
+	if data, err = s.seal(indexName, data); err != nil {
+		return err
+	}
+	return writeAtomic(s.indexPath(), data)
+}
+
+// updateIndex applies fn to the index and saves it.
+func (s *Store) updateIndex(fn func(*baseline.Index)) error {
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	x, err := s.loadIndex()
+	if err != nil {
+		return fmt.Errorf("updating index: %w", err)
+	}
+	fn(x)
+	if err := s.saveIndex(x); err != nil {
+		return fmt.Errorf("updating index: %w", err)
+	}
+	return nil
+}
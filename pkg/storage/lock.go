@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/hallucinaut/runtimebase/pkg/audit"
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+// ErrReadOnly is returned by the methods that would change a read-only
+// store.
+var ErrReadOnly = errors.New("store is read-only")
+
+// OpenReadOnly opens the store rooted at dir for reading only: it creates
+// nothing, takes no locks and refuses every change with ErrReadOnly, so
+// tools inspecting a store another process writes, or one on a read-only
+// mount, cannot disturb it.
+func OpenReadOnly(dir string) (*Store, error) {
+	if _, err := os.Stat(filepath.Join(dir, "baselines")); err != nil {
+		return nil, err
+	}
+	log := &audit.Log{Path: filepath.Join(dir, "audit", "audit.jsonl")}
+	return &Store{Dir: dir, Audit: log, Actor: "unknown", ReadOnly: true, root: dir}, nil
+}
+
+// Update applies fn to the named baseline, nil when it does not exist,
+// and saves the baseline fn returns unless that is nil, holding the
+// store's write lock throughout, so that changes other processes make in
+// between are not overwritten.
+func (s *Store) Update(name string, fn func(b *baseline.Baseline) (*baseline.Baseline, error)) error {
+	unlock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	b, _, err := s.load(name)
+	if errors.Is(err, ErrNotFound) {
+		b, err = nil, nil
+	}
+	if err != nil {
+		return err
+	}
+	if b, err = fn(b); err != nil || b == nil {
+		return err
+	}
+	return s.saveBaseline(b)
+}
+
+// lock takes the store's write lock: an advisory lock on <dir>/lock, shared
+// by the namespaces of the store, that every change takes, so processes
+// sharing a store (the CLI and the daemon, say) do not interleave their
+// writes. It waits for the lock unless wait is false, in which case it
+// fails with errLocked when another goroutine or process holds it.
+func (s *Store) lock(wait bool) (unlock func(), err error) {
+	if s.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	path := filepath.Join(s.rootDir(), "lock")
+	mu := processLock(path)
+	if !wait && !mu.TryLock() {
+		return nil, errLocked
+	} else if wait {
+		mu.Lock()
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err == nil {
+		if err = flock(f, wait); err != nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		mu.Unlock()
+		return nil, err
+	}
+	return func() {
+		f.Close() // releases the lock
+		mu.Unlock()
+	}, nil
+}
+
+// errLocked is returned by lock(false) when the lock is held.
+var errLocked = errors.New("store is locked")
+
+// processLocks serializes the goroutines of this process taking the lock
+// of a store, which advisory file locks alone do not reliably do.
+var processLocks sync.Map // lock path → *sync.Mutex
+
+func processLock(path string) *sync.Mutex {
+	mu, _ := processLocks.LoadOrStore(path, new(sync.Mutex))
+	return mu.(*sync.Mutex)
+}
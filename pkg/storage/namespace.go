@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+// DefaultNamespace is the namespace of the store Open returns: baselines
+// kept directly in the store directory.
+const DefaultNamespace = ""
+
+// WithNamespace returns a view of the store holding the baselines of another
+// namespace, so teams sharing one store can use the same baseline names
+// without colliding. Each namespace keeps its own baselines, history,
+// scores, archive and fleet index under <dir>/namespaces/<ns>; the audit
+// log is shared, with targets qualified by namespace. The namespace is
+// created if needed, unless the store is read-only.
+func (s *Store) WithNamespace(ns string) (*Store, error) {
+	view := *s
+	view.Namespace = ns
+	view.Dir = s.rootDir()
+	if ns == DefaultNamespace {
+		return &view, nil
+	}
+	if !validName.MatchString(ns) {
+		return nil, fmt.Errorf("invalid namespace %q", ns)
+	}
+	view.Dir = filepath.Join(view.Dir, "namespaces", ns)
+	if s.ReadOnly {
+		return &view, nil
+	}
+	for _, sub := range []string{"baselines", "history"} {
+		if err := os.MkdirAll(filepath.Join(view.Dir, sub), 0o700); err != nil {
+			return nil, fmt.Errorf("creating namespace %s: %w", ns, err)
+		}
+	}
+	return &view, nil
+}
+
+// ListNamespaces returns the names of all namespaces other than the
+// default one, sorted.
+func (s *Store) ListNamespaces() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.rootDir(), "namespaces"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && validName.MatchString(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Qualify returns name qualified by the store's namespace, e.g.
+// "team-a/web", as it appears in the audit log; names in the default
+// namespace are returned unchanged.
+func (s *Store) Qualify(name string) string {
+	if s.Namespace == DefaultNamespace {
+		return name
+	}
+	return s.Namespace + "/" + name
+}
+
+func (s *Store) rootDir() string {
+	if s.root != "" {
+		return s.root
+	}
+	return s.Dir
+}
+
+// claim stamps b with the store's namespace, refusing baselines that
+// belong to another one.
+func (s *Store) claim(b *baseline.Baseline) error {
+	if b.Namespace != DefaultNamespace && b.Namespace != s.Namespace {
+		return fmt.Errorf("baseline %s belongs to namespace %q, not %q", b.Name, b.Namespace, s.Namespace)
+	}
+	b.Namespace = s.Namespace
+	return nil
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/audit"
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+// PruneHistory keeps only the newest maxAnomalies anomaly records in the
+// baseline's history and returns how many were removed. Event records are
+// left untouched. It holds the store lock, as AppendHistory does, so records
+// appended while the history is rewritten are not lost.
+func (s *Store) PruneHistory(name string, maxAnomalies int) (int, error) {
+	if err := checkName(name); err != nil {
+		return 0, err
+	}
+	unlock, err := s.lock(true)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	data, err := os.ReadFile(s.historyPath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	type line struct {
+		raw     []byte
+		anomaly bool
+	}
+	var lines []line
+	anomalies := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var record Record
+		ok, err := s.decodeLine("history", name, scanner.Bytes(), &record)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			continue
+		}
+		isAnomaly := record.Kind == "anomaly"
+		if isAnomaly {
+			anomalies++
+		}
+		lines = append(lines, line{raw: append([]byte(nil), scanner.Bytes()...), anomaly: isAnomaly})
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	drop := anomalies - maxAnomalies
+	if drop <= 0 {
+		return 0, nil
+	}
+
+	var out []byte
+	dropped := 0
+	for _, l := range lines {
+		if l.anomaly && dropped < drop {
+			dropped++
+			continue
+		}
+		out = append(out, l.raw...)
+		out = append(out, '\n')
+	}
+	return dropped, writeAtomic(s.historyPath(name), out)
+}
+
+// LastActivity returns the later of the baseline's last update and its most
+// recent history record.
+func (s *Store) LastActivity(name string) (time.Time, error) {
+	b, err := s.LoadBaseline(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	last := b.UpdatedAt
+
+	info, err := os.Stat(s.historyPath(name))
+	if err == nil && info.ModTime().After(last) {
+		last = info.ModTime()
+	}
+	return last, nil
+}
+
+// Archive moves a baseline, its history, its scores and its stat history
+// out of the active set into <dir>/archive, where ListBaselines no longer
+// sees it.
+func (s *Store) Archive(name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	unlock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	archive := filepath.Join(s.Dir, "archive")
+	if err := os.MkdirAll(archive, 0o700); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(s.baselinePath(name)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrNotFound, name)
+		}
+		return err
+	}
+	if err := s.audit(audit.ActionArchive, name, nil); err != nil {
+		return err
+	}
+	if err := os.Rename(s.baselinePath(name), filepath.Join(archive, name+".json")); err != nil {
+		return err
+	}
+	err = os.Rename(s.historyPath(name), filepath.Join(archive, name+".jsonl"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	err = os.Rename(s.scoresPath(name), filepath.Join(archive, name+".scores.jsonl"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	err = os.Rename(s.seriesPath(name), filepath.Join(archive, name+".series.jsonl"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return s.updateIndex(func(x *baseline.Index) { x.Remove(name) })
+}
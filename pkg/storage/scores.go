@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ScorePoint is a baseline's behavior score over one interval.
+type ScorePoint struct {
+	Time     time.Time     `json:"time"` // end of the interval
+	Interval time.Duration `json:"interval_ns,omitempty"`
+	Score    float64       `json:"score"`
+	Events   int           `json:"events"`
+	// Release tags the point with the deployed version, so scores can be
+	// compared across releases.
+	Release string `json:"release,omitempty"`
+}
+
+func (s *Store) scoresPath(name string) string {
+	return filepath.Join(s.Dir, "scores", name+".jsonl")
+}
+
+// AppendScores appends behavior scores to the baseline's score series in
+// <dir>/scores/<name>.jsonl.
+func (s *Store) AppendScores(name string, points ...ScorePoint) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	unlock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := os.MkdirAll(filepath.Join(s.Dir, "scores"), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.scoresPath(name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, point := range points {
+		if point.Time.IsZero() {
+			point.Time = time.Now()
+		}
+		line, err := s.encodeLine("scores", name, point)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// Scores returns the baseline's behavior scores at or after since, in the
+// order they were recorded. Malformed lines are skipped.
+// Encrypted lines the store has no key for are an error.
+func (s *Store) Scores(name string, since time.Time) ([]ScorePoint, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.scoresPath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var points []ScorePoint
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var point ScorePoint
+		ok, err := s.decodeLine("scores", name, scanner.Bytes(), &point)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || point.Time.Before(since) {
+			continue
+		}
+		points = append(points, point)
+	}
+	return points, scanner.Err()
+}
+
+// Bucket averages score points into buckets of the given width, weighting
+// each point by its event count. Buckets without points are omitted.
+func Bucket(points []ScorePoint, width time.Duration) []ScorePoint {
+	if width <= 0 {
+		return points
+	}
+	var buckets []ScorePoint
+	var weights []float64
+	index := make(map[time.Time]int)
+	for _, p := range points {
+		end := p.Time.Truncate(width).Add(width)
+		i, ok := index[end]
+		if !ok {
+			i = len(buckets)
+			index[end] = i
+			buckets = append(buckets, ScorePoint{Time: end, Interval: width, Release: p.Release})
+			weights = append(weights, 0)
+		}
+		w := float64(max(p.Events, 1))
+		b := &buckets[i]
+		b.Score = (b.Score*weights[i] + p.Score*w) / (weights[i] + w)
+		weights[i] += w
+		b.Events += p.Events
+		if p.Release != "" {
+			b.Release = p.Release
+		}
+	}
+	return buckets
+}
@@ -0,0 +1,235 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+)
+
+// Default resolutions of stat history: raw buckets are kept for
+// DefaultRawStats, hourly ones for DefaultHourlyStats and daily ones
+// beyond.
+const (
+	DefaultRawStats    = 24 * time.Hour
+	DefaultHourlyStats = 30 * 24 * time.Hour
+)
+
+// StatPoint summarizes the samples of one statistic key over an interval
+// of event time: one closed bucket when raw, or all the key's buckets of
+// an hour or a day once downsampled. Intervals in which the key was not
+// active have no samples.
+type StatPoint struct {
+	Key     string        `json:"key"`
+	Start   time.Time     `json:"start"`
+	Width   time.Duration `json:"width_ns"`
+	Samples int           `json:"samples"`
+	Sum     float64       `json:"sum"`
+	Min     float64       `json:"min"`
+	Max     float64       `json:"max"`
+}
+
+// Mean returns the mean sample of the point.
+func (p StatPoint) Mean() float64 {
+	if p.Samples == 0 {
+		return 0
+	}
+	return p.Sum / float64(p.Samples)
+}
+
+// merge folds other, of the same key, into p.
+func (p StatPoint) merge(other StatPoint) StatPoint {
+	if p.Samples == 0 {
+		p.Min, p.Max = other.Min, other.Max
+	}
+	p.Samples += other.Samples
+	p.Sum += other.Sum
+	p.Min = min(p.Min, other.Min)
+	p.Max = max(p.Max, other.Max)
+	return p
+}
+
+func (s *Store) seriesPath(name string) string {
+	return filepath.Join(s.Dir, "series", name+".jsonl")
+}
+
+// appendClosed appends the buckets b closed since it was last saved to its
+// stat history.
+func (s *Store) appendClosed(b *baseline.Baseline) error {
+	closed := b.TakeClosed()
+	if len(closed) == 0 {
+		return nil
+	}
+	width := b.BucketWidthOrDefault()
+	var points []StatPoint
+	for _, bucket := range closed {
+		for key, count := range bucket.Counts {
+			v := float64(count)
+			points = append(points, StatPoint{Key: key, Start: bucket.Start, Width: width, Samples: 1, Sum: v, Min: v, Max: v})
+		}
+	}
+	return s.appendStats(b.Name, points...)
+}
+
+// AppendStats appends points to the baseline's stat history in
+// <dir>/series/<name>.jsonl. Saving a baseline appends the buckets of
+// timestamped observations it closed (see baseline.RecordObservationAt).
+func (s *Store) AppendStats(name string, points ...StatPoint) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	unlock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return s.appendStats(name, points...)
+}
+
+func (s *Store) appendStats(name string, points ...StatPoint) error {
+	if err := os.MkdirAll(filepath.Join(s.Dir, "series"), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.seriesPath(name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, point := range points {
+		line, err := s.encodeLine("series", name, point)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// StatSeries returns the stat history of the keys starting with prefix
+// (all keys when empty) that ends at or after since, ordered by start and
+// key. Older points are coarser once downsampled. Malformed lines are
+// skipped.
+func (s *Store) StatSeries(name, prefix string, since time.Time) ([]StatPoint, error) {
+	points, err := s.readStats(name)
+	if err != nil {
+		return nil, err
+	}
+	selected := points[:0]
+	for _, p := range points {
+		if strings.HasPrefix(p.Key, prefix) && !p.Start.Add(p.Width).Before(since) {
+			selected = append(selected, p)
+		}
+	}
+	sortStats(selected)
+	return selected, nil
+}
+
+func (s *Store) readStats(name string) ([]StatPoint, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.seriesPath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var points []StatPoint
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var point StatPoint
+		ok, err := s.decodeLine("series", name, scanner.Bytes(), &point)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || point.Width <= 0 {
+			continue
+		}
+		points = append(points, point)
+	}
+	return points, scanner.Err()
+}
+
+func sortStats(points []StatPoint) {
+	sort.Slice(points, func(i, j int) bool {
+		if !points[i].Start.Equal(points[j].Start) {
+			return points[i].Start.Before(points[j].Start)
+		}
+		return points[i].Key < points[j].Key
+	})
+}
+
+// DownsampleStats rewrites the baseline's stat history at lower
+// resolution as it ages: points that ended more than raw before now are
+// merged into hourly points, and those more than hourly before now into
+// daily ones, aligned to UTC. Zero durations use DefaultRawStats and
+// DefaultHourlyStats. It returns how many points the history shrank by.
+func (s *Store) DownsampleStats(name string, raw, hourly time.Duration, now time.Time) (int, error) {
+	if raw <= 0 {
+		raw = DefaultRawStats
+	}
+	if hourly <= 0 {
+		hourly = DefaultHourlyStats
+	}
+	unlock, err := s.lock(true)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	points, err := s.readStats(name)
+	if err != nil || len(points) == 0 {
+		return 0, err
+	}
+
+	type slot struct {
+		key   string
+		start time.Time
+		width time.Duration
+	}
+	merged := make(map[slot]StatPoint)
+	for _, p := range points {
+		width := p.Width
+		switch age := now.Sub(p.Start.Add(p.Width)); {
+		case age > hourly:
+			width = max(width, 24*time.Hour)
+		case age > raw:
+			width = max(width, time.Hour)
+		}
+		k := slot{p.Key, p.Start.Truncate(width), width}
+		m, ok := merged[k]
+		if !ok {
+			m = StatPoint{Key: p.Key, Start: k.start, Width: width}
+		}
+		merged[k] = m.merge(p)
+	}
+	if len(merged) == len(points) {
+		return 0, nil
+	}
+
+	out := make([]StatPoint, 0, len(merged))
+	for _, p := range merged {
+		out = append(out, p)
+	}
+	sortStats(out)
+	var data []byte
+	for _, p := range out {
+		line, err := s.encodeLine("series", name, p)
+		if err != nil {
+			return 0, err
+		}
+		data = append(data, line...)
+	}
+	return len(points) - len(out), writeAtomic(s.seriesPath(name), data)
+}
@@ -0,0 +1,474 @@
+// Package storage persists baselines and detection history on disk.
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/audit"
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+// ErrNotFound is returned when a baseline does not exist.
+var ErrNotFound = errors.New("baseline not found")
+
+// Store keeps one JSON document per baseline and an append-only JSON lines
+// history file per baseline:
+//
+//	<dir>/baselines/<name>.json
+//	<dir>/history/<name>.jsonl
+//
+// Mutations are recorded in the audit log under <dir>/audit, attributed
+// to Actor, before they are made: a change the log cannot record fails.
+// Baseline documents, the index and the history, score and stat history
+// files are encrypted at rest when Cipher is set, and the audit log then
+// records changes without the stat keys and values. Namespace names the
+// namespace the store holds (see WithNamespace). Changes take an advisory
+// lock on <dir>/lock, so processes can share a store; a ReadOnly store
+// (see OpenReadOnly) refuses them.
+type Store struct {
+	Dir       string
+	Audit     *audit.Log
+	Actor     string
+	Cipher    *Cipher
+	Namespace string
+	ReadOnly  bool
+
+	root string // directory of the default namespace
+}
+
+// Record is one entry in a baseline's detection history.
+type Record struct {
+	Time    time.Time
+	Kind    string              // "anomaly" or "event"
+	Anomaly *baseline.Anomaly   `json:",omitempty"`
+	Event   *detect.SystemEvent `json:",omitempty"`
+}
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// DefaultDir returns $RUNTIMEBASE_HOME, or ~/.runtimebase.
+func DefaultDir() string {
+	if dir := os.Getenv("RUNTIMEBASE_HOME"); dir != "" {
+		return dir
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".runtimebase"
+	}
+	return filepath.Join(home, ".runtimebase")
+}
+
+// Open opens the store rooted at dir, creating it if needed.
+func Open(dir string) (*Store, error) {
+	for _, sub := range []string{"baselines", "history"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, fmt.Errorf("creating store: %w", err)
+		}
+	}
+	log, err := audit.Open(filepath.Join(dir, "audit", "audit.jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log: %w", err)
+	}
+	return &Store{Dir: dir, Audit: log, Actor: "unknown", root: dir}, nil
+}
+
+// WithActor returns a view of the store that attributes mutations to actor.
+func (s *Store) WithActor(actor string) *Store {
+	view := *s
+	view.Actor = actor
+	return &view
+}
+
+func (s *Store) audit(action, target string, diff []audit.Change) error {
+	if s.Audit == nil {
+		return nil
+	}
+	if s.Cipher != nil {
+		diff = audit.Redact(diff, s.Cipher.Tag)
+	}
+	if err := s.Audit.Record(s.Actor, action, s.Qualify(target), diff); err != nil {
+		return fmt.Errorf("writing audit log: %w", err)
+	}
+	return nil
+}
+
+func checkName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("invalid baseline name %q", name)
+	}
+	return nil
+}
+
+func (s *Store) baselinePath(name string) string {
+	return filepath.Join(s.Dir, "baselines", name+".json")
+}
+
+func (s *Store) historyPath(name string) string {
+	return filepath.Join(s.Dir, "history", name+".jsonl")
+}
+
+// SaveBaseline writes b atomically and audits the change. To change a
+// stored baseline, use Update.
+func (s *Store) SaveBaseline(b *baseline.Baseline) error {
+	if err := checkName(b.Name); err != nil {
+		return err
+	}
+	unlock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return s.saveBaseline(b)
+}
+
+func (s *Store) saveBaseline(b *baseline.Baseline) error {
+	return s.save(b, false)
+}
+
+// save writes b, audited in summary when learned and only its statistics
+// changed.
+func (s *Store) save(b *baseline.Baseline, learned bool) error {
+	if err := s.claim(b); err != nil {
+		return err
+	}
+	b.SchemaVersion = baseline.SchemaVersion
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if data, err = s.seal(b.Name, data); err != nil {
+		return err
+	}
+
+	// An unreadable previous version is audited as a re-creation. The
+	// change is audited before it is written, so a change the audit log
+	// cannot record is not made.
+	previous, _, _ := s.load(b.Name)
+	diff := audit.DiffBaselines(previous, b)
+	action := ""
+	switch {
+	case previous == nil:
+		action = audit.ActionCreate
+	case previous.AnomalyThreshold != b.AnomalyThreshold || !maps.Equal(previous.Thresholds, b.Thresholds):
+		action = audit.ActionThreshold
+	case changesOverrides(diff):
+		action = audit.ActionOverride
+	case len(diff) > 0 && learned:
+		action, diff = audit.ActionLearn, audit.Summarize(previous, b, diff)
+	case len(diff) > 0:
+		action = audit.ActionUpdate
+	}
+	if action != "" {
+		if err := s.audit(action, b.Name, diff); err != nil {
+			return err
+		}
+	}
+
+	if err := writeAtomic(s.baselinePath(b.Name), data); err != nil {
+		return err
+	}
+	if err := s.appendClosed(b); err != nil {
+		return err
+	}
+	return s.updateIndex(func(x *baseline.Index) { x.Update(b) })
+}
+
+func changesOverrides(diff []audit.Change) bool {
+	for _, c := range diff {
+		if strings.HasPrefix(c.Field, "Overrides.") {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveLearned writes b like SaveBaseline, but audits a change to its
+// statistics alone as one ActionLearn entry counting the stats it changed
+// rather than listing them. It is meant for high-volume learning updates;
+// a change to the baseline's configuration is audited in full.
+func (s *Store) SaveLearned(b *baseline.Baseline) error {
+	if err := checkName(b.Name); err != nil {
+		return err
+	}
+	unlock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return s.save(b, true)
+}
+
+// Promote replaces the target baseline with the candidate baseline and
+// removes the candidate. A candidate relearned against the target loses
+// its reference; one with observations still pending approval is refused.
+func (s *Store) Promote(candidate, target string) error {
+	if err := checkName(target); err != nil {
+		return err
+	}
+	unlock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	b, _, err := s.load(candidate)
+	if err != nil {
+		return err
+	}
+	previous, _, err := s.load(target)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	if len(b.Pending) > 0 {
+		return fmt.Errorf("%s has %d observations pending approval", candidate, len(b.Pending))
+	}
+
+	b.Name = target
+	b.CandidateFor = ""
+	b.Reference = nil
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	if data, err = s.seal(target, data); err != nil {
+		return err
+	}
+	diff := append([]audit.Change{{Field: "Source", New: candidate}}, audit.DiffBaselines(previous, b)...)
+	if err := s.audit(audit.ActionPromote, target, diff); err != nil {
+		return err
+	}
+	if err := writeAtomic(s.baselinePath(target), data); err != nil {
+		return err
+	}
+	if err := os.Remove(s.baselinePath(candidate)); err != nil {
+		return err
+	}
+	return s.updateIndex(func(x *baseline.Index) {
+		x.Remove(candidate)
+		x.Update(b)
+	})
+}
+
+// LoadBaseline reads the named baseline.
+func (s *Store) LoadBaseline(name string) (*baseline.Baseline, error) {
+	b, version, err := s.load(name)
+	if err == nil && version < baseline.SchemaVersion {
+		s.upgrade(b, version)
+	}
+	return b, err
+}
+
+// load reads the named baseline, migrated to the current schema, and
+// returns it with the schema version it was stored in.
+func (s *Store) load(name string) (*baseline.Baseline, int, error) {
+	if err := checkName(name); err != nil {
+		return nil, 0, err
+	}
+	data, err := os.ReadFile(s.baselinePath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if data, err = s.unseal(name, data); err != nil {
+		return nil, 0, err
+	}
+
+	data, version, err := baseline.Migrate(data)
+	if err != nil {
+		return nil, version, fmt.Errorf("baseline %s: %w", name, err)
+	}
+	var b baseline.Baseline
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, version, fmt.Errorf("decoding baseline %s: %w", name, err)
+	}
+	b.Namespace = s.Namespace
+	return &b, version, nil
+}
+
+// upgrade stores b, migrated from schema version from on load, in the
+// current format, so it is migrated once. It gives up, leaving the next
+// load to try again, when the store is read-only, another process is
+// writing to it, or the baseline was saved since it was read.
+func (s *Store) upgrade(b *baseline.Baseline, from int) {
+	unlock, err := s.lock(false)
+	if err != nil {
+		return
+	}
+	defer unlock()
+	if _, version, err := s.load(b.Name); err != nil || version != from {
+		return
+	}
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return
+	}
+	if data, err = s.seal(b.Name, data); err != nil {
+		return
+	}
+	if err := s.audit(audit.ActionMigrate, b.Name, []audit.Change{{
+		Field: "SchemaVersion", Old: strconv.Itoa(from), New: strconv.Itoa(baseline.SchemaVersion),
+	}}); err != nil {
+		return
+	}
+	writeAtomic(s.baselinePath(b.Name), data)
+}
+
+// ListBaselines returns the names of all stored baselines, sorted.
+func (s *Store) ListBaselines() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.Dir, "baselines"))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".json"); ok && !entry.IsDir() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// DeleteBaseline removes a baseline, its history, its scores and its stat
+// history.
+func (s *Store) DeleteBaseline(name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	unlock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err := os.Stat(s.baselinePath(name)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %s", ErrNotFound, name)
+		}
+		return err
+	}
+	if err := s.audit(audit.ActionDelete, name, nil); err != nil {
+		return err
+	}
+	if err := os.Remove(s.baselinePath(name)); err != nil {
+		return err
+	}
+	for _, path := range []string{s.historyPath(name), s.scoresPath(name), s.seriesPath(name)} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return s.updateIndex(func(x *baseline.Index) { x.Remove(name) })
+}
+
+// AppendHistory appends records to the baseline's history.
+func (s *Store) AppendHistory(name string, records ...Record) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	unlock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	f, err := os.OpenFile(s.historyPath(name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, record := range records {
+		if record.Time.IsZero() {
+			record.Time = time.Now()
+		}
+		line, err := s.encodeLine("history", name, record)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// AppendAnomalies records anomalies in the baseline's history.
+func (s *Store) AppendAnomalies(name string, anomalies []baseline.Anomaly) error {
+	records := make([]Record, len(anomalies))
+	for i := range anomalies {
+		records[i] = Record{Time: anomalies[i].Timestamp, Kind: "anomaly", Anomaly: &anomalies[i]}
+	}
+	return s.AppendHistory(name, records...)
+}
+
+// History returns the baseline's history records at or after since, in the
+// order they were written. Malformed lines are skipped; encrypted lines
+// the store has no key for are an error.
+func (s *Store) History(name string, since time.Time) ([]Record, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.historyPath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var record Record
+		ok, err := s.decodeLine("history", name, scanner.Bytes(), &record)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || record.Time.Before(since) {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// writeAtomic writes data to a temporary file and renames it into place.
+func writeAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+var _ baseline.Storage = (*Store)(nil)
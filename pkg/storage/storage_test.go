@@ -0,0 +1,603 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/audit"
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+)
+
+func TestBaselineRoundTrip(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := baseline.NewLearner().CreateBaseline("myapp")
+	b.RecordObservation("syscall", "open", 100)
+	b.RecordObservation("syscall", "open", 120)
+	if err := store.SaveBaseline(b); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := store.LoadBaseline("myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := loaded.Stats["syscall:open"], b.Stats["syscall:open"]; got.Mean != want.Mean || got.SampleCount != want.SampleCount {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	if _, err := store.LoadBaseline("../etc/passwd"); err == nil {
+		t.Error("expected invalid name to be rejected")
+	}
+}
+
+func TestEncryptedBaselines(t *testing.T) {
+	t.Setenv("TEST_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	plain, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := plain.WithEncryption(EnvKey("TEST_KEY"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := baseline.NewLearner().CreateBaseline("myapp")
+	b.RecordObservation("syscall", "open", 100)
+	if err := store.SaveBaseline(b); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(store.baselinePath("myapp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(data) || bytes.Contains(data, []byte("syscall:open")) {
+		t.Fatal("expected baseline to be encrypted at rest")
+	}
+
+	if _, err := store.LoadBaseline("myapp"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plain.LoadBaseline("myapp"); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("expected ErrEncrypted without a key, got %v", err)
+	}
+
+	// A file copied over another baseline's name fails authentication.
+	if err := os.WriteFile(store.baselinePath("other"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadBaseline("other"); err == nil {
+		t.Error("expected swapped baseline to fail decryption")
+	}
+}
+
+func TestEncryptedFiles(t *testing.T) {
+	t.Setenv("TEST_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	dir := t.TempDir()
+	plain, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := plain.WithEncryption(EnvKey("TEST_KEY"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := baseline.NewLearner().CreateBaseline("myapp")
+	b.RecordObservation("network", "10.0.0.9:443", 3)
+	if err := store.SaveBaseline(b); err != nil {
+		t.Fatal(err)
+	}
+	b.RecordObservation("network", "10.0.0.9:443", 5)
+	if err := store.SaveBaseline(b); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	anomaly := baseline.Anomaly{Type: "New behavior", Evidence: "network:10.0.0.9:443", Timestamp: now}
+	if err := store.AppendAnomalies("myapp", []baseline.Anomaly{anomaly, anomaly}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.AppendScores("myapp", ScorePoint{Time: now, Score: 42, Events: 7, Release: "v1.2.3"}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for minute := 0; minute < 120; minute++ {
+		b.RecordObservationAt("network", "10.0.0.9:443", nil, 1, start.Add(time.Duration(minute)*time.Minute))
+	}
+	b.CloseBuckets()
+	if err := store.SaveLearned(b); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{store.historyPath("myapp"), store.scoresPath("myapp"), store.seriesPath("myapp"), filepath.Join(dir, "audit", "audit.jsonl")} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range []string{"10.0.0.9", "v1.2.3", "mean="} {
+			if bytes.Contains(data, []byte(secret)) {
+				t.Errorf("%s contains %q in plaintext:\n%s", filepath.Base(path), secret, data)
+			}
+		}
+	}
+
+	records, err := store.History("myapp", time.Time{})
+	if err != nil || len(records) != 2 || records[0].Anomaly.Evidence != anomaly.Evidence {
+		t.Fatalf("expected the encrypted history read back, got %v, %v", records, err)
+	}
+	points, err := store.Scores("myapp", time.Time{})
+	if err != nil || len(points) != 1 || points[0].Release != "v1.2.3" {
+		t.Fatalf("expected the encrypted scores read back, got %v, %v", points, err)
+	}
+	if _, err := plain.History("myapp", time.Time{}); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("expected ErrEncrypted reading history without a key, got %v", err)
+	}
+	if _, err := plain.Scores("myapp", time.Time{}); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("expected ErrEncrypted reading scores without a key, got %v", err)
+	}
+	series, err := store.StatSeries("myapp", "network:", time.Time{})
+	if err != nil || len(series) != 120 {
+		t.Fatalf("expected the encrypted stat history read back, got %d points: %v", len(series), err)
+	}
+	if _, err := plain.StatSeries("myapp", "", time.Time{}); !errors.Is(err, ErrEncrypted) {
+		t.Errorf("expected ErrEncrypted reading stat history without a key, got %v", err)
+	}
+	if removed, err := store.DownsampleStats("myapp", 0, 0, start.Add(40*24*time.Hour)); err != nil || removed != 119 {
+		t.Errorf("expected the encrypted stat history downsampled, removed %d: %v", removed, err)
+	}
+	if data, _ := os.ReadFile(store.seriesPath("myapp")); bytes.Contains(data, []byte("10.0.0.9")) {
+		t.Error("expected the downsampled stat history to stay encrypted")
+	}
+	if removed, err := store.PruneHistory("myapp", 1); err != nil || removed != 1 {
+		t.Errorf("expected one anomaly pruned from the encrypted history, removed %d: %v", removed, err)
+	}
+
+	// Lines are bound to the file they were written to.
+	history, err := os.ReadFile(store.historyPath("myapp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(store.historyPath("other"), history, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.History("other", time.Time{}); err == nil {
+		t.Error("expected a history line moved to another baseline to fail decryption")
+	}
+
+	entries, err := store.Audit.Query(audit.Filter{Target: "myapp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Learning is audited in summary, without keys to redact.
+	learned := entries[len(entries)-1]
+	want := []audit.Change{{Field: "Stats", Old: "1", New: "1"}, {Field: "StatsChanged", New: "1"}}
+	if learned.Action != audit.ActionLearn || !slices.Equal(learned.Diff, want) {
+		t.Errorf("expected the learned stats audited in summary, got %+v", learned)
+	}
+	last := entries[len(entries)-2]
+	if len(last.Diff) != 1 || !strings.HasPrefix(last.Diff[0].Field, "Stats.") || last.Diff[0].New != audit.Redacted {
+		t.Errorf("expected a redacted stat change, got %+v", last.Diff)
+	}
+	if created := entries[0].Diff; !slices.Contains(created, audit.Change{Field: last.Diff[0].Field, New: audit.Redacted}) {
+		t.Errorf("expected changes to one key to share a tag, got %+v and %+v", created, last.Diff)
+	}
+}
+
+func TestScoreSeries(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	if err := store.AppendScores("myapp",
+		ScorePoint{Time: day.Add(time.Hour), Score: 100, Events: 300, Release: "v1"},
+		ScorePoint{Time: day.Add(2 * time.Hour), Score: 60, Events: 100, Release: "v1"},
+		ScorePoint{Time: day.Add(25 * time.Hour), Score: 80, Events: 10, Release: "v2"},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	points, err := store.Scores("myapp", day.Add(90*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("expected 2 points since cutoff, got %d", len(points))
+	}
+
+	all, _ := store.Scores("myapp", time.Time{})
+	buckets := Bucket(all, 24*time.Hour)
+	if len(buckets) != 2 {
+		t.Fatalf("expected 2 daily buckets, got %d", len(buckets))
+	}
+	if buckets[0].Score != 90 || buckets[0].Events != 400 {
+		t.Errorf("expected event-weighted average 90 over 400 events, got %+v", buckets[0])
+	}
+}
+
+func TestStatHistoryDownsampling(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	b := baseline.NewLearner().CreateBaseline("myapp")
+	for minute := 0; minute < 120; minute++ {
+		b.RecordObservationAt("syscall", "read", nil, minute%10, start.Add(time.Duration(minute)*time.Minute))
+	}
+	b.CloseBuckets()
+	if err := store.SaveLearned(b); err != nil {
+		t.Fatal(err)
+	}
+	points, err := store.StatSeries("myapp", "syscall:", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 120 || points[0].Width != time.Minute {
+		t.Fatalf("expected 120 raw points, got %d", len(points))
+	}
+
+	// A day after the first hour, it is past the raw retention.
+	removed, err := store.DownsampleStats("myapp", 0, 0, start.Add(25*time.Hour+time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	points, _ = store.StatSeries("myapp", "", time.Time{})
+	if removed != 59 || len(points) != 61 {
+		t.Fatalf("expected the first hour merged into one point, removed %d, %d left", removed, len(points))
+	}
+	if p := points[0]; p.Width != time.Hour || p.Samples != 60 || p.Mean() != 4.5 || p.Min != 0 || p.Max != 9 {
+		t.Errorf("unexpected hourly point %+v", p)
+	}
+
+	if removed, _ = store.DownsampleStats("myapp", 0, 0, start.Add(40*24*time.Hour)); removed != 60 {
+		t.Errorf("expected everything merged into one daily point, removed %d", removed)
+	}
+	points, _ = store.StatSeries("myapp", "", time.Time{})
+	if len(points) != 1 || points[0].Width != 24*time.Hour || points[0].Samples != 120 {
+		t.Errorf("expected one daily point of 120 samples, got %+v", points)
+	}
+}
+
+func TestPruneHistoryKeepsNewestAnomalies(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < 5; i++ {
+		anomaly := baseline.Anomaly{Evidence: string(rune('a' + i)), Timestamp: start.Add(time.Duration(i) * time.Minute)}
+		if err := store.AppendAnomalies("myapp", []baseline.Anomaly{anomaly}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pruned, err := store.PruneHistory("myapp", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 3 {
+		t.Errorf("expected 3 pruned records, got %d", pruned)
+	}
+
+	records, err := store.History("myapp", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Anomaly.Evidence != "d" || records[1].Anomaly.Evidence != "e" {
+		t.Errorf("unexpected remaining history: %+v", records)
+	}
+}
+
+func TestFleetIndex(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	learner := baseline.NewLearner()
+	for _, name := range []string{"api", "web", "worker"} {
+		b := learner.CreateBaseline(name)
+		b.RecordLabeledObservation("network", "10.0.0.53:53", map[string]string{"env": "prod"}, 3)
+		if name != "worker" {
+			b.RecordObservation("process", "/usr/bin/curl", 1)
+		}
+		b.Seed("syscall", "ptrace")
+		if err := store.SaveBaseline(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	index, err := store.Index()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen, others := index.Prevalence("network:10.0.0.53:53", "worker"); seen != 2 || others != 2 {
+		t.Errorf("expected the resolver in 2 of 2 other baselines, got %d of %d", seen, others)
+	}
+	if seen, _ := index.Prevalence("syscall:ptrace", ""); seen != 0 {
+		t.Errorf("expected seeded patterns not to be indexed, got %d", seen)
+	}
+
+	if err := store.DeleteBaseline("web"); err != nil {
+		t.Fatal(err)
+	}
+	// The index survives reopening, and a missing one is rebuilt.
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rebuild := range []bool{false, true} {
+		if rebuild {
+			os.Remove(reopened.indexPath())
+		}
+		if index, err = reopened.Index(); err != nil {
+			t.Fatal(err)
+		}
+		if seen, others := index.Prevalence("process:/usr/bin/curl", "worker"); seen != 1 || others != 1 {
+			t.Errorf("rebuild=%v: expected curl in 1 of 1 other baselines, got %d of %d", rebuild, seen, others)
+		}
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	teamA, err := store.WithNamespace("team-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shared := baseline.NewLearner().CreateBaseline("web")
+	shared.RecordObservation("syscall", "open", 1)
+	if err := store.SaveBaseline(shared); err != nil {
+		t.Fatal(err)
+	}
+	own := baseline.NewLearner(baseline.WithNamespace("team-a")).CreateBaseline("web")
+	own.RecordObservation("network", "10.0.0.9:443", 1)
+	if err := teamA.SaveBaseline(own); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := teamA.LoadBaseline("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, leaked := loaded.Stats["syscall:open"]; leaked || loaded.Namespace != "team-a" {
+		t.Errorf("expected team-a's own baseline, got %+v", loaded)
+	}
+	if err := store.SaveBaseline(loaded); err == nil {
+		t.Error("expected saving team-a's baseline in the default namespace to fail")
+	}
+	if x, err := teamA.Index(); err != nil || x.Patterns["syscall:open"] != nil {
+		t.Errorf("expected namespaces to keep separate fleet indexes, got %v, %v", x, err)
+	}
+	if names, _ := store.ListNamespaces(); len(names) != 1 || names[0] != "team-a" {
+		t.Errorf("expected namespace team-a, got %v", names)
+	}
+	if _, err := store.WithNamespace("../etc"); err == nil {
+		t.Error("expected an invalid namespace to be rejected")
+	}
+
+	entries, err := store.Audit.Query(audit.Filter{Target: "team-a/web"})
+	if err != nil || len(entries) != 1 {
+		t.Errorf("expected one audit entry for team-a/web, got %v, %v", entries, err)
+	}
+}
+
+func TestOverridesAudited(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := baseline.NewLearner().CreateBaseline("myapp")
+	b.RecordObservation("syscall", "open", 100)
+	store.SaveBaseline(b)
+
+	expires := time.Date(2026, 10, 3, 12, 0, 0, 0, time.UTC)
+	b.AddOverride(baseline.Override{Key: "network:203.0.113.7", Reason: "migration", Expires: expires})
+	if err := store.SaveBaseline(b); err != nil {
+		t.Fatal(err)
+	}
+	b.ExpireOverrides(expires)
+	store.SaveBaseline(b)
+
+	entries, err := store.Audit.Query(audit.Filter{Action: audit.ActionOverride})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Diff[0].New != "until 2026-10-03T12:00:00Z: migration" || entries[1].Diff[0].New != "" {
+		t.Errorf("override entries = %+v", entries)
+	}
+}
+
+func TestUnauditedChangesRefused(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := baseline.NewLearner().CreateBaseline("myapp")
+	b.RecordObservation("syscall", "open", 100)
+	if err := store.SaveBaseline(b); err != nil {
+		t.Fatal(err)
+	}
+
+	// An audit log that cannot be written: a directory.
+	good := store.Audit
+	store.Audit = &audit.Log{Path: t.TempDir()}
+	b.AnomalyThreshold = 9
+	if err := store.SaveBaseline(b); err == nil {
+		t.Error("expected the save to fail with the audit log")
+	}
+	if err := store.DeleteBaseline("myapp"); err == nil {
+		t.Error("expected the delete to fail with the audit log")
+	}
+	if err := store.Archive("myapp"); err == nil {
+		t.Error("expected the archive to fail with the audit log")
+	}
+
+	loaded, err := store.LoadBaseline("myapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.AnomalyThreshold == 9 {
+		t.Error("expected the unaudited threshold change not written")
+	}
+	store.Audit = good
+	if err := good.Verify(); err != nil {
+		t.Error(err)
+	}
+	if entries, _ := good.Query(audit.Filter{}); len(entries) != 1 || entries[0].Action != audit.ActionCreate {
+		t.Errorf("expected only the creation audited, got %+v", entries)
+	}
+}
+
+func TestLegacyBaselineMigrated(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(store.Dir, "baselines", "legacy.json")
+	legacy := `{"Name": "legacy", "Stats": null, "AnomalyThreshold": 3, "Integrity": {}}`
+	if err := os.WriteFile(path, []byte(legacy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	b, err := store.LoadBaseline("legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.SchemaVersion != baseline.SchemaVersion || b.Stats == nil || b.AnomalyThreshold != 3 {
+		t.Errorf("migrated = %+v", b)
+	}
+	data, _ := os.ReadFile(path)
+	if _, version, err := baseline.Migrate(data); err != nil || version != baseline.SchemaVersion {
+		t.Errorf("stored baseline is at version %d (%v), want it upgraded", version, err)
+	}
+	entries, _ := store.Audit.Query(audit.Filter{Action: audit.ActionMigrate})
+	if len(entries) != 1 || entries[0].Diff[0].Old != "0" {
+		t.Errorf("migrate entries = %+v", entries)
+	}
+
+	os.WriteFile(path, []byte(`{"SchemaVersion": 99, "Name": "legacy"}`), 0o600)
+	if _, err := store.LoadBaseline("legacy"); !errors.Is(err, baseline.ErrSchemaTooNew) {
+		t.Errorf("loading a newer baseline: %v", err)
+	}
+}
+
+func TestPruneHistoryKeepsConcurrentAppends(t *testing.T) {
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 200
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for i := 0; i < n; i++ {
+			event := Record{Kind: "event", Event: &detect.SystemEvent{Type: "syscall", PID: i}}
+			anomaly := Record{Kind: "anomaly", Anomaly: &baseline.Anomaly{Evidence: fmt.Sprint(i)}}
+			if err := store.AppendHistory("myapp", event, anomaly); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if _, err := store.PruneHistory("myapp", 1); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	wg.Wait()
+
+	records, err := store.History("myapp", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := 0
+	for _, r := range records {
+		if r.Kind == "event" {
+			events++
+		}
+	}
+	if events != n {
+		t.Errorf("%d of %d events appended while pruning survived", events, n)
+	}
+}
+
+func TestLockingAndReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	store, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SaveBaseline(baseline.NewLearner().CreateBaseline("myapp"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := store.Update("myapp", func(b *baseline.Baseline) (*baseline.Baseline, error) {
+				b.RecordObservation("syscall", "open", 1)
+				return b, nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	b, _ := store.LoadBaseline("myapp")
+	if n := b.Stats["syscall:open"].SampleCount; n != 20 {
+		t.Errorf("sample count = %d after 20 concurrent updates", n)
+	}
+
+	unlock, err := store.lock(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.lock(false); !errors.Is(err, errLocked) {
+		t.Errorf("taking a held lock: %v", err)
+	}
+	unlock()
+
+	ro, err := OpenReadOnly(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ro.LoadBaseline("myapp"); err != nil {
+		t.Error(err)
+	}
+	if err := ro.SaveBaseline(b); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("saving to a read-only store: %v", err)
+	}
+	if _, err := OpenReadOnly(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected opening a missing store read-only to fail")
+	}
+}
@@ -0,0 +1,158 @@
+// Package term renders output meant for people: severities in color and
+// tables with aligned columns. Color is used only on terminals, and never
+// when NO_COLOR is set (https://no-color.org) or TERM is dumb.
+package term
+
+import (
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hallucinaut/runtimebase/pkg/logging"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// ANSI SGR codes.
+const (
+	reset  = "\x1b[0m"
+	bold   = "1"
+	dim    = "2"
+	red    = "31"
+	green  = "32"
+	yellow = "33"
+	cyan   = "36"
+)
+
+// Colors reports whether output written to w should be colored.
+func Colors(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return logging.IsTerminal(w)
+}
+
+// Painter colors text when Enabled, and returns it unchanged otherwise.
+type Painter struct {
+	Enabled bool
+}
+
+// For returns a painter coloring output to w if it should be (see Colors)
+// and noColor is false.
+func For(w io.Writer, noColor bool) Painter {
+	return Painter{Enabled: !noColor && Colors(w)}
+}
+
+func (p Painter) paint(s string, codes ...string) string {
+	if !p.Enabled || s == "" {
+		return s
+	}
+	return "\x1b[" + strings.Join(codes, ";") + "m" + s + reset
+}
+
+// Bold emphasizes s.
+func (p Painter) Bold(s string) string { return p.paint(s, bold) }
+
+// Dim de-emphasizes s.
+func (p Painter) Dim(s string) string { return p.paint(s, dim) }
+
+// Good marks s as healthy.
+func (p Painter) Good(s string) string { return p.paint(s, green) }
+
+// Warn marks s as needing attention.
+func (p Painter) Warn(s string) string { return p.paint(s, yellow) }
+
+// Bad marks s as a problem.
+func (p Painter) Bad(s string) string { return p.paint(s, red) }
+
+// Severity colors s by the rank of severity name in the active taxonomy:
+// critical bold red, high red, medium yellow and lower cyan.
+func (p Painter) Severity(name, s string) string {
+	switch {
+	case severity.AtLeast(name, severity.Critical):
+		return p.paint(s, bold, red)
+	case severity.AtLeast(name, severity.High):
+		return p.paint(s, red)
+	case severity.AtLeast(name, severity.Medium):
+		return p.paint(s, yellow)
+	}
+	return p.paint(s, cyan)
+}
+
+// Width returns the number of columns s takes on a terminal, not counting
+// color escapes.
+func Width(s string) int {
+	n := 0
+	for i := 0; i < len(s); {
+		if s[i] == '\x1b' {
+			if end := strings.IndexByte(s[i:], 'm'); end >= 0 {
+				i += end + 1
+				continue
+			}
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		n++
+	}
+	return n
+}
+
+// Table writes rows with their columns aligned. Unlike text/tabwriter it
+// measures cells without their color escapes, so painted cells line up.
+type Table struct {
+	// Indent precedes every row.
+	Indent string
+	// Right lists the columns aligned to the right, such as numbers.
+	Right []int
+
+	w    io.Writer
+	rows [][]string
+}
+
+// NewTable returns a table writing to w on Flush.
+func NewTable(w io.Writer) *Table {
+	return &Table{w: w}
+}
+
+// Row adds a row.
+func (t *Table) Row(cells ...string) {
+	t.rows = append(t.rows, cells)
+}
+
+// Flush writes the rows added since the last Flush.
+func (t *Table) Flush() error {
+	var widths []int
+	for _, row := range t.rows {
+		for i, cell := range row {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], Width(cell))
+		}
+	}
+	right := make(map[int]bool, len(t.Right))
+	for _, i := range t.Right {
+		right[i] = true
+	}
+	var b strings.Builder
+	for _, row := range t.rows {
+		var line strings.Builder
+		line.WriteString(t.Indent)
+		for i, cell := range row {
+			pad := strings.Repeat(" ", widths[i]-Width(cell))
+			if i > 0 {
+				line.WriteString("  ")
+			}
+			if right[i] {
+				line.WriteString(pad + cell)
+			} else {
+				line.WriteString(cell + pad)
+			}
+		}
+		b.WriteString(strings.TrimRight(line.String(), " "))
+		b.WriteString("\n")
+	}
+	t.rows = nil
+	_, err := io.WriteString(t.w, b.String())
+	return err
+}
@@ -0,0 +1,51 @@
+package term
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+func TestTable(t *testing.T) {
+	var buf bytes.Buffer
+	p := Painter{Enabled: true}
+	table := NewTable(&buf)
+	table.Indent, table.Right = "  ", []int{1}
+	table.Row("syscall:open", p.Bad("12"), p.Severity(severity.Critical, "CRITICAL"))
+	table.Row("network:10.0.0.1:443", "3", "")
+	table.Flush()
+
+	want := "  syscall:open          \x1b[31m12\x1b[0m  \x1b[1;31mCRITICAL\x1b[0m\n" +
+		"  network:10.0.0.1:443   3\n"
+	if buf.String() != want {
+		t.Errorf("expected\n%q\ngot\n%q", want, buf.String())
+	}
+}
+
+func TestPainter(t *testing.T) {
+	if got := (Painter{}).Severity(severity.High, "HIGH"); got != "HIGH" {
+		t.Errorf("expected a disabled painter to leave text alone, got %q", got)
+	}
+	p := Painter{Enabled: true}
+	for name, want := range map[string]string{
+		severity.Critical: "\x1b[1;31m",
+		severity.High:     "\x1b[31m",
+		severity.Medium:   "\x1b[33m",
+		severity.Low:      "\x1b[36m",
+		"unknown":         "\x1b[36m",
+	} {
+		if got := p.Severity(name, "x"); got != want+"x\x1b[0m" {
+			t.Errorf("%s: expected %q, got %q", name, want+"x\x1b[0m", got)
+		}
+	}
+	if Width(p.Bold("héllo")) != 5 {
+		t.Errorf("expected escapes not to count towards width")
+	}
+}
+
+func TestColors(t *testing.T) {
+	if Colors(&bytes.Buffer{}) {
+		t.Error("expected no color for a buffer")
+	}
+}
@@ -0,0 +1,154 @@
+// Package timeline reconstructs incident sequences from detection history.
+package timeline
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/storage"
+)
+
+// Entry is one line of a timeline.
+type Entry struct {
+	Time    time.Time
+	Kind    string // "anomaly" or "event"
+	Summary string
+	Lineage []Process // offending process first, then its ancestors
+}
+
+// Process is one link in a process lineage.
+type Process struct {
+	PID  int
+	Name string
+}
+
+// maxLineageDepth bounds lineage walks in case of PID reuse loops.
+const maxLineageDepth = 32
+
+// Build orders the records in [end-window, end] by time and annotates each
+// with the lineage of its process, as far as the recorded process events
+// allow.
+func Build(records []storage.Record, window time.Duration, end time.Time) []Entry {
+	start := end.Add(-window)
+	procs := processTable(records)
+
+	var entries []Entry
+	for _, record := range records {
+		if record.Time.Before(start) || record.Time.After(end) {
+			continue
+		}
+
+		entry := Entry{Time: record.Time, Kind: record.Kind}
+		pid := 0
+		switch {
+		case record.Anomaly != nil:
+			a := record.Anomaly
+			entry.Summary = fmt.Sprintf("%s %s: %s", a.Severity, a.Type, a.Evidence)
+			pid = a.PID
+		case record.Event != nil:
+			e := record.Event
+			entry.Summary = fmt.Sprintf("%s %s", e.Type, describeEvent(e.Data, e.Path))
+			pid = e.PID
+		default:
+			continue
+		}
+		if record.Anomaly != nil && len(record.Anomaly.Lineage) > 0 {
+			for _, p := range record.Anomaly.Lineage {
+				entry.Lineage = append(entry.Lineage, Process{PID: p.PID, Name: filepath.Base(p.Exe)})
+			}
+		} else {
+			entry.Lineage = lineage(procs, pid)
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	return entries
+}
+
+type procInfo struct {
+	name string
+	ppid int
+}
+
+// processTable indexes process names and parents from recorded events. Later
+// events win, which matches the most recent exec for a reused PID.
+func processTable(records []storage.Record) map[int]procInfo {
+	procs := make(map[int]procInfo)
+	for _, record := range records {
+		e := record.Event
+		if e == nil || e.PID == 0 {
+			continue
+		}
+		info := procs[e.PID]
+		if e.ProcessName != "" {
+			info.name = e.ProcessName
+		}
+		if ppid, ok := e.Data["ppid"].(float64); ok {
+			info.ppid = int(ppid)
+		} else if ppid, ok := e.Data["ppid"].(int); ok {
+			info.ppid = ppid
+		}
+		procs[e.PID] = info
+	}
+	return procs
+}
+
+func lineage(procs map[int]procInfo, pid int) []Process {
+	var chain []Process
+	seen := make(map[int]bool)
+	for pid > 0 && !seen[pid] && len(chain) < maxLineageDepth {
+		seen[pid] = true
+		info, ok := procs[pid]
+		chain = append(chain, Process{PID: pid, Name: info.name})
+		if !ok {
+			break
+		}
+		pid = info.ppid
+	}
+	return chain
+}
+
+func describeEvent(data map[string]interface{}, path string) string {
+	var parts []string
+	for _, key := range []string{"syscall", "action", "topic", "exe", "cmdline", "destination"} {
+		if v, ok := data[key]; ok {
+			parts = append(parts, fmt.Sprintf("%s=%v", key, v))
+		}
+	}
+	if path != "" {
+		parts = append(parts, "path="+path)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Render writes the timeline as text.
+func Render(w io.Writer, entries []Entry) {
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "No anomalies or events in the selected window")
+		return
+	}
+
+	for _, entry := range entries {
+		marker := " "
+		if entry.Kind == "anomaly" {
+			marker = "!"
+		}
+		fmt.Fprintf(w, "%s %s %s\n", entry.Time.Format("2006-01-02 15:04:05"), marker, entry.Summary)
+		if len(entry.Lineage) > 0 {
+			links := make([]string, len(entry.Lineage))
+			for i, p := range entry.Lineage {
+				name := p.Name
+				if name == "" {
+					name = "?"
+				}
+				links[i] = fmt.Sprintf("%s(%d)", name, p.PID)
+			}
+			fmt.Fprintf(w, "                      └─ %s\n", strings.Join(links, " ← "))
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package timeline
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/detect"
+	"github.com/hallucinaut/runtimebase/pkg/storage"
+)
+
+func TestBuild(t *testing.T) {
+	end := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	records := []storage.Record{
+		// The shell and its parent were started before the window, but
+		// still name the processes in it.
+		{Time: end.Add(-2 * time.Hour), Kind: "event", Event: &detect.SystemEvent{Type: "process", PID: 1, ProcessName: "systemd"}},
+		{Time: end.Add(-90 * time.Minute), Kind: "event", Event: &detect.SystemEvent{Type: "process", PID: 40, ProcessName: "sh", Data: map[string]interface{}{"ppid": float64(1)}}},
+		{Time: end.Add(-10 * time.Minute), Kind: "anomaly", Anomaly: &baseline.Anomaly{Severity: "HIGH", Type: "Unseen Behavior", Evidence: "network:203.0.113.7:4444", PID: 41}},
+		{Time: end.Add(-20 * time.Minute), Kind: "event", Event: &detect.SystemEvent{Type: "process", PID: 41, ProcessName: "curl", Path: "/usr/bin/curl", Data: map[string]interface{}{"ppid": 40, "exe": "/usr/bin/curl"}}},
+		{Time: end.Add(-5 * time.Minute), Kind: "anomaly", Anomaly: &baseline.Anomaly{Severity: "CRITICAL", Type: "Binary Hash Changed", Evidence: "/usr/bin/nginx",
+			Lineage: []baseline.Process{{PID: 7, Exe: "/usr/sbin/nginx"}, {PID: 1, Exe: "/sbin/init"}}}},
+		{Time: end.Add(time.Minute), Kind: "event", Event: &detect.SystemEvent{Type: "process", PID: 42}},
+		{Time: end.Add(-time.Minute), Kind: "anomaly"},
+	}
+
+	entries := Build(records, time.Hour, end)
+	var summaries []string
+	for _, e := range entries {
+		summaries = append(summaries, e.Summary)
+	}
+	want := []string{
+		"process exe=/usr/bin/curl path=/usr/bin/curl",
+		"HIGH Unseen Behavior: network:203.0.113.7:4444",
+		"CRITICAL Binary Hash Changed: /usr/bin/nginx",
+	}
+	if !reflect.DeepEqual(summaries, want) {
+		t.Fatalf("summaries %q, want %q", summaries, want)
+	}
+
+	chain := []Process{{PID: 41, Name: "curl"}, {PID: 40, Name: "sh"}, {PID: 1, Name: "systemd"}}
+	if !reflect.DeepEqual(entries[1].Lineage, chain) {
+		t.Errorf("lineage from events %+v, want %+v", entries[1].Lineage, chain)
+	}
+	if want := []Process{{PID: 7, Name: "nginx"}, {PID: 1, Name: "init"}}; !reflect.DeepEqual(entries[2].Lineage, want) {
+		t.Errorf("recorded lineage %+v, want %+v", entries[2].Lineage, want)
+	}
+}
+
+func TestLineageLoop(t *testing.T) {
+	// A reused PID can make a process its own ancestor.
+	records := []storage.Record{
+		{Kind: "event", Event: &detect.SystemEvent{PID: 2, ProcessName: "a", Data: map[string]interface{}{"ppid": 3}}},
+		{Kind: "event", Event: &detect.SystemEvent{PID: 3, ProcessName: "b", Data: map[string]interface{}{"ppid": 2}}},
+	}
+	if got := lineage(processTable(records), 2); len(got) != 2 {
+		t.Errorf("expected the loop to stop after two processes, got %+v", got)
+	}
+	if got := lineage(processTable(records), 9); !reflect.DeepEqual(got, []Process{{PID: 9}}) {
+		t.Errorf("unknown process: %+v", got)
+	}
+}
+
+func TestRender(t *testing.T) {
+	var out bytes.Buffer
+	Render(&out, nil)
+	if !strings.Contains(out.String(), "No anomalies or events") {
+		t.Errorf("empty timeline: %q", out.String())
+	}
+
+	out.Reset()
+	at := time.Date(2026, 3, 1, 11, 50, 0, 0, time.UTC)
+	Render(&out, []Entry{
+		{Time: at, Kind: "event", Summary: "process exe=/usr/bin/curl"},
+		{Time: at, Kind: "anomaly", Summary: "HIGH Unseen Behavior", Lineage: []Process{{PID: 41, Name: "curl"}, {PID: 40}}},
+	})
+	want := "2026-03-01 11:50:00   process exe=/usr/bin/curl\n" +
+		"2026-03-01 11:50:00 ! HIGH Unseen Behavior\n" +
+		"                      └─ curl(41) ← ?(40)\n"
+	if out.String() != want {
+		t.Errorf("rendered\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,206 @@
+// Package weblog baselines web applications from their access logs.
+package weblog
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/parse"
+	"github.com/hallucinaut/runtimebase/pkg/severity"
+)
+
+// Model learns request rate, status code distribution, and the sets of known
+// paths and user agents for one web application.
+type Model struct {
+	Baseline   *baseline.Baseline
+	Paths      map[string]int
+	UserAgents map[string]int
+	Bucket     time.Duration
+}
+
+// NewModel creates a model that records rate statistics into b using
+// one-minute buckets.
+func NewModel(b *baseline.Baseline) *Model {
+	return &Model{
+		Baseline:   b,
+		Paths:      make(map[string]int),
+		UserAgents: make(map[string]int),
+		Bucket:     time.Minute,
+	}
+}
+
+var statusClasses = []string{"2xx", "3xx", "4xx", "5xx"}
+
+// Learn folds entries into the model.
+func (m *Model) Learn(entries []parse.AccessLogEntry) {
+	for _, bucket := range m.buckets(entries) {
+		m.Baseline.RecordObservation("http", "requests", bucket.total)
+		for _, class := range statusClasses {
+			// Ratios are stored as percentages to fit integer observations.
+			m.Baseline.RecordObservation("http", "status_"+class, bucket.percent(class))
+		}
+	}
+
+	for _, entry := range entries {
+		m.Paths[NormalizePath(entry.Path)]++
+		m.UserAgents[entry.UserAgent]++
+	}
+}
+
+// Detect compares entries against the learned model.
+func (m *Model) Detect(entries []parse.AccessLogEntry) []baseline.Anomaly {
+	var anomalies []baseline.Anomaly
+	now := time.Now()
+
+	for _, bucket := range m.buckets(entries) {
+		if a, ok := m.deviation("requests", bucket.total, bucket.start); ok {
+			a.Description = "Request rate deviates from baseline"
+			anomalies = append(anomalies, a)
+		}
+		for _, class := range statusClasses {
+			if a, ok := m.deviation("status_"+class, bucket.percent(class), bucket.start); ok {
+				a.Description = fmt.Sprintf("Share of %s responses deviates from baseline", class)
+				anomalies = append(anomalies, a)
+			}
+		}
+	}
+
+	newPaths := novel(entries, m.Paths, func(e parse.AccessLogEntry) string { return NormalizePath(e.Path) })
+	if len(newPaths) > 0 {
+		anomalies = append(anomalies, baseline.Anomaly{
+			Type:        "Novel HTTP Path",
+			Description: fmt.Sprintf("%d request paths never seen during learning", len(newPaths)),
+			Severity:    noveltySeverity(len(newPaths)),
+			Evidence:    strings.Join(newPaths, ", "),
+			Category:    "http",
+			Confidence:  0.6,
+			Timestamp:   now,
+			RiskLevel:   noveltySeverity(len(newPaths)),
+		})
+	}
+
+	newAgents := novel(entries, m.UserAgents, func(e parse.AccessLogEntry) string { return e.UserAgent })
+	if len(newAgents) > 0 {
+		anomalies = append(anomalies, baseline.Anomaly{
+			Type:        "Novel User Agent",
+			Description: fmt.Sprintf("%d user agents never seen during learning", len(newAgents)),
+			Severity:    noveltySeverity(len(newAgents)),
+			Evidence:    strings.Join(newAgents, ", "),
+			Category:    "http",
+			Confidence:  0.5,
+			Timestamp:   now,
+			RiskLevel:   noveltySeverity(len(newAgents)),
+		})
+	}
+
+	return anomalies
+}
+
+func (m *Model) deviation(pattern string, value int, at time.Time) (baseline.Anomaly, bool) {
+	stat, exists := m.Baseline.Stats["http:"+pattern]
+	if !exists || stat.StdDev == 0 {
+		return baseline.Anomaly{}, false
+	}
+	z := baseline.CalculateZScore(float64(value), stat.Mean, stat.StdDev)
+	key := "http:" + pattern
+	if m.Baseline.DirectionFor(key).IsNormal(float64(value), stat.Mean, stat.StdDev, m.Baseline.ThresholdFor(key)) {
+		return baseline.Anomaly{}, false
+	}
+	level := severity.Label(severity.Medium)
+	if z > 5 || z < -5 {
+		level = severity.Label(severity.High)
+	}
+	return baseline.Anomaly{
+		Type:       "HTTP Behavioral Anomaly",
+		Severity:   level,
+		Evidence:   fmt.Sprintf("http:%s=%d at %s (mean %.1f, z=%.1f)", pattern, value, at.Format(time.RFC3339), stat.Mean, z),
+		Confidence: 1 - 1/(1+z*z/2),
+		Timestamp:  time.Now(),
+		RiskLevel:  level,
+	}, true
+}
+
+type bucket struct {
+	start    time.Time
+	total    int
+	statuses map[string]int
+}
+
+func (b bucket) percent(class string) int {
+	if b.total == 0 {
+		return 0
+	}
+	return b.statuses[class] * 100 / b.total
+}
+
+func (m *Model) buckets(entries []parse.AccessLogEntry) []bucket {
+	byStart := make(map[time.Time]*bucket)
+	for _, entry := range entries {
+		start := entry.Time.Truncate(m.Bucket)
+		b, ok := byStart[start]
+		if !ok {
+			b = &bucket{start: start, statuses: make(map[string]int)}
+			byStart[start] = b
+		}
+		b.total++
+		if entry.Status >= 200 && entry.Status < 600 {
+			b.statuses[fmt.Sprintf("%dxx", entry.Status/100)]++
+		}
+	}
+
+	buckets := make([]bucket, 0, len(byStart))
+	for _, b := range byStart {
+		buckets = append(buckets, *b)
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].start.Before(buckets[j].start) })
+	return buckets
+}
+
+func novel(entries []parse.AccessLogEntry, known map[string]int, key func(parse.AccessLogEntry) string) []string {
+	var values []string
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		k := key(entry)
+		if _, ok := known[k]; ok || seen[k] {
+			continue
+		}
+		seen[k] = true
+		values = append(values, k)
+	}
+	return values
+}
+
+func noveltySeverity(n int) string {
+	if n > 20 {
+		return severity.Label(severity.High)
+	} else if n > 5 {
+		return severity.Label(severity.Medium)
+	}
+	return severity.Label(severity.Low)
+}
+
+var (
+	numericSegment = regexp.MustCompile(`^[0-9]+$`)
+	uuidSegment    = regexp.MustCompile(`^(?i)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	hexSegment     = regexp.MustCompile(`^(?i)[0-9a-f]{16,}$`)
+)
+
+// NormalizePath drops the query string and replaces numeric, UUID and long
+// hex path segments with ":id" so resource identifiers do not count as novel
+// paths.
+func NormalizePath(path string) string {
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if numericSegment.MatchString(segment) || uuidSegment.MatchString(segment) || hexSegment.MatchString(segment) {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,149 @@
+package weblog
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hallucinaut/runtimebase/pkg/baseline"
+	"github.com/hallucinaut/runtimebase/pkg/parse"
+)
+
+func TestLearnLogFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		path   string
+		agent  string
+		status string
+	}{
+		{
+			name:   "combined",
+			line:   `203.0.113.9 - alice [10/Oct/2026:13:55:36 +0000] "GET /api/users/42?x=1 HTTP/1.1" 200 2326 "https://example.com/" "curl/8.4.0"`,
+			path:   "/api/users/:id",
+			agent:  "curl/8.4.0",
+			status: "2xx",
+		},
+		{
+			name:   "common",
+			line:   `198.51.100.4 - - [10/Oct/2026:13:55:37 +0000] "POST /login HTTP/1.0" 302 -`,
+			path:   "/login",
+			status: "3xx",
+		},
+		{
+			name:   "escaped user agent",
+			line:   `198.51.100.4 - - [10/Oct/2026:13:55:38 +0000] "GET /orders/0b5e8c1a-2f4d-4c1b-9a7e-3d2f1e0c9b8a HTTP/2.0" 404 153 "-" "Mozilla/5.0 \"compatible\""`,
+			path:   "/orders/:id",
+			agent:  `Mozilla/5.0 \"compatible\"`,
+			status: "4xx",
+		},
+		{
+			name:   "request line without protocol",
+			line:   `192.0.2.1 - - [10/Oct/2026:13:55:39 +0000] "GET /health" 200 2 "-" "kube-probe/1.29"`,
+			path:   "/health",
+			agent:  "kube-probe/1.29",
+			status: "2xx",
+		},
+		{
+			name:   "empty request line",
+			line:   `192.0.2.1 - - [10/Oct/2026:13:55:40 +0000] "-" 400 0 "-" "-"`,
+			path:   "-",
+			agent:  "-",
+			status: "4xx",
+		},
+		{
+			name:   "scanner garbage",
+			line:   `192.0.2.1 - - [10/Oct/2026:13:55:41 +0000] "\x16\x03\x01\x00" 400 157 "-" "-"`,
+			path:   `\x16\x03\x01\x00`,
+			agent:  "-",
+			status: "4xx",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := parse.ParseAccessLog(tt.line)
+			if err != nil {
+				t.Fatalf("ParseAccessLog: %v", err)
+			}
+			b := baseline.NewLearner().CreateBaseline("web")
+			m := NewModel(b)
+			m.Learn([]parse.AccessLogEntry{entry})
+
+			if m.Paths[tt.path] != 1 || len(m.Paths) != 1 {
+				t.Errorf("paths %v, want %q", m.Paths, tt.path)
+			}
+			if m.UserAgents[tt.agent] != 1 || len(m.UserAgents) != 1 {
+				t.Errorf("user agents %v, want %q", m.UserAgents, tt.agent)
+			}
+			if s := b.Stats["http:requests"]; s.SampleCount != 1 || s.Mean != 1 {
+				t.Errorf("requests %+v, want one minute of one request", s)
+			}
+			if s := b.Stats["http:status_"+tt.status]; s.Mean != 100 {
+				t.Errorf("share of %s %.0f%%, want 100%%", tt.status, s.Mean)
+			}
+		})
+	}
+}
+
+func TestMalformedLines(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"empty", ""},
+		{"not a log line", "not a log line"},
+		{"json", `{"remote_addr":"192.0.2.1","status":200}`},
+		{"missing status", `192.0.2.1 - - [10/Oct/2026:13:55:36 +0000] "GET / HTTP/1.1"`},
+		{"non-numeric status", `192.0.2.1 - - [10/Oct/2026:13:55:36 +0000] "GET / HTTP/1.1" OK 12`},
+		{"invalid timestamp", `192.0.2.1 - - [32/Foo/2026:13:55:36 +0000] "GET / HTTP/1.1" 200 12`},
+		{"unterminated timestamp", `192.0.2.1 - - [10/Oct/2026:13:55:36 +0000 "GET / HTTP/1.1" 200 12`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if entry, err := parse.ParseAccessLog(tt.line); err == nil {
+				t.Errorf("ParseAccessLog(%q) = %+v, want an error", tt.line, entry)
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	start := time.Date(2026, 10, 10, 13, 0, 0, 0, time.UTC)
+	entries := func(minute, n int, path, agent string) []parse.AccessLogEntry {
+		var logged []parse.AccessLogEntry
+		for i := 0; i < n; i++ {
+			line := fmt.Sprintf(`203.0.113.9 - - [%s] "GET %s HTTP/1.1" 200 512 "-" "%s"`,
+				start.Add(time.Duration(minute)*time.Minute+time.Duration(i)*time.Second).Format("02/Jan/2006:15:04:05 -0700"), path, agent)
+			entry, err := parse.ParseAccessLog(line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			logged = append(logged, entry)
+		}
+		return logged
+	}
+
+	m := NewModel(baseline.NewLearner().CreateBaseline("web"))
+	var learned []parse.AccessLogEntry
+	for minute, n := range []int{4, 5, 6, 5, 4, 6, 5, 5} {
+		learned = append(learned, entries(minute, n, fmt.Sprintf("/items/%d", minute), "curl/8.4.0")...)
+	}
+	m.Learn(learned)
+
+	if anomalies := m.Detect(entries(10, 5, "/items/77", "curl/8.4.0")); len(anomalies) != 0 {
+		t.Errorf("usual traffic reported: %+v", anomalies)
+	}
+
+	anomalies := m.Detect(append(entries(20, 50, "/items/1", "curl/8.4.0"), entries(21, 5, "/admin/export", "sqlmap/1.7")...))
+	types := make(map[string]string)
+	for _, a := range anomalies {
+		types[a.Type] = a.Evidence
+	}
+	if !strings.HasPrefix(types["HTTP Behavioral Anomaly"], "http:requests=50") {
+		t.Errorf("expected the burst of 50 requests reported, got %+v", anomalies)
+	}
+	if types["Novel HTTP Path"] != "/admin/export" || types["Novel User Agent"] != "sqlmap/1.7" {
+		t.Errorf("expected the new path and user agent reported, got %+v", anomalies)
+	}
+}